@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// adaptiveLatencySamples bounds the latencies kept per adjustment interval
+const adaptiveLatencySamples = 1024
+
+// AdaptiveRateConfig configures the controller that adjusts the gate's
+// request rate to the observed database latency. Each interval the rate is
+// multiplied by DecreaseFactor if a target is exceeded, else raised by
+// IncreaseStep, so the rate backs off quickly and recovers gradually.
+type AdaptiveRateConfig struct {
+	Interval      time.Duration // time between adjustments (default 1s)
+	TargetLatency time.Duration // average statement latency to stay under (0 ignores it)
+	TargetP95     time.Duration // 95th percentile latency to stay under (0 ignores it)
+	MaxPoolWait   time.Duration // pool wait per interval to stay under (0 ignores it)
+
+	MinRate        float64 // lowest rate in requests per second (default 1)
+	MaxRate        float64 // highest rate (default the configured rate limit)
+	DecreaseFactor float64 // applied to the rate when a target is exceeded (default 0.7)
+	IncreaseStep   float64 // share of MaxRate added when within targets (default 0.05)
+}
+
+// AdaptiveRateStats reports the adaptive rate controller
+type AdaptiveRateStats struct {
+	Rate        float64       `json:"rate"`
+	Decreases   int64         `json:"decreases"`
+	Increases   int64         `json:"increases"`
+	LastAverage time.Duration `json:"last_average_ns"`
+	LastP95     time.Duration `json:"last_p95_ns"`
+	LastWait    time.Duration `json:"last_pool_wait_ns"`
+}
+
+// adaptiveRateController adjusts a rate limiter from statement latencies,
+// collected with a query hook, and connection pool waits
+type adaptiveRateController struct {
+	config  AdaptiveRateConfig
+	limiter *RateLimiter
+	stats   func() sql.DBStats
+	stop    chan struct{}
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	samples   []time.Duration // ring of this interval's latencies
+	next      int
+	lastWait  time.Duration
+	lastStats AdaptiveRateStats
+}
+
+func newAdaptiveRateController(config AdaptiveRateConfig, limiter *RateLimiter, stats func() sql.DBStats) *adaptiveRateController {
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	if config.MaxRate <= 0 {
+		config.MaxRate = limiter.Rate()
+	}
+	if config.MinRate <= 0 {
+		config.MinRate = 1
+	}
+	if config.MinRate > config.MaxRate {
+		config.MinRate = config.MaxRate
+	}
+	if config.DecreaseFactor <= 0 || config.DecreaseFactor >= 1 {
+		config.DecreaseFactor = 0.7
+	}
+	if config.IncreaseStep <= 0 {
+		config.IncreaseStep = 0.05
+	}
+
+	return &adaptiveRateController{
+		config:    config,
+		limiter:   limiter,
+		stats:     stats,
+		lastStats: AdaptiveRateStats{Rate: limiter.Rate()},
+	}
+}
+
+// hooks records statement latencies
+func (c *adaptiveRateController) hooks() QueryHooks {
+	return QueryHooks{
+		After: func(ctx context.Context, query string, duration time.Duration, err error) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if len(c.samples) < adaptiveLatencySamples {
+				c.samples = append(c.samples, duration)
+				return
+			}
+			c.samples[c.next] = duration
+			c.next = (c.next + 1) % adaptiveLatencySamples
+		},
+	}
+}
+
+func (c *adaptiveRateController) start() {
+	c.stop = make(chan struct{})
+	c.lastWait = c.stats().WaitDuration
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.adjust()
+			}
+		}
+	}()
+}
+
+// close stops the controller if it is running
+func (c *adaptiveRateController) close() {
+	if c.stop == nil {
+		return
+	}
+	close(c.stop)
+	c.wg.Wait()
+	c.stop = nil
+}
+
+// adjust sets the rate for the next interval from the one that ended
+func (c *adaptiveRateController) adjust() {
+	wait := c.stats().WaitDuration
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := c.samples
+	c.samples, c.next = nil, 0
+	var average, p95 time.Duration
+	if len(samples) > 0 {
+		var total time.Duration
+		for _, d := range samples {
+			total += d
+		}
+		average = total / time.Duration(len(samples))
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		p95 = percentile(samples, 95)
+	}
+
+	waited := wait - c.lastWait
+	if waited < 0 {
+		// The counter restarted: Reload replaced the pool
+		waited = wait
+	}
+	c.lastWait = wait
+
+	overloaded := (c.config.TargetLatency > 0 && average > c.config.TargetLatency) ||
+		(c.config.TargetP95 > 0 && p95 > c.config.TargetP95) ||
+		(c.config.MaxPoolWait > 0 && waited > c.config.MaxPoolWait)
+
+	rate := c.limiter.Rate()
+	switch {
+	case overloaded:
+		rate = max(rate*c.config.DecreaseFactor, c.config.MinRate)
+		c.lastStats.Decreases++
+	case rate < c.config.MaxRate:
+		rate = min(rate+c.config.MaxRate*c.config.IncreaseStep, c.config.MaxRate)
+		c.lastStats.Increases++
+	}
+	c.limiter.SetRate(rate)
+
+	c.lastStats.Rate = rate
+	c.lastStats.LastAverage, c.lastStats.LastP95, c.lastStats.LastWait = average, p95, waited
+}
+
+// setMaxRate changes the highest rate, for a new configured rate limit
+func (c *adaptiveRateController) setMaxRate(rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.MaxRate = rate
+	c.config.MinRate = min(c.config.MinRate, rate)
+}
+
+func (c *adaptiveRateController) snapshot() AdaptiveRateStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastStats
+}
+
+// AdaptiveRateStats returns the state of the adaptive rate controller. It
+// returns zero stats unless RuntimeConfig.AdaptiveRate is set.
+func (r *DBRuntime) AdaptiveRateStats() AdaptiveRateStats {
+	if r.adaptive == nil {
+		return AdaptiveRateStats{}
+	}
+	return r.adaptive.snapshot()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrAsyncQueueFull is returned by ExecAsync when the queue has no room
+var ErrAsyncQueueFull = errors.New("async exec queue is full")
+
+// AsyncExecConfig configures the worker pool behind ExecAsync
+type AsyncExecConfig struct {
+	Workers   int // statements running at once (default 2)
+	QueueSize int // statements waiting for a worker (default 1000); excess are rejected
+}
+
+// AsyncExecStats reports ExecAsync counters
+type AsyncExecStats struct {
+	Queued    int64 `json:"queued"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+	Rejected  int64 `json:"rejected"` // queue full or runtime disconnecting
+	Pending   int   `json:"pending"`
+}
+
+// AsyncCallback receives the outcome of an ExecAsync statement
+type AsyncCallback func(result sql.Result, err error)
+
+type asyncJob struct {
+	ctx      context.Context
+	query    string
+	args     []interface{}
+	callback AsyncCallback
+}
+
+// asyncExecutor runs ExecAsync statements on a bounded pool of workers
+type asyncExecutor struct {
+	runtime *DBRuntime
+	jobs    chan asyncJob
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	queued    atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	rejected  atomic.Int64
+}
+
+func newAsyncExecutor(runtime *DBRuntime, config AsyncExecConfig) *asyncExecutor {
+	if config.Workers <= 0 {
+		config.Workers = 2
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+
+	e := &asyncExecutor{runtime: runtime, jobs: make(chan asyncJob, config.QueueSize)}
+	for i := 0; i < config.Workers; i++ {
+		e.wg.Add(1)
+		go e.work()
+	}
+	return e
+}
+
+// submit queues a job without blocking
+func (e *asyncExecutor) submit(job asyncJob) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		e.rejected.Add(1)
+		return fmt.Errorf("database not connected")
+	}
+
+	select {
+	case e.jobs <- job:
+		e.queued.Add(1)
+		return nil
+	default:
+		e.rejected.Add(1)
+		return ErrAsyncQueueFull
+	}
+}
+
+// work runs queued jobs until the queue is closed and drained
+func (e *asyncExecutor) work() {
+	defer e.wg.Done()
+	for job := range e.jobs {
+		e.run(job)
+	}
+}
+
+func (e *asyncExecutor) run(job asyncJob) {
+	result, err := e.runtime.Exec(job.ctx, job.query, job.args...)
+	if err != nil {
+		e.failed.Add(1)
+	} else {
+		e.completed.Add(1)
+	}
+	if job.callback == nil {
+		return
+	}
+
+	// A panicking callback must not take the worker down
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("ExecAsync callback panicked: %v", p)
+		}
+	}()
+	job.callback(result, err)
+}
+
+// close stops accepting jobs and waits for the queued ones to finish
+func (e *asyncExecutor) close() {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+	e.closed = true
+	close(e.jobs)
+	e.mu.Unlock()
+	e.wg.Wait()
+}
+
+func (e *asyncExecutor) stats() AsyncExecStats {
+	return AsyncExecStats{
+		Queued:    e.queued.Load(),
+		Completed: e.completed.Load(),
+		Failed:    e.failed.Load(),
+		Rejected:  e.rejected.Load(),
+		Pending:   len(e.jobs),
+	}
+}
+
+// ExecAsync queues a statement for a background worker and returns without
+// waiting for it, for low-priority writes (audit rows, counters) that should
+// not add to request latency. The statement runs like Exec, through the
+// gate and with retries; callback, if not nil, receives its outcome on the
+// worker goroutine.
+//
+// The statement keeps the values of ctx (such as query tags) but not its
+// cancellation, since it usually outlives the request. If the queue is full
+// ErrAsyncQueueFull is returned and the statement is not run. Disconnect
+// waits for queued statements to finish.
+func (r *DBRuntime) ExecAsync(ctx context.Context, callback AsyncCallback, query string, args ...interface{}) error {
+	if !r.IsConnected() {
+		return fmt.Errorf("database not connected")
+	}
+
+	r.asyncMu.Lock()
+	if r.async == nil {
+		r.async = newAsyncExecutor(r, r.config.AsyncExec)
+	}
+	async := r.async
+	r.asyncMu.Unlock()
+
+	return async.submit(asyncJob{
+		ctx:      context.WithoutCancel(ctx),
+		query:    query,
+		args:     args,
+		callback: callback,
+	})
+}
+
+// AsyncStats returns ExecAsync counters
+func (r *DBRuntime) AsyncStats() AsyncExecStats {
+	r.asyncMu.Lock()
+	defer r.asyncMu.Unlock()
+	if r.async == nil {
+		return AsyncExecStats{}
+	}
+	return r.async.stats()
+}
+
+// stopAsync waits for queued ExecAsync statements to finish
+func (r *DBRuntime) stopAsync() {
+	r.asyncMu.Lock()
+	async := r.async
+	r.async = nil
+	r.asyncMu.Unlock()
+	if async != nil {
+		async.close()
+	}
+}
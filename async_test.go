@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExecAsync(t *testing.T) {
+	config := NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:exec_async?mode=memory&cache=shared").
+		Build()
+	config.AsyncExec = AsyncExecConfig{Workers: 1, QueueSize: 100}
+	runtime := NewDBRuntime(config)
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE audit_log (id INTEGER PRIMARY KEY, event TEXT NOT NULL)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	results := make(chan error, 20)
+	callback := func(result sql.Result, err error) { results <- err }
+	for i := 0; i < 10; i++ {
+		if err := runtime.ExecAsync(ctx, callback, "INSERT INTO audit_log (event) VALUES (?)", fmt.Sprintf("event-%d", i)); err != nil {
+			t.Fatalf("ExecAsync failed: %v", err)
+		}
+	}
+	if err := runtime.ExecAsync(ctx, callback, "INSERT INTO audit_log (event) VALUES (NULL)"); err != nil {
+		t.Fatalf("ExecAsync failed: %v", err)
+	}
+
+	// A canceled request context does not stop the queued statement
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := runtime.ExecAsync(canceled, nil, "INSERT INTO audit_log (event) VALUES ('late')"); err != nil {
+		t.Fatalf("ExecAsync failed: %v", err)
+	}
+
+	failed := 0
+	for i := 0; i < 11; i++ {
+		if err := <-results; err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("Expected 1 failed statement, got %d", failed)
+	}
+
+	runtime.stopAsync()
+	var n int
+	if err := runtime.QueryRow(ctx, "SELECT COUNT(*) FROM audit_log").Scan(&n); err != nil || n != 11 {
+		t.Errorf("Expected 11 rows after draining, got %d (%v)", n, err)
+	}
+
+	// The queue rejects statements beyond its size
+	runtime.config.AsyncExec = AsyncExecConfig{Workers: 1, QueueSize: 1}
+	block := make(chan struct{})
+	started := make(chan struct{})
+	if err := runtime.ExecAsync(ctx, func(sql.Result, error) { close(started); <-block }, "SELECT 1"); err != nil {
+		t.Fatalf("ExecAsync failed: %v", err)
+	}
+	<-started
+	if err := runtime.ExecAsync(ctx, nil, "SELECT 1"); err != nil {
+		t.Fatalf("Expected the queue to take one statement, got %v", err)
+	}
+	if err := runtime.ExecAsync(ctx, nil, "SELECT 1"); !errors.Is(err, ErrAsyncQueueFull) {
+		t.Errorf("Expected ErrAsyncQueueFull, got %v", err)
+	}
+	close(block)
+	if stats := runtime.AsyncStats(); stats.Rejected != 1 {
+		t.Errorf("Expected 1 rejected statement, got %+v", stats)
+	}
+}
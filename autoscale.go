@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// ConcurrencyAutoscaleConfig configures the controller that adjusts the
+// gate's concurrency limit to the connection pool. Each interval in which
+// the pool made callers wait, the limit is multiplied by DecreaseFactor;
+// otherwise it is raised by IncreaseStep. The limit so stays just below
+// the concurrency at which the pool starts queueing.
+type ConcurrencyAutoscaleConfig struct {
+	Interval     time.Duration // time between adjustments (default 5s)
+	MaxWaitCount int64         // pool waits per interval tolerated (default 0)
+	MaxWait      time.Duration // pool wait time per interval tolerated (default 0)
+
+	MinLimit       int64   // lowest limit (default 1)
+	MaxLimit       int64   // highest limit (default and at most MaxConcurrentConnections)
+	DecreaseFactor float64 // applied to the limit when the pool waited (default 0.9)
+	IncreaseStep   int64   // slots added when it did not (default 1)
+}
+
+// ConcurrencyAutoscaleStats reports the concurrency autoscaler
+type ConcurrencyAutoscaleStats struct {
+	Limit        int64         `json:"limit"`
+	Decreases    int64         `json:"decreases"`
+	Increases    int64         `json:"increases"`
+	LastWaits    int64         `json:"last_pool_waits"`
+	LastWaitTime time.Duration `json:"last_pool_wait_ns"`
+}
+
+// concurrencyAutoscaler adjusts a connection limiter from the pool's wait
+// counters
+type concurrencyAutoscaler struct {
+	config  ConcurrencyAutoscaleConfig
+	limiter *ConnectionLimiter
+	stats   func() sql.DBStats
+	stop    chan struct{}
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	lastCount int64
+	lastWait  time.Duration
+	lastStats ConcurrencyAutoscaleStats
+}
+
+func newConcurrencyAutoscaler(config ConcurrencyAutoscaleConfig, limiter *ConnectionLimiter, stats func() sql.DBStats) *concurrencyAutoscaler {
+	if config.Interval <= 0 {
+		config.Interval = 5 * time.Second
+	}
+	if config.MaxLimit <= 0 || config.MaxLimit > limiter.MaxConnections() {
+		config.MaxLimit = limiter.MaxConnections()
+	}
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MinLimit > config.MaxLimit {
+		config.MinLimit = config.MaxLimit
+	}
+	if config.DecreaseFactor <= 0 || config.DecreaseFactor >= 1 {
+		config.DecreaseFactor = 0.9
+	}
+	if config.IncreaseStep <= 0 {
+		config.IncreaseStep = 1
+	}
+
+	limiter.SetLimit(config.MaxLimit)
+	return &concurrencyAutoscaler{
+		config:    config,
+		limiter:   limiter,
+		stats:     stats,
+		lastStats: ConcurrencyAutoscaleStats{Limit: config.MaxLimit},
+	}
+}
+
+func (a *concurrencyAutoscaler) start() {
+	stats := a.stats()
+	a.mu.Lock()
+	a.lastCount, a.lastWait = stats.WaitCount, stats.WaitDuration
+	a.mu.Unlock()
+
+	a.stop = make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(a.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+				a.adjust()
+			}
+		}
+	}()
+}
+
+// close stops the autoscaler if it is running
+func (a *concurrencyAutoscaler) close() {
+	if a.stop == nil {
+		return
+	}
+	close(a.stop)
+	a.wg.Wait()
+	a.stop = nil
+}
+
+// adjust sets the limit for the next interval from the pool waits of the
+// one that ended
+func (a *concurrencyAutoscaler) adjust() {
+	stats := a.stats()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	waits, waited := stats.WaitCount-a.lastCount, stats.WaitDuration-a.lastWait
+	if waits < 0 || waited < 0 {
+		// The counters restarted: Reload replaced the pool
+		waits, waited = stats.WaitCount, stats.WaitDuration
+	}
+	a.lastCount, a.lastWait = stats.WaitCount, stats.WaitDuration
+
+	// Beyond the pool size the gate only moves the queue into the pool
+	maxLimit := a.config.MaxLimit
+	if stats.MaxOpenConnections > 0 {
+		maxLimit = max(min(maxLimit, int64(stats.MaxOpenConnections)), a.config.MinLimit)
+	}
+
+	limit := a.limiter.Limit()
+	switch {
+	case waits > a.config.MaxWaitCount || waited > a.config.MaxWait:
+		// Always step down, even when the factor rounds to the same limit
+		limit = max(min(int64(float64(limit)*a.config.DecreaseFactor), limit-1), a.config.MinLimit)
+		a.lastStats.Decreases++
+	case limit < maxLimit:
+		limit = min(limit+a.config.IncreaseStep, maxLimit)
+		a.lastStats.Increases++
+	default:
+		limit = maxLimit
+	}
+	a.limiter.SetLimit(limit)
+
+	a.lastStats.Limit = limit
+	a.lastStats.LastWaits, a.lastStats.LastWaitTime = waits, waited
+}
+
+// setMaxLimit changes the highest limit, for a new configured concurrency
+// cap
+func (a *concurrencyAutoscaler) setMaxLimit(limit int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.config.MaxLimit = limit
+	a.config.MinLimit = min(a.config.MinLimit, limit)
+}
+
+func (a *concurrencyAutoscaler) snapshot() ConcurrencyAutoscaleStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastStats
+}
+
+// ConcurrencyAutoscaleStats returns the state of the concurrency autoscaler.
+// It returns zero stats unless RuntimeConfig.ConcurrencyAutoscale is set.
+func (r *DBRuntime) ConcurrencyAutoscaleStats() ConcurrencyAutoscaleStats {
+	if r.autoscaler == nil {
+		return ConcurrencyAutoscaleStats{}
+	}
+	return r.autoscaler.snapshot()
+}
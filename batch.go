@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// BatchStatement is one statement of an ExecBatch
+type BatchStatement struct {
+	Query string
+	Args  []interface{}
+}
+
+// BatchResult is the outcome of one statement of an ExecBatch. Statements
+// that did not run (after a failure that stopped the batch) have neither a
+// Result nor an Err.
+type BatchResult struct {
+	Result sql.Result
+	Err    error
+}
+
+// BatchOptions configures an ExecBatch
+type BatchOptions struct {
+	// Transaction runs the batch in one transaction: the first failure rolls
+	// back every statement and stops the batch
+	Transaction bool
+	// TxOptions are used to begin the transaction
+	TxOptions *sql.TxOptions
+	// StopOnError stops a batch run without a transaction at the first
+	// failure instead of running the remaining statements
+	StopOnError bool
+}
+
+// ExecBatch executes statements in order on a single connection and returns
+// a result per statement. The batch takes one gate slot and the query
+// timeout applies to the whole batch.
+//
+// The retry policy applies to the batch as a whole, and only while retrying
+// cannot run a statement twice: a transaction is rolled back before being
+// retried, and a batch without a transaction is only retried if none of its
+// statements succeeded. The returned error is the failure that stopped the
+// batch, or the first statement error.
+func (adb *AdvancedDB) ExecBatch(ctx context.Context, statements []BatchStatement, opts *BatchOptions) ([]BatchResult, error) {
+	if len(statements) == 0 {
+		return nil, nil
+	}
+	var options BatchOptions
+	if opts != nil {
+		options = *opts
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, adb.queryTimeout)
+	defer cancel()
+
+	// Results are returned on failure too, so the gate is entered here
+	// instead of through ExecuteWithGate
+	if err := adb.gate.Allow(ctx); err != nil {
+		return nil, err
+	}
+
+	results, err := adb.retryBatch(ctx, statements, options)
+	if err != nil {
+		adb.gate.RecordFailureContext(ctx)
+		return results, err
+	}
+	adb.gate.RecordSuccess()
+	adb.gate.ReleaseContext(ctx)
+	return results, nil
+}
+
+// retryBatch runs the batch with retry logic
+func (adb *AdvancedDB) retryBatch(ctx context.Context, statements []BatchStatement, options BatchOptions) ([]BatchResult, error) {
+	var (
+		results []BatchResult
+		lastErr error
+	)
+	backoff := adb.retryPolicy.InitialBackoff
+
+	for attempt := 0; attempt <= adb.retryPolicy.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return results, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = time.Duration(float64(backoff) * adb.retryPolicy.BackoffMultiplier)
+			if backoff > adb.retryPolicy.MaxBackoff {
+				backoff = adb.retryPolicy.MaxBackoff
+			}
+		}
+
+		var replayable bool
+		results, replayable, lastErr = adb.execBatch(ctx, statements, options)
+		if lastErr == nil {
+			return results, nil
+		}
+		if !replayable || !adb.retryPolicy.ShouldRetry(lastErr) {
+			return results, lastErr
+		}
+	}
+
+	return results, fmt.Errorf("batch failed after %d attempts: %w", adb.retryPolicy.MaxRetries+1, lastErr)
+}
+
+// execBatch runs the batch once. It reports whether the batch left no
+// changes behind, so it can be run again.
+func (adb *AdvancedDB) execBatch(ctx context.Context, statements []BatchStatement, options BatchOptions) (results []BatchResult, replayable bool, err error) {
+	results = make([]BatchResult, len(statements))
+
+	conn, err := adb.db.Load().Conn(ctx)
+	if err != nil {
+		return results, true, fmt.Errorf("failed to get a batch connection: %w", err)
+	}
+	defer conn.Close()
+
+	type execer interface {
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	}
+	var target execer = conn
+	var tx *sql.Tx
+	if options.Transaction {
+		if tx, err = conn.BeginTx(ctx, options.TxOptions); err != nil {
+			return results, true, fmt.Errorf("failed to begin batch transaction: %w", err)
+		}
+		target = tx
+	}
+
+	var firstErr error
+	succeeded := 0
+	for i, stmt := range statements {
+		hookCtx, done, err := runQueryHooks(ctx, adb.queryHooks(), stmt.Query, stmt.Args)
+		if err == nil {
+			start := time.Now()
+			results[i].Result, err = target.ExecContext(hookCtx, adb.taggedQuery(hookCtx, stmt.Query), stmt.Args...)
+			adb.metrics.RecordQuery(time.Since(start), err)
+			done(err)
+		}
+		if err == nil {
+			succeeded++
+			continue
+		}
+
+		results[i].Err = err
+		err = fmt.Errorf("batch statement %d failed: %w", i, err)
+		if tx != nil {
+			_ = tx.Rollback()
+			return results, true, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if options.StopOnError {
+			break
+		}
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return results, false, fmt.Errorf("failed to commit batch: %w", err)
+		}
+	}
+	return results, succeeded == 0, firstErr
+}
+
+// ExecBatch executes statements on a single connection (see
+// AdvancedDB.ExecBatch)
+func (r *DBRuntime) ExecBatch(ctx context.Context, statements []BatchStatement, opts *BatchOptions) ([]BatchResult, error) {
+	if !r.IsConnected() {
+		return nil, fmt.Errorf("database not connected")
+	}
+	defer func() {
+		for _, stmt := range statements {
+			r.invalidateWrites(ctx, stmt.Query, stmt.Args)
+		}
+	}()
+	return r.advancedDB.ExecBatch(ctx, statements, opts)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExecBatch(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:exec_batch?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	count := func() int {
+		var n int
+		if err := runtime.QueryRow(ctx, "SELECT COUNT(*) FROM items").Scan(&n); err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		return n
+	}
+	batch := []BatchStatement{
+		{Query: "INSERT INTO items (id, name) VALUES (?, ?)", Args: []interface{}{1, "a"}},
+		{Query: "INSERT INTO items (id, name) VALUES (?, ?)", Args: []interface{}{2, nil}},
+		{Query: "INSERT INTO items (id, name) VALUES (?, ?)", Args: []interface{}{3, "c"}},
+	}
+
+	// In a transaction the failing statement rolls back the whole batch
+	results, err := runtime.ExecBatch(ctx, batch, &BatchOptions{Transaction: true})
+	if err == nil || len(results) != 3 || results[1].Err == nil {
+		t.Fatalf("Expected statement 1 to fail, got %v (%+v)", err, results)
+	}
+	if results[2].Result != nil || results[2].Err != nil {
+		t.Errorf("Expected statement 2 not to run, got %+v", results[2])
+	}
+	if n := count(); n != 0 {
+		t.Errorf("Expected the transaction to be rolled back, got %d rows", n)
+	}
+
+	// Without a transaction the other statements still apply
+	results, err = runtime.ExecBatch(ctx, batch, nil)
+	if err == nil || results[0].Err != nil || results[1].Err == nil || results[2].Err != nil {
+		t.Fatalf("Expected only statement 1 to fail, got %v (%+v)", err, results)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected 2 rows, got %d", n)
+	}
+
+	batch[1].Args = []interface{}{2, "b"}
+	if _, err := runtime.Exec(ctx, "DELETE FROM items"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	results, err = runtime.ExecBatch(ctx, batch, &BatchOptions{Transaction: true})
+	if err != nil {
+		t.Fatalf("ExecBatch failed: %v", err)
+	}
+	if affected, _ := results[2].Result.RowsAffected(); affected != 1 {
+		t.Errorf("Expected 1 row affected, got %d", affected)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("Expected 3 rows, got %d", n)
+	}
+	if got := runtime.advancedDB.gate.connectionLimiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected the gate slot to be released, got %d", got)
+	}
+}
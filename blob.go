@@ -3,9 +3,14 @@ package main
 import (
 	"context"
 	"crypto/md5"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -14,21 +19,41 @@ import (
 type BlobStorage interface {
 	Store(ctx context.Context, key string, data []byte, metadata BlobMetadata) error
 	Retrieve(ctx context.Context, key string) (*BlobData, error)
+	// StoreStream stores a blob read from r, and RetrieveStream returns a
+	// reader over one, so large blobs need not be held in memory. The
+	// caller closes the returned reader.
+	StoreStream(ctx context.Context, key string, r io.Reader, metadata BlobMetadata) error
+	RetrieveStream(ctx context.Context, key string) (io.ReadCloser, BlobMetadata, error)
 	Delete(ctx context.Context, key string) error
 	Exists(ctx context.Context, key string) (bool, error)
-	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+	// DeletePrefix deletes the blobs whose key starts with prefix, which
+	// must not be empty, and returns how many were deleted
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+	// DeleteBatch deletes keys, returning a result per key in their order
+	DeleteBatch(ctx context.Context, keys []string) ([]BlobDeleteResult, error)
+	// List returns the blobs whose key starts with prefix and that pass
+	// every filter
+	List(ctx context.Context, prefix string, filters ...BlobFilter) ([]BlobInfo, error)
+	// ListPage returns up to limit of the blobs List would, in key order,
+	// starting after the page token was returned with
+	ListPage(ctx context.Context, prefix string, limit int, token string, filters ...BlobFilter) (BlobPage, error)
+	// ListWithOptions returns the blobs under prefix ordered, limited and
+	// projected as opts asks
+	ListWithOptions(ctx context.Context, prefix string, opts ListOptions) ([]BlobInfo, error)
 	Stats(ctx context.Context) (BlobStats, error)
 }
 
 // BlobMetadata contains metadata about stored blobs
 type BlobMetadata struct {
-	ContentType string            `json:"content_type"`
-	Filename    string            `json:"filename,omitempty"`
-	Size        int64             `json:"size"`
-	Checksum    string            `json:"checksum"`
-	Tags        map[string]string `json:"tags,omitempty"`
-	CreatedAt   time.Time         `json:"created_at"`
-	UpdatedAt   time.Time         `json:"updated_at"`
+	ContentType    string            `json:"content_type"`
+	Filename       string            `json:"filename,omitempty"`
+	Size           int64             `json:"size"`
+	Checksum       string            `json:"checksum"`
+	Compression    string            `json:"compression,omitempty"`     // set by storage: compressor of the stored bytes
+	CompressedSize int64             `json:"compressed_size,omitempty"` // set by storage: size of the stored bytes when compressed
+	Tags           map[string]string `json:"tags,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
 }
 
 // BlobData represents retrieved blob with metadata
@@ -46,43 +71,72 @@ type BlobInfo struct {
 
 // BlobStats contains storage statistics
 type BlobStats struct {
-	TotalBlobs int64 `json:"total_blobs"`
-	TotalSize  int64 `json:"total_size"`
-	UsedSpace  int64 `json:"used_space"`
+	TotalBlobs int64            `json:"total_blobs"`
+	TotalSize  int64            `json:"total_size"` // size of the blobs as stored by callers
+	UsedSpace  int64            `json:"used_space"` // size of the stored bytes, after compression
+	Quotas     []BlobQuotaUsage `json:"quotas,omitempty"`
 }
 
 // BlobStorageConfig configures blob storage backend
 type BlobStorageConfig struct {
-	Backend     string // "database", "filesystem", "memory"
-	RootPath    string // For filesystem backend
-	TableName   string // For database backend
-	MaxSize     int64  // Maximum blob size
-	Compression bool   // Enable compression
+	Backend              string      // "database", "filesystem", "memory"
+	RootPath             string      // For filesystem backend
+	TableName            string      // For database backend; letters, digits and underscores
+	MaxSize              int64       // Maximum blob size
+	ChunkSize            int64       // For database backend: blobs stored larger are split into chunk rows (default 1MB)
+	Compression          bool        // Enable compression
+	HashedLayout         bool        // For filesystem backend: spread blobs over directories named after the key hash
+	CompressionAlgorithm string      // "gzip" (default) or a name registered with RegisterBlobCompressor
+	Quotas               []BlobQuota // Enforced on Store and StoreStream, and reported by Stats
 }
 
 // DatabaseBlobStorage stores blobs in database BLOB fields
 type DatabaseBlobStorage struct {
-	runtime   *DBRuntime
-	tableName string
-	maxSize   int64
+	runtime    *DBRuntime
+	tableName  string
+	maxSize    int64
+	chunkSize  int64
+	compressor BlobCompressor // nil unless compression is enabled
+	quotas     []BlobQuota
 }
 
-// NewDatabaseBlobStorage creates database-backed blob storage
+// blobTableNamePattern restricts blob table names, which are interpolated
+// into SQL, so the derived chunk table and index names stay valid too
+var blobTableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewDatabaseBlobStorage creates database-backed blob storage. The table
+// name is quoted in SQL, so on PostgreSQL and Oracle it is case-sensitive.
 func NewDatabaseBlobStorage(runtime *DBRuntime, config *BlobStorageConfig) (*DatabaseBlobStorage, error) {
 	tableName := "blobs"
 	if config.TableName != "" {
 		tableName = config.TableName
 	}
+	if !blobTableNamePattern.MatchString(tableName) {
+		return nil, fmt.Errorf("invalid blob table name: %q", tableName)
+	}
 
 	maxSize := int64(100 * 1024 * 1024) // 100MB default
 	if config.MaxSize > 0 {
 		maxSize = config.MaxSize
 	}
 
+	chunkSize := int64(1024 * 1024) // 1MB default
+	if config.ChunkSize > 0 {
+		chunkSize = config.ChunkSize
+	}
+
+	compressor, err := newBlobCompressor(config)
+	if err != nil {
+		return nil, err
+	}
+
 	storage := &DatabaseBlobStorage{
-		runtime:   runtime,
-		tableName: tableName,
-		maxSize:   maxSize,
+		runtime:    runtime,
+		tableName:  tableName,
+		maxSize:    maxSize,
+		chunkSize:  chunkSize,
+		compressor: compressor,
+		quotas:     config.Quotas,
 	}
 
 	// Create table if not exists
@@ -93,58 +147,133 @@ func NewDatabaseBlobStorage(runtime *DBRuntime, config *BlobStorageConfig) (*Dat
 	return storage, nil
 }
 
+// table returns the quoted name of the blob table
+func (dbs *DatabaseBlobStorage) table() string {
+	return dbs.runtime.Dialect().QuoteIdentifier(dbs.tableName)
+}
+
+// keyColumn returns the quoted name of the key column, a reserved word on
+// MySQL
+func (dbs *DatabaseBlobStorage) keyColumn() string {
+	return dbs.runtime.Dialect().QuoteIdentifier("key")
+}
+
+// keyOrder returns the key column compared byte by byte, whatever its
+// collation, so blobs are listed in the same order on every backend
+func (dbs *DatabaseBlobStorage) keyOrder() string {
+	switch dbs.runtime.Dialect().Type() {
+	case DatabaseTypePostgreSQL:
+		return dbs.keyColumn() + ` COLLATE "C"`
+	case DatabaseTypeMySQL:
+		return "CAST(" + dbs.keyColumn() + " AS BINARY)"
+	}
+	return dbs.keyColumn()
+}
+
+// blobColumns holds the metadata columns of a blob row as scanned. Rows
+// written by earlier releases may hold NULL in the nullable ones.
+type blobColumns struct {
+	contentType, checksum, compression string
+	filename, tags                     sql.NullString
+	size, storedSize                   int64
+	createdAt, updatedAt               sql.NullTime
+}
+
+// metadata returns the metadata of the row
+func (c blobColumns) metadata() BlobMetadata {
+	metadata := BlobMetadata{
+		ContentType: c.contentType,
+		Filename:    c.filename.String,
+		Size:        c.size,
+		Checksum:    c.checksum,
+		Compression: c.compression,
+		Tags:        unmarshalTags(c.tags.String),
+		CreatedAt:   c.createdAt.Time,
+		UpdatedAt:   c.updatedAt.Time,
+	}
+	if c.compression != "" {
+		metadata.CompressedSize = c.storedSize
+	}
+	return metadata
+}
+
 // createTable creates the blob storage table
 func (dbs *DatabaseBlobStorage) createTable() error {
 	ctx := context.Background()
 
-	// Create table based on database type
-	var createSQL string
-	switch dbs.runtime.config.DatabaseType {
-	case DatabaseTypeSQLite:
-		createSQL = fmt.Sprintf(`
-			CREATE TABLE IF NOT EXISTS %s (
-				key TEXT PRIMARY KEY,
-				data BLOB NOT NULL,
-				content_type TEXT NOT NULL,
-				filename TEXT,
-				size INTEGER NOT NULL,
-				checksum TEXT NOT NULL,
-				tags TEXT, -- JSON
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-			)`, dbs.tableName)
-	case DatabaseTypePostgreSQL:
-		createSQL = fmt.Sprintf(`
-			CREATE TABLE IF NOT EXISTS %s (
-				key TEXT PRIMARY KEY,
-				data BYTEA NOT NULL,
-				content_type TEXT NOT NULL,
-				filename TEXT,
-				size BIGINT NOT NULL,
-				checksum TEXT NOT NULL,
-				tags JSONB,
-				created_at TIMESTAMP DEFAULT NOW(),
-				updated_at TIMESTAMP DEFAULT NOW()
-			)`, dbs.tableName)
-	case DatabaseTypeMySQL:
-		createSQL = fmt.Sprintf(`
-			CREATE TABLE IF NOT EXISTS %s (
-				` + "`key`" + ` VARCHAR(255) PRIMARY KEY,
-				data LONGBLOB NOT NULL,
-				content_type VARCHAR(255) NOT NULL,
-				filename VARCHAR(255),
-				size BIGINT NOT NULL,
-				checksum VARCHAR(64) NOT NULL,
-				tags JSON,
-				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-			)`, dbs.tableName)
-	default:
-		return fmt.Errorf("unsupported database type for blob storage: %s", dbs.runtime.config.DatabaseType)
+	// Oracle has no CREATE TABLE IF NOT EXISTS
+	d := dbs.runtime.Dialect()
+	if d.Type() == DatabaseTypeOracle {
+		return fmt.Errorf("unsupported database type for blob storage: %s", d.Type())
 	}
 
-	_, err := dbs.runtime.Exec(ctx, createSQL)
-	return err
+	// Keys are listed in byte order, which the primary key index serves
+	// on PostgreSQL only with the C collation
+	keyType := d.ColumnType(ColumnKey)
+	if d.Type() == DatabaseTypePostgreSQL {
+		keyType += ` COLLATE "C"`
+	}
+
+	createSQL := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			%s %s PRIMARY KEY,
+			data %s NOT NULL,
+			content_type %s NOT NULL,
+			filename %s,
+			size %s NOT NULL,
+			checksum %s NOT NULL,
+			tags %s,
+			created_at %s DEFAULT CURRENT_TIMESTAMP,
+			updated_at %s DEFAULT CURRENT_TIMESTAMP,
+			compression %s,
+			stored_size %s,
+			chunks %s,
+			generation %s
+		)`, dbs.table(),
+		dbs.keyColumn(), keyType,
+		d.ColumnType(ColumnBinary),
+		d.ColumnType(ColumnKey),
+		d.ColumnType(ColumnKey),
+		d.ColumnType(ColumnInt64),
+		d.ColumnType(ColumnKey),
+		d.ColumnType(ColumnJSON),
+		d.ColumnType(ColumnTimestamp),
+		d.ColumnType(ColumnTimestamp),
+		d.ColumnType(ColumnKey),
+		d.ColumnType(ColumnInt64),
+		d.ColumnType(ColumnInt64),
+		d.ColumnType(ColumnInt64))
+
+	if _, err := dbs.runtime.Exec(ctx, createSQL); err != nil {
+		return err
+	}
+
+	// Tables created before compression and chunking lack their columns
+	if err := dbs.addMissingColumns(ctx, dbs.table(), map[string]ColumnKind{
+		"compression": ColumnKey, "stored_size": ColumnInt64, "chunks": ColumnInt64, "generation": ColumnInt64,
+	}); err != nil {
+		return err
+	}
+	if err := dbs.createTagIndex(ctx); err != nil {
+		return err
+	}
+	return dbs.createChunkTable(ctx)
+}
+
+// addMissingColumns adds the columns a table created by an older version
+// lacks
+func (dbs *DatabaseBlobStorage) addMissingColumns(ctx context.Context, table string, columns map[string]ColumnKind) error {
+	d := dbs.runtime.Dialect()
+	for column, kind := range columns {
+		if rows, err := dbs.runtime.Query(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", column, table)); err == nil {
+			rows.Close()
+			continue
+		}
+		if _, err := dbs.runtime.Exec(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, d.ColumnType(kind))); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Store stores a blob in the database
@@ -160,123 +289,191 @@ func (dbs *DatabaseBlobStorage) Store(ctx context.Context, key string, data []by
 	}
 	metadata.Checksum = checksum
 	metadata.Size = int64(len(data))
-	metadata.UpdatedAt = time.Now()
-	if metadata.CreatedAt.IsZero() {
-		metadata.CreatedAt = metadata.UpdatedAt
-	}
 
-	// Serialize tags if present
-	var tagsJSON string
-	if len(metadata.Tags) > 0 {
-		// Simple JSON serialization for tags
-		parts := make([]string, 0, len(metadata.Tags))
-		for k, v := range metadata.Tags {
-			parts = append(parts, fmt.Sprintf(`"%s":"%s"`, k, v))
-		}
-		tagsJSON = "{" + strings.Join(parts, ",") + "}"
+	stored, compression, err := compressBlob(dbs.compressor, data)
+	if err != nil {
+		return err
 	}
+	metadata.Compression = compression
 
-	// Insert or update
-	if dbs.runtime.config.DatabaseType == DatabaseTypeMySQL {
-		_, err := dbs.runtime.Exec(ctx, fmt.Sprintf(`
-			REPLACE INTO %s (` + "`key`" + `, data, content_type, filename, size, checksum, tags, created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, dbs.tableName),
-			key, data, metadata.ContentType, metadata.Filename, metadata.Size,
-			metadata.Checksum, tagsJSON, metadata.CreatedAt, metadata.UpdatedAt)
-		return err
-	} else {
-		_, err := dbs.runtime.Exec(ctx, fmt.Sprintf(`
-			INSERT OR REPLACE INTO %s (key, data, content_type, filename, size, checksum, tags, created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, dbs.tableName),
-			key, data, metadata.ContentType, metadata.Filename, metadata.Size,
-			metadata.Checksum, tagsJSON, metadata.CreatedAt, metadata.UpdatedAt)
+	return dbs.write(ctx, key, func(w io.Writer) (BlobMetadata, error) {
+		_, err := w.Write(stored)
+		return metadata, err
+	})
+}
+
+// upsert inserts or replaces the row of a blob, whose stored bytes are data
+// or, with chunks set, in that many chunk rows tagged with generation
+func (dbs *DatabaseBlobStorage) upsert(ctx context.Context, tx *AdvancedTx, key string, data []byte, metadata BlobMetadata, chunks, storedSize, generation int64) error {
+	tagsJSON, err := marshalTags(metadata.Tags)
+	if err != nil {
 		return err
 	}
-}
 
-// Retrieve retrieves a blob from the database
-func (dbs *DatabaseBlobStorage) Retrieve(ctx context.Context, key string) (*BlobData, error) {
-	row := dbs.runtime.QueryRow(ctx, fmt.Sprintf(`
-		SELECT data, content_type, filename, size, checksum, tags, created_at, updated_at
-		FROM %s WHERE key = ?
-	`, dbs.tableName), key)
+	// Insert or update
+	d := dbs.runtime.Dialect()
+	columns := "data, content_type, filename, size, checksum, tags, created_at, updated_at, compression, stored_size, chunks, generation"
+	var query string
+	switch d.Type() {
+	case DatabaseTypePostgreSQL:
+		query = fmt.Sprintf(`
+			INSERT INTO %[1]s (%[2]s, %[3]s) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (%[2]s) DO UPDATE SET (%[3]s) = (
+				EXCLUDED.data, EXCLUDED.content_type, EXCLUDED.filename, EXCLUDED.size, EXCLUDED.checksum, EXCLUDED.tags,
+				EXCLUDED.created_at, EXCLUDED.updated_at, EXCLUDED.compression, EXCLUDED.stored_size, EXCLUDED.chunks, EXCLUDED.generation)
+		`, dbs.table(), dbs.keyColumn(), columns)
+	case DatabaseTypeMySQL:
+		query = fmt.Sprintf("REPLACE INTO %s (%s, %s) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", dbs.table(), dbs.keyColumn(), columns)
+	default:
+		query = fmt.Sprintf("INSERT OR REPLACE INTO %s (%s, %s) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", dbs.table(), dbs.keyColumn(), columns)
+	}
 
-	var data []byte
-	var contentType, filename, checksum, tagsJSON string
-	var size int64
-	var createdAt, updatedAt time.Time
+	_, err = tx.Exec(ctx, Rebind(d, query),
+		key, data, metadata.ContentType, metadata.Filename, metadata.Size,
+		metadata.Checksum, tagsJSON, metadata.CreatedAt, metadata.UpdatedAt,
+		metadata.Compression, storedSize, chunks, generation)
+	return err
+}
 
-	err := row.Scan(&data, &contentType, &filename, &size, &checksum, &tagsJSON, &createdAt, &updatedAt)
+// marshalTags encodes tags for the tags column, as NULL when there are
+// none since JSON columns reject an empty string
+func marshalTags(tags map[string]string) (interface{}, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(tags)
 	if err != nil {
-		return nil, fmt.Errorf("blob not found: %w", err)
+		return nil, fmt.Errorf("failed to encode blob tags: %w", err)
 	}
+	return string(data), nil
+}
 
-	// Parse tags
+// unmarshalTags decodes the tags column. Rows written before tags were
+// encoded as JSON hold unescaped "k":"v" pairs, which are split as they
+// were written.
+func unmarshalTags(tagsJSON string) map[string]string {
 	tags := make(map[string]string)
-	if tagsJSON != "" {
-		// Simple JSON parsing for tags (basic implementation)
-		tagsJSON = strings.Trim(tagsJSON, "{}")
-		if tagsJSON != "" {
-			pairs := strings.Split(tagsJSON, ",")
-			for _, pair := range pairs {
-				parts := strings.SplitN(pair, ":", 2)
-				if len(parts) == 2 {
-					key := strings.Trim(parts[0], `"`)
-					value := strings.Trim(parts[1], `"`)
-					tags[key] = value
-				}
-			}
+	if tagsJSON == "" || json.Unmarshal([]byte(tagsJSON), &tags) == nil {
+		return tags
+	}
+	tags = make(map[string]string)
+	for _, pair := range strings.Split(strings.Trim(tagsJSON, "{}"), ",") {
+		if k, v, ok := strings.Cut(pair, ":"); ok {
+			tags[strings.Trim(k, `"`)] = strings.Trim(v, `"`)
 		}
 	}
+	return tags
+}
 
+// Retrieve retrieves a blob from the database
+func (dbs *DatabaseBlobStorage) Retrieve(ctx context.Context, key string) (*BlobData, error) {
+	row, err := dbs.retrieveRow(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	stored, metadata := row.data, row.metadata
+	if row.chunks > 0 {
+		if stored, err = io.ReadAll(dbs.newChunkReader(ctx, key, row)); err != nil {
+			return nil, err
+		}
+		if int64(len(stored)) != row.storedSize {
+			return nil, fmt.Errorf("blob %s: %w", key, errBlobChanged)
+		}
+	}
+
+	data, err := decompressBlob(metadata.Compression, stored)
+	if err != nil {
+		return nil, err
+	}
 	return &BlobData{
-		Key:  key,
-		Data: data,
-		Metadata: BlobMetadata{
-			ContentType: contentType,
-			Filename:    filename,
-			Size:        size,
-			Checksum:    checksum,
-			Tags:        tags,
-			CreatedAt:   createdAt,
-			UpdatedAt:   updatedAt,
-		},
+		Key:      key,
+		Data:     data,
+		Metadata: metadata,
 	}, nil
 }
 
+// blobRow is the row of a blob as read by retrieveRow
+type blobRow struct {
+	data       []byte // stored bytes, unless in chunk rows
+	chunks     int64
+	storedSize int64
+	generation int64 // of the chunk rows, 0 for rows written before it was kept
+	metadata   BlobMetadata
+}
+
+// retrieveRow reads the row of a blob: its metadata, and its stored bytes
+// unless they are in chunk rows
+func (dbs *DatabaseBlobStorage) retrieveRow(ctx context.Context, key string) (blobRow, error) {
+	row := dbs.runtime.QueryRow(ctx, Rebind(dbs.runtime.Dialect(), fmt.Sprintf(`
+		SELECT data, content_type, filename, size, checksum, tags, created_at, updated_at,
+			COALESCE(compression, ''), COALESCE(stored_size, size), COALESCE(chunks, 0), COALESCE(generation, 0)
+		FROM %s WHERE %s = ?
+	`, dbs.table(), dbs.keyColumn())), key)
+
+	var r blobRow
+	var c blobColumns
+	err := row.Scan(&r.data, &c.contentType, &c.filename, &c.size, &c.checksum, &c.tags, &c.createdAt, &c.updatedAt, &c.compression, &c.storedSize, &r.chunks, &r.generation)
+	if err != nil {
+		return blobRow{}, fmt.Errorf("blob not found: %w", err)
+	}
+	r.storedSize, r.metadata = c.storedSize, c.metadata()
+	return r, nil
+}
+
 // Delete removes a blob from storage
 func (dbs *DatabaseBlobStorage) Delete(ctx context.Context, key string) error {
-	_, err := dbs.runtime.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE key = ?", dbs.tableName), key)
+	d := dbs.runtime.Dialect()
+	if _, err := dbs.runtime.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s = %s", dbs.table(), dbs.keyColumn(), d.Placeholder(1)), key); err != nil {
+		return err
+	}
+	_, err := dbs.runtime.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE blob_key = %s", dbs.chunkTable(), d.Placeholder(1)), key)
 	return err
 }
 
 // Exists checks if a blob exists
 func (dbs *DatabaseBlobStorage) Exists(ctx context.Context, key string) (bool, error) {
-	row := dbs.runtime.QueryRow(ctx, fmt.Sprintf("SELECT 1 FROM %s WHERE key = ?", dbs.tableName), key)
+	row := dbs.runtime.QueryRow(ctx, fmt.Sprintf("SELECT 1 FROM %s WHERE %s = %s", dbs.table(), dbs.keyColumn(), dbs.runtime.Dialect().Placeholder(1)), key)
 	var exists int
 	err := row.Scan(&exists)
-	if err != nil {
-		return false, nil // Not found
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
 	}
-	return true, nil
+	return err == nil, err
 }
 
-// List lists blobs with optional prefix filter
-func (dbs *DatabaseBlobStorage) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
-	var query string
+// List lists blobs with optional prefix and metadata filters
+func (dbs *DatabaseBlobStorage) List(ctx context.Context, prefix string, filters ...BlobFilter) ([]BlobInfo, error) {
+	return dbs.list(ctx, prefix, "", ListOptions{Filters: filters})
+}
+
+// list returns the blobs under prefix whose key sorts after after, as opts
+// asks
+func (dbs *DatabaseBlobStorage) list(ctx context.Context, prefix, after string, opts ListOptions) ([]BlobInfo, error) {
+	d := dbs.runtime.Dialect()
+	keyColumn := dbs.keyColumn()
+	columns := keyColumn + ", content_type, filename, size, checksum, tags, created_at, updated_at, COALESCE(compression, ''), COALESCE(stored_size, size)"
+	if opts.Projection == BlobProjectionSizes {
+		columns = keyColumn + ", size, updated_at"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", columns, dbs.table())
+	var conditions []string
 	var args []interface{}
 
 	if prefix != "" {
-		if dbs.runtime.config.DatabaseType == DatabaseTypePostgreSQL {
-			query = fmt.Sprintf("SELECT key, content_type, filename, size, checksum, tags, created_at, updated_at FROM %s WHERE key LIKE $1", dbs.tableName)
-		} else {
-			query = fmt.Sprintf("SELECT key, content_type, filename, size, checksum, tags, created_at, updated_at FROM %s WHERE key LIKE ?", dbs.tableName)
-		}
-		args = []interface{}{prefix + "%"}
-	} else {
-		query = fmt.Sprintf("SELECT key, content_type, filename, size, checksum, tags, created_at, updated_at FROM %s", dbs.tableName)
+		args = append(args, likePrefix(prefix))
+		conditions = append(conditions, keyColumn+" LIKE "+d.Placeholder(len(args))+" ESCAPE '!'")
+	}
+	if after != "" {
+		args = append(args, after)
+		conditions = append(conditions, dbs.keyOrder()+" > "+d.Placeholder(len(args)))
+	}
+	conditions, args = dbs.filterConditions(opts.Filters, conditions, args)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY " + opts.orderBy(dbs.keyOrder())
+	if opts.Limit > 0 {
+		query += " " + d.LimitOffset(int64(opts.Limit), 0)
 	}
 
 	rows, err := dbs.runtime.Query(ctx, query, args...)
@@ -285,57 +482,36 @@ func (dbs *DatabaseBlobStorage) List(ctx context.Context, prefix string) ([]Blob
 	}
 	defer rows.Close()
 
+	// A row failing to scan fails the listing rather than being left out,
+	// as callers such as Reconcile act on what is not listed
 	var infos []BlobInfo
 	for rows.Next() {
-		var key, contentType, filename, checksum, tagsJSON string
-		var size int64
-		var createdAt, updatedAt time.Time
-
-		err := rows.Scan(&key, &contentType, &filename, &size, &checksum, &tagsJSON, &createdAt, &updatedAt)
-		if err != nil {
-			continue
+		var key string
+		var c blobColumns
+		if opts.Projection == BlobProjectionSizes {
+			err = rows.Scan(&key, &c.size, &c.updatedAt)
+		} else {
+			err = rows.Scan(&key, &c.contentType, &c.filename, &c.size, &c.checksum, &c.tags, &c.createdAt, &c.updatedAt, &c.compression, &c.storedSize)
 		}
-
-		// Parse tags
-		tags := make(map[string]string)
-		if tagsJSON != "" {
-			tagsJSON = strings.Trim(tagsJSON, "{}")
-			if tagsJSON != "" {
-				pairs := strings.Split(tagsJSON, ",")
-				for _, pair := range pairs {
-					parts := strings.SplitN(pair, ":", 2)
-					if len(parts) == 2 {
-						tagKey := strings.Trim(parts[0], `"`)
-						tagValue := strings.Trim(parts[1], `"`)
-						tags[tagKey] = tagValue
-					}
-				}
-			}
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan blob row: %w", err)
 		}
-
-		infos = append(infos, BlobInfo{
-			Key: key,
-			Metadata: BlobMetadata{
-				ContentType: contentType,
-				Filename:    filename,
-				Size:        size,
-				Checksum:    checksum,
-				Tags:        tags,
-				CreatedAt:   createdAt,
-				UpdatedAt:   updatedAt,
-			},
-		})
+		infos = append(infos, BlobInfo{Key: key, Metadata: c.metadata()})
 	}
-
-	return infos, nil
+	return infos, rows.Err()
 }
 
 // Stats returns storage statistics
 func (dbs *DatabaseBlobStorage) Stats(ctx context.Context) (BlobStats, error) {
-	row := dbs.runtime.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(size), 0) FROM %s", dbs.tableName))
+	row := dbs.runtime.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(COALESCE(stored_size, size)), 0) FROM %s", dbs.table()))
+
+	var totalBlobs, totalSize, usedSpace int64
+	err := row.Scan(&totalBlobs, &totalSize, &usedSpace)
+	if err != nil {
+		return BlobStats{}, err
+	}
 
-	var totalBlobs, totalSize int64
-	err := row.Scan(&totalBlobs, &totalSize)
+	quotas, err := quotaUsages(dbs.quotas, dbs.prefixUsage(ctx, dbs.runtime.Query))
 	if err != nil {
 		return BlobStats{}, err
 	}
@@ -343,14 +519,18 @@ func (dbs *DatabaseBlobStorage) Stats(ctx context.Context) (BlobStats, error) {
 	return BlobStats{
 		TotalBlobs: totalBlobs,
 		TotalSize:  totalSize,
-		UsedSpace:  totalSize, // Same as total size for database storage
+		UsedSpace:  usedSpace,
+		Quotas:     quotas,
 	}, nil
 }
 
 // FilesystemBlobStorage stores blobs on filesystem
 type FilesystemBlobStorage struct {
-	rootPath string
-	maxSize  int64
+	rootPath     string
+	maxSize      int64
+	compressor   BlobCompressor // nil unless compression is enabled
+	hashedLayout bool
+	quotas       []BlobQuota
 }
 
 // NewFilesystemBlobStorage creates filesystem-backed blob storage
@@ -369,9 +549,17 @@ func NewFilesystemBlobStorage(config *BlobStorageConfig) (*FilesystemBlobStorage
 		maxSize = config.MaxSize
 	}
 
+	compressor, err := newBlobCompressor(config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &FilesystemBlobStorage{
-		rootPath: config.RootPath,
-		maxSize:  maxSize,
+		rootPath:     config.RootPath,
+		maxSize:      maxSize,
+		compressor:   compressor,
+		hashedLayout: config.HashedLayout,
+		quotas:       config.Quotas,
 	}, nil
 }
 
@@ -382,67 +570,115 @@ func (fbs *FilesystemBlobStorage) Store(ctx context.Context, key string, data []
 	}
 
 	// Create subdirectories based on key
-	filePath := filepath.Join(fbs.rootPath, key)
+	filePath, err := fbs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := checkQuotas(fbs.quotas, key, int64(len(data)), fbs.prefixUsage(ctx)); err != nil {
+		return err
+	}
 	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	stored, compression, err := compressBlob(fbs.compressor, data)
+	if err != nil {
+		return err
+	}
+	metadata.Compression, metadata.CompressedSize = compression, 0
+	if compression != "" {
+		metadata.CompressedSize = int64(len(stored))
+	}
+
 	// Write blob data
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := writeFileAtomic(filePath, stored); err != nil {
 		return fmt.Errorf("failed to write blob: %w", err)
 	}
 
-	// Write metadata
-	metadataPath := filePath + ".meta"
-	metadataJSON := fmt.Sprintf(`{
-		"content_type": "%s",
-		"filename": "%s",
-		"size": %d,
-		"checksum": "%x",
-		"created_at": "%s",
-		"updated_at": "%s"
-	}`,
-		metadata.ContentType,
-		metadata.Filename,
-		len(data),
-		md5.Sum(data),
-		time.Now().Format(time.RFC3339),
-		time.Now().Format(time.RFC3339))
+	return fbs.writeMetadata(filePath, metadata, int64(len(data)), fmt.Sprintf("%x", md5.Sum(data)))
+}
+
+// writeMetadata writes the .meta file of the blob at filePath. It is
+// renamed into place after the blob, so a crash in between leaves the old
+// metadata, which readMetadata tells apart by its size and by being older
+// than the blob.
+func (fbs *FilesystemBlobStorage) writeMetadata(filePath string, metadata BlobMetadata, size int64, checksum string) error {
+	metadata.Size = size
+	metadata.Checksum = checksum
+	metadata.UpdatedAt = time.Now()
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = metadata.UpdatedAt
+	}
+	return writeMetadataFile(filePath, metadata)
+}
+
+// blobTempPrefix starts the names of the temporary files blobs and their
+// metadata are written to before being renamed into place
+const blobTempPrefix = ".upload-"
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so path holds either its old or its new content
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), blobTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ignoreRemoved drops the error of a file or directory removed while the
+// tree was walked; other errors, such as an unreadable directory, are kept
+// so a walk does not silently leave out the blobs under it
+func ignoreRemoved(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
 
-	return os.WriteFile(metadataPath, []byte(metadataJSON), 0644)
+// isBlobFile reports whether a file under the root is a blob, rather than
+// metadata or a write in progress
+func isBlobFile(path string, info os.FileInfo) bool {
+	return !info.IsDir() && !strings.HasSuffix(path, ".meta") && !strings.HasPrefix(info.Name(), blobTempPrefix)
 }
 
 // Retrieve retrieves a blob from filesystem
 func (fbs *FilesystemBlobStorage) Retrieve(ctx context.Context, key string) (*BlobData, error) {
-	filePath := filepath.Join(fbs.rootPath, key)
+	filePath, err := fbs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("blob not found: %w", err)
+	}
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("blob not found: %w", err)
 	}
 
-	// Read metadata if exists
-	metadata := BlobMetadata{
-		Size:      int64(len(data)),
-		Checksum:  fmt.Sprintf("%x", md5.Sum(data)),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	// Try to read metadata file
-	metadataPath := filePath + ".meta"
-	if metaData, err := os.ReadFile(metadataPath); err == nil {
-		// Parse basic metadata (simplified)
-		metaStr := string(metaData)
-		if strings.Contains(metaStr, "image/") {
-			metadata.ContentType = "image/jpeg" // Default
-			if strings.Contains(metaStr, "png") {
-				metadata.ContentType = "image/png"
-			} else if strings.Contains(metaStr, "gif") {
-				metadata.ContentType = "image/gif"
-			}
-		}
+	metadata := fbs.readMetadata(filePath, info)
+	if data, err = decompressBlob(metadata.Compression, data); err != nil {
+		return nil, err
 	}
+	metadata.Size = int64(len(data))
+	metadata.Checksum = fmt.Sprintf("%x", md5.Sum(data))
 
 	return &BlobData{
 		Key:      key,
@@ -451,39 +687,93 @@ func (fbs *FilesystemBlobStorage) Retrieve(ctx context.Context, key string) (*Bl
 	}, nil
 }
 
+// readMetadata returns the metadata of the blob whose file at filePath is
+// described by info. The size and checksum of the .meta file are only
+// trusted when it matches the file's size and is not older than the file,
+// since a crash between renaming the blob and its .meta leaves the old
+// metadata, which may have the same size; a blob without one is dated by
+// its modification time.
+func (fbs *FilesystemBlobStorage) readMetadata(filePath string, info os.FileInfo) BlobMetadata {
+	metadata := BlobMetadata{
+		Size:      info.Size(),
+		CreatedAt: info.ModTime(),
+		UpdatedAt: info.ModTime(),
+	}
+
+	stored, _, ok := readMetadataFile(filePath)
+	if !ok {
+		return metadata
+	}
+	metadata.ContentType = stored.ContentType
+	metadata.Filename = stored.Filename
+	metadata.Tags = stored.Tags
+	if !stored.CreatedAt.IsZero() {
+		metadata.CreatedAt = stored.CreatedAt
+	}
+
+	fresh := stored.Size == info.Size()
+	if stored.Compression != "" {
+		fresh = stored.CompressedSize == info.Size()
+	}
+	// The .meta file is written after the blob, so one older than the blob
+	// belongs to the content it replaced
+	if meta, err := os.Stat(filePath + ".meta"); err != nil || info.ModTime().After(meta.ModTime()) {
+		fresh = false
+	}
+	if !fresh {
+		return metadata
+	}
+	metadata.Size = stored.Size
+	metadata.Compression, metadata.CompressedSize = stored.Compression, stored.CompressedSize
+	metadata.Checksum = stored.Checksum
+	if !stored.UpdatedAt.IsZero() {
+		metadata.UpdatedAt = stored.UpdatedAt
+	}
+	return metadata
+}
+
 // Delete removes a blob from filesystem
 func (fbs *FilesystemBlobStorage) Delete(ctx context.Context, key string) error {
-	filePath := filepath.Join(fbs.rootPath, key)
+	filePath, err := fbs.path(key)
+	if err != nil {
+		return err
+	}
 	os.Remove(filePath + ".meta") // Remove metadata if exists
 	return os.Remove(filePath)
 }
 
 // Exists checks if blob exists on filesystem
 func (fbs *FilesystemBlobStorage) Exists(ctx context.Context, key string) (bool, error) {
-	filePath := filepath.Join(fbs.rootPath, key)
-	_, err := os.Stat(filePath)
+	filePath, err := fbs.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(filePath)
 	return err == nil, nil
 }
 
-// List lists blobs on filesystem
-func (fbs *FilesystemBlobStorage) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+// List lists blobs on filesystem. Filters are applied to the metadata of
+// each blob under prefix.
+func (fbs *FilesystemBlobStorage) List(ctx context.Context, prefix string, filters ...BlobFilter) ([]BlobInfo, error) {
 	var infos []BlobInfo
 
 	err := filepath.Walk(fbs.rootPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() || strings.HasSuffix(path, ".meta") {
+		if err != nil {
+			return ignoreRemoved(err)
+		}
+		if !isBlobFile(path, info) {
 			return nil
 		}
 
-		relPath, _ := filepath.Rel(fbs.rootPath, path)
-		if prefix == "" || strings.HasPrefix(relPath, prefix) {
-			infos = append(infos, BlobInfo{
-				Key: relPath,
-				Metadata: BlobMetadata{
-					Size:      info.Size(),
-					CreatedAt: info.ModTime(),
-					UpdatedAt: info.ModTime(),
-				},
-			})
+		key := fbs.key(path)
+		if prefix == "" || strings.HasPrefix(key, prefix) {
+			metadata := fbs.readMetadata(path, info)
+			if matchesAll(filters, metadata) {
+				infos = append(infos, BlobInfo{
+					Key:      key,
+					Metadata: metadata,
+				})
+			}
 		}
 		return nil
 	})
@@ -495,19 +785,29 @@ func (fbs *FilesystemBlobStorage) List(ctx context.Context, prefix string) ([]Bl
 func (fbs *FilesystemBlobStorage) Stats(ctx context.Context) (BlobStats, error) {
 	var totalBlobs int64
 	var totalSize int64
+	var usedSpace int64
 
 	err := filepath.Walk(fbs.rootPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() || strings.HasSuffix(path, ".meta") {
+		if err != nil {
+			return ignoreRemoved(err)
+		}
+		if !isBlobFile(path, info) {
 			return nil
 		}
 		totalBlobs++
-		totalSize += info.Size()
+		totalSize += fbs.readMetadata(path, info).Size
+		usedSpace += info.Size()
 		return nil
 	})
+	if err != nil {
+		return BlobStats{}, err
+	}
 
+	quotas, err := quotaUsages(fbs.quotas, fbs.prefixUsage(ctx))
 	return BlobStats{
 		TotalBlobs: totalBlobs,
 		TotalSize:  totalSize,
-		UsedSpace:  totalSize,
+		UsedSpace:  usedSpace,
+		Quotas:     quotas,
 	}, err
 }
\ No newline at end of file
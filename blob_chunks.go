@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// errBlobChanged is returned for a blob read while it was replaced
+var errBlobChanged = errors.New("blob changed while read")
+
+// chunkTable returns the quoted name of the table holding the chunk rows
+// of the blobs stored larger than the chunk size
+func (dbs *DatabaseBlobStorage) chunkTable() string {
+	return dbs.runtime.Dialect().QuoteIdentifier(dbs.tableName + "_chunks")
+}
+
+// createChunkTable creates the chunk table. Each chunk row carries the
+// generation of the write that stored it, which readers check so a blob
+// replaced while read fails rather than mixing chunks of both writes.
+func (dbs *DatabaseBlobStorage) createChunkTable(ctx context.Context) error {
+	d := dbs.runtime.Dialect()
+	_, err := dbs.runtime.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			blob_key %s NOT NULL,
+			seq %s NOT NULL,
+			data %s NOT NULL,
+			generation %s,
+			PRIMARY KEY (blob_key, seq)
+		)`, dbs.chunkTable(),
+		d.ColumnType(ColumnKey),
+		d.ColumnType(ColumnInt64),
+		d.ColumnType(ColumnBinary),
+		d.ColumnType(ColumnInt64)))
+	if err != nil {
+		return err
+	}
+	return dbs.addMissingColumns(ctx, dbs.chunkTable(), map[string]ColumnKind{"generation": ColumnInt64})
+}
+
+// write stores a blob in one transaction. fill writes the stored bytes of
+// the blob and returns its metadata; they are kept in the blob row when
+// they fit in a chunk, and split into chunk rows otherwise, so no statement
+// carries more than a chunk.
+func (dbs *DatabaseBlobStorage) write(ctx context.Context, key string, fill func(w io.Writer) (BlobMetadata, error)) error {
+	tx, err := dbs.runtime.Begin(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := dbs.writeTx(ctx, tx, key, fill); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (dbs *DatabaseBlobStorage) writeTx(ctx context.Context, tx *AdvancedTx, key string, fill func(w io.Writer) (BlobMetadata, error)) error {
+	if _, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE blob_key = %s", dbs.chunkTable(), dbs.runtime.Dialect().Placeholder(1)), key); err != nil {
+		return err
+	}
+
+	// Non-zero, as 0 stands for the chunk rows written before generations
+	generation := rand.Int63n(math.MaxInt64) + 1
+	insert := Rebind(dbs.runtime.Dialect(), fmt.Sprintf("INSERT INTO %s (blob_key, seq, data, generation) VALUES (?, ?, ?, ?)", dbs.chunkTable()))
+	cw := &chunkWriter{ctx: ctx, tx: tx, insert: insert, key: key, size: dbs.chunkSize, generation: generation}
+	metadata, err := fill(cw)
+	if err != nil {
+		return err
+	}
+	if err := checkQuotas(dbs.quotas, key, metadata.Size, dbs.prefixUsage(ctx, tx.Query)); err != nil {
+		return err
+	}
+	inline, err := cw.finish()
+	if err != nil {
+		return err
+	}
+
+	metadata.UpdatedAt = time.Now()
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = metadata.UpdatedAt
+	}
+	metadata.CompressedSize = 0
+	if metadata.Compression != "" {
+		metadata.CompressedSize = cw.written
+	}
+	return dbs.upsert(ctx, tx, key, inline, metadata, cw.chunks, cw.written, generation)
+}
+
+// chunkWriter inserts the stored bytes of a blob as chunk rows as they are
+// written, keeping at most a chunk in memory
+type chunkWriter struct {
+	ctx        context.Context
+	tx         *AdvancedTx
+	insert     string // statement inserting a chunk row
+	key        string
+	size       int64
+	generation int64
+
+	buf     []byte
+	chunks  int64 // chunk rows inserted
+	written int64
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.buf = append(cw.buf, p...)
+	cw.written += int64(len(p))
+	for int64(len(cw.buf)) > cw.size {
+		if err := cw.flush(cw.buf[:cw.size]); err != nil {
+			return 0, err
+		}
+		cw.buf = append(cw.buf[:0], cw.buf[cw.size:]...)
+	}
+	return len(p), nil
+}
+
+// flush inserts the next chunk row
+func (cw *chunkWriter) flush(chunk []byte) error {
+	_, err := cw.tx.Exec(cw.ctx, cw.insert, cw.key, cw.chunks, chunk, cw.generation)
+	if err != nil {
+		return fmt.Errorf("failed to write blob chunk %d: %w", cw.chunks, err)
+	}
+	cw.chunks++
+	return nil
+}
+
+// finish returns the bytes to keep in the blob row: all of them when they
+// fit in a chunk, else none once the last chunk is inserted
+func (cw *chunkWriter) finish() ([]byte, error) {
+	if cw.chunks == 0 {
+		if cw.buf == nil {
+			return []byte{}, nil
+		}
+		return cw.buf, nil
+	}
+	if len(cw.buf) > 0 {
+		if err := cw.flush(cw.buf); err != nil {
+			return nil, err
+		}
+	}
+	return []byte{}, nil
+}
+
+// chunkReader reads the stored bytes of a chunked blob, fetching one chunk
+// row at a time. Only chunk rows of the generation read with the blob row
+// are read, so a blob replaced or deleted while read fails with
+// errBlobChanged.
+type chunkReader struct {
+	ctx        context.Context
+	runtime    *DBRuntime
+	query      string // statement selecting a chunk row
+	key        string
+	chunks     int64
+	generation int64
+
+	seq int64
+	buf []byte
+}
+
+func (dbs *DatabaseBlobStorage) newChunkReader(ctx context.Context, key string, row blobRow) *chunkReader {
+	query := Rebind(dbs.runtime.Dialect(), fmt.Sprintf("SELECT data FROM %s WHERE blob_key = ? AND seq = ? AND COALESCE(generation, 0) = ?", dbs.chunkTable()))
+	return &chunkReader{ctx: ctx, runtime: dbs.runtime, query: query, key: key, chunks: row.chunks, generation: row.generation}
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.buf) == 0 {
+		if cr.seq == cr.chunks {
+			return 0, io.EOF
+		}
+		row := cr.runtime.QueryRow(cr.ctx, cr.query, cr.key, cr.seq, cr.generation)
+		if err := row.Scan(&cr.buf); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = errBlobChanged
+			}
+			return 0, fmt.Errorf("failed to read blob chunk %d: %w", cr.seq, err)
+		}
+		cr.seq++
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
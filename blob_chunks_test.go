@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBlobChunking(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_chunking?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	storage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{ChunkSize: 100})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	ctx := context.Background()
+	chunkRows := func() int {
+		var n int
+		if err := runtime.QueryRow(ctx, "SELECT COUNT(*) FROM blobs_chunks").Scan(&n); err != nil {
+			t.Fatalf("Failed to count chunks: %v", err)
+		}
+		return n
+	}
+
+	data := make([]byte, 1050)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := storage.Store(ctx, "big", data, BlobMetadata{}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if n := chunkRows(); n != 11 {
+		t.Errorf("Expected 11 chunk rows, got %d", n)
+	}
+	blob, err := storage.Retrieve(ctx, "big")
+	if err != nil || string(blob.Data) != string(data) || blob.Metadata.Size != 1050 {
+		t.Fatalf("Expected the chunked blob back, got %v", err)
+	}
+
+	if err := storage.StoreStream(ctx, "streamed", strings.NewReader(string(data)), BlobMetadata{}); err != nil {
+		t.Fatalf("StoreStream failed: %v", err)
+	}
+	r, _, err := storage.RetrieveStream(ctx, "streamed")
+	if err != nil {
+		t.Fatalf("RetrieveStream failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("Expected the streamed blob back, got %d bytes (%v)", len(got), err)
+	}
+
+	// Replacing with a blob that fits a chunk drops the chunk rows
+	if err := storage.Store(ctx, "big", []byte("small"), BlobMetadata{}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if blob, err := storage.Retrieve(ctx, "big"); err != nil || string(blob.Data) != "small" {
+		t.Fatalf("Expected the replaced blob back, got %v", err)
+	}
+	if err := storage.Delete(ctx, "streamed"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if n := chunkRows(); n != 0 {
+		t.Errorf("Expected no chunk rows left, got %d", n)
+	}
+
+	// A failed stream leaves the previous blob in place
+	if err := storage.StoreStream(ctx, "big", strings.NewReader(string(data)), BlobMetadata{Checksum: "0123"}); err == nil {
+		t.Fatal("Expected a checksum mismatch to be rejected")
+	}
+	if blob, err := storage.Retrieve(ctx, "big"); err != nil || string(blob.Data) != "small" {
+		t.Errorf("Expected the previous blob to stay, got %v", err)
+	}
+	if n := chunkRows(); n != 0 {
+		t.Errorf("Expected the chunk rows of the failed stream rolled back, got %d", n)
+	}
+
+	// A blob replaced while read fails instead of mixing chunks of both
+	first, second := strings.Repeat("a", 250), strings.Repeat("b", 250)
+	if err := storage.Store(ctx, "replaced", []byte(first), BlobMetadata{}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	r, _, err = storage.RetrieveStream(ctx, "replaced")
+	if err != nil {
+		t.Fatalf("RetrieveStream failed: %v", err)
+	}
+	head := make([]byte, 100)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatalf("Failed to read the first chunk: %v", err)
+	}
+	if err := storage.Store(ctx, "replaced", []byte(second), BlobMetadata{}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	rest, err := io.ReadAll(r)
+	r.Close()
+	if !errors.Is(err, errBlobChanged) {
+		t.Errorf("Expected errBlobChanged, got %q (%v)", string(head)+string(rest), err)
+	}
+
+	// Chunk rows written before generations were kept still read
+	for _, table := range []string{"blobs", "blobs_chunks"} {
+		if _, err := runtime.Exec(ctx, "UPDATE "+table+" SET generation = NULL"); err != nil {
+			t.Fatalf("Failed to clear generations: %v", err)
+		}
+	}
+	if blob, err := storage.Retrieve(ctx, "replaced"); err != nil || string(blob.Data) != second {
+		t.Errorf("Expected the blob without generations back, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// BlobCompressor compresses blobs at rest when BlobStorageConfig.Compression
+// is set. gzip is built in; others, such as zstd, are added with
+// RegisterBlobCompressor and picked by BlobStorageConfig.CompressionAlgorithm.
+type BlobCompressor interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipBlobCompressor is the default BlobCompressor
+type GzipBlobCompressor struct{}
+
+// Name returns "gzip"
+func (GzipBlobCompressor) Name() string { return "gzip" }
+
+func (GzipBlobCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (GzipBlobCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+var (
+	blobCompressorsMu sync.RWMutex
+	blobCompressors   = map[string]BlobCompressor{"gzip": GzipBlobCompressor{}}
+)
+
+// RegisterBlobCompressor makes a compressor available under its name, both
+// to store blobs with and to read back the blobs stored with it
+func RegisterBlobCompressor(c BlobCompressor) {
+	blobCompressorsMu.Lock()
+	defer blobCompressorsMu.Unlock()
+	blobCompressors[c.Name()] = c
+}
+
+// lookupBlobCompressor returns the compressor registered as name
+func lookupBlobCompressor(name string) (BlobCompressor, error) {
+	blobCompressorsMu.RLock()
+	defer blobCompressorsMu.RUnlock()
+	c, ok := blobCompressors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown blob compression %q", name)
+	}
+	return c, nil
+}
+
+// newBlobCompressor returns the compressor configured for a storage, or nil
+// when compression is off
+func newBlobCompressor(config *BlobStorageConfig) (BlobCompressor, error) {
+	if !config.Compression {
+		return nil, nil
+	}
+	name := config.CompressionAlgorithm
+	if name == "" {
+		name = "gzip"
+	}
+	return lookupBlobCompressor(name)
+}
+
+// compressBlob returns the bytes to store for data and the name of the
+// compression applied. Data that does not shrink is stored as is.
+func compressBlob(c BlobCompressor, data []byte) ([]byte, string, error) {
+	if c == nil {
+		return data, "", nil
+	}
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to compress blob: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, "", fmt.Errorf("failed to compress blob: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to compress blob: %w", err)
+	}
+	if buf.Len() >= len(data) {
+		return data, "", nil
+	}
+	return buf.Bytes(), c.Name(), nil
+}
+
+// decompressBlob returns the data of a blob stored with compression, which
+// is empty for a blob stored as is
+func decompressBlob(compression string, stored []byte) ([]byte, error) {
+	r, err := decompressBlobReader(compression, io.NopCloser(bytes.NewReader(stored)))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress blob: %w", err)
+	}
+	return data, nil
+}
+
+// decompressBlobReader returns a reader of the data of a blob read from
+// stored. Closing it closes stored.
+func decompressBlobReader(compression string, stored io.ReadCloser) (io.ReadCloser, error) {
+	if compression == "" {
+		return stored, nil
+	}
+	c, err := lookupBlobCompressor(compression)
+	if err != nil {
+		stored.Close()
+		return nil, err
+	}
+	r, err := c.NewReader(stored)
+	if err != nil {
+		stored.Close()
+		return nil, fmt.Errorf("failed to decompress blob: %w", err)
+	}
+	return decompressingReader{r, stored}, nil
+}
+
+// decompressingReader reads decompressed data and closes the stored blob
+// with the decompressor
+type decompressingReader struct {
+	io.ReadCloser
+	stored io.Closer
+}
+
+func (r decompressingReader) Close() error {
+	err := r.ReadCloser.Close()
+	if storedErr := r.stored.Close(); err == nil {
+		err = storedErr
+	}
+	return err
+}
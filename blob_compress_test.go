@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBlobCompression(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_compression?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	dbStorage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{Compression: true})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	fsStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir(), Compression: true})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	if _, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir(), Compression: true, CompressionAlgorithm: "lz9"}); err == nil {
+		t.Error("Expected an unknown compression to be rejected")
+	}
+
+	ctx := context.Background()
+	data := strings.Repeat("compressible ", 1000)
+	for name, storage := range map[string]BlobStorage{"database": dbStorage, "filesystem": fsStorage} {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.Store(ctx, "text", []byte(data), BlobMetadata{ContentType: "text/plain"}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			// Too short to shrink, so stored as is
+			if err := storage.Store(ctx, "tiny", []byte("x"), BlobMetadata{}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if err := storage.StoreStream(ctx, "streamed", strings.NewReader(data), BlobMetadata{}); err != nil {
+				t.Fatalf("StoreStream failed: %v", err)
+			}
+
+			blob, err := storage.Retrieve(ctx, "text")
+			if err != nil {
+				t.Fatalf("Retrieve failed: %v", err)
+			}
+			if string(blob.Data) != data {
+				t.Fatalf("Expected the stored data back, got %d bytes", len(blob.Data))
+			}
+			if blob.Metadata.Compression != "gzip" || blob.Metadata.Size != int64(len(data)) ||
+				blob.Metadata.CompressedSize <= 0 || blob.Metadata.CompressedSize >= blob.Metadata.Size {
+				t.Errorf("Expected gzip metadata with both sizes, got %+v", blob.Metadata)
+			}
+			if blob, err := storage.Retrieve(ctx, "tiny"); err != nil || string(blob.Data) != "x" || blob.Metadata.Compression != "" {
+				t.Errorf("Expected the tiny blob stored as is, got %+v (%v)", blob, err)
+			}
+
+			r, metadata, err := storage.RetrieveStream(ctx, "streamed")
+			if err != nil {
+				t.Fatalf("RetrieveStream failed: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(got) != data || metadata.Compression != "gzip" {
+				t.Fatalf("Expected the streamed data back decompressed, got %d bytes, %+v (%v)", len(got), metadata, err)
+			}
+
+			stats, err := storage.Stats(ctx)
+			if err != nil {
+				t.Fatalf("Stats failed: %v", err)
+			}
+			if stats.TotalSize != int64(2*len(data)+1) || stats.UsedSpace >= stats.TotalSize {
+				t.Errorf("Expected the original size in TotalSize and less UsedSpace, got %+v", stats)
+			}
+		})
+	}
+}
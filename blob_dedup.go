@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of a deduplicated store in its underlying storage
+const (
+	dedupKeyPrefix    = "keys/"    // keys/<key>: the dedupRef of a key
+	dedupObjectPrefix = "objects/" // objects/<hash>: a payload, stored once
+	dedupRefsPrefix   = "refs/"    // refs/<hash>: how many keys reference it
+)
+
+// dedupRef is the record of a key: the hash of its payload and the metadata
+// it was stored with
+type dedupRef struct {
+	Hash     string       `json:"hash"`
+	Metadata BlobMetadata `json:"metadata"`
+}
+
+// DedupBlobStorage is a content-addressable BlobStorage: each key maps to
+// the SHA-256 of its data, and identical payloads are stored once in the
+// underlying storage with a count of the keys referencing them. A payload
+// is deleted with the last key referencing it.
+//
+// Reference counts are updated under a lock of the DedupBlobStorage, so a
+// deduplicated store must be written by a single process. A crash while
+// writing can leave a payload counted once too often, never one missing.
+type DedupBlobStorage struct {
+	storage BlobStorage
+	mu      sync.Mutex
+}
+
+// NewDedupBlobStorage deduplicates the blobs stored in storage, which
+// should hold nothing else
+func NewDedupBlobStorage(storage BlobStorage) *DedupBlobStorage {
+	return &DedupBlobStorage{storage: storage}
+}
+
+// Store stores a blob, reusing the stored payload of any key holding the
+// same data
+func (s *DedupBlobStorage) Store(ctx context.Context, key string, data []byte, metadata BlobMetadata) error {
+	checksum := fmt.Sprintf("%x", md5.Sum(data))
+	if metadata.Checksum != "" && metadata.Checksum != checksum {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", metadata.Checksum, checksum)
+	}
+	metadata.Checksum = checksum
+	metadata.Size = int64(len(data))
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	return s.put(ctx, key, hash, metadata, func(objectKey string) error {
+		return s.storage.Store(ctx, objectKey, data, BlobMetadata{ContentType: metadata.ContentType})
+	})
+}
+
+// StoreStream stores a blob read from r. The data is spooled to a temporary
+// file while hashed, so it is only written to the storage when new.
+func (s *DedupBlobStorage) StoreStream(ctx context.Context, key string, r io.Reader, metadata BlobMetadata) error {
+	spool, err := os.CreateTemp("", "fluxor-dedup-*")
+	if err != nil {
+		return fmt.Errorf("failed to spool blob: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	sha, sum := sha256.New(), md5.New()
+	size, err := io.Copy(io.MultiWriter(spool, sha, sum), contextReader{ctx, r})
+	if err != nil {
+		return fmt.Errorf("failed to spool blob: %w", err)
+	}
+	checksum := fmt.Sprintf("%x", sum.Sum(nil))
+	if metadata.Checksum != "" && metadata.Checksum != checksum {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", metadata.Checksum, checksum)
+	}
+	metadata.Checksum = checksum
+	metadata.Size = size
+
+	hash := fmt.Sprintf("%x", sha.Sum(nil))
+	return s.put(ctx, key, hash, metadata, func(objectKey string) error {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to spool blob: %w", err)
+		}
+		return s.storage.StoreStream(ctx, objectKey, spool, BlobMetadata{ContentType: metadata.ContentType})
+	})
+}
+
+// put points key at the payload hash, storing the payload with store unless
+// already stored, and releases the payload the key pointed at before
+func (s *DedupBlobStorage) put(ctx context.Context, key, hash string, metadata BlobMetadata, store func(objectKey string) error) error {
+	metadata.Compression, metadata.CompressedSize = "", 0
+	metadata.UpdatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, hasOld, err := s.readRef(ctx, key)
+	if err != nil {
+		return err
+	}
+	if hasOld && metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = old.Metadata.CreatedAt
+	}
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = metadata.UpdatedAt
+	}
+	if hasOld && old.Hash == hash {
+		return s.writeRef(ctx, key, dedupRef{Hash: hash, Metadata: metadata})
+	}
+
+	refs, err := s.refs(ctx, hash)
+	if err != nil {
+		return err
+	}
+	// Counted before stored, so a crash leaks the payload rather than
+	// leaving a key without one
+	if err := s.setRefs(ctx, hash, refs+1); err != nil {
+		return err
+	}
+	if refs == 0 {
+		if err := store(dedupObjectPrefix + hash); err != nil {
+			s.setRefs(ctx, hash, refs)
+			return err
+		}
+	}
+	if err := s.writeRef(ctx, key, dedupRef{Hash: hash, Metadata: metadata}); err != nil {
+		s.release(ctx, hash)
+		return err
+	}
+	if hasOld {
+		return s.release(ctx, old.Hash)
+	}
+	return nil
+}
+
+// Retrieve retrieves a blob with the metadata it was stored with
+func (s *DedupBlobStorage) Retrieve(ctx context.Context, key string) (*BlobData, error) {
+	ref, err := s.lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := s.storage.Retrieve(ctx, dedupObjectPrefix+ref.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &BlobData{Key: key, Data: blob.Data, Metadata: ref.Metadata}, nil
+}
+
+// RetrieveStream returns a reader over a blob
+func (s *DedupBlobStorage) RetrieveStream(ctx context.Context, key string) (io.ReadCloser, BlobMetadata, error) {
+	ref, err := s.lookup(ctx, key)
+	if err != nil {
+		return nil, BlobMetadata{}, err
+	}
+	r, _, err := s.storage.RetrieveStream(ctx, dedupObjectPrefix+ref.Hash)
+	if err != nil {
+		return nil, BlobMetadata{}, err
+	}
+	return r, ref.Metadata, nil
+}
+
+// Delete removes a key, and its payload when no other key references it
+func (s *DedupBlobStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ref, ok, err := s.readRef(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("blob not found: %s", key)
+	}
+	if err := s.storage.Delete(ctx, dedupKeyPrefix+key); err != nil {
+		return err
+	}
+	return s.release(ctx, ref.Hash)
+}
+
+// Exists checks if a key exists
+func (s *DedupBlobStorage) Exists(ctx context.Context, key string) (bool, error) {
+	return s.storage.Exists(ctx, dedupKeyPrefix+key)
+}
+
+// List lists the keys with optional prefix and metadata filters. The record
+// of each key is read for its metadata.
+func (s *DedupBlobStorage) List(ctx context.Context, prefix string, filters ...BlobFilter) ([]BlobInfo, error) {
+	keys, err := s.storage.List(ctx, dedupKeyPrefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	var infos []BlobInfo
+	for _, info := range keys {
+		key := strings.TrimPrefix(info.Key, dedupKeyPrefix)
+		ref, ok, err := s.readRef(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok && matchesAll(filters, ref.Metadata) {
+			infos = append(infos, BlobInfo{Key: key, Metadata: ref.Metadata})
+		}
+	}
+	return infos, nil
+}
+
+// Stats returns the count and size of the keys, as if each held its own
+// copy, and the space used by the underlying storage
+func (s *DedupBlobStorage) Stats(ctx context.Context) (BlobStats, error) {
+	infos, err := s.List(ctx, "")
+	if err != nil {
+		return BlobStats{}, err
+	}
+	underlying, err := s.storage.Stats(ctx)
+	if err != nil {
+		return BlobStats{}, err
+	}
+	stats := BlobStats{TotalBlobs: int64(len(infos)), UsedSpace: underlying.UsedSpace}
+	for _, info := range infos {
+		stats.TotalSize += info.Metadata.Size
+	}
+	return stats, nil
+}
+
+// lookup returns the record of a key
+func (s *DedupBlobStorage) lookup(ctx context.Context, key string) (dedupRef, error) {
+	ref, ok, err := s.readRef(ctx, key)
+	if err == nil && !ok {
+		err = fmt.Errorf("blob not found: %s", key)
+	}
+	return ref, err
+}
+
+// readRef reads the record of a key, if any
+func (s *DedupBlobStorage) readRef(ctx context.Context, key string) (dedupRef, bool, error) {
+	if exists, err := s.storage.Exists(ctx, dedupKeyPrefix+key); err != nil || !exists {
+		return dedupRef{}, false, err
+	}
+	blob, err := s.storage.Retrieve(ctx, dedupKeyPrefix+key)
+	if err != nil {
+		return dedupRef{}, false, err
+	}
+	var ref dedupRef
+	if err := json.Unmarshal(blob.Data, &ref); err != nil {
+		return dedupRef{}, false, fmt.Errorf("invalid dedup record for %s: %w", key, err)
+	}
+	return ref, true, nil
+}
+
+func (s *DedupBlobStorage) writeRef(ctx context.Context, key string, ref dedupRef) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	return s.storage.Store(ctx, dedupKeyPrefix+key, data, BlobMetadata{ContentType: "application/json"})
+}
+
+// refs returns how many keys reference the payload hash
+func (s *DedupBlobStorage) refs(ctx context.Context, hash string) (int, error) {
+	if exists, err := s.storage.Exists(ctx, dedupRefsPrefix+hash); err != nil || !exists {
+		return 0, err
+	}
+	blob, err := s.storage.Retrieve(ctx, dedupRefsPrefix+hash)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(string(bytes.TrimSpace(blob.Data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid reference count for %s: %w", hash, err)
+	}
+	return n, nil
+}
+
+func (s *DedupBlobStorage) setRefs(ctx context.Context, hash string, n int) error {
+	if n <= 0 {
+		return s.storage.Delete(ctx, dedupRefsPrefix+hash)
+	}
+	return s.storage.Store(ctx, dedupRefsPrefix+hash, []byte(strconv.Itoa(n)), BlobMetadata{ContentType: "text/plain"})
+}
+
+// release drops a reference to the payload hash, deleting the payload with
+// the last one
+func (s *DedupBlobStorage) release(ctx context.Context, hash string) error {
+	refs, err := s.refs(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if refs > 1 {
+		return s.setRefs(ctx, hash, refs-1)
+	}
+	if err := s.storage.Delete(ctx, dedupObjectPrefix+hash); err != nil {
+		return err
+	}
+	return s.setRefs(ctx, hash, 0)
+}
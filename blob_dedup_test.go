@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDedupBlobStorage(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:dedup_blobs?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	dbStorage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	fsStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+
+	ctx := context.Background()
+	attachment := strings.Repeat("attachment ", 100)
+	for name, underlying := range map[string]BlobStorage{"database": dbStorage, "filesystem": fsStorage} {
+		t.Run(name, func(t *testing.T) {
+			storage := NewDedupBlobStorage(underlying)
+			objects := func() int {
+				infos, err := underlying.List(ctx, dedupObjectPrefix)
+				if err != nil {
+					t.Fatalf("List failed: %v", err)
+				}
+				return len(infos)
+			}
+
+			if err := storage.Store(ctx, "mail/1/a.txt", []byte(attachment), BlobMetadata{Filename: "a.txt"}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if err := storage.StoreStream(ctx, "mail/2/a.txt", strings.NewReader(attachment), BlobMetadata{Filename: "copy.txt"}); err != nil {
+				t.Fatalf("StoreStream failed: %v", err)
+			}
+			if n := objects(); n != 1 {
+				t.Errorf("Expected identical payloads stored once, got %d objects", n)
+			}
+
+			blob, err := storage.Retrieve(ctx, "mail/2/a.txt")
+			if err != nil || string(blob.Data) != attachment || blob.Metadata.Filename != "copy.txt" {
+				t.Fatalf("Expected the payload with the metadata of its key, got %+v (%v)", blob, err)
+			}
+			stats, err := storage.Stats(ctx)
+			if err != nil || stats.TotalBlobs != 2 || stats.TotalSize != int64(2*len(attachment)) {
+				t.Errorf("Expected 2 blobs of the full size, got %+v (%v)", stats, err)
+			}
+			if infos, err := storage.List(ctx, "mail/"); err != nil || len(infos) != 2 {
+				t.Errorf("Expected 2 listed keys, got %d (%v)", len(infos), err)
+			}
+
+			// Replacing one key keeps the payload the other references
+			if err := storage.Store(ctx, "mail/1/a.txt", []byte("edited"), BlobMetadata{}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if n := objects(); n != 2 {
+				t.Errorf("Expected 2 objects, got %d", n)
+			}
+			if err := storage.Delete(ctx, "mail/2/a.txt"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if n := objects(); n != 1 {
+				t.Errorf("Expected the unreferenced payload deleted, got %d objects", n)
+			}
+			if exists, _ := storage.Exists(ctx, "mail/2/a.txt"); exists {
+				t.Error("Expected the deleted key to be gone")
+			}
+			if blob, err := storage.Retrieve(ctx, "mail/1/a.txt"); err != nil || string(blob.Data) != "edited" {
+				t.Errorf("Expected the edited blob, got %v", err)
+			}
+			if err := storage.Delete(ctx, "mail/1/a.txt"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if n := objects(); n != 0 {
+				t.Errorf("Expected no objects left, got %d", n)
+			}
+		})
+	}
+}
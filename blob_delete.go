@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BlobDeleteResult is the outcome of deleting one key of a DeleteBatch
+type BlobDeleteResult struct {
+	Key     string `json:"key"`
+	Deleted bool   `json:"deleted"` // false for a key that did not exist
+	Err     error  `json:"-"`
+}
+
+const (
+	// blobDeleteBatchSize bounds the keys bound to one DELETE statement,
+	// within the parameter limits of every dialect
+	blobDeleteBatchSize = 500
+	// blobDeleteParallelism bounds the files the filesystem backend deletes
+	// at once
+	blobDeleteParallelism = 8
+)
+
+// likePrefix returns the LIKE pattern matching the strings starting with
+// prefix, escaping its wildcards with '!'
+func likePrefix(prefix string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(prefix) + "%"
+}
+
+// DeletePrefix deletes every blob whose key starts with prefix, with one
+// statement per table, and returns how many were deleted. An empty prefix
+// is rejected rather than taken to mean every blob.
+func (dbs *DatabaseBlobStorage) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("blob prefix is required")
+	}
+	d := dbs.runtime.Dialect()
+	tx, err := dbs.runtime.Begin(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s LIKE %s ESCAPE '!'", dbs.table(), dbs.keyColumn(), d.Placeholder(1)), likePrefix(prefix))
+	if err == nil {
+		_, err = tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE blob_key LIKE %s ESCAPE '!'", dbs.chunkTable(), d.Placeholder(1)), likePrefix(prefix))
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// DeleteBatch deletes keys, binding up to 500 of them to each statement.
+// A failed statement fails the whole batch, as it is one transaction.
+func (dbs *DatabaseBlobStorage) DeleteBatch(ctx context.Context, keys []string) ([]BlobDeleteResult, error) {
+	tx, err := dbs.runtime.Begin(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BlobDeleteResult, 0, len(keys))
+	for start := 0; start < len(keys); start += blobDeleteBatchSize {
+		batch := keys[start:min(start+blobDeleteBatchSize, len(keys))]
+		deleted, err := dbs.deleteBatchTx(ctx, tx, batch)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		for _, key := range batch {
+			results = append(results, BlobDeleteResult{Key: key, Deleted: deleted[key]})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// deleteBatchTx deletes a batch of keys and returns those that existed
+func (dbs *DatabaseBlobStorage) deleteBatchTx(ctx context.Context, tx *AdvancedTx, keys []string) (map[string]bool, error) {
+	d := dbs.runtime.Dialect()
+	placeholders := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		placeholders[i] = d.Placeholder(i + 1)
+		args[i] = key
+	}
+	in := "(" + strings.Join(placeholders, ", ") + ")"
+
+	rows, err := tx.Query(ctx, fmt.Sprintf("SELECT %[1]s FROM %[2]s WHERE %[1]s IN %[3]s", dbs.keyColumn(), dbs.table(), in), args...)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(keys))
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		existing[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s IN %s", dbs.table(), dbs.keyColumn(), in), args...); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE blob_key IN %s", dbs.chunkTable(), in), args...); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// remove deletes the blob at filePath and its metadata, reporting whether
+// the blob existed
+func (fbs *FilesystemBlobStorage) remove(filePath string) (bool, error) {
+	os.Remove(filePath + ".meta")
+	err := os.Remove(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeletePrefix deletes every blob whose key starts with prefix, walking the
+// tree and deleting up to 8 files at once, and returns how many were
+// deleted. An empty prefix is rejected rather than taken to mean every
+// blob.
+func (fbs *FilesystemBlobStorage) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("blob prefix is required")
+	}
+
+	paths := make(chan string)
+	var (
+		mu      sync.Mutex
+		deleted int
+		errs    []error
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < blobDeleteParallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				ok, err := fbs.remove(path)
+				mu.Lock()
+				if ok {
+					deleted++
+				}
+				if err != nil {
+					errs = append(errs, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	walkErr := filepath.Walk(fbs.rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return ignoreRemoved(err)
+		}
+		if !isBlobFile(path, info) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(fbs.key(path), prefix) {
+			paths <- path
+		}
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+
+	return deleted, errors.Join(append(errs, walkErr)...)
+}
+
+// DeleteBatch deletes keys, up to 8 at once. The result of each key carries
+// its own error.
+func (fbs *FilesystemBlobStorage) DeleteBatch(ctx context.Context, keys []string) ([]BlobDeleteResult, error) {
+	results := make([]BlobDeleteResult, len(keys))
+	sem := make(chan struct{}, blobDeleteParallelism)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		results[i].Key = key
+		filePath, err := fbs.path(key)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(result *BlobDeleteResult) {
+			defer func() { <-sem; wg.Done() }()
+			result.Deleted, result.Err = fbs.remove(filePath)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// DeletePrefix deletes every key starting with prefix, releasing their
+// payloads, and returns how many were deleted
+func (s *DedupBlobStorage) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("blob prefix is required")
+	}
+	infos, err := s.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	var errs []error
+	for _, info := range infos {
+		if err := s.Delete(ctx, info.Key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// DeleteBatch deletes keys one at a time, releasing their payloads
+func (s *DedupBlobStorage) DeleteBatch(ctx context.Context, keys []string) ([]BlobDeleteResult, error) {
+	results := make([]BlobDeleteResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+		exists, err := s.Exists(ctx, key)
+		if err != nil || !exists {
+			results[i].Err = err
+			continue
+		}
+		if results[i].Err = s.Delete(ctx, key); results[i].Err == nil {
+			results[i].Deleted = true
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBlobDeletes(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_deletes?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	dbStorage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{ChunkSize: 4})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	fsStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	dedupBase, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+
+	ctx := context.Background()
+	storages := map[string]BlobStorage{
+		"database":   dbStorage,
+		"filesystem": fsStorage,
+		"dedup":      NewDedupBlobStorage(dedupBase),
+	}
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			// "a_1/" must not match "a_/" as a LIKE pattern would
+			for _, key := range []string{"a_/1", "a_/2", "a_/3", "a_1/x", "b/1", "b/2"} {
+				if err := storage.Store(ctx, key, []byte("payload of "+key), BlobMetadata{}); err != nil {
+					t.Fatalf("Store failed: %v", err)
+				}
+			}
+
+			if _, err := storage.DeletePrefix(ctx, ""); err == nil {
+				t.Error("Expected an empty prefix to be rejected")
+			}
+			n, err := storage.DeletePrefix(ctx, "a_/")
+			if err != nil {
+				t.Fatalf("DeletePrefix failed: %v", err)
+			}
+			if n != 3 {
+				t.Errorf("Expected 3 blobs deleted, got %d", n)
+			}
+			if exists, _ := storage.Exists(ctx, "a_1/x"); !exists {
+				t.Error("Expected a_1/x to survive DeletePrefix(a_/)")
+			}
+
+			results, err := storage.DeleteBatch(ctx, []string{"b/1", "missing", "b/2"})
+			if err != nil {
+				t.Fatalf("DeleteBatch failed: %v", err)
+			}
+			want := []BlobDeleteResult{{Key: "b/1", Deleted: true}, {Key: "missing"}, {Key: "b/2", Deleted: true}}
+			if len(results) != len(want) {
+				t.Fatalf("Expected %d results, got %d", len(want), len(results))
+			}
+			for i, result := range results {
+				if result != want[i] {
+					t.Errorf("Expected result %+v, got %+v", want[i], result)
+				}
+			}
+
+			infos, err := storage.List(ctx, "")
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(infos) != 1 || infos[0].Key != "a_1/x" {
+				t.Errorf("Expected only a_1/x left, got %v", infos)
+			}
+		})
+	}
+
+	results, err := fsStorage.DeleteBatch(ctx, []string{"../escape"})
+	if err != nil {
+		t.Fatalf("DeleteBatch failed: %v", err)
+	}
+	if !errors.Is(results[0].Err, ErrInvalidBlobKey) {
+		t.Errorf("Expected ErrInvalidBlobKey, got %v", results[0].Err)
+	}
+
+	var chunks int
+	if err := runtime.QueryRow(ctx, "SELECT COUNT(*) FROM "+dbStorage.chunkTable()+" WHERE blob_key LIKE 'a!_/%' ESCAPE '!' OR blob_key LIKE 'b/%'").Scan(&chunks); err != nil {
+		t.Fatalf("Failed to count chunks: %v", err)
+	}
+	if chunks != 0 {
+		t.Errorf("Expected the chunks of deleted blobs removed, got %d", chunks)
+	}
+}
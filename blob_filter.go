@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// BlobFilter narrows List to the blobs of a content type carrying all the
+// given tags. Zero fields match every blob.
+type BlobFilter struct {
+	ContentType string
+	Tags        map[string]string
+}
+
+// matches reports whether a blob with metadata passes the filter
+func (f BlobFilter) matches(metadata BlobMetadata) bool {
+	if f.ContentType != "" && metadata.ContentType != f.ContentType {
+		return false
+	}
+	for k, v := range f.Tags {
+		if tag, ok := metadata.Tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAll reports whether a blob with metadata passes every filter
+func matchesAll(filters []BlobFilter, metadata BlobMetadata) bool {
+	for _, f := range filters {
+		if !f.matches(metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterConditions appends the WHERE conditions and arguments selecting the
+// rows that pass every filter. Postgres tests tags with jsonb containment,
+// which the GIN index of createTagIndex serves; MySQL with JSON_CONTAINS,
+// which cannot use an index unless the caller adds a generated column for a
+// tag; SQLite with json_each.
+func (dbs *DatabaseBlobStorage) filterConditions(filters []BlobFilter, conditions []string, args []interface{}) ([]string, []interface{}) {
+	d := dbs.runtime.Dialect()
+	for _, f := range filters {
+		if f.ContentType != "" {
+			args = append(args, f.ContentType)
+			conditions = append(conditions, "content_type = "+d.Placeholder(len(args)))
+		}
+		if len(f.Tags) == 0 {
+			continue
+		}
+		switch d.Type() {
+		case DatabaseTypePostgreSQL, DatabaseTypeMySQL:
+			tags, _ := json.Marshal(f.Tags)
+			args = append(args, string(tags))
+			if d.Type() == DatabaseTypePostgreSQL {
+				conditions = append(conditions, "tags @> "+d.Placeholder(len(args))+"::jsonb")
+			} else {
+				conditions = append(conditions, "JSON_CONTAINS(tags, "+d.Placeholder(len(args))+")")
+			}
+		default:
+			for k, v := range f.Tags {
+				args = append(args, k, v)
+				conditions = append(conditions, fmt.Sprintf(
+					"EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(tags) THEN tags ELSE '{}' END) WHERE json_each.key = %s AND json_each.value = %s)",
+					d.Placeholder(len(args)-1), d.Placeholder(len(args))))
+			}
+		}
+	}
+	return conditions, args
+}
+
+// createTagIndex indexes the tags column where the dialect can serve tag
+// filters from an index
+func (dbs *DatabaseBlobStorage) createTagIndex(ctx context.Context) error {
+	if dbs.runtime.Dialect().Type() != DatabaseTypePostgreSQL {
+		return nil
+	}
+	d := dbs.runtime.Dialect()
+	_, err := dbs.runtime.Exec(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (tags)", d.QuoteIdentifier(dbs.tableName+"_tags_idx"), dbs.table()))
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBlobListFilters(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_list_filters?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	dbStorage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	fsStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+
+	ctx := context.Background()
+	for name, storage := range map[string]BlobStorage{"database": dbStorage, "filesystem": fsStorage} {
+		t.Run(name, func(t *testing.T) {
+			blobs := map[string]BlobMetadata{
+				"docs/a.pdf": {ContentType: "application/pdf", Tags: map[string]string{"invoice": "2024", "paid": "yes"}},
+				"docs/b.pdf": {ContentType: "application/pdf", Tags: map[string]string{"invoice": "2023"}},
+				"docs/c.txt": {ContentType: "text/plain", Tags: map[string]string{"invoice": "2024"}},
+				"other/d":    {ContentType: "text/plain"},
+			}
+			for key, metadata := range blobs {
+				if err := storage.Store(ctx, key, []byte(key), metadata); err != nil {
+					t.Fatalf("Store failed: %v", err)
+				}
+			}
+
+			for _, tc := range []struct {
+				prefix  string
+				filters []BlobFilter
+				want    int
+			}{
+				{"", nil, 4},
+				{"", []BlobFilter{{Tags: map[string]string{"invoice": "2024"}}}, 2},
+				{"", []BlobFilter{{Tags: map[string]string{"invoice": "2024", "paid": "yes"}}}, 1},
+				{"", []BlobFilter{{ContentType: "text/plain"}}, 2},
+				{"docs/", []BlobFilter{{ContentType: "text/plain"}}, 1},
+				{"", []BlobFilter{{ContentType: "application/pdf"}, {Tags: map[string]string{"invoice": "2023"}}}, 1},
+				{"", []BlobFilter{{Tags: map[string]string{"invoice": "1999"}}}, 0},
+			} {
+				infos, err := storage.List(ctx, tc.prefix, tc.filters...)
+				if err != nil {
+					t.Fatalf("List failed: %v", err)
+				}
+				if len(infos) != tc.want {
+					t.Errorf("List(%q, %+v): expected %d blobs, got %d", tc.prefix, tc.filters, tc.want, len(infos))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// blobHTTPPath is the path prefix of the blob handler; the rest of the
+// path is the key
+const blobHTTPPath = "/v1/blobs/"
+
+// BlobHTTPHandler serves the blobs of a storage over HTTP to clients
+// holding a pre-signed token, passed in the token query parameter or as a
+// bearer token:
+//
+//	GET    /v1/blobs/<key>  downloads a blob (BlobOperationGet)
+//	PUT    /v1/blobs/<key>  uploads a blob (BlobOperationPut)
+//	DELETE /v1/blobs/<key>  deletes a blob (BlobOperationDelete)
+//
+// Blobs are streamed in both directions. Mount it at "/v1/blobs/".
+type BlobHTTPHandler struct {
+	storage BlobStorage
+	signer  *BlobTokenSigner
+}
+
+// NewBlobHTTPHandler creates a handler serving storage to the holders of
+// tokens signed by signer
+func NewBlobHTTPHandler(storage BlobStorage, signer *BlobTokenSigner) *BlobHTTPHandler {
+	return &BlobHTTPHandler{storage: storage, signer: signer}
+}
+
+func (h *BlobHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, blobHTTPPath)
+	if !ok || key == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var op BlobOperation
+	switch r.Method {
+	case http.MethodGet:
+		op = BlobOperationGet
+	case http.MethodPut:
+		op = BlobOperationPut
+	case http.MethodDelete:
+		op = BlobOperationDelete
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.signer.Verify(blobRequestToken(r), key, op); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch op {
+	case BlobOperationGet:
+		h.get(w, r, key)
+	case BlobOperationPut:
+		err := h.storage.StoreStream(r.Context(), key, r.Body, BlobMetadata{ContentType: r.Header.Get("Content-Type")})
+		if err != nil {
+			h.writeError(w, r, key, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case BlobOperationDelete:
+		if err := h.storage.Delete(r.Context(), key); err != nil {
+			h.writeError(w, r, key, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// get streams a blob to the client
+func (h *BlobHTTPHandler) get(w http.ResponseWriter, r *http.Request, key string) {
+	reader, metadata, err := h.storage.RetrieveStream(r.Context(), key)
+	if err != nil {
+		h.writeError(w, r, key, err)
+		return
+	}
+	defer reader.Close()
+
+	if metadata.ContentType != "" {
+		w.Header().Set("Content-Type", metadata.ContentType)
+	}
+	if metadata.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
+	}
+	if metadata.Checksum != "" {
+		w.Header().Set("ETag", strconv.Quote(metadata.Checksum))
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Failed to send blob %s: %v", key, err)
+	}
+}
+
+// writeError writes the status of a failed blob operation. Storages report
+// a missing blob as a plain error, so existence is checked to tell it
+// apart. Only key and quota errors are described to the client, as others
+// may reveal storage internals.
+func (h *BlobHTTPHandler) writeError(w http.ResponseWriter, r *http.Request, key string, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidBlobKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	case r.Method != http.MethodPut:
+		if exists, existsErr := h.storage.Exists(r.Context(), key); existsErr == nil && !exists {
+			http.Error(w, "blob not found", http.StatusNotFound)
+			return
+		}
+	}
+	log.Printf("Failed blob operation on %s: %v", key, err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+// blobRequestToken returns the token of a request, from its query or its
+// Authorization header
+func blobRequestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
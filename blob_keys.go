@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidBlobKey is returned for a key that cannot name a blob on the
+// filesystem, such as one climbing out of the root with ".."
+var ErrInvalidBlobKey = errors.New("invalid blob key")
+
+// maxHashedBlobName bounds the escaped file name of a key in the hashed
+// layout, within the 255 bytes most filesystems allow
+const maxHashedBlobName = 240
+
+// windowsDeviceNames are the file names Windows reserves for devices,
+// whatever their extension
+var windowsDeviceNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// cleanBlobKey validates a key naming a file and returns it normalized:
+// slash-separated, without empty or "." segments. Absolute keys, ".."
+// segments and names the storage keeps for itself (.meta files and writes
+// in progress) or that Windows reserves are rejected.
+func cleanBlobKey(key string) (string, error) {
+	invalid := func(reason string) (string, error) {
+		return "", fmt.Errorf("%w %q: %s", ErrInvalidBlobKey, key, reason)
+	}
+	if key == "" {
+		return invalid("empty")
+	}
+	if strings.ContainsRune(key, 0) {
+		return invalid("contains NUL")
+	}
+	normalized := strings.ReplaceAll(key, `\`, "/")
+	if strings.HasPrefix(normalized, "/") || filepath.VolumeName(key) != "" || filepath.IsAbs(key) {
+		return invalid("absolute path")
+	}
+	for _, segment := range strings.Split(normalized, "/") {
+		switch {
+		case segment == "..":
+			return invalid("parent directory reference")
+		case strings.HasSuffix(segment, ".meta"):
+			return invalid("reserved for metadata")
+		case strings.HasPrefix(segment, blobTempPrefix):
+			return invalid("reserved for writes in progress")
+		case windowsDeviceNames[strings.ToUpper(strings.SplitN(segment, ".", 2)[0])]:
+			return invalid("reserved device name")
+		}
+	}
+	normalized = path.Clean(normalized)
+	if normalized == "." {
+		return invalid("empty")
+	}
+	return normalized, nil
+}
+
+// path returns the file of the blob of key. In the hashed layout blobs are
+// spread over two levels of directories named after the SHA-256 of the key,
+// with the key escaped into a single file name.
+func (fbs *FilesystemBlobStorage) path(key string) (string, error) {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+	if !fbs.hashedLayout {
+		return filepath.Join(fbs.rootPath, filepath.FromSlash(key)), nil
+	}
+	name := url.PathEscape(key)
+	if len(name) > maxHashedBlobName {
+		return "", fmt.Errorf("%w %q: too long for the hashed layout", ErrInvalidBlobKey, key)
+	}
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(fbs.rootPath, hash[:2], hash[2:4], name), nil
+}
+
+// key returns the key of the blob at filePath, a file under the root
+func (fbs *FilesystemBlobStorage) key(filePath string) string {
+	if fbs.hashedLayout {
+		if key, err := url.PathUnescape(filepath.Base(filePath)); err == nil {
+			return key
+		}
+	}
+	rel, _ := filepath.Rel(fbs.rootPath, filePath)
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilesystemBlobKeys(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: filepath.Join(root, "blobs")})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	for _, key := range []string{"", "../escape", "a/../../escape", "/etc/passwd", `..\escape`, "a.meta", "dir/" + blobTempPrefix + "x", "CON.txt", "nul\x00"} {
+		if err := storage.Store(ctx, key, []byte("x"), BlobMetadata{}); !errors.Is(err, ErrInvalidBlobKey) {
+			t.Errorf("Expected key %q to be rejected, got %v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape")); err == nil {
+		t.Fatal("Expected nothing written outside the root")
+	}
+
+	// Keys are normalized
+	if err := storage.Store(ctx, "a//b/./c", []byte("normalized"), BlobMetadata{}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if blob, err := storage.Retrieve(ctx, "a/b/c"); err != nil || string(blob.Data) != "normalized" {
+		t.Errorf("Expected the normalized key to be found, got %v", err)
+	}
+
+	hashed, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: filepath.Join(root, "hashed"), HashedLayout: true})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	for _, key := range []string{"tenant/1/doc.pdf", "tenant/2/doc.pdf", "other"} {
+		if err := hashed.Store(ctx, key, []byte(key), BlobMetadata{}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "hashed", "tenant")); err == nil {
+		t.Error("Expected the hashed layout not to mirror the key")
+	}
+	if blob, err := hashed.Retrieve(ctx, "tenant/1/doc.pdf"); err != nil || string(blob.Data) != "tenant/1/doc.pdf" {
+		t.Errorf("Expected the blob back, got %v", err)
+	}
+	infos, err := hashed.List(ctx, "tenant/")
+	if err != nil || len(infos) != 2 {
+		t.Fatalf("Expected 2 blobs under tenant/, got %d (%v)", len(infos), err)
+	}
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Key, "tenant/") {
+			t.Errorf("Expected listed keys to be the stored keys, got %q", info.Key)
+		}
+	}
+	if err := hashed.Store(ctx, strings.Repeat("k", 300), []byte("x"), BlobMetadata{}); !errors.Is(err, ErrInvalidBlobKey) {
+		t.Errorf("Expected a key too long for the hashed layout to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// BlobSortField is the field ListWithOptions orders blobs by
+type BlobSortField string
+
+const (
+	BlobSortKey       BlobSortField = "key"
+	BlobSortSize      BlobSortField = "size"
+	BlobSortUpdatedAt BlobSortField = "updated_at"
+)
+
+// BlobProjection is the part of the metadata ListWithOptions returns
+type BlobProjection int
+
+const (
+	// BlobProjectionMetadata returns the full metadata of each blob
+	BlobProjectionMetadata BlobProjection = iota
+	// BlobProjectionSizes returns only the size and update time of each
+	// blob, which the database backend reads without the other columns
+	BlobProjectionSizes
+)
+
+// ListOptions orders, limits and projects a listing. Ties are broken by
+// key, in the same direction.
+//
+//	// The 50 most recently updated blobs under "reports/"
+//	storage.ListWithOptions(ctx, "reports/", ListOptions{
+//		SortBy:     BlobSortUpdatedAt,
+//		Descending: true,
+//		Limit:      50,
+//	})
+type ListOptions struct {
+	Filters    []BlobFilter
+	SortBy     BlobSortField // default BlobSortKey
+	Descending bool
+	Limit      int // 0 for all
+	Projection BlobProjection
+}
+
+// validate checks the sort field
+func (opts ListOptions) validate() error {
+	switch opts.SortBy {
+	case "", BlobSortKey, BlobSortSize, BlobSortUpdatedAt:
+		return nil
+	}
+	return fmt.Errorf("unsupported blob sort field %q", opts.SortBy)
+}
+
+// orderBy returns the ORDER BY clause of the options, given the expression
+// ordering keys
+func (opts ListOptions) orderBy(key string) string {
+	direction := ""
+	if opts.Descending {
+		direction = " DESC"
+	}
+	if opts.SortBy == "" || opts.SortBy == BlobSortKey {
+		return key + direction
+	}
+	return string(opts.SortBy) + direction + ", " + key + direction
+}
+
+// less orders two blobs as the options ask
+func (opts ListOptions) less(a, b BlobInfo) bool {
+	if opts.Descending {
+		a, b = b, a
+	}
+	switch opts.SortBy {
+	case BlobSortSize:
+		if a.Metadata.Size != b.Metadata.Size {
+			return a.Metadata.Size < b.Metadata.Size
+		}
+	case BlobSortUpdatedAt:
+		if !a.Metadata.UpdatedAt.Equal(b.Metadata.UpdatedAt) {
+			return a.Metadata.UpdatedAt.Before(b.Metadata.UpdatedAt)
+		}
+	}
+	return a.Key < b.Key
+}
+
+// apply orders, limits and projects blobs listed with the filters of the
+// options already applied
+func (opts ListOptions) apply(infos []BlobInfo) []BlobInfo {
+	sort.Slice(infos, func(i, j int) bool { return opts.less(infos[i], infos[j]) })
+	if opts.Limit > 0 && len(infos) > opts.Limit {
+		infos = infos[:opts.Limit]
+	}
+	if opts.Projection == BlobProjectionSizes {
+		for i, info := range infos {
+			infos[i].Metadata = BlobMetadata{Size: info.Metadata.Size, UpdatedAt: info.Metadata.UpdatedAt}
+		}
+	}
+	return infos
+}
+
+// ListWithOptions lists blobs, ordered and limited by the database
+func (dbs *DatabaseBlobStorage) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) ([]BlobInfo, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return dbs.list(ctx, prefix, "", opts)
+}
+
+// ListWithOptions lists blobs. All the blobs under prefix passing the
+// filters are read before being ordered.
+func (fbs *FilesystemBlobStorage) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) ([]BlobInfo, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	infos, err := fbs.List(ctx, prefix, opts.Filters...)
+	if err != nil {
+		return nil, err
+	}
+	return opts.apply(infos), nil
+}
+
+// ListWithOptions lists keys. All the keys under prefix passing the filters
+// are read before being ordered.
+func (s *DedupBlobStorage) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) ([]BlobInfo, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	infos, err := s.List(ctx, prefix, opts.Filters...)
+	if err != nil {
+		return nil, err
+	}
+	return opts.apply(infos), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobListOptions(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_list_options?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	dbStorage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	fsStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+
+	ctx := context.Background()
+	for name, storage := range map[string]BlobStorage{"database": dbStorage, "filesystem": fsStorage} {
+		t.Run(name, func(t *testing.T) {
+			// Stored oldest first, with the middle one the largest
+			for _, blob := range []struct{ key, data string }{
+				{"x/b", "12"},
+				{"x/a", "12345"},
+				{"x/c", "1"},
+				{"y/z", "123456789"},
+			} {
+				if err := storage.Store(ctx, blob.key, []byte(blob.data), BlobMetadata{ContentType: "text/plain"}); err != nil {
+					t.Fatalf("Store failed: %v", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			keys := func(opts ListOptions) string {
+				infos, err := storage.ListWithOptions(ctx, "x/", opts)
+				if err != nil {
+					t.Fatalf("ListWithOptions failed: %v", err)
+				}
+				var keys []string
+				for _, info := range infos {
+					keys = append(keys, info.Key)
+				}
+				return strings.Join(keys, ",")
+			}
+			if got := keys(ListOptions{}); got != "x/a,x/b,x/c" {
+				t.Errorf("Expected key order, got %s", got)
+			}
+			if got := keys(ListOptions{Descending: true, Limit: 2}); got != "x/c,x/b" {
+				t.Errorf("Expected descending key order, got %s", got)
+			}
+			if got := keys(ListOptions{SortBy: BlobSortSize, Descending: true}); got != "x/a,x/b,x/c" {
+				t.Errorf("Expected largest first, got %s", got)
+			}
+			if got := keys(ListOptions{SortBy: BlobSortUpdatedAt, Descending: true, Limit: 2}); got != "x/c,x/a" {
+				t.Errorf("Expected most recently updated first, got %s", got)
+			}
+
+			infos, err := storage.ListWithOptions(ctx, "x/", ListOptions{Projection: BlobProjectionSizes, Limit: 1})
+			if err != nil || len(infos) != 1 {
+				t.Fatalf("Expected 1 blob, got %d (%v)", len(infos), err)
+			}
+			if infos[0].Metadata.Size != 5 || infos[0].Metadata.ContentType != "" {
+				t.Errorf("Expected only the size, got %+v", infos[0].Metadata)
+			}
+			if _, err := storage.ListWithOptions(ctx, "", ListOptions{SortBy: "color"}); err == nil {
+				t.Error("Expected an unknown sort field to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fsMetadataVersion is the version of the .meta files written by
+// FilesystemBlobStorage. Version 0 is the hand-formatted JSON of earlier
+// releases, which is invalid when a content type or filename holds a quote
+// or backslash.
+const fsMetadataVersion = 1
+
+// fsMetadata is the content of a .meta file
+type fsMetadata struct {
+	Version int `json:"version"`
+	BlobMetadata
+}
+
+// writeMetadataFile writes metadata as the .meta file of the blob at
+// filePath
+func writeMetadataFile(filePath string, metadata BlobMetadata) error {
+	data, err := json.Marshal(fsMetadata{Version: fsMetadataVersion, BlobMetadata: metadata})
+	if err != nil {
+		return fmt.Errorf("failed to write blob metadata: %w", err)
+	}
+	if err := writeFileAtomic(filePath+".meta", data); err != nil {
+		return fmt.Errorf("failed to write blob metadata: %w", err)
+	}
+	return nil
+}
+
+// readMetadataFile reads the .meta file of the blob at filePath, if any,
+// with its version
+func readMetadataFile(filePath string) (BlobMetadata, int, bool) {
+	data, err := os.ReadFile(filePath + ".meta")
+	if err != nil {
+		return BlobMetadata{}, 0, false
+	}
+	var stored fsMetadata
+	if err := json.Unmarshal(data, &stored); err != nil {
+		metadata, ok := parseLegacyMetadata(data)
+		return metadata, 0, ok
+	}
+	return stored.BlobMetadata, stored.Version, true
+}
+
+// parseLegacyMetadata parses a version 0 .meta file that is not valid JSON.
+// Those hold one field per line, with string values quoted but not
+// escaped, so a value is taken whole between the quotes of its line.
+func parseLegacyMetadata(data []byte) (BlobMetadata, bool) {
+	var metadata BlobMetadata
+	parsed := false
+	for _, line := range strings.Split(string(data), "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSuffix(strings.TrimSpace(value), ",")
+		value = strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+
+		switch strings.Trim(name, `"`) {
+		case "content_type":
+			metadata.ContentType = value
+		case "filename":
+			metadata.Filename = value
+		case "checksum":
+			metadata.Checksum = value
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			metadata.Size = size
+		case "created_at":
+			metadata.CreatedAt, _ = time.Parse(time.RFC3339, value)
+		case "updated_at":
+			metadata.UpdatedAt, _ = time.Parse(time.RFC3339, value)
+		default:
+			continue
+		}
+		parsed = true
+	}
+	return metadata, parsed
+}
+
+// MigrateMetadata rewrites the .meta files older than the current version
+// and returns how many it rewrote. Old files are read as they are, so the
+// migration is not needed to read them, only to stop parsing them leniently
+// and to let older releases be retired. A .meta file that does not match
+// its blob is rewritten without the size and checksum it cannot vouch for.
+func (fbs *FilesystemBlobStorage) MigrateMetadata(ctx context.Context) (int, error) {
+	migrated := 0
+	err := filepath.Walk(fbs.rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return ignoreRemoved(err)
+		}
+		if !isBlobFile(path, info) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, version, ok := readMetadataFile(path); !ok || version >= fsMetadataVersion {
+			return nil
+		}
+		if err := writeMetadataFile(path, fbs.readMetadata(path, info)); err != nil {
+			return err
+		}
+		migrated++
+		return nil
+	})
+	return migrated, err
+}
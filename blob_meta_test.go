@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilesystemBlobMetadata(t *testing.T) {
+	root := t.TempDir()
+	storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: root})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	ctx := context.Background()
+	metadataOf := func(key string) BlobMetadata {
+		t.Helper()
+		r, metadata, err := storage.RetrieveStream(ctx, key)
+		if err != nil {
+			t.Fatalf("RetrieveStream failed: %v", err)
+		}
+		r.Close()
+		return metadata
+	}
+
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	stored := BlobMetadata{
+		ContentType: `text/plain; charset="utf-8"`,
+		Filename:    `report "final".txt`,
+		Tags:        map[string]string{"team": "ops"},
+		CreatedAt:   created,
+	}
+	if err := storage.Store(ctx, "docs/report", []byte("report"), stored); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	blob, err := storage.Retrieve(ctx, "docs/report")
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	got := blob.Metadata
+	if got.ContentType != stored.ContentType || got.Filename != stored.Filename || got.Tags["team"] != "ops" {
+		t.Errorf("Expected the stored metadata, got %+v", got)
+	}
+	if !got.CreatedAt.Equal(created) || got.UpdatedAt.Before(created) {
+		t.Errorf("Expected the stored timestamps, got %v and %v", got.CreatedAt, got.UpdatedAt)
+	}
+	if streamed := metadataOf("docs/report"); streamed.Checksum != got.Checksum || !streamed.UpdatedAt.Equal(got.UpdatedAt) {
+		t.Errorf("Expected the recorded checksum and update time, got %+v", streamed)
+	}
+
+	// A .meta file left from before the blob was replaced is not trusted
+	// for size and checksum
+	if err := os.WriteFile(filepath.Join(root, "docs", "report"), []byte("rewritten"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite blob: %v", err)
+	}
+	if stale := metadataOf("docs/report"); stale.Size != 9 || stale.Checksum != "" || stale.ContentType != stored.ContentType {
+		t.Errorf("Expected the file size without checksum, got %+v", stale)
+	}
+
+	// .meta files of earlier releases, one not valid JSON
+	legacy := func(key, contentType, filename, data string) {
+		path := filepath.Join(root, filepath.FromSlash(key))
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write blob: %v", err)
+		}
+		meta := fmt.Sprintf(`{
+		"content_type": "%s",
+		"filename": "%s",
+		"size": %d,
+		"checksum": "%x",
+		"created_at": "%s",
+		"updated_at": "%s"
+	}`, contentType, filename, len(data), md5.Sum([]byte(data)), created.Format(time.RFC3339), created.Format(time.RFC3339))
+		if err := os.WriteFile(path+".meta", []byte(meta), 0644); err != nil {
+			t.Fatalf("Failed to write metadata: %v", err)
+		}
+	}
+	legacy("docs/plain", "image/png", "plain.png", "png data")
+	legacy("docs/quoted", "text/plain", `say "hi".txt`, "hi")
+
+	for key, want := range map[string]BlobMetadata{
+		"docs/plain":  {ContentType: "image/png", Filename: "plain.png", Size: 8},
+		"docs/quoted": {ContentType: "text/plain", Filename: `say "hi".txt`, Size: 2},
+	} {
+		if got := metadataOf(key); got.ContentType != want.ContentType || got.Filename != want.Filename || got.Size != want.Size ||
+			got.Checksum == "" || !got.CreatedAt.Equal(created) {
+			t.Errorf("Expected %s read from its legacy metadata, got %+v", key, got)
+		}
+	}
+
+	migrated, err := storage.MigrateMetadata(ctx)
+	if err != nil {
+		t.Fatalf("MigrateMetadata failed: %v", err)
+	}
+	if migrated != 2 {
+		t.Errorf("Expected 2 metadata files migrated, got %d", migrated)
+	}
+	if _, version, _ := readMetadataFile(filepath.Join(root, "docs", "quoted")); version != fsMetadataVersion {
+		t.Errorf("Expected the metadata at version %d, got %d", fsMetadataVersion, version)
+	}
+	if got := metadataOf("docs/quoted"); got.Filename != `say "hi".txt` || got.Checksum == "" || !got.CreatedAt.Equal(created) {
+		t.Errorf("Expected the metadata kept by the migration, got %+v", got)
+	}
+	if migrated, _ := storage.MigrateMetadata(ctx); migrated != 0 {
+		t.Errorf("Expected nothing left to migrate, got %d", migrated)
+	}
+}
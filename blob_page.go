@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultBlobPageSize is the page size of ListPage for a limit <= 0
+const defaultBlobPageSize = 1000
+
+// BlobPage is a page of blobs returned by ListPage
+type BlobPage struct {
+	Blobs []BlobInfo `json:"blobs"`
+	// NextToken continues the listing after this page, and is empty on the
+	// last one. It encodes the last key of the page, so a token is valid
+	// for any storage holding the same keys.
+	NextToken string `json:"next_token,omitempty"`
+}
+
+func encodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodePageToken returns the key a page token continues after
+func decodePageToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid page token: %w", err)
+	}
+	return string(key), nil
+}
+
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultBlobPageSize
+	}
+	return limit
+}
+
+// newBlobPage returns the page of limit blobs at the start of infos, which
+// holds one more when another page follows
+func newBlobPage(infos []BlobInfo, limit int) BlobPage {
+	if len(infos) <= limit {
+		return BlobPage{Blobs: infos}
+	}
+	infos = infos[:limit]
+	return BlobPage{Blobs: infos, NextToken: encodePageToken(infos[limit-1].Key)}
+}
+
+// ListPage returns a page of blobs, selected by key with an index seek
+// rather than an offset, so late pages cost as much as the first
+func (dbs *DatabaseBlobStorage) ListPage(ctx context.Context, prefix string, limit int, token string, filters ...BlobFilter) (BlobPage, error) {
+	after, err := decodePageToken(token)
+	if err != nil {
+		return BlobPage{}, err
+	}
+	limit = pageLimit(limit)
+	infos, err := dbs.list(ctx, prefix, after, ListOptions{Filters: filters, Limit: limit + 1})
+	if err != nil {
+		return BlobPage{}, err
+	}
+	return newBlobPage(infos, limit), nil
+}
+
+// ListPage returns a page of blobs. The plain layout is walked in key
+// order from the token, skipping the directories before it. The hashed
+// layout spreads keys across directories by hash, so every page walks the
+// whole tree, keeping the first keys after the token.
+func (fbs *FilesystemBlobStorage) ListPage(ctx context.Context, prefix string, limit int, token string, filters ...BlobFilter) (BlobPage, error) {
+	after, err := decodePageToken(token)
+	if err != nil {
+		return BlobPage{}, err
+	}
+	limit = pageLimit(limit)
+	if fbs.hashedLayout {
+		return fbs.listPageHashed(ctx, prefix, limit, after, filters)
+	}
+
+	var infos []BlobInfo
+	_, err = fbs.walkSorted(ctx, fbs.rootPath, "", prefix, after, func(path, key string, info os.FileInfo) bool {
+		metadata := fbs.readMetadata(path, info)
+		if matchesAll(filters, metadata) {
+			infos = append(infos, BlobInfo{Key: key, Metadata: metadata})
+		}
+		return len(infos) <= limit
+	})
+	if err != nil {
+		return BlobPage{}, err
+	}
+	return newBlobPage(infos, limit), nil
+}
+
+// walkSorted visits the blob files under dir, whose keys start with
+// dirKey, in key order. Directories whose keys all sort before after or
+// lack prefix are skipped. It returns false once visit does.
+func (fbs *FilesystemBlobStorage) walkSorted(ctx context.Context, dir, dirKey, prefix, after string, visit func(path, key string, info os.FileInfo) bool) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return true, ignoreRemoved(err)
+	}
+
+	// Keys under a directory share its name and a slash, which can sort
+	// after the files next to it (a.txt before a/b)
+	type keyedEntry struct {
+		os.DirEntry
+		key string
+	}
+	keyed := make([]keyedEntry, len(entries))
+	for i, entry := range entries {
+		keyed[i] = keyedEntry{entry, dirKey + entry.Name()}
+		if entry.IsDir() {
+			keyed[i].key += "/"
+		}
+	}
+	sort.Slice(keyed, func(i, j int) bool { return keyed[i].key < keyed[j].key })
+
+	for _, entry := range keyed {
+		path, key := filepath.Join(dir, entry.Name()), entry.key
+		if entry.IsDir() {
+			if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+				continue
+			}
+			if key < after && !strings.HasPrefix(after, key) {
+				continue
+			}
+			if more, err := fbs.walkSorted(ctx, path, key, prefix, after, visit); err != nil || !more {
+				return more, err
+			}
+			continue
+		}
+		if key <= after || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if err := ignoreRemoved(err); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if !isBlobFile(path, info) {
+			continue
+		}
+		if !visit(path, key, info) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// listPageHashed is ListPage for the hashed layout
+func (fbs *FilesystemBlobStorage) listPageHashed(ctx context.Context, prefix string, limit int, after string, filters []BlobFilter) (BlobPage, error) {
+	var infos []BlobInfo // sorted, at most limit+1
+	err := filepath.Walk(fbs.rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return ignoreRemoved(err)
+		}
+		if !isBlobFile(path, info) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := fbs.key(path)
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			return nil
+		}
+		if len(infos) > limit && key >= infos[limit].Key {
+			return nil
+		}
+		metadata := fbs.readMetadata(path, info)
+		if !matchesAll(filters, metadata) {
+			return nil
+		}
+
+		i := sort.Search(len(infos), func(i int) bool { return infos[i].Key > key })
+		infos = append(infos, BlobInfo{})
+		copy(infos[i+1:], infos[i:])
+		infos[i] = BlobInfo{Key: key, Metadata: metadata}
+		if len(infos) > limit+1 {
+			infos = infos[:limit+1]
+		}
+		return nil
+	})
+	if err != nil {
+		return BlobPage{}, err
+	}
+	return newBlobPage(infos, limit), nil
+}
+
+// ListPage returns a page of keys. Underlying pages are read until the
+// filters let a full page through.
+func (s *DedupBlobStorage) ListPage(ctx context.Context, prefix string, limit int, token string, filters ...BlobFilter) (BlobPage, error) {
+	after, err := decodePageToken(token)
+	if err != nil {
+		return BlobPage{}, err
+	}
+	limit = pageLimit(limit)
+
+	var infos []BlobInfo
+	var underlying string
+	if after != "" {
+		underlying = encodePageToken(dedupKeyPrefix + after)
+	}
+	for len(infos) <= limit {
+		page, err := s.storage.ListPage(ctx, dedupKeyPrefix+prefix, limit+1, underlying)
+		if err != nil {
+			return BlobPage{}, err
+		}
+		for _, info := range page.Blobs {
+			key := strings.TrimPrefix(info.Key, dedupKeyPrefix)
+			ref, ok, err := s.readRef(ctx, key)
+			if err != nil {
+				return BlobPage{}, err
+			}
+			if ok && matchesAll(filters, ref.Metadata) {
+				infos = append(infos, BlobInfo{Key: key, Metadata: ref.Metadata})
+			}
+		}
+		if page.NextToken == "" {
+			break
+		}
+		underlying = page.NextToken
+	}
+	if len(infos) > limit+1 {
+		infos = infos[:limit+1]
+	}
+	return newBlobPage(infos, limit), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestBlobListPages(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_list_pages?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	dbStorage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	fsStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	hashedStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir(), HashedLayout: true})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	dedupStorage := NewDedupBlobStorage(dbStorage)
+
+	ctx := context.Background()
+	storages := []struct {
+		name    string
+		storage BlobStorage
+	}{
+		{"database", dbStorage},
+		{"filesystem", fsStorage},
+		{"hashed", hashedStorage},
+		{"dedup", dedupStorage},
+	}
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 25; i++ {
+				// "page/a.N" sorts before "page/a/N", though walked after it
+				key := fmt.Sprintf("page/a/%02d", i)
+				if i%2 == 0 {
+					key = fmt.Sprintf("page/a.%02d", i)
+				}
+				tags := map[string]string{"even": fmt.Sprint(i%2 == 0)}
+				if err := tc.storage.Store(ctx, key, []byte(key), BlobMetadata{ContentType: "text/plain", Tags: tags}); err != nil {
+					t.Fatalf("Store failed: %v", err)
+				}
+			}
+
+			var keys []string
+			var sizes []int
+			token := ""
+			for {
+				page, err := tc.storage.ListPage(ctx, "page/", 10, token)
+				if err != nil {
+					t.Fatalf("ListPage failed: %v", err)
+				}
+				sizes = append(sizes, len(page.Blobs))
+				for _, info := range page.Blobs {
+					keys = append(keys, info.Key)
+				}
+				if token = page.NextToken; token == "" {
+					break
+				}
+			}
+			if fmt.Sprint(sizes) != "[10 10 5]" {
+				t.Errorf("Expected pages of 10, 10 and 5, got %v", sizes)
+			}
+			if len(keys) != 25 || !sort.StringsAreSorted(keys) || keys[0] != "page/a.00" {
+				t.Errorf("Expected the 25 keys in key order, got %v", keys)
+			}
+
+			page, err := tc.storage.ListPage(ctx, "page/", 100, "", BlobFilter{Tags: map[string]string{"even": "true"}})
+			if err != nil || len(page.Blobs) != 13 || page.NextToken != "" {
+				t.Errorf("Expected one page of the 13 even blobs, got %d, %q (%v)", len(page.Blobs), page.NextToken, err)
+			}
+			if _, err := tc.storage.ListPage(ctx, "page/", 10, "!"); err == nil {
+				t.Error("Expected an invalid token to be rejected")
+			}
+		})
+	}
+}
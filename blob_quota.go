@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrQuotaExceeded is matched by errors.Is for every QuotaExceededError
+var ErrQuotaExceeded = errors.New("blob quota exceeded")
+
+// BlobQuota limits the blobs whose key starts with Prefix, e.g. the keys
+// of a tenant under "tenants/acme/". Quotas may nest; a blob must fit in
+// every quota whose prefix it has.
+type BlobQuota struct {
+	Prefix     string `json:"prefix"`
+	MaxBytes   int64  `json:"max_bytes,omitempty"`   // size of the blobs as stored by callers (0 for no limit)
+	MaxObjects int64  `json:"max_objects,omitempty"` // 0 for no limit
+}
+
+// BlobQuotaUsage is the usage of a quota, reported by Stats
+type BlobQuotaUsage struct {
+	BlobQuota
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// QuotaExceededError is returned by Store and StoreStream when the blob
+// would not fit in a quota. Bytes and Objects are the usage the quota
+// would have with the blob stored.
+type QuotaExceededError struct {
+	Quota   BlobQuota
+	Bytes   int64
+	Objects int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Quota.MaxObjects > 0 && e.Objects > e.Quota.MaxObjects {
+		return fmt.Sprintf("%v for %q: %d of %d objects", ErrQuotaExceeded, e.Quota.Prefix, e.Objects, e.Quota.MaxObjects)
+	}
+	return fmt.Sprintf("%v for %q: %d of %d bytes", ErrQuotaExceeded, e.Quota.Prefix, e.Bytes, e.Quota.MaxBytes)
+}
+
+// Is reports whether target is ErrQuotaExceeded
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// prefixUsage returns the count and size of the blobs under prefix, leaving
+// out the blob at key, which a write is about to replace
+type prefixUsage func(prefix, key string) (objects, bytes int64, err error)
+
+// checkQuotas returns a QuotaExceededError when storing size bytes at key
+// would exceed a quota. Quotas are checked before the blob is committed, so
+// concurrent writes under a prefix can overshoot its quota by the blobs
+// they race with.
+func checkQuotas(quotas []BlobQuota, key string, size int64, usage prefixUsage) error {
+	for _, quota := range quotas {
+		if !strings.HasPrefix(key, quota.Prefix) {
+			continue
+		}
+		objects, bytes, err := usage(quota.Prefix, key)
+		if err != nil {
+			return fmt.Errorf("failed to check quota %q: %w", quota.Prefix, err)
+		}
+		objects, bytes = objects+1, bytes+size
+		if (quota.MaxObjects > 0 && objects > quota.MaxObjects) || (quota.MaxBytes > 0 && bytes > quota.MaxBytes) {
+			return &QuotaExceededError{Quota: quota, Bytes: bytes, Objects: objects}
+		}
+	}
+	return nil
+}
+
+// quotaUsages returns the usage of every quota
+func quotaUsages(quotas []BlobQuota, usage prefixUsage) ([]BlobQuotaUsage, error) {
+	var usages []BlobQuotaUsage
+	for _, quota := range quotas {
+		objects, bytes, err := usage(quota.Prefix, "")
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, BlobQuotaUsage{BlobQuota: quota, Bytes: bytes, Objects: objects})
+	}
+	return usages, nil
+}
+
+// prefixUsage returns the usage under prefix with an aggregate query, run
+// with query so it can see a write transaction
+func (dbs *DatabaseBlobStorage) prefixUsage(ctx context.Context, query func(ctx context.Context, query string, args ...interface{}) (*Rows, error)) prefixUsage {
+	return func(prefix, key string) (int64, int64, error) {
+		d := dbs.runtime.Dialect()
+		rows, err := query(ctx, fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(size), 0) FROM %[1]s WHERE %[2]s LIKE %[3]s ESCAPE '!' AND %[2]s <> %[4]s",
+			dbs.table(), dbs.keyColumn(), d.Placeholder(1), d.Placeholder(2)), likePrefix(prefix), key)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer rows.Close()
+		var objects, bytes int64
+		if rows.Next() {
+			err = rows.Scan(&objects, &bytes)
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		return objects, bytes, err
+	}
+}
+
+// prefixUsage returns the usage under prefix by walking its blobs. In the
+// flat layout only the directory holding the prefix is walked; in the
+// hashed layout the whole tree is.
+func (fbs *FilesystemBlobStorage) prefixUsage(ctx context.Context) prefixUsage {
+	return func(prefix, key string) (int64, int64, error) {
+		root := fbs.rootPath
+		if dir := path.Dir(prefix + "x"); !fbs.hashedLayout && filepath.IsLocal(dir) {
+			root = filepath.Join(fbs.rootPath, filepath.FromSlash(dir))
+		}
+		var objects, bytes int64
+		err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return ignoreRemoved(err)
+			}
+			if !isBlobFile(filePath, info) {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if k := fbs.key(filePath); k != key && strings.HasPrefix(k, prefix) {
+				objects++
+				bytes += fbs.readMetadata(filePath, info).Size
+			}
+			return nil
+		})
+		return objects, bytes, err
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBlobQuotas(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_quotas?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	quotas := []BlobQuota{
+		{Prefix: "tenants/a/", MaxObjects: 2},
+		{Prefix: "tenants/", MaxBytes: 10},
+	}
+	dbStorage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{Quotas: quotas})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	fsStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir(), Quotas: quotas})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+
+	ctx := context.Background()
+	for name, storage := range map[string]BlobStorage{"database": dbStorage, "filesystem": fsStorage} {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"tenants/a/1", "tenants/a/2"} {
+				if err := storage.Store(ctx, key, []byte("abc"), BlobMetadata{}); err != nil {
+					t.Fatalf("Store failed: %v", err)
+				}
+			}
+
+			err := storage.Store(ctx, "tenants/a/3", []byte("abc"), BlobMetadata{})
+			var quotaErr *QuotaExceededError
+			if !errors.As(err, &quotaErr) || quotaErr.Quota.Prefix != "tenants/a/" || quotaErr.Objects != 3 {
+				t.Errorf("Expected the object quota of tenants/a/ exceeded, got %v", err)
+			}
+			if exists, _ := storage.Exists(ctx, "tenants/a/3"); exists {
+				t.Error("Expected the rejected blob not stored")
+			}
+
+			// Replacing a blob does not count it twice
+			if err := storage.Store(ctx, "tenants/a/1", []byte("abcd"), BlobMetadata{}); err != nil {
+				t.Errorf("Expected a replacement within quota, got %v", err)
+			}
+
+			err = storage.StoreStream(ctx, "tenants/b/1", strings.NewReader("abcd"), BlobMetadata{})
+			if !errors.Is(err, ErrQuotaExceeded) || !errors.As(err, &quotaErr) || quotaErr.Quota.Prefix != "tenants/" || quotaErr.Bytes != 11 {
+				t.Errorf("Expected the byte quota of tenants/ exceeded, got %v", err)
+			}
+			if err := storage.Store(ctx, "other/1", make([]byte, 100), BlobMetadata{}); err != nil {
+				t.Errorf("Expected keys outside the quotas unlimited, got %v", err)
+			}
+
+			stats, err := storage.Stats(ctx)
+			if err != nil {
+				t.Fatalf("Stats failed: %v", err)
+			}
+			want := []BlobQuotaUsage{
+				{BlobQuota: quotas[0], Bytes: 7, Objects: 2},
+				{BlobQuota: quotas[1], Bytes: 7, Objects: 2},
+			}
+			if len(stats.Quotas) != len(want) {
+				t.Fatalf("Expected %d quota usages, got %v", len(want), stats.Quotas)
+			}
+			for i, usage := range stats.Quotas {
+				if usage != want[i] {
+					t.Errorf("Expected usage %+v, got %+v", want[i], usage)
+				}
+			}
+		})
+	}
+}
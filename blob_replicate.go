@@ -0,0 +1,607 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicationMode is when a Replicator writes to its secondaries
+type ReplicationMode int
+
+const (
+	// ReplicationSync writes the secondaries before a write returns
+	ReplicationSync ReplicationMode = iota
+	// ReplicationAsync queues the write, and replicates it in the background
+	ReplicationAsync
+)
+
+// Prefixes of the replications in the queue storage: queued, and set aside
+// after a secondary rejected them for good
+const (
+	replicationQueuePrefix  = "replication/"
+	replicationFailedPrefix = "replication-failed/"
+)
+
+// ReplicatorConfig configures a Replicator
+type ReplicatorConfig struct {
+	Mode ReplicationMode
+	// Queue holds the replications pending in async mode, which survive a
+	// restart when the queue is durable, such as a FilesystemBlobStorage.
+	// Required in async mode. It must not be the primary or a secondary.
+	Queue BlobStorage
+	// Interval is how often an async Replicator retries a failed
+	// replication (default 1s)
+	Interval time.Duration
+}
+
+// replicationOp is a queued replication: the key to copy from the primary,
+// or the prefix to delete
+type replicationOp struct {
+	Key          string `json:"key,omitempty"`
+	DeletePrefix string `json:"delete_prefix,omitempty"`
+}
+
+// FailedReplication is a queued replication that a secondary rejected for
+// good, such as a key it cannot hold, set aside so later ones can apply
+type FailedReplication struct {
+	Key          string    `json:"key,omitempty"`
+	DeletePrefix string    `json:"delete_prefix,omitempty"`
+	Error        string    `json:"error"`
+	FailedAt     time.Time `json:"failed_at"`
+}
+
+// ReconcileReport counts the repairs of a Reconcile
+type ReconcileReport struct {
+	Copied  int `json:"copied"`  // blobs missing or different on a secondary
+	Deleted int `json:"deleted"` // blobs on a secondary only
+}
+
+// Replicator is a BlobStorage mirroring the writes of a primary to
+// secondaries, e.g. a filesystem backup of a database storage. Reads are
+// served by the primary.
+//
+// A replication copies the blob as the primary holds it when replicated,
+// or deletes it from the secondaries when the primary no longer holds it,
+// so replaying one is harmless. In async mode a replication is queued
+// before the primary is written, so a crash cannot lose it, and the queue
+// is drained in order by Start or Drain. A queue must be filled by a single
+// Replicator, which only drains the replications of completed writes.
+// Reconcile repairs the divergence left by failures of sync mode or by
+// writes made around the Replicator.
+type Replicator struct {
+	primary     BlobStorage
+	secondaries []BlobStorage
+	config      ReplicatorConfig
+	seq         atomic.Uint64
+
+	drainMu sync.Mutex // serializes Drain, so replications apply in order
+	// writes is held shared by async writes from queuing to writing the
+	// primary, and exclusively by Drain while listing the queue, so a
+	// replication is never applied before its write
+	writes   sync.RWMutex
+	wake     chan struct{}
+	stopChan chan struct{}
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	running  bool
+}
+
+// NewReplicator creates a Replicator of primary to secondaries
+func NewReplicator(primary BlobStorage, secondaries []BlobStorage, config ReplicatorConfig) (*Replicator, error) {
+	if config.Mode == ReplicationAsync && config.Queue == nil {
+		return nil, fmt.Errorf("async replication requires a queue")
+	}
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	return &Replicator{
+		primary:     primary,
+		secondaries: secondaries,
+		config:      config,
+		wake:        make(chan struct{}, 1),
+		stopChan:    make(chan struct{}),
+	}, nil
+}
+
+// Start starts draining the queue of an async Replicator in the background,
+// after each write and every interval
+func (r *Replicator) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running || r.config.Mode != ReplicationAsync {
+		return
+	}
+	r.running = true
+
+	r.wg.Add(1)
+	go r.drainLoop(ctx)
+}
+
+// Stop stops draining the queue and waits for the running drain to finish
+func (r *Replicator) Stop() {
+	r.mu.Lock()
+	if !r.running {
+		r.mu.Unlock()
+		return
+	}
+	close(r.stopChan)
+	r.running = false
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+func (r *Replicator) drainLoop(ctx context.Context) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.Drain(ctx)
+		select {
+		case <-ticker.C:
+		case <-r.wake:
+		case <-r.stopChan:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Drain applies the queued replications in order until the queue is empty,
+// stopping at the first that fails, which stays queued to be retried. A
+// replication every failing secondary rejects for good is set aside with
+// FailedReplications instead, and reported in the returned error once the
+// rest of the queue is drained.
+func (r *Replicator) Drain(ctx context.Context) error {
+	if r.config.Queue == nil {
+		return nil
+	}
+	r.drainMu.Lock()
+	defer r.drainMu.Unlock()
+
+	var failed []error
+	for {
+		r.writes.Lock()
+		page, err := r.config.Queue.ListPage(ctx, replicationQueuePrefix, 0, "")
+		r.writes.Unlock()
+		if err != nil {
+			return err
+		}
+		if len(page.Blobs) == 0 {
+			return errors.Join(failed...)
+		}
+		for _, info := range page.Blobs {
+			blob, err := r.config.Queue.Retrieve(ctx, info.Key)
+			if err != nil {
+				return err
+			}
+			var op replicationOp
+			if err := json.Unmarshal(blob.Data, &op); err != nil {
+				return fmt.Errorf("invalid replication %s: %w", info.Key, err)
+			}
+			if err := r.apply(ctx, op); err != nil {
+				if !permanentReplicationError(err) {
+					return err
+				}
+				if err := r.setAside(ctx, info.Key, op, err); err != nil {
+					return err
+				}
+				failed = append(failed, err)
+				continue
+			}
+			if err := r.config.Queue.Delete(ctx, info.Key); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// permanentReplicationError reports whether every failure of a replication
+// would fail again on a retry, as for a key or a size a secondary rejects
+func permanentReplicationError(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if !permanentReplicationError(err) {
+				return false
+			}
+		}
+		return true
+	}
+	return errors.Is(err, ErrInvalidBlobKey) || errors.Is(err, ErrQuotaExceeded)
+}
+
+// setAside moves the queued replication at key to the failed replications
+func (r *Replicator) setAside(ctx context.Context, key string, op replicationOp, cause error) error {
+	data, err := json.Marshal(FailedReplication{Key: op.Key, DeletePrefix: op.DeletePrefix, Error: cause.Error(), FailedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	failedKey := replicationFailedPrefix + key[len(replicationQueuePrefix):]
+	if err := r.config.Queue.Store(ctx, failedKey, data, BlobMetadata{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("failed to set replication aside: %w", err)
+	}
+	return r.config.Queue.Delete(ctx, key)
+}
+
+// FailedReplications returns the replications set aside by Drain, oldest
+// first
+func (r *Replicator) FailedReplications(ctx context.Context) ([]FailedReplication, error) {
+	if r.config.Queue == nil {
+		return nil, nil
+	}
+	infos, err := r.config.Queue.List(ctx, replicationFailedPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+
+	failures := make([]FailedReplication, 0, len(infos))
+	for _, info := range infos {
+		blob, err := r.config.Queue.Retrieve(ctx, info.Key)
+		if err != nil {
+			return nil, err
+		}
+		var failure FailedReplication
+		if err := json.Unmarshal(blob.Data, &failure); err != nil {
+			return nil, fmt.Errorf("invalid failed replication %s: %w", info.Key, err)
+		}
+		failures = append(failures, failure)
+	}
+	return failures, nil
+}
+
+// RetryFailed queues the replications set aside by Drain again, once what
+// made a secondary reject them is fixed, and returns how many were queued
+func (r *Replicator) RetryFailed(ctx context.Context) (int, error) {
+	if r.config.Queue == nil {
+		return 0, nil
+	}
+	r.writes.RLock()
+	defer r.writes.RUnlock()
+
+	infos, err := r.config.Queue.List(ctx, replicationFailedPrefix)
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+
+	n := 0
+	for _, info := range infos {
+		blob, err := r.config.Queue.Retrieve(ctx, info.Key)
+		if err != nil {
+			return n, err
+		}
+		var failure FailedReplication
+		if err := json.Unmarshal(blob.Data, &failure); err != nil {
+			return n, fmt.Errorf("invalid failed replication %s: %w", info.Key, err)
+		}
+		if err := r.enqueue(ctx, replicationOp{Key: failure.Key, DeletePrefix: failure.DeletePrefix}); err != nil {
+			return n, err
+		}
+		if err := r.config.Queue.Delete(ctx, info.Key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Pending returns how many replications are queued
+func (r *Replicator) Pending(ctx context.Context) (int, error) {
+	if r.config.Queue == nil {
+		return 0, nil
+	}
+	infos, err := r.config.Queue.List(ctx, replicationQueuePrefix)
+	return len(infos), err
+}
+
+// Store stores a blob in the primary and replicates it
+func (r *Replicator) Store(ctx context.Context, key string, data []byte, metadata BlobMetadata) error {
+	if r.config.Mode == ReplicationAsync {
+		return r.queued(ctx, []replicationOp{{Key: key}}, func() error {
+			return r.primary.Store(ctx, key, data, metadata)
+		})
+	}
+	if err := r.primary.Store(ctx, key, data, metadata); err != nil {
+		return err
+	}
+	var errs []error
+	for _, secondary := range r.secondaries {
+		if err := secondary.Store(ctx, key, data, metadata); err != nil {
+			errs = append(errs, fmt.Errorf("failed to replicate %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StoreStream stores a blob read from reader in the primary and replicates
+// it, reading it back from the primary in sync mode
+func (r *Replicator) StoreStream(ctx context.Context, key string, reader io.Reader, metadata BlobMetadata) error {
+	return r.write(ctx, []replicationOp{{Key: key}}, func() error {
+		return r.primary.StoreStream(ctx, key, reader, metadata)
+	})
+}
+
+// Retrieve retrieves a blob from the primary
+func (r *Replicator) Retrieve(ctx context.Context, key string) (*BlobData, error) {
+	return r.primary.Retrieve(ctx, key)
+}
+
+// RetrieveStream returns a reader over a blob of the primary
+func (r *Replicator) RetrieveStream(ctx context.Context, key string) (io.ReadCloser, BlobMetadata, error) {
+	return r.primary.RetrieveStream(ctx, key)
+}
+
+// Delete deletes a blob from the primary and the secondaries
+func (r *Replicator) Delete(ctx context.Context, key string) error {
+	return r.write(ctx, []replicationOp{{Key: key}}, func() error {
+		return r.primary.Delete(ctx, key)
+	})
+}
+
+// DeletePrefix deletes the blobs under prefix from the primary and the
+// secondaries, returning how many the primary held
+func (r *Replicator) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("blob prefix is required")
+	}
+	var n int
+	err := r.write(ctx, []replicationOp{{DeletePrefix: prefix}}, func() error {
+		var err error
+		n, err = r.primary.DeletePrefix(ctx, prefix)
+		return err
+	})
+	return n, err
+}
+
+// DeleteBatch deletes keys from the primary and the secondaries, returning
+// the results of the primary
+func (r *Replicator) DeleteBatch(ctx context.Context, keys []string) ([]BlobDeleteResult, error) {
+	ops := make([]replicationOp, len(keys))
+	for i, key := range keys {
+		ops[i] = replicationOp{Key: key}
+	}
+	var results []BlobDeleteResult
+	err := r.write(ctx, ops, func() error {
+		var err error
+		results, err = r.primary.DeleteBatch(ctx, keys)
+		return err
+	})
+	return results, err
+}
+
+// Exists checks if a blob exists in the primary
+func (r *Replicator) Exists(ctx context.Context, key string) (bool, error) {
+	return r.primary.Exists(ctx, key)
+}
+
+// List lists the blobs of the primary
+func (r *Replicator) List(ctx context.Context, prefix string, filters ...BlobFilter) ([]BlobInfo, error) {
+	return r.primary.List(ctx, prefix, filters...)
+}
+
+// ListPage returns a page of the blobs of the primary
+func (r *Replicator) ListPage(ctx context.Context, prefix string, limit int, token string, filters ...BlobFilter) (BlobPage, error) {
+	return r.primary.ListPage(ctx, prefix, limit, token, filters...)
+}
+
+// ListWithOptions lists the blobs of the primary
+func (r *Replicator) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) ([]BlobInfo, error) {
+	return r.primary.ListWithOptions(ctx, prefix, opts)
+}
+
+// Stats returns the statistics of the primary
+func (r *Replicator) Stats(ctx context.Context) (BlobStats, error) {
+	return r.primary.Stats(ctx)
+}
+
+// write runs a write to the primary, then applies its replications in sync
+// mode or queues them in async mode
+func (r *Replicator) write(ctx context.Context, ops []replicationOp, primary func() error) error {
+	if r.config.Mode == ReplicationAsync {
+		return r.queued(ctx, ops, primary)
+	}
+	if err := primary(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, op := range ops {
+		errs = append(errs, r.apply(ctx, op))
+	}
+	return errors.Join(errs...)
+}
+
+// queued queues replications, then runs the write to the primary and wakes
+// the drain loop. The replications stay queued when the write fails, as
+// replaying them is harmless.
+func (r *Replicator) queued(ctx context.Context, ops []replicationOp, primary func() error) error {
+	r.writes.RLock()
+	defer r.writes.RUnlock()
+	for _, op := range ops {
+		if err := r.enqueue(ctx, op); err != nil {
+			return err
+		}
+	}
+	err := primary()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// enqueue queues a replication under a key ordering it after those queued
+// before
+func (r *Replicator) enqueue(ctx context.Context, op replicationOp) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%020d-%010d", replicationQueuePrefix, time.Now().UnixNano(), r.seq.Add(1))
+	if err := r.config.Queue.Store(ctx, key, data, BlobMetadata{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("failed to queue replication: %w", err)
+	}
+	return nil
+}
+
+// apply applies a replication to every secondary
+func (r *Replicator) apply(ctx context.Context, op replicationOp) error {
+	var errs []error
+	for _, secondary := range r.secondaries {
+		var err error
+		if op.DeletePrefix != "" {
+			_, err = secondary.DeletePrefix(ctx, op.DeletePrefix)
+		} else {
+			err = r.copy(ctx, secondary, op.Key)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to replicate %s: %w", op.Key+op.DeletePrefix, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// copy makes secondary hold key as the primary does: a copy of the blob, or
+// nothing when the primary does not hold it
+func (r *Replicator) copy(ctx context.Context, secondary BlobStorage, key string) error {
+	exists, err := r.primary.Exists(ctx, key)
+	if errors.Is(err, ErrInvalidBlobKey) {
+		return nil // the primary cannot hold it, nor was it replicated
+	}
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if exists, err := secondary.Exists(ctx, key); err != nil || !exists {
+			return err
+		}
+		return secondary.Delete(ctx, key)
+	}
+
+	reader, metadata, err := r.primary.RetrieveStream(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	metadata.Compression, metadata.CompressedSize = "", 0
+	return secondary.StoreStream(ctx, key, reader, metadata)
+}
+
+// Reconcile compares every secondary with the primary by key, size and
+// checksum where both storages record one, copying the blobs missing or
+// different on a secondary and deleting those only a secondary holds. A
+// blob is only deleted once the primary confirms it does not hold it, so a
+// listing missing a blob cannot cost its copies. It goes on past a failed
+// repair, returning the failures together.
+func (r *Replicator) Reconcile(ctx context.Context) (ReconcileReport, error) {
+	var report ReconcileReport
+	var errs []error
+	repair := func(key string, count *int, fn func() error) {
+		if err := fn(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reconcile %s: %w", key, err))
+			return
+		}
+		*count++
+	}
+
+	for _, secondary := range r.secondaries {
+		primaryBlobs, secondaryBlobs := newBlobPager(r.primary), newBlobPager(secondary)
+		for {
+			p, pok, err := primaryBlobs.peek(ctx)
+			if err != nil {
+				return report, err
+			}
+			s, sok, err := secondaryBlobs.peek(ctx)
+			if err != nil {
+				return report, err
+			}
+			if !pok && !sok {
+				break
+			}
+
+			switch {
+			case !sok || (pok && p.Key < s.Key):
+				primaryBlobs.next()
+				repair(p.Key, &report.Copied, func() error { return r.copy(ctx, secondary, p.Key) })
+			case !pok || s.Key < p.Key:
+				secondaryBlobs.next()
+				exists, err := r.primary.Exists(ctx, s.Key)
+				switch {
+				case err != nil:
+					errs = append(errs, fmt.Errorf("failed to reconcile %s: %w", s.Key, err))
+				case exists:
+					repair(s.Key, &report.Copied, func() error { return r.copy(ctx, secondary, s.Key) })
+				default:
+					repair(s.Key, &report.Deleted, func() error { return secondary.Delete(ctx, s.Key) })
+				}
+			default:
+				primaryBlobs.next()
+				secondaryBlobs.next()
+				if !sameBlob(p.Metadata, s.Metadata) {
+					repair(p.Key, &report.Copied, func() error { return r.copy(ctx, secondary, p.Key) })
+				}
+			}
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// sameBlob reports whether two listed blobs hold the same data, as far as
+// their metadata tells
+func sameBlob(a, b BlobMetadata) bool {
+	if a.Size != b.Size {
+		return false
+	}
+	return a.Checksum == "" || b.Checksum == "" || a.Checksum == b.Checksum
+}
+
+// blobPager iterates over the blobs of a storage in key order, a page at a
+// time. A storage listing a key out of order fails the iteration, as
+// merging listings relies on the order.
+type blobPager struct {
+	storage BlobStorage
+	blobs   []BlobInfo
+	token   string
+	done    bool
+	last    *string // key of the blob last moved past
+}
+
+func newBlobPager(storage BlobStorage) *blobPager {
+	return &blobPager{storage: storage}
+}
+
+// peek returns the current blob, if any
+func (p *blobPager) peek(ctx context.Context) (BlobInfo, bool, error) {
+	for len(p.blobs) == 0 && !p.done {
+		page, err := p.storage.ListPage(ctx, "", 0, p.token)
+		if err != nil {
+			return BlobInfo{}, false, err
+		}
+		p.blobs, p.token = page.Blobs, page.NextToken
+		p.done = page.NextToken == ""
+	}
+	if len(p.blobs) == 0 {
+		return BlobInfo{}, false, nil
+	}
+	if p.last != nil && p.blobs[0].Key <= *p.last {
+		return BlobInfo{}, false, fmt.Errorf("blob %q listed after %q, out of key order", p.blobs[0].Key, *p.last)
+	}
+	return p.blobs[0], true, nil
+}
+
+// next moves past the current blob
+func (p *blobPager) next() {
+	key := p.blobs[0].Key
+	p.last = &key
+	p.blobs = p.blobs[1:]
+}
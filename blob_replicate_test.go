@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobReplicator(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_replicator?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	newFS := func() *FilesystemBlobStorage {
+		storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+		if err != nil {
+			t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+		}
+		return storage
+	}
+	keys := func(storage BlobStorage) string {
+		infos, err := storage.List(ctx, "")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var keys []string
+		for _, info := range infos {
+			keys = append(keys, info.Key)
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, ",")
+	}
+
+	t.Run("sync", func(t *testing.T) {
+		primary, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{TableName: "replicated_blobs"})
+		if err != nil {
+			t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+		}
+		backup := newFS()
+		replicator, err := NewReplicator(primary, []BlobStorage{backup}, ReplicatorConfig{})
+		if err != nil {
+			t.Fatalf("NewReplicator failed: %v", err)
+		}
+
+		if err := replicator.Store(ctx, "a", []byte("alpha"), BlobMetadata{}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		if err := replicator.StoreStream(ctx, "b", strings.NewReader("beta"), BlobMetadata{}); err != nil {
+			t.Fatalf("StoreStream failed: %v", err)
+		}
+		if err := replicator.Store(ctx, "c/1", []byte("gamma"), BlobMetadata{}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		if got := keys(backup); got != "a,b,c/1" {
+			t.Errorf("Expected a,b,c/1 replicated, got %s", got)
+		}
+		blob, err := backup.Retrieve(ctx, "b")
+		if err != nil || string(blob.Data) != "beta" {
+			t.Errorf("Expected the streamed blob replicated, got %v, %v", blob, err)
+		}
+
+		if err := replicator.Delete(ctx, "a"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := replicator.DeletePrefix(ctx, "c/"); err != nil {
+			t.Fatalf("DeletePrefix failed: %v", err)
+		}
+		if got := keys(backup); got != "b" {
+			t.Errorf("Expected only b left on the backup, got %s", got)
+		}
+
+		// Diverge the backup behind the replicator's back
+		backup.Store(ctx, "stray", []byte("x"), BlobMetadata{})
+		backup.Store(ctx, "b", []byte("stale"), BlobMetadata{})
+		primary.Store(ctx, "missing", []byte("m"), BlobMetadata{})
+
+		report, err := replicator.Reconcile(ctx)
+		if err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+		if report.Copied != 2 || report.Deleted != 1 {
+			t.Errorf("Expected 2 copied and 1 deleted, got %+v", report)
+		}
+		if got := keys(backup); got != "b,missing" {
+			t.Errorf("Expected b,missing on the backup, got %s", got)
+		}
+		if blob, err := backup.Retrieve(ctx, "b"); err != nil || string(blob.Data) != "beta" {
+			t.Errorf("Expected b repaired, got %v, %v", blob, err)
+		}
+	})
+
+	t.Run("reconcile keeps unlisted blobs", func(t *testing.T) {
+		primary, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{TableName: "reconciled_blobs"})
+		if err != nil {
+			t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+		}
+		backup := newFS()
+		replicator, err := NewReplicator(primary, []BlobStorage{backup}, ReplicatorConfig{})
+		if err != nil {
+			t.Fatalf("NewReplicator failed: %v", err)
+		}
+		for _, key := range []string{"Z", "a", "legacy"} {
+			if err := replicator.Store(ctx, key, []byte("data of "+key), BlobMetadata{}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+		}
+
+		// Rows of earlier releases may hold NULL in nullable columns
+		if _, err := runtime.Exec(ctx, `UPDATE reconciled_blobs SET filename = NULL, created_at = NULL WHERE "key" = 'legacy'`); err != nil {
+			t.Fatalf("Failed to null columns: %v", err)
+		}
+		if got := keys(primary); got != "Z,a,legacy" {
+			t.Errorf("Expected the legacy row listed, got %s", got)
+		}
+		report, err := replicator.Reconcile(ctx)
+		if err != nil || report != (ReconcileReport{}) {
+			t.Errorf("Expected nothing to reconcile, got %+v, %v", report, err)
+		}
+
+		// A blob the primary holds but does not list is copied, not deleted
+		replicator, err = NewReplicator(unlistedStorage{primary, "legacy"}, []BlobStorage{backup}, ReplicatorConfig{})
+		if err != nil {
+			t.Fatalf("NewReplicator failed: %v", err)
+		}
+		report, err = replicator.Reconcile(ctx)
+		if err != nil || report.Deleted != 0 {
+			t.Errorf("Expected nothing deleted, got %+v, %v", report, err)
+		}
+		if got := keys(backup); got != "Z,a,legacy" {
+			t.Errorf("Expected the backup kept whole, got %s", got)
+		}
+
+		// Merging listings out of key order would pair up the wrong blobs
+		replicator, err = NewReplicator(reversedStorage{primary}, []BlobStorage{backup}, ReplicatorConfig{})
+		if err != nil {
+			t.Fatalf("NewReplicator failed: %v", err)
+		}
+		if _, err := replicator.Reconcile(ctx); err == nil || !strings.Contains(err.Error(), "out of key order") {
+			t.Errorf("Expected a listing out of key order to fail, got %v", err)
+		}
+		if got := keys(backup); got != "Z,a,legacy" {
+			t.Errorf("Expected the backup kept whole, got %s", got)
+		}
+	})
+
+	t.Run("rejected replications", func(t *testing.T) {
+		primary, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{TableName: "rejected_blobs"})
+		if err != nil {
+			t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+		}
+		backup := newFS()
+		replicator, err := NewReplicator(primary, []BlobStorage{backup}, ReplicatorConfig{Mode: ReplicationAsync, Queue: newFS()})
+		if err != nil {
+			t.Fatalf("NewReplicator failed: %v", err)
+		}
+
+		// The database holds keys the filesystem backup rejects
+		for _, key := range []string{"a", "report.meta", "b", "x/../y"} {
+			if err := replicator.Store(ctx, key, []byte("data of "+key), BlobMetadata{}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+		}
+		err = replicator.Drain(ctx)
+		if !errors.Is(err, ErrInvalidBlobKey) {
+			t.Errorf("Expected the rejected keys reported, got %v", err)
+		}
+		if got := keys(backup); got != "a,b" {
+			t.Errorf("Expected the keys after the rejected ones replicated, got %s", got)
+		}
+		if pending, _ := replicator.Pending(ctx); pending != 0 {
+			t.Errorf("Expected the queue drained, got %d pending", pending)
+		}
+		failed, err := replicator.FailedReplications(ctx)
+		if err != nil || len(failed) != 2 || failed[0].Key != "report.meta" || failed[1].Key != "x/../y" || failed[0].Error == "" {
+			t.Errorf("Expected the rejected keys set aside in order, got %+v, %v", failed, err)
+		}
+
+		if n, err := replicator.RetryFailed(ctx); err != nil || n != 2 {
+			t.Errorf("Expected 2 replications queued again, got %d, %v", n, err)
+		}
+		if pending, _ := replicator.Pending(ctx); pending != 2 {
+			t.Errorf("Expected 2 pending replications, got %d", pending)
+		}
+		if err := replicator.Drain(ctx); !errors.Is(err, ErrInvalidBlobKey) {
+			t.Errorf("Expected the keys rejected again, got %v", err)
+		}
+		if failed, _ := replicator.FailedReplications(ctx); len(failed) != 2 {
+			t.Errorf("Expected the keys set aside again, got %+v", failed)
+		}
+	})
+
+	t.Run("async", func(t *testing.T) {
+		if _, err := NewReplicator(newFS(), nil, ReplicatorConfig{Mode: ReplicationAsync}); err == nil {
+			t.Error("Expected async replication without a queue to be rejected")
+		}
+
+		primary, backup, queue := newFS(), newFS(), newFS()
+		replicator, err := NewReplicator(primary, []BlobStorage{backup}, ReplicatorConfig{Mode: ReplicationAsync, Queue: queue})
+		if err != nil {
+			t.Fatalf("NewReplicator failed: %v", err)
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			if err := replicator.Store(ctx, key, []byte("data of "+key), BlobMetadata{}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+		}
+		if _, err := replicator.DeleteBatch(ctx, []string{"b"}); err != nil {
+			t.Fatalf("DeleteBatch failed: %v", err)
+		}
+		if got := keys(backup); got != "" {
+			t.Errorf("Expected nothing replicated before draining, got %s", got)
+		}
+		if pending, _ := replicator.Pending(ctx); pending != 4 {
+			t.Errorf("Expected 4 pending replications, got %d", pending)
+		}
+
+		// The queue outlives the replicator that filled it
+		restarted, err := NewReplicator(primary, []BlobStorage{backup}, ReplicatorConfig{Mode: ReplicationAsync, Queue: queue})
+		if err != nil {
+			t.Fatalf("NewReplicator failed: %v", err)
+		}
+		if err := restarted.Drain(ctx); err != nil {
+			t.Fatalf("Drain failed: %v", err)
+		}
+		if got := keys(backup); got != "a,c" {
+			t.Errorf("Expected a,c replicated, got %s", got)
+		}
+		if pending, _ := restarted.Pending(ctx); pending != 0 {
+			t.Errorf("Expected the queue drained, got %d pending", pending)
+		}
+
+		restarted.Start(ctx)
+		defer restarted.Stop()
+		if err := restarted.Store(ctx, "d", []byte("delta"), BlobMetadata{}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for keys(backup) != "a,c,d" && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := keys(backup); got != "a,c,d" {
+			t.Errorf("Expected d replicated in the background, got %s", got)
+		}
+	})
+}
+
+// unlistedStorage leaves a blob out of the pages of a storage
+type unlistedStorage struct {
+	BlobStorage
+	hidden string
+}
+
+func (s unlistedStorage) ListPage(ctx context.Context, prefix string, limit int, token string, filters ...BlobFilter) (BlobPage, error) {
+	page, err := s.BlobStorage.ListPage(ctx, prefix, limit, token, filters...)
+	blobs := page.Blobs[:0]
+	for _, blob := range page.Blobs {
+		if blob.Key != s.hidden {
+			blobs = append(blobs, blob)
+		}
+	}
+	page.Blobs = blobs
+	return page, err
+}
+
+// reversedStorage lists the pages of a storage in reverse
+type reversedStorage struct{ BlobStorage }
+
+func (s reversedStorage) ListPage(ctx context.Context, prefix string, limit int, token string, filters ...BlobFilter) (BlobPage, error) {
+	page, err := s.BlobStorage.ListPage(ctx, prefix, limit, token, filters...)
+	for i, j := 0, len(page.Blobs)-1; i < j; i, j = i+1, j-1 {
+		page.Blobs[i], page.Blobs[j] = page.Blobs[j], page.Blobs[i]
+	}
+	return page, err
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Blob scrub monitor event types
+const (
+	MonitorEventBlobScrubClean   = "blob_scrub_clean"
+	MonitorEventBlobScrubCorrupt = "blob_scrub_corrupt"
+)
+
+// BlobQuarantinePrefix is the key prefix under which Scrub moves corrupt
+// blobs, keeping their recorded metadata for inspection. Scrub skips the
+// blobs under it, so it should not be used for other blobs.
+const BlobQuarantinePrefix = "quarantine/"
+
+// ScrubOptions configures a Scrub
+type ScrubOptions struct {
+	// Quarantine moves corrupt blobs under BlobQuarantinePrefix, instead of
+	// only reporting them
+	Quarantine bool
+	// FixMetadata writes the .meta file of a blob without one and removes
+	// .meta files without a blob. A .meta file not matching its blob is
+	// left as the evidence of corruption. The database backend has no
+	// metadata files to fix.
+	FixMetadata bool
+}
+
+// ScrubReport is the outcome of a Scrub
+type ScrubReport struct {
+	Scanned        int           `json:"scanned"`
+	Corrupt        []string      `json:"corrupt,omitempty"`    // keys whose data does not match their checksum
+	Unreadable     []string      `json:"unreadable,omitempty"` // keys that could not be read
+	Quarantined    int           `json:"quarantined"`
+	Unverified     int           `json:"unverified"`      // blobs without a recorded checksum
+	MetadataFixed  int           `json:"metadata_fixed"`  // .meta files written
+	OrphansRemoved int           `json:"orphans_removed"` // .meta files removed
+	Started        time.Time     `json:"started"`
+	Duration       time.Duration `json:"duration_ns"`
+}
+
+// BlobScrubTarget is a blob storage that can be scrubbed
+type BlobScrubTarget interface {
+	Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error)
+}
+
+// verifyBlob reads a blob through r and compares the MD5 of its data with
+// the recorded checksum, returning the computed checksum and size. A blob
+// without a recorded checksum is not corrupt.
+func verifyBlob(r io.ReadCloser, metadata BlobMetadata) (checksum string, size int64, corrupt bool, err error) {
+	defer r.Close()
+	sum := md5.New()
+	size, err = io.Copy(sum, r)
+	if err != nil {
+		return "", 0, false, err
+	}
+	checksum = fmt.Sprintf("%x", sum.Sum(nil))
+	return checksum, size, metadata.Checksum != "" && metadata.Checksum != checksum, nil
+}
+
+// Scrub re-reads every blob outside the quarantine, comparing its data with
+// its recorded checksum. A blob replaced while read is skipped, and one
+// failing to read is reported as unreadable rather than corrupt.
+func (dbs *DatabaseBlobStorage) Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error) {
+	report := ScrubReport{Started: time.Now()}
+	var errs []error
+
+	blobs := newBlobPager(dbs)
+	for {
+		info, ok, err := blobs.peek(ctx)
+		if err != nil {
+			return report, err
+		}
+		if !ok {
+			break
+		}
+		blobs.next()
+		if strings.HasPrefix(info.Key, BlobQuarantinePrefix) {
+			continue
+		}
+
+		r, metadata, err := dbs.RetrieveStream(ctx, info.Key)
+		var corrupt bool
+		if err == nil {
+			_, _, corrupt, err = verifyBlob(r, metadata)
+		}
+		if errors.Is(err, errBlobChanged) {
+			continue
+		}
+		report.Scanned++
+		switch {
+		case err != nil:
+			report.Unreadable = append(report.Unreadable, info.Key)
+		case metadata.Checksum == "":
+			report.Unverified++
+		case corrupt:
+			report.Corrupt = append(report.Corrupt, info.Key)
+			if opts.Quarantine {
+				if err := dbs.quarantine(ctx, info.Key); err != nil {
+					errs = append(errs, fmt.Errorf("failed to quarantine %s: %w", info.Key, err))
+					continue
+				}
+				report.Quarantined++
+			}
+		}
+	}
+	report.Duration = time.Since(report.Started)
+	return report, errors.Join(errs...)
+}
+
+// quarantine renames a blob and its chunks under BlobQuarantinePrefix,
+// replacing any blob quarantined before under the same key
+func (dbs *DatabaseBlobStorage) quarantine(ctx context.Context, key string) error {
+	d := dbs.runtime.Dialect()
+	p1, p2 := d.Placeholder(1), d.Placeholder(2)
+	keyColumn := dbs.keyColumn()
+	target := BlobQuarantinePrefix + key
+
+	tx, err := dbs.runtime.Begin(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{fmt.Sprintf("DELETE FROM %s WHERE %s = %s", dbs.table(), keyColumn, p1), []interface{}{target}},
+		{fmt.Sprintf("DELETE FROM %s WHERE blob_key = %s", dbs.chunkTable(), p1), []interface{}{target}},
+		{fmt.Sprintf("UPDATE %[1]s SET %[2]s = %[3]s WHERE %[2]s = %[4]s", dbs.table(), keyColumn, p1, p2), []interface{}{target, key}},
+		{fmt.Sprintf("UPDATE %s SET blob_key = %s WHERE blob_key = %s", dbs.chunkTable(), p1, p2), []interface{}{target, key}},
+	} {
+		if _, err := tx.Exec(ctx, stmt.query, stmt.args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Scrub re-reads every blob outside the quarantine, comparing its data with
+// the size and checksum recorded in its .meta file, and with FixMetadata set
+// repairs the .meta files. A blob disagreeing with its .meta file is
+// corrupt. A blob without one has nothing to verify; its .meta file is
+// written from the data as found, so a later Scrub can tell if it changes.
+func (fbs *FilesystemBlobStorage) Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error) {
+	report := ScrubReport{Started: time.Now()}
+	var errs []error
+
+	var blobPaths, orphans []string
+	err := filepath.Walk(fbs.rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return ignoreRemoved(err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if blobPath, ok := strings.CutSuffix(path, ".meta"); ok {
+			if _, err := os.Stat(blobPath); os.IsNotExist(err) {
+				orphans = append(orphans, path)
+			}
+			return nil
+		}
+		if isBlobFile(path, info) && !strings.HasPrefix(fbs.key(path), BlobQuarantinePrefix) {
+			blobPaths = append(blobPaths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, path := range orphans {
+		if !opts.FixMetadata {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			continue
+		}
+		report.OrphansRemoved++
+	}
+
+	for _, path := range blobPaths {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		key := fbs.key(path)
+
+		check, err := verifyFile(path)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, errBlobChanged) {
+			continue // deleted or rewritten since the walk
+		}
+		report.Scanned++
+		switch {
+		case err != nil:
+			report.Unreadable = append(report.Unreadable, key)
+		case check.corrupt:
+			report.Corrupt = append(report.Corrupt, key)
+			if opts.Quarantine {
+				if err := fbs.quarantine(key); err != nil {
+					errs = append(errs, fmt.Errorf("failed to quarantine %s: %w", key, err))
+					continue
+				}
+				report.Quarantined++
+			}
+		case check.metadata.Checksum == "":
+			report.Unverified++
+		case !check.hasMeta:
+			report.Unverified++
+			if opts.FixMetadata {
+				if err := writeMetadataFile(path, check.metadata); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				report.MetadataFixed++
+			}
+		}
+	}
+	report.Duration = time.Since(report.Started)
+	return report, errors.Join(errs...)
+}
+
+// fileCheck is the outcome of verifyFile
+type fileCheck struct {
+	// metadata is the recorded metadata of the blob or, without a .meta
+	// file, metadata describing the data as found
+	metadata BlobMetadata
+	hasMeta  bool
+	corrupt  bool
+}
+
+// verifyFile reads the blob at path and compares it with its .meta file. A
+// blob is corrupt when its stored size, its decoded size or its checksum
+// differs from the recorded ones, or it fails to decode with the recorded
+// compression. Both files are stat'ed before and after, and errBlobChanged
+// returned if either changed, so a write in progress is not mistaken for
+// corruption.
+func verifyFile(path string) (fileCheck, error) {
+	before, err := statBlobFiles(path)
+	if err != nil {
+		return fileCheck{}, err
+	}
+	stored, _, hasMeta := readMetadataFile(path)
+
+	if stored.Compression != "" {
+		if _, err := lookupBlobCompressor(stored.Compression); err != nil {
+			return fileCheck{}, err
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fileCheck{}, err
+	}
+	var checksum string
+	var size int64
+	r, readErr := decompressBlobReader(stored.Compression, f)
+	if readErr == nil {
+		checksum, size, _, readErr = verifyBlob(r, BlobMetadata{})
+	}
+
+	after, err := statBlobFiles(path)
+	if err != nil {
+		return fileCheck{}, err
+	}
+	if after != before {
+		return fileCheck{}, errBlobChanged
+	}
+
+	if !hasMeta {
+		if readErr != nil {
+			return fileCheck{}, readErr
+		}
+		return fileCheck{metadata: BlobMetadata{
+			Size:      size,
+			Checksum:  checksum,
+			CreatedAt: before.modTime,
+			UpdatedAt: before.modTime,
+		}}, nil
+	}
+
+	storedSize := stored.Size
+	if stored.Compression != "" {
+		storedSize = stored.CompressedSize
+	}
+	corrupt := readErr != nil || before.size != storedSize || size != stored.Size ||
+		(stored.Checksum != "" && checksum != stored.Checksum)
+	return fileCheck{metadata: stored, hasMeta: true, corrupt: corrupt}, nil
+}
+
+// blobFileStamp identifies the content of a blob and its .meta file
+type blobFileStamp struct {
+	size, metaSize       int64
+	modTime, metaModTime time.Time
+}
+
+// statBlobFiles stats the blob at path and its .meta file, if any
+func statBlobFiles(path string) (blobFileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return blobFileStamp{}, err
+	}
+	stamp := blobFileStamp{size: info.Size(), modTime: info.ModTime(), metaSize: -1}
+	if meta, err := os.Stat(path + ".meta"); err == nil {
+		stamp.metaSize, stamp.metaModTime = meta.Size(), meta.ModTime()
+	}
+	return stamp, nil
+}
+
+// quarantine moves a blob and its .meta file under BlobQuarantinePrefix.
+// The blob is moved first, so a crash in between leaves an orphaned .meta
+// file rather than the corrupt data with fresh metadata.
+func (fbs *FilesystemBlobStorage) quarantine(key string) error {
+	from, err := fbs.path(key)
+	if err != nil {
+		return err
+	}
+	to, err := fbs.path(BlobQuarantinePrefix + key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	os.Remove(to + ".meta")
+	if err := os.Rename(from+".meta", to+".meta"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// BlobScrubber runs Scrub on a storage on an interval and reports each run
+// to monitor callbacks, as blob_scrub_corrupt when corrupt or unreadable
+// blobs were found and blob_scrub_clean otherwise
+type BlobScrubber struct {
+	target    BlobScrubTarget
+	opts      ScrubOptions
+	interval  time.Duration
+	stopChan  chan struct{}
+	callbacks []MonitorCallback
+	last      *ScrubReport
+	mu        sync.RWMutex
+	wg        sync.WaitGroup
+	running   bool
+}
+
+// NewBlobScrubber creates a scrubber of target running every interval
+// (default 24h)
+func NewBlobScrubber(target BlobScrubTarget, opts ScrubOptions, interval time.Duration) *BlobScrubber {
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	return &BlobScrubber{
+		target:   target,
+		opts:     opts,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// AddCallback adds a callback called with an event after every run
+func (bs *BlobScrubber) AddCallback(callback MonitorCallback) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.callbacks = append(bs.callbacks, callback)
+}
+
+// Start starts scrubbing every interval
+func (bs *BlobScrubber) Start(ctx context.Context) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.running {
+		return
+	}
+	bs.running = true
+
+	bs.wg.Add(1)
+	go bs.scrubLoop(ctx)
+}
+
+// Stop stops the scrubber and waits for a running scrub to finish
+func (bs *BlobScrubber) Stop() {
+	bs.mu.Lock()
+	if !bs.running {
+		bs.mu.Unlock()
+		return
+	}
+	close(bs.stopChan)
+	bs.running = false
+	bs.mu.Unlock()
+
+	bs.wg.Wait()
+}
+
+func (bs *BlobScrubber) scrubLoop(ctx context.Context) {
+	defer bs.wg.Done()
+
+	ticker := time.NewTicker(bs.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			bs.RunOnce(ctx)
+		case <-bs.stopChan:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunOnce scrubs the storage now and notifies the callbacks
+func (bs *BlobScrubber) RunOnce(ctx context.Context) (ScrubReport, error) {
+	report, err := bs.target.Scrub(ctx, bs.opts)
+
+	bs.mu.Lock()
+	last := report
+	bs.last = &last
+	callbacks := bs.callbacks
+	bs.mu.Unlock()
+
+	event := MonitorEvent{
+		Type:      MonitorEventBlobScrubClean,
+		Timestamp: time.Now(),
+		Scrub:     &report,
+		Message:   fmt.Sprintf("Blob scrub checked %d blobs in %v", report.Scanned, report.Duration),
+	}
+	if len(report.Corrupt) > 0 || len(report.Unreadable) > 0 {
+		event.Type = MonitorEventBlobScrubCorrupt
+		event.Message = fmt.Sprintf("Blob scrub found %d corrupt and %d unreadable of %d blobs",
+			len(report.Corrupt), len(report.Unreadable), report.Scanned)
+	}
+	if err != nil {
+		event.Message += fmt.Sprintf(" (errors: %v)", err)
+	}
+	for _, callback := range callbacks {
+		callback(event)
+	}
+	return report, err
+}
+
+// LastReport returns the report of the last run, if any
+func (bs *BlobScrubber) LastReport() (ScrubReport, bool) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	if bs.last == nil {
+		return ScrubReport{}, false
+	}
+	return *bs.last, true
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobScrub(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("filesystem", func(t *testing.T) {
+		root := t.TempDir()
+		storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: root})
+		if err != nil {
+			t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			if err := storage.Store(ctx, key, []byte("data of "+key), BlobMetadata{ContentType: "text/plain"}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+		}
+		// a rots in place, b loses its metadata, and a .meta file outlives
+		// its blob
+		if err := os.WriteFile(filepath.Join(root, "a"), []byte("data of x"), 0644); err != nil {
+			t.Fatalf("Failed to corrupt blob: %v", err)
+		}
+		os.Remove(filepath.Join(root, "b.meta"))
+		if err := os.WriteFile(filepath.Join(root, "gone.meta"), []byte("{}"), 0644); err != nil {
+			t.Fatalf("Failed to write orphan: %v", err)
+		}
+
+		report, err := storage.Scrub(ctx, ScrubOptions{})
+		if err != nil {
+			t.Fatalf("Scrub failed: %v", err)
+		}
+		if report.Scanned != 3 || strings.Join(report.Corrupt, ",") != "a" || report.Unverified != 1 ||
+			report.Quarantined != 0 || report.MetadataFixed != 0 || report.OrphansRemoved != 0 {
+			t.Errorf("Expected a corrupt and b unverified, nothing fixed, got %+v", report)
+		}
+
+		report, err = storage.Scrub(ctx, ScrubOptions{Quarantine: true, FixMetadata: true})
+		if err != nil {
+			t.Fatalf("Scrub failed: %v", err)
+		}
+		if report.Quarantined != 1 || report.MetadataFixed != 1 || report.OrphansRemoved != 1 {
+			t.Errorf("Expected a quarantined, b fixed and the orphan removed, got %+v", report)
+		}
+		if exists, _ := storage.Exists(ctx, "a"); exists {
+			t.Error("Expected a moved out of place")
+		}
+		if _, metadata, err := storage.RetrieveStream(ctx, BlobQuarantinePrefix+"a"); err != nil || metadata.ContentType != "text/plain" {
+			t.Errorf("Expected a quarantined with its metadata, got %+v, %v", metadata, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "gone.meta")); !os.IsNotExist(err) {
+			t.Errorf("Expected the orphaned .meta removed, got %v", err)
+		}
+
+		report, err = storage.Scrub(ctx, ScrubOptions{Quarantine: true, FixMetadata: true})
+		if err != nil {
+			t.Fatalf("Scrub failed: %v", err)
+		}
+		if report.Scanned != 2 || len(report.Corrupt) != 0 || report.Unverified != 0 {
+			t.Errorf("Expected the store clean, got %+v", report)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		for _, compression := range []bool{false, true} {
+			root := t.TempDir()
+			storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: root, Compression: compression})
+			if err != nil {
+				t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+			}
+			data := []byte(strings.Repeat("truncated blob data ", 100))
+			if err := storage.Store(ctx, "t", data, BlobMetadata{}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			stored, err := os.ReadFile(filepath.Join(root, "t"))
+			if err != nil {
+				t.Fatalf("Failed to read blob: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(root, "t"), stored[:len(stored)/2], 0644); err != nil {
+				t.Fatalf("Failed to truncate blob: %v", err)
+			}
+
+			// The .meta file no longer matches, which is corruption to
+			// report rather than metadata to fix
+			for i := 0; i < 2; i++ {
+				report, err := storage.Scrub(ctx, ScrubOptions{FixMetadata: true})
+				if err != nil {
+					t.Fatalf("Scrub failed: %v", err)
+				}
+				if strings.Join(report.Corrupt, ",") != "t" || report.Unverified != 0 || report.MetadataFixed != 0 {
+					t.Errorf("Expected the truncated blob corrupt (compression %v), got %+v", compression, report)
+				}
+			}
+			report, err := storage.Scrub(ctx, ScrubOptions{Quarantine: true})
+			if err != nil {
+				t.Fatalf("Scrub failed: %v", err)
+			}
+			if report.Quarantined != 1 {
+				t.Errorf("Expected the truncated blob quarantined (compression %v), got %+v", compression, report)
+			}
+		}
+	})
+
+	t.Run("database", func(t *testing.T) {
+		runtime := NewDBRuntime(NewConfigBuilder().
+			WithDatabaseType(DatabaseTypeSQLite).
+			WithDSN("file:blob_scrub?mode=memory&cache=shared").
+			Build())
+		if err := runtime.Connect(); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer runtime.Disconnect()
+
+		storage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{ChunkSize: 4})
+		if err != nil {
+			t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+		}
+		for _, key := range []string{"a", "b"} {
+			if err := storage.Store(ctx, key, []byte("chunked data of "+key), BlobMetadata{}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+		}
+		if _, err := runtime.Exec(ctx, "UPDATE "+storage.chunkTable()+" SET data = ? WHERE blob_key = ? AND seq = 1", []byte("XXXX"), "b"); err != nil {
+			t.Fatalf("Failed to corrupt chunk: %v", err)
+		}
+
+		scrubber := NewBlobScrubber(storage, ScrubOptions{Quarantine: true}, time.Hour)
+		var events []MonitorEvent
+		scrubber.AddCallback(func(event MonitorEvent) { events = append(events, event) })
+		report, err := scrubber.RunOnce(ctx)
+		if err != nil {
+			t.Fatalf("RunOnce failed: %v", err)
+		}
+		if report.Scanned != 2 || strings.Join(report.Corrupt, ",") != "b" || report.Quarantined != 1 {
+			t.Errorf("Expected b corrupt and quarantined, got %+v", report)
+		}
+		if len(events) != 1 || events[0].Type != MonitorEventBlobScrubCorrupt || events[0].Scrub == nil {
+			t.Errorf("Expected a corrupt scrub event, got %+v", events)
+		}
+		if last, ok := scrubber.LastReport(); !ok || last.Quarantined != 1 {
+			t.Errorf("Expected the last report kept, got %+v", last)
+		}
+		if exists, _ := storage.Exists(ctx, BlobQuarantinePrefix+"b"); !exists {
+			t.Error("Expected b under the quarantine prefix")
+		}
+
+		report, err = scrubber.RunOnce(ctx)
+		if err != nil {
+			t.Fatalf("RunOnce failed: %v", err)
+		}
+		if report.Scanned != 1 || len(report.Corrupt) != 0 || events[1].Type != MonitorEventBlobScrubClean {
+			t.Errorf("Expected a clean scrub, got %+v", report)
+		}
+	})
+}
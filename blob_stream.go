@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// contextReader fails reads once its context is done, so a copy from a slow
+// or endless source stops when the caller gives up
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// copyBlob copies a blob of at most maxSize bytes from r to w, compressed
+// with c unless nil, and returns its size and checksum
+func copyBlob(ctx context.Context, w io.Writer, r io.Reader, c BlobCompressor, maxSize int64) (int64, string, error) {
+	var zw io.WriteCloser
+	if c != nil {
+		var err error
+		if zw, err = c.NewWriter(w); err != nil {
+			return 0, "", fmt.Errorf("failed to compress blob: %w", err)
+		}
+		w = zw
+	}
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), io.LimitReader(contextReader{ctx, r}, maxSize+1))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if size > maxSize {
+		return 0, "", fmt.Errorf("blob size exceeds maximum %d", maxSize)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return 0, "", fmt.Errorf("failed to compress blob: %w", err)
+		}
+	}
+	return size, fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// StoreStream stores a blob read from r, chunk by chunk once it outgrows a
+// chunk, so at most a chunk is held in memory. With compression on, the
+// blob is compressed as it is read, even if it does not shrink.
+func (dbs *DatabaseBlobStorage) StoreStream(ctx context.Context, key string, r io.Reader, metadata BlobMetadata) error {
+	return dbs.write(ctx, key, func(w io.Writer) (BlobMetadata, error) {
+		size, checksum, err := copyBlob(ctx, w, r, dbs.compressor, dbs.maxSize)
+		if err != nil {
+			return metadata, err
+		}
+		if metadata.Checksum != "" && metadata.Checksum != checksum {
+			return metadata, fmt.Errorf("checksum mismatch: expected %s, got %s", metadata.Checksum, checksum)
+		}
+		metadata.Checksum = checksum
+		metadata.Size = size
+		metadata.Compression = ""
+		if dbs.compressor != nil {
+			metadata.Compression = dbs.compressor.Name()
+		}
+		return metadata, nil
+	})
+}
+
+// RetrieveStream returns a reader over a blob, which reads chunked blobs a
+// chunk at a time
+func (dbs *DatabaseBlobStorage) RetrieveStream(ctx context.Context, key string) (io.ReadCloser, BlobMetadata, error) {
+	row, err := dbs.retrieveRow(ctx, key)
+	if err != nil {
+		return nil, BlobMetadata{}, err
+	}
+	var r io.Reader = bytes.NewReader(row.data)
+	if row.chunks > 0 {
+		r = dbs.newChunkReader(ctx, key, row)
+	}
+	rc, err := decompressBlobReader(row.metadata.Compression, io.NopCloser(r))
+	if err != nil {
+		return nil, BlobMetadata{}, err
+	}
+	return rc, row.metadata, nil
+}
+
+// StoreStream copies a blob from r to a temporary file next to its final
+// path, renamed into place once complete and verified, so readers never see
+// a partial blob. With compression on, the blob is compressed as it is
+// copied, even if it does not shrink.
+func (fbs *FilesystemBlobStorage) StoreStream(ctx context.Context, key string, r io.Reader, metadata BlobMetadata) error {
+	filePath, err := fbs.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, blobTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	size, checksum, err := copyBlob(ctx, tmp, r, fbs.compressor, fbs.maxSize)
+	if err == nil {
+		if syncErr := tmp.Sync(); syncErr != nil {
+			err = fmt.Errorf("failed to write blob: %w", syncErr)
+		}
+	}
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write blob: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	if metadata.Checksum != "" && metadata.Checksum != checksum {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", metadata.Checksum, checksum)
+	}
+	if err := checkQuotas(fbs.quotas, key, size, fbs.prefixUsage(ctx)); err != nil {
+		return err
+	}
+
+	metadata.Compression, metadata.CompressedSize = "", 0
+	if fbs.compressor != nil {
+		metadata.Compression = fbs.compressor.Name()
+		info, err := os.Stat(tmp.Name())
+		if err != nil {
+			return fmt.Errorf("failed to write blob: %w", err)
+		}
+		metadata.CompressedSize = info.Size()
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	return fbs.writeMetadata(filePath, metadata, size, checksum)
+}
+
+// RetrieveStream opens a blob for reading, decompressing it if stored
+// compressed. The checksum is the one recorded when the blob was stored,
+// not recomputed, since that would read the whole blob.
+func (fbs *FilesystemBlobStorage) RetrieveStream(ctx context.Context, key string) (io.ReadCloser, BlobMetadata, error) {
+	filePath, err := fbs.path(key)
+	if err != nil {
+		return nil, BlobMetadata{}, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, BlobMetadata{}, fmt.Errorf("blob not found: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, BlobMetadata{}, fmt.Errorf("blob not found: %w", err)
+	}
+	metadata := fbs.readMetadata(filePath, info)
+	r, err := decompressBlobReader(metadata.Compression, f)
+	if err != nil {
+		return nil, BlobMetadata{}, err
+	}
+	return r, metadata, nil
+}
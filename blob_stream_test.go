@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBlobStreaming(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_streaming?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	dbStorage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{MaxSize: 1024})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	fsStorage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir(), MaxSize: 1024})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+
+	ctx := context.Background()
+	data := strings.Repeat("streamed ", 100)
+	for name, storage := range map[string]BlobStorage{"database": dbStorage, "filesystem": fsStorage} {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.StoreStream(ctx, "docs/a.txt", strings.NewReader(data), BlobMetadata{ContentType: "text/plain"}); err != nil {
+				t.Fatalf("StoreStream failed: %v", err)
+			}
+			r, metadata, err := storage.RetrieveStream(ctx, "docs/a.txt")
+			if err != nil {
+				t.Fatalf("RetrieveStream failed: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(got) != data {
+				t.Fatalf("Expected the stored data back, got %d bytes (%v)", len(got), err)
+			}
+			if metadata.Size != int64(len(data)) {
+				t.Errorf("Expected size %d, got %d", len(data), metadata.Size)
+			}
+
+			if err := storage.StoreStream(ctx, "big", strings.NewReader(strings.Repeat("x", 1025)), BlobMetadata{}); err == nil {
+				t.Error("Expected a blob over MaxSize to be rejected")
+			}
+			if err := storage.StoreStream(ctx, "bad", strings.NewReader(data), BlobMetadata{Checksum: "0123"}); err == nil {
+				t.Error("Expected a checksum mismatch to be rejected")
+			}
+			for _, key := range []string{"big", "bad"} {
+				if exists, _ := storage.Exists(ctx, key); exists {
+					t.Errorf("Expected rejected blob %q not to be stored", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilesystemBlobAtomicWrites(t *testing.T) {
+	root := t.TempDir()
+	storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: root})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "docs/a.txt", []byte("hello"), BlobMetadata{Filename: `say "hi".txt`, Tags: map[string]string{"k": "v"}}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	meta, err := os.ReadFile(filepath.Join(root, "docs", "a.txt.meta"))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	var stored BlobMetadata
+	if err := json.Unmarshal(meta, &stored); err != nil {
+		t.Fatalf("Expected valid metadata JSON, got %s: %v", meta, err)
+	}
+	if stored.Filename != `say "hi".txt` || stored.Size != 5 || stored.Tags["k"] != "v" {
+		t.Errorf("Expected the metadata stored, got %+v", stored)
+	}
+
+	// Writes in progress are neither listed nor counted
+	if err := os.WriteFile(filepath.Join(root, "docs", blobTempPrefix+"123"), []byte("partial"), 0644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if infos, err := storage.List(ctx, ""); err != nil || len(infos) != 1 {
+		t.Errorf("Expected 1 listed blob, got %d (%v)", len(infos), err)
+	}
+	if stats, err := storage.Stats(ctx); err != nil || stats.TotalBlobs != 1 {
+		t.Errorf("Expected 1 blob counted, got %+v (%v)", stats, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "docs"))
+	if len(entries) != 3 {
+		t.Errorf("Expected the blob, its metadata and the stray temp file, got %d entries", len(entries))
+	}
+}
+
+func TestFilesystemBlobInterruptedRewrite(t *testing.T) {
+	root := t.TempDir()
+	storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: root})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "a.txt", []byte("hello"), BlobMetadata{Filename: "a.txt"}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	infos, err := storage.List(ctx, "")
+	if err != nil || len(infos) != 1 || infos[0].Metadata.Checksum == "" {
+		t.Fatalf("Expected the stored checksum listed, got %+v (%v)", infos, err)
+	}
+
+	// A rewrite of the same size that stops before its .meta file is
+	// renamed into place leaves the old checksum behind
+	path := filepath.Join(root, "a.txt")
+	if err := writeFileAtomic(path, []byte("world")); err != nil {
+		t.Fatalf("Failed to rewrite blob: %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("Failed to date blob: %v", err)
+	}
+
+	infos, err = storage.List(ctx, "")
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("Expected 1 listed blob, got %d (%v)", len(infos), err)
+	}
+	if got := infos[0].Metadata; got.Checksum != "" || got.Size != 5 || got.Filename != "a.txt" {
+		t.Errorf("Expected the stale checksum dropped and the rest kept, got %+v", got)
+	}
+}
+
+func TestFilesystemBlobUnreadableDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("directory permissions do not apply to root")
+	}
+	root := t.TempDir()
+	storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: root})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"a.txt", "locked/b.txt"} {
+		if err := storage.Store(ctx, key, []byte("data"), BlobMetadata{}); err != nil {
+			t.Fatalf("Store %s failed: %v", key, err)
+		}
+	}
+	locked := filepath.Join(root, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatalf("Failed to lock directory: %v", err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	// Leaving out the blobs of a directory that cannot be read would
+	// understate listings, stats and quota usage without a word
+	if _, err := storage.List(ctx, ""); err == nil {
+		t.Error("Expected List to fail on an unreadable directory")
+	}
+	if _, err := storage.Stats(ctx); err == nil {
+		t.Error("Expected Stats to fail on an unreadable directory")
+	}
+	if _, err := storage.ListPage(ctx, "", 10, ""); err == nil {
+		t.Error("Expected ListPage to fail on an unreadable directory")
+	}
+	if _, err := storage.DeletePrefix(ctx, "a"); err == nil {
+		t.Error("Expected DeletePrefix to fail on an unreadable directory")
+	}
+}
+
+func TestDatabaseBlobTagsAndTableName(t *testing.T) {
+	ctx := context.Background()
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_tags?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	for _, name := range []string{"blobs; DROP TABLE x", "my-blobs", `a"b`, "1blobs"} {
+		if _, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{TableName: name}); err == nil {
+			t.Errorf("Expected table name %q rejected", name)
+		}
+	}
+
+	storage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{TableName: "Tagged_Blobs"})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	tags := map[string]string{"quote": `say "hi"`, "list": "a,b:c", `{odd}`: `back\slash`}
+	if err := storage.Store(ctx, "tagged", []byte("data"), BlobMetadata{Tags: tags}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	blob, err := storage.Retrieve(ctx, "tagged")
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if fmt.Sprint(blob.Metadata.Tags) != fmt.Sprint(tags) {
+		t.Errorf("Expected tags %v, got %v", tags, blob.Metadata.Tags)
+	}
+	infos, err := storage.List(ctx, "", BlobFilter{Tags: map[string]string{"list": "a,b:c"}})
+	if err != nil || len(infos) != 1 || fmt.Sprint(infos[0].Metadata.Tags) != fmt.Sprint(tags) {
+		t.Errorf("Expected the blob listed by tag with its tags, got %+v, %v", infos, err)
+	}
+
+	// Rows written before tags were JSON encoded are still read
+	if _, err := runtime.Exec(ctx, `UPDATE "Tagged_Blobs" SET tags = '{"env":"pr"od"}' WHERE "key" = 'tagged'`); err != nil {
+		t.Fatalf("Failed to write legacy tags: %v", err)
+	}
+	blob, err = storage.Retrieve(ctx, "tagged")
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if blob.Metadata.Tags["env"] != `pr"od` {
+		t.Errorf("Expected the legacy tags parsed, got %v", blob.Metadata.Tags)
+	}
+
+	if err := storage.Store(ctx, "untagged", []byte("data"), BlobMetadata{}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if blob, err := storage.Retrieve(ctx, "untagged"); err != nil || len(blob.Metadata.Tags) != 0 {
+		t.Errorf("Expected no tags, got %+v, %v", blob, err)
+	}
+}
+
+// numberedDialect is SQLite with PostgreSQL-style numbered placeholders,
+// which SQLite binds by position too
+type numberedDialect struct{ sqliteDialect }
+
+func (numberedDialect) Type() DatabaseType       { return "sqlite_numbered" }
+func (numberedDialect) Placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+func TestDatabaseBlobStorageNumberedPlaceholders(t *testing.T) {
+	ctx := context.Background()
+	if err := RegisterDriver("sqlite_numbered", DriverInfo{DriverName: "sqlite3", Dialect: numberedDialect{}}); err != nil {
+		t.Fatalf("RegisterDriver failed: %v", err)
+	}
+	var queries []string
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType("sqlite_numbered").
+		WithDSN("file:blob_numbered?mode=memory&cache=shared").
+		WithQueryLogging(QueryLogConfig{
+			Mode:   QueryLogAll,
+			Output: func(e QueryLogEntry) { queries = append(queries, e.Query) },
+		}).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	storage, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{ChunkSize: 4, Quotas: []BlobQuota{{Prefix: "docs/", MaxObjects: 10}}})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	for _, key := range []string{"docs/a", "docs/b", "other"} {
+		if err := storage.Store(ctx, key, []byte("chunked data of "+key), BlobMetadata{Tags: map[string]string{"key": key}}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+	// Replacing a blob upserts its row
+	if err := storage.Store(ctx, "docs/a", []byte("replaced"), BlobMetadata{}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if blob, err := storage.Retrieve(ctx, "docs/a"); err != nil || string(blob.Data) != "replaced" {
+		t.Errorf("Expected the replaced blob, got %+v, %v", blob, err)
+	}
+	if blob, err := storage.Retrieve(ctx, "docs/b"); err != nil || string(blob.Data) != "chunked data of docs/b" {
+		t.Errorf("Expected the chunked blob, got %+v, %v", blob, err)
+	}
+	if exists, err := storage.Exists(ctx, "other"); err != nil || !exists {
+		t.Errorf("Expected other to exist, got %v, %v", exists, err)
+	}
+	infos, err := storage.List(ctx, "docs/", BlobFilter{Tags: map[string]string{"key": "docs/b"}})
+	if err != nil || len(infos) != 1 || infos[0].Key != "docs/b" {
+		t.Errorf("Expected docs/b listed, got %+v, %v", infos, err)
+	}
+	if page, err := storage.ListPage(ctx, "", 2, ""); err != nil || len(page.Blobs) != 2 || page.NextToken == "" {
+		t.Errorf("Expected a first page of 2, got %+v, %v", page, err)
+	}
+	if err := storage.Delete(ctx, "other"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if results, err := storage.DeleteBatch(ctx, []string{"docs/b", "missing"}); err != nil || !results[0].Deleted || results[1].Deleted {
+		t.Errorf("Expected docs/b deleted by the batch, got %+v, %v", results, err)
+	}
+	if n, err := storage.DeletePrefix(ctx, "docs/"); err != nil || n != 1 {
+		t.Errorf("Expected docs/a deleted by prefix, got %d, %v", n, err)
+	}
+
+	// SQLite would also bind a bare ?, which PostgreSQL rejects
+	for _, query := range queries {
+		if strings.Contains(query, "?") {
+			t.Errorf("Expected only dialect placeholders, got %q", query)
+		}
+	}
+	if len(queries) == 0 {
+		t.Error("Expected the blob statements logged")
+	}
+}
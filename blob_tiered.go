@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TieringConfig configures a TieredBlobStorage
+type TieringConfig struct {
+	// MaxHotSize is the largest blob kept in the hot tier; larger blobs are
+	// stored in the cold tier (default 1MB)
+	MaxHotSize int64
+	// MaxHotAge is how long after its last update Migrate demotes a blob
+	// read fewer than MinHotAccesses times since the previous Migrate
+	// (default 30 days)
+	MaxHotAge time.Duration
+	// MinHotAccesses is how many reads keep a blob older than MaxHotAge in
+	// the hot tier (default 1)
+	MinHotAccesses int64
+	// PromoteAccesses is how many reads since the last Migrate move a cold
+	// blob back to the hot tier, if it fits (0 never promotes)
+	PromoteAccesses int64
+	// Interval is how often Start runs Migrate (default 1h)
+	Interval time.Duration
+}
+
+// TieringReport counts the blobs moved by a Migrate
+type TieringReport struct {
+	Demoted  int `json:"demoted"`  // moved to the cold tier
+	Promoted int `json:"promoted"` // moved to the hot tier
+}
+
+// TieredBlobStorage keeps hot and small blobs in one BlobStorage, typically
+// a DatabaseBlobStorage, and cold or large ones in another, such as a
+// FilesystemBlobStorage. Store picks the tier by size; Migrate moves blobs
+// between tiers by age and by the reads counted since the previous
+// Migrate. Reads and listings resolve keys across both tiers.
+//
+// Read counts are kept in memory, so after a restart blobs are judged by
+// age until reads are counted again. A blob is moved by copying it before
+// deleting the original, with writes to the storage held meanwhile, so it
+// is readable throughout.
+type TieredBlobStorage struct {
+	hot    BlobStorage
+	cold   BlobStorage
+	config TieringConfig
+
+	writes   sync.RWMutex // held exclusively while a blob is moved
+	accessMu sync.Mutex
+	accesses map[string]int64 // reads by key since the last Migrate
+
+	stopChan chan struct{}
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	running  bool
+}
+
+// NewTieredBlobStorage creates a storage tiering blobs between hot and cold
+func NewTieredBlobStorage(hot, cold BlobStorage, config TieringConfig) *TieredBlobStorage {
+	if config.MaxHotSize <= 0 {
+		config.MaxHotSize = 1024 * 1024
+	}
+	if config.MaxHotAge <= 0 {
+		config.MaxHotAge = 30 * 24 * time.Hour
+	}
+	if config.MinHotAccesses <= 0 {
+		config.MinHotAccesses = 1
+	}
+	if config.Interval <= 0 {
+		config.Interval = time.Hour
+	}
+	return &TieredBlobStorage{
+		hot:      hot,
+		cold:     cold,
+		config:   config,
+		accesses: make(map[string]int64),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start runs Migrate every interval in the background
+func (s *TieredBlobStorage) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return
+	}
+	s.running = true
+
+	s.wg.Add(1)
+	go s.migrateLoop(ctx)
+}
+
+// Stop stops migrating and waits for the running Migrate to finish
+func (s *TieredBlobStorage) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	close(s.stopChan)
+	s.running = false
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *TieredBlobStorage) migrateLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Migrate(ctx)
+		case <-s.stopChan:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Store stores a blob in the tier its size belongs to, and removes any
+// older version from the other
+func (s *TieredBlobStorage) Store(ctx context.Context, key string, data []byte, metadata BlobMetadata) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if int64(len(data)) <= s.config.MaxHotSize {
+		return s.store(ctx, s.hot, s.cold, key, func(tier BlobStorage) error {
+			return tier.Store(ctx, key, data, metadata)
+		})
+	}
+	return s.store(ctx, s.cold, s.hot, key, func(tier BlobStorage) error {
+		return tier.Store(ctx, key, data, metadata)
+	})
+}
+
+// StoreStream stores a blob read from r in the tier its size belongs to.
+// Up to MaxHotSize bytes are buffered to tell small blobs apart; larger
+// ones are streamed to the cold tier.
+func (s *TieredBlobStorage) StoreStream(ctx context.Context, key string, r io.Reader, metadata BlobMetadata) error {
+	head, err := io.ReadAll(io.LimitReader(contextReader{ctx, r}, s.config.MaxHotSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if int64(len(head)) <= s.config.MaxHotSize {
+		return s.store(ctx, s.hot, s.cold, key, func(tier BlobStorage) error {
+			return tier.Store(ctx, key, head, metadata)
+		})
+	}
+	return s.store(ctx, s.cold, s.hot, key, func(tier BlobStorage) error {
+		return tier.StoreStream(ctx, key, io.MultiReader(bytes.NewReader(head), r), metadata)
+	})
+}
+
+// store writes a blob to tier with write, then removes it from other
+func (s *TieredBlobStorage) store(ctx context.Context, tier, other BlobStorage, key string, write func(BlobStorage) error) error {
+	if err := write(tier); err != nil {
+		return err
+	}
+	return deleteIfExists(ctx, other, key)
+}
+
+// Retrieve retrieves a blob from the tier holding it
+func (s *TieredBlobStorage) Retrieve(ctx context.Context, key string) (*BlobData, error) {
+	blob, err := s.hot.Retrieve(ctx, key)
+	if err != nil {
+		if exists, existsErr := s.cold.Exists(ctx, key); existsErr == nil && exists {
+			blob, err = s.cold.Retrieve(ctx, key)
+		}
+	}
+	if err == nil {
+		s.access(key)
+	}
+	return blob, err
+}
+
+// RetrieveStream returns a reader over a blob of the tier holding it
+func (s *TieredBlobStorage) RetrieveStream(ctx context.Context, key string) (io.ReadCloser, BlobMetadata, error) {
+	r, metadata, err := s.hot.RetrieveStream(ctx, key)
+	if err != nil {
+		if exists, existsErr := s.cold.Exists(ctx, key); existsErr == nil && exists {
+			r, metadata, err = s.cold.RetrieveStream(ctx, key)
+		}
+	}
+	if err == nil {
+		s.access(key)
+	}
+	return r, metadata, err
+}
+
+// Delete deletes a blob from both tiers
+func (s *TieredBlobStorage) Delete(ctx context.Context, key string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	hot, err := s.hot.Exists(ctx, key)
+	if err != nil {
+		return err
+	}
+	cold, err := s.cold.Exists(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !hot && !cold {
+		return fmt.Errorf("blob not found: %s", key)
+	}
+	if hot {
+		if err := s.hot.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	if cold {
+		return s.cold.Delete(ctx, key)
+	}
+	return nil
+}
+
+// DeletePrefix deletes the blobs under prefix from both tiers
+func (s *TieredBlobStorage) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	hot, err := s.hot.DeletePrefix(ctx, prefix)
+	if err != nil {
+		return hot, err
+	}
+	cold, err := s.cold.DeletePrefix(ctx, prefix)
+	return hot + cold, err
+}
+
+// DeleteBatch deletes keys from both tiers. A key is reported deleted if
+// either tier held it.
+func (s *TieredBlobStorage) DeleteBatch(ctx context.Context, keys []string) ([]BlobDeleteResult, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	results, err := s.hot.DeleteBatch(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	cold, err := s.cold.DeleteBatch(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Deleted = results[i].Deleted || cold[i].Deleted
+		if results[i].Err == nil {
+			results[i].Err = cold[i].Err
+		}
+	}
+	return results, nil
+}
+
+// Exists checks if either tier holds a blob
+func (s *TieredBlobStorage) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := s.hot.Exists(ctx, key)
+	if err != nil || exists {
+		return exists, err
+	}
+	return s.cold.Exists(ctx, key)
+}
+
+// List lists the blobs of both tiers in key order
+func (s *TieredBlobStorage) List(ctx context.Context, prefix string, filters ...BlobFilter) ([]BlobInfo, error) {
+	hot, err := s.hot.List(ctx, prefix, filters...)
+	if err != nil {
+		return nil, err
+	}
+	cold, err := s.cold.List(ctx, prefix, filters...)
+	if err != nil {
+		return nil, err
+	}
+	return mergeTiers(hot, cold), nil
+}
+
+// ListPage returns a page of the blobs of both tiers, merging a page of
+// each
+func (s *TieredBlobStorage) ListPage(ctx context.Context, prefix string, limit int, token string, filters ...BlobFilter) (BlobPage, error) {
+	if _, err := decodePageToken(token); err != nil {
+		return BlobPage{}, err
+	}
+	limit = pageLimit(limit)
+	hot, err := s.hot.ListPage(ctx, prefix, limit+1, token, filters...)
+	if err != nil {
+		return BlobPage{}, err
+	}
+	cold, err := s.cold.ListPage(ctx, prefix, limit+1, token, filters...)
+	if err != nil {
+		return BlobPage{}, err
+	}
+	infos := mergeTiers(hot.Blobs, cold.Blobs)
+	if len(infos) > limit+1 {
+		infos = infos[:limit+1]
+	}
+	return newBlobPage(infos, limit), nil
+}
+
+// ListWithOptions lists the blobs of both tiers. All the blobs under prefix
+// passing the filters are read before being ordered.
+func (s *TieredBlobStorage) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) ([]BlobInfo, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	infos, err := s.List(ctx, prefix, opts.Filters...)
+	if err != nil {
+		return nil, err
+	}
+	return opts.apply(infos), nil
+}
+
+// Stats returns the combined statistics of both tiers
+func (s *TieredBlobStorage) Stats(ctx context.Context) (BlobStats, error) {
+	hot, err := s.hot.Stats(ctx)
+	if err != nil {
+		return BlobStats{}, err
+	}
+	cold, err := s.cold.Stats(ctx)
+	if err != nil {
+		return BlobStats{}, err
+	}
+	return BlobStats{
+		TotalBlobs: hot.TotalBlobs + cold.TotalBlobs,
+		TotalSize:  hot.TotalSize + cold.TotalSize,
+		UsedSpace:  hot.UsedSpace + cold.UsedSpace,
+	}, nil
+}
+
+// Migrate demotes the hot blobs that are too large, or too old and read too
+// rarely, and promotes the cold blobs read often enough, then starts
+// counting reads afresh. It goes on past a blob it fails to move,
+// returning the failures together.
+func (s *TieredBlobStorage) Migrate(ctx context.Context) (TieringReport, error) {
+	s.accessMu.Lock()
+	accesses := s.accesses
+	s.accesses = make(map[string]int64)
+	s.accessMu.Unlock()
+
+	var report TieringReport
+	var errs []error
+	cutoff := time.Now().Add(-s.config.MaxHotAge)
+
+	hot := newBlobPager(s.hot)
+	for {
+		info, ok, err := hot.peek(ctx)
+		if err != nil {
+			return report, errors.Join(append(errs, err)...)
+		}
+		if !ok {
+			break
+		}
+		hot.next()
+		tooLarge := info.Metadata.Size > s.config.MaxHotSize
+		cold := info.Metadata.UpdatedAt.Before(cutoff) && accesses[info.Key] < s.config.MinHotAccesses
+		if !tooLarge && !cold {
+			continue
+		}
+		if err := s.move(ctx, s.hot, s.cold, info.Key); err != nil {
+			errs = append(errs, fmt.Errorf("failed to demote %s: %w", info.Key, err))
+			continue
+		}
+		report.Demoted++
+	}
+
+	if s.config.PromoteAccesses > 0 {
+		keys := make([]string, 0, len(accesses))
+		for key, n := range accesses {
+			if n >= s.config.PromoteAccesses {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			promoted, err := s.promote(ctx, key)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to promote %s: %w", key, err))
+				continue
+			}
+			if promoted {
+				report.Promoted++
+			}
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// promote moves a frequently read blob to the hot tier if it is cold and
+// fits there
+func (s *TieredBlobStorage) promote(ctx context.Context, key string) (bool, error) {
+	infos, err := s.cold.List(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		if info.Key != key {
+			continue
+		}
+		if info.Metadata.Size > s.config.MaxHotSize {
+			return false, nil
+		}
+		return true, s.move(ctx, s.cold, s.hot, key)
+	}
+	return false, nil
+}
+
+// move copies a blob from one tier to the other, then deletes it from the
+// first, with writes held so none is lost in between
+func (s *TieredBlobStorage) move(ctx context.Context, from, to BlobStorage, key string) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	r, metadata, err := from.RetrieveStream(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	metadata.Compression, metadata.CompressedSize = "", 0
+	if err := to.StoreStream(ctx, key, r, metadata); err != nil {
+		return err
+	}
+	return from.Delete(ctx, key)
+}
+
+// access counts a read of key
+func (s *TieredBlobStorage) access(key string) {
+	s.accessMu.Lock()
+	s.accesses[key]++
+	s.accessMu.Unlock()
+}
+
+// deleteIfExists deletes key from storage unless it is missing
+func deleteIfExists(ctx context.Context, storage BlobStorage, key string) error {
+	exists, err := storage.Exists(ctx, key)
+	if err != nil || !exists {
+		return err
+	}
+	return storage.Delete(ctx, key)
+}
+
+// mergeTiers merges the listings of the hot and cold tiers in key order. A
+// key listed by both, while being moved, is listed once, from the hot tier.
+func mergeTiers(hot, cold []BlobInfo) []BlobInfo {
+	sort.Slice(hot, func(i, j int) bool { return hot[i].Key < hot[j].Key })
+	sort.Slice(cold, func(i, j int) bool { return cold[i].Key < cold[j].Key })
+	infos := make([]BlobInfo, 0, len(hot)+len(cold))
+	i, j := 0, 0
+	for i < len(hot) || j < len(cold) {
+		switch {
+		case j == len(cold) || (i < len(hot) && hot[i].Key < cold[j].Key):
+			infos = append(infos, hot[i])
+			i++
+		case i == len(hot) || cold[j].Key < hot[i].Key:
+			infos = append(infos, cold[j])
+			j++
+		default:
+			infos = append(infos, hot[i])
+			i++
+			j++
+		}
+	}
+	return infos
+}
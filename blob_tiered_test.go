@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTieredBlobStorage(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:blob_tiered?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	hot, err := NewDatabaseBlobStorage(runtime, &BlobStorageConfig{})
+	if err != nil {
+		t.Fatalf("NewDatabaseBlobStorage failed: %v", err)
+	}
+	cold, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	storage := NewTieredBlobStorage(hot, cold, TieringConfig{
+		MaxHotSize:      8,
+		MaxHotAge:       time.Nanosecond,
+		MinHotAccesses:  2,
+		PromoteAccesses: 1,
+	})
+	ctx := context.Background()
+	tierOf := func(key string) string {
+		t.Helper()
+		inHot, _ := hot.Exists(ctx, key)
+		inCold, _ := cold.Exists(ctx, key)
+		switch {
+		case inHot && inCold:
+			return "both"
+		case inHot:
+			return "hot"
+		case inCold:
+			return "cold"
+		}
+		return "none"
+	}
+
+	for key, data := range map[string]string{"a": "small", "b": "small", "c/large": "larger than eight"} {
+		if err := storage.Store(ctx, key, []byte(data), BlobMetadata{}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+	if err := storage.StoreStream(ctx, "d", strings.NewReader("streamed past the limit"), BlobMetadata{}); err != nil {
+		t.Fatalf("StoreStream failed: %v", err)
+	}
+	for key, want := range map[string]string{"a": "hot", "b": "hot", "c/large": "cold", "d": "cold"} {
+		if got := tierOf(key); got != want {
+			t.Errorf("Expected %s in the %s tier, got %s", key, want, got)
+		}
+	}
+
+	// Replacing a large blob with a small one moves it
+	if err := storage.StoreStream(ctx, "d", strings.NewReader("small"), BlobMetadata{}); err != nil {
+		t.Fatalf("StoreStream failed: %v", err)
+	}
+	if got := tierOf("d"); got != "hot" {
+		t.Errorf("Expected d moved to the hot tier, got %s", got)
+	}
+	blob, err := storage.Retrieve(ctx, "c/large")
+	if err != nil || string(blob.Data) != "larger than eight" {
+		t.Errorf("Expected c/large retrieved from the cold tier, got %v, %v", blob, err)
+	}
+
+	page, err := storage.ListPage(ctx, "", 2, "")
+	if err != nil {
+		t.Fatalf("ListPage failed: %v", err)
+	}
+	next, err := storage.ListPage(ctx, "", 2, page.NextToken)
+	if err != nil {
+		t.Fatalf("ListPage failed: %v", err)
+	}
+	var keys []string
+	for _, info := range append(page.Blobs, next.Blobs...) {
+		keys = append(keys, info.Key)
+	}
+	if got := strings.Join(keys, ","); got != "a,b,c/large,d" || next.NextToken != "" {
+		t.Errorf("Expected a,b,c/large,d across two pages, got %s", got)
+	}
+
+	// a is read often enough to stay; b and d are old and unread; c/large
+	// was read, so it is promoted back, but does not fit
+	for i := 0; i < 2; i++ {
+		if _, err := storage.Retrieve(ctx, "a"); err != nil {
+			t.Fatalf("Retrieve failed: %v", err)
+		}
+	}
+	report, err := storage.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if report.Demoted != 2 || report.Promoted != 0 {
+		t.Errorf("Expected 2 demoted, got %+v", report)
+	}
+	for key, want := range map[string]string{"a": "hot", "b": "cold", "c/large": "cold", "d": "cold"} {
+		if got := tierOf(key); got != want {
+			t.Errorf("Expected %s in the %s tier after Migrate, got %s", key, want, got)
+		}
+	}
+
+	if _, err := storage.Retrieve(ctx, "b"); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	report, err = storage.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	// a was not read since the last Migrate, so it is demoted, while b is
+	// promoted
+	if report.Promoted != 1 || tierOf("b") != "hot" {
+		t.Errorf("Expected b promoted, got %+v and %s", report, tierOf("b"))
+	}
+	if blob, err := storage.Retrieve(ctx, "b"); err != nil || string(blob.Data) != "small" {
+		t.Errorf("Expected b intact after moving twice, got %v, %v", blob, err)
+	}
+
+	if err := storage.Delete(ctx, "c/large"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	stats, err := storage.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalBlobs != 3 {
+		t.Errorf("Expected 3 blobs across the tiers, got %d", stats.TotalBlobs)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobTokens(t *testing.T) {
+	secret := []byte(strings.Repeat("s", 32))
+	signer, err := NewBlobTokenSigner(secret)
+	if err != nil {
+		t.Fatalf("NewBlobTokenSigner failed: %v", err)
+	}
+	if _, err := NewBlobTokenSigner([]byte("short")); err == nil {
+		t.Error("Expected a short secret to be rejected")
+	}
+
+	token, err := signer.Sign("docs/a", BlobOperationGet, time.Minute)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := signer.Verify(token, "docs/a", BlobOperationGet); err != nil {
+		t.Errorf("Expected the token valid, got %v", err)
+	}
+	if err := signer.Verify(token, "docs/b", BlobOperationGet); !errors.Is(err, ErrInvalidBlobToken) {
+		t.Errorf("Expected the token invalid for another key, got %v", err)
+	}
+	if err := signer.Verify(token, "docs/a", BlobOperationPut); !errors.Is(err, ErrInvalidBlobToken) {
+		t.Errorf("Expected the token invalid for another operation, got %v", err)
+	}
+	if err := signer.Verify(token[:len(token)-2]+"xx", "docs/a", BlobOperationGet); !errors.Is(err, ErrInvalidBlobToken) {
+		t.Errorf("Expected a tampered token invalid, got %v", err)
+	}
+	// Expiry has a resolution of a second, so this expires at once
+	expired, _ := signer.Sign("docs/a", BlobOperationGet, time.Nanosecond)
+	if err := signer.Verify(expired, "docs/a", BlobOperationGet); !errors.Is(err, ErrBlobTokenExpired) {
+		t.Errorf("Expected the token expired, got %v", err)
+	}
+
+	// Tokens of a rotated secret stay valid
+	rotated, _ := NewBlobTokenSigner([]byte(strings.Repeat("r", 32)), secret)
+	if err := rotated.Verify(token, "docs/a", BlobOperationGet); err != nil {
+		t.Errorf("Expected the token valid after rotation, got %v", err)
+	}
+
+	storage, err := NewFilesystemBlobStorage(&BlobStorageConfig{RootPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFilesystemBlobStorage failed: %v", err)
+	}
+	handler := NewBlobHTTPHandler(storage, signer)
+	do := func(method, key string, op BlobOperation, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		token, err := signer.Sign(key, op, time.Minute)
+		if err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		req := httptest.NewRequest(method, "/v1/blobs/"+key, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "text/plain")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "docs/a", BlobOperationPut, "hello"); rec.Code != http.StatusCreated {
+		t.Errorf("Expected upload status 201, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := do(http.MethodGet, "docs/a", BlobOperationGet, "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected the blob downloaded, got %d %q %q", rec.Code, rec.Body, rec.Header().Get("Content-Type"))
+	}
+	if rec := do(http.MethodGet, "docs/a", BlobOperationPut, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a put token refused for a download, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "docs/missing", BlobOperationGet, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/blobs/docs/a?token="+token, nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected a query token accepted, got %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/v1/blobs/docs/a", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a request without token refused, got %d", rec.Code)
+	}
+
+	if rec := do(http.MethodDelete, "docs/a", BlobOperationDelete, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected delete status 204, got %d", rec.Code)
+	}
+	if exists, _ := storage.Exists(context.Background(), "docs/a"); exists {
+		t.Error("Expected the blob deleted")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+type bulkheadKey struct{}
+
+// WithBulkhead runs the gated calls made with ctx in the named bulkhead
+// (GateConfig.Bulkheads), so a workload such as "reports" can only take its
+// own share of the concurrency and cannot starve "oltp" of pool slots
+func WithBulkhead(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, bulkheadKey{}, name)
+}
+
+// BulkheadFrom returns the bulkhead set on ctx with WithBulkhead
+func BulkheadFrom(ctx context.Context) string {
+	name, _ := ctx.Value(bulkheadKey{}).(string)
+	return name
+}
+
+// newBulkheads creates a limiter per configured bulkhead. They share the
+// backpressure behavior of the gate's connection limiter.
+func newBulkheads(config *GateConfig) map[string]*ConnectionLimiter {
+	if config == nil || len(config.Bulkheads) == 0 {
+		return nil
+	}
+	bulkheads := make(map[string]*ConnectionLimiter, len(config.Bulkheads))
+	for name, max := range config.Bulkheads {
+		bulkheads[name] = NewConnectionLimiter(&GateConfig{
+			MaxConcurrentConnections: max,
+			BackpressureMode:         config.BackpressureMode,
+			BackpressureTimeout:      config.BackpressureTimeout,
+		})
+	}
+	return bulkheads
+}
+
+// acquireBulkhead takes a slot in the bulkhead of ctx, if any. An unknown
+// name is an error, so a misspelled name does not bypass isolation.
+func (cg *ConnectionGate) acquireBulkhead(ctx context.Context) error {
+	name := BulkheadFrom(ctx)
+	if name == "" {
+		return nil
+	}
+	bulkhead, ok := cg.bulkheads[name]
+	if !ok {
+		return fmt.Errorf("unknown bulkhead %q", name)
+	}
+	if err := bulkhead.AcquireWithContext(ctx); err != nil {
+		return fmt.Errorf("bulkhead %q: %w", name, err)
+	}
+	return nil
+}
+
+// releaseBulkhead gives back the bulkhead slot of ctx, if any
+func (cg *ConnectionGate) releaseBulkhead(ctx context.Context) {
+	if bulkhead, ok := cg.bulkheads[BulkheadFrom(ctx)]; ok {
+		bulkhead.Release()
+	}
+}
+
+// BulkheadInUse returns the calls running in each bulkhead
+func (cg *ConnectionGate) BulkheadInUse() map[string]int64 {
+	inUse := make(map[string]int64, len(cg.bulkheads))
+	for name, bulkhead := range cg.bulkheads {
+		inUse[name] = bulkhead.CurrentConnections()
+	}
+	return inUse
+}
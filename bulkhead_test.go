@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBulkheads(t *testing.T) {
+	config := NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:bulkheads?mode=memory&cache=shared").
+		WithBulkheadLimit("reports", 1).
+		Build()
+	runtime := NewDBRuntime(config)
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+	ctx := context.Background()
+	reports := WithBulkhead(ctx, "reports")
+
+	// An open stream holds the only reports slot
+	stream, err := runtime.QueryStream(reports, 1, "SELECT 1")
+	if err != nil {
+		t.Fatalf("QueryStream failed: %v", err)
+	}
+	if inUse := runtime.gate.BulkheadInUse()["reports"]; inUse != 1 {
+		t.Errorf("Expected 1 call in the reports bulkhead, got %d", inUse)
+	}
+	if _, err := runtime.Exec(reports, "SELECT 1"); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("Expected the reports bulkhead to be full, got %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "SELECT 1"); err != nil {
+		t.Errorf("Expected calls outside the bulkhead to run, got %v", err)
+	}
+	if _, err := runtime.Exec(WithBulkhead(ctx, "repotrs"), "SELECT 1"); err == nil {
+		t.Error("Expected an unknown bulkhead to be rejected")
+	}
+
+	stream.Close()
+	if _, err := runtime.Exec(reports, "SELECT 1"); err != nil {
+		t.Errorf("Expected the reports slot to be free after Close, got %v", err)
+	}
+	if runtime.CircuitBreakerState() != CircuitStateClosed {
+		t.Errorf("Expected a full bulkhead to leave the circuit closed, got %s", runtime.CircuitBreakerState())
+	}
+}
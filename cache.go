@@ -3,14 +3,17 @@ package main
 import (
 	"container/list"
 	"context"
+	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Cache provides a Redis alternative for legacy database integration scenarios.
 // Designed for systems where external dependencies (Redis) are not available
-// or where gradual modernization is needed. Values should be JSON-serializable
-// if used across boundaries. Implementations must be concurrency-safe.
+// or where gradual modernization is needed. Values that cross process
+// boundaries are serialized with a Codec, so their types should be registered
+// with RegisterCacheType. Implementations must be concurrency-safe.
 
 type Cache interface {
 	Get(ctx context.Context, key string) (value interface{}, ok bool)
@@ -21,18 +24,62 @@ type Cache interface {
 }
 
 type CacheStats struct {
-	Items        int
-	Capacity     int
-	Hits         uint64
-	Misses       uint64
-	Evictions    uint64
-	ExpiredCount uint64
+	Items        int    `json:"items"`
+	Capacity     int    `json:"capacity"`
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	ExpiredCount uint64 `json:"expired"`
+	Errors       uint64 `json:"errors,omitempty"` // failed calls to an external cache backend
+	Bytes        int64  `json:"bytes,omitempty"`  // estimated size of the entries, when bounded by bytes
+	MaxBytes     int64  `json:"max_bytes,omitempty"`
 }
 
+// HitRate returns the percentage of lookups that hit
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses) * 100
+}
+
+// CacheBackend selects where the runtime keeps its cache
+type CacheBackend string
+
+const (
+	CacheBackendMemory    CacheBackend = "memory"    // InMemoryCache (default)
+	CacheBackendRedis     CacheBackend = "redis"     // RedisCache, configured by RuntimeConfig.RedisCache
+	CacheBackendMemcached CacheBackend = "memcached" // MemcachedCache, configured by RuntimeConfig.Memcached
+	CacheBackendTiered    CacheBackend = "tiered"    // TieredCache over RedisCache, configured by RuntimeConfig.CacheTiers
+)
+
 type cacheItem struct {
 	key      string
 	value    interface{}
 	expireAt time.Time
+	size     int64 // weight of the entry, when the cache has a byte budget
+	tags     []string
+}
+
+// Weigher estimates the memory a cache entry takes, in bytes
+type Weigher func(key string, value interface{}) int64
+
+// cacheEntryOverhead approximates the bookkeeping of an entry: its list
+// element, map slot and expiry
+const cacheEntryOverhead = 96
+
+// EncodedSize is the default Weigher: the length of the key and of the
+// value encoded as JSON, plus the entry bookkeeping. Values that cannot be
+// encoded weigh the bookkeeping only.
+func EncodedSize(key string, value interface{}) int64 {
+	size := int64(len(key)) + cacheEntryOverhead
+	if cv, ok := value.(compressedValue); ok {
+		return size + int64(len(cv.data))
+	}
+	if data, err := json.Marshal(value); err == nil {
+		size += int64(len(data))
+	}
+	return size
 }
 
 // InMemoryCache is a Redis replacement for legacy database scenarios.
@@ -45,6 +92,19 @@ type InMemoryCache struct {
 	ll         *list.List
 	capacity   int
 	defaultTTL time.Duration
+	maxBytes   int64 // 0 = bounded by capacity only
+	bytes      int64
+	weigher    Weigher
+	tags       map[string]map[string]struct{} // tag -> keys
+
+	compressThreshold int // see SetCompression
+	compressCodec     Codec
+
+	listeners []*cacheListener // see OnEvent
+	events    []CacheEvent     // removals not yet dispatched
+	hasEvents atomic.Bool
+
+	loads cacheLoads // see GetOrLoad
 
 	stats struct {
 		Hits         uint64
@@ -66,7 +126,56 @@ func NewInMemoryCache(capacity int, defaultTTL time.Duration) *InMemoryCache {
 	}
 }
 
+// SetMaxBytes bounds the estimated memory of the entries on top of the item
+// capacity, so a few huge result sets cannot push out thousands of small hot
+// entries or exhaust memory. Least recently used entries are evicted to make
+// room, and an entry larger than the whole budget is not cached. weigher
+// defaults to EncodedSize; maxBytes <= 0 removes the bound.
+func (c *InMemoryCache) SetMaxBytes(maxBytes int64, weigher Weigher) {
+	defer c.dispatchEvents()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if maxBytes <= 0 {
+		c.maxBytes, c.bytes, c.weigher = 0, 0, nil
+		for e := c.ll.Front(); e != nil; e = e.Next() {
+			ci := e.Value.(cacheItem)
+			ci.size = 0
+			e.Value = ci
+		}
+		return
+	}
+	if weigher == nil {
+		weigher = EncodedSize
+	}
+	c.maxBytes, c.weigher = maxBytes, weigher
+
+	c.bytes = 0
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		ci := e.Value.(cacheItem)
+		ci.size = weigher(ci.key, ci.value)
+		e.Value = ci
+		c.bytes += ci.size
+	}
+	c.evictLocked(false, 0)
+}
+
 func (c *InMemoryCache) Get(_ context.Context, key string) (interface{}, bool) {
+	value, ok := c.lookup(key)
+	c.dispatchEvents()
+	if cv, compressed := value.(compressedValue); ok && compressed {
+		// Decoded outside the lock
+		v, err := c.decompress(cv)
+		if err != nil {
+			return nil, false
+		}
+		return v, true
+	}
+	return value, ok
+}
+
+// lookup returns the stored value of key, counting the hit or miss
+func (c *InMemoryCache) lookup(key string) (interface{}, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -78,8 +187,7 @@ func (c *InMemoryCache) Get(_ context.Context, key string) (interface{}, bool) {
 	ci := e.Value.(cacheItem)
 	if !ci.expireAt.IsZero() && time.Now().After(ci.expireAt) {
 		// expired
-		c.ll.Remove(e)
-		delete(c.items, key)
+		c.removeLocked(e, CacheEventExpired)
 		c.stats.ExpiredCount++
 		c.stats.Misses++
 		return nil, false
@@ -90,45 +198,153 @@ func (c *InMemoryCache) Get(_ context.Context, key string) (interface{}, bool) {
 	return ci.value, true
 }
 
-func (c *InMemoryCache) Set(_ context.Context, key string, value interface{}, ttl time.Duration) bool {
+func (c *InMemoryCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	return c.SetWithTags(ctx, key, value, ttl)
+}
+
+// SetWithTags stores an entry that InvalidateTag drops along with the other
+// entries sharing one of its tags
+func (c *InMemoryCache) SetWithTags(_ context.Context, key string, value interface{}, ttl time.Duration, tags ...string) bool {
+	c.mu.RLock()
+	weigher := c.weigher
+	threshold, codec := c.compressThreshold, c.compressCodec
+	c.mu.RUnlock()
+
+	value = c.compress(value, threshold, codec)
+	var size int64
+	if weigher != nil {
+		// Weighed outside the lock: encoding a large value takes a while
+		size = weigher(key, value)
+	}
+
+	defer c.dispatchEvents()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.maxBytes > 0 && size > c.maxBytes {
+		// Caching it would evict everything else; the old value is stale
+		if e, ok := c.items[key]; ok {
+			c.removeLocked(e, CacheEventInvalidated)
+		}
+		return false
+	}
+
 	// update existing
 	if e, ok := c.items[key]; ok {
 		ci := e.Value.(cacheItem)
+		c.bytes += size - ci.size
+		c.untagLocked(ci)
 		ci.value = value
 		ci.expireAt = c.effectiveExpire(ttl)
+		ci.size = size
+		ci.tags = tags
+		c.tagLocked(ci)
 		e.Value = ci
 		c.ll.MoveToFront(e)
+		c.evictLocked(false, 0)
 		return true
 	}
 
 	// evict if full
-	if c.ll.Len() >= c.capacity {
-		if tail := c.ll.Back(); tail != nil {
-			ci := tail.Value.(cacheItem)
-			c.ll.Remove(tail)
-			delete(c.items, ci.key)
-			c.stats.Evictions++
-		}
-	}
+	c.evictLocked(true, size)
 
-	e := c.ll.PushFront(cacheItem{key: key, value: value, expireAt: c.effectiveExpire(ttl)})
+	ci := cacheItem{key: key, value: value, expireAt: c.effectiveExpire(ttl), size: size, tags: tags}
+	e := c.ll.PushFront(ci)
 	c.items[key] = e
+	c.bytes += size
+	c.tagLocked(ci)
 	return true
 }
 
+// evictLocked evicts least recently used entries until the cache is within
+// its bounds, with room for a new entry of size bytes when adding
+func (c *InMemoryCache) evictLocked(adding bool, size int64) {
+	for tail := c.ll.Back(); tail != nil; tail = c.ll.Back() {
+		full := adding && c.ll.Len() >= c.capacity
+		if !full && (c.maxBytes <= 0 || c.bytes+size <= c.maxBytes) {
+			return
+		}
+		c.removeLocked(tail, CacheEventEvicted)
+		c.stats.Evictions++
+	}
+}
+
+func (c *InMemoryCache) removeLocked(e *list.Element, reason CacheEventReason) {
+	ci := e.Value.(cacheItem)
+	c.queueEventLocked(ci, reason)
+	c.ll.Remove(e)
+	delete(c.items, ci.key)
+	c.bytes -= ci.size
+	c.untagLocked(ci)
+}
+
+// InvalidateTag drops the entries tagged with tag and returns how many
+func (c *InMemoryCache) InvalidateTag(_ context.Context, tag string) int {
+	defer c.dispatchEvents()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for key := range c.tags[tag] {
+		if e, ok := c.items[key]; ok {
+			c.removeLocked(e, CacheEventInvalidated)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *InMemoryCache) tagLocked(ci cacheItem) {
+	if len(ci.tags) == 0 {
+		return
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range ci.tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[ci.key] = struct{}{}
+	}
+}
+
+func (c *InMemoryCache) untagLocked(ci cacheItem) {
+	for _, tag := range ci.tags {
+		delete(c.tags[tag], ci.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
 func (c *InMemoryCache) Delete(_ context.Context, key string) {
+	defer c.dispatchEvents()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if e, ok := c.items[key]; ok {
-		c.ll.Remove(e)
-		delete(c.items, key)
+		c.removeLocked(e, CacheEventInvalidated)
 	}
 }
 
+// Clear removes all entries
+func (c *InMemoryCache) Clear() {
+	defer c.dispatchEvents()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.ll.Front(); e != nil && len(c.listeners) > 0; e = e.Next() {
+		c.queueEventLocked(e.Value.(cacheItem), CacheEventInvalidated)
+	}
+	c.items = make(map[string]*list.Element, c.capacity)
+	c.ll.Init()
+	c.bytes = 0
+	c.tags = nil
+}
+
 func (c *InMemoryCache) PurgeExpired() {
+	defer c.dispatchEvents()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.ll.Len() == 0 {
@@ -139,8 +355,7 @@ func (c *InMemoryCache) PurgeExpired() {
 		prev := e.Prev()
 		ci := e.Value.(cacheItem)
 		if !ci.expireAt.IsZero() && now.After(ci.expireAt) {
-			c.ll.Remove(e)
-			delete(c.items, ci.key)
+			c.removeLocked(e, CacheEventExpired)
 			c.stats.ExpiredCount++
 		}
 		e = prev
@@ -157,6 +372,8 @@ func (c *InMemoryCache) Stats() CacheStats {
 		Misses:       c.stats.Misses,
 		Evictions:    c.stats.Evictions,
 		ExpiredCount: c.stats.ExpiredCount,
+		Bytes:        c.bytes,
+		MaxBytes:     c.maxBytes,
 	}
 }
 
@@ -169,3 +386,81 @@ func (c *InMemoryCache) effectiveExpire(ttl time.Duration) time.Time {
 	}
 	return time.Now().Add(ttl)
 }
+
+// newConfiguredCache returns the cache selected by a runtime config, or nil
+// when caching is off. The in-memory cache is only created for aggressive
+// caching or in-memory mode; the shared backends whenever selected.
+func newConfiguredCache(config *RuntimeConfig) Cache {
+	backend := config.CacheBackend
+	if backend == "" && config.RedisCache != nil {
+		backend = CacheBackendRedis
+	}
+
+	switch backend {
+	case CacheBackendRedis:
+		return newConfiguredRedisCache(config)
+	case CacheBackendTiered:
+		var tiers TieredCacheConfig
+		if config.CacheTiers != nil {
+			tiers = *config.CacheTiers
+		}
+		return NewTieredCache(newConfiguredMemoryCache(config), newConfiguredRedisCache(config), tiers)
+	case CacheBackendMemcached:
+		var memcachedConfig MemcachedCacheConfig
+		if config.Memcached != nil {
+			memcachedConfig = *config.Memcached
+		}
+		if memcachedConfig.DefaultTTL <= 0 {
+			memcachedConfig.DefaultTTL = config.CacheDefaultTTL
+		}
+		memcachedConfig.Codec = compressingCodec(memcachedConfig.Codec, config.CacheCompressThreshold)
+		return NewMemcachedCache(memcachedConfig)
+	}
+
+	// Auto-configure cache for in-memory optimizations
+	if !config.EnableAggressiveCaching && !config.InMemoryMode {
+		return nil
+	}
+	return newConfiguredMemoryCache(config)
+}
+
+func newConfiguredRedisCache(config *RuntimeConfig) *RedisCache {
+	var redisConfig RedisCacheConfig
+	if config.RedisCache != nil {
+		redisConfig = *config.RedisCache
+	}
+	if redisConfig.DefaultTTL <= 0 {
+		redisConfig.DefaultTTL = config.CacheDefaultTTL
+	}
+	redisConfig.Codec = compressingCodec(redisConfig.Codec, config.CacheCompressThreshold)
+	return NewRedisCache(redisConfig)
+}
+
+func newConfiguredMemoryCache(config *RuntimeConfig) *InMemoryCache {
+	capacity := config.CacheCapacity
+	if capacity <= 0 {
+		capacity = 10000
+	}
+	ttl := config.CacheDefaultTTL
+	if ttl <= 0 {
+		ttl = 300 * time.Second
+	}
+	cache := NewInMemoryCache(capacity, ttl)
+	cache.SetMaxBytes(config.CacheMaxBytes, nil)
+	if config.CacheCompressThreshold > 0 {
+		cache.SetCompression(config.CacheCompressThreshold, nil)
+	}
+	return cache
+}
+
+// compressingCodec wraps codec (default JSONCodec) in a CompressingCodec
+// when threshold is set
+func compressingCodec(codec Codec, threshold int) Codec {
+	if threshold <= 0 {
+		return codec
+	}
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return CompressingCodec{Codec: codec, Threshold: threshold}
+}
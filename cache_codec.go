@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Codec serializes cache values that leave process memory (external cache
+// tiers, persistence, the idempotency store). Encoded values carry a format
+// version and the registered type name, so every runtime instance that
+// registers the same types decodes them back to the same Go types.
+type Codec interface {
+	Name() string
+	Encode(value interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// codecFormatVersion is the envelope version written by the codecs.
+// Decoders accept any version up to and including this one.
+const codecFormatVersion = 1
+
+var (
+	ErrUnregisteredCacheType   = errors.New("cache value type is not registered")
+	ErrUnsupportedCodecVersion = errors.New("unsupported codec format version")
+	ErrUnknownCodec            = errors.New("unknown codec")
+)
+
+var cacheTypes = struct {
+	mu     sync.RWMutex
+	byName map[string]reflect.Type
+	names  map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	names:  make(map[reflect.Type]string),
+}
+
+func init() {
+	RegisterCacheType("QueryResult", QueryResult{})
+	RegisterCacheType("RevalidatingQueryResult", revalidatingResult{})
+	RegisterCacheType("NegativeQueryResult", negativeResult{})
+	RegisterCacheType("ExecResult", ExecResult{})
+	RegisterCacheType("TCPResponse", TCPResponse{})
+
+	// Values that can appear inside QueryResult rows
+	gob.Register(time.Time{})
+}
+
+// RegisterCacheType registers the type of value under a stable name used in
+// encoded cache entries. Pointers to the type are handled automatically.
+// Names must not change between releases, or older entries become unreadable.
+func RegisterCacheType(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	cacheTypes.mu.Lock()
+	defer cacheTypes.mu.Unlock()
+	cacheTypes.byName[name] = t
+	cacheTypes.names[t] = name
+}
+
+// CodecByName returns a built-in codec ("json", "msgpack" or "gob")
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case "json", "":
+		return JSONCodec{}, nil
+	case "msgpack":
+		return MsgpackCodec{}, nil
+	case "gob":
+		return GobCodec{}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+}
+
+// lookupCacheType returns the registered name for a value and whether the
+// value was passed by pointer
+func lookupCacheType(value interface{}) (name string, ptr bool, ok bool) {
+	t := reflect.TypeOf(value)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		ptr = true
+	}
+
+	cacheTypes.mu.RLock()
+	defer cacheTypes.mu.RUnlock()
+	name, ok = cacheTypes.names[t]
+	return name, ptr, ok
+}
+
+// newCacheValue allocates a value of a registered type and returns a pointer to it
+func newCacheValue(name string) (reflect.Value, error) {
+	cacheTypes.mu.RLock()
+	t, ok := cacheTypes.byName[name]
+	cacheTypes.mu.RUnlock()
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%w: %s", ErrUnregisteredCacheType, name)
+	}
+	return reflect.New(t), nil
+}
+
+// finishCacheValue converts a decoded pointer back to the shape it was encoded in
+func finishCacheValue(v reflect.Value, ptr bool) interface{} {
+	if ptr {
+		return v.Interface()
+	}
+	return v.Elem().Interface()
+}
+
+// JSONCodec encodes cache values as JSON. Unregistered values are encoded
+// as plain JSON and decode to generic maps, slices and float64 numbers.
+type JSONCodec struct{}
+
+type jsonEnvelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"t,omitempty"`
+	Ptr     bool            `json:"p,omitempty"`
+	Data    json.RawMessage `json:"d"`
+}
+
+// Name returns the codec name
+func (JSONCodec) Name() string { return "json" }
+
+// Encode encodes a value
+func (JSONCodec) Encode(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode cache value: %w", err)
+	}
+
+	env := jsonEnvelope{Version: codecFormatVersion, Data: data}
+	if name, ptr, ok := lookupCacheType(value); ok {
+		env.Type = name
+		env.Ptr = ptr
+	}
+	return json.Marshal(env)
+}
+
+// Decode decodes a value
+func (JSONCodec) Decode(data []byte) (interface{}, error) {
+	var env jsonEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode cache value: %w", err)
+	}
+	if env.Version > codecFormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCodecVersion, env.Version)
+	}
+
+	if env.Type == "" {
+		var v interface{}
+		if err := json.Unmarshal(env.Data, &v); err != nil {
+			return nil, fmt.Errorf("failed to decode cache value: %w", err)
+		}
+		return v, nil
+	}
+
+	v, err := newCacheValue(env.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(env.Data, v.Interface()); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", env.Type, err)
+	}
+	return finishCacheValue(v, env.Ptr), nil
+}
+
+// GobCodec encodes cache values with encoding/gob. It preserves Go types
+// more faithfully than JSON but only handles registered types.
+type GobCodec struct{}
+
+type gobEnvelope struct {
+	Version int
+	Type    string
+	Ptr     bool
+	Data    []byte
+}
+
+// Name returns the codec name
+func (GobCodec) Name() string { return "gob" }
+
+// Encode encodes a value
+func (GobCodec) Encode(value interface{}) ([]byte, error) {
+	name, ptr, ok := lookupCacheType(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnregisteredCacheType, value)
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(value); err != nil {
+		return nil, fmt.Errorf("failed to encode cache value: %w", err)
+	}
+
+	var buf bytes.Buffer
+	env := gobEnvelope{Version: codecFormatVersion, Type: name, Ptr: ptr, Data: data.Bytes()}
+	if err := gob.NewEncoder(&buf).Encode(env); err != nil {
+		return nil, fmt.Errorf("failed to encode cache value: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a value
+func (GobCodec) Decode(data []byte) (interface{}, error) {
+	var env gobEnvelope
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&env); err != nil {
+		return nil, fmt.Errorf("failed to decode cache value: %w", err)
+	}
+	if env.Version > codecFormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCodecVersion, env.Version)
+	}
+
+	v, err := newCacheValue(env.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(env.Data)).Decode(v.Interface()); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", env.Type, err)
+	}
+	return finishCacheValue(v, env.Ptr), nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCodecs_RoundTripRegisteredTypes(t *testing.T) {
+	for _, name := range []string{"json", "msgpack", "gob"} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := CodecByName(name)
+			if err != nil {
+				t.Fatalf("CodecByName(%q) failed: %v", name, err)
+			}
+
+			qr := QueryResult{
+				Columns: []string{"id", "name"},
+				Rows:    [][]interface{}{{"1", "Alice"}},
+			}
+			data, err := codec.Encode(qr)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			decoded, err := codec.Decode(data)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, qr) {
+				t.Errorf("QueryResult mismatch: expected %+v, got %+v", qr, decoded)
+			}
+
+			resp := NewErrorResponse("42", errors.New("boom"))
+			data, err = codec.Encode(resp)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			decoded, err = codec.Decode(data)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			decodedResp, ok := decoded.(*TCPResponse)
+			if !ok {
+				t.Fatalf("Expected *TCPResponse, got %T", decoded)
+			}
+			if decodedResp.ID != "42" || decodedResp.Error != "boom" {
+				t.Errorf("TCPResponse mismatch: got %+v", decodedResp)
+			}
+		})
+	}
+}
+
+func TestCodecs_Unregistered(t *testing.T) {
+	type custom struct{ A int }
+
+	if _, err := (GobCodec{}).Encode(custom{A: 1}); !errors.Is(err, ErrUnregisteredCacheType) {
+		t.Errorf("Expected ErrUnregisteredCacheType from gob, got %v", err)
+	}
+
+	data, err := (JSONCodec{}).Encode(custom{A: 1})
+	if err != nil {
+		t.Fatalf("JSON should encode unregistered values: %v", err)
+	}
+	decoded, err := (JSONCodec{}).Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if m, ok := decoded.(map[string]interface{}); !ok || m["A"] != float64(1) {
+		t.Errorf("Expected generic map, got %#v", decoded)
+	}
+}
+
+func TestJSONCodec_RejectsNewerVersion(t *testing.T) {
+	_, err := (JSONCodec{}).Decode([]byte(`{"v":99,"d":null}`))
+	if !errors.Is(err, ErrUnsupportedCodecVersion) {
+		t.Errorf("Expected ErrUnsupportedCodecVersion, got %v", err)
+	}
+}
+
+func TestMsgpackCodec_Values(t *testing.T) {
+	freshUntil := time.Unix(1700000000, 123456789)
+	entry := revalidatingResult{
+		Result: QueryResult{
+			Columns: []string{"id", "score", "blob", "seen", "none", "big", "neg"},
+			Rows: [][]interface{}{{
+				int64(1), 2.5, []byte{0, 1, 2}, freshUntil, nil, uint64(math.MaxUint64), int64(-70000),
+			}},
+		},
+		FreshUntil: freshUntil,
+	}
+
+	data, err := (MsgpackCodec{}).Encode(entry)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := (MsgpackCodec{}).Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	got, ok := decoded.(revalidatingResult)
+	if !ok {
+		t.Fatalf("Expected revalidatingResult, got %T", decoded)
+	}
+	if !got.FreshUntil.Equal(freshUntil) {
+		t.Errorf("Expected FreshUntil %v, got %v", freshUntil, got.FreshUntil)
+	}
+	row := got.Result.Rows[0]
+	if row[0] != int64(1) || row[1] != 2.5 || !reflect.DeepEqual(row[2], []byte{0, 1, 2}) ||
+		!row[3].(time.Time).Equal(freshUntil) || row[4] != nil ||
+		row[5] != uint64(math.MaxUint64) || row[6] != int64(-70000) {
+		t.Errorf("Row values changed: %#v", row)
+	}
+
+	// Unregistered values decode to generic values
+	data, err = (MsgpackCodec{}).Encode(map[string]interface{}{"a": []string{"x"}, "b": true})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err = (MsgpackCodec{}).Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	expected := map[string]interface{}{"a": []interface{}{"x"}, "b": true}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, decoded)
+	}
+
+	if _, err := (MsgpackCodec{}).Decode(data[:len(data)-1]); err == nil {
+		t.Error("Expected an error for a truncated value")
+	}
+
+	// {"v": 99, "d": nil}
+	newer := []byte{0x82, 0xa1, 'v', 99, 0xa1, 'd', 0xc0}
+	if _, err := (MsgpackCodec{}).Decode(newer); !errors.Is(err, ErrUnsupportedCodecVersion) {
+		t.Errorf("Expected ErrUnsupportedCodecVersion, got %v", err)
+	}
+}
+
+func TestCodecByName_Unknown(t *testing.T) {
+	if _, err := CodecByName("xml"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("Expected ErrUnknownCodec, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// compressedMarker prefixes compressed cache values. None of the codecs
+// starts its output with a zero byte, so plain and compressed values can be
+// told apart.
+const compressedMarker = 0x00
+
+// CompressingCodec wraps a Codec, gzipping encoded values of at least
+// Threshold bytes. Decode reads compressed and plain values alike, so it can
+// be turned on for a cache that already holds entries.
+type CompressingCodec struct {
+	Codec     Codec
+	Threshold int
+}
+
+// Name returns the codec name
+func (c CompressingCodec) Name() string { return c.Codec.Name() + "+gzip" }
+
+// Encode encodes a value, compressing it when large
+func (c CompressingCodec) Encode(value interface{}) ([]byte, error) {
+	data, err := c.Codec.Encode(value)
+	if err != nil || len(data) < c.Threshold {
+		return data, err
+	}
+	return compressCacheValue(data)
+}
+
+// Decode decodes a value, compressed or not
+func (c CompressingCodec) Decode(data []byte) (interface{}, error) {
+	data, err := decompressCacheValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.Decode(data)
+}
+
+// compressCacheValue gzips an encoded value behind compressedMarker
+func compressCacheValue(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(compressedMarker)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress cache value: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress cache value: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressCacheValue returns the encoded value of data, which is
+// returned as is unless compressed
+func decompressCacheValue(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != compressedMarker {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress cache value: %w", err)
+	}
+	defer zr.Close()
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress cache value: %w", err)
+	}
+	return plain, nil
+}
+
+// compressedValue is an InMemoryCache entry kept compressed
+type compressedValue struct {
+	data  []byte
+	codec Codec
+}
+
+// SetCompression keeps entries whose encoding is at least threshold bytes
+// compressed, trading CPU on Set and Get for memory when caching wide
+// result sets. codec defaults to GobCodec, which keeps row value types;
+// values it cannot encode are kept as they are. threshold <= 0 turns
+// compression off for new entries.
+func (c *InMemoryCache) SetCompression(threshold int, codec Codec) {
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compressThreshold, c.compressCodec = threshold, codec
+}
+
+// compress returns the value to store for value: compressed when its
+// encoding reaches the threshold
+func (c *InMemoryCache) compress(value interface{}, threshold int, codec Codec) interface{} {
+	if threshold <= 0 {
+		return value
+	}
+	data, err := codec.Encode(value)
+	if err != nil || len(data) < threshold {
+		return value
+	}
+	if data, err = compressCacheValue(data); err != nil {
+		return value
+	}
+	return compressedValue{data: data, codec: codec}
+}
+
+// decompress returns the value stored as v
+func (c *InMemoryCache) decompress(v compressedValue) (interface{}, error) {
+	data, err := decompressCacheValue(v.data)
+	if err != nil {
+		return nil, err
+	}
+	return v.codec.Decode(data)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompressingCodec(t *testing.T) {
+	codec := CompressingCodec{Codec: JSONCodec{}, Threshold: 256}
+	small := QueryResult{Columns: []string{"id"}}
+	large := QueryResult{Columns: []string{"name"}}
+	for i := 0; i < 100; i++ {
+		large.Rows = append(large.Rows, []interface{}{"the same name over and over"})
+	}
+
+	for _, value := range []QueryResult{small, large} {
+		data, err := codec.Encode(value)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		if compressed := data[0] == compressedMarker; compressed != (len(value.Rows) > 0) {
+			t.Errorf("Expected only the large value to be compressed, got compressed=%v for %d rows", compressed, len(value.Rows))
+		}
+		got, err := codec.Decode(data)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("Expected %+v, got %+v", value, got)
+		}
+	}
+
+	// Values written before compression was enabled still decode
+	plain, _ := JSONCodec{}.Encode(large)
+	if got, err := codec.Decode(plain); err != nil || !reflect.DeepEqual(got, large) {
+		t.Errorf("Expected the plain value to decode, got %v", err)
+	}
+}
+
+func TestInMemoryCache_Compression(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCache(10, time.Minute)
+	cache.SetMaxBytes(1<<20, nil)
+	cache.SetCompression(256, nil)
+
+	large := QueryResult{Columns: []string{"id", "name"}}
+	for i := 0; i < 100; i++ {
+		large.Rows = append(large.Rows, []interface{}{int64(i), "the same name over and over"})
+	}
+	cache.Set(ctx, "large", large, 0)
+	if _, ok := cache.items["large"].Value.(cacheItem).value.(compressedValue); !ok {
+		t.Fatal("Expected the large value to be stored compressed")
+	}
+	if bytes, plain := cache.Stats().Bytes, EncodedSize("large", large); bytes >= plain/2 {
+		t.Errorf("Expected the compressed entry to weigh well under %d bytes, got %d", plain, bytes)
+	}
+
+	got, ok := cache.Get(ctx, "large")
+	if !ok {
+		t.Fatal("Expected a hit")
+	}
+	if !reflect.DeepEqual(got, large) {
+		t.Errorf("Expected the value back with its row types, got %+v", got)
+	}
+
+	cache.Set(ctx, "small", QueryResult{Columns: []string{"id"}}, 0)
+	if _, ok := cache.items["small"].Value.(cacheItem).value.(QueryResult); !ok {
+		t.Error("Expected a small value to be stored as is")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// writeTarget matches the table a write statement changes
+var writeTarget = regexp.MustCompile(`(?i)^(?:` +
+	`INSERT\s+(?:OR\s+\w+\s+)?(?:IGNORE\s+)?(?:INTO\s+)?|` +
+	`REPLACE\s+(?:INTO\s+)?|` +
+	`UPDATE\s+(?:ONLY\s+)?|` +
+	`DELETE\s+(?:FROM\s+)?(?:ONLY\s+)?|` +
+	`MERGE\s+INTO\s+|` +
+	`TRUNCATE\s+(?:TABLE\s+)?|` +
+	`(?:DROP|ALTER)\s+TABLE\s+(?:IF\s+EXISTS\s+)?` +
+	")([`\"\\[\\]\\w.$#]+)")
+
+// readSource matches the tables after FROM or JOIN, including comma-joined
+// lists with aliases ("FROM users u, orders o")
+var readSource = regexp.MustCompile(`(?i)\b(?:FROM|JOIN)\s+(` +
+	"(?:[`\"\\[\\]\\w.$#]+(?:\\s+(?:AS\\s+)?\\w+)?\\s*,\\s*)*" +
+	"[`\"\\[\\]\\w.$#]+)")
+
+// readTables returns the tables a query reads, normalized with
+// normalizeTable. Names that are not tables (CTEs, the FROM of EXTRACT)
+// only cause extra invalidations.
+func readTables(query string) []string {
+	var tables []string
+	seen := make(map[string]bool)
+	for _, m := range readSource.FindAllStringSubmatch(query, -1) {
+		for _, source := range strings.Split(m[1], ",") {
+			fields := strings.Fields(source)
+			if len(fields) == 0 {
+				continue
+			}
+			if table := normalizeTable(fields[0]); !seen[table] {
+				seen[table] = true
+				tables = append(tables, table)
+			}
+		}
+	}
+	return tables
+}
+
+// writeTables returns the tables a statement writes, normalized with
+// normalizeTable. Statements that are not recognized as writes return nil.
+func writeTables(query string) []string {
+	q := leadingNoise.ReplaceAllString(query, "")
+	m := writeTarget.FindStringSubmatch(q)
+	if m == nil {
+		return nil
+	}
+	return []string{normalizeTable(m[1])}
+}
+
+// normalizeTable lower-cases a table name and strips its quotes and schema,
+// so "App"."Users", app.users and USERS all depend on each other. Sharing a
+// table name across schemas only over-invalidates.
+func normalizeTable(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '"', '`', '[', ']':
+			return -1
+		}
+		return r
+	}, name)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(name)
+}
+
+// cacheDependencies maps tables to the QueryCached keys that read them
+type cacheDependencies struct {
+	mu     sync.RWMutex
+	tables map[string]map[string]struct{} // table -> keys
+}
+
+// add registers key as depending on tables
+func (d *cacheDependencies) add(key string, tables []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tables == nil {
+		d.tables = make(map[string]map[string]struct{})
+	}
+	for _, table := range tables {
+		table = normalizeTable(table)
+		keys, ok := d.tables[table]
+		if !ok {
+			keys = make(map[string]struct{})
+			d.tables[table] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// keys returns the keys depending on any of tables
+func (d *cacheDependencies) keys(tables []string) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var keys []string
+	for _, table := range tables {
+		for key := range d.tables[table] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// RegisterCacheDependencies records that the QueryCached entry stored under
+// key reads tables. With RuntimeConfig.InvalidateCacheOnWrite set, Exec
+// (and ExecBatch) drop the entry after a write to any of those tables.
+// QueryCached registers the tables named in its query by itself; this adds
+// the ones it cannot see, such as the tables behind a view.
+// Registrations are kept for the life of the runtime.
+func (r *DBRuntime) RegisterCacheDependencies(key string, tables ...string) {
+	r.cacheDeps.add(key, tables)
+}
+
+// trackCacheDependencies registers the tables a QueryCached query reads.
+// It runs on hits too, since the entry may have been stored by another
+// runtime sharing the cache.
+func (r *DBRuntime) trackCacheDependencies(key, query string) {
+	if !r.config.InvalidateCacheOnWrite || r.cache == nil || key == "" {
+		return
+	}
+	if tables := readTables(query); len(tables) > 0 {
+		r.cacheDeps.add(key, tables)
+	}
+}
+
+// invalidateWrites drops the cache entries depending on the table query
+// writes, and those named by the matching invalidation rules. It runs
+// whatever the outcome of the write, since a failed or timed-out statement
+// may still have changed rows.
+func (r *DBRuntime) invalidateWrites(ctx context.Context, query string, args []interface{}) {
+	if r.cache == nil {
+		return
+	}
+	r.applyInvalidationRules(ctx, query, args)
+	if !r.config.InvalidateCacheOnWrite {
+		return
+	}
+	tables := writeTables(query)
+	if tables == nil {
+		return
+	}
+	for _, key := range r.cacheDeps.keys(tables) {
+		r.cache.Delete(ctx, key)
+	}
+}
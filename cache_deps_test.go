@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheInvalidationOnWrite(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:cache_invalidation?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		WithCacheInvalidationOnWrite(true).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "CREATE TABLE audit (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	runtime.RegisterCacheDependencies("users:all", "main.Users")
+
+	query := func() ([][]interface{}, bool) {
+		_, rows, cached, err := runtime.QueryCached(ctx, "users:all", time.Minute, "SELECT name FROM users")
+		if err != nil {
+			t.Fatalf("QueryCached failed: %v", err)
+		}
+		return rows, cached
+	}
+	query()
+	if _, cached := query(); !cached {
+		t.Fatal("Expected the second query to be cached")
+	}
+
+	// Writes to other tables keep the entry
+	if _, err := runtime.Exec(ctx, "INSERT INTO audit (id) VALUES (1)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if _, cached := query(); !cached {
+		t.Error("Expected a write to another table to keep the entry")
+	}
+
+	if _, err := runtime.Exec(ctx, "/* signup */ INSERT INTO \"users\" (name) VALUES (?)", "alice"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	rows, cached := query()
+	if cached || len(rows) != 1 {
+		t.Errorf("Expected a fresh result with 1 row, got cached=%v rows=%v", cached, rows)
+	}
+
+	// Tables named in the cached query are tracked without registration
+	if _, _, _, err := runtime.QueryCached(ctx, "audit:count", time.Minute, "SELECT COUNT(*) FROM audit a JOIN users u ON u.id = a.id"); err != nil {
+		t.Fatalf("QueryCached failed: %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "INSERT INTO audit (id) VALUES (2)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if _, _, cached, _ := runtime.QueryCached(ctx, "audit:count", time.Minute, "SELECT COUNT(*) FROM audit a JOIN users u ON u.id = a.id"); cached {
+		t.Error("Expected a write to a table read by the query to drop the entry")
+	}
+
+	for query, want := range map[string]string{
+		"SELECT * FROM users": "users",
+		"SELECT * FROM app.\"Users\" u, orders AS o WHERE u.id = o.uid": "users,orders",
+		"SELECT * FROM a LEFT JOIN b ON a.id = b.id JOIN c USING (id)":  "a,b,c",
+		"SELECT * FROM (SELECT id FROM items) sub":                      "items",
+		"SELECT 1": "",
+	} {
+		if got := strings.Join(readTables(query), ","); got != want {
+			t.Errorf("readTables(%q) = %q, want %q", query, got, want)
+		}
+	}
+
+	for query, want := range map[string]string{
+		"UPDATE ONLY app.users SET name = 'x'":           "users",
+		"delete from `Orders` where id = 1":              "orders",
+		"INSERT OR REPLACE INTO kv (k, v) VALUES (1, 2)": "kv",
+		"TRUNCATE TABLE logs":                            "logs",
+		"SELECT * FROM users":                            "",
+	} {
+		got := ""
+		if tables := writeTables(query); len(tables) > 0 {
+			got = tables[0]
+		}
+		if got != want {
+			t.Errorf("writeTables(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"slices"
+)
+
+// CacheEventReason is why an entry left the cache
+type CacheEventReason string
+
+const (
+	CacheEventEvicted     CacheEventReason = "evicted"     // pushed out by the capacity or byte budget
+	CacheEventExpired     CacheEventReason = "expired"     // past its TTL
+	CacheEventInvalidated CacheEventReason = "invalidated" // Delete, InvalidateTag or Clear
+)
+
+// CacheEvent reports an entry leaving the cache. Overwriting an entry with
+// Set is not an event.
+type CacheEvent struct {
+	Key    string
+	Value  interface{}
+	Reason CacheEventReason
+}
+
+// CacheEventSource is a Cache that reports entries leaving it.
+// InMemoryCache and TieredCache (for its L1) implement it.
+type CacheEventSource interface {
+	OnEvent(fn func(CacheEvent)) (remove func())
+}
+
+// cacheListener wraps a callback so it can be found again for removal
+type cacheListener struct {
+	fn func(CacheEvent)
+}
+
+// OnEvent calls fn for each entry evicted, expired or invalidated, until the
+// returned function is called. Callbacks run after the cache lock is
+// released, on the goroutine whose call removed the entry, so they may use
+// the cache but should be quick.
+func (c *InMemoryCache) OnEvent(fn func(CacheEvent)) (remove func()) {
+	l := &cacheListener{fn: fn}
+	c.mu.Lock()
+	c.listeners = append(c.listeners, l)
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.listeners = slices.DeleteFunc(c.listeners, func(other *cacheListener) bool { return other == l })
+	}
+}
+
+// queueEventLocked records the removal of ci for the listeners, if any
+func (c *InMemoryCache) queueEventLocked(ci cacheItem, reason CacheEventReason) {
+	if len(c.listeners) > 0 {
+		c.events = append(c.events, CacheEvent{Key: ci.key, Value: ci.value, Reason: reason})
+		c.hasEvents.Store(true)
+	}
+}
+
+// dispatchEvents hands the queued events to the listeners. Methods that
+// remove entries defer it ahead of taking the lock, so it runs unlocked.
+func (c *InMemoryCache) dispatchEvents() {
+	if !c.hasEvents.Load() {
+		return
+	}
+	c.mu.Lock()
+	events, listeners := c.events, c.listeners
+	c.events = nil
+	c.hasEvents.Store(false)
+	c.mu.Unlock()
+
+	for _, event := range events {
+		if cv, ok := event.Value.(compressedValue); ok {
+			// Listeners get what Get would have returned
+			event.Value, _ = c.decompress(cv)
+		}
+		for _, l := range listeners {
+			l.fn(event)
+		}
+	}
+}
+
+// OnEvent reports the entries leaving L1. Entries expiring or evicted in L2
+// are not reported.
+func (c *TieredCache) OnEvent(fn func(CacheEvent)) (remove func()) {
+	return c.l1.OnEvent(fn)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInMemoryCache_Events(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCache(2, time.Minute)
+
+	var events []CacheEvent
+	remove := cache.OnEvent(func(e CacheEvent) {
+		// Callbacks run unlocked, so they may use the cache
+		cache.Stats()
+		events = append(events, e)
+	})
+
+	cache.Set(ctx, "a", 1, 0)
+	cache.Set(ctx, "a", 2, 0) // an overwrite is not an event
+	cache.SetWithTags(ctx, "b", 3, 0, "t")
+	cache.Set(ctx, "c", 4, 0) // evicts a
+	cache.InvalidateTag(ctx, "t")
+	cache.Set(ctx, "d", 5, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cache.Get(ctx, "d")
+	cache.Delete(ctx, "c")
+
+	want := []CacheEvent{
+		{Key: "a", Value: 2, Reason: CacheEventEvicted},
+		{Key: "b", Value: 3, Reason: CacheEventInvalidated},
+		{Key: "d", Value: 5, Reason: CacheEventExpired},
+		{Key: "c", Value: 4, Reason: CacheEventInvalidated},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Expected %+v, got %+v", want, events)
+	}
+
+	remove()
+	cache.Set(ctx, "e", 6, 0)
+	cache.Clear()
+	if len(events) != len(want) {
+		t.Errorf("Expected no events after removing the callback, got %+v", events[len(want):])
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// flightGroup collapses concurrent cache misses of the same key into a
+// single load whose result every caller shares. The zero value is ready to
+// use.
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+// flightCall is a load in flight
+type flightCall[T any] struct {
+	done   chan struct{}
+	result T
+	err    error
+}
+
+// do runs load for key unless a call for key is already in flight, in which
+// case it waits for that call and returns its result with shared set. A
+// caller stops waiting when its own ctx is done. When the running call
+// failed only because its caller's context ended, a waiter whose context is
+// still live runs the load itself.
+func (g *flightGroup[T]) do(ctx context.Context, key string, load func() (T, error)) (result T, shared bool, err error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = make(map[string]*flightCall[T])
+		}
+		c, ok := g.calls[key]
+		if !ok {
+			c = &flightCall[T]{done: make(chan struct{})}
+			g.calls[key] = c
+			g.mu.Unlock()
+			g.run(key, c, load)
+			return c.result, false, c.err
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			var zero T
+			return zero, false, ctx.Err()
+		}
+		if ctx.Err() == nil && (errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded)) {
+			continue
+		}
+		return c.result, true, c.err
+	}
+}
+
+// run loads the result of c and releases its waiters, even if load panics
+func (g *flightGroup[T]) run(key string, c *flightCall[T], load func() (T, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.err = errors.New("cache load panicked") // seen by the waiters if load panics
+	c.result, c.err = load()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup[QueryResult]
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func() (QueryResult, error) {
+		loads.Add(1)
+		<-release
+		return QueryResult{Columns: []string{"n"}}, nil
+	}
+
+	var wg sync.WaitGroup
+	var shared atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			qr, s, err := g.do(context.Background(), "k", load)
+			if err != nil || len(qr.Columns) != 1 {
+				t.Errorf("unexpected result %+v, %v", qr, err)
+			}
+			if s {
+				shared.Add(1)
+			}
+		}()
+	}
+	// Let the callers queue up behind the first load
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		started := len(g.calls) == 1
+		g.mu.Unlock()
+		if started && loads.Load() == 1 {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("expected 1 load, got %d", n)
+	}
+	if n := shared.Load(); n != 9 {
+		t.Errorf("expected 9 shared results, got %d", n)
+	}
+
+	// A waiter does not inherit the cancellation of the caller it waited on
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go g.do(leaderCtx, "c", func() (QueryResult, error) {
+		close(started)
+		<-leaderCtx.Done()
+		return QueryResult{}, leaderCtx.Err()
+	})
+	<-started
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := g.do(context.Background(), "c", func() (QueryResult, error) {
+			return QueryResult{}, nil
+		})
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected the waiter to load again, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// InspectableCache is a Cache whose contents can be examined without
+// perturbing it: Peek neither counts a hit nor refreshes the recency of an
+// entry. InMemoryCache, RedisCache and TieredCache implement it.
+type InspectableCache interface {
+	Cache
+	// Keys returns the keys starting with prefix, sorted
+	Keys(ctx context.Context, prefix string) []string
+	// TTLRemaining returns the time left before key expires, 0 if it never
+	// does; ok is false when key is not cached
+	TTLRemaining(ctx context.Context, key string) (ttl time.Duration, ok bool)
+	// Peek returns the value of key
+	Peek(ctx context.Context, key string) (value interface{}, ok bool)
+}
+
+func (c *InMemoryCache) Keys(_ context.Context, prefix string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+	var keys []string
+	for key, e := range c.items {
+		ci := e.Value.(cacheItem)
+		if strings.HasPrefix(key, prefix) && (ci.expireAt.IsZero() || !now.After(ci.expireAt)) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (c *InMemoryCache) TTLRemaining(_ context.Context, key string) (time.Duration, bool) {
+	ci, ok := c.peekItem(key)
+	if !ok || ci.expireAt.IsZero() {
+		return 0, ok
+	}
+	return time.Until(ci.expireAt), true
+}
+
+func (c *InMemoryCache) Peek(_ context.Context, key string) (interface{}, bool) {
+	ci, ok := c.peekItem(key)
+	if !ok {
+		return nil, false
+	}
+	if cv, compressed := ci.value.(compressedValue); compressed {
+		v, err := c.decompress(cv)
+		return v, err == nil
+	}
+	return ci.value, true
+}
+
+// peekItem returns the entry of key unless it expired, leaving the entry,
+// its recency and the stats as they are
+func (c *InMemoryCache) peekItem(key string) (cacheItem, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.items[key]
+	if !ok {
+		return cacheItem{}, false
+	}
+	ci := e.Value.(cacheItem)
+	if !ci.expireAt.IsZero() && time.Now().After(ci.expireAt) {
+		return cacheItem{}, false
+	}
+	return ci, true
+}
+
+// Keys scans the keys under the KeyPrefix of the cache, leaving out the tag
+// sets. On a shared server the scan is O(keys in the database).
+func (c *RedisCache) Keys(ctx context.Context, prefix string) []string {
+	pattern := redisGlobEscape(c.config.KeyPrefix+prefix) + "*"
+	tagPrefix := c.tagKey("")
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := c.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			c.errors.Add(1)
+			break
+		}
+		// A SCAN reply is [next cursor, [keys...]]
+		parts, _ := reply.([]interface{})
+		if len(parts) != 2 {
+			break
+		}
+		next, _ := parts[0].([]byte)
+		batch, _ := parts[1].([]interface{})
+		for _, k := range batch {
+			key, _ := k.([]byte)
+			if key != nil && !strings.HasPrefix(string(key), tagPrefix) {
+				keys = append(keys, strings.TrimPrefix(string(key), c.config.KeyPrefix))
+			}
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	slices.Sort(keys)
+	// SCAN may return a key more than once
+	return slices.Compact(keys)
+}
+
+func (c *RedisCache) TTLRemaining(ctx context.Context, key string) (time.Duration, bool) {
+	reply, err := c.do(ctx, "PTTL", c.config.KeyPrefix+key)
+	if err != nil {
+		c.errors.Add(1)
+		return 0, false
+	}
+	// PTTL is -2 for a missing key and -1 for one without expiry
+	switch ms, _ := reply.(int64); {
+	case ms == -2:
+		return 0, false
+	case ms < 0:
+		return 0, true
+	default:
+		return time.Duration(ms) * time.Millisecond, true
+	}
+}
+
+func (c *RedisCache) Peek(ctx context.Context, key string) (interface{}, bool) {
+	reply, err := c.do(ctx, "GET", c.config.KeyPrefix+key)
+	if err != nil {
+		c.errors.Add(1)
+		return nil, false
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false
+	}
+	value, err := c.config.Codec.Decode(data)
+	if err != nil {
+		c.errors.Add(1)
+		return nil, false
+	}
+	return value, true
+}
+
+// redisGlobEscape escapes the glob characters of a SCAN MATCH pattern
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\^-`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Keys returns the keys of L2, which holds every entry of L1
+func (c *TieredCache) Keys(ctx context.Context, prefix string) []string {
+	if ic, ok := c.l2.(InspectableCache); ok {
+		return ic.Keys(ctx, prefix)
+	}
+	return c.l1.Keys(ctx, prefix)
+}
+
+// TTLRemaining returns the TTL left in L2, which outlives the L1 copy
+func (c *TieredCache) TTLRemaining(ctx context.Context, key string) (time.Duration, bool) {
+	if ic, ok := c.l2.(InspectableCache); ok {
+		return ic.TTLRemaining(ctx, key)
+	}
+	return c.l1.TTLRemaining(ctx, key)
+}
+
+// Peek reads L1, then L2 without filling L1
+func (c *TieredCache) Peek(ctx context.Context, key string) (interface{}, bool) {
+	if value, ok := c.l1.Peek(ctx, key); ok {
+		return value, true
+	}
+	if ic, ok := c.l2.(InspectableCache); ok {
+		return ic.Peek(ctx, key)
+	}
+	return nil, false
+}
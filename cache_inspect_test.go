@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInspectableCache(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	ctx := context.Background()
+	redis := NewRedisCache(RedisCacheConfig{Addr: srv.Addr().String(), KeyPrefix: "app:"})
+	memory := NewInMemoryCache(10, 0)
+	memory.SetCompression(1, nil)
+
+	for name, cache := range map[string]InspectableCache{"memory": memory, "redis": redis} {
+		qr := QueryResult{Columns: []string{"id"}}
+		cache.Set(ctx, "user:2", qr, time.Minute)
+		cache.Set(ctx, "user:1", qr, 0)
+		cache.Set(ctx, "order:1", qr, 0)
+		if tc, ok := cache.(TaggedCache); ok {
+			tc.SetWithTags(ctx, "user:[3]", qr, 0, "users")
+		}
+		before := cache.Stats()
+
+		if keys := cache.Keys(ctx, "user:"); !reflect.DeepEqual(keys, []string{"user:1", "user:2", "user:[3]"}) {
+			t.Errorf("%s: expected the user keys, got %v", name, keys)
+		}
+		if keys := cache.Keys(ctx, "user:["); !reflect.DeepEqual(keys, []string{"user:[3]"}) {
+			t.Errorf("%s: expected the prefix to be matched literally, got %v", name, keys)
+		}
+		if ttl, ok := cache.TTLRemaining(ctx, "user:2"); !ok || ttl <= 59*time.Second || ttl > time.Minute {
+			t.Errorf("%s: expected about a minute left, got %v, %v", name, ttl, ok)
+		}
+		if ttl, ok := cache.TTLRemaining(ctx, "user:1"); !ok || ttl != 0 {
+			t.Errorf("%s: expected no expiry, got %v, %v", name, ttl, ok)
+		}
+		if _, ok := cache.TTLRemaining(ctx, "missing"); ok {
+			t.Errorf("%s: expected a missing key to have no TTL", name)
+		}
+		if v, ok := cache.Peek(ctx, "user:1"); !ok || !reflect.DeepEqual(v, qr) {
+			t.Errorf("%s: expected to peek the value, got %+v, %v", name, v, ok)
+		}
+		if after := cache.Stats(); after.Hits != before.Hits || after.Misses != before.Misses {
+			t.Errorf("%s: expected inspection to leave the stats alone, got %+v then %+v", name, before, after)
+		}
+	}
+
+	// Peeking does not make an entry recently used
+	lru := NewInMemoryCache(2, 0)
+	lru.Set(ctx, "a", 1, 0)
+	lru.Set(ctx, "b", 2, 0)
+	lru.Peek(ctx, "a")
+	lru.Set(ctx, "c", 3, 0)
+	if _, ok := lru.Peek(ctx, "a"); ok {
+		t.Error("Expected a to be evicted despite the peek")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CacheKey derives a QueryCached key from a statement and its arguments: a
+// hash of the normalized SQL and of each argument's type and JSON encoding,
+// prefixed with namespace and ":" when set. Statements differing only in
+// whitespace or comments share a key; arguments of different types (1 and
+// "1") do not.
+func CacheKey(namespace, query string, args ...interface{}) string {
+	h := sha256.New()
+	h.Write([]byte(normalizeCacheSQL(query)))
+	for _, arg := range args {
+		h.Write([]byte{0})
+		fmt.Fprintf(h, "%T:", arg)
+		if data, err := json.Marshal(arg); err == nil {
+			h.Write(data)
+		} else {
+			fmt.Fprintf(h, "%v", arg)
+		}
+	}
+
+	key := "sql:" + hex.EncodeToString(h.Sum(nil))
+	if namespace != "" {
+		key = namespace + ":" + key
+	}
+	return key
+}
+
+// normalizeCacheSQL drops comments and collapses whitespace outside quotes.
+// Unlike QueryFingerprint it keeps literals and case, which change results.
+func normalizeCacheSQL(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false // whitespace pending between two tokens
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+			space = true
+			continue
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+			space = true
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		if c == '\'' || c == '"' || c == '`' {
+			end := skipQuoted(query, i)
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+// QueryCachedAuto is QueryCached under a key derived from the query and its
+// arguments with CacheKey, in the namespace RuntimeConfig.CacheKeyNamespace
+func (r *DBRuntime) QueryCachedAuto(ctx context.Context, ttl time.Duration, query string, args ...interface{}) ([]string, [][]interface{}, bool, error) {
+	return r.QueryCached(ctx, CacheKey(r.config.CacheKeyNamespace, query, args...), ttl, query, args...)
+}
+
+// QueryCachedAuto is QueryCached under a key derived like
+// DBRuntime.QueryCachedAuto, in the primary's namespace
+func (rr *ReplicatedRuntime) QueryCachedAuto(ctx context.Context, ttl time.Duration, query string, args ...interface{}) ([]string, [][]interface{}, bool, error) {
+	return rr.QueryCached(ctx, CacheKey(rr.primary.config.CacheKeyNamespace, query, args...), ttl, query, args...)
+}
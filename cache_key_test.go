@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryCachedAuto(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:query_cached_auto?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		WithCacheKeyNamespace("app").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	query := "SELECT ? AS n"
+	if _, _, cached, err := runtime.QueryCachedAuto(ctx, time.Minute, query, 1); err != nil || cached {
+		t.Fatalf("Expected a miss, got cached=%v err=%v", cached, err)
+	}
+	_, rows, cached, err := runtime.QueryCachedAuto(ctx, time.Minute, query, 1)
+	if err != nil || !cached || len(rows) != 1 {
+		t.Errorf("Expected a hit, got cached=%v rows=%v err=%v", cached, rows, err)
+	}
+	if _, _, cached, _ := runtime.QueryCachedAuto(ctx, time.Minute, query, 2); cached {
+		t.Error("Expected other arguments to miss")
+	}
+	if _, ok := runtime.Cache().Get(ctx, CacheKey("app", query, 1)); !ok {
+		t.Error("Expected the entry under the namespaced key")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	base := CacheKey("", "SELECT * FROM users WHERE id = ?", 1)
+	if !strings.HasPrefix(base, "sql:") {
+		t.Errorf("Expected a sql: key, got %s", base)
+	}
+	same := []string{
+		CacheKey("", "SELECT *\n  FROM users -- by id\n WHERE id = ?", 1),
+		CacheKey("", " SELECT * /* hint */ FROM users WHERE id = ? ", 1),
+	}
+	for _, key := range same {
+		if key != base {
+			t.Errorf("Expected formatting to be ignored, got %s and %s", base, key)
+		}
+	}
+
+	different := []string{
+		CacheKey("", "SELECT * FROM users WHERE id = ?", 2),
+		CacheKey("", "SELECT * FROM users WHERE id = ?", "1"),
+		CacheKey("", "select * from users where id = ?", 1),
+		CacheKey("", "SELECT * FROM users WHERE id = ? AND name = 'a  b'", 1),
+		CacheKey("", "SELECT * FROM users WHERE id = ? AND name = 'a b'", 1),
+		CacheKey("", "SELECT * FROM users WHERE id = ?", 1, nil),
+	}
+	seen := map[string]bool{base: true}
+	for _, key := range different {
+		if seen[key] {
+			t.Errorf("Expected a distinct key, got %s again", key)
+		}
+		seen[key] = true
+	}
+
+	if key := CacheKey("tenant1", "SELECT 1"); !strings.HasPrefix(key, "tenant1:sql:") {
+		t.Errorf("Expected the namespace prefix, got %s", key)
+	}
+}
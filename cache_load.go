@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// getOrLoad is the read path shared by GetOrLoad, QueryCached and idempotent
+// requests: lookup answers a hit, and concurrent misses of key run load once
+// through group. cached is set for a hit and for a result shared with
+// another caller. A lookup may hit with an error, for cached failures.
+func getOrLoad[T any](ctx context.Context, group *flightGroup[T], key string, lookup func() (T, bool, error), load func() (T, error)) (value T, cached bool, err error) {
+	if lookup != nil {
+		if value, ok, err := lookup(); ok {
+			return value, true, err
+		}
+	}
+	return group.do(ctx, key, load)
+}
+
+// LoadingCache is a Cache that can load a missing entry itself, running the
+// loader once for concurrent misses of a key
+type LoadingCache interface {
+	Cache
+	GetOrLoad(ctx context.Context, key string, ttl time.Duration, load func(ctx context.Context) (interface{}, error)) (value interface{}, cached bool, err error)
+}
+
+// cacheLoads implements GetOrLoad for the caches embedding it
+type cacheLoads struct {
+	flight flightGroup[interface{}]
+}
+
+// getOrLoad returns the value of key in c, or loads and stores it with ttl.
+// Load errors are returned and not cached.
+func (l *cacheLoads) getOrLoad(ctx context.Context, c Cache, key string, ttl time.Duration, load func(ctx context.Context) (interface{}, error)) (interface{}, bool, error) {
+	lookup := func() (interface{}, bool, error) {
+		value, ok := c.Get(ctx, key)
+		return value, ok, nil
+	}
+	return getOrLoad(ctx, &l.flight, key, lookup, func() (interface{}, error) {
+		value, err := load(ctx)
+		if err == nil {
+			c.Set(ctx, key, value, ttl)
+		}
+		return value, err
+	})
+}
+
+// GetOrLoad returns the value of key, loading and caching it on a miss.
+// Concurrent misses of key share a single load.
+func (c *InMemoryCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load func(ctx context.Context) (interface{}, error)) (interface{}, bool, error) {
+	return c.loads.getOrLoad(ctx, c, key, ttl, load)
+}
+
+// GetOrLoad returns the value of key, loading and caching it on a miss.
+// Concurrent misses of key in this process share a single load.
+func (c *RedisCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load func(ctx context.Context) (interface{}, error)) (interface{}, bool, error) {
+	return c.loads.getOrLoad(ctx, c, key, ttl, load)
+}
+
+// GetOrLoad returns the value of key, loading and caching it on a miss.
+// Concurrent misses of key in this process share a single load.
+func (c *MemcachedCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load func(ctx context.Context) (interface{}, error)) (interface{}, bool, error) {
+	return c.loads.getOrLoad(ctx, c, key, ttl, load)
+}
+
+// GetOrLoad returns the value of key from either tier, loading and writing
+// it through on a miss. Concurrent misses of key share a single load.
+func (c *TieredCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load func(ctx context.Context) (interface{}, error)) (interface{}, bool, error) {
+	return c.loads.getOrLoad(ctx, c, key, ttl, load)
+}
+
+// GetOrLoad is LoadingCache.GetOrLoad for any cache. Caches that are not a
+// LoadingCache load every miss.
+func GetOrLoad(ctx context.Context, c Cache, key string, ttl time.Duration, load func(ctx context.Context) (interface{}, error)) (interface{}, bool, error) {
+	if lc, ok := c.(LoadingCache); ok {
+		return lc.GetOrLoad(ctx, key, ttl, load)
+	}
+	if value, ok := c.Get(ctx, key); ok {
+		return value, true, nil
+	}
+	value, err := load(ctx)
+	if err == nil {
+		c.Set(ctx, key, value, ttl)
+	}
+	return value, false, err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCache(10, time.Minute)
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, error) {
+		loads.Add(1)
+		<-release
+		return "value", nil
+	}
+	var wg sync.WaitGroup
+	var cached atomic.Int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, c, err := cache.GetOrLoad(ctx, "k", time.Minute, load)
+			if err != nil || v != "value" {
+				t.Errorf("unexpected result %v, %v", v, err)
+			}
+			if c {
+				cached.Add(1)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads.Load() != 1 || cached.Load() != 4 {
+		t.Errorf("Expected 1 load shared by 4 callers, got %d loads and %d cached", loads.Load(), cached.Load())
+	}
+	if v, ok := cache.Peek(ctx, "k"); !ok || v != "value" {
+		t.Error("Expected the loaded value to be cached")
+	}
+
+	// Failures are returned, not cached
+	failing := func(ctx context.Context) (interface{}, error) { return nil, errors.New("down") }
+	if _, _, err := cache.GetOrLoad(ctx, "bad", 0, failing); err == nil {
+		t.Error("Expected the load error")
+	}
+	if _, ok := cache.Peek(ctx, "bad"); ok {
+		t.Error("Expected a failed load not to be cached")
+	}
+
+	// Any cache works through the package function
+	if v, c, err := GetOrLoad(ctx, cache, "k", 0, failing); err != nil || !c || v != "value" {
+		t.Errorf("Expected a hit, got %v, %v, %v", v, c, err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxMemcachedKey is the longest key memcached accepts
+	maxMemcachedKey = 250
+	// maxMemcachedRelativeTTL is the longest expiry memcached reads as
+	// seconds from now; longer ones must be sent as a Unix time
+	maxMemcachedRelativeTTL = 30 * 24 * time.Hour
+)
+
+// MemcachedCacheConfig configures a MemcachedCache
+type MemcachedCacheConfig struct {
+	Addr string // host:port (default localhost:11211)
+
+	// KeyPrefix is prepended to every key, so applications can share a
+	// memcached without their keys colliding
+	KeyPrefix  string
+	DefaultTTL time.Duration // TTL of entries set without one (0 = no expiry)
+	Codec      Codec         // value serialization (default JSONCodec)
+
+	PoolSize    int           // connections kept open at most (default 10)
+	DialTimeout time.Duration // default 5s
+	IOTimeout   time.Duration // deadline of each command (default 3s)
+}
+
+// MemcachedCache is a Cache kept in memcached, speaking its text protocol.
+// Like RedisCache, values are serialized with the configured Codec and a
+// server error is a miss for Get and a rejected Set, counted in
+// CacheStats.Errors. Memcached expires entries with a resolution of a
+// second, so TTLs are rounded up to whole seconds.
+type MemcachedCache struct {
+	config MemcachedCacheConfig
+	pool   *cachePool
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	errors atomic.Uint64
+
+	loads cacheLoads // see GetOrLoad
+}
+
+// NewMemcachedCache creates a memcached-backed cache. Connections are
+// opened on first use.
+func NewMemcachedCache(config MemcachedCacheConfig) *MemcachedCache {
+	if config.Addr == "" {
+		config.Addr = "localhost:11211"
+	}
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = 10
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+	if config.IOTimeout <= 0 {
+		config.IOTimeout = 3 * time.Second
+	}
+	return &MemcachedCache{
+		config: config,
+		pool:   newCachePool(config.Addr, config.PoolSize, config.DialTimeout, config.IOTimeout, nil),
+	}
+}
+
+// key returns the memcached key of a cache key. Keys memcached cannot
+// store (too long, or with spaces or control characters) are hashed.
+func (c *MemcachedCache) key(key string) string {
+	key = c.config.KeyPrefix + key
+	if len(key) <= maxMemcachedKey && !strings.ContainsFunc(key, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (c *MemcachedCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	var data []byte
+	err := c.pool.do(ctx, func(conn *cacheConn) error {
+		fmt.Fprintf(conn.w, "get %s\r\n", c.key(key))
+		if err := conn.w.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(conn)
+		if err != nil || line == "END" {
+			return err
+		}
+
+		var name string
+		var flags, size int
+		if _, err := fmt.Sscanf(line, "VALUE %s %d %d", &name, &flags, &size); err != nil {
+			return fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		data = make([]byte, size+2)
+		if _, err := io.ReadFull(conn.r, data); err != nil {
+			return err
+		}
+		data = data[:size]
+		if line, err := readMemcachedLine(conn); err != nil || line != "END" {
+			return fmt.Errorf("memcached: unexpected reply %q: %v", line, err)
+		}
+		return nil
+	})
+	if err != nil {
+		c.errors.Add(1)
+		c.misses.Add(1)
+		return nil, false
+	}
+	if data == nil {
+		c.misses.Add(1)
+		return nil, false
+	}
+	value, err := c.config.Codec.Decode(data)
+	if err != nil {
+		c.errors.Add(1)
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return value, true
+}
+
+func (c *MemcachedCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	data, err := c.config.Codec.Encode(value)
+	if err != nil {
+		c.errors.Add(1)
+		return false
+	}
+	if ttl <= 0 {
+		ttl = c.config.DefaultTTL
+	}
+
+	err = c.pool.do(ctx, func(conn *cacheConn) error {
+		fmt.Fprintf(conn.w, "set %s 0 %d %d\r\n", c.key(key), memcachedExpiry(ttl, time.Now()), len(data))
+		conn.w.Write(data)
+		conn.w.WriteString("\r\n")
+		if err := conn.w.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(conn)
+		if err == nil && line != "STORED" {
+			err = &cacheServerError{server: "memcached", msg: line}
+		}
+		return err
+	})
+	if err != nil {
+		c.errors.Add(1)
+		return false
+	}
+	return true
+}
+
+func (c *MemcachedCache) Delete(ctx context.Context, key string) {
+	err := c.pool.do(ctx, func(conn *cacheConn) error {
+		fmt.Fprintf(conn.w, "delete %s\r\n", c.key(key))
+		if err := conn.w.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(conn)
+		if err == nil && line != "DELETED" && line != "NOT_FOUND" {
+			err = &cacheServerError{server: "memcached", msg: line}
+		}
+		return err
+	})
+	if err != nil {
+		c.errors.Add(1)
+	}
+}
+
+// PurgeExpired is a no-op: memcached expires entries itself
+func (c *MemcachedCache) PurgeExpired() {}
+
+// Stats returns the hit and miss counters of this instance. Items is the
+// server's item count when no KeyPrefix is set, and 0 otherwise.
+func (c *MemcachedCache) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Errors: c.errors.Load(),
+	}
+	if c.config.KeyPrefix != "" {
+		return stats
+	}
+	c.pool.do(context.Background(), func(conn *cacheConn) error {
+		fmt.Fprint(conn.w, "stats\r\n")
+		if err := conn.w.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := readMemcachedLine(conn)
+			if err != nil || line == "END" {
+				return err
+			}
+			if n, ok := strings.CutPrefix(line, "STAT curr_items "); ok {
+				stats.Items, _ = strconv.Atoi(n)
+			}
+		}
+	})
+	return stats
+}
+
+// Close closes the idle connections. The cache stays usable and reconnects
+// on the next command.
+func (c *MemcachedCache) Close() error {
+	return c.pool.closeIdle()
+}
+
+// memcachedExpiry returns the exptime of a TTL: 0 for none, seconds for up
+// to 30 days and a Unix time beyond
+func memcachedExpiry(ttl time.Duration, now time.Time) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl > maxMemcachedRelativeTTL {
+		return now.Add(ttl).Unix()
+	}
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// readMemcachedLine reads a reply line. Error replies are returned as
+// errors; a CLIENT_ERROR may leave the stream out of sync, so it is not a
+// cacheServerError and the connection gets closed.
+func readMemcachedLine(conn *cacheConn) (string, error) {
+	line, err := conn.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch {
+	case line == "ERROR", strings.HasPrefix(line, "SERVER_ERROR"):
+		return "", &cacheServerError{server: "memcached", msg: line}
+	case strings.HasPrefix(line, "CLIENT_ERROR"):
+		return "", fmt.Errorf("memcached: %s", line)
+	}
+	return line, nil
+}
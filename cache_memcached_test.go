@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached serves get, set, delete and stats from a map
+type fakeMemcached struct {
+	net.Listener
+
+	mu      sync.Mutex
+	data    map[string]string
+	expiry  map[string]int64
+	lastKey string
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := &fakeMemcached{Listener: l, data: map[string]string{}, expiry: map[string]int64{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+
+		s.mu.Lock()
+		var reply string
+		switch fields[0] {
+		case "get":
+			s.lastKey = fields[1]
+			if v, ok := s.data[fields[1]]; ok {
+				reply = fmt.Sprintf("VALUE %s 0 %d\r\n%s\r\n", fields[1], len(v), v)
+			}
+			reply += "END\r\n"
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			io.ReadFull(r, data)
+			s.data[fields[1]] = string(data[:size])
+			s.expiry[fields[1]], _ = strconv.ParseInt(fields[3], 10, 64)
+			reply = "STORED\r\n"
+		case "delete":
+			reply = "NOT_FOUND\r\n"
+			if _, ok := s.data[fields[1]]; ok {
+				delete(s.data, fields[1])
+				reply = "DELETED\r\n"
+			}
+		case "stats":
+			reply = fmt.Sprintf("STAT pid 1\r\nSTAT curr_items %d\r\nEND\r\n", len(s.data))
+		default:
+			reply = "ERROR\r\n"
+		}
+		s.mu.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+func TestMemcachedCache(t *testing.T) {
+	srv := newFakeMemcached(t)
+	ctx := context.Background()
+	cache := NewMemcachedCache(MemcachedCacheConfig{Addr: srv.Addr().String(), PoolSize: 2})
+	defer cache.Close()
+
+	if _, ok := cache.Get(ctx, "q"); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+
+	want := QueryResult{Columns: []string{"id"}, Rows: [][]interface{}{{"1"}}}
+	if !cache.Set(ctx, "q", want, 1500*time.Millisecond) {
+		t.Fatal("Set failed")
+	}
+	srv.mu.Lock()
+	expiry := srv.expiry["q"]
+	srv.mu.Unlock()
+	if expiry != 2 {
+		t.Errorf("expected the TTL rounded up to 2s, got %d", expiry)
+	}
+
+	got, ok := cache.Get(ctx, "q")
+	if !ok {
+		t.Fatal("expected a hit")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// Keys memcached cannot store are hashed
+	if !cache.Set(ctx, "SELECT * FROM users", want, 0) {
+		t.Fatal("Set with a key containing spaces failed")
+	}
+	if _, ok := cache.Get(ctx, "SELECT * FROM users"); !ok {
+		t.Error("expected a hit for a hashed key")
+	}
+	srv.mu.Lock()
+	lastKey := srv.lastKey
+	srv.mu.Unlock()
+	if !strings.HasPrefix(lastKey, "sha256:") {
+		t.Errorf("expected a hashed key, got %q", lastKey)
+	}
+
+	cache.Delete(ctx, "q")
+	if _, ok := cache.Get(ctx, "q"); ok {
+		t.Error("expected a miss after Delete")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Errors != 0 || stats.Items != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	if got := memcachedExpiry(60*24*time.Hour, now); got != now.Add(60*24*time.Hour).Unix() {
+		t.Errorf("expected a TTL over 30 days sent as a Unix time, got %d", got)
+	}
+}
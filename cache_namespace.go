@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/url"
+)
+
+type cacheNamespaceKey struct{}
+
+// WithCacheNamespace keeps the entries QueryCached reads and stores for
+// calls made with ctx in namespace, typically a tenant ID. Entries of other
+// namespaces are never returned, and FlushCacheNamespace drops a namespace
+// without touching the others.
+func WithCacheNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, cacheNamespaceKey{}, namespace)
+}
+
+// CacheNamespaceFrom returns the namespace set on ctx with
+// WithCacheNamespace
+func CacheNamespaceFrom(ctx context.Context) string {
+	namespace, _ := ctx.Value(cacheNamespaceKey{}).(string)
+	return namespace
+}
+
+// cacheNamespace returns the namespace of the QueryCached calls made with
+// ctx: the one set on ctx, else RuntimeConfig.CacheNamespace
+func (r *DBRuntime) cacheNamespace(ctx context.Context) string {
+	if namespace := CacheNamespaceFrom(ctx); namespace != "" {
+		return namespace
+	}
+	return r.config.CacheNamespace
+}
+
+// namespacedKey returns the cache key of a QueryCached key in the namespace
+// of ctx. The namespace is escaped so no namespace can spell another's key.
+func (r *DBRuntime) namespacedKey(ctx context.Context, key string) string {
+	namespace := r.cacheNamespace(ctx)
+	if namespace == "" || key == "" {
+		return key
+	}
+	return url.PathEscape(namespace) + "/" + key
+}
+
+// namespaceTag is the tag of every entry stored in namespace
+func namespaceTag(namespace string) string {
+	return "__ns__:" + namespace
+}
+
+// FlushCacheNamespace drops the QueryCached entries of namespace and
+// returns how many were cached. Like InvalidateTag it needs a TaggedCache
+// and returns 0 otherwise.
+func (r *DBRuntime) FlushCacheNamespace(ctx context.Context, namespace string) int {
+	return r.InvalidateTag(ctx, namespaceTag(namespace))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheNamespaces(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:cache_namespaces?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	acme := WithCacheNamespace(context.Background(), "acme")
+	globex := WithCacheNamespace(context.Background(), "globex")
+	lookup := func(ctx context.Context, value string) (interface{}, bool) {
+		_, rows, cached, err := runtime.QueryCached(ctx, "settings", time.Minute, "SELECT ? AS v", value)
+		if err != nil {
+			t.Fatalf("QueryCached failed: %v", err)
+		}
+		return rows[0][0], cached
+	}
+
+	lookup(acme, "a")
+	if v, cached := lookup(globex, "g"); cached || v != "g" {
+		t.Errorf("Expected globex not to read the entry of acme, got %v cached=%v", v, cached)
+	}
+	if v, cached := lookup(acme, "a"); !cached || v != "a" {
+		t.Errorf("Expected acme to hit its own entry, got %v cached=%v", v, cached)
+	}
+
+	if n := runtime.FlushCacheNamespace(context.Background(), "acme"); n != 1 {
+		t.Errorf("Expected 1 entry flushed, got %d", n)
+	}
+	if _, cached := lookup(acme, "a"); cached {
+		t.Error("Expected the acme entry to be flushed")
+	}
+	if _, cached := lookup(globex, "g"); !cached {
+		t.Error("Expected the globex entry to survive the flush of acme")
+	}
+}
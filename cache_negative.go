@@ -0,0 +1,22 @@
+package main
+
+import (
+	"slices"
+)
+
+// negativeResult is a cached QueryCached failure, served again until it
+// expires (see RuntimeConfig.NegativeCacheErrors)
+type negativeResult struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// negativeCacheable reports whether a QueryCached failure is cached
+func (r *DBRuntime) negativeCacheable(err error) bool {
+	return r.config.NegativeCacheTTL > 0 && slices.Contains(r.config.NegativeCacheErrors, ErrorCode(err))
+}
+
+// err rebuilds the failure, keeping its code for ErrorCode
+func (n negativeResult) err() error {
+	return NewDatabaseError(n.Code, n.Message, nil)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNegativeCaching(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:negative_caching?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		WithNegativeCaching(100*time.Millisecond, ErrCodeQueryFailed).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	// An empty result is cached for the negative TTL only
+	lookup := func() ([][]interface{}, bool) {
+		_, rows, cached, err := runtime.QueryCached(ctx, "user:bob", time.Minute, "SELECT id FROM users WHERE name = ?", "bob")
+		if err != nil {
+			t.Fatalf("QueryCached failed: %v", err)
+		}
+		return rows, cached
+	}
+	lookup()
+	if _, err := runtime.Exec(ctx, "INSERT INTO users (name) VALUES (?)", "bob"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if rows, cached := lookup(); !cached || len(rows) != 0 {
+		t.Errorf("Expected the cached empty result, got cached=%v rows=%v", cached, rows)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if rows, cached := lookup(); cached || len(rows) != 1 {
+		t.Errorf("Expected a fresh result after the negative TTL, got cached=%v rows=%v", cached, rows)
+	}
+
+	// So is a failure with a listed error code
+	_, _, _, err := runtime.QueryCached(ctx, "missing", time.Minute, "SELECT * FROM missing_table")
+	if err == nil {
+		t.Fatal("Expected the query of a missing table to fail")
+	}
+	_, _, cached, err := runtime.QueryCached(ctx, "missing", time.Minute, "SELECT * FROM missing_table")
+	if !cached || ErrorCode(err) != ErrCodeQueryFailed {
+		t.Errorf("Expected the cached failure, got cached=%v err=%v", cached, err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// cacheConn is a connection to a cache server
+type cacheConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// cacheServerError is an error reply from a cache server. The connection
+// that returned it is still usable.
+type cacheServerError struct {
+	server string
+	msg    string
+}
+
+func (e *cacheServerError) Error() string { return e.server + ": " + e.msg }
+
+// cachePool keeps up to size connections to a cache server, handing them
+// out one command at a time
+type cachePool struct {
+	addr        string
+	dialTimeout time.Duration
+	ioTimeout   time.Duration
+	setup       func(*cacheConn) error // run on each new connection, if set
+	slots       chan struct{}          // one per connection in use
+
+	mu   sync.Mutex
+	idle []*cacheConn
+}
+
+func newCachePool(addr string, size int, dialTimeout, ioTimeout time.Duration, setup func(*cacheConn) error) *cachePool {
+	return &cachePool{
+		addr:        addr,
+		dialTimeout: dialTimeout,
+		ioTimeout:   ioTimeout,
+		setup:       setup,
+		slots:       make(chan struct{}, size),
+	}
+}
+
+// do runs fn on a pooled connection within the I/O timeout. A connection
+// fn failed on for any reason but a server error reply is closed, since its
+// stream may be out of sync.
+func (p *cachePool) do(ctx context.Context, fn func(*cacheConn) error) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	conn, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(p.ioTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	err = fn(conn)
+	var serverErr *cacheServerError
+	if err != nil && !errors.As(err, &serverErr) {
+		conn.Close()
+		return err
+	}
+	p.put(conn)
+	return err
+}
+
+// get returns an idle connection or dials a new one
+func (p *cachePool) get(ctx context.Context) (*cacheConn, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return conn, nil
+	}
+	p.mu.Unlock()
+	return p.dial(ctx)
+}
+
+// dial opens a connection outside the pool, set up for commands
+func (p *cachePool) dial(ctx context.Context) (*cacheConn, error) {
+	dialer := net.Dialer{Timeout: p.dialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to cache server %s: %w", p.addr, err)
+	}
+	conn := &cacheConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	if p.setup != nil {
+		nc.SetDeadline(time.Now().Add(p.ioTimeout))
+		if err := p.setup(conn); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (p *cachePool) put(conn *cacheConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = append(p.idle, conn)
+}
+
+func (p *cachePool) closeIdle() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, conn := range idle {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RedisCacheConfig configures a RedisCache
+type RedisCacheConfig struct {
+	Addr     string // host:port (default localhost:6379)
+	Password string // sent with AUTH when set
+	DB       int    // database selected on each connection
+
+	// KeyPrefix is prepended to every key, so applications can share a
+	// Redis without their keys colliding
+	KeyPrefix  string
+	DefaultTTL time.Duration // TTL of entries set without one (0 = no expiry)
+	Codec      Codec         // value serialization (default JSONCodec)
+
+	PoolSize    int           // connections kept open at most (default 10)
+	DialTimeout time.Duration // default 5s
+	IOTimeout   time.Duration // deadline of each command (default 3s)
+}
+
+// RedisCache is a Cache kept in Redis, so QueryCached results and
+// idempotency records are shared by every runtime using the same server.
+// Values are serialized with the configured Codec, so their types must be
+// registered with RegisterCacheType. A Redis error is a miss for Get and a
+// rejected Set; it is counted in CacheStats.Errors.
+type RedisCache struct {
+	config RedisCacheConfig
+	pool   *cachePool
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	errors atomic.Uint64
+
+	loads cacheLoads // see GetOrLoad
+}
+
+// NewRedisCache creates a Redis-backed cache. Connections are opened on
+// first use, so an unreachable server shows up as cache errors rather than
+// a startup failure.
+func NewRedisCache(config RedisCacheConfig) *RedisCache {
+	if config.Addr == "" {
+		config.Addr = "localhost:6379"
+	}
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = 10
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+	if config.IOTimeout <= 0 {
+		config.IOTimeout = 3 * time.Second
+	}
+	c := &RedisCache{config: config}
+	c.pool = newCachePool(config.Addr, config.PoolSize, config.DialTimeout, config.IOTimeout, c.setup)
+	return c
+}
+
+// setup authenticates a new connection and selects the database
+func (c *RedisCache) setup(conn *cacheConn) error {
+	if c.config.Password != "" {
+		if _, err := redisCommand(conn, "AUTH", c.config.Password); err != nil {
+			return fmt.Errorf("redis authentication failed: %w", err)
+		}
+	}
+	if c.config.DB != 0 {
+		if _, err := redisCommand(conn, "SELECT", strconv.Itoa(c.config.DB)); err != nil {
+			return fmt.Errorf("failed to select redis database %d: %w", c.config.DB, err)
+		}
+	}
+	return nil
+}
+
+// do runs a command on a pooled connection and returns its reply: nil,
+// []byte, int64, string or []interface{}
+func (c *RedisCache) do(ctx context.Context, args ...string) (interface{}, error) {
+	var reply interface{}
+	err := c.pool.do(ctx, func(conn *cacheConn) error {
+		var err error
+		reply, err = redisCommand(conn, args...)
+		return err
+	})
+	return reply, err
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	reply, err := c.do(ctx, "GET", c.config.KeyPrefix+key)
+	if err != nil {
+		c.errors.Add(1)
+		c.misses.Add(1)
+		return nil, false
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	value, err := c.config.Codec.Decode(data)
+	if err != nil {
+		// An entry this instance cannot read is as good as missing
+		c.errors.Add(1)
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return value, true
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	return c.SetWithTags(ctx, key, value, ttl)
+}
+
+// SetWithTags stores an entry and adds its key to a Redis set per tag. A tag
+// set lives as long as its longest-lived entry, so sets of expired entries
+// do not pile up.
+func (c *RedisCache) SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) bool {
+	data, err := c.config.Codec.Encode(value)
+	if err != nil {
+		c.errors.Add(1)
+		return false
+	}
+	if ttl <= 0 {
+		ttl = c.config.DefaultTTL
+	}
+
+	args := []string{"SET", c.config.KeyPrefix + key, string(data)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	if _, err := c.do(ctx, args...); err != nil {
+		c.errors.Add(1)
+		return false
+	}
+	for _, tag := range tags {
+		if err := c.tag(ctx, c.tagKey(tag), c.config.KeyPrefix+key, ttl); err != nil {
+			// Untagged, the entry would survive InvalidateTag
+			c.Delete(ctx, key)
+			c.errors.Add(1)
+			return false
+		}
+	}
+	return true
+}
+
+// tagKey is the key of the set of keys tagged with tag
+func (c *RedisCache) tagKey(tag string) string {
+	return c.config.KeyPrefix + "__tag__:" + tag
+}
+
+// tag adds key to a tag set, extending the set's expiry to ttl
+func (c *RedisCache) tag(ctx context.Context, tagKey, key string, ttl time.Duration) error {
+	reply, err := c.do(ctx, "PTTL", tagKey)
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(ctx, "SADD", tagKey, key); err != nil {
+		return err
+	}
+	// PTTL is -2 for a new set and -1 for one without expiry
+	remaining, _ := reply.(int64)
+	switch {
+	case ttl <= 0 && remaining != -1:
+		_, err = c.do(ctx, "PERSIST", tagKey)
+	case ttl > 0 && remaining != -1 && remaining < ttl.Milliseconds():
+		_, err = c.do(ctx, "PEXPIRE", tagKey, strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	return err
+}
+
+// InvalidateTag drops the entries tagged with tag and returns how many were
+// still cached
+func (c *RedisCache) InvalidateTag(ctx context.Context, tag string) int {
+	tagKey := c.tagKey(tag)
+	reply, err := c.do(ctx, "SMEMBERS", tagKey)
+	if err != nil {
+		c.errors.Add(1)
+		return 0
+	}
+	members, _ := reply.([]interface{})
+
+	args := []string{"DEL", tagKey}
+	for _, m := range members {
+		if key, ok := m.([]byte); ok {
+			args = append(args, string(key))
+		}
+	}
+	reply, err = c.do(ctx, args...)
+	if err != nil {
+		c.errors.Add(1)
+		return 0
+	}
+	n, _ := reply.(int64)
+	return max(int(n)-1, 0) // the tag set itself
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) {
+	if _, err := c.do(ctx, "DEL", c.config.KeyPrefix+key); err != nil {
+		c.errors.Add(1)
+	}
+}
+
+// PurgeExpired is a no-op: Redis expires entries itself
+func (c *RedisCache) PurgeExpired() {}
+
+// Stats returns the hit and miss counters of this instance. Items is the
+// size of the Redis database when no KeyPrefix is set, and 0 otherwise.
+func (c *RedisCache) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Errors: c.errors.Load(),
+	}
+	if c.config.KeyPrefix == "" {
+		if n, err := c.do(context.Background(), "DBSIZE"); err == nil {
+			if size, ok := n.(int64); ok {
+				stats.Items = int(size)
+			}
+		}
+	}
+	return stats
+}
+
+// Ping checks that the Redis server is reachable
+func (c *RedisCache) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, "PING")
+	return err
+}
+
+// Close closes the idle connections. The cache stays usable and reconnects
+// on the next command.
+func (c *RedisCache) Close() error {
+	return c.pool.closeIdle()
+}
+
+// Publish sends msg to the subscribers of channel
+func (c *RedisCache) Publish(ctx context.Context, channel, msg string) error {
+	_, err := c.do(ctx, "PUBLISH", channel, msg)
+	return err
+}
+
+// Subscribe calls fn with each message published on channel, on a
+// connection of its own, until the returned stop function is called. After
+// a connection error it resubscribes with backoff and calls resubscribed,
+// if set, since messages sent in between were lost.
+func (c *RedisCache) Subscribe(channel string, fn func(msg string), resubscribed func()) (stop func()) {
+	done := make(chan struct{})
+	var mu sync.Mutex
+	var current *cacheConn
+
+	go func() {
+		backoff := 100 * time.Millisecond
+		for attempt := 0; ; attempt++ {
+			conn, err := c.pool.dial(context.Background())
+			if err == nil {
+				mu.Lock()
+				select {
+				case <-done:
+					mu.Unlock()
+					conn.Close()
+					return
+				default:
+					current = conn
+				}
+				mu.Unlock()
+
+				subscribed := func() {
+					backoff = 100 * time.Millisecond
+					if attempt > 0 && resubscribed != nil {
+						resubscribed()
+					}
+				}
+				c.receive(conn, channel, fn, subscribed)
+				conn.Close()
+			}
+
+			select {
+			case <-done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, 5*time.Second)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			close(done)
+			if current != nil {
+				// Unblocks the read of the subscriber
+				current.Close()
+			}
+		})
+	}
+}
+
+// receive subscribes conn to channel and passes its messages to fn until
+// the connection fails
+func (c *RedisCache) receive(conn *cacheConn, channel string, fn func(msg string), subscribed func()) {
+	conn.SetDeadline(time.Now().Add(c.config.IOTimeout))
+	if _, err := redisCommand(conn, "SUBSCRIBE", channel); err != nil {
+		return
+	}
+	subscribed()
+	// Messages arrive whenever they are published
+	conn.SetDeadline(time.Time{})
+	for {
+		reply, err := readRedisReply(conn.r)
+		if err != nil {
+			return
+		}
+		// A message is ["message", channel, payload]
+		if parts, ok := reply.([]interface{}); ok && len(parts) == 3 {
+			kind, _ := parts[0].([]byte)
+			payload, _ := parts[2].([]byte)
+			if string(kind) == "message" {
+				fn(string(payload))
+			}
+		}
+	}
+}
+
+// redisCommand sends a command and reads its reply
+func redisCommand(conn *cacheConn, args ...string) (interface{}, error) {
+	fmt.Fprintf(conn.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(conn.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := conn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRedisReply(conn.r)
+}
+
+// readRedisReply reads one RESP reply
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, &cacheServerError{server: "redis", msg: body}
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				var serverErr *cacheServerError
+				if !errors.As(err, &serverErr) {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
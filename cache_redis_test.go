@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the strings, sets, expiry and pub/sub commands
+// RedisCache uses from maps
+type fakeRedis struct {
+	net.Listener
+	password string
+
+	mu   sync.Mutex
+	data map[string]string
+	sets map[string]map[string]bool
+	ttls map[string]time.Duration
+	subs map[string][]net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := &fakeRedis{Listener: l, password: password, data: map[string]string{}, sets: map[string]map[string]bool{}, ttls: map[string]time.Duration{}, subs: map[string][]net.Conn{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := s.password == ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			r.ReadString('\n')
+			arg, _ := r.ReadString('\n')
+			args[i] = strings.TrimSuffix(arg, "\r\n")
+		}
+
+		s.mu.Lock()
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			authed = args[1] == s.password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required\r\n"
+		case cmd == "PING":
+			reply = "+PONG\r\n"
+		case cmd == "GET":
+			if v, ok := s.data[args[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case cmd == "SET":
+			s.data[args[1]] = args[2]
+			delete(s.ttls, args[1])
+			if len(args) == 5 && args[3] == "PX" {
+				ms, _ := strconv.Atoi(args[4])
+				s.ttls[args[1]] = time.Duration(ms) * time.Millisecond
+			}
+			reply = "+OK\r\n"
+		case cmd == "DEL":
+			n := 0
+			for _, key := range args[1:] {
+				_, isString := s.data[key]
+				_, isSet := s.sets[key]
+				if isString || isSet {
+					n++
+				}
+				delete(s.data, key)
+				delete(s.sets, key)
+				delete(s.ttls, key)
+			}
+			reply = fmt.Sprintf(":%d\r\n", n)
+		case cmd == "SADD":
+			if s.sets[args[1]] == nil {
+				s.sets[args[1]] = map[string]bool{}
+			}
+			s.sets[args[1]][args[2]] = true
+			reply = ":1\r\n"
+		case cmd == "SMEMBERS":
+			reply = fmt.Sprintf("*%d\r\n", len(s.sets[args[1]]))
+			for m := range s.sets[args[1]] {
+				reply += fmt.Sprintf("$%d\r\n%s\r\n", len(m), m)
+			}
+		case cmd == "PTTL":
+			_, isString := s.data[args[1]]
+			_, isSet := s.sets[args[1]]
+			ttl, hasTTL := s.ttls[args[1]]
+			switch {
+			case !isString && !isSet:
+				reply = ":-2\r\n"
+			case !hasTTL:
+				reply = ":-1\r\n"
+			default:
+				reply = fmt.Sprintf(":%d\r\n", ttl.Milliseconds())
+			}
+		case cmd == "PEXPIRE":
+			ms, _ := strconv.Atoi(args[2])
+			s.ttls[args[1]] = time.Duration(ms) * time.Millisecond
+			reply = ":1\r\n"
+		case cmd == "PERSIST":
+			delete(s.ttls, args[1])
+			reply = ":1\r\n"
+		case cmd == "SCAN":
+			// Only prefix patterns, answered in one batch
+			prefix := strings.TrimSuffix(strings.ReplaceAll(args[3], `\`, ""), "*")
+			var keys []string
+			for key := range s.data {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			for key := range s.sets {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			reply = fmt.Sprintf("*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+			for _, key := range keys {
+				reply += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
+			}
+		case cmd == "DBSIZE":
+			reply = fmt.Sprintf(":%d\r\n", len(s.data))
+		case cmd == "SUBSCRIBE":
+			s.subs[args[1]] = append(s.subs[args[1]], conn)
+			// Written under the lock so a message cannot cut into it
+			conn.Write([]byte(fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])))
+		case cmd == "PUBLISH":
+			msg := fmt.Sprintf("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2])
+			for _, sub := range s.subs[args[1]] {
+				sub.Write([]byte(msg))
+			}
+			reply = fmt.Sprintf(":%d\r\n", len(s.subs[args[1]]))
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		s.mu.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	srv := newFakeRedis(t, "secret")
+	ctx := context.Background()
+	cache := NewRedisCache(RedisCacheConfig{Addr: srv.Addr().String(), Password: "secret", PoolSize: 2})
+	defer cache.Close()
+
+	if err := cache.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if _, ok := cache.Get(ctx, "q"); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+
+	want := QueryResult{Columns: []string{"id"}, Rows: [][]interface{}{{"1"}}}
+	if !cache.Set(ctx, "q", want, 1500*time.Millisecond) {
+		t.Fatal("Set failed")
+	}
+	srv.mu.Lock()
+	ttl := srv.ttls["q"]
+	srv.mu.Unlock()
+	if ttl != 1500*time.Millisecond {
+		t.Errorf("expected the TTL sent as PX 1500, got %v", ttl)
+	}
+
+	// A second instance sees the entry
+	other := NewRedisCache(RedisCacheConfig{Addr: srv.Addr().String(), Password: "secret"})
+	got, ok := other.Get(ctx, "q")
+	if !ok {
+		t.Fatal("expected a hit from another instance")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// Concurrent calls share the pool
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Get(ctx, "q")
+		}()
+	}
+	wg.Wait()
+
+	cache.Delete(ctx, "q")
+	if _, ok := cache.Get(ctx, "q"); ok {
+		t.Error("expected a miss after Delete")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 10 || stats.Misses != 2 || stats.Errors != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// A wrong password is a miss and a counted error
+	bad := NewRedisCache(RedisCacheConfig{Addr: srv.Addr().String(), Password: "wrong"})
+	if bad.Set(ctx, "q", want, 0) {
+		t.Error("expected Set to fail without authentication")
+	}
+	if bad.Stats().Errors == 0 {
+		t.Error("expected the failed Set to be counted as an error")
+	}
+}
+
+func TestRedisCache_Tags(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	ctx := context.Background()
+	cache := NewRedisCache(RedisCacheConfig{Addr: srv.Addr().String(), KeyPrefix: "app:"})
+
+	qr := QueryResult{Columns: []string{"id"}}
+	cache.SetWithTags(ctx, "u1", qr, time.Minute, "users")
+	cache.SetWithTags(ctx, "u2", qr, 2*time.Minute, "users")
+	cache.SetWithTags(ctx, "o1", qr, time.Minute, "orders")
+
+	srv.mu.Lock()
+	ttl := srv.ttls["app:__tag__:users"]
+	srv.mu.Unlock()
+	if ttl != 2*time.Minute {
+		t.Errorf("Expected the tag set to live as long as its longest entry, got %v", ttl)
+	}
+
+	if n := cache.InvalidateTag(ctx, "users"); n != 2 {
+		t.Errorf("Expected 2 entries invalidated, got %d", n)
+	}
+	if _, ok := cache.Get(ctx, "u1"); ok {
+		t.Error("Expected the tagged entry to be dropped")
+	}
+	if _, ok := cache.Get(ctx, "o1"); !ok {
+		t.Error("Expected an entry with another tag to stay")
+	}
+	if n := cache.InvalidateTag(ctx, "users"); n != 0 {
+		t.Errorf("Expected nothing left to invalidate, got %d", n)
+	}
+}
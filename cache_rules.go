@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// InvalidationRule drops cache entries after each Exec or ExecBatch
+// statement matching Pattern. Keys and Tags are templates: $1 or ${name}
+// expand to submatches of Pattern, and {arg1}, {arg2}... to the arguments
+// of the statement.
+//
+//	InvalidationRule{
+//		Pattern: `(?i)^UPDATE\s+users\b`,
+//		Keys:    []string{"user:{arg2}"},
+//		Tags:    []string{"users"},
+//	}
+type InvalidationRule struct {
+	Pattern string   // regular expression matched against the statement
+	Keys    []string // QueryCached keys dropped, in the cache namespace of the call
+	Tags    []string // tags invalidated (see WithCacheTags)
+}
+
+// invalidationRule is an InvalidationRule with its pattern compiled
+type invalidationRule struct {
+	InvalidationRule
+	pattern *regexp.Regexp
+}
+
+// argPlaceholder matches the {argN} references of a rule template
+var argPlaceholder = regexp.MustCompile(`\{arg(\d+)\}`)
+
+func compileInvalidationRule(rule InvalidationRule) (invalidationRule, error) {
+	pattern, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return invalidationRule{}, fmt.Errorf("invalid invalidation rule pattern %q: %w", rule.Pattern, err)
+	}
+	return invalidationRule{InvalidationRule: rule, pattern: pattern}, nil
+}
+
+// expand fills a key or tag template for a statement. A reference to a
+// missing argument expands to nothing.
+func (rule invalidationRule) expand(template, query string, match []int, args []interface{}) string {
+	template = argPlaceholder.ReplaceAllStringFunc(template, func(ref string) string {
+		n, _ := strconv.Atoi(argPlaceholder.FindStringSubmatch(ref)[1])
+		if n < 1 || n > len(args) {
+			return ""
+		}
+		return fmt.Sprint(args[n-1])
+	})
+	return string(rule.pattern.ExpandString(nil, template, query, match))
+}
+
+// AddInvalidationRule registers a rule applied to every later Exec and
+// ExecBatch statement. Rules apply whether or not InvalidateCacheOnWrite is
+// set.
+func (r *DBRuntime) AddInvalidationRule(rule InvalidationRule) error {
+	compiled, err := compileInvalidationRule(rule)
+	if err != nil {
+		return err
+	}
+	r.rulesMu.Lock()
+	defer r.rulesMu.Unlock()
+	r.rules = append(r.rules, compiled)
+	return nil
+}
+
+// addConfiguredInvalidationRules registers RuntimeConfig.InvalidationRules.
+// ConfigBuilder.Validate rejects bad patterns; any left are logged and
+// skipped.
+func (r *DBRuntime) addConfiguredInvalidationRules() {
+	for _, rule := range r.config.InvalidationRules {
+		if err := r.AddInvalidationRule(rule); err != nil {
+			log.Printf("Skipping cache invalidation rule: %v", err)
+		}
+	}
+}
+
+// applyInvalidationRules drops the keys and tags of the rules matching a
+// statement
+func (r *DBRuntime) applyInvalidationRules(ctx context.Context, query string, args []interface{}) {
+	r.rulesMu.RLock()
+	rules := r.rules
+	r.rulesMu.RUnlock()
+
+	for _, rule := range rules {
+		match := rule.pattern.FindStringSubmatchIndex(query)
+		if match == nil {
+			continue
+		}
+		for _, key := range rule.Keys {
+			if key = rule.expand(key, query, match, args); key != "" {
+				r.cache.Delete(ctx, r.namespacedKey(ctx, key))
+			}
+		}
+		for _, tag := range rule.Tags {
+			if tag = rule.expand(tag, query, match, args); tag != "" {
+				r.InvalidateTag(ctx, tag)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInvalidationRules(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:invalidation_rules?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		WithInvalidationRules(InvalidationRule{
+			Pattern: `(?i)^UPDATE\s+users\b`,
+			Keys:    []string{"user:{arg2}"},
+		}).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+	if err := runtime.AddInvalidationRule(InvalidationRule{Pattern: `(?i)^DELETE FROM (\w+)`, Tags: []string{"table:$1"}}); err != nil {
+		t.Fatalf("AddInvalidationRule failed: %v", err)
+	}
+	if err := runtime.AddInvalidationRule(InvalidationRule{Pattern: "("}); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users (id, name) VALUES (1, 'alice'), (2, 'bob')",
+	} {
+		if _, err := runtime.Exec(ctx, stmt); err != nil {
+			t.Fatalf("Exec failed: %v", err)
+		}
+	}
+	cached := func(ctx context.Context, key string, id int) bool {
+		_, _, cached, err := runtime.QueryCached(ctx, key, time.Minute, "SELECT name FROM users WHERE id = ?", id)
+		if err != nil {
+			t.Fatalf("QueryCached failed: %v", err)
+		}
+		return cached
+	}
+	cached(ctx, "user:1", 1)
+	cached(ctx, "user:2", 2)
+
+	if _, err := runtime.Exec(ctx, "UPDATE users SET name = ? WHERE id = ?", "carol", 2); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if cached(ctx, "user:2", 2) {
+		t.Error("Expected the key of the updated user to be dropped")
+	}
+	if !cached(ctx, "user:1", 1) {
+		t.Error("Expected the other user to stay cached")
+	}
+
+	tagged := WithCacheTags(ctx, "table:users")
+	cached(tagged, "all", 1)
+	if _, err := runtime.Exec(ctx, "DELETE FROM users WHERE id = 1"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if cached(tagged, "all", 1) {
+		t.Error("Expected the tag expanded from the statement to be invalidated")
+	}
+}
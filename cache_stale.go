@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// defaultQueryCacheTTL is the freshness of a QueryCached entry set without
+// a TTL or CacheDefaultTTL, matching the in-memory cache default
+const defaultQueryCacheTTL = 300 * time.Second
+
+// revalidatingResult is a QueryCached entry stored with
+// stale-while-revalidate: it stays in the cache CacheMaxStale past
+// FreshUntil so it can be served while a refresh runs
+type revalidatingResult struct {
+	Result     QueryResult `json:"result"`
+	FreshUntil time.Time   `json:"fresh_until"`
+}
+
+// cachedQuery returns the QueryCached entry of key. An entry past its TTL
+// but within CacheMaxStale of it is returned with stale set; a cached
+// failure is returned as err.
+func (r *DBRuntime) cachedQuery(ctx context.Context, key string) (qr QueryResult, stale bool, ok bool, err error) {
+	v, ok := r.cache.Get(ctx, key)
+	if !ok {
+		return QueryResult{}, false, false, nil
+	}
+	switch entry := v.(type) {
+	case QueryResult:
+		return entry, false, true, nil
+	case negativeResult:
+		return QueryResult{}, false, true, entry.err()
+	case revalidatingResult:
+		now := time.Now()
+		if now.Before(entry.FreshUntil) {
+			return entry.Result, false, true, nil
+		}
+		// Caches expire entries with their own resolution, so the bound
+		// is checked here too
+		if now.Before(entry.FreshUntil.Add(r.config.CacheMaxStale)) {
+			return entry.Result, true, true, nil
+		}
+	}
+	return QueryResult{}, false, false, nil
+}
+
+// storeCachedQuery caches the outcome of a QueryCached query. Empty results
+// and negatively cacheable failures get NegativeCacheTTL; other failures are
+// not cached. With CacheMaxStale set a result is kept that much longer than
+// ttl, for stale-while-revalidate. CacheTTLJitter shortens the TTL by a
+// random share.
+func (r *DBRuntime) storeCachedQuery(ctx context.Context, key string, qr QueryResult, err error, ttl time.Duration) {
+	if err != nil {
+		if r.negativeCacheable(err) {
+			entry := negativeResult{Code: ErrorCode(err), Message: err.Error()}
+			_ = r.setCache(ctx, key, entry, r.jitterTTL(r.config.NegativeCacheTTL))
+		}
+		return
+	}
+	if len(qr.Rows) == 0 && r.config.NegativeCacheTTL > 0 {
+		ttl = r.config.NegativeCacheTTL
+	}
+	if r.config.CacheMaxStale <= 0 && r.config.CacheTTLJitter <= 0 {
+		// Stored as QueryResult so the entry can be serialized by a Codec
+		_ = r.setCache(ctx, key, qr, ttl)
+		return
+	}
+	if ttl <= 0 {
+		ttl = r.config.CacheDefaultTTL
+	}
+	if ttl <= 0 {
+		ttl = defaultQueryCacheTTL
+	}
+	ttl = r.jitterTTL(ttl)
+	if r.config.CacheMaxStale <= 0 {
+		_ = r.setCache(ctx, key, qr, ttl)
+		return
+	}
+	entry := revalidatingResult{Result: qr, FreshUntil: time.Now().Add(ttl)}
+	_ = r.setCache(ctx, key, entry, ttl+r.config.CacheMaxStale)
+}
+
+// revalidate refreshes a stale entry in the background. The refresh keeps
+// the values of ctx but not its cancellation, since the caller has already
+// been answered; concurrent refreshes of a key run once.
+func (r *DBRuntime) revalidate(ctx context.Context, key string, load func(ctx context.Context) (QueryResult, error)) {
+	ctx = context.WithoutCancel(ctx)
+	go r.cacheFlight.do(ctx, key, func() (QueryResult, error) {
+		return load(ctx)
+	})
+}
+
+// jitterTTL shortens ttl by a random share of up to CacheTTLJitter, so
+// entries cached at the same moment do not all expire together. The TTL
+// asked for stays the upper bound.
+func (r *DBRuntime) jitterTTL(ttl time.Duration) time.Duration {
+	jitter := min(r.config.CacheTTLJitter, 1)
+	if jitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	return ttl - time.Duration(rand.Float64()*jitter*float64(ttl))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:stale_while_revalidate?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		WithStaleWhileRevalidate(200 * time.Millisecond).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	query := func() ([][]interface{}, bool) {
+		_, rows, cached, err := runtime.QueryCached(ctx, "users:all", 50*time.Millisecond, "SELECT name FROM users")
+		if err != nil {
+			t.Fatalf("QueryCached failed: %v", err)
+		}
+		return rows, cached
+	}
+
+	query()
+	if _, err := runtime.Exec(ctx, "INSERT INTO users (name) VALUES (?)", "alice"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	time.Sleep(80 * time.Millisecond)
+
+	// The expired entry is served while it is refreshed
+	if rows, cached := query(); !cached || len(rows) != 0 {
+		t.Fatalf("Expected the stale entry, got cached=%v rows=%v", cached, rows)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		rows, cached := query()
+		if cached && len(rows) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the refreshed entry, got cached=%v rows=%v", cached, rows)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Past the max-stale bound the entry is gone
+	time.Sleep(300 * time.Millisecond)
+	if _, cached := query(); cached {
+		t.Error("Expected a miss past the max-stale bound")
+	}
+}
+
+func TestJitterTTL(t *testing.T) {
+	r := &DBRuntime{config: &RuntimeConfig{CacheTTLJitter: 0.2}}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		ttl := r.jitterTTL(time.Minute)
+		if ttl < 48*time.Second || ttl > time.Minute {
+			t.Fatalf("Expected a TTL between 48s and 1m, got %v", ttl)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("Expected the TTLs to be spread out, got %d distinct values", len(seen))
+	}
+
+	r.config.CacheTTLJitter = 0
+	if ttl := r.jitterTTL(time.Minute); ttl != time.Minute {
+		t.Errorf("Expected no jitter when disabled, got %v", ttl)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// TaggedCache is a Cache whose entries can carry tags (table names, tenant
+// IDs) so that every entry sharing a tag can be dropped in one call.
+// InMemoryCache and RedisCache implement it.
+type TaggedCache interface {
+	Cache
+	SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) bool
+	InvalidateTag(ctx context.Context, tag string) int
+}
+
+type cacheTagsKey struct{}
+
+// WithCacheTags tags the entries QueryCached stores for calls made with
+// ctx, for DBRuntime.InvalidateTag
+func WithCacheTags(ctx context.Context, tags ...string) context.Context {
+	return context.WithValue(ctx, cacheTagsKey{}, append(CacheTagsFrom(ctx), tags...))
+}
+
+// CacheTagsFrom returns the tags set on ctx with WithCacheTags
+func CacheTagsFrom(ctx context.Context) []string {
+	tags, _ := ctx.Value(cacheTagsKey{}).([]string)
+	return tags[:len(tags):len(tags)]
+}
+
+// setCache stores a cache entry with the tags of ctx, and the tag of its
+// namespace. Tags are dropped when the cache is not a TaggedCache.
+func (r *DBRuntime) setCache(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	tags := CacheTagsFrom(ctx)
+	if namespace := r.cacheNamespace(ctx); namespace != "" {
+		tags = append(tags, namespaceTag(namespace))
+	}
+	if len(tags) > 0 {
+		if tc, ok := r.cache.(TaggedCache); ok {
+			return tc.SetWithTags(ctx, key, value, ttl, tags...)
+		}
+	}
+	return r.cache.Set(ctx, key, value, ttl)
+}
+
+// InvalidateTag drops the cache entries tagged with tag (see WithCacheTags)
+// and returns how many were cached. It returns 0 when the cache does not
+// support tags.
+func (r *DBRuntime) InvalidateTag(ctx context.Context, tag string) int {
+	tc, ok := r.cache.(TaggedCache)
+	if !ok {
+		return 0
+	}
+	return tc.InvalidateTag(ctx, tag)
+}
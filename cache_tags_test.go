@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:invalidate_tag?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	tagged := WithCacheTags(ctx, "users")
+	for _, key := range []string{"users:all", "users:count"} {
+		if _, _, _, err := runtime.QueryCached(tagged, key, time.Minute, "SELECT COUNT(*) FROM users"); err != nil {
+			t.Fatalf("QueryCached failed: %v", err)
+		}
+	}
+	if _, _, _, err := runtime.QueryCached(ctx, "untagged", time.Minute, "SELECT 1"); err != nil {
+		t.Fatalf("QueryCached failed: %v", err)
+	}
+
+	if n := runtime.InvalidateTag(ctx, "users"); n != 2 {
+		t.Errorf("Expected 2 entries invalidated, got %d", n)
+	}
+	if _, _, cached, _ := runtime.QueryCached(tagged, "users:all", time.Minute, "SELECT COUNT(*) FROM users"); cached {
+		t.Error("Expected the tagged entry to be dropped")
+	}
+	if _, _, cached, _ := runtime.QueryCached(ctx, "untagged", time.Minute, "SELECT 1"); !cached {
+		t.Error("Expected the untagged entry to stay")
+	}
+}
+
+func TestInMemoryCache_Tags(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCache(2, time.Minute)
+
+	cache.SetWithTags(ctx, "u1", "alice", 0, "users")
+	cache.SetWithTags(ctx, "u2", "bob", 0, "users", "tenant:1")
+	if n := cache.InvalidateTag(ctx, "tenant:1"); n != 1 {
+		t.Errorf("Expected 1 entry invalidated, got %d", n)
+	}
+	if _, ok := cache.Get(ctx, "u1"); !ok {
+		t.Error("Expected an entry without the tag to stay")
+	}
+
+	// Retagging replaces the tags of an entry, and evicted entries leave
+	// the index
+	cache.SetWithTags(ctx, "u1", "alice", 0, "admins")
+	cache.Set(ctx, "a", 1, 0)
+	cache.Set(ctx, "b", 2, 0)
+	if n := cache.InvalidateTag(ctx, "users"); n != 0 {
+		t.Errorf("Expected no entry left with the old tag, got %d", n)
+	}
+	if len(cache.tags) != 0 {
+		t.Errorf("Expected the tag index to be empty, got %v", cache.tags)
+	}
+}
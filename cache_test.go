@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewConfiguredCache(t *testing.T) {
+	cases := []struct {
+		config RuntimeConfig
+		want   string
+	}{
+		{RuntimeConfig{}, "<nil>"},
+		{RuntimeConfig{EnableAggressiveCaching: true}, "*main.InMemoryCache"},
+		{RuntimeConfig{RedisCache: &RedisCacheConfig{}}, "*main.RedisCache"},
+		{RuntimeConfig{CacheBackend: CacheBackendRedis}, "*main.RedisCache"},
+		{RuntimeConfig{CacheBackend: CacheBackendMemcached}, "*main.MemcachedCache"},
+		{RuntimeConfig{CacheBackend: CacheBackendTiered}, "*main.TieredCache"},
+	}
+	for _, tc := range cases {
+		if got := fmt.Sprintf("%T", newConfiguredCache(&tc.config)); got != tc.want {
+			t.Errorf("backend %q: expected %s, got %s", tc.config.CacheBackend, tc.want, got)
+		}
+	}
+}
+
+func TestInMemoryCache_MaxBytes(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCache(100, time.Minute)
+	weigh := func(key string, value interface{}) int64 { return int64(len(value.(string))) }
+	cache.SetMaxBytes(100, weigh)
+
+	for i := 0; i < 5; i++ {
+		cache.Set(ctx, fmt.Sprintf("small%d", i), strings.Repeat("x", 10), 0)
+	}
+	// Too large for the whole budget: not cached, nothing evicted
+	if cache.Set(ctx, "huge", strings.Repeat("x", 101), 0) {
+		t.Error("Expected an entry larger than the budget to be rejected")
+	}
+	if stats := cache.Stats(); stats.Items != 5 || stats.Bytes != 50 {
+		t.Errorf("Expected 5 entries of 50 bytes, got %+v", stats)
+	}
+
+	// A large entry evicts the least recently used ones only
+	cache.Get(ctx, "small0")
+	cache.Set(ctx, "large", strings.Repeat("x", 80), 0)
+	if _, ok := cache.Get(ctx, "small0"); !ok {
+		t.Error("Expected the recently used entry to stay")
+	}
+	if _, ok := cache.Get(ctx, "small1"); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if stats := cache.Stats(); stats.Items != 3 || stats.Bytes != 100 || stats.Evictions != 3 {
+		t.Errorf("Expected 3 entries of 100 bytes after 3 evictions, got %+v", stats)
+	}
+
+	// Shrinking an entry frees its bytes
+	cache.Set(ctx, "large", "x", 0)
+	if stats := cache.Stats(); stats.Bytes != 21 {
+		t.Errorf("Expected 21 bytes, got %d", stats.Bytes)
+	}
+	cache.Delete(ctx, "large")
+	if stats := cache.Stats(); stats.Bytes != 20 || stats.MaxBytes != 100 {
+		t.Errorf("Expected 20 of 100 bytes, got %+v", stats)
+	}
+
+	if size := EncodedSize("k", QueryResult{Columns: []string{"id"}}); size <= cacheEntryOverhead {
+		t.Errorf("Expected the encoded value to be weighed, got %d", size)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// TieredCacheConfig configures a TieredCache
+type TieredCacheConfig struct {
+	// L1TTL bounds how long an entry stays in process memory (default 30s).
+	// It is also the longest an entry can stay stale in L1 while
+	// invalidation messages cannot be received.
+	L1TTL time.Duration
+	// Channel is the pub/sub channel of L1 invalidations (default
+	// "fluxor:cache:invalidate"). Runtimes sharing an L2 must share it.
+	Channel string
+}
+
+// CacheBus carries L1 invalidations between the runtimes sharing an L2.
+// RedisCache implements it with Redis pub/sub.
+type CacheBus interface {
+	Publish(ctx context.Context, channel, msg string) error
+	Subscribe(channel string, fn func(msg string), resubscribed func()) (stop func())
+}
+
+// tieredMessage is an invalidation published by a TieredCache: a key, or a
+// tag when Tag is set
+type tieredMessage struct {
+	Origin string `json:"o"`
+	Key    string `json:"k,omitempty"`
+	Tag    string `json:"t,omitempty"`
+}
+
+// TieredCache layers an in-process InMemoryCache (L1) over a shared cache
+// such as RedisCache (L2). Reads go through L1 to L2 and fill L1 on an L2
+// hit; writes go to L2 then L1. When L2 is a CacheBus, every Set, Delete and
+// InvalidateTag is published so the other runtimes drop their L1 copy.
+// Tags are kept by L2 only, so a tag invalidation clears L1 entirely.
+type TieredCache struct {
+	l1     *InMemoryCache
+	l2     Cache
+	bus    CacheBus // nil when L2 cannot publish
+	config TieredCacheConfig
+	origin string // tells the messages of this cache apart
+
+	mu   sync.Mutex
+	stop func() // stops the subscription, nil until first use
+
+	loads cacheLoads // see GetOrLoad
+}
+
+// NewTieredCache creates a two-tier cache. The invalidation subscription is
+// opened on first use.
+func NewTieredCache(l1 *InMemoryCache, l2 Cache, config TieredCacheConfig) *TieredCache {
+	if config.L1TTL <= 0 {
+		config.L1TTL = 30 * time.Second
+	}
+	if config.Channel == "" {
+		config.Channel = "fluxor:cache:invalidate"
+	}
+	origin := make([]byte, 8)
+	rand.Read(origin)
+
+	c := &TieredCache{l1: l1, l2: l2, config: config, origin: hex.EncodeToString(origin)}
+	c.bus, _ = l2.(CacheBus)
+	return c
+}
+
+// listen subscribes to the invalidations of the other runtimes unless
+// already subscribed
+func (c *TieredCache) listen() {
+	if c.bus == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop == nil {
+		// Invalidations missed while reconnecting could leave L1 stale
+		c.stop = c.bus.Subscribe(c.config.Channel, c.receive, c.l1.Clear)
+	}
+}
+
+// receive applies an invalidation published by another runtime
+func (c *TieredCache) receive(msg string) {
+	var m tieredMessage
+	if err := json.Unmarshal([]byte(msg), &m); err != nil || m.Origin == c.origin {
+		return
+	}
+	if m.Tag != "" {
+		c.l1.Clear()
+		return
+	}
+	c.l1.Delete(context.Background(), m.Key)
+}
+
+// publish tells the other runtimes to drop a key or tag from their L1
+func (c *TieredCache) publish(ctx context.Context, m tieredMessage) {
+	if c.bus == nil {
+		return
+	}
+	m.Origin = c.origin
+	data, _ := json.Marshal(m)
+	// A lost message leaves other L1s stale for at most L1TTL
+	c.bus.Publish(ctx, c.config.Channel, string(data))
+}
+
+func (c *TieredCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	c.listen()
+	if value, ok := c.l1.Get(ctx, key); ok {
+		return value, true
+	}
+	value, ok := c.l2.Get(ctx, key)
+	if ok {
+		c.l1.Set(ctx, key, value, c.config.L1TTL)
+	}
+	return value, ok
+}
+
+func (c *TieredCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	return c.SetWithTags(ctx, key, value, ttl)
+}
+
+// SetWithTags writes an entry through to L2, tagged there when L2 is a
+// TaggedCache
+func (c *TieredCache) SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) bool {
+	c.listen()
+	var ok bool
+	if tc, isTagged := c.l2.(TaggedCache); isTagged {
+		ok = tc.SetWithTags(ctx, key, value, ttl, tags...)
+	} else {
+		ok = c.l2.Set(ctx, key, value, ttl)
+	}
+	if !ok {
+		// L1 must not hold what L2 does not
+		c.l1.Delete(ctx, key)
+		return false
+	}
+	l1TTL := c.config.L1TTL
+	if ttl > 0 && ttl < l1TTL {
+		l1TTL = ttl
+	}
+	c.l1.Set(ctx, key, value, l1TTL)
+	c.publish(ctx, tieredMessage{Key: key})
+	return true
+}
+
+func (c *TieredCache) Delete(ctx context.Context, key string) {
+	c.listen()
+	c.l2.Delete(ctx, key)
+	c.l1.Delete(ctx, key)
+	c.publish(ctx, tieredMessage{Key: key})
+}
+
+// InvalidateTag drops the entries tagged with tag from L2 and clears every
+// L1. It returns the count of L2, or 0 when L2 is not a TaggedCache.
+func (c *TieredCache) InvalidateTag(ctx context.Context, tag string) int {
+	c.listen()
+	n := 0
+	if tc, ok := c.l2.(TaggedCache); ok {
+		n = tc.InvalidateTag(ctx, tag)
+	}
+	c.l1.Clear()
+	c.publish(ctx, tieredMessage{Tag: tag})
+	return n
+}
+
+func (c *TieredCache) PurgeExpired() {
+	c.l1.PurgeExpired()
+	c.l2.PurgeExpired()
+}
+
+// Clear empties L1. L2 is shared with other runtimes and left alone.
+func (c *TieredCache) Clear() {
+	c.l1.Clear()
+}
+
+// Stats reports the size of L1 and the lookups of both tiers: a hit in
+// either tier is a hit, and only an L2 miss is a miss
+func (c *TieredCache) Stats() CacheStats {
+	l1, l2 := c.l1.Stats(), c.l2.Stats()
+	return CacheStats{
+		Items:        l1.Items,
+		Capacity:     l1.Capacity,
+		Hits:         l1.Hits + l2.Hits,
+		Misses:       l2.Misses,
+		Evictions:    l1.Evictions + l2.Evictions,
+		ExpiredCount: l1.ExpiredCount + l2.ExpiredCount,
+		Errors:       l2.Errors,
+		Bytes:        l1.Bytes,
+		MaxBytes:     l1.MaxBytes,
+	}
+}
+
+// Close stops the invalidation subscription and clears L1, since it can no
+// longer be kept fresh. The cache stays usable and resubscribes on next use.
+func (c *TieredCache) Close() error {
+	c.mu.Lock()
+	if c.stop != nil {
+		c.stop()
+		c.stop = nil
+	}
+	c.mu.Unlock()
+	c.l1.Clear()
+
+	if closer, ok := c.l2.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTieredCache(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	ctx := context.Background()
+	newTiered := func() *TieredCache {
+		l2 := NewRedisCache(RedisCacheConfig{Addr: srv.Addr().String()})
+		c := NewTieredCache(NewInMemoryCache(10, time.Minute), l2, TieredCacheConfig{})
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	a, b := newTiered(), newTiered()
+
+	// Both subscribe on first use
+	a.Get(ctx, "q")
+	b.Get(ctx, "q")
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+		}
+	}
+	waitFor("the subscriptions", func() bool {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		return len(srv.subs["fluxor:cache:invalidate"]) == 2
+	})
+
+	v1 := QueryResult{Columns: []string{"v1"}}
+	v2 := QueryResult{Columns: []string{"v2"}}
+	a.Set(ctx, "q", v1, time.Minute)
+	if got, ok := b.Get(ctx, "q"); !ok || !reflect.DeepEqual(got, v1) {
+		t.Fatalf("Expected a read through L2, got %+v, %v", got, ok)
+	}
+	if b.l1.Stats().Items != 1 {
+		t.Fatal("Expected the L2 hit to fill L1")
+	}
+
+	// A write on a drops the copy in the L1 of b
+	a.Set(ctx, "q", v2, time.Minute)
+	waitFor("the L1 invalidation", func() bool { return b.l1.Stats().Items == 0 })
+	if got, _ := b.Get(ctx, "q"); !reflect.DeepEqual(got, v2) {
+		t.Errorf("Expected the new value, got %+v", got)
+	}
+	if _, ok := a.l1.Get(ctx, "q"); !ok {
+		t.Error("Expected a not to invalidate its own L1")
+	}
+
+	a.SetWithTags(ctx, "u", v1, time.Minute, "users")
+	b.Get(ctx, "u")
+	a.InvalidateTag(ctx, "users")
+	waitFor("the tag invalidation", func() bool { return b.l1.Stats().Items == 0 })
+	if _, ok := b.Get(ctx, "u"); ok {
+		t.Error("Expected the tagged entry to be gone from both tiers")
+	}
+
+	if stats := b.Stats(); stats.Hits != 3 || stats.Misses != 2 {
+		t.Errorf("Expected 3 hits and 2 misses, got %+v", stats)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CacheWarmupQuery is a query whose result is loaded into the cache at startup
+type CacheWarmupQuery struct {
+	Key   string        `json:"key"`
+	Query string        `json:"query"`
+	Args  []interface{} `json:"args,omitempty"`
+	TTL   time.Duration `json:"ttl_ns,omitempty"`
+	Uses  int64         `json:"uses,omitempty"` // recorded usage, used to rank entries
+}
+
+// maxWarmupCandidates bounds the number of distinct QueryCached keys tracked
+// for the warmup manifest
+const maxWarmupCandidates = 10000
+
+// warmupRecorder tracks QueryCached usage so the most used entries can be
+// written to the warmup manifest for the next run
+type warmupRecorder struct {
+	mu      sync.Mutex
+	entries map[string]*CacheWarmupQuery
+}
+
+func newWarmupRecorder() *warmupRecorder {
+	return &warmupRecorder{entries: make(map[string]*CacheWarmupQuery)}
+}
+
+// record counts a use of a cached query
+func (wr *warmupRecorder) record(key string, ttl time.Duration, query string, args []interface{}) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if e, ok := wr.entries[key]; ok {
+		e.Uses++
+		return
+	}
+	if len(wr.entries) >= maxWarmupCandidates {
+		return
+	}
+	wr.entries[key] = &CacheWarmupQuery{Key: key, Query: query, Args: args, TTL: ttl, Uses: 1}
+}
+
+// top returns the n most used entries
+func (wr *warmupRecorder) top(n int) []CacheWarmupQuery {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	entries := make([]CacheWarmupQuery, 0, len(wr.entries))
+	for _, e := range wr.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Uses != entries[j].Uses {
+			return entries[i].Uses > entries[j].Uses
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// LoadCacheWarmupManifest reads warmup queries from a JSON manifest file.
+// A missing file is not an error and yields no queries.
+func LoadCacheWarmupManifest(path string) ([]CacheWarmupQuery, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read warmup manifest: %w", err)
+	}
+
+	var queries []CacheWarmupQuery
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("failed to parse warmup manifest: %w", err)
+	}
+	return queries, nil
+}
+
+// SaveCacheWarmupManifest writes warmup queries to a JSON manifest file
+func SaveCacheWarmupManifest(path string, queries []CacheWarmupQuery) error {
+	data, err := json.MarshalIndent(queries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode warmup manifest: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write warmup manifest: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// WarmCache runs the given queries through QueryCached so their results are
+// cached. Failed queries are logged and skipped; the number of loaded
+// entries is returned.
+func (r *DBRuntime) WarmCache(ctx context.Context, queries []CacheWarmupQuery) (int, error) {
+	if r.cache == nil {
+		return 0, fmt.Errorf("cache not configured")
+	}
+
+	loaded := 0
+	for _, q := range queries {
+		if err := ctx.Err(); err != nil {
+			return loaded, err
+		}
+		if q.Key == "" || q.Query == "" {
+			continue
+		}
+		if _, _, _, err := r.QueryCached(ctx, q.Key, q.TTL, q.Query, q.Args...); err != nil {
+			log.Printf("Cache warmup query %q failed: %v", q.Key, err)
+			continue
+		}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// warmupCache loads the configured warmup queries and the manifest entries
+// from the previous run
+func (r *DBRuntime) warmupCache() {
+	queries := append([]CacheWarmupQuery(nil), r.config.CacheWarmupQueries...)
+	if r.config.CacheWarmupManifest != "" {
+		manifest, err := LoadCacheWarmupManifest(r.config.CacheWarmupManifest)
+		if err != nil {
+			log.Printf("Cache warmup: %v", err)
+		}
+		queries = append(queries, manifest...)
+	}
+	if len(queries) == 0 || r.cache == nil {
+		return
+	}
+
+	timeout := r.config.CacheWarmupTimeout
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	loaded, err := r.WarmCache(ctx, queries)
+	if err != nil {
+		log.Printf("Cache warmup stopped early: %v", err)
+	}
+	log.Printf("Cache warmup loaded %d/%d entries in %v", loaded, len(queries), time.Since(start))
+}
+
+// saveWarmupManifest records the most used cached queries for the next run
+func (r *DBRuntime) saveWarmupManifest() {
+	if r.config.CacheWarmupManifest == "" || r.config.CacheWarmupTopN <= 0 || r.warmup == nil {
+		return
+	}
+
+	top := r.warmup.top(r.config.CacheWarmupTopN)
+	if len(top) == 0 {
+		return
+	}
+	if err := SaveCacheWarmupManifest(r.config.CacheWarmupManifest, top); err != nil {
+		log.Printf("Cache warmup: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheWarmup(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "warmup.json")
+
+	newRuntime := func() *DBRuntime {
+		config := NewConfigBuilder().
+			WithDatabaseType(DatabaseTypeSQLite).
+			WithDSN("file:warmup?mode=memory&cache=shared").
+			WithInMemoryMode(true).
+			WithCacheWarmup(manifest, 10, CacheWarmupQuery{Key: "one", Query: "SELECT 1"}).
+			Build()
+		return NewDBRuntime(config)
+	}
+
+	runtime := newRuntime()
+	if runtime.IsReady() {
+		t.Error("Runtime should not be ready before Connect")
+	}
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if !runtime.IsReady() {
+		t.Error("Runtime should be ready after Connect")
+	}
+
+	ctx := context.Background()
+	if _, _, fromCache, err := runtime.QueryCached(ctx, "one", time.Minute, "SELECT 1"); err != nil || !fromCache {
+		t.Errorf("Configured warmup query should be cached, fromCache=%v err=%v", fromCache, err)
+	}
+	if _, _, _, err := runtime.QueryCached(ctx, "two", time.Minute, "SELECT ?", 2); err != nil {
+		t.Fatalf("Failed to query: %v", err)
+	}
+	runtime.Disconnect()
+
+	queries, err := LoadCacheWarmupManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(queries) != 2 || queries[0].Key != "one" {
+		t.Fatalf("Expected manifest with 'one' ranked first, got %+v", queries)
+	}
+
+	// The next run replays the manifest
+	runtime = newRuntime()
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+	if _, _, fromCache, err := runtime.QueryCached(ctx, "two", time.Minute, "SELECT ?", 2); err != nil || !fromCache {
+		t.Errorf("Manifest entry should be cached, fromCache=%v err=%v", fromCache, err)
+	}
+}
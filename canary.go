@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Canary monitor event types
+const (
+	MonitorEventCanaryPassed = "canary_passed"
+	MonitorEventCanaryFailed = "canary_failed"
+)
+
+// CanaryQuery is a query run periodically to verify the database end to end
+type CanaryQuery struct {
+	Name       string
+	Query      string
+	Args       []interface{}
+	Interval   time.Duration // default 30s
+	Timeout    time.Duration // default 10s
+	LatencySLO time.Duration // runs slower than this fail (0 disables)
+
+	// Assertions on the result. All configured assertions must hold.
+	MinRows  int                                                // minimum number of rows
+	Expected [][]interface{}                                    // exact rows, compared like VerifyParity
+	Parity   *ParityOptions                                     // tolerances used with Expected
+	Assert   func(columns []string, rows [][]interface{}) error // custom assertion
+}
+
+// CanaryResult is the outcome of a single canary run
+type CanaryResult struct {
+	Name        string        `json:"name"`
+	Passed      bool          `json:"passed"`
+	Latency     time.Duration `json:"latency_ns"`
+	SLOBreached bool          `json:"slo_breached"`
+	Error       string        `json:"error,omitempty"`
+	Timestamp   time.Time     `json:"timestamp"`
+}
+
+// CanaryStats aggregates the results of a canary
+type CanaryStats struct {
+	Runs                int64         `json:"runs"`
+	Passes              int64         `json:"passes"`
+	Failures            int64         `json:"failures"`
+	SLOBreaches         int64         `json:"slo_breaches"`
+	ConsecutiveFailures int64         `json:"consecutive_failures"`
+	AverageLatency      time.Duration `json:"average_latency_ns"`
+	LastResult          *CanaryResult `json:"last_result,omitempty"`
+	totalLatency        time.Duration
+}
+
+// CanaryScheduler runs canary queries against a runtime on their intervals
+// and reports each result to monitor callbacks
+type CanaryScheduler struct {
+	runtime   *DBRuntime
+	canaries  []CanaryQuery
+	stopChan  chan struct{}
+	callbacks []MonitorCallback
+	stats     map[string]*CanaryStats
+	mu        sync.RWMutex
+	wg        sync.WaitGroup
+	running   bool
+}
+
+// NewCanaryScheduler creates a canary scheduler
+func NewCanaryScheduler(runtime *DBRuntime, canaries ...CanaryQuery) *CanaryScheduler {
+	cs := &CanaryScheduler{
+		runtime:  runtime,
+		stopChan: make(chan struct{}),
+		stats:    make(map[string]*CanaryStats),
+	}
+	for _, c := range canaries {
+		if c.Interval <= 0 {
+			c.Interval = 30 * time.Second
+		}
+		if c.Timeout <= 0 {
+			c.Timeout = 10 * time.Second
+		}
+		cs.canaries = append(cs.canaries, c)
+		cs.stats[c.Name] = &CanaryStats{}
+	}
+	return cs
+}
+
+// AddCallback adds a callback called with a canary_passed or canary_failed
+// event after every run
+func (cs *CanaryScheduler) AddCallback(callback MonitorCallback) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.callbacks = append(cs.callbacks, callback)
+}
+
+// Start starts running the canaries, each on its own interval
+func (cs *CanaryScheduler) Start(ctx context.Context) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.running {
+		return
+	}
+	cs.running = true
+
+	for _, c := range cs.canaries {
+		cs.wg.Add(1)
+		go cs.canaryLoop(ctx, c)
+	}
+}
+
+// Stop stops the scheduler and waits for running canaries to finish
+func (cs *CanaryScheduler) Stop() {
+	cs.mu.Lock()
+	if !cs.running {
+		cs.mu.Unlock()
+		return
+	}
+	close(cs.stopChan)
+	cs.running = false
+	cs.mu.Unlock()
+
+	cs.wg.Wait()
+}
+
+// canaryLoop runs a canary every interval
+func (cs *CanaryScheduler) canaryLoop(ctx context.Context, c CanaryQuery) {
+	defer cs.wg.Done()
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cs.run(ctx, c)
+		case <-cs.stopChan:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunOnce runs every canary once and returns the results
+func (cs *CanaryScheduler) RunOnce(ctx context.Context) []CanaryResult {
+	results := make([]CanaryResult, 0, len(cs.canaries))
+	for _, c := range cs.canaries {
+		results = append(results, cs.run(ctx, c))
+	}
+	return results
+}
+
+// Stats returns the aggregated results per canary name
+func (cs *CanaryScheduler) Stats() map[string]CanaryStats {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	stats := make(map[string]CanaryStats, len(cs.stats))
+	for name, st := range cs.stats {
+		stats[name] = *st
+	}
+	return stats
+}
+
+// run executes a canary, records the result and notifies callbacks
+func (cs *CanaryScheduler) run(ctx context.Context, c CanaryQuery) CanaryResult {
+	result := cs.check(ctx, c)
+
+	cs.mu.Lock()
+	st := cs.stats[c.Name]
+	st.Runs++
+	st.totalLatency += result.Latency
+	st.AverageLatency = st.totalLatency / time.Duration(st.Runs)
+	if result.Passed {
+		st.Passes++
+		st.ConsecutiveFailures = 0
+	} else {
+		st.Failures++
+		st.ConsecutiveFailures++
+	}
+	if result.SLOBreached {
+		st.SLOBreaches++
+	}
+	last := result
+	st.LastResult = &last
+	callbacks := cs.callbacks
+	cs.mu.Unlock()
+
+	event := MonitorEvent{
+		Type:      MonitorEventCanaryPassed,
+		Timestamp: result.Timestamp,
+		Canary:    &result,
+		Message:   fmt.Sprintf("Canary %s passed in %v", c.Name, result.Latency),
+	}
+	if !result.Passed {
+		event.Type = MonitorEventCanaryFailed
+		event.Message = fmt.Sprintf("Canary %s failed: %s", c.Name, result.Error)
+	}
+	for _, callback := range callbacks {
+		callback(event)
+	}
+
+	return result
+}
+
+// check executes a canary query and evaluates its assertions
+func (cs *CanaryScheduler) check(ctx context.Context, c CanaryQuery) CanaryResult {
+	result := CanaryResult{Name: c.Name, Timestamp: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	// Canaries bypass the cache and shadow mirroring: they verify the database itself
+	start := time.Now()
+	columns, rows, err := cs.runtime.queryAll(ctx, c.Query, c.Args...)
+	result.Latency = time.Since(start)
+
+	if err == nil {
+		err = checkCanaryResult(c, columns, rows)
+	}
+	if err == nil && c.LatencySLO > 0 && result.Latency > c.LatencySLO {
+		result.SLOBreached = true
+		err = fmt.Errorf("latency %v exceeds SLO of %v", result.Latency, c.LatencySLO)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// checkCanaryResult evaluates the assertions of a canary
+func checkCanaryResult(c CanaryQuery, columns []string, rows [][]interface{}) error {
+	if len(rows) < c.MinRows {
+		return fmt.Errorf("expected at least %d rows, got %d", c.MinRows, len(rows))
+	}
+
+	if c.Expected != nil {
+		for i, row := range c.Expected {
+			if len(row) != len(columns) {
+				return fmt.Errorf("expected row %d has %d values, result has %d columns", i, len(row), len(columns))
+			}
+		}
+		report, err := CompareResults(c.Query, columns, rows, columns, c.Expected, c.Parity)
+		if err != nil {
+			return err
+		}
+		if !report.Equal() {
+			return fmt.Errorf("unexpected result: %s", report)
+		}
+	}
+
+	if c.Assert != nil {
+		return c.Assert(columns, rows)
+	}
+	return nil
+}
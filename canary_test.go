@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCanaryScheduler(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN(":memory:").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	scheduler := NewCanaryScheduler(runtime,
+		CanaryQuery{Name: "select_one", Query: "SELECT 1", Expected: [][]interface{}{{1}}},
+		CanaryQuery{Name: "wrong_value", Query: "SELECT 2", Expected: [][]interface{}{{1}}},
+		CanaryQuery{Name: "min_rows", Query: "SELECT 1 WHERE 1 = 0", MinRows: 1},
+		CanaryQuery{Name: "bad_sql", Query: "SELECT FROM"},
+	)
+
+	var failed []string
+	scheduler.AddCallback(func(event MonitorEvent) {
+		if event.Type == MonitorEventCanaryFailed {
+			failed = append(failed, event.Canary.Name)
+		}
+	})
+
+	results := scheduler.RunOnce(context.Background())
+	if len(results) != 4 || !results[0].Passed {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+	if len(failed) != 3 {
+		t.Errorf("Expected 3 failed canaries, got %v", failed)
+	}
+
+	stats := scheduler.Stats()
+	if st := stats["wrong_value"]; st.Runs != 1 || st.Failures != 1 || st.ConsecutiveFailures != 1 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+	if st := stats["select_one"]; st.Passes != 1 || st.LastResult == nil || !st.LastResult.Passed {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+}
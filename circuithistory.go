@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultCircuitHistorySize is the number of transitions kept by default
+	defaultCircuitHistorySize = 20
+
+	// MonitorEventCircuitTransition is the Monitor event of a circuit
+	// breaker state change
+	MonitorEventCircuitTransition = "circuit_breaker_transition"
+)
+
+// CircuitTransition is a circuit breaker state change
+type CircuitTransition struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// circuitHistory keeps the last transitions of a circuit breaker. It is
+// guarded by the breaker's mu.
+type circuitHistory struct {
+	transitions []CircuitTransition // ring
+	next        int
+	full        bool
+}
+
+func newCircuitHistory(size int) *circuitHistory {
+	if size <= 0 {
+		size = defaultCircuitHistorySize
+	}
+	return &circuitHistory{transitions: make([]CircuitTransition, size)}
+}
+
+func (h *circuitHistory) add(t CircuitTransition) {
+	h.transitions[h.next] = t
+	h.next = (h.next + 1) % len(h.transitions)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns the transitions, oldest first
+func (h *circuitHistory) list() []CircuitTransition {
+	if !h.full {
+		return append([]CircuitTransition(nil), h.transitions[:h.next]...)
+	}
+	list := make([]CircuitTransition, 0, len(h.transitions))
+	list = append(list, h.transitions[h.next:]...)
+	return append(list, h.transitions[:h.next]...)
+}
+
+// transition moves the breaker from one state to another, recording why.
+// It is a no-op if the breaker is no longer in from, so concurrent callers
+// seeing the same state record one transition. Called with mu held.
+func (cb *CircuitBreaker) transition(from, to int32, reason string) bool {
+	if !atomic.CompareAndSwapInt32(&cb.state, from, to) {
+		return false
+	}
+	cb.history.add(CircuitTransition{
+		Time:   time.Now(),
+		From:   circuitStateName(from),
+		To:     circuitStateName(to),
+		Reason: reason,
+	})
+	if cb.onStateChange != nil {
+		cb.onStateChange(circuitStateName(from), circuitStateName(to))
+	}
+	return true
+}
+
+// tripReason describes why a failure in the closed state opened the
+// circuit. Called with mu held.
+func (cb *CircuitBreaker) tripReason(consecutive int64) string {
+	if cb.window == nil {
+		return fmt.Sprintf("%d consecutive failures", consecutive)
+	}
+	return fmt.Sprintf("%.0f%% of the last %d calls failed (threshold %.0f%%)",
+		float64(cb.windowFailed)*100/float64(cb.windowCalls), cb.windowCalls, cb.failureRate)
+}
+
+// History returns the last state transitions, oldest first
+func (cb *CircuitBreaker) History() []CircuitTransition {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.history.list()
+}
+
+// CircuitHistory returns the last circuit breaker transitions, oldest first
+func (cg *ConnectionGate) CircuitHistory() []CircuitTransition {
+	return cg.circuitBreaker.History()
+}
+
+// CircuitBreakerHistory returns the last circuit breaker transitions, with
+// when and why each happened, oldest first
+func (r *DBRuntime) CircuitBreakerHistory() []CircuitTransition {
+	return r.gate.CircuitHistory()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/godror/godror"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// fluxorctl is a standalone operations tool. Like blobonly it does not
+// depend on the runtime package, so the parity comparison below mirrors
+// CompareResults in parity.go in a reduced form.
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `fluxorctl <command> [flags]
+
+Commands:
+  parity   run a query against two databases and report differences
+
+parity flags:
+  -a-type, -a-dsn     first database (oracle|postgres|mysql|sqlite)
+  -b-type, -b-dsn     second database
+  -query              query to run on both sides
+  -keys               comma-separated key columns (positional matching if empty)
+  -tolerance          allowed absolute numeric difference
+  -trim               ignore trailing spaces in strings
+  -null-empty         treat NULL and '' as equal
+  -max-diffs          stop after this many differences (default 100)
+  -timeout            query timeout (default 60s)`)
+}
+
+func driverName(dbType string) (string, error) {
+	switch dbType {
+	case "oracle":
+		return "godror", nil
+	case "postgres":
+		return "postgres", nil
+	case "mysql":
+		return "mysql", nil
+	case "sqlite":
+		return "sqlite3", nil
+	}
+	return "", fmt.Errorf("unsupported database type: %s", dbType)
+}
+
+func queryAll(ctx context.Context, dbType, dsn, query string) ([]string, [][]interface{}, error) {
+	driver, err := driverName(dbType)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var out [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		out = append(out, values)
+	}
+	return cols, out, rows.Err()
+}
+
+type compareOptions struct {
+	tolerance float64
+	trim      bool
+	nullEmpty bool
+}
+
+func normalize(v interface{}, o compareOptions) interface{} {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	if s, ok := v.(string); ok {
+		if o.trim {
+			s = strings.TrimRight(s, " ")
+		}
+		if o.nullEmpty && s == "" {
+			return nil
+		}
+		return s
+	}
+	return v
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}, o compareOptions) bool {
+	a, b = normalize(a, o), normalize(b, o)
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	if fa, ok := number(a); ok {
+		if fb, ok := number(b); ok {
+			return math.Abs(fa-fb) <= o.tolerance
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func rowKey(row []interface{}, idx []int, o compareOptions) string {
+	parts := make([]string, len(idx))
+	for i, j := range idx {
+		v := normalize(row[j], o)
+		if f, ok := number(v); ok {
+			parts[i] = strconv.FormatFloat(f, 'g', -1, 64)
+		} else {
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+func columnIndex(cols []string, name string) int {
+	for i, c := range cols {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func runParity(args []string) int {
+	fs := flag.NewFlagSet("parity", flag.ExitOnError)
+	aType := fs.String("a-type", "", "first database type")
+	aDSN := fs.String("a-dsn", "", "first database DSN")
+	bType := fs.String("b-type", "", "second database type")
+	bDSN := fs.String("b-dsn", "", "second database DSN")
+	query := fs.String("query", "", "query to compare")
+	keys := fs.String("keys", "", "comma-separated key columns")
+	tolerance := fs.Float64("tolerance", 0, "numeric tolerance")
+	trim := fs.Bool("trim", false, "ignore trailing spaces")
+	nullEmpty := fs.Bool("null-empty", false, "treat NULL and '' as equal")
+	maxDiffs := fs.Int("max-diffs", 100, "maximum differences to report")
+	timeout := fs.Duration("timeout", 60*time.Second, "query timeout")
+	_ = fs.Parse(args)
+
+	if *aType == "" || *bType == "" || *query == "" {
+		usage()
+		return 2
+	}
+	o := compareOptions{tolerance: *tolerance, trim: *trim, nullEmpty: *nullEmpty}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	colsA, rowsA, err := queryAll(ctx, *aType, *aDSN, *query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "database A:", err)
+		return 1
+	}
+	colsB, rowsB, err := queryAll(ctx, *bType, *bDSN, *query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "database B:", err)
+		return 1
+	}
+
+	diffs := 0
+	report := func(format string, a ...interface{}) bool {
+		diffs++
+		if diffs <= *maxDiffs {
+			fmt.Printf(format+"\n", a...)
+		}
+		return diffs < *maxDiffs
+	}
+
+	// Column mapping by case-insensitive name
+	pairs := [][2]int{}
+	for i, c := range colsA {
+		if j := columnIndex(colsB, c); j >= 0 {
+			pairs = append(pairs, [2]int{i, j})
+		} else {
+			report("column_missing_in_b column=%s", c)
+		}
+	}
+	for _, c := range colsB {
+		if columnIndex(colsA, c) < 0 {
+			report("column_missing_in_a column=%s", c)
+		}
+	}
+
+	compareRow := func(label string, ra, rb []interface{}) bool {
+		for _, p := range pairs {
+			if !equal(ra[p[0]], rb[p[1]], o) {
+				if !report("value_mismatch %s column=%s a=%v b=%v", label, colsA[p[0]], normalize(ra[p[0]], o), normalize(rb[p[1]], o)) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	if *keys == "" {
+		for i := 0; i < len(rowsA) || i < len(rowsB); i++ {
+			label := fmt.Sprintf("row=%d", i)
+			ok := true
+			switch {
+			case i >= len(rowsB):
+				ok = report("row_missing_in_b %s", label)
+			case i >= len(rowsA):
+				ok = report("row_missing_in_a %s", label)
+			default:
+				ok = compareRow(label, rowsA[i], rowsB[i])
+			}
+			if !ok {
+				break
+			}
+		}
+	} else {
+		var idxA, idxB []int
+		for _, k := range strings.Split(*keys, ",") {
+			ia, ib := columnIndex(colsA, strings.TrimSpace(k)), columnIndex(colsB, strings.TrimSpace(k))
+			if ia < 0 || ib < 0 {
+				fmt.Fprintf(os.Stderr, "key column %q missing\n", k)
+				return 1
+			}
+			idxA, idxB = append(idxA, ia), append(idxB, ib)
+		}
+		byKey := make(map[string][]interface{}, len(rowsB))
+		for _, row := range rowsB {
+			byKey[rowKey(row, idxB, o)] = row
+		}
+		for _, row := range rowsA {
+			key := rowKey(row, idxA, o)
+			rb, ok := byKey[key]
+			if !ok {
+				if !report("row_missing_in_b key=%s", key) {
+					break
+				}
+				continue
+			}
+			delete(byKey, key)
+			if !compareRow("key="+key, row, rb) {
+				break
+			}
+		}
+		for key := range byKey {
+			if !report("row_missing_in_a key=%s", key) {
+				break
+			}
+		}
+	}
+
+	fmt.Printf("rows a=%d b=%d differences=%d\n", len(rowsA), len(rowsB), diffs)
+	if diffs > 0 {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "parity":
+		os.Exit(runParity(os.Args[2:]))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
@@ -22,10 +22,7 @@ func NewConfigBuilder() *ConfigBuilder {
 // DefaultConfig returns a configuration with production-ready defaults
 func DefaultConfig() *RuntimeConfig {
 	dbType := DatabaseType(getEnv("DB_TYPE", string(DatabaseTypeSQLite)))
-	validationQuery := "SELECT 1"
-	if dbType == DatabaseTypeOracle {
-		validationQuery = "SELECT 1 FROM DUAL"
-	}
+	validationQuery := DialectFor(dbType).ValidationQuery()
 
 	dsn := getEnv("DB_DSN", "")
 	if dsn == "" && dbType == DatabaseTypeSQLite {
@@ -57,6 +54,7 @@ func DefaultConfig() *RuntimeConfig {
 		CircuitBreakerResetTimeout:    getEnvDuration("DB_CB_RESET_TIMEOUT", 60*time.Second),
 		CircuitBreakerHalfOpenTimeout: getEnvDuration("DB_CB_HALF_OPEN_TIMEOUT", 10*time.Second),
 		MaxRequestsPerSecond:          getEnvInt64("DB_MAX_REQUESTS_PER_SEC", 1000),
+		RateLimitBurst:                getEnvInt64("DB_RATE_LIMIT_BURST", 0),
 		MaxConcurrentConnections:      getEnvInt64("DB_MAX_CONCURRENT_CONNECTIONS", 100),
 
 		// Query settings
@@ -65,6 +63,9 @@ func DefaultConfig() *RuntimeConfig {
 		QueryTimeout:       getEnvDuration("DB_QUERY_TIMEOUT", 30*time.Second),
 		MaxRetries:         getEnvInt("DB_MAX_RETRIES", 3),
 		RetryBackoff:       getEnvDuration("DB_RETRY_BACKOFF", 100*time.Millisecond),
+		TransactionTimeout: getEnvDuration("DB_TX_TIMEOUT", 0),
+		ExplainSlowQueries: getEnvBool("DB_EXPLAIN_SLOW_QUERIES", false),
+		QueryLog:           QueryLogConfig{Mode: QueryLogMode(getEnv("DB_QUERY_LOG", string(QueryLogOff)))},
 
 		// Backpressure defaults (drop by default for backward compatibility)
 		BackpressureMode:    getEnv("DB_BACKPRESSURE_MODE", "drop"),
@@ -74,23 +75,29 @@ func DefaultConfig() *RuntimeConfig {
 		EnableAggressiveCaching: getEnvBool("DB_AGGRESSIVE_CACHING", false),
 		CacheDefaultTTL:         getEnvDuration("DB_CACHE_DEFAULT_TTL", 300*time.Second),
 		CacheCapacity:           getEnvInt("DB_CACHE_CAPACITY", 10000),
+		CacheMaxBytes:           getEnvInt64("DB_CACHE_MAX_BYTES", 0),
+		CacheCompressThreshold:  getEnvInt("DB_CACHE_COMPRESS_THRESHOLD", 0),
+		CacheTTLJitter:          getEnvFloat("DB_CACHE_TTL_JITTER", 0),
+		CacheKeyNamespace:       getEnv("DB_CACHE_KEY_NAMESPACE", ""),
+		CacheNamespace:          getEnv("DB_CACHE_NAMESPACE", ""),
+		CacheBackend:            CacheBackend(getEnv("DB_CACHE_BACKEND", "")),
 		InMemoryMode:            getEnvBool("DB_IN_MEMORY_MODE", false),
+
+		// Cache warmup
+		CacheWarmupManifest: getEnv("DB_CACHE_WARMUP_MANIFEST", ""),
+		CacheWarmupTopN:     getEnvInt("DB_CACHE_WARMUP_TOP_N", 0),
+		CacheWarmupTimeout:  getEnvDuration("DB_CACHE_WARMUP_TIMEOUT", 60*time.Second),
 	}
 }
 
 // WithDatabaseType sets the database type (oracle, postgres, or mysql)
 func (cb *ConfigBuilder) WithDatabaseType(dbType DatabaseType) *ConfigBuilder {
-	cb.config.DatabaseType = dbType
-	// Update validation query based on database type
-	if dbType == DatabaseTypePostgreSQL || dbType == DatabaseTypeMySQL || dbType == DatabaseTypeSQLite {
-		if cb.config.ValidationQuery == "SELECT 1 FROM DUAL" {
-			cb.config.ValidationQuery = "SELECT 1"
-		}
-	} else if dbType == DatabaseTypeOracle {
-		if cb.config.ValidationQuery == "SELECT 1" {
-			cb.config.ValidationQuery = "SELECT 1 FROM DUAL"
-		}
+	// Replace the validation query unless it was customized
+	previous := DialectFor(cb.config.DatabaseType).ValidationQuery()
+	if cb.config.ValidationQuery == previous || cb.config.ValidationQuery == "" {
+		cb.config.ValidationQuery = DialectFor(dbType).ValidationQuery()
 	}
+	cb.config.DatabaseType = dbType
 	return cb
 }
 
@@ -129,12 +136,66 @@ func (cb *ConfigBuilder) WithCircuitBreaker(maxFailures int, resetTimeout, halfO
 	return cb
 }
 
+// WithErrorRateCircuitBreaker trips the circuit breaker when more than
+// failureRate percent of the last window calls failed, once at least
+// minCalls calls are in the window, instead of on consecutive failures
+func (cb *ConfigBuilder) WithErrorRateCircuitBreaker(failureRate float64, window, minCalls int) *ConfigBuilder {
+	cb.config.CircuitBreakerMode = "error-rate"
+	cb.config.CircuitBreakerFailureRate = failureRate
+	cb.config.CircuitBreakerWindow = window
+	cb.config.CircuitBreakerMinCalls = minCalls
+	return cb
+}
+
 // WithRateLimit sets rate limiting
 func (cb *ConfigBuilder) WithRateLimit(maxRequestsPerSecond int64) *ConfigBuilder {
 	cb.config.MaxRequestsPerSecond = maxRequestsPerSecond
 	return cb
 }
 
+// WithRateLimitBurst sets how many requests the rate limiter lets through at
+// once after an idle period
+func (cb *ConfigBuilder) WithRateLimitBurst(burst int64) *ConfigBuilder {
+	cb.config.RateLimitBurst = burst
+	return cb
+}
+
+// WithIdentityRateLimit gives each identity (see WithIdentity) a token
+// bucket of rate requests per second and burst tokens, scaled by its weight
+// in weights (identities not listed have weight 1)
+func (cb *ConfigBuilder) WithIdentityRateLimit(rate, burst int64, weights map[string]float64) *ConfigBuilder {
+	cb.config.IdentityRateLimit = rate
+	cb.config.IdentityBurst = burst
+	cb.config.IdentityWeights = weights
+	return cb
+}
+
+// WithCostFunc charges each statement fn(query, args) rate limit tokens
+// instead of 1 (see StatementCosts)
+func (cb *ConfigBuilder) WithCostFunc(fn CostFunc) *ConfigBuilder {
+	cb.config.CostFunc = fn
+	return cb
+}
+
+// WithBulkheadLimit adds a named bulkhead running at most maxConcurrent
+// calls at once; calls select it with WithBulkhead
+func (cb *ConfigBuilder) WithBulkheadLimit(name string, maxConcurrent int64) *ConfigBuilder {
+	if cb.config.Bulkheads == nil {
+		cb.config.Bulkheads = make(map[string]int64)
+	}
+	cb.config.Bulkheads[name] = maxConcurrent
+	return cb
+}
+
+// WithPriorityAdmission keeps reserve connection slots free for each
+// priority level (see WithPriority); calls wait up to queueTimeout for room
+// before they are shed
+func (cb *ConfigBuilder) WithPriorityAdmission(reserve int64, queueTimeout time.Duration) *ConfigBuilder {
+	cb.config.PriorityReserve = reserve
+	cb.config.PriorityQueueTimeout = queueTimeout
+	return cb
+}
+
 // WithBackpressure configures backpressure behavior when reaching concurrency limit
 // mode: "drop" | "block" | "timeout"; timeout used only for "timeout" mode
 func (cb *ConfigBuilder) WithBackpressure(mode string, timeout time.Duration) *ConfigBuilder {
@@ -168,6 +229,125 @@ func (cb *ConfigBuilder) WithAggressiveCaching(capacity int, defaultTTL time.Dur
 	return cb
 }
 
+// WithCacheMaxBytes bounds the estimated memory of the in-memory cache
+// (see InMemoryCache.SetMaxBytes)
+func (cb *ConfigBuilder) WithCacheMaxBytes(maxBytes int64) *ConfigBuilder {
+	cb.config.CacheMaxBytes = maxBytes
+	return cb
+}
+
+// WithCacheCompression compresses cache values whose encoding is at least
+// threshold bytes, in memory and in Redis or memcached
+func (cb *ConfigBuilder) WithCacheCompression(threshold int) *ConfigBuilder {
+	cb.config.CacheCompressThreshold = threshold
+	return cb
+}
+
+// WithCacheTTLJitter shortens QueryCached TTLs by a random share of up to
+// jitter (0-1), spreading the expiry of entries cached together
+func (cb *ConfigBuilder) WithCacheTTLJitter(jitter float64) *ConfigBuilder {
+	cb.config.CacheTTLJitter = jitter
+	return cb
+}
+
+// WithCacheKeyNamespace prefixes the keys QueryCachedAuto derives, so
+// runtimes sharing a cache do not share entries
+func (cb *ConfigBuilder) WithCacheKeyNamespace(namespace string) *ConfigBuilder {
+	cb.config.CacheKeyNamespace = namespace
+	return cb
+}
+
+// WithCacheNamespace keeps the QueryCached entries of this runtime in
+// namespace, apart from those of other runtimes sharing the cache. A
+// namespace set on the context of a call takes precedence.
+func (cb *ConfigBuilder) WithCacheNamespace(namespace string) *ConfigBuilder {
+	cb.config.CacheNamespace = namespace
+	return cb
+}
+
+// WithRedisCache keeps the cache in Redis, shared with the other runtimes
+// using the same server
+func (cb *ConfigBuilder) WithRedisCache(config RedisCacheConfig) *ConfigBuilder {
+	cb.config.CacheBackend = CacheBackendRedis
+	cb.config.RedisCache = &config
+	return cb
+}
+
+// WithTieredCache keeps the cache in process memory in front of Redis. L1
+// copies are invalidated through Redis pub/sub when another runtime writes.
+func (cb *ConfigBuilder) WithTieredCache(redis RedisCacheConfig, tiers TieredCacheConfig) *ConfigBuilder {
+	cb.config.CacheBackend = CacheBackendTiered
+	cb.config.RedisCache = &redis
+	cb.config.CacheTiers = &tiers
+	return cb
+}
+
+// WithMemcachedCache keeps the cache in memcached, shared with the other
+// runtimes using the same server
+func (cb *ConfigBuilder) WithMemcachedCache(config MemcachedCacheConfig) *ConfigBuilder {
+	cb.config.CacheBackend = CacheBackendMemcached
+	cb.config.Memcached = &config
+	return cb
+}
+
+// WithCacheWarmup configures queries preloaded into the cache on Connect.
+// If manifestPath is set, its entries are replayed as well and the topN most
+// used QueryCached entries are written back to it on Disconnect.
+func (cb *ConfigBuilder) WithCacheWarmup(manifestPath string, topN int, queries ...CacheWarmupQuery) *ConfigBuilder {
+	cb.config.CacheWarmupManifest = manifestPath
+	cb.config.CacheWarmupTopN = topN
+	cb.config.CacheWarmupQueries = queries
+	return cb
+}
+
+// WithShadow mirrors percentage (0-100) of read queries to a secondary
+// runtime. With compare set, QueryCached misses are diffed against the shadow
+// result using VerifyParity's comparison rules.
+func (cb *ConfigBuilder) WithShadow(runtime *DBRuntime, percentage float64, compare bool) *ConfigBuilder {
+	cb.config.Shadow = &ShadowConfig{
+		Runtime:    runtime,
+		Percentage: percentage,
+		Compare:    compare,
+	}
+	return cb
+}
+
+// WithCacheInvalidationOnWrite makes Exec drop the QueryCached entries that
+// read the table it writes (see DBRuntime.RegisterCacheDependencies)
+func (cb *ConfigBuilder) WithCacheInvalidationOnWrite(enabled bool) *ConfigBuilder {
+	cb.config.InvalidateCacheOnWrite = enabled
+	return cb
+}
+
+// WithInvalidationRules makes Exec drop the cache keys and tags of the
+// rules matching its statements
+func (cb *ConfigBuilder) WithInvalidationRules(rules ...InvalidationRule) *ConfigBuilder {
+	cb.config.InvalidationRules = append(cb.config.InvalidationRules, rules...)
+	return cb
+}
+
+// WithStaleWhileRevalidate serves QueryCached entries up to maxStale past
+// their TTL while refreshing them in the background
+func (cb *ConfigBuilder) WithStaleWhileRevalidate(maxStale time.Duration) *ConfigBuilder {
+	cb.config.CacheMaxStale = maxStale
+	return cb
+}
+
+// WithNegativeCaching caches empty QueryCached results, and failures with
+// one of the given ErrCode* codes, for ttl
+func (cb *ConfigBuilder) WithNegativeCaching(ttl time.Duration, errorCodes ...string) *ConfigBuilder {
+	cb.config.NegativeCacheTTL = ttl
+	cb.config.NegativeCacheErrors = errorCodes
+	return cb
+}
+
+// WithPoolSampler samples the connection pool every interval, keeping the
+// last samples samples for DBRuntime.PoolSamples
+func (cb *ConfigBuilder) WithPoolSampler(interval time.Duration, samples int) *ConfigBuilder {
+	cb.config.PoolSampler = &PoolSamplerConfig{Interval: interval, Samples: samples}
+	return cb
+}
+
 // WithQuerySettings configures query-related settings
 func (cb *ConfigBuilder) WithQuerySettings(stmtCacheSize int, slowQueryThreshold, queryTimeout time.Duration) *ConfigBuilder {
 	cb.config.StmtCacheSize = stmtCacheSize
@@ -183,6 +363,33 @@ func (cb *ConfigBuilder) WithRetryPolicy(maxRetries int, backoff time.Duration)
 	return cb
 }
 
+// WithSlowQueryExplain runs EXPLAIN in the background for statements
+// slower than the slow query threshold (see DBRuntime.SlowQueries)
+func (cb *ConfigBuilder) WithSlowQueryExplain(enabled bool) *ConfigBuilder {
+	cb.config.ExplainSlowQueries = enabled
+	return cb
+}
+
+// WithQueryTagComments sends the tags set with WithQueryTags to the
+// database as a comment in front of each statement
+func (cb *ConfigBuilder) WithQueryTagComments(enabled bool) *ConfigBuilder {
+	cb.config.QueryTagComments = enabled
+	return cb
+}
+
+// WithQueryLogging configures the query logger
+func (cb *ConfigBuilder) WithQueryLogging(config QueryLogConfig) *ConfigBuilder {
+	cb.config.QueryLog = config
+	return cb
+}
+
+// WithTransactionTimeout bounds how long a transaction may stay open before
+// it is rolled back (0 disables the limit)
+func (cb *ConfigBuilder) WithTransactionTimeout(timeout time.Duration) *ConfigBuilder {
+	cb.config.TransactionTimeout = timeout
+	return cb
+}
+
 // Build returns the configured RuntimeConfig
 func (cb *ConfigBuilder) Build() *RuntimeConfig {
 	return cb.config
@@ -202,6 +409,26 @@ func (cb *ConfigBuilder) Validate() error {
 	if cb.config.MaxIdleConns <= 0 {
 		return fmt.Errorf("MaxIdleConns must be greater than 0")
 	}
+	if cb.config.DatabaseType != "" {
+		if _, ok := LookupDriver(cb.config.DatabaseType); !ok {
+			return fmt.Errorf("unsupported database type %q", cb.config.DatabaseType)
+		}
+	}
+	switch cb.config.CacheBackend {
+	case "", CacheBackendMemory, CacheBackendRedis, CacheBackendMemcached, CacheBackendTiered:
+	default:
+		return fmt.Errorf("unsupported cache backend %q", cb.config.CacheBackend)
+	}
+	for _, rule := range cb.config.InvalidationRules {
+		if _, err := compileInvalidationRule(rule); err != nil {
+			return err
+		}
+	}
+	switch cb.config.QueryLog.Mode {
+	case "", QueryLogOff, QueryLogAll, QueryLogSlow, QueryLogErrors:
+	default:
+		return fmt.Errorf("unsupported query log mode %q", cb.config.QueryLog.Mode)
+	}
 	return nil
 }
 
@@ -248,3 +475,12 @@ func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
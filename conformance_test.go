@@ -0,0 +1,571 @@
+//go:build conformance
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The conformance suite runs every runtime feature against each database
+// type and prints a support matrix. SQLite always runs in memory; the other
+// databases run when their DSN is set:
+//
+//	FLUXOR_POSTGRES_DSN, FLUXOR_MYSQL_DSN, FLUXOR_ORACLE_DSN
+//
+// Run it with `make test-conformance`, which starts the databases in
+// containers. Set FLUXOR_CONFORMANCE_REPORT to write the matrix to a file.
+
+// conformanceTarget is a database the suite runs against
+type conformanceTarget struct {
+	dbType  DatabaseType
+	dsn     string
+	runtime *DBRuntime
+	seq     int
+}
+
+// conformanceFeature is a runtime feature verified on every target. run
+// returns an error instead of failing the test so known gaps can be reported.
+type conformanceFeature struct {
+	name string
+	run  func(ctx context.Context, c *conformanceTarget) error
+}
+
+// conformanceKnownGaps lists features known not to work on a database type.
+// They are reported as unsupported instead of failing the suite; a gap that
+// starts passing is logged so it can be removed from this list.
+var conformanceKnownGaps = map[string][]DatabaseType{
+	// DatabaseBlobStorage has no Oracle schema
+	"blobs": {DatabaseTypeOracle},
+	// COPY is a PostgreSQL protocol feature
+	"copy_in": {DatabaseTypeSQLite, DatabaseTypeMySQL, DatabaseTypeOracle},
+}
+
+var conformanceFeatures = []conformanceFeature{
+	{"exec_query", conformanceExecQuery},
+	{"query_row", conformanceQueryRow},
+	{"transactions", conformanceTransactions},
+	{"retries", conformanceRetries},
+	{"bulk_insert", conformanceBulkInsert},
+	{"copy_in", conformanceCopyIn},
+	{"upsert", conformanceUpsert},
+	{"blobs", conformanceBlobs},
+	{"cached_queries", conformanceCachedQueries},
+	{"cancellation", conformanceCancellation},
+}
+
+// Support matrix cell values
+const (
+	conformancePass        = "pass"
+	conformanceFail        = "FAIL"
+	conformanceUnsupported = "unsupported"
+	conformanceNotRun      = "-"
+)
+
+func TestConformance(t *testing.T) {
+	targets := []*conformanceTarget{
+		{dbType: DatabaseTypeSQLite, dsn: "file:conformance?mode=memory&cache=shared"},
+		{dbType: DatabaseTypePostgreSQL, dsn: os.Getenv("FLUXOR_POSTGRES_DSN")},
+		{dbType: DatabaseTypeMySQL, dsn: os.Getenv("FLUXOR_MYSQL_DSN")},
+		{dbType: DatabaseTypeOracle, dsn: os.Getenv("FLUXOR_ORACLE_DSN")},
+	}
+
+	// results[feature][dbType]
+	results := make(map[string]map[DatabaseType]string)
+	for _, f := range conformanceFeatures {
+		results[f.name] = make(map[DatabaseType]string)
+	}
+
+	for _, target := range targets {
+		t.Run(string(target.dbType), func(t *testing.T) {
+			if target.dsn == "" {
+				t.Skipf("No DSN configured for %s", target.dbType)
+			}
+
+			target.runtime = NewDBRuntime(NewConfigBuilder().
+				WithDatabaseType(target.dbType).
+				WithDSN(target.dsn).
+				Build())
+			if err := target.runtime.Connect(); err != nil {
+				t.Fatalf("Failed to connect to %s: %v", target.dbType, err)
+			}
+			defer target.runtime.Disconnect()
+
+			for _, feature := range conformanceFeatures {
+				t.Run(feature.name, func(t *testing.T) {
+					err := feature.run(context.Background(), target)
+					known := isConformanceGap(feature.name, target.dbType)
+					switch {
+					case err == nil:
+						results[feature.name][target.dbType] = conformancePass
+						if known {
+							t.Logf("%s now passes on %s; remove it from conformanceKnownGaps", feature.name, target.dbType)
+						}
+					case known:
+						results[feature.name][target.dbType] = conformanceUnsupported
+						t.Skipf("Known gap: %v", err)
+					default:
+						results[feature.name][target.dbType] = conformanceFail
+						t.Error(err)
+					}
+				})
+			}
+		})
+	}
+
+	report := conformanceMatrix(targets, results)
+	t.Logf("Support matrix:\n%s", report)
+	if path := os.Getenv("FLUXOR_CONFORMANCE_REPORT"); path != "" {
+		if err := os.WriteFile(path, []byte(report), 0644); err != nil {
+			t.Errorf("Failed to write report: %v", err)
+		}
+	}
+}
+
+// conformanceMatrix renders the results as a Markdown table
+func conformanceMatrix(targets []*conformanceTarget, results map[string]map[DatabaseType]string) string {
+	var b strings.Builder
+	b.WriteString("| Feature |")
+	for _, target := range targets {
+		fmt.Fprintf(&b, " %s |", target.dbType)
+	}
+	b.WriteString("\n|---|")
+	for range targets {
+		b.WriteString("---|")
+	}
+	b.WriteString("\n")
+
+	for _, f := range conformanceFeatures {
+		fmt.Fprintf(&b, "| %s |", f.name)
+		for _, target := range targets {
+			cell := results[f.name][target.dbType]
+			if cell == "" {
+				cell = conformanceNotRun
+			}
+			fmt.Fprintf(&b, " %s |", cell)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func isConformanceGap(feature string, dbType DatabaseType) bool {
+	for _, gap := range conformanceKnownGaps[feature] {
+		if gap == dbType {
+			return true
+		}
+	}
+	return false
+}
+
+// ph returns the nth (1-based) bind placeholder of the target's dialect
+func (c *conformanceTarget) ph(n int) string {
+	switch c.dbType {
+	case DatabaseTypePostgreSQL:
+		return fmt.Sprintf("$%d", n)
+	case DatabaseTypeOracle:
+		return fmt.Sprintf(":%d", n)
+	default:
+		return "?"
+	}
+}
+
+// createTable creates a uniquely named (id, name) table and returns its name
+func (c *conformanceTarget) createTable(ctx context.Context, feature string) (string, error) {
+	c.seq++
+	table := fmt.Sprintf("conformance_%s_%d_%d", feature, time.Now().Unix()%100000, c.seq)
+
+	ddl := fmt.Sprintf("CREATE TABLE %s (id INTEGER PRIMARY KEY, name VARCHAR(100))", table)
+	if c.dbType == DatabaseTypeOracle {
+		ddl = fmt.Sprintf("CREATE TABLE %s (id NUMBER(10) PRIMARY KEY, name VARCHAR2(100))", table)
+	}
+	if _, err := c.runtime.Exec(ctx, ddl); err != nil {
+		return "", fmt.Errorf("create table: %w", err)
+	}
+	return table, nil
+}
+
+// dropTable drops a table created by createTable
+func (c *conformanceTarget) dropTable(table string) {
+	c.runtime.Exec(context.Background(), "DROP TABLE "+table)
+}
+
+// queryValue scans the single value returned by query into dest. It uses
+// Query so that features other than query_row do not depend on QueryRow.
+func (c *conformanceTarget) queryValue(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	rows, err := c.runtime.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("no rows returned")
+	}
+	return rows.Scan(dest)
+}
+
+// count returns the number of rows in table
+func (c *conformanceTarget) count(ctx context.Context, table string) (int64, error) {
+	var n int64
+	if err := c.queryValue(ctx, &n, "SELECT COUNT(*) FROM "+table); err != nil {
+		return 0, fmt.Errorf("count rows: %w", err)
+	}
+	return n, nil
+}
+
+// insert inserts a row into an (id, name) table
+func (c *conformanceTarget) insert(ctx context.Context, exec func(context.Context, string, ...interface{}) error, table string, id int, name string) error {
+	return exec(ctx, fmt.Sprintf("INSERT INTO %s (id, name) VALUES (%s, %s)", table, c.ph(1), c.ph(2)), id, name)
+}
+
+// runtimeExec adapts DBRuntime.Exec to insert
+func (c *conformanceTarget) runtimeExec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := c.runtime.Exec(ctx, query, args...)
+	return err
+}
+
+// txExec adapts AdvancedTx.Exec to insert
+func txExec(tx *AdvancedTx) func(context.Context, string, ...interface{}) error {
+	return func(ctx context.Context, query string, args ...interface{}) error {
+		_, err := tx.Exec(ctx, query, args...)
+		return err
+	}
+}
+
+func conformanceExecQuery(ctx context.Context, c *conformanceTarget) error {
+	table, err := c.createTable(ctx, "exec")
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	if err := c.insert(ctx, c.runtimeExec, table, 1, "alice"); err != nil {
+		return fmt.Errorf("insert: %w", err)
+	}
+
+	rows, err := c.runtime.Query(ctx, fmt.Sprintf("SELECT name FROM %s WHERE id = %s", table, c.ph(1)), 1)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	_, values, err := ScanAllRows(rows)
+	if err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+	if len(values) != 1 || fmt.Sprint(values[0][0]) != "alice" {
+		return fmt.Errorf("expected [[alice]], got %v", values)
+	}
+	return nil
+}
+
+func conformanceQueryRow(ctx context.Context, c *conformanceTarget) error {
+	table, err := c.createTable(ctx, "row")
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	if err := c.insert(ctx, c.runtimeExec, table, 1, "alice"); err != nil {
+		return fmt.Errorf("insert: %w", err)
+	}
+
+	var name string
+	if err := c.runtime.QueryRow(ctx, fmt.Sprintf("SELECT name FROM %s WHERE id = %s", table, c.ph(1)), 1).Scan(&name); err != nil {
+		return fmt.Errorf("query row: %w", err)
+	}
+	if name != "alice" {
+		return fmt.Errorf("expected 'alice', got %q", name)
+	}
+	return nil
+}
+
+func conformanceTransactions(ctx context.Context, c *conformanceTarget) error {
+	table, err := c.createTable(ctx, "tx")
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	tx, err := c.runtime.Begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	if err := c.insert(ctx, txExec(tx), table, 1, "rolled back"); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert in transaction: %w", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	if n, err := c.count(ctx, table); err != nil || n != 0 {
+		return fmt.Errorf("expected 0 rows after rollback, got %d (%v)", n, err)
+	}
+
+	err = NewQueryExecutor(c.runtime).Transaction(ctx, func(tx *AdvancedTx) error {
+		return c.insert(ctx, txExec(tx), table, 2, "committed")
+	})
+	if err != nil {
+		return fmt.Errorf("transaction: %w", err)
+	}
+	if n, err := c.count(ctx, table); err != nil || n != 1 {
+		return fmt.Errorf("expected 1 row after commit, got %d (%v)", n, err)
+	}
+	return nil
+}
+
+// errConformanceTransient simulates a transient failure such as a
+// serialization error
+var errConformanceTransient = errors.New("transient failure")
+
+func conformanceRetries(ctx context.Context, c *conformanceTarget) error {
+	table, err := c.createTable(ctx, "retry")
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	// The first attempt writes and then fails; its write must be rolled
+	// back so the retry commits exactly one row
+	attempts := 0
+	err = WithRetry(ctx, 3, 10*time.Millisecond, func() error {
+		attempts++
+		return NewQueryExecutor(c.runtime).Transaction(ctx, func(tx *AdvancedTx) error {
+			if err := c.insert(ctx, txExec(tx), table, 1, "retried"); err != nil {
+				return err
+			}
+			if attempts == 1 {
+				return errConformanceTransient
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+	if attempts != 2 {
+		return fmt.Errorf("expected 2 attempts, got %d", attempts)
+	}
+	if n, err := c.count(ctx, table); err != nil || n != 1 {
+		return fmt.Errorf("expected 1 row, got %d (%v)", n, err)
+	}
+	return nil
+}
+
+func conformanceBulkInsert(ctx context.Context, c *conformanceTarget) error {
+	table, err := c.createTable(ctx, "bulk")
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	const total = 500
+	err = NewQueryExecutor(c.runtime).Transaction(ctx, func(tx *AdvancedTx) error {
+		for i := 1; i <= total; i++ {
+			if err := c.insert(ctx, txExec(tx), table, i, fmt.Sprintf("row-%d", i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("bulk insert: %w", err)
+	}
+	if n, err := c.count(ctx, table); err != nil || n != total {
+		return fmt.Errorf("expected %d rows, got %d (%v)", total, n, err)
+	}
+	return nil
+}
+
+func conformanceCopyIn(ctx context.Context, c *conformanceTarget) error {
+	table, err := c.createTable(ctx, "copy")
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	const total = 5000
+	rows := make([][]interface{}, total)
+	for i := range rows {
+		rows[i] = []interface{}{i + 1, fmt.Sprintf("row-%d", i+1)}
+	}
+	copied, err := c.runtime.CopyIn(ctx, table, []string{"id", "name"}, CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	if copied != total {
+		return fmt.Errorf("expected %d rows copied, got %d", total, copied)
+	}
+	if n, err := c.count(ctx, table); err != nil || n != total {
+		return fmt.Errorf("expected %d rows, got %d (%v)", total, n, err)
+	}
+
+	// A failing row rolls back the whole copy
+	rows = [][]interface{}{{total + 1, "new"}, {1, "duplicate"}}
+	if _, err := c.runtime.CopyIn(ctx, table, []string{"id", "name"}, CopyFromRows(rows)); err == nil {
+		return fmt.Errorf("expected a duplicate key to fail the copy")
+	}
+	if n, err := c.count(ctx, table); err != nil || n != total {
+		return fmt.Errorf("expected a failed copy to load nothing, got %d rows (%v)", n, err)
+	}
+	return nil
+}
+
+func conformanceUpsert(ctx context.Context, c *conformanceTarget) error {
+	table, err := c.createTable(ctx, "upsert")
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	var upsert string
+	switch c.dbType {
+	case DatabaseTypeMySQL:
+		upsert = fmt.Sprintf("INSERT INTO %s (id, name) VALUES (?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)", table)
+	case DatabaseTypeOracle:
+		upsert = fmt.Sprintf(`MERGE INTO %s t USING (SELECT :1 AS id, :2 AS name FROM DUAL) s ON (t.id = s.id)
+			WHEN MATCHED THEN UPDATE SET t.name = s.name
+			WHEN NOT MATCHED THEN INSERT (id, name) VALUES (s.id, s.name)`, table)
+	default:
+		upsert = fmt.Sprintf("INSERT INTO %s (id, name) VALUES (%s, %s) ON CONFLICT (id) DO UPDATE SET name = excluded.name",
+			table, c.ph(1), c.ph(2))
+	}
+
+	for _, name := range []string{"first", "second"} {
+		if _, err := c.runtime.Exec(ctx, upsert, 1, name); err != nil {
+			return fmt.Errorf("upsert %s: %w", name, err)
+		}
+	}
+
+	var name string
+	if err := c.queryValue(ctx, &name, fmt.Sprintf("SELECT name FROM %s WHERE id = %s", table, c.ph(1)), 1); err != nil {
+		return fmt.Errorf("read back: %w", err)
+	}
+	if name != "second" {
+		return fmt.Errorf("expected upserted name 'second', got %q", name)
+	}
+	if n, err := c.count(ctx, table); err != nil || n != 1 {
+		return fmt.Errorf("expected 1 row, got %d (%v)", n, err)
+	}
+	return nil
+}
+
+func conformanceBlobs(ctx context.Context, c *conformanceTarget) error {
+	c.seq++
+	table := fmt.Sprintf("conformance_blobs_%d_%d", time.Now().Unix()%100000, c.seq)
+	storage, err := NewDatabaseBlobStorage(c.runtime, &BlobStorageConfig{TableName: table})
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	data := bytes.Repeat([]byte{0, 1, 2, 0xff}, 1024)
+	if err := storage.Store(ctx, "doc", data, BlobMetadata{ContentType: "application/octet-stream"}); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+
+	blob, err := storage.Retrieve(ctx, "doc")
+	if err != nil {
+		return fmt.Errorf("retrieve: %w", err)
+	}
+	if !bytes.Equal(blob.Data, data) {
+		return fmt.Errorf("retrieved %d bytes differ from the %d stored", len(blob.Data), len(data))
+	}
+
+	if err := storage.Delete(ctx, "doc"); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if exists, err := storage.Exists(ctx, "doc"); err != nil || exists {
+		return fmt.Errorf("expected blob to be deleted, exists=%v (%v)", exists, err)
+	}
+	return nil
+}
+
+func conformanceCachedQueries(ctx context.Context, c *conformanceTarget) error {
+	table, err := c.createTable(ctx, "cache")
+	if err != nil {
+		return err
+	}
+	defer c.dropTable(table)
+
+	if err := c.insert(ctx, c.runtimeExec, table, 1, "cached"); err != nil {
+		return fmt.Errorf("insert: %w", err)
+	}
+
+	// Caching is opt-in for databases other than in-memory SQLite
+	if c.runtime.Cache() == nil {
+		c.runtime.SetCache(NewInMemoryCache(1000, time.Minute))
+	}
+
+	query := "SELECT id, name FROM " + table
+	key := "conformance:" + table
+	_, first, fromCache, err := c.runtime.QueryCached(ctx, key, time.Minute, query)
+	if err != nil {
+		return fmt.Errorf("first query: %w", err)
+	}
+	if fromCache {
+		return fmt.Errorf("first query was served from the cache")
+	}
+
+	_, second, fromCache, err := c.runtime.QueryCached(ctx, key, time.Minute, query)
+	if err != nil {
+		return fmt.Errorf("second query: %w", err)
+	}
+	if !fromCache {
+		return fmt.Errorf("second query was not served from the cache")
+	}
+	if fmt.Sprint(first) != fmt.Sprint(second) {
+		return fmt.Errorf("cached rows %v differ from %v", second, first)
+	}
+	return nil
+}
+
+func conformanceCancellation(ctx context.Context, c *conformanceTarget) error {
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.runtime.Exec(cancelled, c.runtime.config.ValidationQuery); err == nil {
+		return fmt.Errorf("exec with a cancelled context succeeded")
+	}
+
+	var slow string
+	switch c.dbType {
+	case DatabaseTypePostgreSQL:
+		slow = "SELECT pg_sleep(30)"
+	case DatabaseTypeMySQL:
+		slow = "SELECT SLEEP(30)"
+	case DatabaseTypeOracle:
+		slow = "SELECT COUNT(*) FROM all_objects a, all_objects b, all_objects c"
+	default:
+		slow = "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT COUNT(*) FROM n"
+	}
+
+	deadline, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	rows, err := c.runtime.Query(deadline, slow)
+	if err == nil {
+		// Some drivers only report the cancellation while reading rows
+		_, _, err = ScanAllRows(rows)
+		rows.Close()
+	}
+	if err == nil {
+		return fmt.Errorf("slow query finished despite the deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		return fmt.Errorf("slow query took %v to cancel", elapsed)
+	}
+
+	// The runtime is still usable afterwards
+	return c.runtime.HealthCheck(ctx)
+}
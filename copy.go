@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CopySource supplies the rows of a CopyIn. Next advances to the next row
+// and returns false when there are no more rows or an error occurred;
+// Values returns the current row; Err returns the error that stopped Next.
+type CopySource interface {
+	Next() bool
+	Values() ([]interface{}, error)
+	Err() error
+}
+
+// CopyFromRows returns a CopySource over rows held in memory
+func CopyFromRows(rows [][]interface{}) CopySource {
+	return &copyFromRows{rows: rows, idx: -1}
+}
+
+type copyFromRows struct {
+	rows [][]interface{}
+	idx  int
+}
+
+func (c *copyFromRows) Next() bool {
+	c.idx++
+	return c.idx < len(c.rows)
+}
+
+func (c *copyFromRows) Values() ([]interface{}, error) {
+	return c.rows[c.idx], nil
+}
+
+func (c *copyFromRows) Err() error {
+	return nil
+}
+
+// CopyIn bulk loads rows into a PostgreSQL table with the COPY protocol,
+// which is much faster than row-by-row INSERTs. Rows are streamed from
+// source inside a single transaction, so either every row is loaded or none.
+// It returns the number of rows copied.
+//
+// CopyIn requires the lib/pq driver. It is not retried (the source cannot be
+// replayed) and runs without the runtime's query timeout; bound it with ctx.
+func (r *DBRuntime) CopyIn(ctx context.Context, table string, columns []string, source CopySource) (int64, error) {
+	if !r.IsConnected() {
+		return 0, fmt.Errorf("database not connected")
+	}
+	if r.databaseType() != DatabaseTypePostgreSQL {
+		return 0, fmt.Errorf("COPY is only supported on %s, not %s", DatabaseTypePostgreSQL, r.databaseType())
+	}
+	if info, _ := LookupDriver(DatabaseTypePostgreSQL); info.DriverName != "postgres" {
+		return 0, fmt.Errorf("COPY requires the lib/pq driver, not %s", info.DriverName)
+	}
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("at least one column is required")
+	}
+
+	adb := r.advancedDB
+	start := time.Now()
+	copied, err := ExecuteWithGate(adb.gate, ctx, func(ctx context.Context) (int64, error) {
+		return adb.copyIn(ctx, table, columns, source)
+	})
+	adb.metrics.RecordQuery(time.Since(start), err)
+	return copied, err
+}
+
+// copyIn runs a COPY FROM STDIN in a transaction
+func (adb *AdvancedDB) copyIn(ctx context.Context, table string, columns []string, source CopySource) (copied int64, err error) {
+	tx, err := adb.db.Load().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin copy transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return 0, fmt.Errorf("failed to start copy: %w", err)
+	}
+	defer stmt.Close()
+
+	for source.Next() {
+		values, err := source.Values()
+		if err != nil {
+			return copied, fmt.Errorf("copy source failed at row %d: %w", copied+1, err)
+		}
+		if len(values) != len(columns) {
+			return copied, fmt.Errorf("row %d has %d values, expected %d", copied+1, len(values), len(columns))
+		}
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return copied, fmt.Errorf("copy failed at row %d: %w", copied+1, err)
+		}
+		copied++
+	}
+	if err := source.Err(); err != nil {
+		return copied, fmt.Errorf("copy source failed: %w", err)
+	}
+
+	// An Exec without arguments flushes the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return copied, fmt.Errorf("copy failed: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return copied, fmt.Errorf("copy failed: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return copied, fmt.Errorf("failed to commit copy: %w", err)
+	}
+	return copied, nil
+}
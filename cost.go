@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// CostFunc returns how many rate limit tokens a statement consumes, so
+// expensive statements (reports, scans) use up the rate faster than cheap
+// point lookups
+type CostFunc func(query string, args []interface{}) float64
+
+type costKey struct{}
+
+// WithCost sets the rate limit cost of the gated calls made with ctx,
+// overriding RuntimeConfig.CostFunc
+func WithCost(ctx context.Context, cost float64) context.Context {
+	return context.WithValue(ctx, costKey{}, cost)
+}
+
+// CostFrom returns the rate limit cost set on ctx, 1 if none
+func CostFrom(ctx context.Context) float64 {
+	if cost, ok := ctx.Value(costKey{}).(float64); ok && cost > 0 {
+		return cost
+	}
+	return 1
+}
+
+// StatementCosts returns a CostFunc charging by statement type, keyed by
+// the upper-cased first keyword ("SELECT", "INSERT", "WITH"...). Other
+// statements cost 1.
+func StatementCosts(costs map[string]float64) CostFunc {
+	return func(query string, args []interface{}) float64 {
+		q := leadingNoise.ReplaceAllString(query, "")
+		if cost, ok := costs[strings.ToUpper(statementKeyword(q))]; ok {
+			return cost
+		}
+		return 1
+	}
+}
+
+// costHooks sets the cost of each statement on its context, unless the
+// caller set one with WithCost. The hooks run before the gate is entered.
+func costHooks(fn CostFunc) QueryHooks {
+	return QueryHooks{
+		Before: func(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+			if _, ok := ctx.Value(costKey{}).(float64); ok {
+				return nil, nil
+			}
+			return WithCost(ctx, fn(query, args)), nil
+		},
+	}
+}
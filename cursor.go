@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxOpenCursors          = 100
+	defaultMaxOpenCursorsPerClient = 10
+	defaultCursorIdleTimeout       = 5 * time.Minute
+	defaultFetchSize               = 100
+	maxFetchSize                   = 10000
+)
+
+// serverCursor is an open result set paged through by FETCH messages. It
+// holds a database connection and gate slot until it is exhausted or
+// closed. cur.mu is never held while taking s.cursorMu, or the other way
+// around.
+type serverCursor struct {
+	mu       sync.Mutex
+	rows     *Rows
+	columns  []string
+	clientIP string
+	lastUsed atomic.Int64 // unix nanoseconds, read by the idle sweeper without mu
+	closed   bool
+}
+
+// close releases the result set. The caller must hold cur.mu.
+func (cur *serverCursor) close() {
+	if !cur.closed {
+		cur.rows.Close()
+		cur.closed = true
+	}
+}
+
+// handleOpenCursor runs the query and registers a cursor over its rows
+func (s *TCPServer) handleOpenCursor(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	if err := s.reserveCursor(msg.ClientIP); err != nil {
+		return NewErrorResponseWithCode(msg.ID, ErrCodeServerBusy, err)
+	}
+
+	// The cursor outlives this message, so neither the message timeout nor
+	// the query timeout apply; idle cursors are closed instead
+	rows, err := s.runtime.QueryHeld(ctx, msg.Query, msg.Args...)
+	if err != nil {
+		s.releaseCursor(msg.ClientIP)
+		return NewErrorResponse(msg.ID, err)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		s.releaseCursor(msg.ClientIP)
+		return NewErrorResponse(msg.ID, err)
+	}
+
+	var idBytes [16]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		rows.Close()
+		s.releaseCursor(msg.ClientIP)
+		return NewErrorResponse(msg.ID, fmt.Errorf("failed to generate cursor id: %w", err))
+	}
+	id := hex.EncodeToString(idBytes[:])
+
+	cur := &serverCursor{rows: rows, columns: columns, clientIP: msg.ClientIP}
+	cur.lastUsed.Store(time.Now().UnixNano())
+	s.cursorMu.Lock()
+	s.cursors[id] = cur
+	s.cursorMu.Unlock()
+
+	return s.successResponse(msg.ID, CursorResult{CursorID: id, Columns: columns})
+}
+
+// handleFetch returns the next rows of a cursor. The cursor is closed once
+// it is exhausted.
+func (s *TCPServer) handleFetch(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	req, errResp := s.parseCursorRequest(msg)
+	if errResp != nil {
+		return errResp
+	}
+
+	cur, ok := s.lookupCursor(req.CursorID, msg.ClientIP)
+	if !ok {
+		return NewErrorResponseWithCode(msg.ID, ErrCodeNotFound, fmt.Errorf("unknown or expired cursor: %s", req.CursorID))
+	}
+
+	count := req.Count
+	if count <= 0 {
+		count = defaultFetchSize
+	}
+	if count > maxFetchSize {
+		count = maxFetchSize
+	}
+
+	resp, closed := s.fetchRows(ctx, msg, cur, req.CursorID, count)
+	if closed {
+		// Unregistered after cur.mu is released, see serverCursor
+		s.removeCursor(req.CursorID)
+	}
+	return resp
+}
+
+// fetchRows reads up to count rows from cur. closed reports that the cursor
+// was closed because it is exhausted or failed.
+func (s *TCPServer) fetchRows(ctx context.Context, msg *TCPMessage, cur *serverCursor, id string, count int) (resp *TCPResponse, closed bool) {
+	mem := s.memory.newRequest(s.config.MaxRequestMemory)
+	defer mem.release()
+
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	if cur.closed {
+		return NewErrorResponseWithCode(msg.ID, ErrCodeNotFound, fmt.Errorf("unknown or expired cursor: %s", id)), false
+	}
+	cur.lastUsed.Store(time.Now().UnixNano())
+
+	result := CursorResult{CursorID: id, Rows: make([][]interface{}, 0, count)}
+	for len(result.Rows) < count {
+		if !cur.rows.Next() {
+			err := cur.rows.Err()
+			cur.close()
+			if err != nil {
+				return NewErrorResponse(msg.ID, err), true
+			}
+			result.Done = true
+			break
+		}
+
+		row, err := scanRow(cur.rows, len(cur.columns))
+		if err == nil {
+			err = mem.reserve(estimateRowSize(row))
+		}
+		if err != nil {
+			// The rows already read cannot be returned later, so the cursor
+			// cannot continue
+			cur.close()
+			return s.queryErrorResponse(ctx, msg, err), true
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return s.successResponse(msg.ID, result), result.Done
+}
+
+// handleCloseCursor closes a cursor before it is exhausted
+func (s *TCPServer) handleCloseCursor(msg *TCPMessage) *TCPResponse {
+	req, errResp := s.parseCursorRequest(msg)
+	if errResp != nil {
+		return errResp
+	}
+
+	cur, ok := s.lookupCursor(req.CursorID, msg.ClientIP)
+	if ok {
+		s.removeCursor(req.CursorID)
+		cur.mu.Lock()
+		cur.close()
+		cur.mu.Unlock()
+	}
+
+	// Closing an unknown cursor succeeds: it may have expired or been exhausted
+	return s.successResponse(msg.ID, CursorResult{CursorID: req.CursorID, Done: true})
+}
+
+// parseCursorRequest decodes the payload of a FETCH or CLOSE_CURSOR message
+func (s *TCPServer) parseCursorRequest(msg *TCPMessage) (*CursorRequest, *TCPResponse) {
+	var req CursorRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return nil, NewErrorResponse(msg.ID, fmt.Errorf("invalid cursor payload: %w", err))
+	}
+	if req.CursorID == "" {
+		return nil, NewErrorResponse(msg.ID, fmt.Errorf("cursor_id is required"))
+	}
+	return &req, nil
+}
+
+// lookupCursor returns a cursor opened by clientIP
+func (s *TCPServer) lookupCursor(id, clientIP string) (*serverCursor, bool) {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+	cur, ok := s.cursors[id]
+	if !ok || cur.clientIP != clientIP {
+		return nil, false
+	}
+	return cur, true
+}
+
+// removeCursor unregisters a cursor
+func (s *TCPServer) removeCursor(id string) {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+	if cur, ok := s.cursors[id]; ok {
+		delete(s.cursors, id)
+		s.releaseCursorLocked(cur.clientIP)
+	}
+}
+
+// reserveCursor takes a cursor slot for clientIP. Slots are taken before
+// the query runs, so concurrent opens cannot exceed the limits.
+func (s *TCPServer) reserveCursor(clientIP string) error {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+	if s.cursorSlots >= s.maxOpenCursors() {
+		return fmt.Errorf("too many open cursors (limit %d)", s.maxOpenCursors())
+	}
+	if s.clientCursors[clientIP] >= s.maxOpenCursorsPerClient() {
+		return fmt.Errorf("too many open cursors for %s (limit %d)", clientIP, s.maxOpenCursorsPerClient())
+	}
+	s.cursorSlots++
+	s.clientCursors[clientIP]++
+	return nil
+}
+
+// releaseCursor returns a cursor slot taken by reserveCursor
+func (s *TCPServer) releaseCursor(clientIP string) {
+	s.cursorMu.Lock()
+	s.releaseCursorLocked(clientIP)
+	s.cursorMu.Unlock()
+}
+
+// releaseCursorLocked is releaseCursor with cursorMu held
+func (s *TCPServer) releaseCursorLocked(clientIP string) {
+	s.cursorSlots--
+	if s.clientCursors[clientIP]--; s.clientCursors[clientIP] <= 0 {
+		delete(s.clientCursors, clientIP)
+	}
+}
+
+// cleanupCursors closes cursors idle for longer than CursorIdleTimeout, or
+// all cursors when all is set
+func (s *TCPServer) cleanupCursors(now time.Time, all bool) {
+	idle := s.config.CursorIdleTimeout
+	if idle <= 0 {
+		idle = defaultCursorIdleTimeout
+	}
+
+	// Cursors are unregistered under cursorMu and closed after it is
+	// released, so a slow FETCH holding cur.mu blocks only its own cursor
+	var expired []*serverCursor
+	s.cursorMu.Lock()
+	for id, cur := range s.cursors {
+		if all || now.Sub(time.Unix(0, cur.lastUsed.Load())) > idle {
+			expired = append(expired, cur)
+			delete(s.cursors, id)
+			s.releaseCursorLocked(cur.clientIP)
+		}
+	}
+	s.cursorMu.Unlock()
+
+	for _, cur := range expired {
+		cur.mu.Lock()
+		cur.close()
+		cur.mu.Unlock()
+	}
+}
+
+// maxOpenCursors returns the limit on concurrently open cursors
+func (s *TCPServer) maxOpenCursors() int {
+	if s.config.MaxOpenCursors > 0 {
+		return s.config.MaxOpenCursors
+	}
+	return defaultMaxOpenCursors
+}
+
+// maxOpenCursorsPerClient returns the limit on cursors open by one client
+func (s *TCPServer) maxOpenCursorsPerClient() int {
+	if s.config.MaxOpenCursorsPerClient > 0 {
+		return s.config.MaxOpenCursorsPerClient
+	}
+	return defaultMaxOpenCursorsPerClient
+}
+
+// OpenCursors returns the number of open cursors
+func (s *TCPServer) OpenCursors() int {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+	return len(s.cursors)
+}
+
+// Cursor pages through a query result held open on the server
+type Cursor struct {
+	client  *TCPClient
+	id      string
+	Columns []string
+	done    bool
+}
+
+// OpenCursor runs a query on the server and returns a cursor over its
+// rows. Fetch the rows in pages and Close the cursor if it is abandoned
+// before the last page.
+func (c *TCPClient) OpenCursor(query string, args ...interface{}) (*Cursor, error) {
+	msg := &TCPMessage{
+		Type:  MessageTypeOpenCursor,
+		ID:    c.nextID(),
+		Query: query,
+		Args:  args,
+	}
+
+	result, err := c.cursorRequest(msg, "open cursor")
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{client: c, id: result.CursorID, Columns: result.Columns}, nil
+}
+
+// Fetch returns up to count rows (0 = server default of 100). It returns
+// io.EOF once every row has been returned.
+func (cur *Cursor) Fetch(count int) ([][]interface{}, error) {
+	if cur.done {
+		return nil, io.EOF
+	}
+
+	result, err := cur.send(MessageTypeFetch, count, "fetch")
+	if err != nil {
+		return nil, err
+	}
+	cur.done = result.Done
+	if len(result.Rows) == 0 && cur.done {
+		return nil, io.EOF
+	}
+	return result.Rows, nil
+}
+
+// Done reports whether every row has been fetched
+func (cur *Cursor) Done() bool {
+	return cur.done
+}
+
+// Close releases the cursor on the server
+func (cur *Cursor) Close() error {
+	if cur.done {
+		return nil
+	}
+	cur.done = true
+	_, err := cur.send(MessageTypeCloseCursor, 0, "close cursor")
+	return err
+}
+
+// send sends a FETCH or CLOSE_CURSOR message for the cursor
+func (cur *Cursor) send(msgType MessageType, count int, op string) (*CursorResult, error) {
+	payload, err := json.Marshal(CursorRequest{CursorID: cur.id, Count: count})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode cursor request: %w", err)
+	}
+
+	return cur.client.cursorRequest(&TCPMessage{
+		Type:    msgType,
+		ID:      cur.client.nextID(),
+		Payload: payload,
+	}, op)
+}
+
+// cursorRequest sends a cursor message and parses the result
+func (c *TCPClient) cursorRequest(msg *TCPMessage, op string) (*CursorResult, error) {
+	resp, err := c.sendAndReceive(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, ResponseError(op, resp)
+	}
+
+	var result CursorResult
+	if err := json.Unmarshal(resp.Data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTCPClient_Cursor(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:cursor?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for i := 1; i <= 25; i++ {
+		if _, err := runtime.Exec(ctx, "INSERT INTO items (id) VALUES (?)", i); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	server := NewTCPServer(&TCPServerConfig{Address: "localhost:0", Runtime: runtime, MaxOpenCursors: 2})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{Address: server.GetAddress(), Timeout: 5 * time.Second})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+	defer client.Disconnect()
+
+	cursor, err := client.OpenCursor("SELECT id FROM items ORDER BY id")
+	if err != nil {
+		t.Fatalf("OpenCursor failed: %v", err)
+	}
+	if len(cursor.Columns) != 1 || cursor.Columns[0] != "id" {
+		t.Errorf("Expected columns [id], got %v", cursor.Columns)
+	}
+
+	var pages, total int
+	for {
+		rows, err := cursor.Fetch(10)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		pages++
+		total += len(rows)
+		if len(rows) > 10 {
+			t.Errorf("Expected at most 10 rows per page, got %d", len(rows))
+		}
+	}
+	if pages != 3 || total != 25 {
+		t.Errorf("Expected 25 rows in 3 pages, got %d in %d", total, pages)
+	}
+	if server.OpenCursors() != 0 {
+		t.Errorf("Expected the exhausted cursor to be closed, %d open", server.OpenCursors())
+	}
+
+	// Abandoned cursors count against the limit until closed
+	first, err := client.OpenCursor("SELECT id FROM items")
+	if err != nil {
+		t.Fatalf("OpenCursor failed: %v", err)
+	}
+	second, err := client.OpenCursor("SELECT id FROM items")
+	if err != nil {
+		t.Fatalf("OpenCursor failed: %v", err)
+	}
+	if _, err := client.OpenCursor("SELECT id FROM items"); err == nil {
+		t.Error("Expected the open cursor limit to be enforced")
+	}
+	if err := first.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if _, err := first.Fetch(1); err != io.EOF {
+		t.Errorf("Expected io.EOF from a closed cursor, got %v", err)
+	}
+	if server.OpenCursors() != 1 {
+		t.Errorf("Expected 1 open cursor, got %d", server.OpenCursors())
+	}
+	limiter := runtime.advancedDB.gate.connectionLimiter
+	if got := limiter.CurrentConnections(); got != 1 {
+		t.Errorf("Expected the open cursor to hold a gate slot, got %d", got)
+	}
+
+	// Idle cursors are closed by the cleanup loop
+	server.cleanupCursors(time.Now().Add(time.Hour), false)
+	if _, err := second.Fetch(1); !IsNotFoundError(err) {
+		t.Errorf("Expected NOT_FOUND for an expired cursor, got %v", err)
+	}
+	if got := limiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected closed cursors to release their gate slots, got %d", got)
+	}
+
+	// Concurrent opens cannot exceed the limits, which also apply per client
+	limited := NewTCPServer(&TCPServerConfig{Address: "localhost:0", Runtime: runtime, MaxOpenCursors: 3, MaxOpenCursorsPerClient: 2})
+	var wg sync.WaitGroup
+	var opened atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := &TCPMessage{Type: MessageTypeOpenCursor, ID: fmt.Sprint(i), Query: "SELECT id FROM items", ClientIP: fmt.Sprintf("10.0.0.%d", i%4)}
+			if resp := limited.handleOpenCursor(ctx, msg); resp.Success {
+				opened.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := opened.Load(); n != 3 || limited.OpenCursors() != 3 {
+		t.Errorf("Expected 3 cursors opened, got %d (%d open)", n, limited.OpenCursors())
+	}
+	limited.cleanupCursors(time.Now(), true)
+
+	for i := 0; i < 3; i++ {
+		msg := &TCPMessage{Type: MessageTypeOpenCursor, ID: fmt.Sprint(i), Query: "SELECT id FROM items", ClientIP: "10.0.0.1"}
+		resp := limited.handleOpenCursor(ctx, msg)
+		if i < 2 && !resp.Success {
+			t.Fatalf("OpenCursor %d failed: %s", i, resp.Error)
+		}
+		if i == 2 && (resp.Success || resp.Code != ErrCodeServerBusy) {
+			t.Errorf("Expected SERVER_BUSY beyond the per-client limit, got %+v", resp)
+		}
+	}
+	limited.cleanupCursors(time.Now(), true)
+	if got := limiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected every gate slot released, got %d", got)
+	}
+}
+
+func TestCursor_FetchDuringCleanup(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:cursor_cleanup?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "INSERT INTO items (id) VALUES (1), (2)"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	server := NewTCPServer(&TCPServerConfig{Address: "localhost:0", Runtime: runtime, MaxOpenCursorsPerClient: 20})
+
+	// Idle cursors keep the sweeper busy while it holds the cursor registry
+	for i := 0; i < 20; i++ {
+		if resp := server.handleOpenCursor(ctx, &TCPMessage{Type: MessageTypeOpenCursor, ID: "idle", Query: "SELECT id FROM items", ClientIP: "10.0.1.1"}); !resp.Success {
+			t.Fatalf("OpenCursor failed: %s", resp.Error)
+		}
+	}
+
+	// Fetches reaching the end of their rows unregister the cursor while
+	// the sweeper walks the cursors; neither may wait on the other
+	done := make(chan struct{})
+	var sweeper sync.WaitGroup
+	sweeper.Add(1)
+	go func() {
+		defer sweeper.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				server.cleanupCursors(time.Now(), false)
+			}
+		}
+	}()
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				clientIP := fmt.Sprintf("10.0.0.%d", i)
+				for j := 0; j < 50; j++ {
+					resp := server.handleOpenCursor(ctx, &TCPMessage{Type: MessageTypeOpenCursor, ID: "open", Query: "SELECT id FROM items", ClientIP: clientIP})
+					if !resp.Success {
+						t.Errorf("OpenCursor failed: %s", resp.Error)
+						return
+					}
+					var result CursorResult
+					if err := json.Unmarshal(resp.Data, &result); err != nil {
+						t.Errorf("Failed to decode cursor: %v", err)
+						return
+					}
+					payload := []byte(fmt.Sprintf(`{"cursor_id":%q,"count":10}`, result.CursorID))
+					if resp := server.handleFetch(ctx, &TCPMessage{Type: MessageTypeFetch, ID: "fetch", Payload: payload, ClientIP: clientIP}); !resp.Success {
+						t.Errorf("Fetch failed: %s", resp.Error)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Fetch and cursor cleanup deadlocked")
+	}
+	close(done)
+	sweeper.Wait()
+
+	if server.OpenCursors() != 20 {
+		t.Errorf("Expected only the idle cursors open, %d open", server.OpenCursors())
+	}
+	server.cleanupCursors(time.Now(), true)
+	if got := runtime.advancedDB.gate.connectionLimiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected every gate slot released, got %d", got)
+	}
+}
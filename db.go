@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -11,12 +12,17 @@ import (
 
 // AdvancedDB provides advanced database operations beyond standard sql.DB
 type AdvancedDB struct {
-	db           *sql.DB
+	db           atomic.Pointer[sql.DB] // replaced by DBRuntime.Reload
 	gate         *ConnectionGate
 	stmtCache    *PreparedStatementCache
 	metrics      *DBMetrics
 	retryPolicy  *RetryPolicy
 	queryTimeout time.Duration
+	txTimeout    time.Duration
+	slowLog      *slowQueryLog
+	hooks        []QueryHooks
+	tagComments  bool // send query tags to the database as a comment
+	dialect      Dialect
 	mu           sync.RWMutex
 }
 
@@ -35,7 +41,10 @@ type DBMetrics struct {
 	TotalQueryTime     int64 // nanoseconds
 	SlowQueries        int64
 	SlowQueryThreshold time.Duration
-	mu                 sync.RWMutex // nolint:unused // Used for thread-safe metrics access
+	queries            map[string]*queryStat // per-fingerprint metrics, guarded by mu
+	errorCodes         map[string]int64      // failures per ErrorCode, guarded by mu
+	tagCounts          map[string]int64      // statements per name=value query tag, guarded by mu
+	mu                 sync.RWMutex
 }
 
 // RetryPolicy defines retry behavior for failed operations
@@ -50,18 +59,34 @@ type RetryPolicy struct {
 // NewAdvancedDB creates a new advanced database wrapper
 func NewAdvancedDB(db *sql.DB, gate *ConnectionGate, config *DBAdvancedConfig) *AdvancedDB {
 	adb := &AdvancedDB{
-		db:           db,
 		gate:         gate,
 		stmtCache:    NewPreparedStatementCache(config),
 		metrics:      NewDBMetrics(config),
 		retryPolicy:  NewRetryPolicy(config),
 		queryTimeout: 30 * time.Second,
 	}
+	adb.db.Store(db)
 
 	if config != nil {
 		if config.QueryTimeout > 0 {
 			adb.queryTimeout = config.QueryTimeout
 		}
+		adb.txTimeout = config.TransactionTimeout
+		adb.tagComments = config.QueryTagComments
+	}
+
+	var dbType DatabaseType
+	explain := false
+	if config != nil {
+		dbType, explain = config.DatabaseType, config.ExplainSlowQueries
+	}
+	adb.dialect = DialectFor(dbType)
+	adb.slowLog = newSlowQueryLog(adb.db.Load, gate, dbType, adb.metrics.SlowQueryThreshold, explain)
+	adb.UseQueryHooks(adb.slowLog.hooks())
+	adb.UseQueryHooks(adb.metrics.queryStatsHooks())
+	adb.UseQueryHooks(adb.metrics.queryTagHooks())
+	if config != nil && config.CostFunc != nil {
+		adb.UseQueryHooks(costHooks(config.CostFunc))
 	}
 
 	return adb
@@ -74,27 +99,40 @@ type DBAdvancedConfig struct {
 	QueryTimeout       time.Duration
 	MaxRetries         int
 	RetryBackoff       time.Duration
+	TransactionTimeout time.Duration
+	DatabaseType       DatabaseType
+	ExplainSlowQueries bool
+	QueryTagComments   bool
+	CostFunc           CostFunc
 }
 
 // Exec executes a query with advanced features
-func (adb *AdvancedDB) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+func (adb *AdvancedDB) Exec(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
 	start := time.Now()
 	defer func() {
-		adb.metrics.RecordQuery(time.Since(start), nil)
+		adb.metrics.RecordQuery(time.Since(start), err)
 	}()
 
+	ctx, done, err := runQueryHooks(ctx, adb.queryHooks(), query, args)
+	if err != nil {
+		return nil, err
+	}
+
 	// Apply query timeout
 	ctx, cancel := context.WithTimeout(ctx, adb.queryTimeout)
 	defer cancel()
 
 	// Execute with gate protection and retry
-	return ExecuteWithGate(adb.gate, ctx, func(ctx context.Context) (sql.Result, error) {
+	result, err = ExecuteWithGate(adb.gate, ctx, func(ctx context.Context) (sql.Result, error) {
 		return adb.retryExec(ctx, query, args...)
 	})
+	done(err)
+	return result, err
 }
 
 // retryExec executes with retry logic
 func (adb *AdvancedDB) retryExec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query = adb.taggedQuery(ctx, query)
 	var lastErr error
 	backoff := adb.retryPolicy.InitialBackoff
 
@@ -111,7 +149,7 @@ func (adb *AdvancedDB) retryExec(ctx context.Context, query string, args ...inte
 			}
 		}
 
-		result, err := adb.db.ExecContext(ctx, query, args...)
+		result, err := adb.db.Load().ExecContext(ctx, query, args...)
 		if err == nil {
 			return result, nil
 		}
@@ -126,22 +164,104 @@ func (adb *AdvancedDB) retryExec(ctx context.Context, query string, args ...inte
 }
 
 // Query executes a query that returns rows
-func (adb *AdvancedDB) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+func (adb *AdvancedDB) Query(ctx context.Context, query string, args ...interface{}) (_ *Rows, err error) {
 	start := time.Now()
 	defer func() {
-		adb.metrics.RecordQuery(time.Since(start), nil)
+		adb.metrics.RecordQuery(time.Since(start), err)
 	}()
 
+	ctx, done, err := runQueryHooks(ctx, adb.queryHooks(), query, args)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, adb.queryTimeout)
-	defer cancel()
 
-	return ExecuteWithGate(adb.gate, ctx, func(ctx context.Context) (*sql.Rows, error) {
+	rows, err := ExecuteWithGate(adb.gate, ctx, func(ctx context.Context) (*sql.Rows, error) {
 		return adb.retryQuery(ctx, query, args...)
 	})
+	done(err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Rows are read after Query returns, so the context stays alive until
+	// they are closed
+	return &Rows{Rows: rows, release: cancel}, nil
+}
+
+// QueryHeld executes a query whose rows are read long after it returns,
+// such as those of a server cursor. Like QueryStream, it holds its gate
+// slot until the rows are closed and is bounded by ctx rather than the
+// query timeout.
+func (adb *AdvancedDB) QueryHeld(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, done, err := runQueryHooks(ctx, adb.queryHooks(), query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The slot is held until the rows are closed, so the gate is entered
+	// here instead of through ExecuteWithGate
+	if err := adb.gate.Allow(ctx); err != nil {
+		done(err)
+		return nil, err
+	}
+
+	start := time.Now()
+	gateCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	rows, err := adb.retryQuery(ctx, query, args...)
+	done(err)
+	if err != nil {
+		cancel()
+		adb.gate.RecordFailureContext(gateCtx)
+		adb.metrics.RecordQuery(time.Since(start), err)
+		return nil, err
+	}
+
+	held := &Rows{Rows: rows}
+	held.release = func() {
+		cancel()
+		err := rows.Err()
+		if err != nil {
+			adb.gate.RecordFailureContext(gateCtx)
+		} else {
+			adb.gate.RecordSuccess()
+			adb.gate.ReleaseContext(gateCtx)
+		}
+		adb.metrics.RecordQuery(time.Since(start), err)
+	}
+	return held, nil
+}
+
+// Rows is the result of Query. Closing or exhausting the rows releases the
+// context of the query, which is canceled by the query timeout otherwise.
+type Rows struct {
+	*sql.Rows
+	once    sync.Once
+	release func() // releases the context, and the gate slot of QueryHeld
+}
+
+// Next prepares the next row like sql.Rows.Next
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.once.Do(r.release)
+	return false
+}
+
+// Close closes the rows and releases the context of the query
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.once.Do(r.release)
+	return err
 }
 
 // retryQuery executes query with retry logic
 func (adb *AdvancedDB) retryQuery(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query = adb.taggedQuery(ctx, query)
 	var lastErr error
 	backoff := adb.retryPolicy.InitialBackoff
 
@@ -158,7 +278,7 @@ func (adb *AdvancedDB) retryQuery(ctx context.Context, query string, args ...int
 			}
 		}
 
-		rows, err := adb.db.QueryContext(ctx, query, args...)
+		rows, err := adb.db.Load().QueryContext(ctx, query, args...)
 		if err == nil {
 			return rows, nil
 		}
@@ -172,19 +292,140 @@ func (adb *AdvancedDB) retryQuery(ctx context.Context, query string, args ...int
 	return nil, fmt.Errorf("query failed after %d attempts: %w", adb.retryPolicy.MaxRetries+1, lastErr)
 }
 
-// QueryRow executes a query that returns at most one row
-func (adb *AdvancedDB) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+// QueryRow executes a query that returns at most one row. The query runs
+// through the gate like Query; its outcome is recorded when the row is
+// scanned, so Row.Scan must be called to release the gate slot (it is
+// released anyway once the query timeout passes).
+func (adb *AdvancedDB) QueryRow(ctx context.Context, query string, args ...interface{}) *Row {
 	start := time.Now()
-	defer func() {
-		adb.metrics.RecordQuery(time.Since(start), nil)
-	}()
 
+	ctx, done, err := runQueryHooks(ctx, adb.queryHooks(), query, args)
+	if err != nil {
+		return &Row{err: err}
+	}
+
+	// The slot is held until Scan, so the gate is entered here instead of
+	// through ExecuteWithGate
+	if err := adb.gate.Allow(ctx); err != nil {
+		adb.metrics.RecordQuery(time.Since(start), err)
+		done(err)
+		return &Row{err: err}
+	}
+
+	gateCtx := ctx
 	ctx, cancel := context.WithTimeout(ctx, adb.queryTimeout)
-	defer cancel()
+	rows, err := adb.retryQuery(ctx, query, args...)
+	if err != nil {
+		cancel()
+		adb.gate.RecordFailureContext(gateCtx)
+		adb.metrics.RecordQuery(time.Since(start), err)
+		done(err)
+		return &Row{err: err}
+	}
+
+	row := &Row{
+		rows:   rows,
+		cancel: cancel,
+		finish: func(err error) {
+			// A missing row is an answer, not a database failure
+			failed := err
+			if errors.Is(err, sql.ErrNoRows) {
+				failed = nil
+			}
+			if failed != nil {
+				adb.gate.RecordFailureContext(gateCtx)
+			} else {
+				adb.gate.RecordSuccess()
+				adb.gate.ReleaseContext(gateCtx)
+			}
+			adb.metrics.RecordQuery(time.Since(start), failed)
+			done(failed)
+		},
+	}
+	row.mu.Lock()
+	row.timer = time.AfterFunc(adb.queryTimeout, row.expire)
+	row.mu.Unlock()
+	return row
+}
+
+// errRowScanned is returned by a second Row.Scan
+var errRowScanned = errors.New("row already scanned")
+
+// Row is the result of QueryRow. Like sql.Row, errors are deferred to Scan.
+type Row struct {
+	mu     sync.Mutex
+	rows   *sql.Rows
+	err    error // returned by Scan without reading rows
+	done   bool
+	timer  *time.Timer
+	cancel context.CancelFunc
+	finish func(err error) // gate, metrics and hook accounting
+}
+
+// Scan copies the columns of the row into dest. It returns sql.ErrNoRows if
+// the query selected no rows; extra rows are discarded.
+func (r *Row) Scan(dest ...interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.done {
+		return errRowScanned
+	}
 
-	// Note: QueryRow doesn't return error immediately, so we can't use gate here
-	// But we can still track metrics
-	return adb.db.QueryRowContext(ctx, query, args...)
+	err := scanSingleRow(r.rows, dest)
+	r.complete(err)
+	return err
+}
+
+// Err returns the error that prevented the query from running, without
+// scanning the row
+func (r *Row) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// expire releases a row that was not scanned within the query timeout
+func (r *Row) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil || r.done {
+		return
+	}
+	r.err = fmt.Errorf("row not scanned within the query timeout: %w", context.DeadlineExceeded)
+	r.complete(r.err)
+}
+
+// complete closes the rows and records the outcome. The caller holds r.mu.
+func (r *Row) complete(err error) {
+	r.done = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.rows.Close()
+	r.cancel()
+	r.finish(err)
+}
+
+// scanSingleRow scans the first row of rows and closes them
+func scanSingleRow(rows *sql.Rows, dest []interface{}) error {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// Dialect returns the SQL dialect of the database
+func (adb *AdvancedDB) Dialect() Dialect {
+	return adb.dialect
 }
 
 // Prepare creates or retrieves a cached prepared statement
@@ -195,7 +436,7 @@ func (adb *AdvancedDB) Prepare(ctx context.Context, query string) (*sql.Stmt, er
 	}
 
 	// Create new prepared statement
-	stmt, err := adb.db.PrepareContext(ctx, query)
+	stmt, err := adb.db.Load().PrepareContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -205,73 +446,183 @@ func (adb *AdvancedDB) Prepare(ctx context.Context, query string) (*sql.Stmt, er
 	return stmt, nil
 }
 
-// Begin starts a transaction with advanced features
+// swapDB makes db the pool of new operations and returns the previous one.
+// Cached statements belong to the previous pool, so they are closed.
+func (adb *AdvancedDB) swapDB(db *sql.DB) *sql.DB {
+	old := adb.db.Swap(db)
+	adb.stmtCache.Clear()
+	return old
+}
+
+// InvalidateStatement closes the cached prepared statement for query, so the
+// next Prepare re-prepares it against the current schema. Use it after DDL
+// changes a table the statement uses. Executions in progress finish first;
+// a *sql.Stmt obtained earlier from Prepare fails once closed. It reports
+// whether the statement was cached.
+func (adb *AdvancedDB) InvalidateStatement(query string) bool {
+	return adb.stmtCache.Remove(query)
+}
+
+// InvalidateAll closes every cached prepared statement (see
+// InvalidateStatement) and returns how many were closed
+func (adb *AdvancedDB) InvalidateAll() int {
+	return adb.stmtCache.Clear()
+}
+
+// Begin starts a transaction with advanced features. If a transaction
+// timeout is configured (or set on ctx with WithTxTimeout), the transaction
+// is rolled back when it expires and later calls on it fail fast.
 func (adb *AdvancedDB) Begin(ctx context.Context, opts *sql.TxOptions) (*AdvancedTx, error) {
-	ctx, cancel := context.WithTimeout(ctx, adb.queryTimeout)
-	defer cancel()
+	// database/sql rolls a transaction back when its context ends, so the
+	// transaction uses the caller's context rather than a per-query timeout
+	// that would be canceled as soon as Begin returns
+	timeout := adb.txTimeout
+	if d, ok := ctx.Value(txTimeoutKey{}).(time.Duration); ok {
+		timeout = d
+	}
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
 
 	tx, err := ExecuteWithGate(adb.gate, ctx, func(ctx context.Context) (*sql.Tx, error) {
-		return adb.db.BeginTx(ctx, opts)
+		return adb.db.Load().BeginTx(ctx, opts)
 	})
 
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	return &AdvancedTx{
+	atx := &AdvancedTx{
 		tx:      tx,
 		gate:    adb.gate,
 		metrics: adb.metrics,
-	}, nil
+		hooks:   adb.queryHooks(),
+		cancel:  cancel,
+		tags:    adb.tagComments,
+	}
+	atx.deadline, atx.hasDeadline = ctx.Deadline()
+	return atx, nil
+}
+
+type txTimeoutKey struct{}
+
+// WithTxTimeout returns a context whose Begin uses timeout as the
+// transaction deadline instead of the configured TransactionTimeout
+// (0 disables it)
+func WithTxTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, txTimeoutKey{}, timeout)
 }
 
+// ErrTxTimeout is returned by calls on a transaction whose deadline passed.
+// The transaction has been rolled back.
+var ErrTxTimeout = NewDatabaseError(ErrCodeTransactionFailed, "transaction deadline exceeded, rolled back", context.DeadlineExceeded)
+
 // AdvancedTx wraps sql.Tx with advanced features
 type AdvancedTx struct {
 	tx      *sql.Tx
 	gate    *ConnectionGate
 	metrics *DBMetrics
+	hooks   []QueryHooks
+	tags    bool // send query tags as a comment
+
+	cancel      context.CancelFunc
+	deadline    time.Time
+	hasDeadline bool
+}
+
+// expired reports whether the transaction deadline has passed
+func (atx *AdvancedTx) expired() bool {
+	return atx.hasDeadline && !time.Now().Before(atx.deadline)
+}
+
+// txError replaces the errors of a transaction rolled back by its deadline
+func (atx *AdvancedTx) txError(err error) error {
+	if err != nil && atx.expired() {
+		return ErrTxTimeout
+	}
+	return err
 }
 
 // Exec executes within transaction
 func (atx *AdvancedTx) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if atx.expired() {
+		return nil, ErrTxTimeout
+	}
+	ctx, done, err := runQueryHooks(ctx, atx.hooks, query, args)
+	if err != nil {
+		return nil, err
+	}
 	start := time.Now()
-	result, err := atx.tx.ExecContext(ctx, query, args...)
+	result, err := atx.tx.ExecContext(ctx, atx.taggedQuery(ctx, query), args...)
+	err = atx.txError(err)
 	atx.metrics.RecordQuery(time.Since(start), err)
+	done(err)
 	return result, err
 }
 
 // Query executes query within transaction
-func (atx *AdvancedTx) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+func (atx *AdvancedTx) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if atx.expired() {
+		return nil, ErrTxTimeout
+	}
+	ctx, done, err := runQueryHooks(ctx, atx.hooks, query, args)
+	if err != nil {
+		return nil, err
+	}
 	start := time.Now()
-	defer func() {
-		atx.metrics.RecordQuery(time.Since(start), nil)
-	}()
-	return atx.tx.QueryContext(ctx, query, args...)
+	rows, err := atx.tx.QueryContext(ctx, atx.taggedQuery(ctx, query), args...)
+	err = atx.txError(err)
+	atx.metrics.RecordQuery(time.Since(start), err)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{Rows: rows, release: func() {}}, nil
 }
 
 // Commit commits the transaction
 func (atx *AdvancedTx) Commit() error {
-	err := atx.tx.Commit()
-	if err != nil {
-		atx.gate.RecordFailure()
-	} else {
+	defer atx.cancel()
+	if atx.expired() {
+		// database/sql rolls the transaction back when the deadline fires;
+		// make sure it has before reporting. An expired transaction was
+		// held too long by its caller, not failed by the database, so like
+		// ErrNoRows it leaves the circuit breaker alone.
+		_ = atx.tx.Rollback()
+		return ErrTxTimeout
+	}
+
+	// The connection slot was released when Begin returned, so only the
+	// circuit breaker is updated here
+	err := atx.txError(atx.tx.Commit())
+	switch {
+	case err == nil:
 		atx.gate.RecordSuccess()
+	case !errors.Is(err, ErrTxTimeout):
+		atx.gate.circuitBreaker.RecordFailure()
 	}
 	return err
 }
 
 // Rollback rolls back the transaction
 func (atx *AdvancedTx) Rollback() error {
+	defer atx.cancel()
 	err := atx.tx.Rollback()
+	if err != nil && atx.expired() {
+		// Already rolled back by the deadline
+		return nil
+	}
 	if err != nil {
-		atx.gate.RecordFailure()
+		atx.gate.circuitBreaker.RecordFailure()
 	}
 	return err
 }
 
 // Stats returns connection pool statistics
 func (adb *AdvancedDB) Stats() sql.DBStats {
-	return adb.db.Stats()
+	return adb.db.Load().Stats()
 }
 
 // Metrics returns performance metrics
@@ -283,7 +634,7 @@ func (adb *AdvancedDB) Metrics() *DBMetrics {
 func (adb *AdvancedDB) HealthCheck(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	return adb.db.PingContext(ctx)
+	return adb.db.Load().PingContext(ctx)
 }
 
 // NewPreparedStatementCache creates a new statement cache
@@ -322,15 +673,32 @@ func (psc *PreparedStatementCache) Put(query string, stmt *sql.Stmt) {
 	psc.cache[query] = stmt
 }
 
-// Clear clears the statement cache
-func (psc *PreparedStatementCache) Clear() {
+// Remove closes and removes a statement from the cache. It reports whether
+// the statement was cached.
+func (psc *PreparedStatementCache) Remove(query string) bool {
+	psc.mu.Lock()
+	stmt, ok := psc.cache[query]
+	delete(psc.cache, query)
+	psc.mu.Unlock()
+
+	if ok {
+		stmt.Close()
+	}
+	return ok
+}
+
+// Clear clears the statement cache and returns the number of statements
+// that were closed
+func (psc *PreparedStatementCache) Clear() int {
 	psc.mu.Lock()
 	defer psc.mu.Unlock()
 
+	n := len(psc.cache)
 	for _, stmt := range psc.cache {
 		stmt.Close()
 	}
 	psc.cache = make(map[string]*sql.Stmt)
+	return n
 }
 
 // NewDBMetrics creates new database metrics
@@ -352,6 +720,13 @@ func (m *DBMetrics) RecordQuery(duration time.Duration, err error) {
 
 	if err != nil {
 		atomic.AddInt64(&m.FailedQueries, 1)
+		code := ErrorCode(err)
+		m.mu.Lock()
+		if m.errorCodes == nil {
+			m.errorCodes = make(map[string]int64)
+		}
+		m.errorCodes[code]++
+		m.mu.Unlock()
 	} else {
 		atomic.AddInt64(&m.SuccessfulQueries, 1)
 	}
@@ -370,9 +745,28 @@ func (m *DBMetrics) GetStats() MetricsStats {
 	slow := atomic.LoadInt64(&m.SlowQueries)
 
 	avgTime := time.Duration(0)
+	successRate := 0.0
 	if total > 0 {
 		avgTime = time.Duration(totalTime / total)
+		successRate = float64(successful) / float64(total) * 100
+	}
+
+	m.mu.RLock()
+	var byCode map[string]int64
+	if len(m.errorCodes) > 0 {
+		byCode = make(map[string]int64, len(m.errorCodes))
+		for code, n := range m.errorCodes {
+			byCode[code] = n
+		}
+	}
+	var byTag map[string]int64
+	if len(m.tagCounts) > 0 {
+		byTag = make(map[string]int64, len(m.tagCounts))
+		for tag, n := range m.tagCounts {
+			byTag[tag] = n
+		}
 	}
+	m.mu.RUnlock()
 
 	return MetricsStats{
 		TotalQueries:      total,
@@ -380,7 +774,9 @@ func (m *DBMetrics) GetStats() MetricsStats {
 		FailedQueries:     failed,
 		AverageQueryTime:  avgTime,
 		SlowQueries:       slow,
-		SuccessRate:       float64(successful) / float64(total) * 100,
+		SuccessRate:       successRate,
+		ErrorsByCode:      byCode,
+		QueriesByTag:      byTag,
 	}
 }
 
@@ -392,6 +788,10 @@ type MetricsStats struct {
 	AverageQueryTime  time.Duration
 	SlowQueries       int64
 	SuccessRate       float64
+	ErrorsByCode      map[string]int64 // failed queries per ErrorCode
+	QueriesByTag      map[string]int64 // statements per name=value query tag
+	GateWait          GateWaitStats    // set by DBRuntime.Metrics
+	Cache             *CacheStats      // set by DBRuntime.Metrics when a cache is configured
 }
 
 // NewRetryPolicy creates a new retry policy
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTransactionCommitAndRollback(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:transactions?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE accounts (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	// The transaction must outlive Begin
+	err := NewQueryExecutor(runtime).Transaction(ctx, func(tx *AdvancedTx) error {
+		_, err := tx.Exec(ctx, "INSERT INTO accounts (id) VALUES (1)")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+
+	err = NewQueryExecutor(runtime).Transaction(ctx, func(tx *AdvancedTx) error {
+		if _, err := tx.Exec(ctx, "INSERT INTO accounts (id) VALUES (2)"); err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	if err == nil {
+		t.Fatal("Expected the failing transaction to return its error")
+	}
+
+	_, rows, _, err := runtime.QueryCached(ctx, "", 0, "SELECT id FROM accounts")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(rows) != 1 || fmt.Sprint(rows[0][0]) != "1" {
+		t.Errorf("Expected only the committed row, got %v", rows)
+	}
+}
+
+func TestTransactionTimeout(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:txtimeout?mode=memory&cache=shared").
+		WithTransactionTimeout(50 * time.Millisecond).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE locks (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	tx, err := runtime.Begin(ctx, nil)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO locks (id) VALUES (1)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := tx.Exec(ctx, "INSERT INTO locks (id) VALUES (2)"); err != ErrTxTimeout {
+		t.Errorf("Expected ErrTxTimeout from Exec, got %v", err)
+	}
+	if _, err := tx.Query(ctx, "SELECT id FROM locks"); err != ErrTxTimeout {
+		t.Errorf("Expected ErrTxTimeout from Query, got %v", err)
+	}
+	if err := tx.Commit(); err != ErrTxTimeout {
+		t.Errorf("Expected ErrTxTimeout from Commit, got %v", err)
+	}
+	cb := runtime.advancedDB.gate.circuitBreaker
+	cb.mu.RLock()
+	failures := cb.failureCount
+	cb.mu.RUnlock()
+	if failures != 0 {
+		t.Errorf("Expected an expired transaction to leave the circuit breaker alone, got %d failures", failures)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("Expected Rollback of an expired transaction to succeed, got %v", err)
+	}
+
+	// The pending insert was rolled back and its lock released
+	if _, err := runtime.Exec(ctx, "INSERT INTO locks (id) VALUES (1)"); err != nil {
+		t.Fatalf("Expected the rolled back row to be insertable, got %v", err)
+	}
+
+	// The deadline can be overridden per Begin
+	tx, err = runtime.Begin(WithTxTimeout(ctx, 0), nil)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := tx.Exec(ctx, "INSERT INTO locks (id) VALUES (3)"); err != nil {
+		t.Fatalf("Exec without a deadline failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit without a deadline failed: %v", err)
+	}
+}
+
+func TestQueryRow_GateAndMetrics(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:query_row_gate?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "INSERT INTO users (id, name) VALUES (1, 'alice')"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	limiter := runtime.advancedDB.gate.connectionLimiter
+
+	// The gate slot is held until Scan
+	row := runtime.QueryRow(ctx, "SELECT name FROM users WHERE id = ?", 1)
+	if got := limiter.CurrentConnections(); got != 1 {
+		t.Errorf("Expected the row to hold a gate slot, got %d", got)
+	}
+	var name string
+	if err := row.Scan(&name); err != nil || name != "alice" {
+		t.Fatalf("Expected alice, got %q (%v)", name, err)
+	}
+	if got := limiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected Scan to release the gate slot, got %d", got)
+	}
+	if err := row.Scan(&name); err == nil {
+		t.Error("Expected a second Scan to fail")
+	}
+
+	// A missing row is not a failure
+	failed := runtime.Metrics().FailedQueries
+	if err := runtime.QueryRow(ctx, "SELECT name FROM users WHERE id = ?", 2).Scan(&name); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+	if got := runtime.Metrics().FailedQueries; got != failed {
+		t.Errorf("Expected no failed queries for a missing row, got %d", got-failed)
+	}
+
+	// Errors reach the metrics and the circuit breaker
+	if err := runtime.QueryRow(ctx, "SELECT nope FROM users").Scan(&name); err == nil {
+		t.Fatal("Expected an invalid query to fail")
+	}
+	if got := runtime.Metrics().FailedQueries; got != failed+1 {
+		t.Errorf("Expected 1 failed query, got %d", got-failed)
+	}
+	if got := limiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected the gate slot to be released after a failure, got %d", got)
+	}
+
+	disconnected := NewDBRuntime(NewConfigBuilder().WithDSN("file:unused?mode=memory").Build())
+	if err := disconnected.QueryRow(ctx, "SELECT 1").Scan(&name); err == nil {
+		t.Error("Expected QueryRow on a disconnected runtime to fail")
+	}
+}
+
+func TestQuery_ReleasesContext(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:query_context?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	query := func() (*Rows, *int) {
+		rows, err := runtime.Query(ctx, "SELECT 1 UNION ALL SELECT 2")
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		canceled := new(int)
+		release := rows.release
+		rows.release = func() {
+			*canceled++
+			release()
+		}
+		return rows, canceled
+	}
+
+	// Closing the rows early releases the context at once
+	rows, canceled := query()
+	if !rows.Next() {
+		t.Fatalf("Expected a row, got %v", rows.Err())
+	}
+	if *canceled != 0 {
+		t.Error("Expected the context to stay alive while rows are read")
+	}
+	rows.Close()
+	if *canceled != 1 {
+		t.Errorf("Expected Close to release the context, got %d cancels", *canceled)
+	}
+
+	// So does reading every row
+	rows, canceled = query()
+	_, values, err := ScanAllRows(rows)
+	if err != nil || len(values) != 2 {
+		t.Fatalf("Expected 2 rows, got %v (%v)", values, err)
+	}
+	if *canceled != 1 {
+		t.Errorf("Expected the last Next to release the context, got %d cancels", *canceled)
+	}
+	rows.Close()
+}
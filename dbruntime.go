@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql" // MySQL driver
@@ -33,6 +35,28 @@ type DBRuntime struct {
 	advancedDB  *AdvancedDB
 	config      *RuntimeConfig
 	cache       Cache
+	warmup      *warmupRecorder
+	shadow      *shadowMirror
+	adaptive    *adaptiveRateController
+	autoscaler  *concurrencyAutoscaler
+	shedder     *resourceShedder
+	cacheDeps   cacheDependencies
+	cacheFlight flightGroup[QueryResult]
+	ready       atomic.Bool
+
+	rulesMu sync.RWMutex
+	rules   []invalidationRule // see AddInvalidationRule
+
+	hooksMu sync.Mutex
+	hooks   []QueryHooks
+
+	asyncMu sync.Mutex
+	async   *asyncExecutor
+
+	samplerMu sync.Mutex
+	sampler   *poolSampler
+
+	reloadMu sync.Mutex // serializes Reload and Disconnect
 }
 
 // RuntimeConfig configures the entire database runtime
@@ -53,13 +77,26 @@ type RuntimeConfig struct {
 	WarmupTimeout          time.Duration
 	ConnectionTimeout      time.Duration
 	EnableLeakDetection    bool
+	ReloadDrainTimeout     time.Duration // how long Reload waits for the old pool to go idle (default 30s)
 
 	// Gate configuration
 	CircuitBreakerMaxFailures     int
 	CircuitBreakerResetTimeout    time.Duration
 	CircuitBreakerHalfOpenTimeout time.Duration
+	CircuitBreakerMode            string  // consecutive | error-rate
+	CircuitBreakerFailureRate     float64 // error-rate mode: percentage of failed calls that trips (default 50)
+	CircuitBreakerWindow          int     // error-rate mode: calls in the sliding window (default 100)
+	CircuitBreakerMinCalls        int     // error-rate mode: calls before the rate is evaluated (default window)
+	CircuitBreakerHistory         int     // state transitions kept for CircuitBreakerHistory() (default 20)
 	MaxRequestsPerSecond          int64
+	RateLimitBurst                int64              // requests allowed at once after idle (default 10 seconds of MaxRequestsPerSecond)
+	IdentityRateLimit             int64              // requests per second per WithIdentity identity (0 disables)
+	IdentityBurst                 int64              // bucket size per identity (default IdentityRateLimit)
+	IdentityWeights               map[string]float64 // rate and burst multiplier per identity (default 1)
 	MaxConcurrentConnections      int64
+	Bulkheads                     map[string]int64 // named concurrency limits selected with WithBulkhead
+	PriorityReserve               int64            // connection slots kept free per priority level (0 disables WithPriority)
+	PriorityQueueTimeout          time.Duration    // wait for room before shedding a call (0 sheds at once)
 
 	// Database operation configuration
 	StmtCacheSize      int
@@ -67,6 +104,11 @@ type RuntimeConfig struct {
 	QueryTimeout       time.Duration
 	MaxRetries         int
 	RetryBackoff       time.Duration
+	TransactionTimeout time.Duration // 0 disables the transaction deadline
+	QueryLog           QueryLogConfig
+	ExplainSlowQueries bool     // capture the plan of slow queries
+	QueryTagComments   bool     // send WithQueryTags tags to the database as a SQL comment
+	CostFunc           CostFunc // rate limit tokens per statement (default 1, see StatementCosts)
 
 	// Backpressure configuration (for connection gating)
 	BackpressureMode    string        // drop | block | timeout
@@ -76,7 +118,67 @@ type RuntimeConfig struct {
 	EnableAggressiveCaching bool          // Cache everything possible
 	CacheDefaultTTL         time.Duration // Default cache TTL
 	CacheCapacity           int           // Cache capacity
+	CacheMaxBytes           int64         // Estimated memory budget of the in-memory cache (0 = capacity only)
+	CacheCompressThreshold  int           // Encoded size from which cache values are compressed (0 disables)
+	CacheTTLJitter          float64       // Share of a QueryCached TTL randomly taken off, 0-1 (0 disables)
+	CacheKeyNamespace       string        // Prefix of the keys QueryCachedAuto derives
+	CacheNamespace          string        // Namespace of QueryCached entries when ctx sets none (see WithCacheNamespace)
 	InMemoryMode            bool          // Pure in-memory mode
+
+	// CacheBackend selects the cache: in process memory, shared with other
+	// runtimes in Redis or memcached, or tiered with memory over Redis.
+	// Setting RedisCache alone selects Redis as well.
+	CacheBackend CacheBackend
+	RedisCache   *RedisCacheConfig
+	Memcached    *MemcachedCacheConfig
+	CacheTiers   *TieredCacheConfig
+
+	// Cache warmup, run by Connect before the runtime reports ready
+	CacheWarmupQueries  []CacheWarmupQuery // Queries to preload
+	CacheWarmupManifest string             // JSON manifest replayed on Connect
+	CacheWarmupTopN     int                // Most used QueryCached entries saved to the manifest on Disconnect (0 disables)
+	CacheWarmupTimeout  time.Duration      // Upper bound for the warmup phase
+
+	// Exec drops the QueryCached entries reading the table it writes: those
+	// whose query names it, and those registered with
+	// RegisterCacheDependencies
+	InvalidateCacheOnWrite bool
+
+	// InvalidationRules name the cache keys and tags Exec drops after
+	// matching statements (see DBRuntime.AddInvalidationRule)
+	InvalidationRules []InvalidationRule
+
+	// CacheMaxStale enables stale-while-revalidate: a QueryCached entry up
+	// to this long past its TTL is served while it is refreshed in the
+	// background (0 disables)
+	CacheMaxStale time.Duration
+
+	// NegativeCacheTTL, when set, is the TTL of empty QueryCached results
+	// and of failures whose ErrorCode is in NegativeCacheErrors, so repeated
+	// lookups of missing data skip the database (0 disables)
+	NegativeCacheTTL    time.Duration
+	NegativeCacheErrors []string
+
+	// Shadow traffic mirroring of read queries to a secondary runtime
+	Shadow *ShadowConfig
+
+	// Worker pool of ExecAsync
+	AsyncExec AsyncExecConfig
+
+	// Conditions checked by Readiness
+	Readiness ReadinessConfig
+
+	// Periodic connection pool samples read with PoolSamples (nil disables)
+	PoolSampler *PoolSamplerConfig
+
+	// Rate limit adjusted to the observed database latency (nil disables)
+	AdaptiveRate *AdaptiveRateConfig
+
+	// Concurrency limit adjusted to the connection pool waits (nil disables)
+	ConcurrencyAutoscale *ConcurrencyAutoscaleConfig
+
+	// Low priority work shed under heap or goroutine pressure (nil disables)
+	ResourcePressure *ResourcePressureConfig
 }
 
 // NewDBRuntime creates a new advanced database runtime
@@ -86,31 +188,27 @@ func NewDBRuntime(config *RuntimeConfig) *DBRuntime {
 	}
 
 	// Create connection manager
-	connConfig := &AdvancedConfig{
-		DatabaseType:           config.DatabaseType,
-		DSN:                    config.DSN,
-		MaxOpenConns:           config.MaxOpenConns,
-		MaxIdleConns:           config.MaxIdleConns,
-		ConnMaxLifetime:        config.ConnMaxLifetime,
-		ConnMaxIdleTime:        config.ConnMaxIdleTime,
-		LeakDetectionThreshold: config.LeakDetectionThreshold,
-		ValidationQuery:        config.ValidationQuery,
-		ValidationTimeout:      config.ValidationTimeout,
-		WarmupConnections:      config.WarmupConnections,
-		WarmupTimeout:          config.WarmupTimeout,
-		ConnectionTimeout:      config.ConnectionTimeout,
-		EnableLeakDetection:    config.EnableLeakDetection,
-	}
-
-	connManager := NewConnectionManager(connConfig)
+	connManager := NewConnectionManager(connectionConfig(config))
 
 	// Create connection gate
 	gateConfig := &GateConfig{
 		MaxFailures:              config.CircuitBreakerMaxFailures,
 		ResetTimeout:             config.CircuitBreakerResetTimeout,
 		HalfOpenTimeout:          config.CircuitBreakerHalfOpenTimeout,
+		Mode:                     config.CircuitBreakerMode,
+		FailureRate:              config.CircuitBreakerFailureRate,
+		FailureRateWindow:        config.CircuitBreakerWindow,
+		FailureRateMinCalls:      config.CircuitBreakerMinCalls,
+		CircuitHistorySize:       config.CircuitBreakerHistory,
 		MaxRequestsPerSecond:     config.MaxRequestsPerSecond,
+		RateLimitBurst:           config.RateLimitBurst,
+		IdentityRateLimit:        config.IdentityRateLimit,
+		IdentityBurst:            config.IdentityBurst,
+		IdentityWeights:          config.IdentityWeights,
 		MaxConcurrentConnections: config.MaxConcurrentConnections,
+		Bulkheads:                config.Bulkheads,
+		PriorityReserve:          config.PriorityReserve,
+		PriorityQueueTimeout:     config.PriorityQueueTimeout,
 		BackpressureMode:         config.BackpressureMode,
 		BackpressureTimeout:      config.BackpressureTimeout,
 	}
@@ -124,22 +222,55 @@ func NewDBRuntime(config *RuntimeConfig) *DBRuntime {
 		config:      config,
 	}
 
-	// Auto-configure cache for in-memory optimizations
-	if config.EnableAggressiveCaching || config.InMemoryMode {
-		capacity := config.CacheCapacity
-		if capacity <= 0 {
-			capacity = 10000
-		}
-		ttl := config.CacheDefaultTTL
-		if ttl <= 0 {
-			ttl = 300 * time.Second
-		}
-		runtime.cache = NewInMemoryCache(capacity, ttl)
+	runtime.cache = newConfiguredCache(config)
+	runtime.addConfiguredInvalidationRules()
+
+	if config.CacheWarmupManifest != "" && config.CacheWarmupTopN > 0 {
+		runtime.warmup = newWarmupRecorder()
+	}
+
+	if config.Shadow != nil && config.Shadow.Runtime != nil {
+		runtime.shadow = newShadowMirror(*config.Shadow)
+	}
+
+	if config.AdaptiveRate != nil {
+		stats := func() sql.DBStats { return runtime.DB().Stats() }
+		runtime.adaptive = newAdaptiveRateController(*config.AdaptiveRate, gate.rateLimiter, stats)
+		runtime.UseQueryHooks(runtime.adaptive.hooks())
+	}
+
+	if config.ConcurrencyAutoscale != nil {
+		stats := func() sql.DBStats { return runtime.DB().Stats() }
+		runtime.autoscaler = newConcurrencyAutoscaler(*config.ConcurrencyAutoscale, gate.connectionLimiter, stats)
+	}
+
+	if config.ResourcePressure != nil {
+		runtime.shedder = newResourceShedder(*config.ResourcePressure)
+		gate.shedder = runtime.shedder
 	}
 
 	return runtime
 }
 
+// connectionConfig returns the connection settings of a runtime config
+func connectionConfig(config *RuntimeConfig) *AdvancedConfig {
+	return &AdvancedConfig{
+		DatabaseType:           config.DatabaseType,
+		DSN:                    config.DSN,
+		MaxOpenConns:           config.MaxOpenConns,
+		MaxIdleConns:           config.MaxIdleConns,
+		ConnMaxLifetime:        config.ConnMaxLifetime,
+		ConnMaxIdleTime:        config.ConnMaxIdleTime,
+		LeakDetectionThreshold: config.LeakDetectionThreshold,
+		ValidationQuery:        config.ValidationQuery,
+		ValidationTimeout:      config.ValidationTimeout,
+		WarmupConnections:      config.WarmupConnections,
+		WarmupTimeout:          config.WarmupTimeout,
+		ConnectionTimeout:      config.ConnectionTimeout,
+		EnableLeakDetection:    config.EnableLeakDetection,
+	}
+}
+
 // Connect establishes connection to the database
 func (r *DBRuntime) Connect() error {
 	if err := r.connManager.Open(); err != nil {
@@ -153,15 +284,66 @@ func (r *DBRuntime) Connect() error {
 		QueryTimeout:       r.config.QueryTimeout,
 		MaxRetries:         r.config.MaxRetries,
 		RetryBackoff:       r.config.RetryBackoff,
+		TransactionTimeout: r.config.TransactionTimeout,
+		DatabaseType:       r.databaseType(),
+		ExplainSlowQueries: r.config.ExplainSlowQueries,
+		QueryTagComments:   r.config.QueryTagComments,
+		CostFunc:           r.config.CostFunc,
+	}
+
+	adb := NewAdvancedDB(r.connManager.DB(), r.gate, dbConfig)
+	if mode := r.config.QueryLog.Mode; mode != "" && mode != QueryLogOff {
+		logConfig := r.config.QueryLog
+		if logConfig.SlowThreshold <= 0 {
+			logConfig.SlowThreshold = r.config.SlowQueryThreshold
+		}
+		adb.UseQueryHooks(NewQueryLogger(logConfig).Hooks())
+	}
+	r.attachAdvancedDB(adb)
+	r.startPoolSampler()
+	if r.adaptive != nil {
+		r.adaptive.start()
+	}
+	if r.autoscaler != nil {
+		r.autoscaler.start()
+	}
+	if r.shedder != nil {
+		r.shedder.start()
 	}
 
-	r.advancedDB = NewAdvancedDB(r.connManager.DB(), r.gate, dbConfig)
+	// Preload the cache before reporting ready so a fresh deploy does not
+	// send every first request to the database
+	r.warmupCache()
+	r.ready.Store(true)
 
 	return nil
 }
 
 // Disconnect closes all connections and cleans up resources
 func (r *DBRuntime) Disconnect() error {
+	r.ready.Store(false)
+	r.stopAsync()
+	r.stopPoolSampler()
+	if r.adaptive != nil {
+		r.adaptive.close()
+	}
+	if r.autoscaler != nil {
+		r.autoscaler.close()
+	}
+	if r.shedder != nil {
+		r.shedder.close()
+	}
+	if r.shadow != nil {
+		r.shadow.wait()
+	}
+	r.saveWarmupManifest()
+	if c, ok := r.cache.(*TieredCache); ok {
+		// Stops listening for invalidations; resumes on next use
+		c.Close()
+	}
+
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
 	if r.advancedDB != nil && r.advancedDB.stmtCache != nil {
 		r.advancedDB.stmtCache.Clear()
 	}
@@ -188,9 +370,15 @@ func (r *DBRuntime) Cache() Cache {
 	return r.cache
 }
 
+// IsReady returns whether the runtime is connected and has finished its
+// startup phase (including cache warmup)
+func (r *DBRuntime) IsReady() bool {
+	return r.ready.Load()
+}
+
 // IsConnected returns whether the runtime is connected
 func (r *DBRuntime) IsConnected() bool {
-	return r.connManager.db != nil
+	return r.connManager.DB() != nil
 }
 
 // Exec executes a query without returning rows (with all advanced features)
@@ -198,11 +386,33 @@ func (r *DBRuntime) Exec(ctx context.Context, query string, args ...interface{})
 	if !r.IsConnected() {
 		return nil, fmt.Errorf("database not connected")
 	}
+	defer r.invalidateWrites(ctx, query, args)
 	return r.advancedDB.Exec(ctx, query, args...)
 }
 
 // Query executes a query that returns rows (with all advanced features)
-func (r *DBRuntime) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+func (r *DBRuntime) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if r.shadow == nil {
+		return r.query(ctx, query, args...)
+	}
+
+	start := time.Now()
+	rows, err := r.query(ctx, query, args...)
+	r.shadow.mirror(query, args, time.Since(start), err, nil)
+	return rows, err
+}
+
+// QueryHeld executes a query whose rows are read long after it returns (see
+// AdvancedDB.QueryHeld)
+func (r *DBRuntime) QueryHeld(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if !r.IsConnected() {
+		return nil, fmt.Errorf("database not connected")
+	}
+	return r.advancedDB.QueryHeld(ctx, query, args...)
+}
+
+// query executes a query without shadow mirroring
+func (r *DBRuntime) query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
 	if !r.IsConnected() {
 		return nil, fmt.Errorf("database not connected")
 	}
@@ -211,64 +421,75 @@ func (r *DBRuntime) Query(ctx context.Context, query string, args ...interface{}
 
 // QueryCached executes a query and caches the materialized rows under the provided key.
 // Returns columns, rows (each row is a slice of values), whether the result came from cache, and error if any.
+// Concurrent misses of the same key run the query once and share its result,
+// which is reported as coming from cache for all but the caller that ran it.
+// Keys are scoped to the cache namespace of ctx (see WithCacheNamespace).
 func (r *DBRuntime) QueryCached(ctx context.Context, key string, ttl time.Duration, query string, args ...interface{}) ([]string, [][]interface{}, bool, error) {
-	if r.cache != nil && key != "" {
-		if v, ok := r.cache.Get(ctx, key); ok {
-			if qr, ok2 := v.(struct{
-				Columns []string
-				Rows    [][]interface{}
-			}); ok2 {
-				return qr.Columns, qr.Rows, true, nil
-			}
-		}
+	// Entries of a tenant namespace would be replayed outside of it
+	if r.warmup != nil && key != "" && CacheNamespaceFrom(ctx) == "" {
+		r.warmup.record(key, ttl, query, args)
 	}
+	key = r.namespacedKey(ctx, key)
+	r.trackCacheDependencies(key, query)
 
-	rows, err := r.Query(ctx, query, args...)
-	if err != nil {
-		return nil, nil, false, err
-	}
-	defer rows.Close()
-
-	columns, err := rows.Columns()
-	if err != nil {
-		return nil, nil, false, err
+	if key == "" {
+		qr, err := r.loadCached(ctx, key, ttl, query, args)
+		return qr.Columns, qr.Rows, false, err
 	}
 
-	var results [][]interface{}
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		ptrs := make([]interface{}, len(columns))
-		for i := range values {
-			ptrs[i] = &values[i]
-		}
-		if err := rows.Scan(ptrs...); err != nil {
-			return nil, nil, false, err
-		}
-		for i, v := range values {
-			if b, ok := v.([]byte); ok {
-				values[i] = string(b)
+	var lookup func() (QueryResult, bool, error)
+	if r.cache != nil {
+		lookup = func() (QueryResult, bool, error) {
+			qr, stale, ok, err := r.cachedQuery(ctx, key)
+			if stale {
+				r.revalidate(ctx, key, func(ctx context.Context) (QueryResult, error) {
+					return r.loadCached(ctx, key, ttl, query, args)
+				})
 			}
+			return qr, ok, err
 		}
-		results = append(results, values)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, nil, false, err
+	qr, cached, err := getOrLoad(ctx, &r.cacheFlight, key, lookup, func() (QueryResult, error) {
+		return r.loadCached(ctx, key, ttl, query, args)
+	})
+	if err != nil {
+		return nil, nil, cached, err
 	}
+	return qr.Columns, qr.Rows, cached, nil
+}
 
+// loadCached runs the query of a QueryCached miss and caches its result
+func (r *DBRuntime) loadCached(ctx context.Context, key string, ttl time.Duration, query string, args []interface{}) (QueryResult, error) {
+	start := time.Now()
+	columns, results, err := r.queryAll(ctx, query, args...)
+	if r.shadow != nil {
+		// Mirrored with the materialized result so the shadow can be compared
+		r.shadow.mirror(query, args, time.Since(start), err, &QueryResult{Columns: columns, Rows: results})
+	}
+	qr := QueryResult{Columns: columns, Rows: results}
 	if r.cache != nil && key != "" {
-		_ = r.cache.Set(ctx, key, struct{
-			Columns []string
-			Rows    [][]interface{}
-		}{Columns: columns, Rows: results}, ttl)
+		r.storeCachedQuery(ctx, key, qr, err, ttl)
+	}
+	if err != nil {
+		return QueryResult{}, err
 	}
+	return qr, nil
+}
 
-	return columns, results, false, nil
+// queryAll executes a query without shadow mirroring and materializes the rows
+func (r *DBRuntime) queryAll(ctx context.Context, query string, args ...interface{}) ([]string, [][]interface{}, error) {
+	rows, err := r.query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	return ScanAllRows(rows)
 }
 
 // QueryRow executes a query that returns at most one row
-func (r *DBRuntime) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+func (r *DBRuntime) QueryRow(ctx context.Context, query string, args ...interface{}) *Row {
 	if !r.IsConnected() {
-		return nil
+		return &Row{err: fmt.Errorf("database not connected")}
 	}
 	return r.advancedDB.QueryRow(ctx, query, args...)
 }
@@ -281,6 +502,23 @@ func (r *DBRuntime) Prepare(ctx context.Context, query string) (*sql.Stmt, error
 	return r.advancedDB.Prepare(ctx, query)
 }
 
+// InvalidateStatement drops a cached prepared statement (see
+// AdvancedDB.InvalidateStatement)
+func (r *DBRuntime) InvalidateStatement(query string) bool {
+	if !r.IsConnected() {
+		return false
+	}
+	return r.advancedDB.InvalidateStatement(query)
+}
+
+// InvalidateAllStatements drops every cached prepared statement
+func (r *DBRuntime) InvalidateAllStatements() int {
+	if !r.IsConnected() {
+		return 0
+	}
+	return r.advancedDB.InvalidateAll()
+}
+
 // Begin starts a new transaction
 func (r *DBRuntime) Begin(ctx context.Context, opts *sql.TxOptions) (*AdvancedTx, error) {
 	if !r.IsConnected() {
@@ -302,10 +540,17 @@ func (r *DBRuntime) Metrics() MetricsStats {
 	if !r.IsConnected() {
 		return MetricsStats{}
 	}
-	return r.advancedDB.Metrics().GetStats()
+	stats := r.advancedDB.Metrics().GetStats()
+	stats.GateWait = r.gate.WaitStats()
+	if r.cache != nil {
+		cache := r.cache.Stats()
+		stats.Cache = &cache
+	}
+	return stats
 }
 
-// HealthCheck performs a health check on the database connection
+// HealthCheck performs a health check on the database connection (see
+// Liveness and Readiness for orchestrator probes)
 func (r *DBRuntime) HealthCheck(ctx context.Context) error {
 	if !r.IsConnected() {
 		return fmt.Errorf("database not connected")
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -260,3 +261,27 @@ func TestEnvironmentVariableDBType(t *testing.T) {
 		t.Error("DatabaseType should not be empty")
 	}
 }
+
+func TestCopyIn_RequiresPostgreSQL(t *testing.T) {
+	runtime := NewDBRuntime(&RuntimeConfig{DatabaseType: DatabaseTypeSQLite, DSN: ":memory:"})
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	source := CopyFromRows([][]interface{}{{1, "a"}, {2, "b"}})
+	if _, err := runtime.CopyIn(context.Background(), "t", []string{"id", "name"}, source); err == nil {
+		t.Error("CopyIn should fail on SQLite")
+	}
+
+	var n int
+	for source.Next() {
+		if values, err := source.Values(); err != nil || len(values) != 2 {
+			t.Errorf("Unexpected row: %v (%v)", values, err)
+		}
+		n++
+	}
+	if n != 2 || source.Err() != nil {
+		t.Errorf("Expected 2 rows, got %d (%v)", n, source.Err())
+	}
+}
@@ -117,3 +117,95 @@ func TestDBRuntime_Disconnect_NotConnected(t *testing.T) {
 		t.Errorf("Disconnect should not fail when not connected: %v", err)
 	}
 }
+
+func TestRegisterDriver(t *testing.T) {
+	if err := RegisterDriver("custom", DriverInfo{DriverName: "no-such-driver"}); err == nil {
+		t.Error("Registering an unknown sql driver should fail")
+	}
+
+	// A custom type backed by the sqlite3 driver
+	if err := RegisterDriver("embedded", DriverInfo{DriverName: "sqlite3"}); err != nil {
+		t.Fatalf("RegisterDriver failed: %v", err)
+	}
+	info, ok := LookupDriver("embedded")
+	if !ok || info.ValidationQuery != "SELECT 1" {
+		t.Errorf("Unexpected driver info: %+v (%v)", info, ok)
+	}
+
+	cm := NewConnectionManager(&AdvancedConfig{DatabaseType: "embedded", DSN: ":memory:"})
+	if err := cm.Open(); err != nil {
+		t.Fatalf("Failed to open custom database type: %v", err)
+	}
+	defer cm.Close()
+	if err := cm.DB().PingContext(context.Background()); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+
+	cm = NewConnectionManager(&AdvancedConfig{DatabaseType: "unknown", DSN: ":memory:"})
+	if err := cm.Open(); err == nil {
+		cm.Close()
+		t.Error("Opening an unregistered database type should fail")
+	}
+}
+
+func TestInvalidateStatement(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:stmt_invalidate?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE products (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	const query = "SELECT * FROM products"
+	stmt, err := runtime.Prepare(ctx, query)
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if again, _ := runtime.Prepare(ctx, query); again != stmt {
+		t.Fatal("Expected the statement to be cached")
+	}
+	if _, err := runtime.Prepare(ctx, "SELECT id FROM products"); err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+
+	if _, err := runtime.Exec(ctx, "ALTER TABLE products ADD COLUMN name TEXT"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if !runtime.InvalidateStatement(query) {
+		t.Error("Expected the statement to be invalidated")
+	}
+	if runtime.InvalidateStatement(query) {
+		t.Error("Expected a second invalidation to find nothing")
+	}
+	if _, err := stmt.Query(); err == nil {
+		t.Error("Expected the invalidated statement to be closed")
+	}
+
+	fresh, err := runtime.Prepare(ctx, query)
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if fresh == stmt {
+		t.Error("Expected a newly prepared statement")
+	}
+	rows, err := fresh.Query()
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	columns, _ := rows.Columns()
+	rows.Close()
+	if len(columns) != 2 {
+		t.Errorf("Expected the new column to be visible, got %v", columns)
+	}
+
+	if n := runtime.InvalidateAllStatements(); n != 2 {
+		t.Errorf("Expected 2 statements to be closed, got %d", n)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect describes the SQL syntax differences between database types, so
+// statements can be built once instead of switching on the DatabaseType
+type Dialect interface {
+	// Type returns the database type of the dialect
+	Type() DatabaseType
+	// Placeholder returns the bind placeholder of the nth (1-based) argument
+	Placeholder(n int) string
+	// QuoteIdentifier quotes a single table or column name. Quoted names
+	// are case-sensitive on Oracle and PostgreSQL.
+	QuoteIdentifier(name string) string
+	// LimitOffset returns the clause that skips offset rows and returns at
+	// most limit rows (limit < 0 means no limit). It goes after ORDER BY.
+	LimitOffset(limit, offset int64) string
+	// ValidationQuery returns a cheap query that checks a connection
+	ValidationQuery() string
+	// ColumnType returns the column type used for a kind of value in DDL
+	ColumnType(kind ColumnKind) string
+}
+
+// ColumnKind is a portable column type used to build DDL with a Dialect
+type ColumnKind int
+
+const (
+	// ColumnKey is indexable text, such as a primary key (up to 255 characters)
+	ColumnKey ColumnKind = iota
+	// ColumnText is unbounded text
+	ColumnText
+	// ColumnBinary is unbounded binary data
+	ColumnBinary
+	// ColumnInt64 is a 64-bit integer
+	ColumnInt64
+	// ColumnTimestamp is a date and time
+	ColumnTimestamp
+	// ColumnJSON is a JSON document (text where there is no JSON type)
+	ColumnJSON
+)
+
+// DialectFor returns the dialect of a database type: the one registered
+// with its driver (DriverInfo.Dialect) if any, else the built-in dialect.
+// Unknown types get a generic dialect with ? placeholders, double-quoted
+// identifiers and LIMIT/OFFSET.
+func DialectFor(dbType DatabaseType) Dialect {
+	if info, ok := LookupDriver(dbType); ok && info.Dialect != nil {
+		return info.Dialect
+	}
+	switch dbType {
+	case DatabaseTypeOracle:
+		return oracleDialect{}
+	case DatabaseTypePostgreSQL:
+		return postgresDialect{}
+	case DatabaseTypeMySQL:
+		return mysqlDialect{}
+	case DatabaseTypeSQLite:
+		return sqliteDialect{}
+	}
+	return genericDialect{dbType: dbType}
+}
+
+// Rebind rewrites the ? placeholders of a query into the placeholder style
+// of a dialect. Question marks inside string literals, quoted identifiers
+// and comments are left alone.
+func Rebind(d Dialect, query string) string {
+	if d.Placeholder(1) == "?" || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var out strings.Builder
+	out.Grow(len(query) + 8)
+	n := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := skipQuoted(query, i)
+			out.WriteString(query[i:end])
+			i = end
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+			continue
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 4
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+			continue
+		case c == '?':
+			n++
+			out.WriteString(d.Placeholder(n))
+			i++
+			continue
+		}
+		out.WriteByte(c)
+		i++
+	}
+	return out.String()
+}
+
+// quoteWith quotes name with q, doubling q inside it
+func quoteWith(q, name string) string {
+	return q + strings.ReplaceAll(name, q, q+q) + q
+}
+
+// standardLimitOffset is the LIMIT/OFFSET clause of PostgreSQL and the
+// generic dialect
+func standardLimitOffset(limit, offset int64) string {
+	var parts []string
+	if limit >= 0 {
+		parts = append(parts, "LIMIT "+strconv.FormatInt(limit, 10))
+	}
+	if offset > 0 {
+		parts = append(parts, "OFFSET "+strconv.FormatInt(offset, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+type oracleDialect struct{}
+
+func (oracleDialect) Type() DatabaseType                 { return DatabaseTypeOracle }
+func (oracleDialect) Placeholder(n int) string           { return ":" + strconv.Itoa(n) }
+func (oracleDialect) QuoteIdentifier(name string) string { return quoteWith(`"`, name) }
+func (oracleDialect) ValidationQuery() string            { return "SELECT 1 FROM DUAL" }
+
+// LimitOffset uses the row limiting clause of Oracle 12c and later
+func (oracleDialect) LimitOffset(limit, offset int64) string {
+	var parts []string
+	if offset > 0 {
+		parts = append(parts, "OFFSET "+strconv.FormatInt(offset, 10)+" ROWS")
+	}
+	if limit >= 0 {
+		parts = append(parts, "FETCH NEXT "+strconv.FormatInt(limit, 10)+" ROWS ONLY")
+	}
+	return strings.Join(parts, " ")
+}
+
+func (oracleDialect) ColumnType(kind ColumnKind) string {
+	switch kind {
+	case ColumnKey:
+		return "VARCHAR2(255)"
+	case ColumnBinary:
+		return "BLOB"
+	case ColumnInt64:
+		return "NUMBER(19)"
+	case ColumnTimestamp:
+		return "TIMESTAMP"
+	}
+	return "CLOB"
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Type() DatabaseType                 { return DatabaseTypePostgreSQL }
+func (postgresDialect) Placeholder(n int) string           { return "$" + strconv.Itoa(n) }
+func (postgresDialect) QuoteIdentifier(name string) string { return quoteWith(`"`, name) }
+func (postgresDialect) ValidationQuery() string            { return "SELECT 1" }
+
+func (postgresDialect) LimitOffset(limit, offset int64) string {
+	return standardLimitOffset(limit, offset)
+}
+
+func (postgresDialect) ColumnType(kind ColumnKind) string {
+	switch kind {
+	case ColumnBinary:
+		return "BYTEA"
+	case ColumnInt64:
+		return "BIGINT"
+	case ColumnTimestamp:
+		return "TIMESTAMP"
+	case ColumnJSON:
+		return "JSONB"
+	}
+	return "TEXT"
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Type() DatabaseType                 { return DatabaseTypeMySQL }
+func (mysqlDialect) Placeholder(int) string             { return "?" }
+func (mysqlDialect) QuoteIdentifier(name string) string { return quoteWith("`", name) }
+func (mysqlDialect) ValidationQuery() string            { return "SELECT 1" }
+
+// LimitOffset uses the largest row count for an offset without a limit,
+// since MySQL has no OFFSET on its own
+func (mysqlDialect) LimitOffset(limit, offset int64) string {
+	if limit < 0 && offset > 0 {
+		return "LIMIT 18446744073709551615 OFFSET " + strconv.FormatInt(offset, 10)
+	}
+	return standardLimitOffset(limit, offset)
+}
+
+func (mysqlDialect) ColumnType(kind ColumnKind) string {
+	switch kind {
+	case ColumnKey:
+		return "VARCHAR(255)"
+	case ColumnText:
+		return "LONGTEXT"
+	case ColumnBinary:
+		return "LONGBLOB"
+	case ColumnInt64:
+		return "BIGINT"
+	case ColumnTimestamp:
+		return "TIMESTAMP"
+	}
+	return "JSON"
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Type() DatabaseType                 { return DatabaseTypeSQLite }
+func (sqliteDialect) Placeholder(int) string             { return "?" }
+func (sqliteDialect) QuoteIdentifier(name string) string { return quoteWith(`"`, name) }
+func (sqliteDialect) ValidationQuery() string            { return "SELECT 1" }
+
+// LimitOffset uses LIMIT -1 for an offset without a limit, since SQLite has
+// no OFFSET on its own
+func (sqliteDialect) LimitOffset(limit, offset int64) string {
+	if limit < 0 && offset > 0 {
+		return "LIMIT -1 OFFSET " + strconv.FormatInt(offset, 10)
+	}
+	return standardLimitOffset(limit, offset)
+}
+
+func (sqliteDialect) ColumnType(kind ColumnKind) string {
+	switch kind {
+	case ColumnBinary:
+		return "BLOB"
+	case ColumnInt64:
+		return "INTEGER"
+	case ColumnTimestamp:
+		return "DATETIME"
+	}
+	return "TEXT"
+}
+
+// genericDialect is used for registered database types without a dialect
+type genericDialect struct {
+	dbType DatabaseType
+}
+
+func (d genericDialect) Type() DatabaseType               { return d.dbType }
+func (genericDialect) Placeholder(int) string             { return "?" }
+func (genericDialect) QuoteIdentifier(name string) string { return quoteWith(`"`, name) }
+func (genericDialect) ValidationQuery() string            { return "SELECT 1" }
+
+func (genericDialect) LimitOffset(limit, offset int64) string {
+	return standardLimitOffset(limit, offset)
+}
+
+func (genericDialect) ColumnType(kind ColumnKind) string {
+	switch kind {
+	case ColumnKey:
+		return "VARCHAR(255)"
+	case ColumnBinary:
+		return "BLOB"
+	case ColumnInt64:
+		return "BIGINT"
+	case ColumnTimestamp:
+		return "TIMESTAMP"
+	}
+	return "TEXT"
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DriverInfo describes how a runtime opens a database type
+type DriverInfo struct {
+	// DriverName is the name the driver is registered under with database/sql
+	DriverName string
+	// ValidationQuery is the default query used to validate connections
+	ValidationQuery string
+	// Dialect describes the database's SQL syntax (default: see DialectFor)
+	Dialect Dialect
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[DatabaseType]DriverInfo{
+		DatabaseTypeOracle:     {DriverName: "godror", ValidationQuery: "SELECT 1 FROM DUAL"},
+		DatabaseTypePostgreSQL: {DriverName: "postgres", ValidationQuery: "SELECT 1"},
+		DatabaseTypeMySQL:      {DriverName: "mysql", ValidationQuery: "SELECT 1"},
+		DatabaseTypeSQLite:     {DriverName: "sqlite3", ValidationQuery: "SELECT 1"},
+	}
+)
+
+// RegisterDriver makes a database type available to runtimes, or replaces
+// the driver used for a built-in type. The driver itself must already be
+// registered with database/sql (usually by importing its package).
+func RegisterDriver(dbType DatabaseType, info DriverInfo) error {
+	if dbType == "" {
+		return fmt.Errorf("database type is required")
+	}
+	if info.DriverName == "" {
+		return fmt.Errorf("driver name is required")
+	}
+	if !sqlDriverRegistered(info.DriverName) {
+		return fmt.Errorf("sql driver %q is not registered (missing import?)", info.DriverName)
+	}
+	if info.ValidationQuery == "" {
+		info.ValidationQuery = "SELECT 1"
+		if info.Dialect != nil {
+			info.ValidationQuery = info.Dialect.ValidationQuery()
+		}
+	}
+
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[dbType] = info
+	return nil
+}
+
+// LookupDriver returns the driver registered for a database type
+func LookupDriver(dbType DatabaseType) (DriverInfo, bool) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	info, ok := drivers[dbType]
+	return info, ok
+}
+
+// RegisteredDatabaseTypes returns the database types runtimes can open
+func RegisteredDatabaseTypes() []DatabaseType {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	types := make([]DatabaseType, 0, len(drivers))
+	for dbType := range drivers {
+		types = append(types, dbType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// sqlDriverRegistered reports whether database/sql knows a driver name
+func sqlDriverRegistered(name string) bool {
+	for _, d := range sql.Drivers() {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
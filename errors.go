@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"time"
@@ -12,6 +14,9 @@ type DatabaseError struct {
 	Code    string
 	Message string
 	Err     error
+	// RetryAfter is how long to back off before retrying, when the error
+	// comes from a limit that frees up over time (0 if unknown)
+	RetryAfter time.Duration
 }
 
 func (e *DatabaseError) Error() string {
@@ -27,15 +32,25 @@ func (e *DatabaseError) Unwrap() error {
 
 // Error codes
 const (
-	ErrCodeConnectionFailed   = "CONNECTION_FAILED"
-	ErrCodeQueryFailed        = "QUERY_FAILED"
-	ErrCodeTransactionFailed  = "TRANSACTION_FAILED"
-	ErrCodeCircuitBreakerOpen = "CIRCUIT_BREAKER_OPEN"
-	ErrCodeRateLimitExceeded  = "RATE_LIMIT_EXCEEDED"
-	ErrCodeConnectionLeak     = "CONNECTION_LEAK"
-	ErrCodeValidationFailed   = "VALIDATION_FAILED"
-	ErrCodeTimeout            = "TIMEOUT"
-	ErrCodeRetryExhausted     = "RETRY_EXHAUSTED"
+	ErrCodeConnectionFailed    = "CONNECTION_FAILED"
+	ErrCodeQueryFailed         = "QUERY_FAILED"
+	ErrCodeTransactionFailed   = "TRANSACTION_FAILED"
+	ErrCodeCircuitBreakerOpen  = "CIRCUIT_BREAKER_OPEN"
+	ErrCodeRateLimitExceeded   = "RATE_LIMIT_EXCEEDED"
+	ErrCodeConnectionLeak      = "CONNECTION_LEAK"
+	ErrCodeValidationFailed    = "VALIDATION_FAILED"
+	ErrCodeTimeout             = "TIMEOUT"
+	ErrCodeRetryExhausted      = "RETRY_EXHAUSTED"
+	ErrCodeServerBusy          = "SERVER_BUSY"
+	ErrCodeResponseTooLarge    = "RESPONSE_TOO_LARGE"
+	ErrCodeIdempotencyConflict = "IDEMPOTENCY_CONFLICT"
+	ErrCodeNotReplayable       = "NOT_REPLAYABLE"
+	ErrCodeMemoryLimitExceeded = "MEMORY_LIMIT_EXCEEDED"
+	ErrCodeNotFound            = "NOT_FOUND"
+	ErrCodeConnectionLimit     = "CONNECTION_LIMIT"
+	ErrCodeCanceled            = "CANCELED"
+	ErrCodeSerializationFailed = "SERIALIZATION_FAILURE"
+	ErrCodeLoadShed            = "LOAD_SHED"
 )
 
 // NewDatabaseError creates a new database error
@@ -47,6 +62,56 @@ func NewDatabaseError(code, message string, err error) *DatabaseError {
 	}
 }
 
+// ErrorCode classifies an error into one of the ErrCode* codes. Errors the
+// runtime does not recognize are ErrCodeQueryFailed; nil has no code.
+func ErrorCode(err error) string {
+	var dbErr *DatabaseError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &dbErr):
+		return dbErr.Code
+	case errors.Is(err, ErrCircuitOpen):
+		return ErrCodeCircuitBreakerOpen
+	case errors.Is(err, ErrRateLimitExceeded):
+		return ErrCodeRateLimitExceeded
+	case errors.Is(err, ErrConnectionLimit):
+		return ErrCodeConnectionLimit
+	case errors.Is(err, ErrLoadShed):
+		return ErrCodeLoadShed
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrCodeTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrCodeCanceled
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrCodeNotFound
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return ErrCodeConnectionFailed
+	case IsSerializationFailure(err):
+		return ErrCodeSerializationFailed
+	}
+	return ErrCodeQueryFailed
+}
+
+// RetryAfter returns how long to back off before retrying after err: the
+// hint of a rate limit or connection limit error, or of a DatabaseError
+// carrying one. It returns 0 for other errors.
+func RetryAfter(err error) time.Duration {
+	var dbErr *DatabaseError
+	if errors.As(err, &dbErr) && dbErr.RetryAfter > 0 {
+		return dbErr.RetryAfter
+	}
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		return rateErr.RetryAfter
+	}
+	var limitErr *ConnectionLimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.RetryAfter
+	}
+	return 0
+}
+
 // IsRetryableError checks if an error is retryable
 func IsRetryableError(err error) bool {
 	var dbErr *DatabaseError
@@ -59,6 +124,15 @@ func IsRetryableError(err error) bool {
 	return false
 }
 
+// IsNotFoundError checks if error is due to a query returning no rows
+func IsNotFoundError(err error) bool {
+	var dbErr *DatabaseError
+	if errors.As(err, &dbErr) {
+		return dbErr.Code == ErrCodeNotFound
+	}
+	return false
+}
+
 // IsCircuitBreakerError checks if error is due to circuit breaker
 func IsCircuitBreakerError(err error) bool {
 	var dbErr *DatabaseError
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestNewDatabaseError(t *testing.T) {
@@ -212,3 +215,73 @@ func TestErrorRecovery_HandleError_NonRetryable(t *testing.T) {
 		t.Errorf("HandleError should return original error for non-retryable errors")
 	}
 }
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{NewDatabaseError(ErrCodeTimeout, "timeout", nil), ErrCodeTimeout},
+		{ErrCircuitOpen, ErrCodeCircuitBreakerOpen},
+		{fmt.Errorf("exec: %w", ErrRateLimitExceeded), ErrCodeRateLimitExceeded},
+		{ErrLoadShed, ErrCodeLoadShed},
+		{context.DeadlineExceeded, ErrCodeTimeout},
+		{context.Canceled, ErrCodeCanceled},
+		{sql.ErrNoRows, ErrCodeNotFound},
+		{errors.New("syntax error"), ErrCodeQueryFailed},
+	}
+
+	for _, tt := range tests {
+		if got := ErrorCode(tt.err); got != tt.want {
+			t.Errorf("ErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	busy := NewDatabaseError(ErrCodeServerBusy, "busy", nil)
+	busy.RetryAfter = time.Second
+
+	tests := []struct {
+		err  error
+		want time.Duration
+	}{
+		{nil, 0},
+		{errors.New("syntax error"), 0},
+		{busy, time.Second},
+		{&RateLimitError{RetryAfter: 20 * time.Millisecond}, 20 * time.Millisecond},
+		{fmt.Errorf("bulkhead %q: %w", "reports", &ConnectionLimitError{RetryAfter: time.Millisecond}), time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := RetryAfter(tt.err); got != tt.want {
+			t.Errorf("RetryAfter(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDBMetrics_RecordQueryErrors(t *testing.T) {
+	m := NewDBMetrics(nil)
+	m.RecordQuery(time.Millisecond, nil)
+	m.RecordQuery(time.Millisecond, context.DeadlineExceeded)
+	m.RecordQuery(2*time.Second, ErrCircuitOpen)
+	m.RecordQuery(time.Millisecond, ErrCircuitOpen)
+
+	stats := m.GetStats()
+	if stats.FailedQueries != 3 || stats.SuccessfulQueries != 1 {
+		t.Fatalf("Expected 3 failed and 1 successful query, got %+v", stats)
+	}
+	if stats.SuccessRate != 25 {
+		t.Errorf("Expected a 25%% success rate, got %v", stats.SuccessRate)
+	}
+	if stats.SlowQueries != 1 {
+		t.Errorf("Expected 1 slow query, got %d", stats.SlowQueries)
+	}
+	if stats.ErrorsByCode[ErrCodeCircuitBreakerOpen] != 2 || stats.ErrorsByCode[ErrCodeTimeout] != 1 {
+		t.Errorf("Unexpected per-code counts: %v", stats.ErrorsByCode)
+	}
+	if empty := NewDBMetrics(nil).GetStats(); empty.SuccessRate != 0 {
+		t.Errorf("Expected a 0%% success rate with no queries, got %v", empty.SuccessRate)
+	}
+}
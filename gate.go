@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,11 +21,51 @@ var (
 	ErrConnectionLimit   = errors.New("connection limit exceeded")
 )
 
+// RateLimitError is returned when the rate limiter has no token. It matches
+// ErrRateLimitExceeded with errors.Is.
+type RateLimitError struct {
+	RetryAfter time.Duration // time until a token is available
+	Identity   string        // set when a per-identity limit was exceeded
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Identity != "" {
+		return fmt.Sprintf("%v for %q (retry after %v)", ErrRateLimitExceeded, e.Identity, e.RetryAfter)
+	}
+	return fmt.Sprintf("%v (retry after %v)", ErrRateLimitExceeded, e.RetryAfter)
+}
+
+// Is reports whether target is ErrRateLimitExceeded
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimitExceeded
+}
+
+// ConnectionLimitError is returned when no connection slot is free. It
+// matches ErrConnectionLimit with errors.Is.
+type ConnectionLimitError struct {
+	RetryAfter time.Duration // estimated time until a slot is released
+}
+
+func (e *ConnectionLimitError) Error() string {
+	return fmt.Sprintf("%v (retry after %v)", ErrConnectionLimit, e.RetryAfter)
+}
+
+// Is reports whether target is ErrConnectionLimit
+func (e *ConnectionLimitError) Is(target error) bool {
+	return target == ErrConnectionLimit
+}
+
 // ConnectionGate manages connection access with advanced features
 type ConnectionGate struct {
 	circuitBreaker    *CircuitBreaker
 	rateLimiter       *RateLimiter
+	identities        *identityLimiter
 	connectionLimiter *ConnectionLimiter
+	bulkheads         map[string]*ConnectionLimiter
+	priority          *priorityAdmission
+	shedder           *resourceShedder // set by NewDBRuntime with ResourcePressure
+	rateWait          waitHistogram    // time spent in takeTokens
+	connWait          waitHistogram    // time spent in acquireSlots
 	mu                sync.RWMutex
 }
 
@@ -38,6 +79,15 @@ type CircuitBreaker struct {
 	state           int32 // 0: closed, 1: open, 2: half-open
 	mu              sync.RWMutex
 	onStateChange   func(from, to string)
+	history         *circuitHistory // guarded by mu
+
+	// error-rate mode: outcomes of the last calls, guarded by mu
+	failureRate  float64 // percentage that trips the breaker; 0 uses maxFailures
+	minCalls     int
+	window       []bool // ring, true for a failure
+	windowNext   int
+	windowCalls  int
+	windowFailed int
 }
 
 const (
@@ -46,33 +96,58 @@ const (
 	circuitHalfOpen
 )
 
-// RateLimiter implements token bucket rate limiting
+// RateLimiter implements token bucket rate limiting. Tokens refill
+// continuously, so partial tokens carry over between calls.
 type RateLimiter struct {
-	tokens     int64
-	maxTokens  int64
-	refillRate int64 // tokens per second
+	tokens     float64
+	maxTokens  float64
+	refillRate float64 // tokens per second
 	lastRefill time.Time
 	mu         sync.Mutex
 }
 
-// ConnectionLimiter limits concurrent connections
+// ConnectionLimiter limits concurrent connections. A slot is a token in a
+// buffered channel, so the in-use count cannot drift from the slots
+// actually held, whatever the backpressure mode.
 type ConnectionLimiter struct {
-	currentConnections int64
-	maxConnections     int64
-	mu                 sync.RWMutex
+	maxConnections int64
+	slots          chan struct{}
+	waiting        atomic.Int64
 	// backpressure support
 	mode    string
 	timeout time.Duration
-	sem     chan struct{}
+
+	// average time between releases, to estimate when a slot frees up
+	releaseMu   sync.Mutex
+	lastRelease time.Time
+	releaseGap  time.Duration
+
+	// SetLimit lowers the limit by holding reserved slots itself; pending
+	// slots are reserved on the next releases when all were in use. Both
+	// change under resizeMu.
+	resizeMu sync.Mutex
+	reserved atomic.Int64
+	pending  atomic.Int64
 }
 
+const (
+	// minSlotRetryAfter and maxSlotRetryAfter bound the retry-after estimate
+	// of a full connection limiter
+	minSlotRetryAfter = 10 * time.Millisecond
+	maxSlotRetryAfter = 5 * time.Second
+)
+
 // NewConnectionGate creates a new connection gate
 func NewConnectionGate(config *GateConfig) *ConnectionGate {
-	return &ConnectionGate{
+	cg := &ConnectionGate{
 		circuitBreaker:    NewCircuitBreaker(config),
 		rateLimiter:       NewRateLimiter(config),
+		identities:        newIdentityLimiter(config),
 		connectionLimiter: NewConnectionLimiter(config),
+		bulkheads:         newBulkheads(config),
 	}
+	cg.priority = newPriorityAdmission(config, cg.connectionLimiter)
+	return cg
 }
 
 // GateConfig configures the connection gate
@@ -81,12 +156,44 @@ type GateConfig struct {
 	MaxFailures     int
 	ResetTimeout    time.Duration
 	HalfOpenTimeout time.Duration
+	// Mode is "consecutive" (default), tripping after MaxFailures failures
+	// in a row, or "error-rate", tripping when more than FailureRate percent
+	// (0-100) of the last FailureRateWindow calls failed
+	Mode              string
+	FailureRate       float64
+	FailureRateWindow int // calls in the window (default 100)
+	// FailureRateMinCalls is the number of calls in the window before the
+	// rate is evaluated (default FailureRateWindow)
+	FailureRateMinCalls int
+	// CircuitHistorySize is the number of state transitions kept for
+	// CircuitHistory (default 20)
+	CircuitHistorySize int
 
 	// Rate limiting
 	MaxRequestsPerSecond int64
+	// RateLimitBurst is the bucket size: how many requests may run at once
+	// after an idle period (default 10 seconds of MaxRequestsPerSecond)
+	RateLimitBurst int64
+	// IdentityRateLimit gives each identity (see WithIdentity) its own
+	// bucket of this many requests per second, and IdentityBurst tokens
+	// (default IdentityRateLimit), both multiplied by the identity's weight
+	// in IdentityWeights (default 1). 0 disables per-identity limits.
+	IdentityRateLimit int64
+	IdentityBurst     int64
+	IdentityWeights   map[string]float64
 
 	// Connection limiting
 	MaxConcurrentConnections int64
+	// Bulkheads are named concurrency limits inside MaxConcurrentConnections,
+	// selected per call with WithBulkhead
+	Bulkheads map[string]int64
+	// PriorityReserve is the number of connection slots kept for each
+	// priority above another (see WithPriority): normal calls leave it free
+	// for high ones, low calls twice as many. 0 disables priorities.
+	PriorityReserve int64
+	// PriorityQueueTimeout is how long a call waits for room for its
+	// priority before ErrLoadShed; 0 sheds at once
+	PriorityQueueTimeout time.Duration
 
 	// Backpressure behavior when hitting connection limit
 	// Modes:
@@ -97,21 +204,62 @@ type GateConfig struct {
 	BackpressureTimeout time.Duration
 }
 
-// Allow checks if a connection request should be allowed
+// Allow checks if a connection request should be allowed. If ctx selects
+// a bulkhead (WithBulkhead), the call must end with ReleaseContext or
+// RecordFailureContext on the same ctx.
 func (cg *ConnectionGate) Allow(ctx context.Context) error {
 	// Check circuit breaker
 	if err := cg.circuitBreaker.Allow(ctx); err != nil {
 		return err
 	}
 
-	// Check rate limiter
-	if err := cg.rateLimiter.Allow(); err != nil {
-		cg.circuitBreaker.RecordFailure()
+	// Shed work the process cannot afford, before it takes any token
+	if err := cg.shedder.admit(ctx); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := cg.takeTokens(ctx)
+	cg.rateWait.observe(time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	err = cg.acquireSlots(ctx)
+	cg.connWait.observe(time.Since(start))
+	return err
+}
+
+// takeTokens applies the rate limits, charging the cost of ctx (WithCost)
+func (cg *ConnectionGate) takeTokens(ctx context.Context) error {
+	// Running over the rate, globally or as a tenant over its quota, says
+	// nothing about the database, so it leaves the circuit alone
+	if err := cg.rateLimiter.AllowN(CostFrom(ctx)); err != nil {
+		return err
+	}
+	return cg.identities.allow(ctx)
+}
+
+// acquireSlots takes the bulkhead and connection slots
+func (cg *ConnectionGate) acquireSlots(ctx context.Context) error {
+	// Check the bulkhead before the shared limit, so waiting for a busy
+	// bulkhead does not hold a slot other workloads could use. A full
+	// bulkhead is not a database failure and leaves the circuit alone.
+	if err := cg.acquireBulkhead(ctx); err != nil {
+		return err
+	}
+
+	// Shed lower priorities first when the shared limit fills up; shedding
+	// is by design, not a database failure
+	if err := cg.priority.admit(ctx); err != nil {
+		cg.releaseBulkhead(ctx)
 		return err
 	}
 
 	// Check connection limiter
 	if err := cg.connectionLimiter.AcquireWithContext(ctx); err != nil {
+		cg.releaseBulkhead(ctx)
 		cg.circuitBreaker.RecordFailure()
 		return err
 	}
@@ -122,6 +270,14 @@ func (cg *ConnectionGate) Allow(ctx context.Context) error {
 // Release releases a connection slot
 func (cg *ConnectionGate) Release() {
 	cg.connectionLimiter.Release()
+	cg.priority.notify()
+}
+
+// ReleaseContext releases the connection slot and bulkhead slot taken by
+// Allow(ctx)
+func (cg *ConnectionGate) ReleaseContext(ctx context.Context) {
+	cg.releaseBulkhead(ctx)
+	cg.Release()
 }
 
 // RecordSuccess records a successful operation
@@ -132,7 +288,14 @@ func (cg *ConnectionGate) RecordSuccess() {
 // RecordFailure records a failed operation
 func (cg *ConnectionGate) RecordFailure() {
 	cg.circuitBreaker.RecordFailure()
-	cg.connectionLimiter.Release()
+	cg.Release()
+}
+
+// RecordFailureContext records a failed operation admitted by Allow(ctx)
+// and releases its slots
+func (cg *ConnectionGate) RecordFailureContext(ctx context.Context) {
+	cg.releaseBulkhead(ctx)
+	cg.RecordFailure()
 }
 
 // State returns the current circuit breaker state
@@ -140,6 +303,11 @@ func (cg *ConnectionGate) State() string {
 	return cg.circuitBreaker.State()
 }
 
+// Rate returns the gate's current request rate in requests per second
+func (cg *ConnectionGate) Rate() float64 {
+	return cg.rateLimiter.Rate()
+}
+
 // NewCircuitBreaker creates a new circuit breaker
 func NewCircuitBreaker(config *GateConfig) *CircuitBreaker {
 	cb := &CircuitBreaker{
@@ -148,6 +316,7 @@ func NewCircuitBreaker(config *GateConfig) *CircuitBreaker {
 		halfOpenTimeout: 10 * time.Second,
 		state:           circuitClosed,
 	}
+	historySize := 0
 
 	if config != nil {
 		if config.MaxFailures > 0 {
@@ -159,7 +328,24 @@ func NewCircuitBreaker(config *GateConfig) *CircuitBreaker {
 		if config.HalfOpenTimeout > 0 {
 			cb.halfOpenTimeout = config.HalfOpenTimeout
 		}
+		historySize = config.CircuitHistorySize
+		if config.Mode == "error-rate" {
+			cb.failureRate = config.FailureRate
+			if cb.failureRate <= 0 {
+				cb.failureRate = 50
+			}
+			size := config.FailureRateWindow
+			if size <= 0 {
+				size = 100
+			}
+			cb.window = make([]bool, size)
+			cb.minCalls = config.FailureRateMinCalls
+			if cb.minCalls <= 0 || cb.minCalls > size {
+				cb.minCalls = size
+			}
+		}
 	}
+	cb.history = newCircuitHistory(historySize)
 
 	return cb
 }
@@ -175,10 +361,8 @@ func (cb *CircuitBreaker) Allow(_ context.Context) error {
 		// Check if we should transition to half-open
 		cb.mu.Lock()
 		if time.Since(cb.lastFailureTime) > cb.resetTimeout {
-			atomic.StoreInt32(&cb.state, circuitHalfOpen)
-			atomic.StoreInt64(&cb.failureCount, 0)
-			if cb.onStateChange != nil {
-				cb.onStateChange(CircuitStateOpen, CircuitStateHalfOpen)
+			if cb.transition(circuitOpen, circuitHalfOpen, fmt.Sprintf("reset timeout of %v elapsed", cb.resetTimeout)) {
+				atomic.StoreInt64(&cb.failureCount, 0)
 			}
 			cb.mu.Unlock()
 			return nil
@@ -202,14 +386,18 @@ func (cb *CircuitBreaker) RecordSuccess() {
 
 	if state == circuitHalfOpen {
 		cb.mu.Lock()
-		atomic.StoreInt32(&cb.state, circuitClosed)
-		atomic.StoreInt64(&cb.failureCount, 0)
-		if cb.onStateChange != nil {
-			cb.onStateChange(CircuitStateHalfOpen, CircuitStateClosed)
+		if cb.transition(circuitHalfOpen, circuitClosed, "trial call succeeded") {
+			atomic.StoreInt64(&cb.failureCount, 0)
+			cb.resetWindow()
 		}
 		cb.mu.Unlock()
 	} else if state == circuitClosed {
 		atomic.StoreInt64(&cb.failureCount, 0)
+		if cb.window != nil {
+			cb.mu.Lock()
+			cb.recordOutcome(false)
+			cb.mu.Unlock()
+		}
 	}
 }
 
@@ -224,21 +412,55 @@ func (cb *CircuitBreaker) RecordFailure() {
 
 	if state == circuitHalfOpen {
 		// Immediately open on failure in half-open state
-		atomic.StoreInt32(&cb.state, circuitOpen)
-		if cb.onStateChange != nil {
-			cb.onStateChange(CircuitStateHalfOpen, CircuitStateOpen)
-		}
-	} else if state == circuitClosed && int(failures) >= cb.maxFailures {
-		atomic.StoreInt32(&cb.state, circuitOpen)
-		if cb.onStateChange != nil {
-			cb.onStateChange(CircuitStateClosed, CircuitStateOpen)
+		cb.transition(circuitHalfOpen, circuitOpen, "trial call failed")
+	} else if state == circuitClosed && cb.shouldTrip(failures) {
+		cb.transition(circuitClosed, circuitOpen, cb.tripReason(failures))
+		cb.resetWindow()
+	}
+}
+
+// shouldTrip reports whether a failure in the closed state opens the
+// circuit. Called with mu held.
+func (cb *CircuitBreaker) shouldTrip(consecutive int64) bool {
+	if cb.window == nil {
+		return int(consecutive) >= cb.maxFailures
+	}
+	cb.recordOutcome(true)
+	if cb.windowCalls < cb.minCalls {
+		return false
+	}
+	return float64(cb.windowFailed)*100 > cb.failureRate*float64(cb.windowCalls)
+}
+
+// recordOutcome adds a call to the error-rate window. Called with mu held.
+func (cb *CircuitBreaker) recordOutcome(failed bool) {
+	if cb.windowCalls == len(cb.window) {
+		if cb.window[cb.windowNext] {
+			cb.windowFailed--
 		}
+	} else {
+		cb.windowCalls++
+	}
+	cb.window[cb.windowNext] = failed
+	if failed {
+		cb.windowFailed++
 	}
+	cb.windowNext = (cb.windowNext + 1) % len(cb.window)
+}
+
+// resetWindow forgets the error-rate window, so a recovered database is
+// judged on new calls only. Called with mu held.
+func (cb *CircuitBreaker) resetWindow() {
+	cb.windowNext, cb.windowCalls, cb.windowFailed = 0, 0, 0
 }
 
 // State returns the current state as a string
 func (cb *CircuitBreaker) State() string {
-	state := atomic.LoadInt32(&cb.state)
+	return circuitStateName(atomic.LoadInt32(&cb.state))
+}
+
+// circuitStateName returns the CircuitState* name of a state
+func circuitStateName(state int32) string {
 	switch state {
 	case circuitClosed:
 		return CircuitStateClosed
@@ -259,35 +481,63 @@ func NewRateLimiter(config *GateConfig) *RateLimiter {
 	}
 
 	if config != nil && config.MaxRequestsPerSecond > 0 {
-		rl.maxTokens = config.MaxRequestsPerSecond * 10 // 10 seconds worth
-		rl.refillRate = config.MaxRequestsPerSecond
+		rl.maxTokens = float64(config.MaxRequestsPerSecond * 10) // 10 seconds worth
+		rl.refillRate = float64(config.MaxRequestsPerSecond)
+	}
+	if config != nil && config.RateLimitBurst > 0 {
+		rl.maxTokens = float64(config.RateLimitBurst)
 	}
 
 	rl.tokens = rl.maxTokens
 	return rl
 }
 
-// Allow checks if a request is allowed under rate limiting
+// Allow checks if a request is allowed under rate limiting. When it is not,
+// the error is a *RateLimitError telling when the next token is due.
 func (rl *RateLimiter) Allow() error {
+	return rl.AllowN(1)
+}
+
+// AllowN takes n tokens, failing with a *RateLimitError if there are not
+// enough. A cost above the burst size takes a full bucket, so it can still
+// run.
+func (rl *RateLimiter) AllowN(n float64) error {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now()
-	elapsed := now.Sub(rl.lastRefill)
-
 	// Refill tokens
-	tokensToAdd := int64(elapsed.Seconds() * float64(rl.refillRate))
-	if tokensToAdd > 0 {
-		rl.tokens = min(rl.tokens+tokensToAdd, rl.maxTokens)
-		rl.lastRefill = now
-	}
+	now := time.Now()
+	rl.tokens = min(rl.tokens+now.Sub(rl.lastRefill).Seconds()*rl.refillRate, rl.maxTokens)
+	rl.lastRefill = now
 
-	if rl.tokens > 0 {
-		rl.tokens--
+	n = min(n, rl.maxTokens)
+	if rl.tokens >= n {
+		rl.tokens -= n
 		return nil
 	}
 
-	return ErrRateLimitExceeded
+	missing := n - rl.tokens
+	return &RateLimitError{RetryAfter: time.Duration(missing / rl.refillRate * float64(time.Second))}
+}
+
+// SetRate changes the refill rate (tokens per second). Tokens already in
+// the bucket and the burst size are kept.
+func (rl *RateLimiter) SetRate(rate float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Refill at the old rate up to now, so the change is not retroactive
+	now := time.Now()
+	rl.tokens = min(rl.tokens+now.Sub(rl.lastRefill).Seconds()*rl.refillRate, rl.maxTokens)
+	rl.lastRefill = now
+	rl.refillRate = rate
+}
+
+// Rate returns the refill rate in tokens per second
+func (rl *RateLimiter) Rate() float64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.refillRate
 }
 
 // NewConnectionLimiter creates a new connection limiter
@@ -299,96 +549,167 @@ func NewConnectionLimiter(config *GateConfig) *ConnectionLimiter {
 	if config != nil && config.MaxConcurrentConnections > 0 {
 		cl.maxConnections = config.MaxConcurrentConnections
 	}
-
 	if config != nil {
 		cl.mode = config.BackpressureMode
 		cl.timeout = config.BackpressureTimeout
-		if cl.mode == "block" || cl.mode == "timeout" {
-			// semaphore with capacity = maxConnections to block when full
-			cl.sem = make(chan struct{}, cl.maxConnections)
-		}
 	}
+	cl.slots = make(chan struct{}, cl.maxConnections)
 
 	return cl
 }
 
-// Acquire acquires a connection slot
+// Acquire acquires a connection slot without waiting
 func (cl *ConnectionLimiter) Acquire() error {
-	cl.mu.Lock()
-	defer cl.mu.Unlock()
-
-	if atomic.LoadInt64(&cl.currentConnections) >= cl.maxConnections {
-		return ErrConnectionLimit
+	select {
+	case cl.slots <- struct{}{}:
+		return nil
+	default:
+		return cl.limitError()
 	}
-
-	atomic.AddInt64(&cl.currentConnections, 1)
-	return nil
 }
 
 // AcquireWithContext acquires a connection slot with backpressure behavior
 func (cl *ConnectionLimiter) AcquireWithContext(ctx context.Context) error {
-	// If no backpressure semaphore configured, use legacy non-blocking path
-	if cl.sem == nil {
-		return cl.Acquire()
-	}
-
-	// Fast path: if below maxConnections we also increment counters
-	// We rely on semaphore to represent in-flight usage consistently
-	select {
-	case cl.sem <- struct{}{}:
-		atomic.AddInt64(&cl.currentConnections, 1)
+	// Fast path: a free slot
+	if err := cl.Acquire(); err == nil {
 		return nil
-	default:
-		// Full: apply backpressure according to mode
 	}
 
+	// Full: apply backpressure according to mode
+	var timeout <-chan time.Time
 	switch cl.mode {
 	case "block":
-		select {
-		case cl.sem <- struct{}{}:
-			atomic.AddInt64(&cl.currentConnections, 1)
-			return nil
-		case <-ctx.Done():
-			return ctx.Err()
-		}
 	case "timeout":
-		timeout := cl.timeout
-		if timeout <= 0 {
+		if cl.timeout <= 0 {
 			// fallback to immediate failure if timeout not set
-			return ErrConnectionLimit
+			return cl.limitError()
 		}
-		timer := time.NewTimer(timeout)
+		timer := time.NewTimer(cl.timeout)
 		defer timer.Stop()
-		select {
-		case cl.sem <- struct{}{}:
-			atomic.AddInt64(&cl.currentConnections, 1)
-			return nil
-		case <-timer.C:
-			return ErrConnectionLimit
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+		timeout = timer.C
 	default:
 		// drop (legacy behavior)
-		return ErrConnectionLimit
+		return cl.limitError()
+	}
+
+	cl.waiting.Add(1)
+	defer cl.waiting.Add(-1)
+	select {
+	case cl.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return cl.limitError()
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-// Release releases a connection slot
+// Release releases a connection slot. Releasing more slots than were
+// acquired is a no-op, so it cannot raise the limit.
 func (cl *ConnectionLimiter) Release() {
-	if cl.sem != nil {
-		// release semaphore token if acquired
+	if !cl.releaseSlot() {
+		return
+	}
+
+	now := time.Now()
+	cl.releaseMu.Lock()
+	defer cl.releaseMu.Unlock()
+	if !cl.lastRelease.IsZero() {
+		gap := min(now.Sub(cl.lastRelease), maxSlotRetryAfter)
+		if cl.releaseGap == 0 {
+			cl.releaseGap = gap
+		} else {
+			cl.releaseGap += (gap - cl.releaseGap) / 5
+		}
+	}
+	cl.lastRelease = now
+}
+
+// limitError returns a *ConnectionLimitError whose RetryAfter is the time
+// for the calls already waiting, and this one, to get a slot at the recent
+// release rate
+func (cl *ConnectionLimiter) limitError() error {
+	cl.releaseMu.Lock()
+	gap := cl.releaseGap
+	cl.releaseMu.Unlock()
+
+	retryAfter := gap * time.Duration(cl.Waiting()+1)
+	return &ConnectionLimitError{RetryAfter: min(max(retryAfter, minSlotRetryAfter), maxSlotRetryAfter)}
+}
+
+// releaseSlot frees a slot in use, or keeps it for a pending SetLimit
+// decrease. It reports whether a slot was freed.
+func (cl *ConnectionLimiter) releaseSlot() bool {
+	cl.resizeMu.Lock()
+	defer cl.resizeMu.Unlock()
+
+	// The first reserved slots are held by SetLimit, not by connections
+	if int64(len(cl.slots)) <= cl.reserved.Load() {
+		return false
+	}
+	if cl.pending.Load() > 0 {
+		cl.pending.Add(-1)
+		cl.reserved.Add(1)
+		return false
+	}
+	select {
+	case <-cl.slots:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetLimit changes the number of slots, between 1 and MaxConnections.
+// Lowering it below the slots in use takes effect as they are released.
+func (cl *ConnectionLimiter) SetLimit(limit int64) {
+	limit = min(max(limit, 1), cl.maxConnections)
+
+	cl.resizeMu.Lock()
+	defer cl.resizeMu.Unlock()
+
+	delta := cl.maxConnections - limit - cl.reserved.Load() - cl.pending.Load()
+	for ; delta > 0; delta-- {
 		select {
-		case <-cl.sem:
+		case cl.slots <- struct{}{}:
+			cl.reserved.Add(1)
 		default:
+			cl.pending.Add(1)
+		}
+	}
+	for ; delta < 0; delta++ {
+		if cl.pending.Load() > 0 {
+			cl.pending.Add(-1)
+			continue
+		}
+		select {
+		case <-cl.slots:
+			cl.reserved.Add(-1)
+		default:
+			return
 		}
 	}
-	atomic.AddInt64(&cl.currentConnections, -1)
+}
+
+// Limit returns the number of slots, MaxConnections unless lowered with
+// SetLimit
+func (cl *ConnectionLimiter) Limit() int64 {
+	return cl.maxConnections - cl.reserved.Load() - cl.pending.Load()
 }
 
 // CurrentConnections returns the current number of connections
 func (cl *ConnectionLimiter) CurrentConnections() int64 {
-	return atomic.LoadInt64(&cl.currentConnections)
+	return int64(len(cl.slots)) - cl.reserved.Load()
+}
+
+// Waiting returns the number of calls blocked waiting for a slot
+func (cl *ConnectionLimiter) Waiting() int64 {
+	return cl.waiting.Load()
+}
+
+// MaxConnections returns the configured number of slots
+func (cl *ConnectionLimiter) MaxConnections() int64 {
+	return cl.maxConnections
 }
 
 // ExecuteWithGate executes a database operation with gate protection
@@ -408,17 +729,11 @@ func ExecuteWithGate[T any](
 	result, err := operation(ctx)
 
 	if err != nil {
-		gate.RecordFailure()
+		gate.RecordFailureContext(ctx)
 		return zero, err
 	}
 
 	gate.RecordSuccess()
+	gate.ReleaseContext(ctx)
 	return result, nil
 }
-
-func min(a, b int64) int64 {
-	if a < b {
-		return a
-	}
-	return b
-}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"testing"
 	"time"
@@ -185,6 +186,74 @@ func TestConnectionLimiter_Release(t *testing.T) {
 	}
 }
 
+func TestConnectionLimiter_ExtraRelease(t *testing.T) {
+	cl := NewConnectionLimiter(&GateConfig{
+		MaxConcurrentConnections: 2,
+	})
+
+	// Releasing unheld slots must not raise the limit
+	cl.Release()
+	cl.Release()
+	for i := 0; i < 2; i++ {
+		if err := cl.Acquire(); err != nil {
+			t.Fatalf("Acquire() should succeed, got error: %v", err)
+		}
+	}
+	err := cl.Acquire()
+	if !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("Expected ErrConnectionLimit, got %v", err)
+	}
+	var limitErr *ConnectionLimitError
+	if !errors.As(err, &limitErr) || limitErr.RetryAfter < minSlotRetryAfter {
+		t.Errorf("Expected a retry after hint, got %v", err)
+	}
+	if got := cl.CurrentConnections(); got != 2 {
+		t.Errorf("Expected 2 connections, got %d", got)
+	}
+}
+
+func TestConnectionLimiter_Waiting(t *testing.T) {
+	cl := NewConnectionLimiter(&GateConfig{
+		MaxConcurrentConnections: 1,
+		BackpressureMode:         "block",
+	})
+	if err := cl.AcquireWithContext(context.Background()); err != nil {
+		t.Fatalf("AcquireWithContext failed: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- cl.AcquireWithContext(context.Background()) }()
+	deadline := time.Now().Add(time.Second)
+	for cl.Waiting() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := cl.Waiting(); got != 1 {
+		t.Fatalf("Expected 1 waiting call, got %d", got)
+	}
+
+	cl.Release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("Blocked AcquireWithContext failed: %v", err)
+	}
+	if got := cl.Waiting(); got != 0 {
+		t.Errorf("Expected no waiting calls, got %d", got)
+	}
+	if got := cl.CurrentConnections(); got != 1 {
+		t.Errorf("Expected 1 connection, got %d", got)
+	}
+
+	// Timeout mode gives up after the timeout
+	cl = NewConnectionLimiter(&GateConfig{
+		MaxConcurrentConnections: 1,
+		BackpressureMode:         "timeout",
+		BackpressureTimeout:      10 * time.Millisecond,
+	})
+	cl.Acquire()
+	if err := cl.AcquireWithContext(context.Background()); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("Expected ErrConnectionLimit after the timeout, got %v", err)
+	}
+}
+
 func TestExecuteWithGate(t *testing.T) {
 	gate := NewConnectionGate(nil)
 	ctx := context.Background()
@@ -202,6 +271,27 @@ func TestExecuteWithGate(t *testing.T) {
 	}
 }
 
+func TestExecuteWithGate_ReleasesSlot(t *testing.T) {
+	gate := NewConnectionGate(&GateConfig{
+		MaxConcurrentConnections: 2,
+		MaxRequestsPerSecond:     1000,
+	})
+	ctx := context.Background()
+
+	// More successful operations than the limit must not exhaust it
+	for i := 0; i < 5; i++ {
+		if _, err := ExecuteWithGate(gate, ctx, func(ctx context.Context) (int, error) {
+			return i, nil
+		}); err != nil {
+			t.Fatalf("ExecuteWithGate() call %d failed: %v", i, err)
+		}
+	}
+
+	if n := gate.connectionLimiter.CurrentConnections(); n != 0 {
+		t.Errorf("Expected 0 connections held, got %d", n)
+	}
+}
+
 func TestExecuteWithGate_Error(t *testing.T) {
 	gate := NewConnectionGate(nil)
 	ctx := context.Background()
@@ -219,3 +309,599 @@ func TestExecuteWithGate_Error(t *testing.T) {
 		t.Errorf("Expected test error, got %v", err)
 	}
 }
+
+func TestRateLimiter_Burst(t *testing.T) {
+	rl := NewRateLimiter(&GateConfig{
+		MaxRequestsPerSecond: 20,
+		RateLimitBurst:       2,
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := rl.Allow(); err != nil {
+			t.Fatalf("Allow() within burst should succeed, got error: %v", err)
+		}
+	}
+
+	err := rl.Allow()
+	var rateErr *RateLimitError
+	if !errors.As(err, &rateErr) || !errors.Is(err, ErrRateLimitExceeded) {
+		t.Fatalf("Expected a RateLimitError, got %v", err)
+	}
+	if rateErr.RetryAfter <= 0 || rateErr.RetryAfter > 50*time.Millisecond {
+		t.Errorf("Expected retry after at most 50ms, got %v", rateErr.RetryAfter)
+	}
+
+	// At 20/s a token is due every 50ms; partial refills carry over
+	time.Sleep(20 * time.Millisecond)
+	if err := rl.Allow(); err == nil {
+		t.Error("Expected no token after 20ms")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := rl.Allow(); err != nil {
+		t.Errorf("Expected a token after 60ms, got error: %v", err)
+	}
+}
+
+func TestCircuitBreaker_ErrorRate(t *testing.T) {
+	cb := NewCircuitBreaker(&GateConfig{
+		Mode:              "error-rate",
+		FailureRate:       50,
+		FailureRateWindow: 10,
+	})
+
+	// Alternating failures never trip the consecutive mode, and stay at 50%
+	for i := 0; i < 10; i++ {
+		cb.RecordFailure()
+		cb.RecordSuccess()
+	}
+	if cb.State() != CircuitStateClosed {
+		t.Fatalf("Expected closed at 50%% failures, got %s", cb.State())
+	}
+
+	// Window now holds 6 failures out of 10
+	cb.RecordFailure()
+	cb.RecordFailure()
+	if cb.State() != CircuitStateOpen {
+		t.Errorf("Expected open above 50%% failures, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreaker_ErrorRateMinCalls(t *testing.T) {
+	cb := NewCircuitBreaker(&GateConfig{
+		Mode:                "error-rate",
+		FailureRateWindow:   100,
+		FailureRateMinCalls: 5,
+	})
+
+	for i := 0; i < 4; i++ {
+		cb.RecordFailure()
+	}
+	if cb.State() != CircuitStateClosed {
+		t.Fatalf("Expected closed below the minimum calls, got %s", cb.State())
+	}
+	cb.RecordFailure()
+	if cb.State() != CircuitStateOpen {
+		t.Errorf("Expected open once the minimum calls is reached, got %s", cb.State())
+	}
+}
+
+func TestConnectionGate_Priority(t *testing.T) {
+	gate := NewConnectionGate(&GateConfig{
+		MaxConcurrentConnections: 4,
+		PriorityReserve:          1,
+	})
+	ctx := context.Background()
+	low := WithPriority(ctx, PriorityLow)
+	high := WithPriority(ctx, PriorityHigh)
+
+	// Low priority may fill 2 slots, normal 3 and high all 4
+	for i := 0; i < 2; i++ {
+		if err := gate.Allow(low); err != nil {
+			t.Fatalf("Allow(low) failed: %v", err)
+		}
+	}
+	if err := gate.Allow(low); !errors.Is(err, ErrLoadShed) {
+		t.Fatalf("Expected low priority to be shed, got %v", err)
+	}
+	if err := gate.Allow(ctx); err != nil {
+		t.Fatalf("Allow(normal) failed: %v", err)
+	}
+	if err := gate.Allow(ctx); !errors.Is(err, ErrLoadShed) {
+		t.Fatalf("Expected normal priority to be shed, got %v", err)
+	}
+	if err := gate.Allow(high); err != nil {
+		t.Fatalf("Allow(high) failed: %v", err)
+	}
+
+	shed := gate.ShedCounts()
+	if shed["low"] != 1 || shed["normal"] != 1 || shed["high"] != 0 {
+		t.Errorf("Unexpected shed counts: %v", shed)
+	}
+	if gate.State() != CircuitStateClosed {
+		t.Errorf("Expected shedding to leave the circuit closed, got %s", gate.State())
+	}
+}
+
+func TestConnectionGate_PriorityQueue(t *testing.T) {
+	gate := NewConnectionGate(&GateConfig{
+		MaxConcurrentConnections: 2,
+		PriorityReserve:          1,
+		PriorityQueueTimeout:     time.Second,
+	})
+	ctx := context.Background()
+	if err := gate.Allow(ctx); err != nil {
+		t.Fatalf("Allow failed: %v", err)
+	}
+
+	// A queued call is admitted once a slot is released
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		gate.Release()
+	}()
+	if err := gate.Allow(ctx); err != nil {
+		t.Errorf("Expected the queued call to be admitted, got %v", err)
+	}
+}
+
+func TestAdaptiveRateController(t *testing.T) {
+	limiter := NewRateLimiter(&GateConfig{MaxRequestsPerSecond: 100})
+	var stats sql.DBStats
+	c := newAdaptiveRateController(AdaptiveRateConfig{
+		TargetP95:   10 * time.Millisecond,
+		MaxPoolWait: 50 * time.Millisecond,
+		MinRate:     40,
+	}, limiter, func() sql.DBStats { return stats })
+	after := c.hooks().After
+	ctx := context.Background()
+
+	// Slow statements lower the rate
+	for i := 0; i < 20; i++ {
+		after(ctx, "SELECT 1", 20*time.Millisecond, nil)
+	}
+	c.adjust()
+	if rate := limiter.Rate(); rate != 70 {
+		t.Fatalf("Expected rate 70 after slow statements, got %v", rate)
+	}
+
+	// Pool waits lower it too, down to MinRate
+	stats.WaitDuration = time.Second
+	c.adjust()
+	if rate := limiter.Rate(); rate != 49 {
+		t.Fatalf("Expected rate 49 after pool waits, got %v", rate)
+	}
+	stats.WaitDuration = 2 * time.Second
+	c.adjust()
+	if rate := limiter.Rate(); rate != 40 {
+		t.Fatalf("Expected rate capped at MinRate 40, got %v", rate)
+	}
+
+	// Healthy intervals raise it back gradually
+	for i := 0; i < 3; i++ {
+		after(ctx, "SELECT 1", time.Millisecond, nil)
+		c.adjust()
+	}
+	if rate := limiter.Rate(); rate != 55 {
+		t.Errorf("Expected rate 55 after 3 healthy intervals, got %v", rate)
+	}
+	if s := c.snapshot(); s.Decreases != 3 || s.Increases != 3 {
+		t.Errorf("Unexpected controller stats: %+v", s)
+	}
+}
+
+func TestConnectionGate_IdentityRateLimit(t *testing.T) {
+	gate := NewConnectionGate(&GateConfig{
+		IdentityRateLimit: 1,
+		IdentityBurst:     2,
+		IdentityWeights:   map[string]float64{"gold": 2},
+	})
+	ctx := context.Background()
+	allowed := func(ctx context.Context, n int) int {
+		ok := 0
+		for i := 0; i < n; i++ {
+			if err := gate.Allow(ctx); err == nil {
+				ok++
+				gate.Release()
+			}
+		}
+		return ok
+	}
+
+	if n := allowed(WithIdentity(ctx, "basic"), 5); n != 2 {
+		t.Errorf("Expected 2 calls for a weight 1 identity, got %d", n)
+	}
+	if n := allowed(WithIdentity(ctx, "gold"), 5); n != 4 {
+		t.Errorf("Expected 4 calls for a weight 2 identity, got %d", n)
+	}
+	if n := allowed(ctx, 5); n != 5 {
+		t.Errorf("Expected calls without identity to be unlimited, got %d", n)
+	}
+
+	err := gate.Allow(WithIdentity(ctx, "basic"))
+	var rateErr *RateLimitError
+	if !errors.As(err, &rateErr) || rateErr.Identity != "basic" {
+		t.Errorf("Expected a RateLimitError for basic, got %v", err)
+	}
+	if gate.State() != CircuitStateClosed {
+		t.Errorf("Expected identity limits to leave the circuit closed, got %s", gate.State())
+	}
+}
+
+func TestConnectionGate_WaitStats(t *testing.T) {
+	gate := NewConnectionGate(&GateConfig{
+		MaxConcurrentConnections: 1,
+		BackpressureMode:         "block",
+	})
+	ctx := context.Background()
+	if err := gate.Allow(ctx); err != nil {
+		t.Fatalf("Allow failed: %v", err)
+	}
+
+	// The second call waits about 20ms for the slot
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		gate.Release()
+	}()
+	if err := gate.Allow(ctx); err != nil {
+		t.Fatalf("Allow failed: %v", err)
+	}
+
+	stats := gate.WaitStats()
+	if stats.RateLimit.Count != 2 || stats.Connection.Count != 2 {
+		t.Fatalf("Expected 2 observations per stage, got %d and %d", stats.RateLimit.Count, stats.Connection.Count)
+	}
+	if len(stats.Connection.Counts) != len(stats.Connection.Bounds)+1 {
+		t.Errorf("Expected one count per bound plus overflow, got %d counts", len(stats.Connection.Counts))
+	}
+	// 20ms falls in the (10ms, 50ms] bucket
+	if stats.Connection.Counts[4] != 1 || stats.Connection.Total < 20*time.Millisecond {
+		t.Errorf("Expected one wait of about 20ms, got %+v", stats.Connection)
+	}
+}
+
+func TestConnectionGate_Cost(t *testing.T) {
+	gate := NewConnectionGate(&GateConfig{
+		MaxRequestsPerSecond: 1,
+		RateLimitBurst:       10,
+	})
+	report := WithCost(context.Background(), 4)
+
+	for i := 0; i < 2; i++ {
+		if err := gate.Allow(report); err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+		gate.Release()
+	}
+	// 2 tokens left: not enough for a report, enough for 2 lookups
+	if err := gate.Allow(report); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("Expected the report to be rate limited, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := gate.Allow(context.Background()); err != nil {
+			t.Errorf("Expected a lookup to be allowed, got %v", err)
+		}
+		gate.Release()
+	}
+}
+
+func TestConnectionGate_RateLimitLeavesCircuit(t *testing.T) {
+	gate := NewConnectionGate(&GateConfig{
+		MaxRequestsPerSecond: 1,
+		RateLimitBurst:       1,
+		MaxFailures:          3,
+	})
+	ctx := context.Background()
+
+	if err := gate.Allow(ctx); err != nil {
+		t.Fatalf("Allow failed: %v", err)
+	}
+	gate.RecordSuccess()
+	gate.Release()
+	for i := 0; i < 10; i++ {
+		if err := gate.Allow(ctx); !errors.Is(err, ErrRateLimitExceeded) {
+			t.Fatalf("Expected the call to be rate limited, got %v", err)
+		}
+	}
+	if gate.State() != CircuitStateClosed {
+		t.Errorf("Expected rate limiting to leave the circuit closed, got %s", gate.State())
+	}
+}
+
+func TestStatementCosts(t *testing.T) {
+	cost := StatementCosts(map[string]float64{"SELECT": 5, "INSERT": 2})
+	tests := []struct {
+		query string
+		want  float64
+	}{
+		{"SELECT * FROM orders", 5},
+		{"/* report */ select count(*) FROM orders", 5},
+		{"INSERT INTO orders VALUES (1)", 2},
+		{"DELETE FROM orders", 1},
+	}
+	for _, tt := range tests {
+		if got := cost(tt.query, nil); got != tt.want {
+			t.Errorf("StatementCosts(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCircuitBreaker_History(t *testing.T) {
+	cb := NewCircuitBreaker(&GateConfig{
+		MaxFailures:  2,
+		ResetTimeout: 10 * time.Millisecond,
+	})
+
+	cb.RecordFailure()
+	cb.RecordFailure()
+	time.Sleep(20 * time.Millisecond)
+	if err := cb.Allow(context.Background()); err != nil {
+		t.Fatalf("Expected a trial call after the reset timeout, got %v", err)
+	}
+	cb.RecordSuccess()
+	cb.RecordSuccess()
+
+	history := cb.History()
+	want := []struct{ from, to, reason string }{
+		{CircuitStateClosed, CircuitStateOpen, "2 consecutive failures"},
+		{CircuitStateOpen, CircuitStateHalfOpen, "reset timeout of 10ms elapsed"},
+		{CircuitStateHalfOpen, CircuitStateClosed, "trial call succeeded"},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("Expected %d transitions, got %+v", len(want), history)
+	}
+	for i, w := range want {
+		got := history[i]
+		if got.From != w.from || got.To != w.to || got.Reason != w.reason || got.Time.IsZero() {
+			t.Errorf("Transition %d = %+v, want %s -> %s (%s)", i, got, w.from, w.to, w.reason)
+		}
+	}
+
+	// Only the last transitions are kept
+	cb = NewCircuitBreaker(&GateConfig{
+		MaxFailures:        1,
+		ResetTimeout:       time.Millisecond,
+		CircuitHistorySize: 2,
+	})
+	cb.RecordFailure()
+	time.Sleep(5 * time.Millisecond)
+	cb.Allow(context.Background())
+	cb.RecordFailure()
+	history = cb.History()
+	if len(history) != 2 || history[0].To != CircuitStateHalfOpen || history[1].Reason != "trial call failed" {
+		t.Errorf("Expected the last 2 transitions, got %+v", history)
+	}
+}
+
+func TestConnectionLimiter_SetLimit(t *testing.T) {
+	cl := NewConnectionLimiter(&GateConfig{MaxConcurrentConnections: 4})
+	for i := 0; i < 3; i++ {
+		cl.Acquire()
+	}
+
+	// Lowering below the slots in use applies as they are released
+	cl.SetLimit(2)
+	if got := cl.Limit(); got != 2 {
+		t.Fatalf("Expected limit 2, got %d", got)
+	}
+	if got := cl.CurrentConnections(); got != 3 {
+		t.Errorf("Expected 3 connections, got %d", got)
+	}
+	if err := cl.Acquire(); err == nil {
+		t.Error("Acquire() should fail above the new limit")
+	}
+	cl.Release()
+	if err := cl.Acquire(); err == nil {
+		t.Error("Acquire() should fail until the connections are under the limit")
+	}
+	cl.Release()
+	if got := cl.CurrentConnections(); got != 1 {
+		t.Errorf("Expected 1 connection, got %d", got)
+	}
+	if err := cl.Acquire(); err != nil {
+		t.Errorf("Acquire() should succeed under the new limit, got %v", err)
+	}
+
+	// Raising frees the reserved slots, up to MaxConnections
+	cl.SetLimit(10)
+	if got := cl.Limit(); got != 4 {
+		t.Errorf("Expected limit capped at 4, got %d", got)
+	}
+	for i := 0; i < 2; i++ {
+		if err := cl.Acquire(); err != nil {
+			t.Errorf("Acquire() should succeed after raising the limit, got %v", err)
+		}
+	}
+	if got := cl.CurrentConnections(); got != 4 {
+		t.Errorf("Expected 4 connections, got %d", got)
+	}
+}
+
+func TestConnectionLimiter_ExtraReleaseAfterSetLimit(t *testing.T) {
+	cl := NewConnectionLimiter(&GateConfig{MaxConcurrentConnections: 4})
+	cl.SetLimit(2)
+
+	// Releasing unheld slots must not free the slots SetLimit holds
+	cl.Acquire()
+	cl.Release()
+	cl.Release()
+	cl.Release()
+	if got := cl.CurrentConnections(); got != 0 {
+		t.Errorf("Expected 0 connections, got %d", got)
+	}
+	if got := cl.Limit(); got != 2 {
+		t.Errorf("Expected limit 2, got %d", got)
+	}
+	for i := 0; i < 2; i++ {
+		if err := cl.Acquire(); err != nil {
+			t.Fatalf("Acquire() should succeed, got error: %v", err)
+		}
+	}
+	if err := cl.Acquire(); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("Expected ErrConnectionLimit above the lowered limit, got %v", err)
+	}
+}
+
+func TestConcurrencyAutoscaler(t *testing.T) {
+	limiter := NewConnectionLimiter(&GateConfig{MaxConcurrentConnections: 20})
+	stats := sql.DBStats{MaxOpenConnections: 15}
+	a := newConcurrencyAutoscaler(ConcurrencyAutoscaleConfig{
+		MinLimit: 8,
+	}, limiter, func() sql.DBStats { return stats })
+	a.start()
+	a.close()
+
+	// The limit does not exceed the pool size
+	a.adjust()
+	if got := limiter.Limit(); got != 15 {
+		t.Fatalf("Expected the limit capped at the pool size, got %d", got)
+	}
+
+	// Pool waits lower it, down to MinLimit
+	stats.WaitCount, stats.WaitDuration = 5, 100*time.Millisecond
+	a.adjust()
+	if got := limiter.Limit(); got != 13 {
+		t.Fatalf("Expected limit 13 after pool waits, got %d", got)
+	}
+	for i := 0; i < 10; i++ {
+		stats.WaitCount++
+		a.adjust()
+	}
+	if got := limiter.Limit(); got != 8 {
+		t.Fatalf("Expected limit capped at MinLimit 8, got %d", got)
+	}
+
+	// Intervals without waits raise it back one slot at a time
+	for i := 0; i < 3; i++ {
+		a.adjust()
+	}
+	if got := limiter.Limit(); got != 11 {
+		t.Errorf("Expected limit 11 after 3 intervals without waits, got %d", got)
+	}
+	if s := a.snapshot(); s.Limit != 11 || s.Decreases != 11 || s.Increases != 3 {
+		t.Errorf("Unexpected autoscaler stats: %+v", s)
+	}
+}
+
+func TestConnectionGate_UpdateConfig(t *testing.T) {
+	gate := NewConnectionGate(&GateConfig{
+		MaxFailures:              1,
+		ResetTimeout:             time.Hour,
+		MaxRequestsPerSecond:     10,
+		MaxConcurrentConnections: 4,
+	})
+	gate.Allow(context.Background())
+	gate.RecordFailure()
+	if gate.State() != CircuitStateOpen {
+		t.Fatalf("Expected the circuit to be open, got %s", gate.State())
+	}
+
+	// An invalid field rejects the whole update
+	failures, limit := 3, int64(8)
+	if _, err := gate.UpdateConfig(GateUpdate{MaxFailures: &failures, MaxConcurrentConnections: &limit}); err == nil {
+		t.Error("Expected a concurrency cap above the startup one to be rejected")
+	}
+	if s := gate.Settings(); s.MaxFailures != 1 {
+		t.Errorf("Expected a rejected update to change nothing, got %+v", s)
+	}
+
+	rate, burst, limit := 50.0, 5.0, int64(2)
+	reset := time.Millisecond
+	settings, err := gate.UpdateConfig(GateUpdate{
+		MaxFailures:              &failures,
+		ResetTimeout:             &reset,
+		MaxRequestsPerSecond:     &rate,
+		RateLimitBurst:           &burst,
+		MaxConcurrentConnections: &limit,
+	})
+	if err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	want := GateSettings{
+		MaxFailures:              3,
+		ResetTimeout:             time.Millisecond,
+		HalfOpenTimeout:          10 * time.Second,
+		MaxRequestsPerSecond:     50,
+		RateLimitBurst:           5,
+		MaxConcurrentConnections: 2,
+	}
+	if settings != want {
+		t.Errorf("UpdateConfig() = %+v, want %+v", settings, want)
+	}
+	// The breaker state survives the update
+	if gate.State() != CircuitStateOpen {
+		t.Errorf("Expected the circuit to stay open, got %s", gate.State())
+	}
+
+	// The new limits apply: the shorter reset timeout lets a trial call in,
+	// and the concurrency cap of 2 holds
+	time.Sleep(5 * time.Millisecond)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := gate.Allow(ctx); err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+	}
+	if err := gate.Allow(ctx); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("Expected the new concurrency cap to apply, got %v", err)
+	}
+}
+
+func TestResourceShedder(t *testing.T) {
+	heap, goroutines := uint64(0), 0
+	s := newResourceShedder(ResourcePressureConfig{
+		MaxHeapBytes:  1000,
+		MaxGoroutines: 100,
+	})
+	s.read = func() (uint64, int) { return heap, goroutines }
+	gate := NewConnectionGate(nil)
+	gate.shedder = s
+
+	ctx := context.Background()
+	low := WithPriority(ctx, PriorityLow)
+	high := WithPriority(ctx, PriorityHigh)
+	admitted := func(ctx context.Context) bool {
+		err := gate.Allow(ctx)
+		if err == nil {
+			gate.Release()
+			return true
+		}
+		if !errors.Is(err, ErrLoadShed) {
+			t.Fatalf("Expected ErrLoadShed, got %v", err)
+		}
+		return false
+	}
+
+	s.sample()
+	if !admitted(low) {
+		t.Error("Expected low priority to be admitted without pressure")
+	}
+
+	// At a limit low priority is shed
+	goroutines = 100
+	s.sample()
+	if admitted(low) || !admitted(ctx) {
+		t.Error("Expected only low priority to be shed under elevated pressure")
+	}
+
+	// Past the critical ratio only high priority is admitted
+	heap = 1300
+	s.sample()
+	if admitted(ctx) || !admitted(high) {
+		t.Error("Expected only high priority to be admitted under critical pressure")
+	}
+
+	// Pressure is released below 90% of the limits
+	heap, goroutines = 950, 95
+	s.sample()
+	if got := s.snapshot().Level; got != "elevated" {
+		t.Errorf("Expected elevated pressure near the limits, got %s", got)
+	}
+	heap, goroutines = 500, 50
+	s.sample()
+	stats := s.snapshot()
+	if stats.Level != "none" || !admitted(low) {
+		t.Errorf("Expected the pressure to be released, got %+v", stats)
+	}
+	if stats.Shed != 2 || stats.HeapBytes != 500 || stats.Goroutines != 50 {
+		t.Errorf("Unexpected shedder stats: %+v", stats)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// GateSettings are the gate limits that can be changed while the runtime
+// is running
+type GateSettings struct {
+	MaxFailures              int           `json:"max_failures"`
+	FailureRate              float64       `json:"failure_rate,omitempty"` // error-rate mode only
+	ResetTimeout             time.Duration `json:"reset_timeout_ns"`
+	HalfOpenTimeout          time.Duration `json:"half_open_timeout_ns"`
+	MaxRequestsPerSecond     float64       `json:"max_requests_per_second"`
+	RateLimitBurst           float64       `json:"rate_limit_burst"`
+	MaxConcurrentConnections int64         `json:"max_concurrent_connections"`
+}
+
+// GateUpdate changes the gate settings. Nil fields are left unchanged.
+type GateUpdate struct {
+	MaxFailures              *int           `json:"max_failures,omitempty"`
+	FailureRate              *float64       `json:"failure_rate,omitempty"`
+	ResetTimeout             *time.Duration `json:"reset_timeout_ns,omitempty"`
+	HalfOpenTimeout          *time.Duration `json:"half_open_timeout_ns,omitempty"`
+	MaxRequestsPerSecond     *float64       `json:"max_requests_per_second,omitempty"`
+	RateLimitBurst           *float64       `json:"rate_limit_burst,omitempty"`
+	MaxConcurrentConnections *int64         `json:"max_concurrent_connections,omitempty"`
+}
+
+// Settings returns the current gate limits
+func (cg *ConnectionGate) Settings() GateSettings {
+	cb := cg.circuitBreaker
+	cb.mu.RLock()
+	settings := GateSettings{
+		MaxFailures:     cb.maxFailures,
+		ResetTimeout:    cb.resetTimeout,
+		HalfOpenTimeout: cb.halfOpenTimeout,
+	}
+	if cb.window != nil {
+		settings.FailureRate = cb.failureRate
+	}
+	cb.mu.RUnlock()
+
+	rl := cg.rateLimiter
+	rl.mu.Lock()
+	settings.MaxRequestsPerSecond, settings.RateLimitBurst = rl.refillRate, rl.maxTokens
+	rl.mu.Unlock()
+
+	settings.MaxConcurrentConnections = cg.connectionLimiter.Limit()
+	return settings
+}
+
+// UpdateConfig changes gate limits without resetting the circuit breaker
+// state, the tokens left or the calls in flight. A lower concurrency cap
+// applies as running calls finish; the cap cannot exceed the
+// MaxConcurrentConnections the gate was created with. The update is
+// validated as a whole, so an invalid field changes nothing. It returns the
+// settings in effect after the update.
+func (cg *ConnectionGate) UpdateConfig(update GateUpdate) (GateSettings, error) {
+	if err := cg.validateUpdate(update); err != nil {
+		return GateSettings{}, err
+	}
+
+	cb := cg.circuitBreaker
+	cb.mu.Lock()
+	if update.MaxFailures != nil {
+		cb.maxFailures = *update.MaxFailures
+	}
+	if update.FailureRate != nil {
+		cb.failureRate = *update.FailureRate
+	}
+	if update.ResetTimeout != nil {
+		cb.resetTimeout = *update.ResetTimeout
+	}
+	if update.HalfOpenTimeout != nil {
+		cb.halfOpenTimeout = *update.HalfOpenTimeout
+	}
+	cb.mu.Unlock()
+
+	if update.MaxRequestsPerSecond != nil {
+		cg.rateLimiter.SetRate(*update.MaxRequestsPerSecond)
+	}
+	if update.RateLimitBurst != nil {
+		cg.rateLimiter.SetBurst(*update.RateLimitBurst)
+	}
+	if update.MaxConcurrentConnections != nil {
+		cg.connectionLimiter.SetLimit(*update.MaxConcurrentConnections)
+	}
+	return cg.Settings(), nil
+}
+
+// validateUpdate rejects limits the gate cannot apply
+func (cg *ConnectionGate) validateUpdate(u GateUpdate) error {
+	if u.MaxFailures != nil && *u.MaxFailures <= 0 {
+		return fmt.Errorf("max_failures must be positive")
+	}
+	if u.FailureRate != nil {
+		if *u.FailureRate <= 0 || *u.FailureRate > 100 {
+			return fmt.Errorf("failure_rate must be between 0 and 100")
+		}
+		if cg.circuitBreaker.window == nil {
+			return fmt.Errorf("failure_rate requires the error-rate circuit breaker mode")
+		}
+	}
+	if u.ResetTimeout != nil && *u.ResetTimeout <= 0 {
+		return fmt.Errorf("reset_timeout_ns must be positive")
+	}
+	if u.HalfOpenTimeout != nil && *u.HalfOpenTimeout <= 0 {
+		return fmt.Errorf("half_open_timeout_ns must be positive")
+	}
+	if u.MaxRequestsPerSecond != nil && *u.MaxRequestsPerSecond <= 0 {
+		return fmt.Errorf("max_requests_per_second must be positive")
+	}
+	if u.RateLimitBurst != nil && *u.RateLimitBurst < 1 {
+		return fmt.Errorf("rate_limit_burst must be at least 1")
+	}
+	if u.MaxConcurrentConnections != nil {
+		if max := cg.connectionLimiter.MaxConnections(); *u.MaxConcurrentConnections <= 0 || *u.MaxConcurrentConnections > max {
+			return fmt.Errorf("max_concurrent_connections must be between 1 and %d", max)
+		}
+	}
+	return nil
+}
+
+// SetBurst changes the bucket size. Tokens above the new size are dropped.
+func (rl *RateLimiter) SetBurst(burst float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.tokens = min(rl.tokens+now.Sub(rl.lastRefill).Seconds()*rl.refillRate, burst)
+	rl.lastRefill = now
+	rl.maxTokens = burst
+}
+
+// GateSettings returns the current gate limits
+func (r *DBRuntime) GateSettings() GateSettings {
+	return r.gate.Settings()
+}
+
+// UpdateGateConfig changes gate limits at runtime (see
+// ConnectionGate.UpdateConfig). A new rate or concurrency cap also becomes
+// the ceiling of the adaptive rate controller or concurrency autoscaler.
+func (r *DBRuntime) UpdateGateConfig(update GateUpdate) (GateSettings, error) {
+	settings, err := r.gate.UpdateConfig(update)
+	if err != nil {
+		return GateSettings{}, err
+	}
+	if r.adaptive != nil && update.MaxRequestsPerSecond != nil {
+		r.adaptive.setMaxRate(*update.MaxRequestsPerSecond)
+	}
+	if r.autoscaler != nil && update.MaxConcurrentConnections != nil {
+		r.autoscaler.setMaxLimit(*update.MaxConcurrentConnections)
+	}
+	return settings, nil
+}
+
+// gateUpdate returns the gate limits set in a runtime config, for Reload.
+// Zero fields are left unchanged.
+func gateUpdate(config *RuntimeConfig) GateUpdate {
+	var u GateUpdate
+	if config.CircuitBreakerMaxFailures > 0 {
+		u.MaxFailures = &config.CircuitBreakerMaxFailures
+	}
+	if config.CircuitBreakerFailureRate > 0 && config.CircuitBreakerMode == "error-rate" {
+		u.FailureRate = &config.CircuitBreakerFailureRate
+	}
+	if config.CircuitBreakerResetTimeout > 0 {
+		u.ResetTimeout = &config.CircuitBreakerResetTimeout
+	}
+	if config.CircuitBreakerHalfOpenTimeout > 0 {
+		u.HalfOpenTimeout = &config.CircuitBreakerHalfOpenTimeout
+	}
+	if config.MaxRequestsPerSecond > 0 {
+		rate := float64(config.MaxRequestsPerSecond)
+		u.MaxRequestsPerSecond = &rate
+	}
+	if burst := config.RateLimitBurst; burst > 0 || config.MaxRequestsPerSecond > 0 {
+		if burst <= 0 {
+			burst = config.MaxRequestsPerSecond * 10 // the default of NewRateLimiter
+		}
+		b := float64(burst)
+		u.RateLimitBurst = &b
+	}
+	if config.MaxConcurrentConnections > 0 {
+		u.MaxConcurrentConnections = &config.MaxConcurrentConnections
+	}
+	return u
+}
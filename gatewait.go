@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// gateWaitBounds are the upper bounds of the gate wait histogram buckets
+var gateWaitBounds = [...]time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// WaitHistogram is a histogram of wait times. Counts[i] is the number of
+// waits of at most Bounds[i] (and more than Bounds[i-1]); the extra last
+// count is for waits longer than every bound.
+type WaitHistogram struct {
+	Bounds []time.Duration `json:"bounds_ns"`
+	Counts []int64         `json:"counts"`
+	Count  int64           `json:"count"`
+	Total  time.Duration   `json:"total_ns"`
+}
+
+// GateWaitStats reports the time calls spend in the gate before they run
+type GateWaitStats struct {
+	RateLimit  WaitHistogram `json:"rate_limit"` // rate limit and per-identity tokens
+	Connection WaitHistogram `json:"connection"` // bulkhead, priority and connection slots
+}
+
+// waitHistogram records waits into gateWaitBounds buckets
+type waitHistogram struct {
+	counts [len(gateWaitBounds) + 1]atomic.Int64
+	total  atomic.Int64
+}
+
+func (h *waitHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(gateWaitBounds) && d > gateWaitBounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.total.Add(int64(d))
+}
+
+func (h *waitHistogram) snapshot() WaitHistogram {
+	s := WaitHistogram{
+		Bounds: gateWaitBounds[:],
+		Counts: make([]int64, len(h.counts)),
+		Total:  time.Duration(h.total.Load()),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+// WaitStats returns the histograms of the time calls spent in the gate,
+// whether they were admitted or rejected
+func (cg *ConnectionGate) WaitStats() GateWaitStats {
+	return GateWaitStats{
+		RateLimit:  cg.rateWait.snapshot(),
+		Connection: cg.connWait.snapshot(),
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReadinessConfig configures DBRuntime.Readiness
+type ReadinessConfig struct {
+	// MinPoolHeadroom is the number of idle or unopened pool connections
+	// required to take traffic (default 1)
+	MinPoolHeadroom int
+	// ReplicaLagQuery returns the replication lag in seconds as a single
+	// number; empty skips the check. For example, on a PostgreSQL standby:
+	//
+	//	SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	ReplicaLagQuery string
+	// MaxReplicaLag is the lag above which the runtime is not ready
+	// (default 30s)
+	MaxReplicaLag time.Duration
+	// Timeout bounds each check (default 5s)
+	Timeout time.Duration
+}
+
+// LivenessResult is the outcome of DBRuntime.Liveness
+type LivenessResult struct {
+	Alive     bool          `json:"alive"`
+	Latency   time.Duration `json:"latency_ns"`
+	Error     string        `json:"error,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// ReadinessResult is the outcome of DBRuntime.Readiness. Reasons lists
+// every failed condition, not only the first.
+type ReadinessResult struct {
+	Ready        bool          `json:"ready"`
+	Reasons      []string      `json:"reasons,omitempty"`
+	CircuitState string        `json:"circuit_state"`
+	PoolInUse    int           `json:"pool_in_use"`
+	PoolMax      int           `json:"pool_max"` // 0 means unlimited
+	ReplicaLag   time.Duration `json:"replica_lag_ns,omitempty"`
+	CheckedAt    time.Time     `json:"checked_at"`
+}
+
+func (c ReadinessConfig) withDefaults() ReadinessConfig {
+	if c.MinPoolHeadroom <= 0 {
+		c.MinPoolHeadroom = 1
+	}
+	if c.MaxReplicaLag <= 0 {
+		c.MaxReplicaLag = 30 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	return c
+}
+
+// Liveness reports whether the database answers a ping. It bypasses the
+// gate, so a runtime that is shedding load (open circuit, rate or
+// concurrency limit) is still alive: orchestrators should restart a process
+// on failed liveness and only stop routing to it on failed readiness.
+func (r *DBRuntime) Liveness(ctx context.Context) LivenessResult {
+	result := LivenessResult{CheckedAt: time.Now()}
+	if !r.IsConnected() {
+		result.Error = "database not connected"
+		return result
+	}
+
+	start := time.Now()
+	err := r.advancedDB.HealthCheck(ctx)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Alive = true
+	return result
+}
+
+// Readiness reports whether the runtime should receive traffic: it is
+// connected and done with startup, the database answers, the circuit
+// breaker is not open, the pool has headroom and, if configured, replica
+// lag is within bounds.
+func (r *DBRuntime) Readiness(ctx context.Context) ReadinessResult {
+	config := r.config.Readiness.withDefaults()
+	result := ReadinessResult{
+		CircuitState: r.CircuitBreakerState(),
+		CheckedAt:    time.Now(),
+	}
+	fail := func(format string, args ...interface{}) {
+		result.Reasons = append(result.Reasons, fmt.Sprintf(format, args...))
+	}
+
+	if !r.IsConnected() {
+		fail("database not connected")
+		return result
+	}
+	if !r.IsReady() {
+		fail("startup not complete")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	if err := r.advancedDB.HealthCheck(ctx); err != nil {
+		fail("ping failed: %v", err)
+	}
+
+	if result.CircuitState == CircuitStateOpen {
+		fail("circuit breaker is open")
+	}
+
+	stats := r.Stats()
+	result.PoolInUse, result.PoolMax = stats.InUse, stats.MaxOpenConnections
+	if stats.MaxOpenConnections > 0 && stats.MaxOpenConnections-stats.InUse < config.MinPoolHeadroom {
+		fail("connection pool has %d free connections, %d required", stats.MaxOpenConnections-stats.InUse, config.MinPoolHeadroom)
+	}
+
+	if config.ReplicaLagQuery != "" {
+		lag, err := r.replicaLag(ctx, config.ReplicaLagQuery)
+		if err != nil {
+			fail("replica lag check failed: %v", err)
+		} else {
+			result.ReplicaLag = lag
+			if lag > config.MaxReplicaLag {
+				fail("replica lag %v exceeds %v", lag, config.MaxReplicaLag)
+			}
+		}
+	}
+
+	result.Ready = len(result.Reasons) == 0
+	return result
+}
+
+// replicaLag runs the lag query outside the gate, so an overloaded gate
+// does not hide the lag
+func (r *DBRuntime) replicaLag(ctx context.Context, query string) (time.Duration, error) {
+	var seconds float64
+	if err := r.DB().QueryRowContext(ctx, query).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
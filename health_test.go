@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLivenessAndReadiness(t *testing.T) {
+	config := NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:readiness?mode=memory&cache=shared").
+		WithConnectionPool(2, 2).
+		WithCircuitBreaker(1, time.Minute, time.Second).
+		Build()
+	config.Readiness = ReadinessConfig{
+		ReplicaLagQuery: "SELECT lag FROM replica_lag",
+		MaxReplicaLag:   10 * time.Second,
+	}
+	runtime := NewDBRuntime(config)
+	ctx := context.Background()
+
+	if runtime.Liveness(ctx).Alive || runtime.Readiness(ctx).Ready {
+		t.Fatal("Expected a disconnected runtime to be neither alive nor ready")
+	}
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	if _, err := runtime.Exec(ctx, "CREATE TABLE replica_lag (lag REAL)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "INSERT INTO replica_lag (lag) VALUES (2.5)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	ready := runtime.Readiness(ctx)
+	if !ready.Ready || ready.ReplicaLag != 2500*time.Millisecond {
+		t.Fatalf("Expected the runtime to be ready with 2.5s lag, got %+v", ready)
+	}
+
+	// Lag and an open circuit make it unready, but it stays alive
+	if _, err := runtime.Exec(ctx, "UPDATE replica_lag SET lag = 60"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	runtime.gate.circuitBreaker.RecordFailure()
+	ready = runtime.Readiness(ctx)
+	if ready.Ready || len(ready.Reasons) != 2 || ready.CircuitState != CircuitStateOpen {
+		t.Errorf("Expected lag and circuit reasons, got %+v", ready)
+	}
+	if live := runtime.Liveness(ctx); !live.Alive {
+		t.Errorf("Expected the runtime to stay alive while shedding load, got %+v", live)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// BeforeQueryHook runs before a statement is sent to the database. It may
+// return a derived context (e.g. carrying a trace span) that is used for the
+// statement and passed to the After hooks, or an error to reject the
+// statement without running it.
+type BeforeQueryHook func(ctx context.Context, query string, args []interface{}) (context.Context, error)
+
+// AfterQueryHook runs when a statement has finished, with its duration and
+// error. For Query the duration covers executing the query, not reading rows.
+type AfterQueryHook func(ctx context.Context, query string, duration time.Duration, err error)
+
+// QueryHooks is a pair of hooks registered together. Either may be nil.
+//
+// Hooks compose like middleware: Before hooks run in registration order and
+// After hooks in reverse order, and only hooks whose Before ran (or that
+// have no Before) see the After call. A rejected statement reports the
+// rejection error to those After hooks.
+type QueryHooks struct {
+	Before BeforeQueryHook
+	After  AfterQueryHook
+}
+
+// UseQueryHooks adds query hooks for Exec, Query, QueryRow and QueryStream
+// and for statements run in transactions
+func (adb *AdvancedDB) UseQueryHooks(hooks QueryHooks) {
+	adb.mu.Lock()
+	defer adb.mu.Unlock()
+	// Copy on write so calls in flight keep the slice they started with
+	chain := make([]QueryHooks, len(adb.hooks), len(adb.hooks)+1)
+	copy(chain, adb.hooks)
+	adb.hooks = append(chain, hooks)
+}
+
+// queryHooks returns the current hook chain
+func (adb *AdvancedDB) queryHooks() []QueryHooks {
+	adb.mu.RLock()
+	defer adb.mu.RUnlock()
+	return adb.hooks
+}
+
+// runQueryHooks runs the Before hooks of a chain. It returns the context to
+// run the statement with and a function reporting the outcome to the After
+// hooks; if a Before hook rejects the statement, the error is returned and
+// has already been reported.
+func runQueryHooks(ctx context.Context, chain []QueryHooks, query string, args []interface{}) (context.Context, func(error), error) {
+	if len(chain) == 0 {
+		return ctx, func(error) {}, nil
+	}
+
+	start := time.Now()
+	ran := 0
+	after := func(err error) {
+		duration := time.Since(start)
+		for i := ran - 1; i >= 0; i-- {
+			if chain[i].After != nil {
+				chain[i].After(ctx, query, duration, err)
+			}
+		}
+	}
+
+	for _, h := range chain {
+		if h.Before != nil {
+			next, err := h.Before(ctx, query, args)
+			if err != nil {
+				after(err)
+				return ctx, nil, err
+			}
+			if next != nil {
+				ctx = next
+			}
+		}
+		ran++
+	}
+	return ctx, after, nil
+}
+
+// UseQueryHooks adds query hooks to the runtime. Hooks added before Connect
+// are installed when the runtime connects.
+func (r *DBRuntime) UseQueryHooks(hooks QueryHooks) {
+	r.hooksMu.Lock()
+	defer r.hooksMu.Unlock()
+	r.hooks = append(r.hooks, hooks)
+	if r.advancedDB != nil {
+		r.advancedDB.UseQueryHooks(hooks)
+	}
+}
+
+// attachAdvancedDB installs the runtime's hooks on a new AdvancedDB and
+// makes it the runtime's database
+func (r *DBRuntime) attachAdvancedDB(adb *AdvancedDB) {
+	r.hooksMu.Lock()
+	defer r.hooksMu.Unlock()
+	for _, h := range r.hooks {
+		adb.UseQueryHooks(h)
+	}
+	r.advancedDB = adb
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryHooks(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:hooks?mode=memory&cache=shared").
+		Build())
+
+	type traceKey struct{}
+	var events []string
+	errForbidden := errors.New("forbidden table")
+
+	// Hooks registered before Connect are installed on connect
+	runtime.UseQueryHooks(QueryHooks{
+		Before: func(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+			events = append(events, "trace:before")
+			return context.WithValue(ctx, traceKey{}, "span-1"), nil
+		},
+		After: func(ctx context.Context, query string, duration time.Duration, err error) {
+			events = append(events, fmt.Sprintf("trace:after:%v:%v", ctx.Value(traceKey{}), err != nil))
+		},
+	})
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+	runtime.UseQueryHooks(QueryHooks{
+		Before: func(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+			events = append(events, "tenancy:before")
+			if strings.Contains(query, "secrets") {
+				return nil, errForbidden
+			}
+			return nil, nil
+		},
+		After: func(ctx context.Context, query string, duration time.Duration, err error) {
+			events = append(events, "tenancy:after")
+		},
+	})
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE notes (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	want := []string{"trace:before", "tenancy:before", "tenancy:after", "trace:after:span-1:false"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("Expected hook order %v, got %v", want, events)
+	}
+
+	// A rejecting Before hook stops the statement
+	events = nil
+	if _, err := runtime.Exec(ctx, "CREATE TABLE secrets (id INTEGER)"); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected the statement to be rejected, got %v", err)
+	}
+	want = []string{"trace:before", "tenancy:before", "trace:after:span-1:true"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("Expected hook order %v, got %v", want, events)
+	}
+	rows, err := runtime.Query(ctx, "SELECT name FROM sqlite_master WHERE name = ?", "secrets")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if rows.Next() {
+		t.Error("Expected the rejected table not to exist")
+	}
+	rows.Close()
+
+	// Statements in transactions are hooked too
+	tx, err := runtime.Begin(ctx, nil)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	events = nil
+	if _, err := tx.Exec(ctx, "INSERT INTO notes (id) VALUES (1)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if len(events) != 4 {
+		t.Errorf("Expected the transaction statement to run the hooks, got %v", events)
+	}
+}
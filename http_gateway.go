@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPGateway exposes the TCP message protocol over HTTP and WebSocket.
+// Requests are handed to a RequestProcessor (normally the TCPServer), so
+// both paths share the gate, per-IP protections and idempotency of the TCP
+// path. Each HTTP request and each WebSocket session counts as one
+// connection of its client IP while it lasts.
+type HTTPGateway struct {
+	config    *HTTPGatewayConfig
+	processor RequestProcessor
+	server    *http.Server
+	listener  net.Listener
+	mu        sync.RWMutex
+
+	// Upgraded WebSocket connections, closed by Stop
+	wsConns  map[net.Conn]struct{}
+	wsMu     sync.Mutex
+	wsClosed bool
+}
+
+// HTTPGatewayConfig configures the HTTP gateway
+type HTTPGatewayConfig struct {
+	Address        string
+	Processor      RequestProcessor
+	MaxRequestSize int64         // maximum request body size (default 1MB)
+	RequestTimeout time.Duration // per-request processing timeout (default 30s)
+	// WebSocketIdleTimeout closes WebSocket connections that send nothing
+	// for this long (default 5 minutes)
+	WebSocketIdleTimeout time.Duration
+}
+
+const (
+	httpGatewayPath          = "/v1/request"
+	httpGatewayWebSocketPath = "/v1/ws"
+)
+
+// NewHTTPGateway creates a new HTTP gateway
+func NewHTTPGateway(config *HTTPGatewayConfig) *HTTPGateway {
+	if config.MaxRequestSize <= 0 {
+		config.MaxRequestSize = 1024 * 1024 // 1MB, same as the TCP scanner buffer
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = 30 * time.Second
+	}
+	if config.WebSocketIdleTimeout <= 0 {
+		config.WebSocketIdleTimeout = 5 * time.Minute
+	}
+
+	g := &HTTPGateway{
+		config:    config,
+		processor: config.Processor,
+		wsConns:   make(map[net.Conn]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpGatewayPath, g.handleRequest)
+	mux.HandleFunc(httpGatewayWebSocketPath, g.handleWebSocket)
+	g.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return g
+}
+
+// Start starts the HTTP gateway
+func (g *HTTPGateway) Start() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.listener != nil {
+		return fmt.Errorf("gateway already started")
+	}
+
+	listener, err := net.Listen("tcp", g.config.Address)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP gateway: %w", err)
+	}
+	g.listener = listener
+	log.Printf("HTTP gateway listening on %s", listener.Addr())
+
+	go func() {
+		if err := g.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP gateway error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop gracefully stops the HTTP gateway
+func (g *HTTPGateway) Stop() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.listener == nil {
+		return fmt.Errorf("gateway not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := g.server.Shutdown(ctx)
+	g.closeWebSockets()
+	return err
+}
+
+// Handler returns the gateway HTTP handler, for mounting on an existing server
+func (g *HTTPGateway) Handler() http.Handler {
+	return g.server.Handler
+}
+
+// GetAddress returns the gateway address
+func (g *HTTPGateway) GetAddress() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.listener != nil {
+		return g.listener.Addr().String()
+	}
+	return g.config.Address
+}
+
+// handleRequest decodes a TCPMessage from the request body and writes the
+// processor's TCPResponse as JSON
+func (g *HTTPGateway) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientIP := httpClientIP(r)
+	if resp := g.processor.OpenConnection(clientIP); resp != nil {
+		g.writeResponse(w, connectionRejectedStatus(resp), resp)
+		return
+	}
+	defer g.processor.CloseConnection(clientIP)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.config.MaxRequestSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			g.writeResponse(w, http.StatusRequestEntityTooLarge, NewErrorResponse("", fmt.Errorf("request too large")))
+			return
+		}
+		g.writeResponse(w, http.StatusBadRequest, NewErrorResponse("", err))
+		return
+	}
+
+	msg, err := DecodeTCPMessage(body)
+	if err != nil {
+		g.writeResponse(w, http.StatusBadRequest, NewErrorResponse("", err))
+		return
+	}
+
+	// Transport-owned fields must never be taken from the client
+	msg.ClientIP = clientIP
+	msg.RequestSize = int64(len(body))
+
+	ctx, cancel := context.WithTimeout(r.Context(), g.config.RequestTimeout)
+	defer cancel()
+
+	resp := g.processor.Process(ctx, msg)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	g.writeResponse(w, http.StatusOK, resp)
+}
+
+// writeResponse writes a TCPResponse as JSON
+func (g *HTTPGateway) writeResponse(w http.ResponseWriter, status int, resp *TCPResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Failed to write HTTP response: %v", err)
+	}
+}
+
+// connectionRejectedStatus returns the HTTP status of a connection
+// rejected by OpenConnection
+func connectionRejectedStatus(resp *TCPResponse) int {
+	if resp.Code == ErrCodeConnectionLimit {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusForbidden
+}
+
+// httpClientIP extracts the client IP from the connection. Forwarding
+// headers are ignored so clients cannot spoof their way past per-IP limits.
+func httpClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
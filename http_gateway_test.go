@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHTTPGateway(serverConfig *TCPServerConfig) *HTTPGateway {
+	serverConfig.Address = "localhost:0"
+	serverConfig.Runtime = &DBRuntime{}
+	return NewHTTPGateway(&HTTPGatewayConfig{
+		Address:   "localhost:0",
+		Processor: NewTCPServer(serverConfig),
+	})
+}
+
+func doGatewayRequest(t *testing.T, g *HTTPGateway, body string) (int, *TCPResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, httpGatewayPath, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	g.Handler().ServeHTTP(rec, req)
+
+	resp, err := DecodeTCPResponse(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to decode gateway response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestHTTPGateway_Ping(t *testing.T) {
+	g := newTestHTTPGateway(&TCPServerConfig{})
+
+	code, resp := doGatewayRequest(t, g, `{"type":"PING","id":"1"}`)
+	if code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+	if !resp.Success || resp.ID != "1" {
+		t.Errorf("Expected successful ping response, got %+v", resp)
+	}
+}
+
+func TestHTTPGateway_SharesProtections(t *testing.T) {
+	// httptest requests come from 192.0.2.1
+	g := newTestHTTPGateway(&TCPServerConfig{
+		EnableDDoSProtection: true,
+		BlacklistedIPs:       []string{"192.0.2.1"},
+	})
+
+	_, resp := doGatewayRequest(t, g, `{"type":"PING","id":"1"}`)
+	if resp.Success {
+		t.Error("Blacklisted IP should be rejected by the HTTP gateway")
+	}
+
+	// A spoofed client_ip must not bypass the blacklist
+	_, resp = doGatewayRequest(t, g, `{"type":"PING","id":"2","client_ip":"10.0.0.1"}`)
+	if resp.Success {
+		t.Error("client_ip from the request body should be ignored")
+	}
+}
+
+func TestHTTPGateway_ResponseSizeLimit(t *testing.T) {
+	g := newTestHTTPGateway(&TCPServerConfig{MaxResponseSize: 20})
+
+	_, resp := doGatewayRequest(t, g, `{"type":"PING","id":"1"}`)
+	if resp.Success || resp.Code != ErrCodeResponseTooLarge {
+		t.Errorf("Expected RESPONSE_TOO_LARGE over HTTP, got %+v", resp)
+	}
+}
+
+func TestHTTPGateway_BadRequests(t *testing.T) {
+	g := newTestHTTPGateway(&TCPServerConfig{})
+
+	code, _ := doGatewayRequest(t, g, `not json`)
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, httpGatewayPath, nil)
+	rec := httptest.NewRecorder()
+	g.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", rec.Code)
+	}
+}
+
+// dialGatewayWebSocket opens a WebSocket to the gateway listening on addr
+func dialGatewayWebSocket(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, reader, resp := upgradeGatewayWebSocket(t, addr)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+	// Accept value from the RFC 6455 example
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected Sec-WebSocket-Accept %q", accept)
+	}
+	return conn, reader
+}
+
+// upgradeGatewayWebSocket sends a WebSocket handshake to the gateway
+// listening on addr and returns its response
+func upgradeGatewayWebSocket(t *testing.T, addr string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial gateway: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+httpGatewayWebSocketPath, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to write handshake: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	return conn, reader, resp
+}
+
+// writeClientFrame writes a masked frame
+func writeClientFrame(t *testing.T, conn net.Conn, fin bool, op byte, payload []byte) {
+	t.Helper()
+	first := op
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+}
+
+// readServerFrame reads an unmasked frame
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("Server frames must not be masked")
+	}
+	n := int(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(reader, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(reader, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("Failed to read frame payload: %v", err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestHTTPGateway_WebSocket(t *testing.T) {
+	g := newTestHTTPGateway(&TCPServerConfig{})
+	srv := httptest.NewServer(g.Handler())
+	defer srv.Close()
+
+	conn, reader := dialGatewayWebSocket(t, srv.Listener.Addr().String())
+
+	writeClientFrame(t, conn, true, wsOpText, []byte(`{"type":"PING","id":"1"}`))
+	op, payload := readServerFrame(t, reader)
+	resp, err := DecodeTCPResponse(payload)
+	if op != wsOpText || err != nil {
+		t.Fatalf("Expected a text response, got opcode %d: %v", op, err)
+	}
+	if !resp.Success || resp.ID != "1" {
+		t.Errorf("Expected successful ping response, got %+v", resp)
+	}
+
+	// A message fragmented around a control frame
+	writeClientFrame(t, conn, false, wsOpText, []byte(`{"type":"PING",`))
+	writeClientFrame(t, conn, true, wsOpPing, []byte("hi"))
+	writeClientFrame(t, conn, true, wsOpContinuation, []byte(`"id":"2"}`))
+	if op, payload := readServerFrame(t, reader); op != wsOpPong || string(payload) != "hi" {
+		t.Errorf("Expected pong \"hi\", got opcode %d %q", op, payload)
+	}
+	_, payload = readServerFrame(t, reader)
+	if resp, err := DecodeTCPResponse(payload); err != nil || !resp.Success || resp.ID != "2" {
+		t.Errorf("Expected fragmented ping response, got %+v (%v)", resp, err)
+	}
+
+	writeClientFrame(t, conn, true, wsOpText, []byte(`not json`))
+	_, payload = readServerFrame(t, reader)
+	if resp, err := DecodeTCPResponse(payload); err != nil || resp.Success {
+		t.Errorf("Expected an error response for invalid JSON, got %+v (%v)", resp, err)
+	}
+
+	writeClientFrame(t, conn, true, wsOpClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal))
+	op, payload = readServerFrame(t, reader)
+	if op != wsOpClose || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Errorf("Expected close frame echo, got opcode %d %v", op, payload)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected connection to be closed, got %v", err)
+	}
+}
+
+func TestHTTPGateway_WebSocketSharesProtections(t *testing.T) {
+	g := newTestHTTPGateway(&TCPServerConfig{
+		EnableDDoSProtection: true,
+		BlacklistedIPs:       []string{"127.0.0.1"},
+	})
+	srv := httptest.NewServer(g.Handler())
+	defer srv.Close()
+
+	_, _, resp := upgradeGatewayWebSocket(t, srv.Listener.Addr().String())
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a blacklisted IP to be refused the upgrade, got status %d", resp.StatusCode)
+	}
+
+	// IPs banned during a session are rejected per message
+	g = newTestHTTPGateway(&TCPServerConfig{EnableDDoSProtection: true})
+	srv2 := httptest.NewServer(g.Handler())
+	defer srv2.Close()
+	conn, reader := dialGatewayWebSocket(t, srv2.Listener.Addr().String())
+	g.processor.(*TCPServer).BlacklistIP("127.0.0.1", time.Minute)
+	writeClientFrame(t, conn, true, wsOpText, []byte(`{"type":"PING","id":"1","client_ip":"10.0.0.1"}`))
+	_, payload := readServerFrame(t, reader)
+	if resp, err := DecodeTCPResponse(payload); err != nil || resp.Success {
+		t.Errorf("Blacklisted IP should be rejected over WebSocket, got %+v (%v)", resp, err)
+	}
+}
+
+func TestHTTPGateway_ConnectionsPerIP(t *testing.T) {
+	g := newTestHTTPGateway(&TCPServerConfig{EnableDDoSProtection: true, MaxConnectionsPerIP: 1})
+	server := g.processor.(*TCPServer)
+	srv := httptest.NewServer(g.Handler())
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	// An open session holds the only connection of its IP
+	conn, reader := dialGatewayWebSocket(t, addr)
+	if _, _, resp := upgradeGatewayWebSocket(t, addr); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected a second session to be refused, got status %d", resp.StatusCode)
+	}
+	httpResp, err := http.Post(srv.URL+httpGatewayPath, "application/json", strings.NewReader(`{"type":"PING","id":"1"}`))
+	if err != nil {
+		t.Fatalf("HTTP request failed: %v", err)
+	}
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected an HTTP request to be refused during the session, got status %d", httpResp.StatusCode)
+	}
+
+	// Closing the session releases the connection
+	writeClientFrame(t, conn, true, wsOpClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal))
+	readServerFrame(t, reader)
+	reader.ReadByte()
+	deadline := time.Now().Add(2 * time.Second)
+	for server.GetIPStats()[0].ActiveConnections != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	dialGatewayWebSocket(t, addr)
+}
+
+func TestHTTPGateway_WebSocketRejects(t *testing.T) {
+	g := NewHTTPGateway(&HTTPGatewayConfig{
+		Processor:      NewTCPServer(&TCPServerConfig{Address: "localhost:0", Runtime: &DBRuntime{}}),
+		MaxRequestSize: 64,
+	})
+	srv := httptest.NewServer(g.Handler())
+	defer srv.Close()
+
+	// Plain GET without upgrade headers
+	rec := httptest.NewRecorder()
+	g.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, httpGatewayWebSocketPath, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+
+	conn, reader := dialGatewayWebSocket(t, srv.Listener.Addr().String())
+	writeClientFrame(t, conn, true, wsOpText, []byte(strings.Repeat("x", 100)))
+	op, payload := readServerFrame(t, reader)
+	if op != wsOpClose || binary.BigEndian.Uint16(payload) != wsCloseTooLarge {
+		t.Errorf("Expected close 1009 for an oversized message, got opcode %d %v", op, payload)
+	}
+
+	// Unmasked client frame
+	conn, reader = dialGatewayWebSocket(t, srv.Listener.Addr().String())
+	conn.Write([]byte{0x81, 0x02, '{', '}'})
+	op, payload = readServerFrame(t, reader)
+	if op != wsOpClose || binary.BigEndian.Uint16(payload) != wsCloseProtocolError {
+		t.Errorf("Expected close 1002 for an unmasked frame, got opcode %d %v", op, payload)
+	}
+
+	// Stop closes open WebSocket connections
+	g.config.Address = "localhost:0"
+	if err := g.Start(); err != nil {
+		t.Fatalf("Failed to start gateway: %v", err)
+	}
+	conn, reader = dialGatewayWebSocket(t, g.GetAddress())
+	writeClientFrame(t, conn, true, wsOpText, []byte(`{"type":"PING","id":"1"}`))
+	readServerFrame(t, reader)
+	if err := g.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected Stop to close the WebSocket, got %v", err)
+	}
+}
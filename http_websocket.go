@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// websocketGUID is appended to Sec-WebSocket-Key to build the accept key
+// (RFC 6455 section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// WebSocket close codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseTooLarge      = 1009
+)
+
+// wsCloseError ends a WebSocket connection with a close frame
+type wsCloseError struct {
+	code   uint16
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket closed (%d): %s", e.code, e.reason)
+}
+
+// handleWebSocket upgrades the request to a WebSocket. Every text or binary
+// message is a TCPMessage and is answered with a text message holding the
+// TCPResponse, in order.
+func (g *HTTPGateway) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The session holds a connection of its client IP until it ends
+	clientIP := httpClientIP(r)
+	if resp := g.processor.OpenConnection(clientIP); resp != nil {
+		g.writeResponse(w, connectionRejectedStatus(resp), resp)
+		return
+	}
+	defer g.processor.CloseConnection(clientIP)
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("Failed to upgrade WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	if !g.trackWebSocket(conn) {
+		return
+	}
+	defer g.untrackWebSocket(conn)
+
+	ws := &wsConn{conn: conn, reader: rw.Reader, maxSize: g.config.MaxRequestSize, writeTimeout: g.config.RequestTimeout}
+	g.serveWebSocket(r.Context(), ws, clientIP)
+}
+
+// serveWebSocket processes messages until the client closes the connection
+func (g *HTTPGateway) serveWebSocket(ctx context.Context, ws *wsConn, clientIP string) {
+	for {
+		ws.conn.SetReadDeadline(time.Now().Add(g.config.WebSocketIdleTimeout))
+		payload, err := ws.readMessage()
+		if err != nil {
+			var closeErr *wsCloseError
+			if errors.As(err, &closeErr) {
+				ws.writeClose(closeErr.code, closeErr.reason)
+			}
+			return
+		}
+
+		var resp *TCPResponse
+		msg, err := DecodeTCPMessage(payload)
+		if err != nil {
+			resp = NewErrorResponse("", err)
+		} else {
+			// Transport-owned fields must never be taken from the client
+			msg.ClientIP = clientIP
+			msg.RequestSize = int64(len(payload))
+
+			reqCtx, cancel := context.WithTimeout(ctx, g.config.RequestTimeout)
+			resp = g.processor.Process(reqCtx, msg)
+			cancel()
+		}
+		if resp == nil {
+			continue
+		}
+
+		data, err := EncodeTCPResponse(resp)
+		if err != nil {
+			log.Printf("Failed to encode response: %v", err)
+			continue
+		}
+		if err := ws.writeFrame(wsOpText, data); err != nil {
+			log.Printf("Failed to write WebSocket response: %v", err)
+			return
+		}
+	}
+}
+
+// trackWebSocket registers an upgraded connection so Stop can close it.
+// It fails once the gateway is stopping.
+func (g *HTTPGateway) trackWebSocket(conn net.Conn) bool {
+	g.wsMu.Lock()
+	defer g.wsMu.Unlock()
+	if g.wsClosed {
+		return false
+	}
+	g.wsConns[conn] = struct{}{}
+	return true
+}
+
+func (g *HTTPGateway) untrackWebSocket(conn net.Conn) {
+	g.wsMu.Lock()
+	defer g.wsMu.Unlock()
+	delete(g.wsConns, conn)
+}
+
+// closeWebSockets closes all upgraded connections, which the HTTP server
+// no longer tracks
+func (g *HTTPGateway) closeWebSockets() {
+	g.wsMu.Lock()
+	defer g.wsMu.Unlock()
+	g.wsClosed = true
+	for conn := range g.wsConns {
+		conn.Close()
+	}
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept value for a key
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma-separated header contains token
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn reads and writes WebSocket frames on the server side
+type wsConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	maxSize      int64
+	writeTimeout time.Duration
+}
+
+// readMessage returns the payload of the next data message, reassembling
+// fragments and answering control frames in between
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code := uint16(wsCloseNormal)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			return nil, &wsCloseError{code: code}
+		case wsOpText, wsOpBinary:
+			if started {
+				return nil, &wsCloseError{wsCloseProtocolError, "expected continuation frame"}
+			}
+			started = true
+		case wsOpContinuation:
+			if !started {
+				return nil, &wsCloseError{wsCloseProtocolError, "unexpected continuation frame"}
+			}
+		default:
+			return nil, &wsCloseError{wsCloseProtocolError, fmt.Sprintf("unknown opcode %d", op)}
+		}
+
+		if int64(len(message)+len(payload)) > c.maxSize {
+			return nil, &wsCloseError{wsCloseTooLarge, "message too large"}
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads one client frame and unmasks its payload
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "reserved bits set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "client frames must be masked"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsOpClose && (length > 125 || !fin) {
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "invalid control frame"}
+	}
+	if length > uint64(c.maxSize) {
+		return false, 0, nil, &wsCloseError{wsCloseTooLarge, "message too large"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame writes an unfragmented, unmasked frame
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// writeClose sends a close frame; errors are ignored as the connection is
+// closed right after
+func (c *wsConn) writeClose(code uint16, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	c.writeFrame(wsOpClose, append(payload, reason...))
+}
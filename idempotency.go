@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultIdempotencyTTL is how long responses are kept for replay
+	defaultIdempotencyTTL = 5 * time.Minute
+	// defaultIdempotencyMaxResponse is the largest response data kept for
+	// replay
+	defaultIdempotencyMaxResponse = 256 << 10
+)
+
+// IdempotencyRecord is a stored response together with the fingerprint of
+// the request that produced it. A response whose data was too large to keep
+// is stored without it, with the digest and size of the data instead.
+type IdempotencyRecord struct {
+	Fingerprint string       `json:"fingerprint"`
+	Response    *TCPResponse `json:"response"`
+	Digest      string       `json:"digest,omitempty"`  // SHA-256 of the dropped data
+	Size        int          `json:"size,omitempty"`    // length of the dropped data
+	Pending     bool         `json:"pending,omitempty"` // reserved by a request still running
+}
+
+// newIdempotencyRecord returns the record of a response, dropping data
+// longer than maxData (no limit if maxData <= 0)
+func newIdempotencyRecord(msg *TCPMessage, response *TCPResponse, maxData int64) *IdempotencyRecord {
+	rec := &IdempotencyRecord{Fingerprint: RequestFingerprint(msg), Response: response}
+	if maxData > 0 && int64(len(response.Data)) > maxData {
+		sum := sha256.Sum256(response.Data)
+		stub := *response
+		stub.Data = nil
+		rec.Response = &stub
+		rec.Digest = hex.EncodeToString(sum[:])
+		rec.Size = len(response.Data)
+	}
+	return rec
+}
+
+func init() {
+	RegisterCacheType("IdempotencyRecord", IdempotencyRecord{})
+}
+
+// RequestFingerprint hashes the parts of a message that determine what it
+// does (type, query and arguments). Reusing an idempotency key with a
+// different fingerprint is a conflict.
+func RequestFingerprint(msg *TCPMessage) string {
+	h := sha256.New()
+	h.Write([]byte(msg.Type))
+	h.Write([]byte{0})
+	h.Write([]byte(msg.Query))
+	h.Write([]byte{0})
+	// Arguments are hashed in their JSON form, which is what the client sent
+	args, _ := json.Marshal(msg.Args)
+	h.Write(args)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IdempotencyStore keeps the responses of requests that carried an
+// idempotency key, so retries of the same request get the original response
+// instead of executing again. Use a shared, persistent store to suppress
+// duplicates across server restarts and across several server instances.
+type IdempotencyStore interface {
+	// Get returns the record stored for key. Expired entries are not returned.
+	Get(ctx context.Context, key string) (*IdempotencyRecord, bool, error)
+	// Put stores the record for key for ttl
+	Put(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error
+}
+
+// IdempotencyReserver is implemented by stores shared between servers. The
+// server reserves a key before running its request, so servers receiving
+// the same request at once cannot both run it.
+type IdempotencyReserver interface {
+	// Reserve stores a pending record for key unless an unexpired record
+	// exists. It reports whether the key was reserved, returning the
+	// existing record otherwise (nil if it was just removed).
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error)
+	// Release removes the pending record of a request that failed, so a
+	// retry can run
+	Release(ctx context.Context, key string) error
+}
+
+// idempotencyPurger is implemented by stores that need expired entries
+// removed periodically
+type idempotencyPurger interface {
+	Purge(ctx context.Context) (int64, error)
+}
+
+// CacheIdempotencyStore keeps idempotency entries in a Cache. With the
+// default in-memory cache entries are lost on restart.
+type CacheIdempotencyStore struct {
+	cache Cache
+}
+
+// NewCacheIdempotencyStore creates an idempotency store backed by a cache
+func NewCacheIdempotencyStore(cache Cache) *CacheIdempotencyStore {
+	return &CacheIdempotencyStore{cache: cache}
+}
+
+// Get returns the record stored for key
+func (s *CacheIdempotencyStore) Get(ctx context.Context, key string) (*IdempotencyRecord, bool, error) {
+	cached, ok := s.cache.Get(ctx, key)
+	if !ok {
+		return nil, false, nil
+	}
+	rec, ok := cached.(*IdempotencyRecord)
+	return rec, ok, nil
+}
+
+// Put stores the record for key
+func (s *CacheIdempotencyStore) Put(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	if !s.cache.Set(ctx, key, rec, ttl) {
+		return fmt.Errorf("cache rejected idempotency key %s", key)
+	}
+	return nil
+}
+
+// DatabaseIdempotencyStore keeps idempotency entries in a database table so
+// they survive restarts and are shared by every server using the database.
+// Keys are reserved with a pending row before their request runs, relying
+// on the primary key so only one server gets each.
+type DatabaseIdempotencyStore struct {
+	runtime   *DBRuntime
+	tableName string
+	codec     Codec
+}
+
+// NewDatabaseIdempotencyStore creates a database-backed idempotency store.
+// The table (default "idempotency_keys") is created if it does not exist.
+func NewDatabaseIdempotencyStore(runtime *DBRuntime, tableName string) (*DatabaseIdempotencyStore, error) {
+	if tableName == "" {
+		tableName = "idempotency_keys"
+	}
+
+	store := &DatabaseIdempotencyStore{
+		runtime:   runtime,
+		tableName: tableName,
+		codec:     JSONCodec{},
+	}
+
+	if err := store.createTable(); err != nil {
+		return nil, fmt.Errorf("failed to create idempotency table: %w", err)
+	}
+
+	return store, nil
+}
+
+// createTable creates the idempotency table
+func (s *DatabaseIdempotencyStore) createTable() error {
+	ctx := context.Background()
+
+	// expires_at holds Unix nanoseconds to avoid time zone differences between drivers
+	var createSQL string
+	switch s.runtime.config.DatabaseType {
+	case DatabaseTypeSQLite:
+		createSQL = fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				idempotency_key TEXT PRIMARY KEY,
+				response BLOB NOT NULL,
+				expires_at INTEGER NOT NULL
+			)`, s.tableName)
+	case DatabaseTypePostgreSQL:
+		createSQL = fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				idempotency_key TEXT PRIMARY KEY,
+				response BYTEA NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`, s.tableName)
+	case DatabaseTypeMySQL:
+		createSQL = fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				idempotency_key VARCHAR(255) PRIMARY KEY,
+				response LONGBLOB NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`, s.tableName)
+	default:
+		return fmt.Errorf("unsupported database type for idempotency store: %s", s.runtime.config.DatabaseType)
+	}
+
+	_, err := s.runtime.Exec(ctx, createSQL)
+	return err
+}
+
+// Get returns the record stored for key
+func (s *DatabaseIdempotencyStore) Get(ctx context.Context, key string) (*IdempotencyRecord, bool, error) {
+	query := Rebind(s.runtime.Dialect(), fmt.Sprintf("SELECT response FROM %s WHERE idempotency_key = ? AND expires_at > ?", s.tableName))
+
+	rows, err := s.runtime.query(ctx, query, key, time.Now().UnixNano())
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, false, rows.Err()
+	}
+	var data []byte
+	if err := rows.Scan(&data); err != nil {
+		return nil, false, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+
+	value, err := s.codec.Decode(data)
+	if err != nil {
+		return nil, false, err
+	}
+	rec, ok := value.(*IdempotencyRecord)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected idempotency entry type %T", value)
+	}
+	return rec, true, nil
+}
+
+// Put stores the record for key, replacing any previous entry
+func (s *DatabaseIdempotencyStore) Put(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	data, err := s.codec.Encode(rec)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(ttl).UnixNano()
+
+	var query string
+	switch s.runtime.config.DatabaseType {
+	case DatabaseTypePostgreSQL:
+		query = fmt.Sprintf(`
+			INSERT INTO %s (idempotency_key, response, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (idempotency_key) DO UPDATE SET response = EXCLUDED.response, expires_at = EXCLUDED.expires_at
+		`, s.tableName)
+	case DatabaseTypeMySQL:
+		query = fmt.Sprintf("REPLACE INTO %s (idempotency_key, response, expires_at) VALUES (?, ?, ?)", s.tableName)
+	default:
+		query = fmt.Sprintf("INSERT OR REPLACE INTO %s (idempotency_key, response, expires_at) VALUES (?, ?, ?)", s.tableName)
+	}
+
+	if _, err := s.runtime.Exec(ctx, query, key, data, expiresAt); err != nil {
+		return fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Reserve inserts a pending row for key, once an expired entry is deleted.
+// Only the server whose insert adds the row reserves the key.
+func (s *DatabaseIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	data, err := s.codec.Encode(&IdempotencyRecord{Fingerprint: fingerprint, Pending: true})
+	if err != nil {
+		return nil, false, err
+	}
+	d := s.runtime.Dialect()
+	now := time.Now()
+
+	expired := Rebind(d, fmt.Sprintf("DELETE FROM %s WHERE idempotency_key = ? AND expires_at <= ?", s.tableName))
+	if _, err := s.runtime.Exec(ctx, expired, key, now.UnixNano()); err != nil {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	var query string
+	switch s.runtime.config.DatabaseType {
+	case DatabaseTypePostgreSQL:
+		query = "INSERT INTO %s (idempotency_key, response, expires_at) VALUES (?, ?, ?) ON CONFLICT (idempotency_key) DO NOTHING"
+	case DatabaseTypeMySQL:
+		query = "INSERT IGNORE INTO %s (idempotency_key, response, expires_at) VALUES (?, ?, ?)"
+	default:
+		query = "INSERT OR IGNORE INTO %s (idempotency_key, response, expires_at) VALUES (?, ?, ?)"
+	}
+	result, err := s.runtime.Exec(ctx, Rebind(d, fmt.Sprintf(query, s.tableName)), key, data, now.Add(ttl).UnixNano())
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 1 {
+		return nil, err == nil, err
+	}
+
+	rec, _, err := s.Get(ctx, key)
+	return rec, false, err
+}
+
+// Release deletes the row of key
+func (s *DatabaseIdempotencyStore) Release(ctx context.Context, key string) error {
+	query := Rebind(s.runtime.Dialect(), fmt.Sprintf("DELETE FROM %s WHERE idempotency_key = ?", s.tableName))
+	if _, err := s.runtime.Exec(ctx, query, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Purge deletes expired entries and returns how many were removed
+func (s *DatabaseIdempotencyStore) Purge(ctx context.Context) (int64, error) {
+	query := Rebind(s.runtime.Dialect(), fmt.Sprintf("DELETE FROM %s WHERE expires_at <= ?", s.tableName))
+
+	result, err := s.runtime.Exec(ctx, query, time.Now().UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDatabaseIdempotencyStore(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:idempotency?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	store, err := NewDatabaseIdempotencyStore(runtime, "")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	ctx := context.Background()
+	msg := &TCPMessage{Type: MessageTypeExec, IdempotencyKey: "pay-1", Query: "INSERT INTO missing VALUES (?)", Args: []interface{}{1}}
+	resp, _ := NewSuccessResponse("1", ExecResult{RowsAffected: 1})
+	rec := &IdempotencyRecord{Fingerprint: RequestFingerprint(msg), Response: resp}
+	if err := store.Put(ctx, "pay-1", rec, time.Minute); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Put(ctx, "pay-2", rec, -time.Second); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	got, ok, err := store.Get(ctx, "pay-1")
+	if err != nil || !ok || got.Fingerprint != rec.Fingerprint || string(got.Response.Data) != string(resp.Data) {
+		t.Errorf("Expected stored record, got %+v ok=%v err=%v", got, ok, err)
+	}
+	if _, ok, _ := store.Get(ctx, "pay-2"); ok {
+		t.Error("Expired entry should not be returned")
+	}
+	if n, err := store.Purge(ctx); err != nil || n != 1 {
+		t.Errorf("Expected 1 purged entry, got %d err=%v", n, err)
+	}
+
+	// A restarted server sharing the store replays the original response
+	for i := 0; i < 2; i++ {
+		server := NewTCPServer(&TCPServerConfig{
+			Runtime:           runtime,
+			EnableIdempotency: true,
+			IdempotencyStore:  store,
+		})
+		retry := *msg
+		retry.ID = fmt.Sprint(i)
+		out := server.Process(ctx, &retry)
+		if !out.Success || out.ID != retry.ID {
+			t.Errorf("Expected replayed response for request %d, got %+v", i, out)
+		}
+	}
+
+	// A key reserved by another server is not run until released
+	if _, err := runtime.Exec(ctx, "CREATE TABLE payments (id INTEGER)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	server := NewTCPServer(&TCPServerConfig{Runtime: runtime, EnableIdempotency: true, IdempotencyStore: store})
+	pay := &TCPMessage{Type: MessageTypeExec, ID: "1", IdempotencyKey: "pay-3", Query: "INSERT INTO payments VALUES (?)", Args: []interface{}{3}}
+	if _, reserved, err := store.Reserve(ctx, "pay-3", RequestFingerprint(pay), time.Minute); err != nil || !reserved {
+		t.Fatalf("Expected pay-3 reserved, got %v (%v)", reserved, err)
+	}
+	if rec, reserved, err := store.Reserve(ctx, "pay-3", RequestFingerprint(pay), time.Minute); err != nil || reserved || rec == nil || !rec.Pending {
+		t.Fatalf("Expected the pending reservation back, got %+v, %v (%v)", rec, reserved, err)
+	}
+	if out := server.Process(ctx, pay); out.Success || out.Code != ErrCodeServerBusy {
+		t.Errorf("Expected SERVER_BUSY for a reserved key, got %+v", out)
+	}
+	if err := store.Release(ctx, "pay-3"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if out := server.Process(ctx, pay); !out.Success {
+			t.Errorf("Expected request %d to succeed, got %+v", i, out)
+		}
+	}
+	var payments int
+	if err := runtime.QueryRow(ctx, "SELECT COUNT(*) FROM payments").Scan(&payments); err != nil || payments != 1 {
+		t.Errorf("Expected the payment inserted once, got %d (%v)", payments, err)
+	}
+
+	// A failed request releases its reservation, so a retry can run
+	failing := &TCPMessage{Type: MessageTypeExec, ID: "1", IdempotencyKey: "pay-4", Query: "INSERT INTO missing VALUES (?)", Args: []interface{}{4}}
+	if out := server.Process(ctx, failing); out.Success {
+		t.Fatal("Expected the insert into a missing table to fail")
+	}
+	if _, ok, err := store.Get(ctx, "pay-4"); ok || err != nil {
+		t.Errorf("Expected the reservation of a failed request released, got %v (%v)", ok, err)
+	}
+}
+
+func TestIdempotencyFingerprintConflict(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Runtime:           &DBRuntime{},
+		EnableIdempotency: true,
+	})
+
+	msg := &TCPMessage{Type: MessageTypeExec, ID: "1", IdempotencyKey: "k", Query: "UPDATE a SET b = ?", Args: []interface{}{1}}
+	resp, _ := NewSuccessResponse("1", ExecResult{RowsAffected: 1})
+	server.storeIdempotency(msg, resp)
+
+	same := *msg
+	same.ID = "2"
+	if out := server.checkIdempotency(&same); out == nil || !out.Success {
+		t.Errorf("Expected replay for identical request, got %+v", out)
+	}
+
+	different := same
+	different.Args = []interface{}{2}
+	out := server.checkIdempotency(&different)
+	if out == nil || out.Success || out.Code != ErrCodeIdempotencyConflict {
+		t.Errorf("Expected conflict for different arguments, got %+v", out)
+	}
+}
+
+func TestIdempotencyMaxResponse(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Runtime:                &DBRuntime{},
+		EnableIdempotency:      true,
+		IdempotencyMaxResponse: 64,
+	})
+
+	query := &TCPMessage{Type: MessageTypeQuery, ID: "1", IdempotencyKey: "q", Query: "SELECT name FROM users"}
+	big, _ := NewSuccessResponse("1", QueryResult{Columns: []string{"name"}, Rows: [][]interface{}{{strings.Repeat("x", 100)}}})
+	server.storeIdempotency(query, big)
+
+	rec, ok, _ := server.idempotencyStore.Get(context.Background(), "q")
+	if !ok || rec.Response.Data != nil || rec.Size != len(big.Data) || len(rec.Digest) != 64 {
+		t.Fatalf("Expected a digest in place of the response data, got %+v", rec)
+	}
+	if out := server.checkIdempotency(query); out != nil {
+		t.Errorf("Expected the oversized read to run again, got %+v", out)
+	}
+
+	exec := &TCPMessage{Type: MessageTypeExec, ID: "2", IdempotencyKey: "e", Query: "DELETE FROM users RETURNING *"}
+	bigExec := *big
+	server.storeIdempotency(exec, &bigExec)
+	if out := server.checkIdempotency(exec); out == nil || out.Success || out.Code != ErrCodeNotReplayable {
+		t.Errorf("Expected NOT_REPLAYABLE for the oversized write, got %+v", out)
+	}
+
+	small, _ := NewSuccessResponse("3", ExecResult{RowsAffected: 1})
+	smallExec := &TCPMessage{Type: MessageTypeExec, ID: "3", IdempotencyKey: "s", Query: "UPDATE users SET a = 1"}
+	server.storeIdempotency(smallExec, small)
+	if out := server.checkIdempotency(smallExec); out == nil || !out.Success || string(out.Data) != string(small.Data) {
+		t.Errorf("Expected the small response to be replayed, got %+v", out)
+	}
+}
+
+func TestIdempotencyConcurrentCopies(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:idempotency_copies?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE payments (amount REAL)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	server := NewTCPServer(&TCPServerConfig{Runtime: runtime, EnableIdempotency: true})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			msg := &TCPMessage{Type: MessageTypeExec, ID: id, IdempotencyKey: "pay-7", Query: "INSERT INTO payments VALUES (?)", Args: []interface{}{5.0}}
+			if out := server.Process(ctx, msg); !out.Success || out.ID != id {
+				t.Errorf("Expected the response to request %s, got %+v", id, out)
+			}
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+
+	_, rows, err := runtime.queryAll(ctx, "SELECT COUNT(*) FROM payments")
+	if err != nil || fmt.Sprint(rows[0][0]) != "1" {
+		t.Errorf("Expected the copies to insert once, got %v, %v", rows, err)
+	}
+}
+
+func TestTCPClient_ExecWithIdempotency(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:client_idempotency?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+	if _, err := runtime.Exec(context.Background(), "CREATE TABLE payments (amount REAL)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	server := NewTCPServer(&TCPServerConfig{
+		Address:           "localhost:0",
+		Runtime:           runtime,
+		EnableIdempotency: true,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{Address: server.GetAddress(), Timeout: 5 * time.Second})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+	defer client.Disconnect()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ExecWithIdempotency("INSERT INTO payments VALUES (?)", "pay-42", 100.0); err != nil {
+			t.Fatalf("ExecWithIdempotency failed: %v", err)
+		}
+	}
+
+	result, err := client.QueryWithIdempotency("SELECT COUNT(*) FROM payments", "count-1")
+	if err != nil {
+		t.Fatalf("QueryWithIdempotency failed: %v", err)
+	}
+	if len(result.Rows) != 1 || fmt.Sprint(result.Rows[0][0]) != "1" {
+		t.Errorf("Expected the duplicate insert to be suppressed, got %v", result.Rows)
+	}
+
+	if _, err := client.ExecWithIdempotency("INSERT INTO payments VALUES (?)", "pay-42", 200.0); err == nil {
+		t.Error("Expected conflict when reusing the key for a different request")
+	}
+}
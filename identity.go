@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// maxIdentityBuckets bounds the per-identity buckets; when full, idle
+	// buckets are dropped before a new one is added
+	maxIdentityBuckets = 10000
+	// identityBucketIdle is how long a bucket is unused before it can be
+	// dropped. A dropped bucket comes back full, which an identity idle
+	// that long would have refilled anyway.
+	identityBucketIdle = 10 * time.Minute
+)
+
+type identityKey struct{}
+
+// WithIdentity sets the client identity (tenant, user, API key) of the
+// gated calls made with ctx, for per-identity rate limits
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity set on ctx with WithIdentity
+func IdentityFrom(ctx context.Context) string {
+	identity, _ := ctx.Value(identityKey{}).(string)
+	return identity
+}
+
+// identityLimiter keeps a token bucket per identity. An identity's rate and
+// burst are the base ones multiplied by its weight.
+type identityLimiter struct {
+	rate    float64
+	burst   float64
+	weights map[string]float64
+
+	mu      sync.Mutex
+	buckets map[string]*RateLimiter
+}
+
+func newIdentityLimiter(config *GateConfig) *identityLimiter {
+	if config == nil || config.IdentityRateLimit <= 0 {
+		return nil
+	}
+	burst := config.IdentityBurst
+	if burst <= 0 {
+		burst = config.IdentityRateLimit
+	}
+	return &identityLimiter{
+		rate:    float64(config.IdentityRateLimit),
+		burst:   float64(burst),
+		weights: config.IdentityWeights,
+		buckets: make(map[string]*RateLimiter),
+	}
+}
+
+// allow takes the cost of ctx from the bucket of its identity. Calls
+// without an identity are not limited here.
+func (il *identityLimiter) allow(ctx context.Context) error {
+	identity := IdentityFrom(ctx)
+	if il == nil || identity == "" {
+		return nil
+	}
+	if err := il.bucket(identity).AllowN(CostFrom(ctx)); err != nil {
+		if rateErr, ok := err.(*RateLimitError); ok {
+			rateErr.Identity = identity
+		}
+		return err
+	}
+	return nil
+}
+
+// bucket returns the bucket of an identity, creating it full
+func (il *identityLimiter) bucket(identity string) *RateLimiter {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	if rl, ok := il.buckets[identity]; ok {
+		return rl
+	}
+	if len(il.buckets) >= maxIdentityBuckets {
+		il.pruneLocked()
+	}
+
+	weight := 1.0
+	if w, ok := il.weights[identity]; ok && w > 0 {
+		weight = w
+	}
+	rl := &RateLimiter{
+		tokens:     il.burst * weight,
+		maxTokens:  il.burst * weight,
+		refillRate: il.rate * weight,
+		lastRefill: time.Now(),
+	}
+	il.buckets[identity] = rl
+	return rl
+}
+
+// pruneLocked drops the buckets unused for identityBucketIdle
+func (il *identityLimiter) pruneLocked() {
+	cutoff := time.Now().Add(-identityBucketIdle)
+	for identity, rl := range il.buckets {
+		rl.mu.Lock()
+		idle := rl.lastRefill.Before(cutoff)
+		rl.mu.Unlock()
+		if idle {
+			delete(il.buckets, identity)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IDGenerator produces unique identifiers. It replaces the racy
+// SELECT MAX(id)+1 pattern: every call returns an ID no other caller gets.
+type IDGenerator interface {
+	// NextID returns the next identifier
+	NextID(ctx context.Context) (string, error)
+}
+
+// Snowflake layout: 41 bits of milliseconds since the epoch, 10 bits of node
+// ID and 12 bits of per-millisecond sequence
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	snowflakeMaxNode      = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// DefaultSnowflakeEpoch is the default epoch of SnowflakeGenerator
+var DefaultSnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator generates time-ordered 63-bit IDs without coordination.
+// Every process generating IDs must use a distinct node ID.
+type SnowflakeGenerator struct {
+	mu       sync.Mutex
+	epoch    int64 // milliseconds
+	node     int64
+	lastTime int64
+	sequence int64
+}
+
+// NewSnowflakeGenerator creates a Snowflake generator for node (0-1023)
+func NewSnowflakeGenerator(node int64) (*SnowflakeGenerator, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("snowflake node must be between 0 and %d, got %d", snowflakeMaxNode, node)
+	}
+	return &SnowflakeGenerator{
+		epoch: DefaultSnowflakeEpoch.UnixMilli(),
+		node:  node,
+	}, nil
+}
+
+// NextID returns the next ID in decimal form
+func (g *SnowflakeGenerator) NextID(ctx context.Context) (string, error) {
+	id, err := g.NextInt64()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// NextInt64 returns the next ID
+func (g *SnowflakeGenerator) NextInt64() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now().UnixMilli() - g.epoch
+	// If the clock moves backwards keep using the last timestamp so IDs stay
+	// unique and ordered
+	if now < g.lastTime {
+		now = g.lastTime
+	}
+
+	if now == g.lastTime {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSequence
+		if g.sequence == 0 {
+			// Sequence exhausted for this millisecond
+			for now <= g.lastTime {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Now().UnixMilli() - g.epoch
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastTime = now
+
+	if now >= 1<<41 {
+		return 0, fmt.Errorf("snowflake timestamp overflow")
+	}
+	return now<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence, nil
+}
+
+// UUIDv7Generator generates RFC 9562 version 7 UUIDs: a millisecond
+// timestamp followed by random bits. IDs from one generator are strictly
+// increasing; a 12-bit counter orders IDs created in the same millisecond.
+type UUIDv7Generator struct {
+	mu       sync.Mutex
+	lastTime int64
+	counter  uint16
+}
+
+// NewUUIDv7Generator creates a UUIDv7 generator
+func NewUUIDv7Generator() *UUIDv7Generator {
+	return &UUIDv7Generator{}
+}
+
+// NextID returns the next UUID in its canonical string form
+func (g *UUIDv7Generator) NextID(ctx context.Context) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+
+	g.mu.Lock()
+	now := time.Now().UnixMilli()
+	if now <= g.lastTime {
+		now = g.lastTime
+		g.counter++
+		if g.counter > 0x0fff {
+			// Counter exhausted: borrow the next millisecond
+			now++
+			g.counter = 0
+		}
+	} else {
+		// Start each millisecond at a random counter value in the lower half
+		g.counter = binary.BigEndian.Uint16(b[6:8]) & 0x07ff
+	}
+	g.lastTime = now
+	counter := g.counter
+	g.mu.Unlock()
+
+	b[0] = byte(now >> 40)
+	b[1] = byte(now >> 32)
+	b[2] = byte(now >> 24)
+	b[3] = byte(now >> 16)
+	b[4] = byte(now >> 8)
+	b[5] = byte(now)
+	b[6] = 0x70 | byte(counter>>8) // version 7
+	b[7] = byte(counter)
+	b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:]), nil
+}
+
+// sequenceNamePattern restricts sequence names, which are interpolated into SQL
+var sequenceNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SequenceGenerator hands out IDs from the database. PostgreSQL and Oracle
+// use a native sequence; MySQL and SQLite, which lack sequences, use a row
+// in a counter table (default "id_sequences") that is incremented atomically.
+type SequenceGenerator struct {
+	runtime *DBRuntime
+	name    string
+	table   string
+}
+
+// NewSequenceGenerator creates a generator for the named sequence. On
+// PostgreSQL, MySQL and SQLite the sequence is created if it does not exist;
+// on Oracle it must already exist.
+func NewSequenceGenerator(runtime *DBRuntime, name string) (*SequenceGenerator, error) {
+	if !sequenceNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid sequence name: %q", name)
+	}
+
+	g := &SequenceGenerator{runtime: runtime, name: name, table: "id_sequences"}
+	if err := g.create(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to create sequence %s: %w", name, err)
+	}
+	return g, nil
+}
+
+// create creates the sequence or counter row
+func (g *SequenceGenerator) create(ctx context.Context) error {
+	var statements []string
+	switch g.runtime.config.DatabaseType {
+	case DatabaseTypePostgreSQL:
+		statements = []string{fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s", g.name)}
+	case DatabaseTypeOracle:
+		return nil
+	case DatabaseTypeMySQL:
+		statements = []string{
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name VARCHAR(255) PRIMARY KEY, value BIGINT NOT NULL)", g.table),
+			fmt.Sprintf("INSERT IGNORE INTO %s (name, value) VALUES (?, 0)", g.table),
+		}
+	case DatabaseTypeSQLite:
+		statements = []string{
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name TEXT PRIMARY KEY, value INTEGER NOT NULL)", g.table),
+			fmt.Sprintf("INSERT OR IGNORE INTO %s (name, value) VALUES (?, 0)", g.table),
+		}
+	default:
+		return fmt.Errorf("unsupported database type for sequences: %s", g.runtime.config.DatabaseType)
+	}
+
+	for i, stmt := range statements {
+		var args []interface{}
+		if i == 1 {
+			args = []interface{}{g.name}
+		}
+		if _, err := g.runtime.Exec(ctx, stmt, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextID returns the next sequence value in decimal form
+func (g *SequenceGenerator) NextID(ctx context.Context) (string, error) {
+	id, err := g.NextInt64(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// NextInt64 returns the next sequence value
+func (g *SequenceGenerator) NextInt64(ctx context.Context) (int64, error) {
+	var query string
+	var args []interface{}
+	switch g.runtime.config.DatabaseType {
+	case DatabaseTypePostgreSQL:
+		query = fmt.Sprintf("SELECT nextval('%s')", g.name)
+	case DatabaseTypeOracle:
+		query = fmt.Sprintf("SELECT %s.NEXTVAL FROM DUAL", g.name)
+	case DatabaseTypeMySQL:
+		// LAST_INSERT_ID(expr) makes the new value available on the same statement's result
+		result, err := g.runtime.Exec(ctx, fmt.Sprintf("UPDATE %s SET value = LAST_INSERT_ID(value + 1) WHERE name = ?", g.table), g.name)
+		if err != nil {
+			return 0, fmt.Errorf("failed to advance sequence %s: %w", g.name, err)
+		}
+		return result.LastInsertId()
+	case DatabaseTypeSQLite:
+		query = fmt.Sprintf("UPDATE %s SET value = value + 1 WHERE name = ? RETURNING value", g.table)
+		args = []interface{}{g.name}
+	default:
+		return 0, fmt.Errorf("unsupported database type for sequences: %s", g.runtime.config.DatabaseType)
+	}
+
+	rows, err := g.runtime.query(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to advance sequence %s: %w", g.name, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("failed to advance sequence %s: %w", g.name, err)
+		}
+		return 0, fmt.Errorf("sequence %s not found", g.name)
+	}
+	var id int64
+	if err := rows.Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to read sequence %s: %w", g.name, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestSnowflakeGenerator(t *testing.T) {
+	if _, err := NewSnowflakeGenerator(1024); err == nil {
+		t.Error("Expected error for node out of range")
+	}
+
+	gen, err := NewSnowflakeGenerator(7)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	var last int64
+	for i := 0; i < 10000; i++ {
+		id, err := gen.NextInt64()
+		if err != nil {
+			t.Fatalf("NextInt64 failed: %v", err)
+		}
+		if id <= last {
+			t.Fatalf("IDs not increasing: %d after %d", id, last)
+		}
+		if node := (id >> snowflakeSequenceBits) & snowflakeMaxNode; node != 7 {
+			t.Fatalf("Expected node 7, got %d", node)
+		}
+		last = id
+	}
+}
+
+func TestUUIDv7Generator(t *testing.T) {
+	gen := NewUUIDv7Generator()
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	last := ""
+	for i := 0; i < 10000; i++ {
+		id, err := gen.NextID(context.Background())
+		if err != nil {
+			t.Fatalf("NextID failed: %v", err)
+		}
+		if !pattern.MatchString(id) {
+			t.Fatalf("Invalid UUIDv7: %s", id)
+		}
+		if id <= last {
+			t.Fatalf("UUIDs not increasing: %s after %s", id, last)
+		}
+		last = id
+	}
+}
+
+func TestSequenceGenerator(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:sequences?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	if _, err := NewSequenceGenerator(runtime, "orders; DROP TABLE x"); err == nil {
+		t.Error("Expected error for invalid sequence name")
+	}
+
+	gen, err := NewSequenceGenerator(runtime, "orders")
+	if err != nil {
+		t.Fatalf("Failed to create sequence: %v", err)
+	}
+
+	server := NewTCPServer(&TCPServerConfig{
+		Runtime:      runtime,
+		IDGenerators: map[string]IDGenerator{"orders": gen},
+	})
+	resp := server.Process(context.Background(), &TCPMessage{
+		Type:    MessageTypeNextID,
+		ID:      "1",
+		Payload: []byte(`{"generator":"orders","count":3}`),
+	})
+	if !resp.Success {
+		t.Fatalf("NEXT_ID failed: %s", resp.Error)
+	}
+	result, err := ParseNextIDResult(resp.Data)
+	if err != nil {
+		t.Fatalf("Failed to parse result: %v", err)
+	}
+	for i, id := range result.IDs {
+		if id != strconv.Itoa(i+1) {
+			t.Errorf("Expected ID %d, got %s", i+1, id)
+		}
+	}
+
+	// Recreating the generator keeps the current value
+	gen, _ = NewSequenceGenerator(runtime, "orders")
+	if id, err := gen.NextID(context.Background()); err != nil || id != "4" {
+		t.Errorf("Expected 4, got %s err=%v", id, err)
+	}
+
+	resp = server.Process(context.Background(), &TCPMessage{Type: MessageTypeNextID, ID: "2", Payload: []byte(`{"generator":"missing"}`)})
+	if resp.Success {
+		t.Error("Expected error for unknown generator")
+	}
+}
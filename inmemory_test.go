@@ -152,4 +152,4 @@ func BenchmarkInMemoryQueries(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		runtime.Query(ctx, "SELECT COUNT(*) FROM bench")
 	}
-}
\ No newline at end of file
+}
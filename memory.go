@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrMemoryLimitExceeded is returned when a request needs more memory than
+// its own budget or than is left in the server's global budget
+var ErrMemoryLimitExceeded = errors.New("memory limit exceeded")
+
+// Approximate per-value overheads used when estimating the size of a row
+const (
+	valueOverhead = 16 // interface header
+	rowOverhead   = 24 // slice header
+)
+
+// memoryBudget tracks the approximate memory held by all in-flight requests
+// against a global limit
+type memoryBudget struct {
+	limit int64 // 0 = unlimited
+	used  int64
+}
+
+// requestMemory tracks the memory held by a single request. It is not safe
+// for concurrent use; each request owns its tracker.
+type requestMemory struct {
+	budget *memoryBudget
+	limit  int64 // 0 = unlimited
+	used   int64
+}
+
+// newRequest starts tracking a request with the given per-request limit
+func (b *memoryBudget) newRequest(limit int64) *requestMemory {
+	return &requestMemory{budget: b, limit: limit}
+}
+
+// inUse returns the memory currently reserved by in-flight requests
+func (b *memoryBudget) inUse() int64 {
+	return atomic.LoadInt64(&b.used)
+}
+
+// reserve accounts n more bytes to the request. It fails without reserving
+// anything when the request or global limit would be exceeded.
+func (m *requestMemory) reserve(n int64) error {
+	if m.limit > 0 && m.used+n > m.limit {
+		return fmt.Errorf("%w: request needs more than %d bytes", ErrMemoryLimitExceeded, m.limit)
+	}
+
+	total := atomic.AddInt64(&m.budget.used, n)
+	if m.budget.limit > 0 && total > m.budget.limit {
+		atomic.AddInt64(&m.budget.used, -n)
+		return fmt.Errorf("%w: server memory budget of %d bytes exhausted", ErrMemoryLimitExceeded, m.budget.limit)
+	}
+
+	m.used += n
+	return nil
+}
+
+// release returns everything the request reserved to the global budget
+func (m *requestMemory) release() {
+	atomic.AddInt64(&m.budget.used, -m.used)
+	m.used = 0
+}
+
+// estimateRowSize approximates the memory held by a scanned row
+func estimateRowSize(row []interface{}) int64 {
+	size := int64(rowOverhead + len(row)*valueOverhead)
+	for _, v := range row {
+		switch val := v.(type) {
+		case string:
+			size += int64(len(val))
+		case []byte:
+			size += int64(len(val))
+		case time.Time:
+			size += 24
+		case nil:
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// MemoryInUse returns the approximate memory held by in-flight requests
+func (s *TCPServer) MemoryInUse() int64 {
+	return s.memory.inUse()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTCPServer_MemoryLimits(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:memory_limits?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, `CREATE TABLE docs AS
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200)
+		SELECT i AS id, printf('%.1000c', 'x') AS body FROM n`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	server := NewTCPServer(&TCPServerConfig{
+		Address:          "localhost:0",
+		Runtime:          runtime,
+		MaxRequestMemory: 50 * 1024,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{Address: server.GetAddress(), Timeout: 5 * time.Second})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+	defer client.Disconnect()
+
+	// About 200KB of rows exceeds the per-request budget
+	_, err := client.Query("SELECT * FROM docs")
+	var dbErr *DatabaseError
+	if !errors.As(err, &dbErr) || dbErr.Code != ErrCodeMemoryLimitExceeded {
+		t.Fatalf("Expected MEMORY_LIMIT_EXCEEDED, got %v", err)
+	}
+
+	// Smaller queries still succeed
+	result, err := client.Query("SELECT * FROM docs WHERE id <= 10")
+	if err != nil {
+		t.Fatalf("Query within budget failed: %v", err)
+	}
+	if len(result.Rows) != 10 {
+		t.Errorf("Expected 10 rows, got %d", len(result.Rows))
+	}
+
+	if n := server.MemoryInUse(); n != 0 {
+		t.Errorf("Expected all memory released, got %d bytes in use", n)
+	}
+
+	// The global budget is shared by concurrent requests
+	budget := &memoryBudget{limit: 100}
+	a, b := budget.newRequest(0), budget.newRequest(0)
+	if err := a.reserve(80); err != nil {
+		t.Fatalf("reserve failed: %v", err)
+	}
+	if err := b.reserve(30); !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Errorf("Expected global limit to be exceeded, got %v", err)
+	}
+	a.release()
+	if err := b.reserve(30); err != nil {
+		t.Errorf("reserve after release failed: %v", err)
+	}
+}
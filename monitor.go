@@ -15,6 +15,10 @@ type Monitor struct {
 	callbacks []MonitorCallback
 	mu        sync.RWMutex // nolint:unused // Used for thread-safe callback management
 	running   bool
+	// time of the last circuit transition reported and the last resource
+	// pressure level, used by the loop only
+	lastTransition time.Time
+	lastPressure   string
 }
 
 // MonitorCallback is called when monitoring events occur
@@ -26,6 +30,10 @@ type MonitorEvent struct {
 	Timestamp   time.Time
 	Diagnostics *Diagnostics
 	Health      *HealthStatus
+	Canary      *CanaryResult          // set for canary events
+	Transition  *CircuitTransition     // set for circuit transition events
+	Pressure    *ResourcePressureStats // set for resource pressure events
+	Scrub       *ScrubReport           // set for blob scrub events
 	Message     string
 }
 
@@ -139,6 +147,44 @@ func (m *Monitor) checkAndNotify(ctx context.Context) {
 		}
 	}
 
+	// Report circuit breaker transitions since the last check
+	for i := range diagnostics.CircuitHistory {
+		t := diagnostics.CircuitHistory[i]
+		if !t.Time.After(m.lastTransition) {
+			continue
+		}
+		m.lastTransition = t.Time
+		transitionEvent := MonitorEvent{
+			Type:        MonitorEventCircuitTransition,
+			Timestamp:   time.Now(),
+			Diagnostics: diagnostics,
+			Transition:  &t,
+			Message:     fmt.Sprintf("Circuit breaker %s -> %s: %s", t.From, t.To, t.Reason),
+		}
+		for _, callback := range callbacks {
+			callback(transitionEvent)
+		}
+	}
+
+	// Report resource pressure level changes
+	if pressure := m.runtime.ResourcePressure(); pressure.Level != "" && pressure.Level != m.lastPressure {
+		// The first check finding no pressure is not a change
+		if m.lastPressure != "" || pressure.Level != PressureNone.String() {
+			pressureEvent := MonitorEvent{
+				Type:        MonitorEventResourcePressure,
+				Timestamp:   time.Now(),
+				Diagnostics: diagnostics,
+				Pressure:    &pressure,
+				Message: fmt.Sprintf("Resource pressure %s (heap %d bytes, %d goroutines, %d calls shed)",
+					pressure.Level, pressure.HeapBytes, pressure.Goroutines, pressure.Shed),
+			}
+			for _, callback := range callbacks {
+				callback(pressureEvent)
+			}
+		}
+		m.lastPressure = pressure.Level
+	}
+
 	// Check circuit breaker state
 	if diagnostics.CircuitBreaker == CircuitStateOpen {
 		cbEvent := MonitorEvent{
@@ -162,17 +208,27 @@ func DefaultLoggingCallback(event MonitorEvent) {
 		fmt.Printf("[ERROR] %s: Circuit breaker is open\n", event.Timestamp.Format(time.RFC3339))
 	case "slow_queries":
 		fmt.Printf("[WARN] %s: %s\n", event.Timestamp.Format(time.RFC3339), event.Message)
+	case MonitorEventCircuitTransition, MonitorEventResourcePressure:
+		fmt.Printf("[WARN] %s: %s\n", event.Timestamp.Format(time.RFC3339), event.Message)
+	case MonitorEventCanaryFailed:
+		fmt.Printf("[ERROR] %s: %s\n", event.Timestamp.Format(time.RFC3339), event.Message)
+	case MonitorEventCanaryPassed:
+		fmt.Printf("[INFO] %s: %s\n", event.Timestamp.Format(time.RFC3339), event.Message)
 	default:
 		// Periodic check - log diagnostics summary
 		if event.Diagnostics != nil {
 			stats := event.Diagnostics.ConnectionStats
-			fmt.Printf("[INFO] %s: Connections=%d/%d, Queries=%d, SuccessRate=%.2f%%\n",
+			line := fmt.Sprintf("[INFO] %s: Connections=%d/%d, Queries=%d, SuccessRate=%.2f%%",
 				event.Timestamp.Format(time.RFC3339),
 				stats.InUse,
 				stats.OpenConnections,
 				event.Diagnostics.Metrics.TotalQueries,
 				event.Diagnostics.Metrics.SuccessRate,
 			)
+			if c := event.Diagnostics.Metrics.Cache; c != nil {
+				line += fmt.Sprintf(", CacheHitRate=%.2f%%, CacheEvictions=%d", c.HitRate(), c.Evictions)
+			}
+			fmt.Println(line)
 		}
 	}
 }
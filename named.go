@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BindNamed rewrites the :name parameters of a query into the placeholder
+// style of a database type and returns the matching arguments:
+//
+//	Oracle:       :name, bound with sql.Named
+//	PostgreSQL:   $1, $2, ... (a name used twice reuses its number)
+//	MySQL/SQLite: ? (repeated for every use of a name)
+//
+// Parameters inside string literals, quoted identifiers and comments are
+// left alone, as are PostgreSQL casts (::type). Every parameter must have a
+// value in params; unused values are ignored.
+func BindNamed(dbType DatabaseType, query string, params map[string]interface{}) (string, []interface{}, error) {
+	var (
+		out     strings.Builder
+		args    []interface{}
+		numbers map[string]int // PostgreSQL placeholder number per name
+		named   map[string]bool
+	)
+	out.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := skipQuoted(query, i)
+			out.WriteString(query[i:end])
+			i = end
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+			continue
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 4
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+			continue
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			out.WriteString("::")
+			i += 2
+			continue
+		case c == ':' && i+1 < len(query) && isNameStart(query[i+1]):
+			end := i + 2
+			for end < len(query) && isNamePart(query[end]) {
+				end++
+			}
+			name := query[i+1 : end]
+			value, ok := params[name]
+			if !ok {
+				return "", nil, fmt.Errorf("missing value for parameter :%s", name)
+			}
+
+			switch dbType {
+			case DatabaseTypeOracle:
+				out.WriteString(query[i:end])
+				if named == nil {
+					named = make(map[string]bool)
+				}
+				if !named[name] {
+					named[name] = true
+					args = append(args, sql.Named(name, value))
+				}
+			case DatabaseTypePostgreSQL:
+				if numbers == nil {
+					numbers = make(map[string]int)
+				}
+				n, seen := numbers[name]
+				if !seen {
+					args = append(args, value)
+					n = len(args)
+					numbers[name] = n
+				}
+				out.WriteString("$" + strconv.Itoa(n))
+			default:
+				out.WriteByte('?')
+				args = append(args, value)
+			}
+			i = end
+			continue
+		}
+		out.WriteByte(c)
+		i++
+	}
+
+	return out.String(), args, nil
+}
+
+// skipQuoted returns the index just past the quoted section starting at
+// start. A doubled quote character is an escaped quote.
+func skipQuoted(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != quote {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(query)
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9'
+}
+
+// databaseType returns the runtime's database type after defaults
+func (r *DBRuntime) databaseType() DatabaseType {
+	return r.connManager.Config().DatabaseType
+}
+
+// Dialect returns the SQL dialect of the runtime's database type
+func (r *DBRuntime) Dialect() Dialect {
+	return DialectFor(r.databaseType())
+}
+
+// ExecNamed executes a statement with :name parameters (see BindNamed)
+func (r *DBRuntime) ExecNamed(ctx context.Context, query string, params map[string]interface{}) (sql.Result, error) {
+	bound, args, err := BindNamed(r.databaseType(), query, params)
+	if err != nil {
+		return nil, err
+	}
+	return r.Exec(ctx, bound, args...)
+}
+
+// QueryNamed executes a query with :name parameters (see BindNamed)
+func (r *DBRuntime) QueryNamed(ctx context.Context, query string, params map[string]interface{}) (*Rows, error) {
+	bound, args, err := BindNamed(r.databaseType(), query, params)
+	if err != nil {
+		return nil, err
+	}
+	return r.Query(ctx, bound, args...)
+}
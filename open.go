@@ -76,7 +76,12 @@ func NewConnectionManager(config *AdvancedConfig) *ConnectionManager {
 		validator:         NewConnectionValidator(config),
 	}
 
-	// Set defaults
+	setConnectionDefaults(config)
+	return cm
+}
+
+// setConnectionDefaults fills the unset fields of config
+func setConnectionDefaults(config *AdvancedConfig) {
 	if config.MaxOpenConns == 0 {
 		config.MaxOpenConns = 25
 	}
@@ -92,16 +97,15 @@ func NewConnectionManager(config *AdvancedConfig) *ConnectionManager {
 	if config.LeakDetectionThreshold == 0 {
 		config.LeakDetectionThreshold = 10 * time.Minute
 	}
+	// Set default database type if not specified
+	if config.DatabaseType == "" {
+		config.DatabaseType = DatabaseTypeSQLite
+	}
 	if config.ValidationQuery == "" {
 		// Set default validation query based on database type
-		switch config.DatabaseType {
-		case DatabaseTypePostgreSQL:
-			config.ValidationQuery = "SELECT 1"
-		case DatabaseTypeMySQL:
-			config.ValidationQuery = "SELECT 1"
-		default:
-			// Default to Oracle
-			config.ValidationQuery = "SELECT 1 FROM DUAL"
+		config.ValidationQuery = "SELECT 1"
+		if info, ok := LookupDriver(config.DatabaseType); ok {
+			config.ValidationQuery = info.ValidationQuery
 		}
 	}
 	if config.ValidationTimeout == 0 {
@@ -110,12 +114,6 @@ func NewConnectionManager(config *AdvancedConfig) *ConnectionManager {
 	if config.ConnectionTimeout == 0 {
 		config.ConnectionTimeout = 30 * time.Second
 	}
-	// Set default database type if not specified
-	if config.DatabaseType == "" {
-		config.DatabaseType = DatabaseTypeSQLite
-	}
-
-	return cm
 }
 
 // Open creates and configures the database connection pool
@@ -127,41 +125,9 @@ func (cm *ConnectionManager) Open() error {
 		return nil
 	}
 
-	// Open database connection based on database type
-	var driverName string
-	switch cm.config.DatabaseType {
-	case DatabaseTypePostgreSQL:
-		driverName = "postgres"
-	case DatabaseTypeMySQL:
-		driverName = "mysql"
-	case DatabaseTypeOracle:
-		driverName = "godror"
-	case DatabaseTypeSQLite:
-		driverName = "sqlite3"
-	default:
-		// Default to SQLite for in-memory capability
-		driverName = "sqlite3"
-		cm.config.DatabaseType = DatabaseTypeSQLite
-	}
-
-	db, err := sql.Open(driverName, cm.config.DSN)
+	db, err := openPool(cm.config)
 	if err != nil {
-		return fmt.Errorf("failed to open %s database: %w", cm.config.DatabaseType, err)
-	}
-
-	// Configure connection pool
-	db.SetMaxOpenConns(cm.config.MaxOpenConns)
-	db.SetMaxIdleConns(cm.config.MaxIdleConns)
-	db.SetConnMaxLifetime(cm.config.ConnMaxLifetime)
-	db.SetConnMaxIdleTime(cm.config.ConnMaxIdleTime)
-
-	// Validate initial connection
-	ctx, cancel := context.WithTimeout(context.Background(), cm.config.ConnectionTimeout)
-	defer cancel()
-
-	if err := db.PingContext(ctx); err != nil {
-		db.Close()
-		return fmt.Errorf("failed to ping database: %w", err)
+		return err
 	}
 
 	cm.db = db
@@ -173,33 +139,60 @@ func (cm *ConnectionManager) Open() error {
 
 	// Warm up connections
 	if cm.config.WarmupConnections > 0 {
-		go cm.warmupConnections()
+		go cm.warmupConnections(db, cm.config)
 	}
 
 	return nil
 }
 
-// warmupConnections pre-creates connections to reduce latency
-func (cm *ConnectionManager) warmupConnections() {
-	cm.mu.RLock()
-	db := cm.db
-	cm.mu.RUnlock()
-	
-	if cm.warmupDone.Load() || db == nil {
+// openPool opens a connection pool configured by config and pings it
+func openPool(config *AdvancedConfig) (*sql.DB, error) {
+	// Open database connection with the driver registered for the type
+	driver, ok := LookupDriver(config.DatabaseType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type %q (register a driver with RegisterDriver)", config.DatabaseType)
+	}
+
+	db, err := sql.Open(driver.DriverName, config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", config.DatabaseType, err)
+	}
+
+	// Configure connection pool
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	// Validate initial connection
+	ctx, cancel := context.WithTimeout(context.Background(), config.ConnectionTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return db, nil
+}
+
+// warmupConnections pre-creates connections on db, the pool opened with
+// config. They are passed in because Reload may swap the pool meanwhile.
+func (cm *ConnectionManager) warmupConnections(db *sql.DB, config *AdvancedConfig) {
+	if cm.warmupDone.Load() {
 		return
 	}
 	defer cm.warmupDone.Store(true)
 
-	ctx, cancel := context.WithTimeout(context.Background(), cm.config.WarmupTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), config.WarmupTimeout)
 	defer cancel()
 
-	if cm.config.WarmupTimeout == 0 {
+	if config.WarmupTimeout == 0 {
 		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 	}
 
 	// Create warmup connections
-	for i := 0; i < cm.config.WarmupConnections && i < cm.config.MaxIdleConns; i++ {
+	for i := 0; i < config.WarmupConnections && i < config.MaxIdleConns; i++ {
 		conn, err := db.Conn(ctx)
 		if err != nil {
 			continue
@@ -210,25 +203,30 @@ func (cm *ConnectionManager) warmupConnections() {
 
 // AcquireConnection acquires a connection and tracks it
 func (cm *ConnectionManager) AcquireConnection(ctx context.Context) (*sql.Conn, error) {
-	if cm.db == nil {
+	// Reload swaps these together
+	cm.mu.RLock()
+	db, config, validator := cm.db, cm.config, cm.validator
+	cm.mu.RUnlock()
+
+	if db == nil {
 		return nil, fmt.Errorf("database not opened")
 	}
 
-	conn, err := cm.db.Conn(ctx)
+	conn, err := db.Conn(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to acquire connection: %w", err)
 	}
 
 	// Validate connection if validator is configured
-	if cm.validator != nil {
-		if err := cm.validator.Validate(ctx, conn); err != nil {
+	if validator != nil {
+		if err := validator.Validate(ctx, conn); err != nil {
 			conn.Close()
 			return nil, fmt.Errorf("connection validation failed: %w", err)
 		}
 	}
 
 	// Track connection for leak detection
-	if cm.config.EnableLeakDetection {
+	if config.EnableLeakDetection {
 		cm.trackConnection(conn)
 	}
 
@@ -279,6 +277,24 @@ func (cm *ConnectionManager) Close() error {
 	return nil
 }
 
+// Config returns the connection settings of the current pool
+func (cm *ConnectionManager) Config() *AdvancedConfig {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.config
+}
+
+// swap makes db, opened with config, the manager's pool and returns the
+// previous pool
+func (cm *ConnectionManager) swap(db *sql.DB, config *AdvancedConfig) *sql.DB {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	old := cm.db
+	cm.db, cm.config = db, config
+	cm.validator = NewConnectionValidator(config)
+	return old
+}
+
 // DB returns the underlying database connection pool
 func (cm *ConnectionManager) DB() *sql.DB {
 	cm.mu.RLock()
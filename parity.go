@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parity difference kinds
+const (
+	ParityColumnMissing = "column_missing"
+	ParityRowMissingA   = "row_missing_in_a"
+	ParityRowMissingB   = "row_missing_in_b"
+	ParityValueMismatch = "value_mismatch"
+	ParityDuplicateKey  = "duplicate_key"
+)
+
+// ParityOptions controls how VerifyParity compares two result sets
+type ParityOptions struct {
+	// KeyColumns match rows by these columns. Rows are matched by position
+	// when empty, which requires a deterministic ORDER BY.
+	KeyColumns []string
+	// IgnoreColumns are excluded from the comparison
+	IgnoreColumns []string
+	// NumericTolerance is the allowed absolute difference between numbers
+	NumericTolerance float64
+	// TimeTolerance is the allowed difference between timestamps
+	TimeTolerance time.Duration
+	// TrimStrings ignores trailing spaces (e.g. Oracle CHAR padding)
+	TrimStrings bool
+	// NullEqualsEmpty treats NULL and '' as equal (Oracle stores '' as NULL)
+	NullEqualsEmpty bool
+	// MaxDifferences stops collecting differences after this many (default 100)
+	MaxDifferences int
+}
+
+// ParityDifference describes a single difference between two result sets
+type ParityDifference struct {
+	Kind   string      `json:"kind"`
+	Row    int         `json:"row"` // row index in A (in B for row_missing_in_a)
+	Key    string      `json:"key,omitempty"`
+	Column string      `json:"column,omitempty"`
+	A      interface{} `json:"a,omitempty"`
+	B      interface{} `json:"b,omitempty"`
+}
+
+// ParityReport is the result of VerifyParity
+type ParityReport struct {
+	Query       string             `json:"query"`
+	RowsA       int                `json:"rows_a"`
+	RowsB       int                `json:"rows_b"`
+	Differences []ParityDifference `json:"differences,omitempty"`
+	Truncated   bool               `json:"truncated"`
+}
+
+// Equal reports whether no differences were found
+func (pr *ParityReport) Equal() bool {
+	return len(pr.Differences) == 0
+}
+
+// String returns a human readable summary of the report
+func (pr *ParityReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Parity: rows A=%d B=%d, %d difference(s)", pr.RowsA, pr.RowsB, len(pr.Differences))
+	if pr.Truncated {
+		b.WriteString(" (truncated)")
+	}
+	for _, d := range pr.Differences {
+		fmt.Fprintf(&b, "\n  %s row=%d", d.Kind, d.Row)
+		if d.Key != "" {
+			fmt.Fprintf(&b, " key=%s", d.Key)
+		}
+		if d.Column != "" {
+			fmt.Fprintf(&b, " column=%s", d.Column)
+		}
+		if d.Kind == ParityValueMismatch {
+			fmt.Fprintf(&b, " a=%v b=%v", d.A, d.B)
+		}
+	}
+	return b.String()
+}
+
+// VerifyParity runs the same query against two runtimes and reports
+// row and column level differences using default options
+func VerifyParity(ctx context.Context, a, b *DBRuntime, query string, args []interface{}) (*ParityReport, error) {
+	return VerifyParityWithOptions(ctx, a, b, query, args, nil)
+}
+
+// VerifyParityWithOptions runs the same query against two runtimes (e.g. a
+// legacy Oracle database and its PostgreSQL replacement) and reports row and
+// column level differences. Column names are compared case-insensitively.
+func VerifyParityWithOptions(ctx context.Context, a, b *DBRuntime, query string, args []interface{}, opts *ParityOptions) (*ParityReport, error) {
+	colsA, rowsA, err := a.queryAll(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("runtime A: %w", err)
+	}
+	colsB, rowsB, err := b.queryAll(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("runtime B: %w", err)
+	}
+	return CompareResults(query, colsA, rowsA, colsB, rowsB, opts)
+}
+
+// CompareResults compares two materialized result sets
+func CompareResults(query string, colsA []string, rowsA [][]interface{}, colsB []string, rowsB [][]interface{}, opts *ParityOptions) (*ParityReport, error) {
+	if opts == nil {
+		opts = &ParityOptions{}
+	}
+	maxDiffs := opts.MaxDifferences
+	if maxDiffs <= 0 {
+		maxDiffs = 100
+	}
+
+	report := &ParityReport{Query: query, RowsA: len(rowsA), RowsB: len(rowsB)}
+	add := func(d ParityDifference) bool {
+		if len(report.Differences) >= maxDiffs {
+			report.Truncated = true
+			return false
+		}
+		report.Differences = append(report.Differences, d)
+		return true
+	}
+
+	ignored := make(map[string]bool, len(opts.IgnoreColumns))
+	for _, c := range opts.IgnoreColumns {
+		ignored[strings.ToLower(c)] = true
+	}
+
+	// Map columns by lower-cased name
+	indexB := make(map[string]int, len(colsB))
+	for i, c := range colsB {
+		indexB[strings.ToLower(c)] = i
+	}
+	type columnPair struct {
+		name   string
+		ia, ib int
+	}
+	var pairs []columnPair
+	seen := make(map[string]bool, len(colsA))
+	for i, c := range colsA {
+		name := strings.ToLower(c)
+		seen[name] = true
+		if ignored[name] {
+			continue
+		}
+		if j, ok := indexB[name]; ok {
+			pairs = append(pairs, columnPair{name: name, ia: i, ib: j})
+		} else if !add(ParityDifference{Kind: ParityColumnMissing, Row: -1, Column: c, A: c}) {
+			return report, nil
+		}
+	}
+	for _, c := range colsB {
+		if name := strings.ToLower(c); !seen[name] && !ignored[name] {
+			if !add(ParityDifference{Kind: ParityColumnMissing, Row: -1, Column: c, B: c}) {
+				return report, nil
+			}
+		}
+	}
+
+	compareRow := func(rowIndex int, key string, ra, rb []interface{}) bool {
+		for _, p := range pairs {
+			if !parityValuesEqual(ra[p.ia], rb[p.ib], opts) {
+				if !add(ParityDifference{Kind: ParityValueMismatch, Row: rowIndex, Key: key, Column: p.name, A: ra[p.ia], B: rb[p.ib]}) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	// Positional matching
+	if len(opts.KeyColumns) == 0 {
+		for i := 0; i < len(rowsA) || i < len(rowsB); i++ {
+			switch {
+			case i >= len(rowsB):
+				if !add(ParityDifference{Kind: ParityRowMissingB, Row: i}) {
+					return report, nil
+				}
+			case i >= len(rowsA):
+				if !add(ParityDifference{Kind: ParityRowMissingA, Row: i}) {
+					return report, nil
+				}
+			default:
+				if !compareRow(i, "", rowsA[i], rowsB[i]) {
+					return report, nil
+				}
+			}
+		}
+		return report, nil
+	}
+
+	// Key-based matching
+	keyA, err := keyColumnIndexes(opts.KeyColumns, colsA)
+	if err != nil {
+		return nil, fmt.Errorf("runtime A: %w", err)
+	}
+	keyB, err := keyColumnIndexes(opts.KeyColumns, colsB)
+	if err != nil {
+		return nil, fmt.Errorf("runtime B: %w", err)
+	}
+
+	byKeyB := make(map[string]int, len(rowsB))
+	for j, row := range rowsB {
+		key := parityRowKey(row, keyB, opts)
+		if _, dup := byKeyB[key]; dup {
+			if !add(ParityDifference{Kind: ParityDuplicateKey, Row: j, Key: key}) {
+				return report, nil
+			}
+			continue
+		}
+		byKeyB[key] = j
+	}
+
+	matched := make(map[int]bool, len(rowsB))
+	for i, row := range rowsA {
+		key := parityRowKey(row, keyA, opts)
+		j, ok := byKeyB[key]
+		if !ok || matched[j] {
+			if !add(ParityDifference{Kind: ParityRowMissingB, Row: i, Key: key}) {
+				return report, nil
+			}
+			continue
+		}
+		matched[j] = true
+		if !compareRow(i, key, row, rowsB[j]) {
+			return report, nil
+		}
+	}
+	for j, row := range rowsB {
+		if !matched[j] {
+			if !add(ParityDifference{Kind: ParityRowMissingA, Row: j, Key: parityRowKey(row, keyB, opts)}) {
+				return report, nil
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// keyColumnIndexes resolves key column names to indexes
+func keyColumnIndexes(keys, columns []string) ([]int, error) {
+	indexes := make([]int, 0, len(keys))
+	for _, k := range keys {
+		found := -1
+		for i, c := range columns {
+			if strings.EqualFold(k, c) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return nil, fmt.Errorf("key column %q not in result", k)
+		}
+		indexes = append(indexes, found)
+	}
+	return indexes, nil
+}
+
+// parityRowKey builds a comparable key from the key columns of a row
+func parityRowKey(row []interface{}, indexes []int, opts *ParityOptions) string {
+	parts := make([]string, len(indexes))
+	for i, idx := range indexes {
+		v := normalizeParityValue(row[idx], opts)
+		if n, ok := parityNumber(v); ok {
+			parts[i] = n.key()
+		} else {
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// normalizeParityValue maps driver-specific representations to comparable values
+func normalizeParityValue(v interface{}, opts *ParityOptions) interface{} {
+	switch val := v.(type) {
+	case []byte:
+		v = string(val)
+	}
+	if s, ok := v.(string); ok {
+		if opts.TrimStrings {
+			s = strings.TrimRight(s, " ")
+		}
+		if opts.NullEqualsEmpty && s == "" {
+			return nil
+		}
+		return s
+	}
+	return v
+}
+
+// parityValuesEqual compares two values with the configured tolerances
+func parityValuesEqual(a, b interface{}, opts *ParityOptions) bool {
+	a = normalizeParityValue(a, opts)
+	b = normalizeParityValue(b, opts)
+
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		if !ok {
+			return false
+		}
+		diff := ta.Sub(tb)
+		if diff < 0 {
+			diff = -diff
+		}
+		return diff <= opts.TimeTolerance
+	}
+
+	// Drivers disagree on numeric types (int64, float64, decimal strings)
+	if na, ok := parityNumber(a); ok {
+		if nb, ok := parityNumber(b); ok {
+			return na.equal(nb, opts.NumericTolerance)
+		}
+	}
+
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// parityNum is a number read by parityNumber. Integers are kept exact,
+// since int64 and uint64 values above 2^53 collide as float64.
+type parityNum struct {
+	i *big.Int // nil for floats
+	f float64
+}
+
+// parityNumber converts numeric values and numeric strings to a parityNum
+func parityNumber(v interface{}) (parityNum, bool) {
+	switch n := v.(type) {
+	case int:
+		return parityNum{i: big.NewInt(int64(n))}, true
+	case int32:
+		return parityNum{i: big.NewInt(int64(n))}, true
+	case int64:
+		return parityNum{i: big.NewInt(n)}, true
+	case uint64:
+		return parityNum{i: new(big.Int).SetUint64(n)}, true
+	case float32:
+		return parityNum{f: float64(n)}, true
+	case float64:
+		return parityNum{f: n}, true
+	case string:
+		n = strings.TrimSpace(n)
+		if i, ok := new(big.Int).SetString(n, 10); ok {
+			return parityNum{i: i}, true
+		}
+		f, err := strconv.ParseFloat(n, 64)
+		return parityNum{f: f}, err == nil
+	}
+	return parityNum{}, false
+}
+
+// equal compares two integers exactly, and as floats when either is one
+func (n parityNum) equal(o parityNum, tolerance float64) bool {
+	if n.i != nil && o.i != nil {
+		diff := new(big.Int).Sub(n.i, o.i)
+		if diff.Sign() == 0 {
+			return true
+		}
+		f, _ := new(big.Float).SetInt(diff.Abs(diff)).Float64()
+		return f <= tolerance
+	}
+	return math.Abs(n.float()-o.float()) <= tolerance
+}
+
+func (n parityNum) float() float64 {
+	if n.i == nil {
+		return n.f
+	}
+	f, _ := new(big.Float).SetInt(n.i).Float64()
+	return f
+}
+
+// key formats the number for parityRowKey, integral floats as integers so
+// they match the same integer
+func (n parityNum) key() string {
+	if n.i != nil {
+		return n.i.String()
+	}
+	if !math.IsInf(n.f, 0) && n.f == math.Trunc(n.f) {
+		i, _ := big.NewFloat(n.f).Int(nil)
+		return i.String()
+	}
+	return strconv.FormatFloat(n.f, 'g', -1, 64)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareResults_Positional(t *testing.T) {
+	cols := []string{"id", "name", "amount"}
+	rowsA := [][]interface{}{{int64(1), "alice", 10.0}, {int64(2), "bob", 20.0}}
+	rowsB := [][]interface{}{{int64(1), []byte("alice"), "10.00"}, {int64(2), "bob", 20.5}}
+
+	report, err := CompareResults("q", cols, rowsA, []string{"ID", "NAME", "AMOUNT"}, rowsB, nil)
+	if err != nil {
+		t.Fatalf("CompareResults failed: %v", err)
+	}
+	if len(report.Differences) != 1 {
+		t.Fatalf("Expected 1 difference, got %s", report)
+	}
+	if d := report.Differences[0]; d.Kind != ParityValueMismatch || d.Row != 1 || d.Column != "amount" {
+		t.Errorf("Unexpected difference: %+v", d)
+	}
+
+	report, _ = CompareResults("q", cols, rowsA, cols, rowsB, &ParityOptions{NumericTolerance: 0.5})
+	if !report.Equal() {
+		t.Errorf("Expected equal within tolerance, got %s", report)
+	}
+}
+
+func TestCompareResults_Keyed(t *testing.T) {
+	cols := []string{"id", "name"}
+	rowsA := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}}
+	rowsB := [][]interface{}{{"3", "c  "}, {int64(1), "a"}, {int64(4), "d"}}
+
+	report, err := CompareResults("q", cols, rowsA, cols, rowsB, &ParityOptions{KeyColumns: []string{"id"}, TrimStrings: true})
+	if err != nil {
+		t.Fatalf("CompareResults failed: %v", err)
+	}
+	kinds := map[string]string{}
+	for _, d := range report.Differences {
+		kinds[d.Key] = d.Kind
+	}
+	if len(report.Differences) != 2 || kinds["2"] != ParityRowMissingB || kinds["4"] != ParityRowMissingA {
+		t.Errorf("Unexpected differences: %s", report)
+	}
+
+	if _, err := CompareResults("q", cols, rowsA, cols, rowsB, &ParityOptions{KeyColumns: []string{"missing"}}); err == nil {
+		t.Error("Expected error for unknown key column")
+	}
+}
+
+func TestCompareResults_ColumnsAndTolerances(t *testing.T) {
+	now := time.Now()
+	rowsA := [][]interface{}{{now, nil, "x"}}
+	rowsB := [][]interface{}{{now.Add(500 * time.Millisecond), "", "y"}}
+
+	opts := &ParityOptions{TimeTolerance: time.Second, NullEqualsEmpty: true, IgnoreColumns: []string{"extra"}}
+	report, _ := CompareResults("q", []string{"ts", "note", "extra"}, rowsA, []string{"ts", "note", "extra"}, rowsB, opts)
+	if !report.Equal() {
+		t.Errorf("Expected equal, got %s", report)
+	}
+
+	report, _ = CompareResults("q", []string{"a", "b"}, nil, []string{"a", "c"}, nil, nil)
+	if len(report.Differences) != 2 || report.Differences[0].Kind != ParityColumnMissing {
+		t.Errorf("Expected 2 missing columns, got %s", report)
+	}
+
+	report, _ = CompareResults("q", []string{"a"}, [][]interface{}{{1}, {2}, {3}}, []string{"a"}, nil, &ParityOptions{MaxDifferences: 2})
+	if len(report.Differences) != 2 || !report.Truncated {
+		t.Errorf("Expected truncated report, got %s", report)
+	}
+}
+
+func TestCompareResults_LargeIntegers(t *testing.T) {
+	// 2^53 + 1 and 2^53 are the same float64
+	cols := []string{"id", "n"}
+	rowsA := [][]interface{}{{int64(1 << 53), int64(1<<53 + 1)}, {int64(1<<53 + 1), uint64(1<<64 - 1)}}
+	rowsB := [][]interface{}{{int64(1 << 53), int64(1 << 53)}, {"9007199254740993", "18446744073709551614"}}
+
+	report, err := CompareResults("q", cols, rowsA, cols, rowsB, &ParityOptions{KeyColumns: []string{"id"}})
+	if err != nil {
+		t.Fatalf("CompareResults failed: %v", err)
+	}
+	if len(report.Differences) != 2 || report.Differences[0].Kind != ParityValueMismatch || report.Differences[1].Kind != ParityValueMismatch {
+		t.Errorf("Expected both values to differ, got %s", report)
+	}
+
+	// Rows keyed by float and integer forms of a value still match
+	report, _ = CompareResults("q", cols, [][]interface{}{{float64(1e20), 1.5}}, cols, [][]interface{}{{"100000000000000000000", "1.5"}}, &ParityOptions{KeyColumns: []string{"id"}})
+	if !report.Equal() {
+		t.Errorf("Expected equal, got %s", report)
+	}
+}
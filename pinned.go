@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// PinnedConn is a single pooled connection leased with WithPinnedConn.
+// Session state (temporary tables, session variables, Oracle package state)
+// persists between its statements. It must not be used after the
+// WithPinnedConn callback returns.
+type PinnedConn struct {
+	conn    *sql.Conn
+	adb     *AdvancedDB
+	discard bool
+}
+
+// WithPinnedConn leases one connection from the pool for the duration of fn
+// and returns it afterwards. The lease takes one gate slot and counts as one
+// success or failure for the circuit breaker; each statement is recorded in
+// the metrics and runs the query hooks. Statements are not retried, since a
+// retry could run on a session that lost its state.
+//
+// Session state stays on the connection when it goes back to the pool;
+// call Discard to close the connection instead.
+func (adb *AdvancedDB) WithPinnedConn(ctx context.Context, fn func(ctx context.Context, pc *PinnedConn) error) error {
+	_, err := ExecuteWithGate(adb.gate, ctx, func(ctx context.Context) (struct{}, error) {
+		conn, err := adb.db.Load().Conn(ctx)
+		if err != nil {
+			return struct{}{}, fmt.Errorf("failed to lease a connection: %w", err)
+		}
+		pc := &PinnedConn{conn: conn, adb: adb}
+		defer pc.release()
+		return struct{}{}, fn(ctx, pc)
+	})
+	return err
+}
+
+// release returns the connection to the pool, or closes it if discarded
+func (pc *PinnedConn) release() {
+	if pc.discard {
+		// driver.ErrBadConn makes database/sql close the connection
+		_ = pc.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	pc.conn.Close()
+}
+
+// Discard closes the connection when the lease ends instead of returning it
+// to the pool, so its session state cannot leak into later users
+func (pc *PinnedConn) Discard() {
+	pc.discard = true
+}
+
+// Exec executes a statement on the pinned connection with the query timeout
+func (pc *PinnedConn) Exec(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	adb := pc.adb
+	start := time.Now()
+	defer func() {
+		adb.metrics.RecordQuery(time.Since(start), err)
+	}()
+
+	ctx, done, err := runQueryHooks(ctx, adb.queryHooks(), query, args)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, adb.queryTimeout)
+	defer cancel()
+
+	result, err = pc.conn.ExecContext(ctx, adb.taggedQuery(ctx, query), args...)
+	done(err)
+	return result, err
+}
+
+// Query executes a query on the pinned connection. The rows must be closed
+// before the next statement on the connection.
+func (pc *PinnedConn) Query(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	adb := pc.adb
+	start := time.Now()
+	defer func() {
+		adb.metrics.RecordQuery(time.Since(start), err)
+	}()
+
+	ctx, done, err := runQueryHooks(ctx, adb.queryHooks(), query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows are read after Query returns, so the query is bounded by ctx
+	// (and the lease) rather than the query timeout
+	rows, err = pc.conn.QueryContext(ctx, adb.taggedQuery(ctx, query), args...)
+	done(err)
+	return rows, err
+}
+
+// Begin starts a transaction on the pinned connection. It must end before
+// the lease does.
+func (pc *PinnedConn) Begin(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return pc.conn.BeginTx(ctx, opts)
+}
+
+// WithPinnedConn leases one connection for a sequence of statements (see
+// AdvancedDB.WithPinnedConn)
+func (r *DBRuntime) WithPinnedConn(ctx context.Context, fn func(ctx context.Context, pc *PinnedConn) error) error {
+	if !r.IsConnected() {
+		return fmt.Errorf("database not connected")
+	}
+	return r.advancedDB.WithPinnedConn(ctx, fn)
+}
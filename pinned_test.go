@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWithPinnedConn(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:pinned?mode=memory&cache=shared").
+		WithConnectionPool(4, 4).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	limiter := runtime.advancedDB.gate.connectionLimiter
+	before := runtime.Metrics().TotalQueries
+
+	var total int
+	err := runtime.WithPinnedConn(ctx, func(ctx context.Context, pc *PinnedConn) error {
+		if got := limiter.CurrentConnections(); got != 1 {
+			t.Errorf("Expected the lease to hold one gate slot, got %d", got)
+		}
+		// Temporary tables are only visible on the connection that made them
+		if _, err := pc.Exec(ctx, "CREATE TEMP TABLE scratch (n INTEGER)"); err != nil {
+			return err
+		}
+		for i := 1; i <= 3; i++ {
+			if _, err := pc.Exec(ctx, "INSERT INTO scratch (n) VALUES (?)", i); err != nil {
+				return err
+			}
+		}
+		rows, err := pc.Query(ctx, "SELECT SUM(n) FROM scratch")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if rows.Next() {
+			return rows.Scan(&total)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		t.Fatalf("WithPinnedConn failed: %v", err)
+	}
+	if total != 6 {
+		t.Errorf("Expected a sum of 6, got %d", total)
+	}
+	if got := runtime.Metrics().TotalQueries - before; got != 5 {
+		t.Errorf("Expected 5 recorded statements, got %d", got)
+	}
+	if got := limiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected the gate slot to be released, got %d", got)
+	}
+
+	errStop := errors.New("stop")
+	err = runtime.WithPinnedConn(ctx, func(ctx context.Context, pc *PinnedConn) error {
+		pc.Discard()
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("Expected the callback error, got %v", err)
+	}
+	if got := limiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected the gate slot to be released after a failure, got %d", got)
+	}
+}
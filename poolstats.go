@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// PoolSamplerConfig configures the connection pool sampler
+type PoolSamplerConfig struct {
+	Interval time.Duration // time between samples (default 10s)
+	Samples  int           // samples kept, oldest dropped first (default 360, one hour at 10s)
+}
+
+// PoolSample is the state of the connection pool at the end of an interval.
+// Counters are deltas over the interval rather than totals since open, so
+// trends such as growing waits are visible.
+type PoolSample struct {
+	Time              time.Time     `json:"time"`
+	Interval          time.Duration `json:"interval_ns"`
+	OpenConnections   int           `json:"open_connections"`
+	InUse             int           `json:"in_use"`
+	Idle              int           `json:"idle"`
+	WaitCount         int64         `json:"wait_count"`
+	WaitDuration      time.Duration `json:"wait_duration_ns"`
+	MaxIdleClosed     int64         `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64         `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64         `json:"max_lifetime_closed"`
+}
+
+// poolSampler records PoolSamples into a ring buffer
+type poolSampler struct {
+	config PoolSamplerConfig
+	stats  func() sql.DBStats
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	mu      sync.RWMutex
+	samples []PoolSample // ring
+	next    int
+	last    sql.DBStats
+	lastAt  time.Time
+}
+
+func newPoolSampler(config PoolSamplerConfig, stats func() sql.DBStats) *poolSampler {
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+	if config.Samples <= 0 {
+		config.Samples = 360
+	}
+	return &poolSampler{
+		config: config,
+		stats:  stats,
+		stop:   make(chan struct{}),
+		last:   stats(),
+		lastAt: time.Now(),
+	}
+}
+
+func (ps *poolSampler) start() {
+	ps.wg.Add(1)
+	go func() {
+		defer ps.wg.Done()
+		ticker := time.NewTicker(ps.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ps.stop:
+				return
+			case <-ticker.C:
+				ps.sample()
+			}
+		}
+	}()
+}
+
+func (ps *poolSampler) close() {
+	close(ps.stop)
+	ps.wg.Wait()
+}
+
+// sample records the pool state since the previous sample
+func (ps *poolSampler) sample() {
+	now, cur := time.Now(), ps.stats()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if cur.WaitCount < ps.last.WaitCount || cur.WaitDuration < ps.last.WaitDuration {
+		// The counters restarted: Reload replaced the pool
+		ps.last = sql.DBStats{}
+	}
+	s := PoolSample{
+		Time:              now,
+		Interval:          now.Sub(ps.lastAt),
+		OpenConnections:   cur.OpenConnections,
+		InUse:             cur.InUse,
+		Idle:              cur.Idle,
+		WaitCount:         cur.WaitCount - ps.last.WaitCount,
+		WaitDuration:      cur.WaitDuration - ps.last.WaitDuration,
+		MaxIdleClosed:     cur.MaxIdleClosed - ps.last.MaxIdleClosed,
+		MaxIdleTimeClosed: cur.MaxIdleTimeClosed - ps.last.MaxIdleTimeClosed,
+		MaxLifetimeClosed: cur.MaxLifetimeClosed - ps.last.MaxLifetimeClosed,
+	}
+	ps.last, ps.lastAt = cur, now
+
+	if len(ps.samples) < ps.config.Samples {
+		ps.samples = append(ps.samples, s)
+		return
+	}
+	ps.samples[ps.next] = s
+	ps.next = (ps.next + 1) % ps.config.Samples
+}
+
+// since returns the samples taken after t, oldest first
+func (ps *poolSampler) since(t time.Time) []PoolSample {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var out []PoolSample
+	for i := range ps.samples {
+		s := ps.samples[(ps.next+i)%len(ps.samples)]
+		if s.Time.After(t) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// PoolSamples returns the connection pool samples of the last window,
+// oldest first (window <= 0 returns all kept samples). It returns nil
+// unless RuntimeConfig.PoolSampler is set and the runtime is connected.
+func (r *DBRuntime) PoolSamples(window time.Duration) []PoolSample {
+	r.samplerMu.Lock()
+	sampler := r.sampler
+	r.samplerMu.Unlock()
+	if sampler == nil {
+		return nil
+	}
+
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+	}
+	return sampler.since(since)
+}
+
+// startPoolSampler starts the sampler if configured
+func (r *DBRuntime) startPoolSampler() {
+	if r.config.PoolSampler == nil {
+		return
+	}
+	// Stats are read through r.DB() since Reload replaces the pool
+	sampler := newPoolSampler(*r.config.PoolSampler, func() sql.DBStats { return r.DB().Stats() })
+	sampler.start()
+
+	r.samplerMu.Lock()
+	r.sampler = sampler
+	r.samplerMu.Unlock()
+}
+
+// stopPoolSampler stops the sampler
+func (r *DBRuntime) stopPoolSampler() {
+	r.samplerMu.Lock()
+	sampler := r.sampler
+	r.sampler = nil
+	r.samplerMu.Unlock()
+	if sampler != nil {
+		sampler.close()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestPoolSampler(t *testing.T) {
+	var stats sql.DBStats
+	sampler := newPoolSampler(PoolSamplerConfig{Interval: time.Hour, Samples: 3}, func() sql.DBStats { return stats })
+
+	for i := 1; i <= 4; i++ {
+		stats.WaitCount += int64(i)
+		stats.WaitDuration += time.Duration(i) * time.Millisecond
+		stats.InUse = i
+		sampler.sample()
+	}
+
+	// The ring keeps the last 3 samples, oldest first, as per-interval deltas
+	samples := sampler.since(time.Time{})
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %d", len(samples))
+	}
+	for i, s := range samples {
+		if s.WaitCount != int64(i+2) || s.WaitDuration != time.Duration(i+2)*time.Millisecond || s.InUse != i+2 {
+			t.Errorf("Unexpected sample %d: %+v", i, s)
+		}
+	}
+	if got := sampler.since(samples[1].Time); len(got) != 1 || got[0].InUse != 4 {
+		t.Errorf("Expected only the last sample, got %+v", got)
+	}
+
+	config := NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:poolsampler?mode=memory&cache=shared").
+		WithPoolSampler(10*time.Millisecond, 10).
+		Build()
+	runtime := NewDBRuntime(config)
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(runtime.PoolSamples(time.Minute)) == 0 {
+		t.Error("Expected the runtime to record pool samples")
+	}
+	runtime.Disconnect()
+	if runtime.PoolSamples(0) != nil {
+		t.Error("Expected no samples after disconnect")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	goruntime "runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MonitorEventResourcePressure is the Monitor event of a resource pressure
+// level change
+const MonitorEventResourcePressure = "resource_pressure"
+
+// pressureRecovery is the share of a limit usage must fall under before the
+// shedder leaves a level, so it does not flap around the limit
+const pressureRecovery = 0.9
+
+// ResourcePressureConfig configures the shedder that rejects gated calls
+// while the process is short of memory or drowning in goroutines. At a
+// limit low priority calls are shed (see WithPriority); at CriticalRatio
+// times a limit only PriorityHigh calls are admitted.
+type ResourcePressureConfig struct {
+	Interval      time.Duration // time between samples (default 1s)
+	MaxHeapBytes  uint64        // heap in use at which shedding starts (0 ignores the heap)
+	MaxGoroutines int           // goroutines at which shedding starts (0 ignores them)
+	CriticalRatio float64       // multiple of a limit at which normal priority is shed too (default 1.25)
+}
+
+// PressureLevel is how short of resources the process is
+type PressureLevel int32
+
+const (
+	// PressureNone admits every call
+	PressureNone PressureLevel = iota
+	// PressureElevated sheds low priority calls
+	PressureElevated
+	// PressureCritical sheds all but high priority calls
+	PressureCritical
+)
+
+func (l PressureLevel) String() string {
+	switch l {
+	case PressureElevated:
+		return "elevated"
+	case PressureCritical:
+		return "critical"
+	}
+	return "none"
+}
+
+// ResourcePressureStats reports the resource shedder
+type ResourcePressureStats struct {
+	Level      string    `json:"level"`
+	HeapBytes  uint64    `json:"heap_bytes"`
+	Goroutines int       `json:"goroutines"`
+	Shed       int64     `json:"shed"`
+	Since      time.Time `json:"since"` // when the current level was entered
+}
+
+// resourceShedder samples the process resources and sheds gated calls by
+// priority under pressure
+type resourceShedder struct {
+	config ResourcePressureConfig
+	read   func() (heap uint64, goroutines int)
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	level atomic.Int32
+	shed  atomic.Int64
+
+	mu         sync.Mutex
+	heap       uint64
+	goroutines int
+	since      time.Time
+}
+
+func newResourceShedder(config ResourcePressureConfig) *resourceShedder {
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	if config.CriticalRatio <= 1 {
+		config.CriticalRatio = 1.25
+	}
+	return &resourceShedder{
+		config: config,
+		read:   readProcessResources,
+		since:  time.Now(),
+	}
+}
+
+// readProcessResources returns the heap in use and the goroutine count
+func readProcessResources() (uint64, int) {
+	var m goruntime.MemStats
+	goruntime.ReadMemStats(&m)
+	return m.HeapInuse, goruntime.NumGoroutine()
+}
+
+func (s *resourceShedder) start() {
+	s.sample()
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.sample()
+			}
+		}
+	}()
+}
+
+// close stops the shedder if it is running and admits every call again
+func (s *resourceShedder) close() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	s.stop = nil
+	s.level.Store(int32(PressureNone))
+}
+
+// sample reads the resources and sets the pressure level
+func (s *resourceShedder) sample() {
+	heap, goroutines := s.read()
+
+	// usage is the highest share of a limit in use
+	usage := 0.0
+	if s.config.MaxHeapBytes > 0 {
+		usage = max(usage, float64(heap)/float64(s.config.MaxHeapBytes))
+	}
+	if s.config.MaxGoroutines > 0 {
+		usage = max(usage, float64(goroutines)/float64(s.config.MaxGoroutines))
+	}
+
+	current := PressureLevel(s.level.Load())
+	level := PressureNone
+	switch {
+	case usage >= s.config.CriticalRatio,
+		current == PressureCritical && usage >= s.config.CriticalRatio*pressureRecovery:
+		level = PressureCritical
+	case usage >= 1, current >= PressureElevated && usage >= pressureRecovery:
+		level = PressureElevated
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heap, s.goroutines = heap, goroutines
+	if level != current {
+		s.level.Store(int32(level))
+		s.since = time.Now()
+	}
+}
+
+// admit sheds the call of ctx if its priority is too low for the pressure
+func (s *resourceShedder) admit(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	level := PressureLevel(s.level.Load())
+	if level == PressureNone {
+		return nil
+	}
+	p := PriorityFrom(ctx)
+	if p <= PriorityLow || (level == PressureCritical && p < PriorityHigh) {
+		s.shed.Add(1)
+		return fmt.Errorf("%s resource pressure: %w", level, ErrLoadShed)
+	}
+	return nil
+}
+
+func (s *resourceShedder) snapshot() ResourcePressureStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ResourcePressureStats{
+		Level:      PressureLevel(s.level.Load()).String(),
+		HeapBytes:  s.heap,
+		Goroutines: s.goroutines,
+		Shed:       s.shed.Load(),
+		Since:      s.since,
+	}
+}
+
+// ResourcePressure returns the state of the resource shedder. It returns
+// zero stats unless RuntimeConfig.ResourcePressure is set.
+func (r *DBRuntime) ResourcePressure() ResourcePressureStats {
+	if r.shedder == nil {
+		return ResourcePressureStats{}
+	}
+	return r.shedder.snapshot()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLoadShed is returned when the gate is too busy to admit a call of its
+// priority
+var ErrLoadShed = errors.New("load shed: gate saturated for this priority")
+
+// Priority orders gated calls when the gate is saturated
+type Priority int
+
+const (
+	// PriorityLow is background work (reports, batch jobs) shed first
+	PriorityLow Priority = -1
+	// PriorityNormal is the default priority
+	PriorityNormal Priority = 0
+	// PriorityHigh is work that may use every connection slot
+	PriorityHigh Priority = 1
+)
+
+func (p Priority) String() string {
+	switch {
+	case p <= PriorityLow:
+		return "low"
+	case p >= PriorityHigh:
+		return "high"
+	}
+	return "normal"
+}
+
+type priorityKey struct{}
+
+// WithPriority sets the priority of the gated calls made with ctx
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the priority set on ctx, PriorityNormal if none
+func PriorityFrom(ctx context.Context) Priority {
+	p, ok := ctx.Value(priorityKey{}).(Priority)
+	if !ok {
+		return PriorityNormal
+	}
+	return min(max(p, PriorityLow), PriorityHigh)
+}
+
+// priorityAdmission keeps connection slots free for higher priorities: with
+// a reserve of r, high priority calls may fill the connection limit, normal
+// ones all but r slots and low ones all but 2r. The check is made before
+// taking a slot, so under contention the bounds are approximate.
+type priorityAdmission struct {
+	limiter *ConnectionLimiter
+	reserve int64
+	wait    time.Duration // how long a call waits for room before it is shed
+
+	mu       sync.Mutex
+	released chan struct{} // closed on every release to wake waiters
+
+	shed [3]atomic.Int64 // by priority, low first
+}
+
+func newPriorityAdmission(config *GateConfig, limiter *ConnectionLimiter) *priorityAdmission {
+	if config == nil || config.PriorityReserve <= 0 {
+		return nil
+	}
+	return &priorityAdmission{
+		limiter:  limiter,
+		reserve:  config.PriorityReserve,
+		wait:     config.PriorityQueueTimeout,
+		released: make(chan struct{}),
+	}
+}
+
+// admit waits until the connection limiter has room for the priority of
+// ctx, or sheds the call
+func (pa *priorityAdmission) admit(ctx context.Context) error {
+	if pa == nil {
+		return nil
+	}
+	p := PriorityFrom(ctx)
+	limit := pa.limiter.Limit() - pa.reserve*int64(PriorityHigh-p)
+
+	var timer *time.Timer
+	for {
+		pa.mu.Lock()
+		released := pa.released
+		pa.mu.Unlock()
+
+		if pa.limiter.CurrentConnections() < limit {
+			return nil
+		}
+		if pa.wait <= 0 {
+			pa.shed[p-PriorityLow].Add(1)
+			return ErrLoadShed
+		}
+		if timer == nil {
+			timer = time.NewTimer(pa.wait)
+			defer timer.Stop()
+		}
+
+		select {
+		case <-released:
+		case <-timer.C:
+			pa.shed[p-PriorityLow].Add(1)
+			return ErrLoadShed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notify wakes the calls waiting for a slot
+func (pa *priorityAdmission) notify() {
+	if pa == nil {
+		return
+	}
+	pa.mu.Lock()
+	close(pa.released)
+	pa.released = make(chan struct{})
+	pa.mu.Unlock()
+}
+
+// ShedCounts returns the calls shed by priority admission, by priority name
+func (cg *ConnectionGate) ShedCounts() map[string]int64 {
+	counts := make(map[string]int64, 3)
+	if cg.priority == nil {
+		return counts
+	}
+	for p := PriorityLow; p <= PriorityHigh; p++ {
+		counts[p.String()] = cg.priority.shed[p-PriorityLow].Load()
+	}
+	return counts
+}
+
+// ShedCounts returns the calls shed by priority admission, by priority
+// ("low", "normal", "high")
+func (r *DBRuntime) ShedCounts() map[string]int64 {
+	return r.gate.ShedCounts()
+}
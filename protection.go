@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+)
+
+// ProtectionSettings returns the current DDoS protection limits
+func (s *TCPServer) ProtectionSettings() ProtectionSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.protection
+}
+
+// UpdateProtection changes DDoS protection limits without restarting the
+// server. Live connections are kept: a lower connection limit only rejects
+// new connections, and a lower rate limit shrinks existing token buckets on
+// their next request. It returns the settings in effect after the update.
+func (s *TCPServer) UpdateProtection(update ProtectionUpdate) (ProtectionSettings, error) {
+	if err := update.validate(); err != nil {
+		return ProtectionSettings{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := &s.protection
+	if update.MaxConnectionsPerIP != nil {
+		p.MaxConnectionsPerIP = *update.MaxConnectionsPerIP
+	}
+	if update.RateLimitPerIP != nil {
+		p.RateLimitPerIP = *update.RateLimitPerIP
+	}
+	if update.RateLimitBurstPerIP != nil {
+		p.RateLimitBurstPerIP = *update.RateLimitBurstPerIP
+	}
+	if update.RateLimitBanThreshold != nil {
+		p.RateLimitBanThreshold = *update.RateLimitBanThreshold
+		if p.RateLimitBanThreshold == 0 {
+			s.ipViolations = make(map[string]*rateLimitViolations)
+		}
+	}
+	if update.RateLimitBanDuration != nil {
+		p.RateLimitBanDuration = *update.RateLimitBanDuration
+	}
+	return *p, nil
+}
+
+// validate rejects negative limits
+func (u *ProtectionUpdate) validate() error {
+	if u.MaxConnectionsPerIP != nil && *u.MaxConnectionsPerIP < 0 {
+		return fmt.Errorf("max_connections_per_ip must not be negative")
+	}
+	if u.RateLimitPerIP != nil && *u.RateLimitPerIP < 0 {
+		return fmt.Errorf("rate_limit_per_ip must not be negative")
+	}
+	if u.RateLimitBurstPerIP != nil && *u.RateLimitBurstPerIP < 0 {
+		return fmt.Errorf("rate_limit_burst_per_ip must not be negative")
+	}
+	if u.RateLimitBanThreshold != nil && *u.RateLimitBanThreshold < 0 {
+		return fmt.Errorf("rate_limit_ban_threshold must not be negative")
+	}
+	if u.RateLimitBanDuration != nil && *u.RateLimitBanDuration < 0 {
+		return fmt.Errorf("rate_limit_ban_duration_ns must not be negative")
+	}
+	return nil
+}
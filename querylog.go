@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// QueryLogMode selects which statements the query logger records
+type QueryLogMode string
+
+const (
+	// QueryLogOff disables query logging
+	QueryLogOff QueryLogMode = "off"
+	// QueryLogAll records every statement
+	QueryLogAll QueryLogMode = "all"
+	// QueryLogSlow records statements slower than the slow query threshold
+	QueryLogSlow QueryLogMode = "slow"
+	// QueryLogErrors records statements that failed
+	QueryLogErrors QueryLogMode = "errors"
+)
+
+// RedactionPolicy decides how a statement argument appears in the query
+// log. It returns the text to log in place of the value.
+type RedactionPolicy func(arg interface{}) string
+
+// RedactAll masks every argument, keeping only its type. This is the
+// default policy.
+func RedactAll(arg interface{}) string {
+	if named, ok := arg.(sql.NamedArg); ok {
+		return ":" + named.Name + "=" + RedactAll(named.Value)
+	}
+	if arg == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("<%T>", arg)
+}
+
+// RedactStrings masks text and binary arguments but shows numbers, booleans
+// and times, which are usually keys and flags that help debugging
+func RedactStrings(arg interface{}) string {
+	value := arg
+	prefix := ""
+	if named, ok := arg.(sql.NamedArg); ok {
+		value, prefix = named.Value, ":"+named.Name+"="
+	}
+	switch v := value.(type) {
+	case string, []byte:
+		return prefix + RedactAll(v)
+	case time.Time:
+		return prefix + v.Format(time.RFC3339Nano)
+	}
+	return prefix + formatLogArg(value)
+}
+
+// RedactNone logs arguments unchanged. Use it only where the logged data is
+// not sensitive.
+func RedactNone(arg interface{}) string {
+	if named, ok := arg.(sql.NamedArg); ok {
+		return ":" + named.Name + "=" + formatLogArg(named.Value)
+	}
+	return formatLogArg(arg)
+}
+
+// RedactNamed masks named arguments (sql.Named, e.g. from BindNamed on
+// Oracle) whose names are listed, and applies fallback to all others
+func RedactNamed(fallback RedactionPolicy, names ...string) RedactionPolicy {
+	masked := make(map[string]bool, len(names))
+	for _, n := range names {
+		masked[strings.ToLower(n)] = true
+	}
+	return func(arg interface{}) string {
+		if named, ok := arg.(sql.NamedArg); ok && masked[strings.ToLower(named.Name)] {
+			return RedactAll(arg)
+		}
+		return fallback(arg)
+	}
+}
+
+func formatLogArg(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(v)
+}
+
+// QueryLogEntry is a logged statement
+type QueryLogEntry struct {
+	Query    string
+	Args     []string // redacted
+	Duration time.Duration
+	Err      error
+	Slow     bool
+	Tags     map[string]string // see WithQueryTags
+}
+
+// String formats the entry as a single log line
+func (e QueryLogEntry) String() string {
+	var b strings.Builder
+	switch {
+	case e.Err != nil:
+		b.WriteString("Query failed")
+	case e.Slow:
+		b.WriteString("Slow query")
+	default:
+		b.WriteString("Query")
+	}
+	fmt.Fprintf(&b, " (%v)", e.Duration.Round(time.Microsecond))
+	if len(e.Tags) > 0 {
+		fmt.Fprintf(&b, " {%s}", formatQueryTags(e.Tags))
+	}
+	fmt.Fprintf(&b, ": %s", e.Query)
+	if len(e.Args) > 0 {
+		fmt.Fprintf(&b, " [%s]", strings.Join(e.Args, ", "))
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+// QueryLogConfig configures the query logger
+type QueryLogConfig struct {
+	// Mode selects the statements to record (default off)
+	Mode QueryLogMode
+	// SlowThreshold marks slow statements (default: the runtime's
+	// SlowQueryThreshold)
+	SlowThreshold time.Duration
+	// Redact formats arguments (default RedactAll)
+	Redact RedactionPolicy
+	// Output receives the entries (default: log.Printf)
+	Output func(QueryLogEntry)
+}
+
+// QueryLogger records statements through query hooks, with their arguments
+// passed through a redaction policy
+type QueryLogger struct {
+	config QueryLogConfig
+}
+
+// NewQueryLogger creates a query logger. Install it with
+// runtime.UseQueryHooks(logger.Hooks()).
+func NewQueryLogger(config QueryLogConfig) *QueryLogger {
+	if config.Mode == "" {
+		config.Mode = QueryLogOff
+	}
+	if config.SlowThreshold <= 0 {
+		config.SlowThreshold = time.Second
+	}
+	if config.Redact == nil {
+		config.Redact = RedactAll
+	}
+	if config.Output == nil {
+		config.Output = func(e QueryLogEntry) { log.Print(e.String()) }
+	}
+	return &QueryLogger{config: config}
+}
+
+type queryLogArgsKey struct{}
+
+// Hooks returns the hooks that feed the logger
+func (l *QueryLogger) Hooks() QueryHooks {
+	return QueryHooks{
+		// Arguments are only visible before the statement runs, so they
+		// are carried to the After hook in the context
+		Before: func(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+			if l.config.Mode == QueryLogOff || len(args) == 0 {
+				return nil, nil
+			}
+			return context.WithValue(ctx, queryLogArgsKey{}, args), nil
+		},
+		After: func(ctx context.Context, query string, duration time.Duration, err error) {
+			l.record(ctx, query, duration, err)
+		},
+	}
+}
+
+// record logs a finished statement if the mode selects it
+func (l *QueryLogger) record(ctx context.Context, query string, duration time.Duration, err error) {
+	slow := duration > l.config.SlowThreshold
+	switch l.config.Mode {
+	case QueryLogAll:
+	case QueryLogSlow:
+		if !slow {
+			return
+		}
+	case QueryLogErrors:
+		if err == nil {
+			return
+		}
+	default:
+		return
+	}
+
+	entry := QueryLogEntry{Query: query, Duration: duration, Err: err, Slow: slow, Tags: QueryTags(ctx)}
+	if args, _ := ctx.Value(queryLogArgsKey{}).([]interface{}); len(args) > 0 {
+		entry.Args = make([]string, len(args))
+		for i, arg := range args {
+			entry.Args[i] = l.config.Redact(arg)
+		}
+	}
+	l.config.Output(entry)
+}
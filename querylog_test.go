@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryLogging(t *testing.T) {
+	var entries []QueryLogEntry
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:querylog?mode=memory&cache=shared").
+		WithQueryLogging(QueryLogConfig{
+			Mode:   QueryLogAll,
+			Redact: RedactStrings,
+			Output: func(e QueryLogEntry) { entries = append(entries, e) },
+		}).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "INSERT INTO users (id, email) VALUES (?, ?)", 7, "jane@example.com"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "INSERT INTO missing (id) VALUES (?)", 1); err == nil {
+		t.Fatal("Expected the insert into a missing table to fail")
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 log entries, got %d", len(entries))
+	}
+	line := entries[1].String()
+	if strings.Contains(line, "jane@example.com") {
+		t.Errorf("Expected the email to be redacted, got %q", line)
+	}
+	if !strings.Contains(line, "[7, <string>]") {
+		t.Errorf("Expected the id to be logged and the email masked, got %q", line)
+	}
+	if entries[2].Err == nil || !strings.HasPrefix(entries[2].String(), "Query failed") {
+		t.Errorf("Expected the failed statement to be logged as a failure, got %q", entries[2].String())
+	}
+}
+
+func TestQueryLogger_Modes(t *testing.T) {
+	var entries []QueryLogEntry
+	output := func(e QueryLogEntry) { entries = append(entries, e) }
+	ctx := context.Background()
+	fail := errors.New("boom")
+
+	run := func(mode QueryLogMode) int {
+		entries = nil
+		hooks := NewQueryLogger(QueryLogConfig{Mode: mode, SlowThreshold: 10 * time.Millisecond, Output: output}).Hooks()
+		for _, c := range []struct {
+			d   time.Duration
+			err error
+		}{{time.Millisecond, nil}, {50 * time.Millisecond, nil}, {time.Millisecond, fail}} {
+			next, _ := hooks.Before(ctx, "SELECT ?", []interface{}{"secret"})
+			hooks.After(next, "SELECT ?", c.d, c.err)
+		}
+		return len(entries)
+	}
+
+	if got := run(QueryLogAll); got != 3 {
+		t.Errorf("all: expected 3 entries, got %d", got)
+	}
+	if got := run(QueryLogSlow); got != 1 || !entries[0].Slow {
+		t.Errorf("slow: expected the slow statement only, got %d", got)
+	}
+	if got := run(QueryLogErrors); got != 1 || entries[0].Err != fail {
+		t.Errorf("errors: expected the failed statement only, got %d", got)
+	}
+	if got := run(QueryLogOff); got != 0 {
+		t.Errorf("off: expected no entries, got %d", got)
+	}
+
+	// The default policy masks every argument
+	run(QueryLogAll)
+	if args := entries[0].Args; len(args) != 1 || args[0] != "<string>" {
+		t.Errorf("Expected the argument to be masked, got %v", args)
+	}
+
+	policy := RedactNamed(RedactNone, "password")
+	if got := policy(sql.Named("password", "hunter2")); got != ":password=<string>" {
+		t.Errorf("Expected the named argument to be masked, got %q", got)
+	}
+	if got := policy(sql.Named("user", "jane")); got != `:user="jane"` {
+		t.Errorf("Expected the named argument to be shown, got %q", got)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxQueryFingerprints bounds the statements tracked by DBMetrics; when
+	// full, the least-called statement makes room for a new one
+	maxQueryFingerprints = 1000
+	// queryLatencySamples is the number of recent latencies kept per
+	// statement for percentiles
+	queryLatencySamples = 512
+)
+
+// QueryStats are the metrics of one statement fingerprint
+type QueryStats struct {
+	Fingerprint string        `json:"fingerprint"`
+	Calls       int64         `json:"calls"`
+	Errors      int64         `json:"errors"`
+	ErrorRate   float64       `json:"error_rate"` // percent
+	TotalTime   time.Duration `json:"total_time_ns"`
+	MeanTime    time.Duration `json:"mean_time_ns"`
+	P50         time.Duration `json:"p50_ns"`
+	P95         time.Duration `json:"p95_ns"`
+	P99         time.Duration `json:"p99_ns"`
+	MaxTime     time.Duration `json:"max_time_ns"`
+	LastSeen    time.Time     `json:"last_seen"`
+}
+
+// queryStat accumulates the metrics of a fingerprint
+type queryStat struct {
+	calls, errors int64
+	total, max    time.Duration
+	samples       []time.Duration // ring of recent latencies
+	next          int
+	lastSeen      time.Time
+}
+
+// RecordStatement records a statement execution under its fingerprint
+func (m *DBMetrics) RecordStatement(query string, duration time.Duration, err error) {
+	fp := QueryFingerprint(query)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.queries == nil {
+		m.queries = make(map[string]*queryStat)
+	}
+	st, ok := m.queries[fp]
+	if !ok {
+		if len(m.queries) >= maxQueryFingerprints {
+			m.evictQueryStat()
+		}
+		st = &queryStat{}
+		m.queries[fp] = st
+	}
+
+	st.calls++
+	if err != nil {
+		st.errors++
+	}
+	st.total += duration
+	if duration > st.max {
+		st.max = duration
+	}
+	if len(st.samples) < queryLatencySamples {
+		st.samples = append(st.samples, duration)
+	} else {
+		st.samples[st.next] = duration
+		st.next = (st.next + 1) % queryLatencySamples
+	}
+	st.lastSeen = time.Now()
+}
+
+// evictQueryStat drops the least-called fingerprint. The caller holds m.mu.
+func (m *DBMetrics) evictQueryStat() {
+	var victim string
+	var fewest int64 = -1
+	for fp, st := range m.queries {
+		if fewest < 0 || st.calls < fewest {
+			victim, fewest = fp, st.calls
+		}
+	}
+	delete(m.queries, victim)
+}
+
+// TopQueries returns the n statements with the highest total execution time,
+// which are the ones costing the database most (n <= 0 returns all)
+func (m *DBMetrics) TopQueries(n int) []QueryStats {
+	m.mu.RLock()
+	stats := make([]QueryStats, 0, len(m.queries))
+	for fp, st := range m.queries {
+		stats = append(stats, st.snapshot(fp))
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].TotalTime != stats[j].TotalTime {
+			return stats[i].TotalTime > stats[j].TotalTime
+		}
+		return stats[i].Fingerprint < stats[j].Fingerprint
+	})
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+func (st *queryStat) snapshot(fp string) QueryStats {
+	s := QueryStats{
+		Fingerprint: fp,
+		Calls:       st.calls,
+		Errors:      st.errors,
+		TotalTime:   st.total,
+		MaxTime:     st.max,
+		LastSeen:    st.lastSeen,
+	}
+	if st.calls > 0 {
+		s.ErrorRate = float64(st.errors) / float64(st.calls) * 100
+		s.MeanTime = st.total / time.Duration(st.calls)
+	}
+
+	sorted := make([]time.Duration, len(st.samples))
+	copy(sorted, st.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	s.P50 = percentile(sorted, 50)
+	s.P95 = percentile(sorted, 95)
+	s.P99 = percentile(sorted, 99)
+	return s
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+var (
+	fingerprintInList = regexp.MustCompile(`\(\?(,\?)+\)`)
+	fingerprintValues = regexp.MustCompile(`(\([?,]+\))(,\([?,]+\))+`)
+)
+
+// QueryFingerprint normalizes a statement so executions that differ only in
+// their values share metrics: literals and placeholders become ?, lists of
+// values collapse to one, comments are removed, unquoted words are
+// lower-cased and whitespace is dropped except between words.
+//
+//	SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'x'
+//	select*from t where id in(?)and name=?
+func QueryFingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	last := byte(0) // last byte written
+	emit := func(s string, word bool) {
+		if word && isFingerprintWord(last) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+		last = s[len(s)-1]
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'':
+			i = skipQuoted(query, i)
+			emit("?", true)
+		case c == '"' || c == '`':
+			end := skipQuoted(query, i)
+			emit(query[i:end], true)
+			i = end
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			emit("::", false)
+			i += 2
+		case (c == ':' || c == '$' || c == '@') && i+1 < len(query) && isNamePart(query[i+1]):
+			i++
+			for i < len(query) && isNamePart(query[i]) {
+				i++
+			}
+			emit("?", true)
+		case c == '?':
+			emit("?", true)
+			i++
+		case c >= '0' && c <= '9':
+			for i < len(query) && (isNamePart(query[i]) || query[i] == '.') {
+				i++
+			}
+			emit("?", true)
+		case isNameStart(c):
+			start := i
+			for i < len(query) && (isNamePart(query[i]) || query[i] == '$' || query[i] == '#') {
+				i++
+			}
+			emit(strings.ToLower(query[start:i]), true)
+		default:
+			emit(string(c), false)
+			i++
+		}
+	}
+
+	fp := fingerprintInList.ReplaceAllString(b.String(), "(?)")
+	return fingerprintValues.ReplaceAllString(fp, "$1")
+}
+
+// isFingerprintWord reports whether a written byte ends a word, so the next
+// word needs a separating space
+func isFingerprintWord(c byte) bool {
+	return isNamePart(c) || c == '?' || c == '"' || c == '`'
+}
+
+// queryStatsHooks feeds DBMetrics.RecordStatement
+func (m *DBMetrics) queryStatsHooks() QueryHooks {
+	return QueryHooks{
+		After: func(ctx context.Context, query string, duration time.Duration, err error) {
+			m.RecordStatement(query, duration, err)
+		},
+	}
+}
+
+// TopQueries returns the statements with the highest total execution time
+// (see DBMetrics.TopQueries)
+func (r *DBRuntime) TopQueries(n int) []QueryStats {
+	if !r.IsConnected() {
+		return nil
+	}
+	return r.advancedDB.Metrics().TopQueries(n)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTCPClient_TopQueries(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:top_queries?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	server := NewTCPServer(&TCPServerConfig{Address: "localhost:0", Runtime: runtime})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{Address: server.GetAddress(), Timeout: 5 * time.Second})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+	defer client.Disconnect()
+
+	if _, err := client.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := client.Exec("INSERT INTO items (id) VALUES (?)", i); err != nil {
+			t.Fatalf("Exec failed: %v", err)
+		}
+	}
+	if _, err := client.Query("SELECT * FROM missing"); err == nil {
+		t.Fatal("Expected the query of a missing table to fail")
+	}
+
+	stats, err := client.TopQueries(0)
+	if err != nil {
+		t.Fatalf("TopQueries failed: %v", err)
+	}
+	byFingerprint := make(map[string]QueryStats)
+	for _, s := range stats {
+		byFingerprint[s.Fingerprint] = s
+	}
+	if s := byFingerprint["insert into items(id)values(?)"]; s.Calls != 5 || s.Errors != 0 {
+		t.Errorf("Expected 5 successful inserts, got %+v", s)
+	}
+	if s := byFingerprint["select*from missing"]; s.Calls != 1 || s.ErrorRate != 100 {
+		t.Errorf("Expected 1 failed query, got %+v", s)
+	}
+
+	stats, err = client.TopQueries(1)
+	if err != nil || len(stats) != 1 {
+		t.Errorf("Expected a single statement, got %d (%v)", len(stats), err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// reloadDrainPoll is how often Reload checks whether the old pool is idle
+const reloadDrainPoll = 10 * time.Millisecond
+
+// Reload moves the runtime to the connection settings of newConfig without
+// downtime, for credential rotation or failover. It opens and warms a pool
+// for newConfig, swaps it in for new operations, then waits for the
+// operations still running on the old pool (up to
+// newConfig.ReloadDrainTimeout, default 30s) before closing it.
+//
+// The connection settings are applied (DSN, pool sizes and lifetimes,
+// validation, warmup and timeouts) along with the gate limits set in
+// newConfig (see UpdateGateConfig); zero gate limits are left unchanged.
+// The database type cannot change. If the new pool cannot be opened or a
+// gate limit is invalid, the runtime keeps its current pool and limits.
+func (r *DBRuntime) Reload(newConfig *RuntimeConfig) error {
+	if !r.IsConnected() {
+		return fmt.Errorf("database not connected")
+	}
+	if newConfig == nil {
+		return fmt.Errorf("reload config is nil")
+	}
+
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	connConfig := connectionConfig(newConfig)
+	if connConfig.DatabaseType == "" {
+		connConfig.DatabaseType = r.databaseType()
+	}
+	if connConfig.DatabaseType != r.databaseType() {
+		return fmt.Errorf("reload cannot change the database type from %s to %s", r.databaseType(), connConfig.DatabaseType)
+	}
+	setConnectionDefaults(connConfig)
+	update := gateUpdate(newConfig)
+	if err := r.gate.validateUpdate(update); err != nil {
+		return fmt.Errorf("invalid gate config: %w", err)
+	}
+
+	db, err := openPool(connConfig)
+	if err != nil {
+		return fmt.Errorf("failed to open new connection pool: %w", err)
+	}
+	warmPool(db, connConfig)
+
+	old := r.connManager.swap(db, connConfig)
+	r.advancedDB.swapDB(db)
+	if _, err := r.UpdateGateConfig(update); err != nil {
+		log.Printf("Failed to update gate config on reload: %v", err)
+	}
+
+	timeout := newConfig.ReloadDrainTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	drainPool(old, timeout)
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("failed to close old connection pool: %w", err)
+	}
+	return nil
+}
+
+// warmPool opens WarmupConnections connections on db (MaxIdleConns if
+// unset), holding them all at once so each one is a new connection
+func warmPool(db *sql.DB, config *AdvancedConfig) {
+	n := config.WarmupConnections
+	if n <= 0 || n > config.MaxIdleConns {
+		n = config.MaxIdleConns
+	}
+	timeout := config.WarmupTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conns := make([]*sql.Conn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			break
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// drainPool waits until no connection of db is in use or timeout passes
+func drainPool(db *sql.DB, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for db.Stats().InUse > 0 && time.Now().Before(deadline) {
+		time.Sleep(reloadDrainPoll)
+	}
+}
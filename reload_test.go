@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	config := NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:reload_old?mode=memory&cache=shared").
+		Build()
+	runtime := NewDBRuntime(config)
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+	ctx := context.Background()
+
+	if _, err := runtime.Exec(ctx, "CREATE TABLE old_only (id INTEGER)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if err := runtime.Reload(&RuntimeConfig{DatabaseType: DatabaseTypePostgreSQL}); err == nil {
+		t.Error("Expected Reload to reject a database type change")
+	}
+
+	// A transaction on the old pool holds the reload until it ends
+	tx, err := runtime.Begin(ctx, nil)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tx.Commit()
+	}()
+
+	newConfig := NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:reload_new?mode=memory&cache=shared").
+		Build()
+	newConfig.ReloadDrainTimeout = 5 * time.Second
+
+	// Gate limits are validated before anything changes
+	newConfig.MaxConcurrentConnections = 1000
+	if err := runtime.Reload(newConfig); err == nil {
+		t.Error("Expected Reload to reject a concurrency cap above the startup one")
+	}
+	newConfig.MaxConcurrentConnections = 50
+	newConfig.MaxRequestsPerSecond = 500
+
+	// Connections acquired during the reload come from either pool
+	reloaded := make(chan struct{})
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		for {
+			select {
+			case <-reloaded:
+				return
+			default:
+			}
+			if conn, err := runtime.connManager.AcquireConnection(ctx); err == nil {
+				runtime.connManager.ReleaseConnection(conn)
+			}
+		}
+	}()
+
+	start := time.Now()
+	err = runtime.Reload(newConfig)
+	close(reloaded)
+	<-acquired
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected Reload to wait for the transaction, returned after %v", elapsed)
+	}
+
+	if _, err := runtime.Query(ctx, "SELECT id FROM old_only"); err == nil {
+		t.Error("Expected the old database to be gone after Reload")
+	}
+	if _, err := runtime.Exec(ctx, "CREATE TABLE new_only (id INTEGER)"); err != nil {
+		t.Errorf("Exec on the new pool failed: %v", err)
+	}
+	if s := runtime.GateSettings(); s.MaxConcurrentConnections != 50 || s.MaxRequestsPerSecond != 500 || s.RateLimitBurst != 5000 {
+		t.Errorf("Expected Reload to apply the gate limits, got %+v", s)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadRoutingPolicy selects the replica that serves a read
+type ReadRoutingPolicy string
+
+const (
+	// RouteRoundRobin spreads reads evenly over healthy replicas
+	RouteRoundRobin ReadRoutingPolicy = "round_robin"
+	// RouteLeastLoaded sends reads to the replica whose connection pool is
+	// least utilized
+	RouteLeastLoaded ReadRoutingPolicy = "least_loaded"
+	// RouteStickyAfterWrite sends reads to the primary for StickyWindow after
+	// a write, so callers read their own writes despite replication lag, and
+	// round-robins them otherwise
+	RouteStickyAfterWrite ReadRoutingPolicy = "sticky_after_write"
+)
+
+const (
+	defaultStickyWindow        = 2 * time.Second
+	defaultReplicaCheckEvery   = 5 * time.Second
+	defaultReplicaCheckTimeout = 2 * time.Second
+	maxStickySessions          = 10000
+)
+
+// ReplicaConfig configures read/write splitting. The runtimes are created
+// and connected by the caller.
+type ReplicaConfig struct {
+	Primary             *DBRuntime        // Receives writes, transactions and reads when no replica is usable
+	Replicas            []*DBRuntime      // Read replicas
+	Policy              ReadRoutingPolicy // Default RouteRoundRobin
+	StickyWindow        time.Duration     // Reads stay on the primary this long after a write (default 2s)
+	HealthCheckInterval time.Duration     // How often replicas are checked (default 5s)
+	HealthCheckTimeout  time.Duration     // Timeout per check (default 2s)
+}
+
+// ReplicaStatus reports the state of one replica
+type ReplicaStatus struct {
+	Index     int       `json:"index"`
+	Healthy   bool      `json:"healthy"`
+	Reads     int64     `json:"reads"`
+	LastError string    `json:"last_error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// replica is a read replica and its health
+type replica struct {
+	runtime   *DBRuntime
+	healthy   atomic.Bool
+	reads     atomic.Int64
+	mu        sync.Mutex
+	lastError string
+	checkedAt time.Time
+}
+
+// ReplicatedRuntime routes reads to replicas and everything else to the
+// primary. Reads are SELECT, WITH (without data-modifying statements), SHOW
+// and EXPLAIN queries; locking reads (FOR UPDATE/FOR SHARE) go to the
+// primary. Use ForcePrimary to read from the primary explicitly.
+type ReplicatedRuntime struct {
+	config   ReplicaConfig
+	primary  *DBRuntime
+	replicas []*replica
+	next     atomic.Uint64
+
+	// Last write per session for RouteStickyAfterWrite; "" is the runtime
+	// wide session used when the context carries none
+	stickyMu   sync.Mutex
+	lastWrites map[string]time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type routingContextKey int
+
+const (
+	forcePrimaryKey routingContextKey = iota
+	routingSessionKey
+)
+
+// ForcePrimary returns a context whose reads are served by the primary
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey, true)
+}
+
+// WithRoutingSession scopes RouteStickyAfterWrite to a session (e.g. a user
+// or request ID): only that session's reads move to the primary after it
+// writes. Without a session every write makes all reads sticky.
+func WithRoutingSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, routingSessionKey, session)
+}
+
+// NewReplicatedRuntime creates a replica-aware runtime and starts the
+// replica health checks. Close stops them.
+func NewReplicatedRuntime(config ReplicaConfig) (*ReplicatedRuntime, error) {
+	if config.Primary == nil {
+		return nil, fmt.Errorf("primary runtime is required")
+	}
+	if config.Policy == "" {
+		config.Policy = RouteRoundRobin
+	}
+	switch config.Policy {
+	case RouteRoundRobin, RouteLeastLoaded, RouteStickyAfterWrite:
+	default:
+		return nil, fmt.Errorf("unknown read routing policy: %s", config.Policy)
+	}
+	if config.StickyWindow <= 0 {
+		config.StickyWindow = defaultStickyWindow
+	}
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = defaultReplicaCheckEvery
+	}
+	if config.HealthCheckTimeout <= 0 {
+		config.HealthCheckTimeout = defaultReplicaCheckTimeout
+	}
+
+	rr := &ReplicatedRuntime{
+		config:     config,
+		primary:    config.Primary,
+		lastWrites: make(map[string]time.Time),
+		stop:       make(chan struct{}),
+	}
+	for _, r := range config.Replicas {
+		rep := &replica{runtime: r}
+		rep.healthy.Store(r.IsConnected())
+		rr.replicas = append(rr.replicas, rep)
+	}
+
+	if len(rr.replicas) > 0 {
+		rr.wg.Add(1)
+		go rr.healthLoop()
+	}
+	return rr, nil
+}
+
+// Close stops the replica health checks. The runtimes stay connected.
+func (rr *ReplicatedRuntime) Close() {
+	select {
+	case <-rr.stop:
+	default:
+		close(rr.stop)
+	}
+	rr.wg.Wait()
+}
+
+// Primary returns the primary runtime
+func (rr *ReplicatedRuntime) Primary() *DBRuntime {
+	return rr.primary
+}
+
+// Exec executes a statement on the primary
+func (rr *ReplicatedRuntime) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	rr.recordWrite(ctx)
+	return rr.primary.Exec(ctx, query, args...)
+}
+
+// Query executes a query on a replica when it is a read, otherwise on the
+// primary
+func (rr *ReplicatedRuntime) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return rr.route(ctx, query).Query(ctx, query, args...)
+}
+
+// QueryRow executes a query that returns at most one row, routed like Query
+func (rr *ReplicatedRuntime) QueryRow(ctx context.Context, query string, args ...interface{}) *Row {
+	return rr.route(ctx, query).QueryRow(ctx, query, args...)
+}
+
+// QueryCached executes a query routed like Query and caches the rows.
+// Results are cached in the primary's cache whichever runtime served them,
+// so replicas share entries.
+func (rr *ReplicatedRuntime) QueryCached(ctx context.Context, key string, ttl time.Duration, query string, args ...interface{}) ([]string, [][]interface{}, bool, error) {
+	target := rr.route(ctx, query)
+	if target == rr.primary {
+		return rr.primary.QueryCached(ctx, key, ttl, query, args...)
+	}
+
+	key = rr.primary.namespacedKey(ctx, key)
+	rr.primary.trackCacheDependencies(key, query)
+	cached := rr.primary.Cache() != nil && key != ""
+	load := func(ctx context.Context) (QueryResult, error) {
+		columns, results, err := target.queryAll(ctx, query, args...)
+		qr := QueryResult{Columns: columns, Rows: results}
+		if cached {
+			rr.primary.storeCachedQuery(ctx, key, qr, err, ttl)
+		}
+		if err != nil {
+			return QueryResult{}, err
+		}
+		return qr, nil
+	}
+
+	if cached {
+		if qr, stale, ok, err := rr.primary.cachedQuery(ctx, key); ok {
+			if err != nil {
+				return nil, nil, true, err
+			}
+			if stale {
+				rr.primary.revalidate(ctx, key, load)
+			}
+			return qr.Columns, qr.Rows, true, nil
+		}
+	}
+	qr, err := load(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return qr.Columns, qr.Rows, false, nil
+}
+
+// Begin starts a transaction on the primary
+func (rr *ReplicatedRuntime) Begin(ctx context.Context, opts *sql.TxOptions) (*AdvancedTx, error) {
+	if opts == nil || !opts.ReadOnly {
+		rr.recordWrite(ctx)
+	}
+	return rr.primary.Begin(ctx, opts)
+}
+
+// ReplicaStatus returns the state of every replica
+func (rr *ReplicatedRuntime) ReplicaStatus() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, len(rr.replicas))
+	for i, rep := range rr.replicas {
+		rep.mu.Lock()
+		statuses[i] = ReplicaStatus{
+			Index:     i,
+			Healthy:   rep.healthy.Load(),
+			Reads:     rep.reads.Load(),
+			LastError: rep.lastError,
+			CheckedAt: rep.checkedAt,
+		}
+		rep.mu.Unlock()
+	}
+	return statuses
+}
+
+// route picks the runtime for a query
+func (rr *ReplicatedRuntime) route(ctx context.Context, query string) *DBRuntime {
+	if !isReadQuery(query) {
+		rr.recordWrite(ctx)
+		return rr.primary
+	}
+	if force, _ := ctx.Value(forcePrimaryKey).(bool); force {
+		return rr.primary
+	}
+	if rr.config.Policy == RouteStickyAfterWrite && rr.recentlyWrote(ctx) {
+		return rr.primary
+	}
+
+	rep := rr.pickReplica()
+	if rep == nil {
+		return rr.primary
+	}
+	rep.reads.Add(1)
+	return rep.runtime
+}
+
+// pickReplica returns a usable replica according to the policy, or nil
+func (rr *ReplicatedRuntime) pickReplica() *replica {
+	n := len(rr.replicas)
+	if n == 0 {
+		return nil
+	}
+
+	start := int(rr.next.Add(1) % uint64(n))
+	var best *replica
+	bestLoad := 0.0
+	for i := 0; i < n; i++ {
+		rep := rr.replicas[(start+i)%n]
+		if !rep.healthy.Load() || rep.runtime.CircuitBreakerState() == CircuitStateOpen {
+			continue
+		}
+		if rr.config.Policy != RouteLeastLoaded {
+			return rep
+		}
+		if load := poolLoad(rep.runtime.Stats()); best == nil || load < bestLoad {
+			best, bestLoad = rep, load
+		}
+	}
+	return best
+}
+
+// poolLoad returns the share of a pool's connections in use
+func poolLoad(stats sql.DBStats) float64 {
+	if stats.MaxOpenConnections > 0 {
+		return float64(stats.InUse) / float64(stats.MaxOpenConnections)
+	}
+	return float64(stats.InUse)
+}
+
+// recordWrite notes a write for RouteStickyAfterWrite
+func (rr *ReplicatedRuntime) recordWrite(ctx context.Context) {
+	if rr.config.Policy != RouteStickyAfterWrite {
+		return
+	}
+	session, _ := ctx.Value(routingSessionKey).(string)
+	now := time.Now()
+
+	rr.stickyMu.Lock()
+	defer rr.stickyMu.Unlock()
+	if len(rr.lastWrites) >= maxStickySessions {
+		for s, at := range rr.lastWrites {
+			if now.Sub(at) > rr.config.StickyWindow {
+				delete(rr.lastWrites, s)
+			}
+		}
+	}
+	rr.lastWrites[session] = now
+}
+
+// recentlyWrote reports whether the context's session wrote within the
+// sticky window
+func (rr *ReplicatedRuntime) recentlyWrote(ctx context.Context) bool {
+	session, _ := ctx.Value(routingSessionKey).(string)
+
+	rr.stickyMu.Lock()
+	defer rr.stickyMu.Unlock()
+	at, ok := rr.lastWrites[session]
+	return ok && time.Since(at) < rr.config.StickyWindow
+}
+
+// healthLoop periodically checks every replica
+func (rr *ReplicatedRuntime) healthLoop() {
+	defer rr.wg.Done()
+
+	ticker := time.NewTicker(rr.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rr.checkReplicas()
+		case <-rr.stop:
+			return
+		}
+	}
+}
+
+// checkReplicas runs a health check against every replica
+func (rr *ReplicatedRuntime) checkReplicas() {
+	for i, rep := range rr.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), rr.config.HealthCheckTimeout)
+		err := rep.runtime.HealthCheck(ctx)
+		cancel()
+
+		rep.mu.Lock()
+		rep.checkedAt = time.Now()
+		if err != nil {
+			rep.lastError = err.Error()
+		} else {
+			rep.lastError = ""
+		}
+		rep.mu.Unlock()
+
+		healthy := err == nil
+		if rep.healthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Printf("Replica %d is healthy again", i)
+			} else {
+				log.Printf("Replica %d failed its health check: %v", i, err)
+			}
+		}
+	}
+}
+
+var (
+	leadingNoise  = regexp.MustCompile(`^(\s+|--[^\n]*\n?|(?s:/\*.*?\*/)|\()+`)
+	lockingRead   = regexp.MustCompile(`(?i)\bFOR\s+(UPDATE|SHARE|NO\s+KEY\s+UPDATE|KEY\s+SHARE)\b|\bLOCK\s+IN\s+SHARE\s+MODE\b`)
+	modifyingStmt = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE)\b`)
+)
+
+// isReadQuery reports whether a query only reads and may run on a replica
+func isReadQuery(query string) bool {
+	q := leadingNoise.ReplaceAllString(query, "")
+	switch statementKeyword(q) {
+	case "SELECT":
+		return !lockingRead.MatchString(q)
+	case "WITH", "EXPLAIN":
+		// Data-modifying CTEs and EXPLAIN ANALYZE of a write change data
+		return !lockingRead.MatchString(q) && !modifyingStmt.MatchString(q)
+	case "SHOW", "DESCRIBE":
+		return true
+	}
+	return false
+}
+
+// statementKeyword returns the upper-cased first word of a statement whose
+// leading comments and whitespace have been removed
+func statementKeyword(q string) string {
+	end := strings.IndexFunc(q, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if end < 0 {
+		end = len(q)
+	}
+	return strings.ToUpper(q[:end])
+}
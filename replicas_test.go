@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplicatedRuntime_Routing(t *testing.T) {
+	ctx := context.Background()
+	connect := func(name string) *DBRuntime {
+		runtime := NewDBRuntime(NewConfigBuilder().
+			WithDatabaseType(DatabaseTypeSQLite).
+			WithDSN("file:" + name + "?mode=memory&cache=shared").
+			Build())
+		if err := runtime.Connect(); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		for _, stmt := range []string{"CREATE TABLE node (name TEXT)", "INSERT INTO node VALUES ('" + name + "')"} {
+			if _, err := runtime.Exec(ctx, stmt); err != nil {
+				t.Fatalf("Failed to set up %s: %v", name, err)
+			}
+		}
+		return runtime
+	}
+	primary := connect("rw_primary")
+	defer primary.Disconnect()
+	replicaA := connect("rw_replica_a")
+	defer replicaA.Disconnect()
+	replicaB := connect("rw_replica_b")
+
+	rr, err := NewReplicatedRuntime(ReplicaConfig{
+		Primary:      primary,
+		Replicas:     []*DBRuntime{replicaA, replicaB},
+		Policy:       RouteStickyAfterWrite,
+		StickyWindow: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewReplicatedRuntime failed: %v", err)
+	}
+	defer rr.Close()
+
+	node := func(ctx context.Context) string {
+		rows, err := rr.Query(ctx, "SELECT name FROM node LIMIT 1")
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		defer rows.Close()
+		var name string
+		if rows.Next() {
+			rows.Scan(&name)
+		}
+		return name
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		seen[node(ctx)] = true
+	}
+	if !seen["rw_replica_a"] || !seen["rw_replica_b"] || seen["rw_primary"] {
+		t.Errorf("Expected reads spread over both replicas, got %v", seen)
+	}
+	if got := node(ForcePrimary(ctx)); got != "rw_primary" {
+		t.Errorf("ForcePrimary read from %s", got)
+	}
+
+	// A session that writes reads from the primary; other sessions do not
+	alice := WithRoutingSession(ctx, "alice")
+	if _, err := rr.Exec(alice, "INSERT INTO node VALUES ('written')"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if got := node(alice); got != "rw_primary" {
+		t.Errorf("Expected alice to read her writes from the primary, got %s", got)
+	}
+	if got := node(WithRoutingSession(ctx, "bob")); got == "rw_primary" {
+		t.Error("Expected bob to keep reading from replicas")
+	}
+
+	// Unhealthy replicas are skipped
+	replicaB.Disconnect()
+	rr.checkReplicas()
+	for i := 0; i < 3; i++ {
+		if got := node(ctx); got != "rw_replica_a" {
+			t.Errorf("Expected only the healthy replica, got %s", got)
+		}
+	}
+	if status := rr.ReplicaStatus(); status[1].Healthy || status[1].LastError == "" || !status[0].Healthy {
+		t.Errorf("Unexpected replica status: %+v", status)
+	}
+}
+
+func TestIsReadQuery(t *testing.T) {
+	tests := map[string]bool{
+		"SELECT * FROM t":                                true,
+		"  /* report */ select 1":                        true,
+		"-- comment\nSELECT 1":                           true,
+		"(SELECT 1) UNION (SELECT 2)":                    true,
+		"WITH x AS (SELECT 1) SELECT * FROM x":           true,
+		"SHOW TABLES":                                    true,
+		"EXPLAIN SELECT 1":                               true,
+		"SELECT * FROM t FOR UPDATE":                     false,
+		"SELECT * FROM t LOCK IN SHARE MODE":             false,
+		"WITH d AS (DELETE FROM t RETURNING *) SELECT 1": false,
+		"EXPLAIN ANALYZE DELETE FROM t":                  false,
+		"INSERT INTO t VALUES (1) RETURNING id":          false,
+		"UPDATE t SET a = 1":                             false,
+		"":                                               false,
+	}
+	for query, want := range tests {
+		if got := isReadQuery(query); got != want {
+			t.Errorf("isReadQuery(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// columnNamePattern restricts returning column names, which are
+// interpolated into SQL
+var columnNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$#]*$`)
+
+// ExecReturning executes a statement that writes a single row (usually an
+// INSERT) and scans the values of the returning columns, such as generated
+// keys, into dest. The statement is written without a RETURNING clause; the
+// clause is added for the runtime's database type:
+//
+//	PostgreSQL/SQLite: RETURNING col, ... read back like a query
+//	Oracle:            RETURNING col, ... INTO :ret1, ... bound as sql.Out
+//	MySQL:             no clause; the single column receives LastInsertId
+//
+// On MySQL only one returning column is supported, and it must be the
+// AUTO_INCREMENT column.
+func (r *DBRuntime) ExecReturning(ctx context.Context, query string, returning []string, dest []interface{}, args ...interface{}) error {
+	if !r.IsConnected() {
+		return fmt.Errorf("database not connected")
+	}
+	if len(returning) == 0 {
+		return fmt.Errorf("at least one returning column is required")
+	}
+	if len(dest) != len(returning) {
+		return fmt.Errorf("%d returning columns but %d destinations", len(returning), len(dest))
+	}
+	for _, column := range returning {
+		if !columnNamePattern.MatchString(column) {
+			return fmt.Errorf("invalid returning column: %q", column)
+		}
+	}
+
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	columns := strings.Join(returning, ", ")
+
+	switch r.databaseType() {
+	case DatabaseTypeMySQL:
+		if len(returning) > 1 {
+			return fmt.Errorf("MySQL can only return the AUTO_INCREMENT column, not %d columns", len(returning))
+		}
+		result, err := r.Exec(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to read generated key: %w", err)
+		}
+		return assignValue(dest[0], id)
+
+	case DatabaseTypeOracle:
+		// Out binds follow the statement's own binds, by name if the
+		// statement uses named binds and by position otherwise
+		isNamed := false
+		for _, arg := range args {
+			if _, ok := arg.(sql.NamedArg); ok {
+				isNamed = true
+				break
+			}
+		}
+		binds := make([]string, len(returning))
+		bound := append(make([]interface{}, 0, len(args)+len(dest)), args...)
+		for i, d := range dest {
+			name := "ret" + strconv.Itoa(i+1)
+			binds[i] = ":" + name
+			if isNamed {
+				bound = append(bound, sql.Named(name, sql.Out{Dest: d}))
+			} else {
+				bound = append(bound, sql.Out{Dest: d})
+			}
+		}
+		_, err := r.Exec(ctx, query+" RETURNING "+columns+" INTO "+strings.Join(binds, ", "), bound...)
+		return err
+
+	default:
+		return r.QueryRow(ctx, query+" RETURNING "+columns, args...).Scan(dest...)
+	}
+}
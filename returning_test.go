@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestExecReturning(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:exec_returning?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE orders (id INTEGER PRIMARY KEY, ref TEXT, created TEXT DEFAULT 'now')"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	var id int64
+	var created string
+	for i := 1; i <= 2; i++ {
+		err := runtime.ExecReturning(ctx, "INSERT INTO orders (ref) VALUES (?);",
+			[]string{"id", "created"}, []interface{}{&id, &created}, fmt.Sprintf("ref-%d", i))
+		if err != nil {
+			t.Fatalf("ExecReturning failed: %v", err)
+		}
+		if id != int64(i) || created != "now" {
+			t.Errorf("Expected id %d and created now, got %d and %q", i, id, created)
+		}
+	}
+
+	if err := runtime.ExecReturning(ctx, "INSERT INTO orders (ref) VALUES (?)", []string{"id"}, nil, "x"); err == nil {
+		t.Error("Expected mismatched destinations to fail")
+	}
+	if err := runtime.ExecReturning(ctx, "INSERT INTO orders (ref) VALUES (?)", []string{"id; DROP TABLE orders"}, []interface{}{&id}, "x"); err == nil {
+		t.Error("Expected an invalid column name to fail")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShadowConfig configures shadow traffic mirroring. A share of the read
+// queries served by a runtime is replayed asynchronously against a
+// secondary runtime, e.g. a new database being validated before cutover.
+// Shadow results never reach the caller.
+type ShadowConfig struct {
+	Runtime     *DBRuntime     // Secondary runtime receiving mirrored reads
+	Percentage  float64        // Share of read queries mirrored (0-100)
+	Compare     bool           // Compare shadow results with the primary (QueryCached misses only)
+	Parity      *ParityOptions // Comparison options (tolerances, key columns)
+	MaxInFlight int            // Mirrored queries running at once (default 10); excess are dropped
+	Timeout     time.Duration  // Timeout per mirrored query (default 30s)
+}
+
+// ShadowStats reports shadow mirroring counters. Latencies are averages over
+// mirrored queries, so primary and shadow are measured on the same traffic.
+type ShadowStats struct {
+	Mirrored              int64         `json:"mirrored"`
+	Dropped               int64         `json:"dropped"` // skipped because MaxInFlight was reached
+	PrimaryErrors         int64         `json:"primary_errors"`
+	ShadowErrors          int64         `json:"shadow_errors"`
+	ErrorMismatches       int64         `json:"error_mismatches"` // only one side failed
+	Compared              int64         `json:"compared"`
+	ResultMismatches      int64         `json:"result_mismatches"`
+	AveragePrimaryLatency time.Duration `json:"average_primary_latency_ns"`
+	AverageShadowLatency  time.Duration `json:"average_shadow_latency_ns"`
+}
+
+// shadowMirror dispatches mirrored queries and collects ShadowStats
+type shadowMirror struct {
+	config   ShadowConfig
+	inflight chan struct{}
+	wg       sync.WaitGroup
+
+	mirrored         atomic.Int64
+	dropped          atomic.Int64
+	primaryErrors    atomic.Int64
+	shadowErrors     atomic.Int64
+	errorMismatches  atomic.Int64
+	compared         atomic.Int64
+	resultMismatches atomic.Int64
+	primaryNanos     atomic.Int64
+	shadowNanos      atomic.Int64
+	completed        atomic.Int64
+}
+
+func newShadowMirror(config ShadowConfig) *shadowMirror {
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = 10
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	return &shadowMirror{
+		config:   config,
+		inflight: make(chan struct{}, config.MaxInFlight),
+	}
+}
+
+// sample decides whether a query is mirrored
+func (sm *shadowMirror) sample() bool {
+	p := sm.config.Percentage
+	return p >= 100 || (p > 0 && rand.Float64()*100 < p)
+}
+
+// mirror replays a query against the shadow runtime. primary holds the
+// primary's materialized result when available and enables comparison.
+func (sm *shadowMirror) mirror(query string, args []interface{}, primaryLatency time.Duration, primaryErr error, primary *QueryResult) {
+	if !sm.sample() {
+		return
+	}
+
+	select {
+	case sm.inflight <- struct{}{}:
+	default:
+		sm.dropped.Add(1)
+		return
+	}
+
+	sm.mirrored.Add(1)
+	args = append([]interface{}(nil), args...)
+
+	sm.wg.Add(1)
+	go func() {
+		defer sm.wg.Done()
+		defer func() { <-sm.inflight }()
+		sm.run(query, args, primaryLatency, primaryErr, primary)
+	}()
+}
+
+func (sm *shadowMirror) run(query string, args []interface{}, primaryLatency time.Duration, primaryErr error, primary *QueryResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), sm.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	var columns []string
+	var rows [][]interface{}
+	rs, err := sm.config.Runtime.Query(ctx, query, args...)
+	shadowLatency := time.Since(start)
+	if err == nil {
+		// Drain the rows so the shadow does the same work as the primary
+		columns, rows, err = ScanAllRows(rs)
+		rs.Close()
+	}
+
+	sm.primaryNanos.Add(int64(primaryLatency))
+	sm.shadowNanos.Add(int64(shadowLatency))
+	sm.completed.Add(1)
+
+	if primaryErr != nil {
+		sm.primaryErrors.Add(1)
+	}
+	if err != nil {
+		sm.shadowErrors.Add(1)
+	}
+	if (primaryErr == nil) != (err == nil) {
+		sm.errorMismatches.Add(1)
+		log.Printf("Shadow error mismatch for %q: primary=%v shadow=%v", query, primaryErr, err)
+		return
+	}
+
+	if !sm.config.Compare || primary == nil || err != nil {
+		return
+	}
+
+	report, err := CompareResults(query, primary.Columns, primary.Rows, columns, rows, sm.config.Parity)
+	if err != nil {
+		log.Printf("Shadow comparison for %q failed: %v", query, err)
+		return
+	}
+	sm.compared.Add(1)
+	if !report.Equal() {
+		sm.resultMismatches.Add(1)
+		log.Printf("Shadow result mismatch: %s", report)
+	}
+}
+
+// wait blocks until in-flight mirrored queries have finished
+func (sm *shadowMirror) wait() {
+	sm.wg.Wait()
+}
+
+// stats returns a snapshot of the counters
+func (sm *shadowMirror) stats() ShadowStats {
+	stats := ShadowStats{
+		Mirrored:         sm.mirrored.Load(),
+		Dropped:          sm.dropped.Load(),
+		PrimaryErrors:    sm.primaryErrors.Load(),
+		ShadowErrors:     sm.shadowErrors.Load(),
+		ErrorMismatches:  sm.errorMismatches.Load(),
+		Compared:         sm.compared.Load(),
+		ResultMismatches: sm.resultMismatches.Load(),
+	}
+	if n := sm.completed.Load(); n > 0 {
+		stats.AveragePrimaryLatency = time.Duration(sm.primaryNanos.Load() / n)
+		stats.AverageShadowLatency = time.Duration(sm.shadowNanos.Load() / n)
+	}
+	return stats
+}
+
+// ShadowStats returns shadow mirroring statistics. It returns zero stats
+// when mirroring is not configured.
+func (r *DBRuntime) ShadowStats() ShadowStats {
+	if r.shadow == nil {
+		return ShadowStats{}
+	}
+	return r.shadow.stats()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShadowMirroring(t *testing.T) {
+	ctx := context.Background()
+	connect := func(config *RuntimeConfig) *DBRuntime {
+		runtime := NewDBRuntime(config)
+		if err := runtime.Connect(); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return runtime
+	}
+
+	shadow := connect(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:shadow_b?mode=memory&cache=shared").
+		Build())
+	defer shadow.Disconnect()
+
+	primary := connect(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:shadow_a?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		WithShadow(shadow, 100, true).
+		Build())
+
+	for _, r := range []*DBRuntime{primary, shadow} {
+		if _, err := r.Exec(ctx, "CREATE TABLE items (id INTEGER, name TEXT)"); err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+	}
+	primary.Exec(ctx, "INSERT INTO items VALUES (1, 'a')")
+	shadow.Exec(ctx, "INSERT INTO items VALUES (1, 'b')")
+
+	if _, _, _, err := primary.QueryCached(ctx, "items", time.Minute, "SELECT id, name FROM items"); err != nil {
+		t.Fatalf("QueryCached failed: %v", err)
+	}
+	rows, err := primary.Query(ctx, "SELECT id FROM items")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	rows.Close()
+	primary.Disconnect()
+
+	stats := primary.ShadowStats()
+	if stats.Mirrored != 2 || stats.Compared != 1 || stats.ResultMismatches != 1 {
+		t.Errorf("Unexpected shadow stats: %+v", stats)
+	}
+	if stats.ShadowErrors != 0 || stats.AverageShadowLatency <= 0 {
+		t.Errorf("Unexpected shadow errors or latency: %+v", stats)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	slowQueryLogSize     = 100
+	slowQueryExplainTTL  = time.Minute
+	slowQueryExplainWait = 5 * time.Second
+)
+
+// SlowQueryRecord is a statement that exceeded the slow query threshold.
+// Plan holds its execution plan when slow query EXPLAIN is enabled; it is
+// filled in the background, so it may be empty for a moment after the
+// statement finished.
+type SlowQueryRecord struct {
+	Query      string        `json:"query"`
+	Duration   time.Duration `json:"duration"`
+	At         time.Time     `json:"at"`
+	Err        string        `json:"error,omitempty"`
+	Plan       string        `json:"plan,omitempty"`
+	PlanError  string        `json:"plan_error,omitempty"`
+	PlanCached bool          `json:"plan_cached,omitempty"` // reused from an earlier run
+}
+
+// slowQueryLog keeps the most recent slow statements and explains them
+type slowQueryLog struct {
+	db        func() *sql.DB
+	gate      *ConnectionGate
+	dbType    DatabaseType
+	threshold time.Duration
+	explain   bool
+
+	mu      sync.Mutex
+	records []*SlowQueryRecord // ring, oldest overwritten first
+	next    int
+	plans   map[string]cachedPlan
+
+	explaining chan struct{} // holds a token while an EXPLAIN runs
+}
+
+type cachedPlan struct {
+	plan, err string
+	at        time.Time
+}
+
+func newSlowQueryLog(db func() *sql.DB, gate *ConnectionGate, dbType DatabaseType, threshold time.Duration, explain bool) *slowQueryLog {
+	return &slowQueryLog{
+		db:         db,
+		gate:       gate,
+		dbType:     dbType,
+		threshold:  threshold,
+		explain:    explain,
+		plans:      make(map[string]cachedPlan),
+		explaining: make(chan struct{}, 1),
+	}
+}
+
+type slowQueryArgsKey struct{}
+
+// hooks returns the query hooks that feed the log
+func (l *slowQueryLog) hooks() QueryHooks {
+	return QueryHooks{
+		Before: func(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+			if !l.explain || len(args) == 0 {
+				return nil, nil
+			}
+			return context.WithValue(ctx, slowQueryArgsKey{}, args), nil
+		},
+		After: func(ctx context.Context, query string, duration time.Duration, err error) {
+			if duration <= l.threshold {
+				return
+			}
+			args, _ := ctx.Value(slowQueryArgsKey{}).([]interface{})
+			l.record(query, args, duration, err)
+		},
+	}
+}
+
+// record stores a slow statement and starts explaining it
+func (l *slowQueryLog) record(query string, args []interface{}, duration time.Duration, err error) {
+	rec := &SlowQueryRecord{Query: query, Duration: duration, At: time.Now()}
+	if err != nil {
+		rec.Err = err.Error()
+	}
+
+	l.mu.Lock()
+	if len(l.records) < slowQueryLogSize {
+		l.records = append(l.records, rec)
+	} else {
+		l.records[l.next] = rec
+	}
+	l.next = (l.next + 1) % slowQueryLogSize
+
+	explain := l.explain && explainable(query)
+	if cached, ok := l.plans[query]; ok && time.Since(cached.at) < slowQueryExplainTTL {
+		rec.Plan, rec.PlanError, rec.PlanCached = cached.plan, cached.err, true
+		explain = false
+	}
+	l.mu.Unlock()
+
+	if !explain {
+		return
+	}
+	// One EXPLAIN at a time, and none while the breaker is shedding load:
+	// diagnosing a slow database must not add to its load
+	if l.gate.State() == CircuitStateOpen {
+		return
+	}
+	select {
+	case l.explaining <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-l.explaining }()
+		plan, err := l.runExplain(query, args)
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		rec.Plan = plan
+		if err != nil {
+			rec.PlanError = err.Error()
+			log.Printf("EXPLAIN of slow query failed: %v", err)
+		}
+		l.plans[query] = cachedPlan{plan: rec.Plan, err: rec.PlanError, at: time.Now()}
+	}()
+}
+
+// explainable reports whether a statement has a plan. EXPLAIN without
+// ANALYZE does not execute the statement, so writes can be explained too.
+func explainable(query string) bool {
+	switch statementKeyword(leadingNoise.ReplaceAllString(query, "")) {
+	case "SELECT", "WITH", "INSERT", "UPDATE", "DELETE", "MERGE":
+		return true
+	}
+	return false
+}
+
+// runExplain fetches the execution plan of a statement
+func (l *slowQueryLog) runExplain(query string, args []interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), slowQueryExplainWait)
+	defer cancel()
+
+	// Oracle writes the plan to PLAN_TABLE and reads it back, which must
+	// happen on one session
+	conn, err := l.db().Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var rows *sql.Rows
+	switch l.dbType {
+	case DatabaseTypeOracle:
+		if _, err := conn.ExecContext(ctx, "EXPLAIN PLAN FOR "+query, args...); err != nil {
+			return "", err
+		}
+		rows, err = conn.QueryContext(ctx, "SELECT PLAN_TABLE_OUTPUT FROM TABLE(DBMS_XPLAN.DISPLAY())")
+	case DatabaseTypeSQLite:
+		rows, err = conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	default:
+		rows, err = conn.QueryContext(ctx, "EXPLAIN "+query, args...)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	_, results, err := ScanAllRows(rows)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(results))
+	for i, row := range results {
+		cols := make([]string, len(row))
+		for j, v := range row {
+			cols[j] = fmt.Sprint(v)
+		}
+		lines[i] = strings.Join(cols, " | ")
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// snapshot returns copies of the records, newest first
+func (l *slowQueryLog) snapshot() []SlowQueryRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]SlowQueryRecord, 0, len(l.records))
+	for i := 1; i <= len(l.records); i++ {
+		idx := (l.next - i + slowQueryLogSize) % slowQueryLogSize
+		if idx < len(l.records) {
+			out = append(out, *l.records[idx])
+		}
+	}
+	return out
+}
+
+// SlowQueries returns the most recent slow statements, newest first
+func (adb *AdvancedDB) SlowQueries() []SlowQueryRecord {
+	return adb.slowLog.snapshot()
+}
+
+// SlowQueries returns the most recent slow statements, newest first
+func (r *DBRuntime) SlowQueries() []SlowQueryRecord {
+	if !r.IsConnected() {
+		return nil
+	}
+	return r.advancedDB.SlowQueries()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowQueryExplain(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:slowlog?mode=memory&cache=shared").
+		WithQuerySettings(200, time.Nanosecond, 30*time.Second).
+		WithSlowQueryExplain(true).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE orders (id INTEGER PRIMARY KEY, customer INTEGER)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	rows, err := runtime.Query(ctx, "SELECT id FROM orders WHERE customer = ?", 42)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	rows.Close()
+
+	var records []SlowQueryRecord
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		records = runtime.SlowQueries()
+		if len(records) == 2 && (records[0].Plan != "" || records[0].PlanError != "") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 slow queries, got %d", len(records))
+	}
+	if records[0].Query != "SELECT id FROM orders WHERE customer = ?" {
+		t.Errorf("Expected the newest record first, got %q", records[0].Query)
+	}
+	if records[0].PlanError != "" || !strings.Contains(records[0].Plan, "SCAN") {
+		t.Errorf("Expected a query plan, got %q (error %q)", records[0].Plan, records[0].PlanError)
+	}
+	if records[1].Plan != "" {
+		t.Errorf("Expected DDL not to be explained, got %q", records[1].Plan)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// snapshotChunkSize is the largest chunk served per SNAPSHOT message. It
+	// keeps the base64-encoded response below the default 1MB message limit.
+	snapshotChunkSize = 512 * 1024
+	// snapshotTTL is how long an exported snapshot stays available to peers
+	snapshotTTL = 10 * time.Minute
+	// snapshotReuseAge is the age under which a snapshot is served to new
+	// downloads instead of exporting another
+	snapshotReuseAge = time.Minute
+	// maxSnapshots caps the snapshots kept at once
+	maxSnapshots = 4
+)
+
+// ExportSnapshot writes a consistent copy of the database to path, which
+// must not exist or be empty. Only SQLite (including in-memory) is supported.
+func (r *DBRuntime) ExportSnapshot(ctx context.Context, path string) error {
+	if r.config.DatabaseType != DatabaseTypeSQLite {
+		return fmt.Errorf("snapshots are only supported for SQLite, not %s", r.config.DatabaseType)
+	}
+	if _, err := r.Exec(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces the contents of the database with the snapshot
+// at path. In-memory databases must use a shared-cache DSN (for example
+// "file:app?mode=memory&cache=shared") so every pooled connection sees the
+// restored data.
+func (r *DBRuntime) RestoreSnapshot(ctx context.Context, path string) error {
+	if r.config.DatabaseType != DatabaseTypeSQLite {
+		return fmt.Errorf("snapshots are only supported for SQLite, not %s", r.config.DatabaseType)
+	}
+	if !r.IsConnected() {
+		return fmt.Errorf("database not connected")
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer src.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer srcConn.Close()
+
+	dstConn, err := r.DB().Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer dstConn.Close()
+
+	err = dstConn.Raw(func(dst interface{}) error {
+		return srcConn.Raw(func(src interface{}) error {
+			dstSQLite, ok1 := dst.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := src.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return fmt.Errorf("unexpected driver connection type")
+			}
+
+			backup, err := dstSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	// Cached results describe the old dataset
+	if c, ok := r.cache.(interface{ Clear() }); ok {
+		c.Clear()
+	}
+	return nil
+}
+
+// BootstrapFromPeer downloads the current snapshot from a peer server and
+// restores it into the runtime, so a new node does not need to re-sync from
+// the source database
+func BootstrapFromPeer(ctx context.Context, r *DBRuntime, client *TCPClient, token string) error {
+	f, err := os.CreateTemp("", "fluxor-bootstrap-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	if err := client.FetchSnapshot(token, path); err != nil {
+		return err
+	}
+	return r.RestoreSnapshot(ctx, path)
+}
+
+// exportedSnapshot is a snapshot file served to peers
+type exportedSnapshot struct {
+	path      string
+	size      int64
+	checksum  string
+	createdAt time.Time
+}
+
+// handleSnapshot handles a snapshot message. A request without a snapshot
+// ID starts a download of the latest snapshot, exporting a new one when it
+// is older than snapshotReuseAge; later requests read it chunk by chunk.
+// Snapshots copy the whole database, so the admin token is always required.
+func (s *TCPServer) handleSnapshot(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	if !s.config.EnableSnapshotExport {
+		return NewErrorResponse(msg.ID, fmt.Errorf("snapshot export is disabled"))
+	}
+	if s.config.AdminToken == "" {
+		return NewErrorResponse(msg.ID, fmt.Errorf("snapshot export requires an admin token"))
+	}
+
+	var req SnapshotRequest
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return NewErrorResponse(msg.ID, fmt.Errorf("invalid snapshot payload: %w", err))
+		}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(s.config.AdminToken)) != 1 {
+		log.Printf("Rejected snapshot request from %s: invalid token", msg.ClientIP)
+		return NewErrorResponse(msg.ID, fmt.Errorf("admin authorization failed"))
+	}
+
+	if req.SnapshotID == "" {
+		id, err := s.latestSnapshot(ctx)
+		if err != nil {
+			return NewErrorResponse(msg.ID, err)
+		}
+		req.SnapshotID = id
+	}
+
+	s.snapshotMu.Lock()
+	snap, ok := s.snapshots[req.SnapshotID]
+	s.snapshotMu.Unlock()
+	if !ok {
+		return NewErrorResponse(msg.ID, fmt.Errorf("unknown or expired snapshot: %s", req.SnapshotID))
+	}
+
+	if req.Offset < 0 || req.Offset > snap.size {
+		return NewErrorResponse(msg.ID, fmt.Errorf("invalid snapshot offset: %d", req.Offset))
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > snapshotChunkSize {
+		limit = snapshotChunkSize
+	}
+
+	f, err := os.Open(snap.path)
+	if err != nil {
+		return NewErrorResponse(msg.ID, fmt.Errorf("failed to read snapshot: %w", err))
+	}
+	defer f.Close()
+
+	data := make([]byte, limit)
+	n, err := f.ReadAt(data, req.Offset)
+	if err != nil && err != io.EOF {
+		return NewErrorResponse(msg.ID, fmt.Errorf("failed to read snapshot: %w", err))
+	}
+
+	return s.successResponse(msg.ID, SnapshotChunk{
+		SnapshotID: req.SnapshotID,
+		Size:       snap.size,
+		Checksum:   snap.checksum,
+		Offset:     req.Offset,
+		Data:       data[:n],
+	})
+}
+
+// latestSnapshot returns the newest snapshot under snapshotReuseAge, or
+// exports a new one. Exports run one at a time, so concurrent downloads
+// share a snapshot.
+func (s *TCPServer) latestSnapshot(ctx context.Context) (string, error) {
+	s.snapshotExportMu.Lock()
+	defer s.snapshotExportMu.Unlock()
+
+	s.snapshotMu.Lock()
+	var latestID string
+	var latest *exportedSnapshot
+	for id, snap := range s.snapshots {
+		if latest == nil || snap.createdAt.After(latest.createdAt) {
+			latestID, latest = id, snap
+		}
+	}
+	n := len(s.snapshots)
+	s.snapshotMu.Unlock()
+
+	if latest != nil && time.Since(latest.createdAt) < snapshotReuseAge {
+		return latestID, nil
+	}
+	if n >= maxSnapshots {
+		return "", fmt.Errorf("too many snapshots in progress, retry after %s", snapshotReuseAge)
+	}
+	return s.createSnapshot(ctx)
+}
+
+// createSnapshot exports the runtime database to a temporary file
+func (s *TCPServer) createSnapshot(ctx context.Context) (string, error) {
+	f, err := os.CreateTemp("", "fluxor-snapshot-*.db")
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	path := f.Name()
+	f.Close()
+
+	if err := s.runtime.ExportSnapshot(ctx, path); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var idBytes [8]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to generate snapshot id: %w", err)
+	}
+	id := hex.EncodeToString(idBytes[:])
+
+	s.snapshotMu.Lock()
+	s.snapshots[id] = &exportedSnapshot{
+		path:      path,
+		size:      size,
+		checksum:  hex.EncodeToString(h.Sum(nil)),
+		createdAt: time.Now(),
+	}
+	s.snapshotMu.Unlock()
+
+	log.Printf("Exported snapshot %s (%d bytes)", id, size)
+	return id, nil
+}
+
+// cleanupSnapshots removes snapshots older than snapshotTTL, or all
+// snapshots when all is set
+func (s *TCPServer) cleanupSnapshots(now time.Time, all bool) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	for id, snap := range s.snapshots {
+		if all || now.Sub(snap.createdAt) > snapshotTTL {
+			os.Remove(snap.path)
+			delete(s.snapshots, id)
+		}
+	}
+}
+
+// FetchSnapshot downloads the peer's current snapshot to path and verifies
+// its checksum
+func (c *TCPClient) FetchSnapshot(token, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	req := SnapshotRequest{Token: token}
+	for {
+		chunk, err := c.snapshotChunk(&req)
+		if err != nil {
+			return err
+		}
+		if chunk.Offset != req.Offset {
+			return fmt.Errorf("snapshot chunk at offset %d, expected %d", chunk.Offset, req.Offset)
+		}
+		if _, err := f.Write(chunk.Data); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+		h.Write(chunk.Data)
+
+		req.SnapshotID = chunk.SnapshotID
+		req.Offset += int64(len(chunk.Data))
+		if req.Offset >= chunk.Size {
+			if sum := hex.EncodeToString(h.Sum(nil)); sum != chunk.Checksum {
+				return fmt.Errorf("snapshot checksum mismatch: got %s, expected %s", sum, chunk.Checksum)
+			}
+			return f.Sync()
+		}
+		if len(chunk.Data) == 0 {
+			return fmt.Errorf("snapshot ended at %d of %d bytes", req.Offset, chunk.Size)
+		}
+	}
+}
+
+// snapshotChunk requests a single snapshot chunk
+func (c *TCPClient) snapshotChunk(req *SnapshotRequest) (*SnapshotChunk, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot request: %w", err)
+	}
+
+	msg := &TCPMessage{
+		Type:    MessageTypeSnapshot,
+		ID:      c.nextID(),
+		Payload: payload,
+	}
+
+	resp, err := c.sendAndReceive(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, ResponseError("snapshot", resp)
+	}
+
+	var chunk SnapshotChunk
+	if err := json.Unmarshal(resp.Data, &chunk); err != nil {
+		return nil, err
+	}
+	return &chunk, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnapshotBootstrapFromPeer(t *testing.T) {
+	ctx := context.Background()
+	connect := func(dsn string) *DBRuntime {
+		runtime := NewDBRuntime(NewConfigBuilder().
+			WithDatabaseType(DatabaseTypeSQLite).
+			WithDSN(dsn).
+			WithInMemoryMode(true).
+			Build())
+		if err := runtime.Connect(); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return runtime
+	}
+
+	peer := connect("file:snapshot_peer?mode=memory&cache=shared")
+	defer peer.Disconnect()
+	if _, err := peer.Exec(ctx, "CREATE TABLE accounts (id INTEGER, name TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		peer.Exec(ctx, "INSERT INTO accounts VALUES (?, ?)", i, fmt.Sprintf("account-%d", i))
+	}
+
+	server := NewTCPServer(&TCPServerConfig{
+		Address:              "localhost:0",
+		Runtime:              peer,
+		AdminToken:           "secret",
+		EnableSnapshotExport: true,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{Address: server.GetAddress(), Timeout: 5 * time.Second})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+	defer client.Disconnect()
+
+	node := connect("file:snapshot_node?mode=memory&cache=shared")
+	defer node.Disconnect()
+
+	if err := BootstrapFromPeer(ctx, node, client, "wrong"); err == nil {
+		t.Error("Expected bootstrap with an invalid token to fail")
+	}
+	if err := BootstrapFromPeer(ctx, node, client, "secret"); err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+
+	_, rows, _, err := node.QueryCached(ctx, "", 0, "SELECT COUNT(*) FROM accounts")
+	if err != nil {
+		t.Fatalf("Query on bootstrapped node failed: %v", err)
+	}
+	if fmt.Sprint(rows[0][0]) != "100" {
+		t.Errorf("Expected 100 rows, got %v", rows[0][0])
+	}
+
+	// Downloads share a recent snapshot, and no more than maxSnapshots are kept
+	if err := BootstrapFromPeer(ctx, node, client, "secret"); err != nil {
+		t.Fatalf("Second bootstrap failed: %v", err)
+	}
+	server.snapshotMu.Lock()
+	n := len(server.snapshots)
+	for _, snap := range server.snapshots {
+		snap.createdAt = snap.createdAt.Add(-snapshotReuseAge)
+	}
+	server.snapshotMu.Unlock()
+	if n != 1 {
+		t.Errorf("Expected one snapshot shared by both downloads, got %d", n)
+	}
+	for i := 1; i < maxSnapshots; i++ {
+		if _, err := server.latestSnapshot(ctx); err != nil {
+			t.Fatalf("Snapshot %d failed: %v", i+1, err)
+		}
+		server.snapshotMu.Lock()
+		for _, snap := range server.snapshots {
+			snap.createdAt = snap.createdAt.Add(-snapshotReuseAge)
+		}
+		server.snapshotMu.Unlock()
+	}
+	if err := BootstrapFromPeer(ctx, node, client, "secret"); err == nil {
+		t.Error("Expected a snapshot beyond maxSnapshots to be refused")
+	}
+
+	// Without an admin token nobody may export the database
+	unprotected := NewTCPServer(&TCPServerConfig{
+		Address:              "localhost:0",
+		Runtime:              peer,
+		EnableSnapshotExport: true,
+	})
+	if err := unprotected.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer unprotected.Stop()
+	openClient := NewTCPClient(&TCPClientConfig{Address: unprotected.GetAddress(), Timeout: 5 * time.Second})
+	if err := openClient.Connect(); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+	defer openClient.Disconnect()
+	if err := BootstrapFromPeer(ctx, node, openClient, ""); err == nil {
+		t.Error("Expected snapshots to be refused without an admin token")
+	}
+	if len(unprotected.snapshots) != 0 {
+		t.Errorf("Expected no snapshot exported, got %d", len(unprotected.snapshots))
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultStreamBuffer = 1000
+
+// RowStream is a pull-based iterator over a large result set. A background
+// reader fetches rows into a bounded buffer and waits while it is full, so
+// at most the buffer size is held in memory however fast the database is.
+// The stream holds a connection gate slot until it is closed.
+//
+//	stream, err := runtime.QueryStream(ctx, 500, "SELECT id, payload FROM events")
+//	if err != nil { ... }
+//	defer stream.Close()
+//	for stream.Next() {
+//	    var id int64
+//	    var payload string
+//	    if err := stream.Scan(&id, &payload); err != nil { ... }
+//	}
+//	if err := stream.Err(); err != nil { ... }
+type RowStream struct {
+	columns []string
+	buf     chan []interface{}
+	current []interface{}
+	cancel  context.CancelFunc
+	err     error // set by the reader before buf is closed
+
+	adb       *AdvancedDB
+	gateCtx   context.Context // the context the gate slot was taken with
+	start     time.Time
+	closeOnce sync.Once
+	closed    bool
+	finished  bool // Next has seen the end of the buffer
+}
+
+// QueryStream executes a query and streams its rows, buffering at most
+// bufferSize rows (default 1000). Opening the query is retried like Query;
+// the stream is bounded by ctx rather than the query timeout, since reading
+// millions of rows can take much longer. Close must be called.
+func (adb *AdvancedDB) QueryStream(ctx context.Context, bufferSize int, query string, args ...interface{}) (*RowStream, error) {
+	if bufferSize <= 0 {
+		bufferSize = defaultStreamBuffer
+	}
+
+	ctx, done, err := runQueryHooks(ctx, adb.queryHooks(), query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The gate slot is held for the life of the stream, so the gate is
+	// entered here instead of through ExecuteWithGate
+	if err := adb.gate.Allow(ctx); err != nil {
+		done(err)
+		return nil, err
+	}
+
+	start := time.Now()
+	streamCtx, cancel := context.WithCancel(ctx)
+	rows, err := adb.retryQuery(streamCtx, query, args...)
+	done(err)
+	if err != nil {
+		cancel()
+		adb.gate.RecordFailureContext(ctx)
+		adb.metrics.RecordQuery(time.Since(start), err)
+		return nil, err
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		cancel()
+		adb.gate.RecordFailureContext(ctx)
+		adb.metrics.RecordQuery(time.Since(start), err)
+		return nil, err
+	}
+
+	s := &RowStream{
+		columns: columns,
+		buf:     make(chan []interface{}, bufferSize),
+		cancel:  cancel,
+		adb:     adb,
+		gateCtx: ctx,
+		start:   start,
+	}
+	go s.read(streamCtx, rows)
+	return s, nil
+}
+
+// read fetches rows into the buffer until the result set ends, an error
+// occurs or the stream is closed
+func (s *RowStream) read(ctx context.Context, rows *sql.Rows) {
+	defer close(s.buf)
+	defer rows.Close()
+
+	for rows.Next() {
+		row, err := scanRow(rows, len(s.columns))
+		if err != nil {
+			s.err = err
+			return
+		}
+		select {
+		case s.buf <- row:
+		case <-ctx.Done():
+			return
+		}
+	}
+	s.err = rows.Err()
+}
+
+// Columns returns the column names
+func (s *RowStream) Columns() []string {
+	return s.columns
+}
+
+// Next advances to the next row, waiting for the reader if the buffer is
+// empty. It returns false at the end of the result set or on error.
+func (s *RowStream) Next() bool {
+	if s.closed {
+		return false
+	}
+	row, ok := <-s.buf
+	s.current = row
+	if !ok {
+		s.finished = true
+	}
+	return ok
+}
+
+// Values returns the current row. []byte values are returned as strings.
+func (s *RowStream) Values() []interface{} {
+	return s.current
+}
+
+// Scan copies the current row into dest, converting values the way
+// database/sql does for the common types
+func (s *RowStream) Scan(dest ...interface{}) error {
+	if s.current == nil {
+		return fmt.Errorf("scan called without a row")
+	}
+	if len(dest) != len(s.current) {
+		return fmt.Errorf("expected %d destination arguments, got %d", len(s.current), len(dest))
+	}
+	for i, d := range dest {
+		if err := assignValue(d, s.current[i]); err != nil {
+			return fmt.Errorf("scan column %d (%s): %w", i, s.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// Err returns the error that ended the stream, if any. It is valid once
+// Next has returned false.
+func (s *RowStream) Err() error {
+	if !s.finished || errors.Is(s.err, context.Canceled) {
+		return nil
+	}
+	return s.err
+}
+
+// Close stops the stream and releases its connection and gate slot. It
+// returns the error that ended the stream, if any.
+func (s *RowStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.cancel()
+		for range s.buf {
+			// Drain so the reader can exit
+		}
+		s.closed = true
+		s.current = nil
+
+		err = s.err
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		}
+		if err != nil {
+			s.adb.gate.RecordFailureContext(s.gateCtx)
+		} else {
+			s.adb.gate.RecordSuccess()
+			s.adb.gate.ReleaseContext(s.gateCtx)
+		}
+		s.adb.metrics.RecordQuery(time.Since(s.start), err)
+	})
+	return err
+}
+
+// assignValue stores a scanned value in a destination pointer
+func assignValue(dest, src interface{}) error {
+	switch d := dest.(type) {
+	case *interface{}:
+		*d = src
+		return nil
+	case sql.Scanner:
+		return d.Scan(src)
+	}
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("destination %T is not a non-nil pointer", dest)
+	}
+	dv = dv.Elem()
+
+	if src == nil {
+		switch dv.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+		return fmt.Errorf("cannot store NULL in %s", dv.Type())
+	}
+	if dv.Kind() == reflect.Ptr {
+		v := reflect.New(dv.Type().Elem())
+		if err := assignValue(v.Interface(), src); err != nil {
+			return err
+		}
+		dv.Set(v)
+		return nil
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dv.Type()) {
+		dv.Set(sv)
+		return nil
+	}
+
+	// Parse strings (and text columns returned as strings) into the
+	// destination kind, and convert between numeric kinds
+	text, isText := src.(string)
+	if !isText {
+		text = fmt.Sprint(src)
+	}
+	switch dv.Kind() {
+	case reflect.String:
+		dv.SetString(text)
+		return nil
+	case reflect.Slice:
+		if dv.Type().Elem().Kind() == reflect.Uint8 {
+			dv.SetBytes([]byte(text))
+			return nil
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(text); err == nil {
+			dv.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(text, 10, dv.Type().Bits()); err == nil {
+			dv.SetInt(n)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, err := strconv.ParseUint(text, 10, dv.Type().Bits()); err == nil {
+			dv.SetUint(n)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(text, dv.Type().Bits()); err == nil {
+			dv.SetFloat(f)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot convert %T to %s", src, dv.Type())
+}
+
+// QueryStream executes a query and streams its rows (see AdvancedDB.QueryStream)
+func (r *DBRuntime) QueryStream(ctx context.Context, bufferSize int, query string, args ...interface{}) (*RowStream, error) {
+	if !r.IsConnected() {
+		return nil, fmt.Errorf("database not connected")
+	}
+	return r.advancedDB.QueryStream(ctx, bufferSize, query, args...)
+}
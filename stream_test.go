@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestQueryStream(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:stream?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE events (id INTEGER PRIMARY KEY, payload TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for i := 1; i <= 500; i++ {
+		if _, err := runtime.Exec(ctx, "INSERT INTO events (id, payload) VALUES (?, ?)", i, fmt.Sprintf("event-%d", i)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	limiter := runtime.advancedDB.gate.connectionLimiter
+
+	stream, err := runtime.QueryStream(ctx, 10, "SELECT id, payload FROM events ORDER BY id")
+	if err != nil {
+		t.Fatalf("QueryStream failed: %v", err)
+	}
+	if got := limiter.CurrentConnections(); got != 1 {
+		t.Errorf("Expected the stream to hold one gate slot, got %d", got)
+	}
+
+	count := 0
+	for stream.Next() {
+		var id int64
+		var payload string
+		if err := stream.Scan(&id, &payload); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		count++
+		if id != int64(count) || payload != fmt.Sprintf("event-%d", count) {
+			t.Fatalf("Unexpected row %d: %d %q", count, id, payload)
+		}
+		if buffered := len(stream.buf); buffered > 10 {
+			t.Fatalf("Buffer holds %d rows, limit is 10", buffered)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if count != 500 {
+		t.Errorf("Expected 500 rows, got %d", count)
+	}
+	if got := limiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected the gate slot to be released, got %d", got)
+	}
+
+	// Closing early stops the reader and releases the slot
+	stream, err = runtime.QueryStream(ctx, 5, "SELECT id FROM events")
+	if err != nil {
+		t.Fatalf("QueryStream failed: %v", err)
+	}
+	if !stream.Next() {
+		t.Fatal("Expected a row")
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if stream.Next() {
+		t.Error("Expected Next to return false after Close")
+	}
+	if got := limiter.CurrentConnections(); got != 0 {
+		t.Errorf("Expected the gate slot to be released after an early close, got %d", got)
+	}
+}
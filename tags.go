@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// maxTagValues bounds the distinct tag values counted by DBMetrics
+const maxTagValues = 1000
+
+type queryTagsKey struct{}
+
+// WithQueryTags returns a context whose statements carry tags such as
+// service, endpoint or tenant. Tags are added to those already on ctx,
+// replacing tags of the same name. They show up in the query log and in
+// MetricsStats.QueriesByTag, and when RuntimeConfig.QueryTagComments is set
+// they are sent to the database as a leading comment:
+//
+//	/* endpoint=/orders,service=billing */ SELECT ...
+//
+// so the statement's origin is visible in the database's own session and
+// statement views.
+func WithQueryTags(ctx context.Context, tags map[string]string) context.Context {
+	merged := make(map[string]string, len(tags))
+	for k, v := range QueryTags(ctx) {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, queryTagsKey{}, merged)
+}
+
+// QueryTags returns the tags attached to ctx with WithQueryTags. The map
+// must not be modified.
+func QueryTags(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(queryTagsKey{}).(map[string]string)
+	return tags
+}
+
+// formatQueryTags formats tags as sorted name=value pairs
+func formatQueryTags(tags map[string]string) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(tags[name])
+	}
+	return b.String()
+}
+
+// commentSafe keeps tag text from ending the comment it is written in
+var commentSafe = strings.NewReplacer("*/", "* /", "/*", "/ *", "\n", " ", "\r", " ")
+
+// tagQuery prefixes query with a comment holding the tags of ctx. Tags are
+// sorted, so a statement with the same tags always has the same text.
+func tagQuery(ctx context.Context, query string) string {
+	tags := QueryTags(ctx)
+	if len(tags) == 0 {
+		return query
+	}
+	return "/* " + commentSafe.Replace(formatQueryTags(tags)) + " */ " + query
+}
+
+// taggedQuery returns query with the tags of ctx as a comment, if enabled
+func (adb *AdvancedDB) taggedQuery(ctx context.Context, query string) string {
+	if !adb.tagComments {
+		return query
+	}
+	return tagQuery(ctx, query)
+}
+
+// taggedQuery returns query with the tags of ctx as a comment, if enabled
+func (atx *AdvancedTx) taggedQuery(ctx context.Context, query string) string {
+	if !atx.tags {
+		return query
+	}
+	return tagQuery(ctx, query)
+}
+
+// recordTags counts a statement under each of its tags
+func (m *DBMetrics) recordTags(tags map[string]string) {
+	if len(tags) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tagCounts == nil {
+		m.tagCounts = make(map[string]int64)
+	}
+	for name, value := range tags {
+		tag := name + "=" + value
+		if _, ok := m.tagCounts[tag]; !ok && len(m.tagCounts) >= maxTagValues {
+			continue
+		}
+		m.tagCounts[tag]++
+	}
+}
+
+// queryTagHooks feeds DBMetrics.recordTags
+func (m *DBMetrics) queryTagHooks() QueryHooks {
+	return QueryHooks{
+		Before: func(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+			m.recordTags(QueryTags(ctx))
+			return nil, nil
+		},
+	}
+}
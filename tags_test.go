@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestQueryTags(t *testing.T) {
+	var entries []QueryLogEntry
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:query_tags?mode=memory&cache=shared").
+		WithQueryTagComments(true).
+		WithQueryLogging(QueryLogConfig{
+			Mode:   QueryLogAll,
+			Output: func(e QueryLogEntry) { entries = append(entries, e) },
+		}).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE orders (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	ctx = WithQueryTags(ctx, map[string]string{"service": "billing", "tenant": "acme"})
+	ctx = WithQueryTags(ctx, map[string]string{"endpoint": "/orders */ DROP TABLE orders; /*"})
+	if got := tagQuery(ctx, "SELECT 1"); got != "/* endpoint=/orders * / DROP TABLE orders; / *,service=billing,tenant=acme */ SELECT 1" {
+		t.Errorf("Unexpected tagged query: %q", got)
+	}
+
+	// The comment cannot be escaped by a tag value
+	if _, err := runtime.Exec(ctx, "INSERT INTO orders (id) VALUES (1)"); err != nil {
+		t.Fatalf("Exec with tags failed: %v", err)
+	}
+	var n int
+	if err := runtime.QueryRow(ctx, "SELECT COUNT(*) FROM orders").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("Expected the orders table to survive with 1 row, got %d (%v)", n, err)
+	}
+
+	last := entries[len(entries)-1]
+	if last.Tags["tenant"] != "acme" || !strings.Contains(last.String(), "service=billing") {
+		t.Errorf("Expected the log entry to carry the tags, got %q", last.String())
+	}
+	if strings.Contains(last.Query, "/*") {
+		t.Errorf("Expected the log to show the statement without the tag comment, got %q", last.Query)
+	}
+	if got := runtime.Metrics().QueriesByTag["tenant=acme"]; got != 2 {
+		t.Errorf("Expected 2 statements tagged tenant=acme, got %d", got)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoadBalancingPolicy selects which of several server addresses a client
+// connects to
+type LoadBalancingPolicy string
+
+const (
+	// LoadBalanceRoundRobin rotates through the addresses on each connect
+	LoadBalanceRoundRobin LoadBalancingPolicy = "round_robin"
+	// LoadBalanceLeastLatency prefers the address with the lowest observed
+	// round-trip time
+	LoadBalanceLeastLatency LoadBalancingPolicy = "least_latency"
+)
+
+const (
+	defaultFailoverCooldown = 10 * time.Second
+	latencySmoothing        = 0.2 // weight of the newest sample in the moving average
+)
+
+// serverEndpoint is one server address and what the client knows about it
+type serverEndpoint struct {
+	address   string
+	latency   time.Duration // moving average of round trips (0 = not measured)
+	downUntil time.Time     // the address is skipped until then after a failure
+}
+
+// addressBalancer orders server addresses for connection attempts
+type addressBalancer struct {
+	mu        sync.Mutex
+	policy    LoadBalancingPolicy
+	cooldown  time.Duration
+	endpoints []*serverEndpoint
+	next      int
+}
+
+// newAddressBalancer creates a balancer over the addresses. Round robin
+// starts at a random address so clients spread over the servers.
+func newAddressBalancer(addresses []string, policy LoadBalancingPolicy, cooldown time.Duration) *addressBalancer {
+	if policy == "" {
+		policy = LoadBalanceRoundRobin
+	}
+	if cooldown <= 0 {
+		cooldown = defaultFailoverCooldown
+	}
+
+	b := &addressBalancer{policy: policy, cooldown: cooldown}
+	for _, addr := range addresses {
+		b.endpoints = append(b.endpoints, &serverEndpoint{address: addr})
+	}
+	b.next = rand.Intn(len(b.endpoints))
+	return b
+}
+
+// candidates returns the addresses in the order they should be tried.
+// Addresses marked down come last, so they are still tried when every
+// address has failed recently.
+func (b *addressBalancer) candidates(now time.Time) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ordered := make([]*serverEndpoint, 0, len(b.endpoints))
+	for i := range b.endpoints {
+		ordered = append(ordered, b.endpoints[(b.next+i)%len(b.endpoints)])
+	}
+	b.next = (b.next + 1) % len(b.endpoints)
+
+	if b.policy == LoadBalanceLeastLatency {
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].latency < ordered[j].latency
+		})
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !now.Before(ordered[i].downUntil) && now.Before(ordered[j].downUntil)
+	})
+
+	addresses := make([]string, len(ordered))
+	for i, ep := range ordered {
+		addresses[i] = ep.address
+	}
+	return addresses
+}
+
+// unmeasured returns the healthy addresses without a latency sample
+func (b *addressBalancer) unmeasured(now time.Time) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var addresses []string
+	for _, ep := range b.endpoints {
+		if ep.latency == 0 && !now.Before(ep.downUntil) {
+			addresses = append(addresses, ep.address)
+		}
+	}
+	return addresses
+}
+
+// observe records a successful round trip to an address
+func (b *addressBalancer) observe(address string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ep := b.find(address); ep != nil {
+		if d <= 0 {
+			d = 1
+		}
+		if ep.latency == 0 {
+			ep.latency = d
+		} else {
+			ep.latency = time.Duration(latencySmoothing*float64(d) + (1-latencySmoothing)*float64(ep.latency))
+		}
+		ep.downUntil = time.Time{}
+	}
+}
+
+// markDown skips an address for the cooldown period
+func (b *addressBalancer) markDown(address string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ep := b.find(address); ep != nil {
+		ep.downUntil = now.Add(b.cooldown)
+	}
+}
+
+// find returns the endpoint for an address. The caller must hold b.mu.
+func (b *addressBalancer) find(address string) *serverEndpoint {
+	for _, ep := range b.endpoints {
+		if ep.address == address {
+			return ep
+		}
+	}
+	return nil
+}
+
+// probe dials every healthy address that has no latency sample yet and
+// records the dial time, so least-latency selection has data to work with
+func (b *addressBalancer) probe(timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, addr := range b.unmeasured(time.Now()) {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			start := time.Now()
+			conn, err := net.DialTimeout("tcp", addr, timeout)
+			if err != nil {
+				b.markDown(addr, time.Now())
+				return
+			}
+			conn.Close()
+			b.observe(addr, time.Since(start))
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// transportError is a failure of the connection itself rather than of the
+// request. The connection cannot be used afterwards.
+type transportError struct {
+	conn net.Conn
+	err  error
+}
+
+func (e *transportError) Error() string { return e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// failoverRetryable reports whether a message may be sent again to another
+// server after the connection failed. Only read-only messages are retried,
+// since a write may already have been applied by the failed server.
+func failoverRetryable(msg *TCPMessage) bool {
+	switch msg.Type {
+	case MessageTypePing, MessageTypeQuery, MessageTypeQueryRow, MessageTypeStats, MessageTypeMetrics, MessageTypeMetricsQueries:
+		return true
+	}
+	return false
+}
+
+// dialBalanced connects to the first reachable address in balancing order
+func (c *TCPClient) dialBalanced() (net.Conn, string, error) {
+	if c.balancer.policy == LoadBalanceLeastLatency {
+		c.balancer.probe(c.timeout)
+	}
+
+	var lastErr error
+	for _, addr := range c.balancer.candidates(time.Now()) {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", addr, c.timeout)
+		if err != nil {
+			c.balancer.markDown(addr, time.Now())
+			lastErr = err
+			continue
+		}
+		if c.balancer.policy == LoadBalanceLeastLatency {
+			c.balancer.observe(addr, time.Since(start))
+		}
+		return conn, addr, nil
+	}
+	return nil, "", fmt.Errorf("failed to connect to any of %d addresses: %w", len(c.balancer.endpoints), lastErr)
+}
+
+// failover replaces a failed connection with one to the next healthy
+// address. It reports whether the client is connected afterwards.
+func (c *TCPClient) failover(failed net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	// Disconnected by the caller, or another request already failed over
+	if !c.connected {
+		return false
+	}
+	if c.conn != failed {
+		return true
+	}
+
+	c.balancer.markDown(c.address, time.Now())
+	c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+	c.connected = false
+
+	conn, addr, err := c.dialBalanced()
+	if err != nil {
+		return false
+	}
+	c.setConn(conn, addr)
+	return true
+}
+
+// CurrentAddress returns the address of the server the client is connected to
+func (c *TCPClient) CurrentAddress() string {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.address
+}
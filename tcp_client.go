@@ -2,7 +2,10 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -13,17 +16,33 @@ import (
 type TCPClient struct {
 	address    string
 	conn       net.Conn
+	reader     *bufio.Reader
 	messageID  uint64
 	mu         sync.Mutex
 	timeout    time.Duration
 	connected  bool
 	connMu     sync.RWMutex
+	limits     TCPClientConfig
+	balancer   *addressBalancer // set when several addresses are configured
 }
 
 // TCPClientConfig configures the TCP client
 type TCPClientConfig struct {
-	Address string
-	Timeout time.Duration
+	Address         string
+	Timeout         time.Duration
+	MaxRequestSize  int           // larger requests are refused before sending (default 1MB)
+	MaxResponseSize int           // larger responses are discarded with an error (default 1MB)
+	WriteChunkSize  int           // requests are written in chunks of this size (default 64KB)
+	RequestTimeout  time.Duration // server-side deadline sent with EXEC and QUERY (0 = server default)
+
+	// Addresses lists several servers to connect to instead of Address. The
+	// client holds one connection at a time, picked by LoadBalancing (default
+	// round robin). When it fails the client reconnects to the next address,
+	// skips the failed one for FailoverCooldown (default 10s) and resends
+	// read-only requests (PING, QUERY, QUERY_ROW, STATS, METRICS).
+	Addresses        []string
+	LoadBalancing    LoadBalancingPolicy
+	FailoverCooldown time.Duration
 }
 
 // NewTCPClient creates a new TCP client
@@ -33,14 +52,30 @@ func NewTCPClient(config *TCPClientConfig) *TCPClient {
 		timeout = config.Timeout
 	}
 
-	return &TCPClient{
+	limits := *config
+	if limits.MaxRequestSize <= 0 {
+		limits.MaxRequestSize = DefaultMaxMessageSize
+	}
+	if limits.MaxResponseSize <= 0 {
+		limits.MaxResponseSize = DefaultMaxMessageSize
+	}
+
+	client := &TCPClient{
 		address: config.Address,
 		timeout: timeout,
+		limits:  limits,
+	}
+	if len(config.Addresses) > 0 {
+		client.address = config.Addresses[0]
+		client.balancer = newAddressBalancer(config.Addresses, config.LoadBalancing, config.FailoverCooldown)
 	}
+	return client
 }
 
 // Connect connects to the TCP server
 func (c *TCPClient) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
 
@@ -48,18 +83,38 @@ func (c *TCPClient) Connect() error {
 		return fmt.Errorf("already connected")
 	}
 
+	if c.balancer != nil {
+		conn, addr, err := c.dialBalanced()
+		if err != nil {
+			return err
+		}
+		c.setConn(conn, addr)
+		return nil
+	}
+
 	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
 	if err != nil {
 		return fmt.Errorf("failed to connect to %s: %w", c.address, err)
 	}
 
+	c.setConn(conn, c.address)
+	return nil
+}
+
+// setConn installs a new connection. The caller must hold mu and connMu.
+func (c *TCPClient) setConn(conn net.Conn, address string) {
 	c.conn = conn
+	c.reader = bufio.NewReaderSize(conn, 64*1024)
+	c.address = address
 	c.connected = true
-	return nil
 }
 
 // Disconnect disconnects from the TCP server
 func (c *TCPClient) Disconnect() error {
+	// mu is taken before connMu, as requests do, so an in-flight request
+	// finishes before the connection is closed
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
 
@@ -73,11 +128,16 @@ func (c *TCPClient) Disconnect() error {
 		ID:   c.nextID(),
 	}
 
-	c.sendMessage(msg)
+	// Best-effort close notification; the locks are already held, so write
+	// directly instead of going through sendMessage
+	if data, err := EncodeTCPMessage(msg); err == nil && c.conn != nil {
+		_, _ = c.conn.Write(data)
+	}
 
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
+		c.reader = nil
 	}
 
 	c.connected = false
@@ -104,7 +164,7 @@ func (c *TCPClient) Ping() error {
 	}
 
 	if !resp.Success {
-		return fmt.Errorf("ping failed: %s", resp.Error)
+		return ResponseError("ping", resp)
 	}
 
 	return nil
@@ -123,6 +183,7 @@ func (c *TCPClient) ExecWithIdempotency(query string, idempotencyKey string, arg
 		Query:          query,
 		Args:           args,
 		IdempotencyKey: idempotencyKey,
+		Timeout:        int64(c.limits.RequestTimeout),
 	}
 
 	resp, err := c.sendAndReceive(msg)
@@ -131,7 +192,7 @@ func (c *TCPClient) ExecWithIdempotency(query string, idempotencyKey string, arg
 	}
 
 	if !resp.Success {
-		return nil, fmt.Errorf("exec failed: %s", resp.Error)
+		return nil, ResponseError("exec", resp)
 	}
 
 	return ParseExecResult(resp.Data)
@@ -150,6 +211,7 @@ func (c *TCPClient) QueryWithIdempotency(query string, idempotencyKey string, ar
 		Query:          query,
 		Args:           args,
 		IdempotencyKey: idempotencyKey,
+		Timeout:        int64(c.limits.RequestTimeout),
 	}
 
 	resp, err := c.sendAndReceive(msg)
@@ -158,12 +220,35 @@ func (c *TCPClient) QueryWithIdempotency(query string, idempotencyKey string, ar
 	}
 
 	if !resp.Success {
-		return nil, fmt.Errorf("query failed: %s", resp.Error)
+		return nil, ResponseError("query", resp)
 	}
 
 	return ParseQueryResult(resp.Data)
 }
 
+// QueryRow executes a query and returns its first row. A query without rows
+// returns a NOT_FOUND error; check for it with IsNotFoundError.
+func (c *TCPClient) QueryRow(query string, args ...interface{}) (*QueryRowResult, error) {
+	msg := &TCPMessage{
+		Type:    MessageTypeQueryRow,
+		ID:      c.nextID(),
+		Query:   query,
+		Args:    args,
+		Timeout: int64(c.limits.RequestTimeout),
+	}
+
+	resp, err := c.sendAndReceive(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, ResponseError("query row", resp)
+	}
+
+	return ParseQueryRowResult(resp.Data)
+}
+
 // Stats retrieves connection pool statistics
 func (c *TCPClient) Stats() (*StatsResult, error) {
 	msg := &TCPMessage{
@@ -177,7 +262,7 @@ func (c *TCPClient) Stats() (*StatsResult, error) {
 	}
 
 	if !resp.Success {
-		return nil, fmt.Errorf("stats failed: %s", resp.Error)
+		return nil, ResponseError("stats", resp)
 	}
 
 	return ParseStatsResult(resp.Data)
@@ -196,14 +281,136 @@ func (c *TCPClient) Metrics() (*MetricsResult, error) {
 	}
 
 	if !resp.Success {
-		return nil, fmt.Errorf("metrics failed: %s", resp.Error)
+		return nil, ResponseError("metrics", resp)
 	}
 
 	return ParseMetricsResult(resp.Data)
 }
 
-// sendAndReceive sends a message and waits for response
+// TopQueries retrieves the metrics of the limit statements with the highest
+// total execution time on the server (0 uses the server default of 10)
+func (c *TCPClient) TopQueries(limit int) ([]QueryStats, error) {
+	payload, err := json.Marshal(QueryMetricsRequest{Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metrics request: %w", err)
+	}
+
+	msg := &TCPMessage{
+		Type:    MessageTypeMetricsQueries,
+		ID:      c.nextID(),
+		Payload: payload,
+	}
+
+	resp, err := c.sendAndReceive(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, ResponseError("query metrics", resp)
+	}
+
+	return ParseQueryStats(resp.Data)
+}
+
+// NextID returns count IDs (at least one) from the named server-side generator
+func (c *TCPClient) NextID(generator string, count int) ([]string, error) {
+	payload, err := json.Marshal(NextIDRequest{Generator: generator, Count: count})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode next id request: %w", err)
+	}
+
+	msg := &TCPMessage{
+		Type:    MessageTypeNextID,
+		ID:      c.nextID(),
+		Payload: payload,
+	}
+
+	resp, err := c.sendAndReceive(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, ResponseError("next id", resp)
+	}
+
+	result, err := ParseNextIDResult(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	return result.IDs, nil
+}
+
+// IPStats retrieves per-IP server statistics, busiest IPs first. token is
+// required when the server has an admin token; limit 0 returns all IPs.
+func (c *TCPClient) IPStats(token string, limit int) ([]IPStats, error) {
+	payload, err := json.Marshal(IPStatsRequest{Token: token, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode stats request: %w", err)
+	}
+
+	msg := &TCPMessage{
+		Type:    MessageTypeStatsIPs,
+		ID:      c.nextID(),
+		Payload: payload,
+	}
+
+	resp, err := c.sendAndReceive(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, ResponseError("ip stats", resp)
+	}
+
+	return ParseIPStats(resp.Data)
+}
+
+// Admin sends an administrative command to the server
+func (c *TCPClient) Admin(cmd *AdminCommand) (*AdminResult, error) {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode admin command: %w", err)
+	}
+
+	msg := &TCPMessage{
+		Type:    MessageTypeAdmin,
+		ID:      c.nextID(),
+		Payload: payload,
+	}
+
+	resp, err := c.sendAndReceive(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, ResponseError("admin", resp)
+	}
+
+	return ParseAdminResult(resp.Data)
+}
+
+// sendAndReceive sends a message and waits for response. With several
+// addresses configured, a failed connection is replaced and read-only
+// messages are resent once to the new server.
 func (c *TCPClient) sendAndReceive(msg *TCPMessage) (*TCPResponse, error) {
+	resp, err := c.roundTrip(msg)
+	var terr *transportError
+	if c.balancer == nil || !errors.As(err, &terr) {
+		return resp, err
+	}
+
+	if !c.failover(terr.conn) || !failoverRetryable(msg) || isTimeout(err) {
+		return nil, err
+	}
+	return c.roundTrip(msg)
+}
+
+// roundTrip sends a message on the current connection and waits for response
+func (c *TCPClient) roundTrip(msg *TCPMessage) (*TCPResponse, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -211,9 +418,12 @@ func (c *TCPClient) sendAndReceive(msg *TCPMessage) (*TCPResponse, error) {
 		return nil, fmt.Errorf("not connected")
 	}
 
+	conn := c.conn
+	start := time.Now()
+
 	// Set write deadline
-	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
-		return nil, fmt.Errorf("failed to set write deadline: %w", err)
+	if err := conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, &transportError{conn, fmt.Errorf("failed to set write deadline: %w", err)}
 	}
 
 	// Send message
@@ -222,33 +432,42 @@ func (c *TCPClient) sendAndReceive(msg *TCPMessage) (*TCPResponse, error) {
 		return nil, err
 	}
 
-	if _, err := c.conn.Write(data); err != nil {
-		return nil, fmt.Errorf("failed to send message: %w", err)
+	if len(data)-1 > c.limits.MaxRequestSize {
+		return nil, fmt.Errorf("%w: request is %d bytes, limit is %d", ErrMessageTooLarge, len(data)-1, c.limits.MaxRequestSize)
+	}
+
+	if err := WriteMessageChunked(conn, data, c.limits.WriteChunkSize); err != nil {
+		return nil, &transportError{conn, fmt.Errorf("failed to send message: %w", err)}
 	}
 
 	// Set read deadline
-	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
-		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	if err := conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, &transportError{conn, fmt.Errorf("failed to set read deadline: %w", err)}
 	}
 
 	// Read response
-	scanner := bufio.NewScanner(c.conn)
-	scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // 1MB buffer
-
-	if !scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, fmt.Errorf("failed to read response: %w", err)
-		}
-		return nil, fmt.Errorf("connection closed")
+	line, err := ReadMessageLine(c.reader, c.limits.MaxResponseSize)
+	if errors.Is(err, ErrMessageTooLarge) {
+		return nil, fmt.Errorf("%w: response exceeds %d bytes", err, c.limits.MaxResponseSize)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil, &transportError{conn, fmt.Errorf("connection closed")}
+	}
+	if err != nil {
+		return nil, &transportError{conn, fmt.Errorf("failed to read response: %w", err)}
+	}
+	if c.balancer != nil {
+		c.balancer.observe(c.address, time.Since(start))
 	}
 
-	resp, err := DecodeTCPResponse(scanner.Bytes())
+	resp, err := DecodeTCPResponse(line)
 	if err != nil {
 		return nil, err
 	}
 
-	// Verify response ID matches request ID
-	if resp.ID != msg.ID {
+	// Verify response ID matches request ID. Errors for requests the server
+	// could not decode (e.g. oversized ones) carry no ID.
+	if resp.ID != msg.ID && !(resp.ID == "" && !resp.Success) {
 		return nil, fmt.Errorf("response ID mismatch: expected %s, got %s", msg.ID, resp.ID)
 	}
 
@@ -269,7 +488,7 @@ func (c *TCPClient) sendMessage(msg *TCPMessage) error {
 		return err
 	}
 
-	if _, err := c.conn.Write(data); err != nil {
+	if err := WriteMessageChunked(c.conn, data, c.limits.WriteChunkSize); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
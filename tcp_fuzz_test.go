@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Seeds shared by the message and response fuzz targets
+var fuzzProtocolSeeds = []string{
+	`{"type":"EXEC","id":"1","query":"INSERT INTO t (a) VALUES (?)","args":["x"]}`,
+	`{"type":"QUERY","id":"2","query":"SELECT * FROM t WHERE a = ? AND b = ?","args":[1,2.5,null,true]}`,
+	`{"type":"ADMIN","id":"3","payload":{"action":"blacklist_add","token":"t","ip":"10.0.0.1"}}`,
+	`{"type":"NEXT_ID","id":"4","payload":{"generator":"orders","count":1000}}`,
+	`{"type":"SNAPSHOT","id":"5","payload":{"snapshot_id":"ab","offset":9223372036854775807,"limit":-1}}`,
+	`{"id":"6","success":true,"data":{"columns":["a"],"rows":[[1],["x"]]}}`,
+	`{"id":"7","success":false,"error":"boom","code":"SERVER_BUSY"}`,
+	`{"type":"PING","id":"\ud800"}`,
+	`{"type":"EXEC","args":[[[[[[[[[[1]]]]]]]]]]}`,
+	"{\"type\":\"\xff\xfe\"}",
+	`{"type":"EXEC","query":"\"[[[[{{{{"}`,
+	`[]`,
+	`null`,
+	``,
+	`{`,
+}
+
+func FuzzDecodeTCPMessage(f *testing.F) {
+	for _, seed := range fuzzProtocolSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := DecodeTCPMessage(data)
+		if err != nil {
+			return
+		}
+
+		// Anything accepted must survive a round trip
+		encoded, err := EncodeTCPMessage(msg)
+		if err != nil {
+			t.Fatalf("Failed to re-encode decoded message: %v", err)
+		}
+		again, err := DecodeTCPMessage(encoded[:len(encoded)-1])
+		if err != nil {
+			t.Fatalf("Failed to decode re-encoded message: %v", err)
+		}
+		if again.Type != msg.Type || again.ID != msg.ID || again.Query != msg.Query {
+			t.Fatalf("Round trip mismatch: %+v != %+v", again, msg)
+		}
+	})
+}
+
+func FuzzDecodeTCPResponse(f *testing.F) {
+	for _, seed := range fuzzProtocolSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		resp, err := DecodeTCPResponse(data)
+		if err != nil {
+			return
+		}
+
+		encoded, err := EncodeTCPResponse(resp)
+		if err != nil {
+			t.Fatalf("Failed to re-encode decoded response: %v", err)
+		}
+		again, err := DecodeTCPResponse(encoded[:len(encoded)-1])
+		if err != nil {
+			t.Fatalf("Failed to decode re-encoded response: %v", err)
+		}
+		if again.ID != resp.ID || again.Success != resp.Success || again.Error != resp.Error {
+			t.Fatalf("Round trip mismatch: %+v != %+v", again, resp)
+		}
+
+		// Result parsers must fail cleanly on arbitrary data
+		ParseQueryResult(resp.Data)
+		ParseExecResult(resp.Data)
+		ParseNextIDResult(resp.Data)
+		ParseIPStats(resp.Data)
+	})
+}
+
+func FuzzReadMessageLine(f *testing.F) {
+	f.Add([]byte("short\nmessage\n"), 8, 16)
+	f.Add([]byte(strings.Repeat("x", 100)+"\nafter"), 10, 16)
+	f.Add([]byte("\n\n\n"), 1, 16)
+	f.Add([]byte("no newline"), 4, 16)
+
+	f.Fuzz(func(t *testing.T, data []byte, maxSize, bufSize int) {
+		if maxSize <= 0 || maxSize > 1<<16 {
+			maxSize = 1 << 16
+		}
+		reader := bufio.NewReaderSize(bytes.NewReader(data), bufSize)
+
+		// Every call consumes input, so the number of calls is bounded by
+		// the number of lines
+		calls := 0
+		for {
+			line, err := ReadMessageLine(reader, maxSize)
+			calls++
+			if calls > len(data)+1 {
+				t.Fatalf("ReadMessageLine did not make progress after %d calls", calls)
+			}
+			if errors.Is(err, ErrMessageTooLarge) {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if len(line) > maxSize {
+				t.Fatalf("Line of %d bytes exceeds limit of %d", len(line), maxSize)
+			}
+			if bytes.IndexByte(line, '\n') >= 0 {
+				t.Fatalf("Line contains delimiter: %q", line)
+			}
+		}
+	})
+}
+
+func TestDecodeTCPMessage_Malformed(t *testing.T) {
+	nested := `{"type":"EXEC","args":` + strings.Repeat("[", MaxMessageDepth) + strings.Repeat("]", MaxMessageDepth) + `}`
+	if _, err := DecodeTCPMessage([]byte(nested)); !errors.Is(err, ErrMessageTooDeep) {
+		t.Errorf("Expected ErrMessageTooDeep, got %v", err)
+	}
+
+	// Brackets inside strings do not count towards the depth
+	quoted := `{"type":"EXEC","query":"` + strings.Repeat("[", 100) + `\"{"}`
+	if _, err := DecodeTCPMessage([]byte(quoted)); err != nil {
+		t.Errorf("Expected quoted brackets to decode, got %v", err)
+	}
+
+	if _, err := DecodeTCPMessage([]byte("{\"type\":\"PING\",\"id\":\"\xff\"}")); !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("Expected ErrInvalidUTF8, got %v", err)
+	}
+
+	if _, err := DecodeTCPResponse([]byte(`{"id":"1","data":` + strings.Repeat(`{"a":`, 100))); !errors.Is(err, ErrMessageTooDeep) {
+		t.Errorf("Expected ErrMessageTooDeep for response, got %v", err)
+	}
+}
+
+func TestTCPServer_MalformedInput(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:        "localhost:0",
+		Runtime:        &DBRuntime{},
+		MaxRequestSize: 64 * 1024,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.GetAddress())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	inputs := []string{
+		`{"type":"EXEC","args":` + strings.Repeat("[", 10000),
+		"{\"type\":\"EXEC\",\"query\":\"\xc3\x28\"}",
+		`{"type":`,
+		`{"type":"NEXT_ID","id":"1","payload":{"count":1e400}}`,
+		strings.Repeat("}", 1000),
+	}
+	for _, input := range inputs {
+		if _, err := conn.Write([]byte(input + "\n")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("Expected an error response for %.40q, got %v", input, err)
+		}
+		resp, err := DecodeTCPResponse(line[:len(line)-1])
+		if err != nil || resp.Success {
+			t.Errorf("Expected a failed response for %.40q, got %+v (%v)", input, resp, err)
+		}
+	}
+
+	// The connection is still usable
+	ping, _ := EncodeTCPMessage(&TCPMessage{Type: MessageTypePing, ID: "ping"})
+	if _, err := conn.Write(ping); err != nil {
+		t.Fatalf("Failed to write ping: %v", err)
+	}
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Failed to read ping response: %v", err)
+	}
+	if resp, err := DecodeTCPResponse(line[:len(line)-1]); err != nil || !resp.Success || resp.ID != "ping" {
+		t.Errorf("Expected successful ping, got %+v (%v)", resp, err)
+	}
+}
@@ -1,8 +1,32 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxMessageSize is the default limit for a single message
+	DefaultMaxMessageSize = 1024 * 1024
+	// DefaultWriteChunkSize is the default size of each write of a message
+	DefaultWriteChunkSize = 64 * 1024
+	// MaxMessageDepth is the deepest nesting of JSON objects and arrays
+	// accepted in a message. Legitimate messages need a handful of levels.
+	MaxMessageDepth = 32
+)
+
+var (
+	// ErrMessageTooLarge is returned when a message exceeds the size limit
+	ErrMessageTooLarge = errors.New("message exceeds size limit")
+	// ErrInvalidUTF8 is returned when a message is not valid UTF-8
+	ErrInvalidUTF8 = errors.New("message is not valid UTF-8")
+	// ErrMessageTooDeep is returned when a message nests deeper than MaxMessageDepth
+	ErrMessageTooDeep = errors.New("message nesting too deep")
 )
 
 // MessageType represents the type of TCP message
@@ -13,14 +37,43 @@ const (
 	MessageTypeExec MessageType = "EXEC"
 	// MessageTypeQuery executes a query that returns rows
 	MessageTypeQuery MessageType = "QUERY"
+	// MessageTypeQueryRow executes a query and returns its first row
+	MessageTypeQueryRow MessageType = "QUERY_ROW"
+	// MessageTypeOpenCursor executes a query and holds its rows in a server-side cursor
+	MessageTypeOpenCursor MessageType = "OPEN_CURSOR"
+	// MessageTypeFetch returns the next rows of a cursor
+	MessageTypeFetch MessageType = "FETCH"
+	// MessageTypeCloseCursor closes a cursor before it is exhausted
+	MessageTypeCloseCursor MessageType = "CLOSE_CURSOR"
 	// MessageTypePing checks server health
 	MessageTypePing MessageType = "PING"
 	// MessageTypeStats returns connection pool statistics
 	MessageTypeStats MessageType = "STATS"
 	// MessageTypeMetrics returns performance metrics
 	MessageTypeMetrics MessageType = "METRICS"
+	// MessageTypeMetricsQueries returns per-statement metrics, costliest first
+	MessageTypeMetricsQueries MessageType = "METRICS_QUERIES"
 	// MessageTypeClose closes the connection
 	MessageTypeClose MessageType = "CLOSE"
+	// MessageTypeAdmin performs an administrative action on the server
+	MessageTypeAdmin MessageType = "ADMIN"
+	// MessageTypeStatsIPs returns per-IP server statistics
+	MessageTypeStatsIPs MessageType = "STATS_IPS"
+	// MessageTypeNextID returns the next ID from a server-side IDGenerator
+	MessageTypeNextID MessageType = "NEXT_ID"
+	// MessageTypeSnapshot downloads a chunk of the runtime's database snapshot
+	MessageTypeSnapshot MessageType = "SNAPSHOT"
+)
+
+// Admin actions carried in the payload of an ADMIN message
+const (
+	AdminActionBlacklistAdd     = "blacklist_add"
+	AdminActionBlacklistRemove  = "blacklist_remove"
+	AdminActionBlacklistList    = "blacklist_list"
+	AdminActionProtectionGet    = "protection_get"
+	AdminActionProtectionUpdate = "protection_update"
+	AdminActionGateGet          = "gate_get"
+	AdminActionGateUpdate       = "gate_update"
 )
 
 // TCPMessage represents a message sent over TCP
@@ -31,6 +84,7 @@ type TCPMessage struct {
 	Args           []interface{}   `json:"args,omitempty"`
 	Payload        json.RawMessage `json:"payload,omitempty"`
 	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+	Timeout        int64           `json:"timeout_ns,omitempty"` // EXEC/QUERY deadline, capped by the server (0 = server default)
 	ClientIP       string          `json:"client_ip,omitempty"`
 	RequestSize    int64           `json:"request_size,omitempty"`
 }
@@ -40,7 +94,11 @@ type TCPResponse struct {
 	ID      string          `json:"id"`
 	Success bool            `json:"success"`
 	Error   string          `json:"error,omitempty"`
+	Code    string          `json:"code,omitempty"` // machine-readable error code (see ErrCode*)
 	Data    json.RawMessage `json:"data,omitempty"`
+	// RetryAfter is the suggested back-off in nanoseconds for rate limit,
+	// connection limit and busy errors
+	RetryAfter int64 `json:"retry_after_ns,omitempty"`
 }
 
 // ExecResult represents the result of an EXEC operation
@@ -55,6 +113,29 @@ type QueryResult struct {
 	Rows    [][]interface{} `json:"rows"`
 }
 
+// QueryRowResult represents the result of a QUERY_ROW operation. A query
+// without rows fails with a NOT_FOUND error instead.
+type QueryRowResult struct {
+	Columns []string      `json:"columns"`
+	Row     []interface{} `json:"row"`
+}
+
+// CursorRequest is the payload of FETCH and CLOSE_CURSOR messages
+type CursorRequest struct {
+	CursorID string `json:"cursor_id"`
+	Count    int    `json:"count,omitempty"` // rows per FETCH (default 100, max 10000)
+}
+
+// CursorResult represents the result of cursor operations. OPEN_CURSOR
+// returns the columns, FETCH the next rows. Done is set once the cursor is
+// exhausted, after which the server has closed it.
+type CursorResult struct {
+	CursorID string          `json:"cursor_id"`
+	Columns  []string        `json:"columns,omitempty"`
+	Rows     [][]interface{} `json:"rows,omitempty"`
+	Done     bool            `json:"done"`
+}
+
 // StatsResult represents connection pool statistics
 type StatsResult struct {
 	MaxOpenConnections int `json:"max_open_connections"`
@@ -75,6 +156,125 @@ type MetricsResult struct {
 	FailedQueries     int64 `json:"failed_queries"`
 	SlowQueries       int64 `json:"slow_queries"`
 	AverageQueryTime  int64 `json:"average_query_time_ns"`
+
+	GateWait GateWaitStats `json:"gate_wait"`
+	Cache    *CacheStats   `json:"cache,omitempty"` // absent when the runtime has no cache
+}
+
+// QueryMetricsRequest is the optional payload of a METRICS_QUERIES message
+type QueryMetricsRequest struct {
+	Limit int `json:"limit,omitempty"` // statements to return (default 10, 0 < limit <= 1000)
+}
+
+// AdminCommand is the payload of an ADMIN message
+type AdminCommand struct {
+	Action string `json:"action"`
+	Token  string `json:"token"`
+	IP     string `json:"ip,omitempty"`
+	TTL    int64  `json:"ttl_ns,omitempty"` // 0 means a permanent ban
+	// Protection carries the changes of a protection_update action
+	Protection *ProtectionUpdate `json:"protection,omitempty"`
+	// Gate carries the changes of a gate_update action
+	Gate *GateUpdate `json:"gate,omitempty"`
+}
+
+// ProtectionSettings are the DDoS protection limits that can be changed
+// while the server is running. Zero disables a limit.
+type ProtectionSettings struct {
+	MaxConnectionsPerIP   int           `json:"max_connections_per_ip"`
+	RateLimitPerIP        int64         `json:"rate_limit_per_ip"`
+	RateLimitBurstPerIP   int64         `json:"rate_limit_burst_per_ip"`
+	RateLimitBanThreshold int           `json:"rate_limit_ban_threshold"`
+	RateLimitBanDuration  time.Duration `json:"rate_limit_ban_duration_ns"`
+}
+
+// ProtectionUpdate changes the protection settings. Nil fields are left
+// unchanged.
+type ProtectionUpdate struct {
+	MaxConnectionsPerIP   *int           `json:"max_connections_per_ip,omitempty"`
+	RateLimitPerIP        *int64         `json:"rate_limit_per_ip,omitempty"`
+	RateLimitBurstPerIP   *int64         `json:"rate_limit_burst_per_ip,omitempty"`
+	RateLimitBanThreshold *int           `json:"rate_limit_ban_threshold,omitempty"`
+	RateLimitBanDuration  *time.Duration `json:"rate_limit_ban_duration_ns,omitempty"`
+}
+
+// BlacklistEntry describes a banned IP address
+type BlacklistEntry struct {
+	IP        string    `json:"ip"`
+	Permanent bool      `json:"permanent"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AdminResult represents the result of an ADMIN operation
+type AdminResult struct {
+	Action    string           `json:"action"`
+	Changed   bool             `json:"changed"`
+	Blacklist []BlacklistEntry `json:"blacklist,omitempty"`
+	// Protection holds the settings after protection_get or protection_update
+	Protection *ProtectionSettings `json:"protection,omitempty"`
+	// Gate holds the runtime's gate limits after gate_get or gate_update
+	Gate *GateSettings `json:"gate,omitempty"`
+}
+
+// NextIDRequest is the payload of a NEXT_ID message
+type NextIDRequest struct {
+	Generator string `json:"generator"`
+	Count     int    `json:"count,omitempty"` // number of IDs (default 1, max 1000)
+}
+
+// NextIDResult represents the result of a NEXT_ID operation. IDs are
+// strings so 64-bit values survive JSON clients that use float64 numbers.
+type NextIDResult struct {
+	Generator string   `json:"generator"`
+	IDs       []string `json:"ids"`
+}
+
+// SnapshotRequest is the payload of a SNAPSHOT message. The first request
+// omits SnapshotID to export a new snapshot; later requests pass the
+// returned ID and the offset of the next chunk.
+type SnapshotRequest struct {
+	Token      string `json:"token,omitempty"` // the server's AdminToken
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	Offset     int64  `json:"offset,omitempty"`
+	Limit      int    `json:"limit,omitempty"` // chunk size (max 512KB)
+}
+
+// SnapshotChunk represents the result of a SNAPSHOT operation
+type SnapshotChunk struct {
+	SnapshotID string `json:"snapshot_id"`
+	Size       int64  `json:"size"`     // total snapshot size
+	Checksum   string `json:"checksum"` // SHA-256 of the whole snapshot
+	Offset     int64  `json:"offset"`
+	Data       []byte `json:"data"`
+}
+
+// Reasons a request or connection was rejected, as counted in IPStats.Rejects
+const (
+	RejectReasonBlacklisted     = "blacklisted"
+	RejectReasonNotWhitelisted  = "not_whitelisted"
+	RejectReasonConnectionLimit = "connection_limit"
+	RejectReasonRateLimited     = "rate_limited"
+	RejectReasonTooLarge        = "request_too_large"
+	RejectReasonServerBusy      = "server_busy"
+	RejectReasonMemoryLimit     = "memory_limit"
+)
+
+// IPStatsRequest is the optional payload of a STATS_IPS message
+type IPStatsRequest struct {
+	Token string `json:"token,omitempty"` // required when the server has an AdminToken
+	Limit int    `json:"limit,omitempty"` // return only the busiest N IPs (0 = all)
+}
+
+// IPStats holds the server counters for a single client IP
+type IPStats struct {
+	IP                string           `json:"ip"`
+	ActiveConnections int64            `json:"active_connections"`
+	TotalConnections  int64            `json:"total_connections"`
+	Requests          int64            `json:"requests"`
+	BytesIn           int64            `json:"bytes_in"`
+	BytesOut          int64            `json:"bytes_out"`
+	Rejects           map[string]int64 `json:"rejects,omitempty"`
+	LastSeen          time.Time        `json:"last_seen"`
 }
 
 // EncodeTCPMessage encodes a TCP message to JSON bytes
@@ -89,6 +289,9 @@ func EncodeTCPMessage(msg *TCPMessage) ([]byte, error) {
 
 // DecodeTCPMessage decodes JSON bytes to a TCP message
 func DecodeTCPMessage(data []byte) (*TCPMessage, error) {
+	if err := checkMessage(data); err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
 	var msg TCPMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		return nil, fmt.Errorf("failed to decode message: %w", err)
@@ -108,6 +311,9 @@ func EncodeTCPResponse(resp *TCPResponse) ([]byte, error) {
 
 // DecodeTCPResponse decodes JSON bytes to a TCP response
 func DecodeTCPResponse(data []byte) (*TCPResponse, error) {
+	if err := checkMessage(data); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
 	var resp TCPResponse
 	if err := json.Unmarshal(data, &resp); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
@@ -115,6 +321,45 @@ func DecodeTCPResponse(data []byte) (*TCPResponse, error) {
 	return &resp, nil
 }
 
+// checkMessage rejects input that is not valid UTF-8 or nests deeper than
+// MaxMessageDepth, before it reaches the JSON decoder. encoding/json would
+// otherwise silently replace invalid bytes and recurse on every level.
+func checkMessage(data []byte) error {
+	if !utf8.Valid(data) {
+		return ErrInvalidUTF8
+	}
+
+	depth := 0
+	inString := false
+	escaped := false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > MaxMessageDepth {
+				return ErrMessageTooDeep
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
+
 // NewSuccessResponse creates a successful response
 func NewSuccessResponse(id string, data interface{}) (*TCPResponse, error) {
 	payload, err := json.Marshal(data)
@@ -136,3 +381,84 @@ func NewErrorResponse(id string, err error) *TCPResponse {
 		Error:   err.Error(),
 	}
 }
+
+// NewErrorResponseWithCode creates an error response carrying an error code
+func NewErrorResponseWithCode(id, code string, err error) *TCPResponse {
+	resp := NewErrorResponse(id, err)
+	resp.Code = code
+	return resp
+}
+
+// ResponseError converts a failed response into an error. Responses with
+// an error code become a *DatabaseError so callers can inspect the code.
+func ResponseError(op string, resp *TCPResponse) error {
+	if resp.Code != "" {
+		dbErr := NewDatabaseError(resp.Code, op+" failed", errors.New(resp.Error))
+		dbErr.RetryAfter = time.Duration(resp.RetryAfter)
+		return dbErr
+	}
+	return fmt.Errorf("%s failed: %s", op, resp.Error)
+}
+
+// ReadMessageLine reads one newline-delimited message of at most maxSize
+// bytes, excluding the delimiter. An oversized message is discarded up to
+// its newline so the stream stays usable, and ErrMessageTooLarge is returned.
+func ReadMessageLine(r *bufio.Reader, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	var line []byte
+	tooLarge := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			if len(line)+len(chunk) > maxSize+1 {
+				tooLarge = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+
+		switch {
+		case err == nil:
+			if tooLarge {
+				return nil, ErrMessageTooLarge
+			}
+			return line[:len(line)-1], nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(line) > 0 && !tooLarge:
+			// Final message without a trailing newline, which has no
+			// delimiter byte to account for
+			if len(line) > maxSize {
+				return nil, ErrMessageTooLarge
+			}
+			return line, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// WriteMessageChunked writes an encoded message in chunks of at most
+// chunkSize bytes, so large responses are streamed rather than handed to
+// the connection in a single write
+func WriteMessageChunked(w io.Writer, data []byte, chunkSize int) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultWriteChunkSize
+	}
+
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
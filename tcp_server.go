@@ -3,10 +3,15 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,11 +30,30 @@ type TCPServer struct {
 	mu            sync.RWMutex
 	// DDoS protection
 	ipConnections map[string]int
-	ipRateLimits  map[string]*time.Time
-	blacklistMap  map[string]bool
+	activeIPs     int       // IPs sharing the gate budget, see activeIPCount
+	activeIPsAt   time.Time // when activeIPs was counted
+	ipRateLimits  map[string]*ipTokenBucket
+	ipViolations  map[string]*rateLimitViolations
+	blacklistMap  map[string]time.Time // zero expiry means permanent
 	whitelistMap  map[string]bool
+	protection    ProtectionSettings // limits that can be changed at runtime
+	// Per-IP statistics
+	statsMu sync.Mutex
+	ipStats map[string]*IPStats
 	// Idempotency
-	idempotencyCache Cache
+	idempotencyStore  IdempotencyStore
+	idempotencyFlight flightGroup[*TCPResponse] // copies of a request in flight
+	// Snapshots exported to peers
+	snapshotMu       sync.Mutex
+	snapshots        map[string]*exportedSnapshot
+	snapshotExportMu sync.Mutex // serializes snapshot exports
+	// Approximate memory held by in-flight requests
+	memory *memoryBudget
+	// Server-side cursors
+	cursorMu      sync.Mutex
+	cursors       map[string]*serverCursor
+	cursorSlots   int            // open cursors and cursors being opened
+	clientCursors map[string]int // cursorSlots by client IP
 }
 
 // TCPServerConfig configures the TCP server
@@ -37,14 +61,98 @@ type TCPServerConfig struct {
 	Address              string
 	Runtime              *DBRuntime
 	EnableIdempotency    bool
+	IdempotencyStore     IdempotencyStore // where responses are kept (default in-memory)
+	IdempotencyTTL       time.Duration    // how long responses are replayed (default 5 minutes)
 	EnableDDoSProtection bool
-	MaxRequestSize       int64
+	MaxRequestSize       int64 // largest request accepted (default 1MB)
+	MaxResponseSize      int64 // larger responses are replaced by an error (0 = unlimited)
+	MaxRequestMemory     int64 // approximate memory a single QUERY may hold (0 = unlimited)
+	MaxTotalMemory       int64 // approximate memory all in-flight requests may hold (0 = unlimited)
+	WriteChunkSize       int   // responses are written in chunks of this size (default 64KB)
 	MaxConnectionsPerIP  int
 	RateLimitPerIP       int64  // requests per second per IP
+	RateLimitBurstPerIP  int64  // bucket size per IP (default RateLimitPerIP)
 	BlacklistedIPs       []string
 	WhitelistedIPs       []string
+
+	// IdempotencyMaxResponse is the largest response data kept for replay
+	// (default 256KB, negative = unlimited). Retries of a larger QUERY run
+	// again; retries of a larger EXEC get a NOT_REPLAYABLE error.
+	IdempotencyMaxResponse int64
+
+	// ShareGateBudget divides the runtime gate's request rate
+	// (RuntimeConfig.MaxRequestsPerSecond) among the client IPs active in
+	// the last 10 seconds, so per-IP limits follow the database budget and
+	// one client cannot use it all. RateLimitPerIP, if set, still caps the
+	// rate of each IP. It requires EnableDDoSProtection.
+	ShareGateBudget bool
+
+	// Automatic temporary bans for IPs that keep exceeding the rate limit
+	RateLimitBanThreshold int           // violations within a minute before a ban (0 disables)
+	RateLimitBanDuration  time.Duration // ban length (default 5 minutes)
+
+	// MaxRequestTimeout caps the timeout of EXEC and QUERY messages and
+	// applies to messages without one (0 = no server-side limit)
+	MaxRequestTimeout time.Duration
+
+	// AdminToken authorizes ADMIN messages; ADMIN is disabled when empty
+	AdminToken string
+
+	// IdentityFunc names the client of a request for the runtime's
+	// per-identity rate limits (RuntimeConfig.IdentityRateLimit). It
+	// defaults to the client IP.
+	IdentityFunc func(msg *TCPMessage) string
+
+	// IDGenerators are served by NEXT_ID messages, keyed by generator name
+	IDGenerators map[string]IDGenerator
+
+	// Server-side cursors opened by OPEN_CURSOR. Each open cursor holds a
+	// database connection and gate slot until it is exhausted, closed or
+	// idle for CursorIdleTimeout (default 5 minutes).
+	MaxOpenCursors          int // default 100
+	MaxOpenCursorsPerClient int // per client IP, default 10
+	CursorIdleTimeout       time.Duration
+
+	// EnableSnapshotExport serves the SQLite database to peers via SNAPSHOT
+	// messages, which must carry AdminToken (refused when it is empty)
+	EnableSnapshotExport bool
+
+	// Per-connection concurrency. With MaxConcurrentRequestsPerConnection > 0
+	// a connection may pipeline up to that many requests; excess requests get
+	// a SERVER_BUSY error, or wait for a free slot when QueueExcessRequests is set.
+	// Zero processes each connection's requests one at a time.
+	MaxConcurrentRequestsPerConnection int
+	QueueExcessRequests                bool
+}
+
+// lockedConn serializes responses from concurrently processed requests.
+// sendResponse holds writeMu for all chunks of a response.
+type lockedConn struct {
+	net.Conn
+	writeMu sync.Mutex
+}
+
+// ipTokenBucket is the per-IP token bucket used by checkRateLimit
+type ipTokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// rateLimitViolations counts rate limit violations of an IP within a window
+type rateLimitViolations struct {
+	count       int
+	windowStart time.Time
 }
 
+const (
+	rateLimitViolationWindow    = time.Minute
+	defaultRateLimitBanDuration = 5 * time.Minute
+	ipStateCleanupInterval      = time.Minute
+	sharedBudgetWindow          = 10 * time.Second // IPs seen within it share the gate budget
+	sharedBudgetRecount         = time.Second      // how often the active IPs are counted
+	ipStatsRetention            = time.Hour        // idle IPs are dropped from the statistics after this
+)
+
 // NewTCPServer creates a new TCP server
 func NewTCPServer(config *TCPServerConfig) *TCPServer {
 	server := &TCPServer{
@@ -53,14 +161,27 @@ func NewTCPServer(config *TCPServerConfig) *TCPServer {
 		address:       config.Address,
 		shutdown:      make(chan struct{}),
 		ipConnections: make(map[string]int),
-		ipRateLimits:  make(map[string]*time.Time),
-		blacklistMap:  make(map[string]bool),
+		ipRateLimits:  make(map[string]*ipTokenBucket),
+		ipViolations:  make(map[string]*rateLimitViolations),
+		blacklistMap:  make(map[string]time.Time),
 		whitelistMap:  make(map[string]bool),
+		ipStats:       make(map[string]*IPStats),
+		snapshots:     make(map[string]*exportedSnapshot),
+		memory:        &memoryBudget{limit: config.MaxTotalMemory},
+		cursors:       make(map[string]*serverCursor),
+		clientCursors: make(map[string]int),
+		protection: ProtectionSettings{
+			MaxConnectionsPerIP:   config.MaxConnectionsPerIP,
+			RateLimitPerIP:        config.RateLimitPerIP,
+			RateLimitBurstPerIP:   config.RateLimitBurstPerIP,
+			RateLimitBanThreshold: config.RateLimitBanThreshold,
+			RateLimitBanDuration:  config.RateLimitBanDuration,
+		},
 	}
 
-	// Initialize blacklist
+	// Initialize blacklist (startup entries are permanent)
 	for _, ip := range config.BlacklistedIPs {
-		server.blacklistMap[ip] = true
+		server.blacklistMap[ip] = time.Time{}
 	}
 
 	// Initialize whitelist
@@ -68,9 +189,12 @@ func NewTCPServer(config *TCPServerConfig) *TCPServer {
 		server.whitelistMap[ip] = true
 	}
 
-	// Initialize idempotency cache if enabled
+	// Initialize idempotency store if enabled
 	if config.EnableIdempotency {
-		server.idempotencyCache = NewInMemoryCache(10000, 300*time.Second) // 5min TTL
+		server.idempotencyStore = config.IdempotencyStore
+		if server.idempotencyStore == nil {
+			server.idempotencyStore = NewCacheIdempotencyStore(NewInMemoryCache(10000, server.idempotencyTTL()))
+		}
 	}
 
 	return server
@@ -93,8 +217,9 @@ func (s *TCPServer) Start() error {
 	s.listener = listener
 	log.Printf("TCP server listening on %s", s.address)
 
-	s.wg.Add(1)
+	s.wg.Add(2)
 	go s.acceptLoop()
+	go s.cleanupLoop()
 
 	return nil
 }
@@ -102,14 +227,16 @@ func (s *TCPServer) Start() error {
 // Stop stops the TCP server
 func (s *TCPServer) Stop() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if s.listener == nil {
+		s.mu.Unlock()
 		return fmt.Errorf("server not started")
 	}
 
 	close(s.shutdown)
 	s.listener.Close()
+	// Release the lock before waiting: client goroutines need it for the
+	// per-IP checks while they wind down
+	s.mu.Unlock()
 
 	// Close all client connections
 	s.clients.Range(func(key, value interface{}) bool {
@@ -120,6 +247,8 @@ func (s *TCPServer) Stop() error {
 	})
 
 	s.wg.Wait()
+	s.cleanupSnapshots(time.Now(), true)
+	s.cleanupCursors(time.Now(), true)
 	log.Printf("TCP server stopped")
 	return nil
 }
@@ -170,23 +299,48 @@ func (s *TCPServer) handleClient(clientID uint64, conn net.Conn) {
 	log.Printf("Client %d connected from %s (IP: %s)", clientID, conn.RemoteAddr(), clientIP)
 
 	// DDoS protection checks
-	if s.config.EnableDDoSProtection && !s.allowConnection(clientIP) {
-		log.Printf("Connection from %s blocked by DDoS protection", clientIP)
+	if resp := s.OpenConnection(clientIP); resp != nil {
+		log.Printf("Connection from %s blocked by DDoS protection: %s", clientIP, resp.Error)
 		return
 	}
+	defer s.CloseConnection(clientIP)
+
+	// Pipelined requests are processed concurrently, bounded per connection
+	var inflight chan struct{}
+	var requests sync.WaitGroup
+	if s.config.MaxConcurrentRequestsPerConnection > 0 {
+		inflight = make(chan struct{}, s.config.MaxConcurrentRequestsPerConnection)
+		conn = &lockedConn{Conn: conn}
+		defer requests.Wait()
+	}
 
-	scanner := bufio.NewScanner(conn)
-	scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // 1MB buffer
+	maxRequestSize := int(s.config.MaxRequestSize)
+	if maxRequestSize <= 0 {
+		maxRequestSize = DefaultMaxMessageSize
+	}
+	reader := bufio.NewReaderSize(conn, 64*1024)
+
+	for {
+		data, err := ReadMessageLine(reader, maxRequestSize)
 
-	for scanner.Scan() {
 		select {
 		case <-s.shutdown:
 			return
 		default:
 		}
 
-		data := scanner.Bytes()
-		
+		if errors.Is(err, ErrMessageTooLarge) {
+			s.recordIPReject(clientIP, RejectReasonTooLarge)
+			s.sendError(conn, "", fmt.Errorf("request too large: limit is %d bytes", maxRequestSize))
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Read error for client %d: %v", clientID, err)
+			}
+			break
+		}
+
 		// DDoS protection - track request size
 		requestSize := int64(len(data))
 		
@@ -200,95 +354,225 @@ func (s *TCPServer) handleClient(clientID uint64, conn net.Conn) {
 		msg.RequestSize = requestSize
 		msg.ClientIP = clientIP
 
-		s.handleMessage(conn, msg)
+		if inflight == nil || msg.Type == MessageTypeClose {
+			s.handleMessage(conn, msg)
+		} else if !s.acquireRequestSlot(inflight) {
+			s.recordIPReject(clientIP, RejectReasonServerBusy)
+			s.sendResponse(conn, NewErrorResponseWithCode(msg.ID, ErrCodeServerBusy,
+				fmt.Errorf("too many concurrent requests on this connection")))
+			continue
+		} else {
+			requests.Add(1)
+			go func(msg *TCPMessage) {
+				defer requests.Done()
+				defer func() { <-inflight }()
+				s.handleMessage(conn, msg)
+			}(msg)
+		}
 
 		if msg.Type == MessageTypeClose {
 			log.Printf("Client %d requested close", clientID)
 			return
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Scanner error for client %d: %v", clientID, err)
+		// Drop clients banned while connected
+		if s.config.EnableDDoSProtection && s.IsBlacklisted(clientIP) {
+			log.Printf("Client %d from %s disconnected: IP is blacklisted", clientID, clientIP)
+			return
+		}
 	}
 
 	log.Printf("Client %d disconnected", clientID)
 }
 
+// RequestProcessor processes a decoded request and returns the response to
+// send back. Transports must route every request through a RequestProcessor
+// so that none of them can bypass the server protections.
+type RequestProcessor interface {
+	// OpenConnection admits a connection from clientIP under the per-IP
+	// connection protections (blacklist, whitelist, MaxConnectionsPerIP).
+	// It returns the error response for a rejected connection, or nil; an
+	// admitted connection must be released with CloseConnection.
+	// Connectionless transports open one connection per request.
+	OpenConnection(clientIP string) *TCPResponse
+	// CloseConnection releases a connection admitted by OpenConnection
+	CloseConnection(clientIP string)
+	// Process handles msg. msg.ClientIP and msg.RequestSize must be set by
+	// the transport. A nil response means nothing should be sent back.
+	Process(ctx context.Context, msg *TCPMessage) *TCPResponse
+}
+
+// OpenConnection admits a connection from clientIP. It implements
+// RequestProcessor.
+func (s *TCPServer) OpenConnection(clientIP string) *TCPResponse {
+	if s.config.EnableDDoSProtection {
+		if reason := s.allowConnection(clientIP); reason != "" {
+			s.recordIPReject(clientIP, reason)
+			switch reason {
+			case RejectReasonBlacklisted:
+				return NewErrorResponse("", fmt.Errorf("IP is blacklisted: %s", clientIP))
+			case RejectReasonNotWhitelisted:
+				return NewErrorResponse("", fmt.Errorf("IP is not whitelisted: %s", clientIP))
+			default:
+				return NewErrorResponseWithCode("", ErrCodeConnectionLimit, fmt.Errorf("too many connections from %s", clientIP))
+			}
+		}
+	}
+	s.recordIPConnection(clientIP)
+	return nil
+}
+
+// CloseConnection releases a connection admitted by OpenConnection. It
+// implements RequestProcessor.
+func (s *TCPServer) CloseConnection(clientIP string) {
+	if s.config.EnableDDoSProtection {
+		s.releaseConnection(clientIP)
+	}
+	s.releaseIPConnection(clientIP)
+}
+
+// acquireRequestSlot takes an in-flight slot for a connection. Without
+// QueueExcessRequests it fails immediately when all slots are taken.
+func (s *TCPServer) acquireRequestSlot(inflight chan struct{}) bool {
+	select {
+	case inflight <- struct{}{}:
+		return true
+	default:
+	}
+
+	if !s.config.QueueExcessRequests {
+		return false
+	}
+
+	select {
+	case inflight <- struct{}{}:
+		return true
+	case <-s.shutdown:
+		return false
+	}
+}
+
 // handleMessage handles a single message
 func (s *TCPServer) handleMessage(conn net.Conn, msg *TCPMessage) {
-	clientIP := s.getClientIP(conn)
-	
 	// Set client IP for tracking
-	msg.ClientIP = clientIP
-	
+	msg.ClientIP = s.getClientIP(conn)
+
+	if resp := s.Process(context.Background(), msg); resp != nil {
+		s.sendResponse(conn, resp)
+	}
+}
+
+// Process applies DDoS protection and idempotency to a message and
+// dispatches it to the runtime. Responses larger than MaxResponseSize are
+// replaced by a RESPONSE_TOO_LARGE error here, so the limit holds for every
+// transport. It implements RequestProcessor.
+func (s *TCPServer) Process(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	return s.limitResponse(s.process(ctx, msg))
+}
+
+// process is Process without the response size limit
+func (s *TCPServer) process(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	clientIP := msg.ClientIP
+	s.recordIPRequest(clientIP, msg.RequestSize)
+
 	// DDoS protection - request size check
 	if s.config.EnableDDoSProtection && s.config.MaxRequestSize > 0 {
 		if msg.RequestSize > s.config.MaxRequestSize {
-			s.sendError(conn, msg.ID, fmt.Errorf("request too large: %d bytes", msg.RequestSize))
-			return
+			s.recordIPReject(clientIP, RejectReasonTooLarge)
+			return NewErrorResponse(msg.ID, fmt.Errorf("request too large: %d bytes", msg.RequestSize))
 		}
 	}
-	
+
+	// DDoS protection - reject IPs banned after the connection was accepted
+	if s.config.EnableDDoSProtection && s.IsBlacklisted(clientIP) {
+		s.recordIPReject(clientIP, RejectReasonBlacklisted)
+		return NewErrorResponse(msg.ID, fmt.Errorf("IP is blacklisted: %s", clientIP))
+	}
+
+	// DDoS protection - connectionless transports skip allowConnection,
+	// so the whitelist is enforced per request as well
+	if s.config.EnableDDoSProtection && !s.isWhitelisted(clientIP) {
+		s.recordIPReject(clientIP, RejectReasonNotWhitelisted)
+		return NewErrorResponse(msg.ID, fmt.Errorf("IP is not whitelisted: %s", clientIP))
+	}
+
 	// DDoS protection - rate limiting per IP
 	if s.config.EnableDDoSProtection && !s.checkRateLimit(clientIP) {
-		s.sendError(conn, msg.ID, fmt.Errorf("rate limit exceeded for IP: %s", clientIP))
-		return
-	}
-	
-	// Idempotency check
-	if s.config.EnableIdempotency && msg.IdempotencyKey != "" {
-		if result := s.checkIdempotency(msg); result != nil {
-			s.sendResponse(conn, result)
-			return
-		}
+		s.recordIPReject(clientIP, RejectReasonRateLimited)
+		s.recordRateLimitViolation(clientIP)
+		resp := NewErrorResponseWithCode(msg.ID, ErrCodeRateLimitExceeded, fmt.Errorf("rate limit exceeded for IP: %s", clientIP))
+		resp.RetryAfter = int64(s.rateLimitRetryAfter(clientIP))
+		return resp
 	}
 
-	ctx := context.Background()
+	if IdentityFrom(ctx) == "" {
+		ctx = WithIdentity(ctx, s.identity(msg))
+	}
 
 	switch msg.Type {
 	case MessageTypePing:
-		s.handlePing(conn, msg)
+		return s.handlePing(msg)
 
 	case MessageTypeExec:
-		response := s.handleExec(ctx, conn, msg)
-		if s.config.EnableIdempotency && msg.IdempotencyKey != "" {
-			s.storeIdempotency(msg, response)
-		}
+		return s.idempotent(ctx, msg, s.handleExec)
 
 	case MessageTypeQuery:
-		response := s.handleQuery(ctx, conn, msg)
-		if s.config.EnableIdempotency && msg.IdempotencyKey != "" {
-			s.storeIdempotency(msg, response)
-		}
+		return s.idempotent(ctx, msg, s.handleQuery)
+
+	case MessageTypeQueryRow:
+		return s.idempotent(ctx, msg, s.handleQueryRow)
+
+	case MessageTypeOpenCursor:
+		return s.handleOpenCursor(ctx, msg)
+
+	case MessageTypeFetch:
+		return s.handleFetch(ctx, msg)
+
+	case MessageTypeCloseCursor:
+		return s.handleCloseCursor(msg)
 
 	case MessageTypeStats:
-		s.handleStats(conn, msg)
+		return s.handleStats(msg)
 
 	case MessageTypeMetrics:
-		s.handleMetrics(conn, msg)
+		return s.handleMetrics(msg)
+
+	case MessageTypeMetricsQueries:
+		return s.handleMetricsQueries(msg)
+
+	case MessageTypeAdmin:
+		return s.handleAdmin(msg)
+
+	case MessageTypeStatsIPs:
+		return s.handleStatsIPs(msg)
+
+	case MessageTypeNextID:
+		return s.handleNextID(ctx, msg)
+
+	case MessageTypeSnapshot:
+		return s.handleSnapshot(ctx, msg)
+
+	case MessageTypeClose:
+		return nil
 
 	default:
-		s.sendError(conn, msg.ID, fmt.Errorf("unknown message type: %s", msg.Type))
+		return NewErrorResponse(msg.ID, fmt.Errorf("unknown message type: %s", msg.Type))
 	}
 }
 
 // handlePing handles a ping message
-func (s *TCPServer) handlePing(conn net.Conn, msg *TCPMessage) {
-	resp, err := NewSuccessResponse(msg.ID, map[string]string{"status": "ok"})
-	if err != nil {
-		s.sendError(conn, msg.ID, err)
-		return
-	}
-	s.sendResponse(conn, resp)
+func (s *TCPServer) handlePing(msg *TCPMessage) *TCPResponse {
+	return s.successResponse(msg.ID, map[string]string{"status": "ok"})
 }
 
 // handleExec handles an exec message
-func (s *TCPServer) handleExec(ctx context.Context, conn net.Conn, msg *TCPMessage) *TCPResponse {
+func (s *TCPServer) handleExec(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	ctx, cancel := s.requestContext(ctx, msg)
+	defer cancel()
+
 	result, err := s.runtime.Exec(ctx, msg.Query, msg.Args...)
 	if err != nil {
-		s.sendError(conn, msg.ID, err)
-		return nil
+		return s.queryErrorResponse(ctx, msg, err)
 	}
 
 	rowsAffected, _ := result.RowsAffected()
@@ -299,76 +583,128 @@ func (s *TCPServer) handleExec(ctx context.Context, conn net.Conn, msg *TCPMessa
 		LastInsertID: lastInsertID,
 	}
 
-	resp, err := NewSuccessResponse(msg.ID, execResult)
+	return s.successResponse(msg.ID, execResult)
+}
+
+// handleQuery handles a query message
+func (s *TCPServer) handleQuery(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	// Scanned rows and the encoded result count towards the memory budgets;
+	// a query is aborted as soon as it exceeds them
+	mem := s.memory.newRequest(s.config.MaxRequestMemory)
+	defer mem.release()
+
+	ctx, cancel := s.requestContext(ctx, msg)
+	defer cancel()
+
+	rows, err := s.runtime.Query(ctx, msg.Query, msg.Args...)
+	if err != nil {
+		return s.queryErrorResponse(ctx, msg, err)
+	}
+	defer rows.Close()
+
+	columns, results, err := scanRows(rows, func(row []interface{}) error {
+		return mem.reserve(estimateRowSize(row))
+	})
 	if err != nil {
-		s.sendError(conn, msg.ID, err)
-		return nil
+		return s.queryErrorResponse(ctx, msg, err)
 	}
 
-	s.sendResponse(conn, resp)
-	return resp
+	payload, err := json.Marshal(QueryResult{
+		Columns: columns,
+		Rows:    results,
+	})
+	if err != nil {
+		return NewErrorResponse(msg.ID, err)
+	}
+	if err := mem.reserve(int64(len(payload))); err != nil {
+		return s.queryErrorResponse(ctx, msg, err)
+	}
+
+	return &TCPResponse{ID: msg.ID, Success: true, Data: payload}
 }
 
-// handleQuery handles a query message
-func (s *TCPServer) handleQuery(ctx context.Context, conn net.Conn, msg *TCPMessage) *TCPResponse {
+// handleQueryRow handles a single-row query message. Only the first row
+// is read, so point lookups skip materializing and encoding a full result.
+func (s *TCPServer) handleQueryRow(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	mem := s.memory.newRequest(s.config.MaxRequestMemory)
+	defer mem.release()
+
+	ctx, cancel := s.requestContext(ctx, msg)
+	defer cancel()
+
 	rows, err := s.runtime.Query(ctx, msg.Query, msg.Args...)
 	if err != nil {
-		s.sendError(conn, msg.ID, err)
-		return nil
+		return s.queryErrorResponse(ctx, msg, err)
 	}
 	defer rows.Close()
 
 	columns, err := rows.Columns()
 	if err != nil {
-		s.sendError(conn, msg.ID, err)
-		return nil
+		return NewErrorResponse(msg.ID, err)
 	}
 
-	var results [][]interface{}
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return s.queryErrorResponse(ctx, msg, err)
 		}
+		return NewErrorResponseWithCode(msg.ID, ErrCodeNotFound, fmt.Errorf("no rows in result set"))
+	}
 
-		if err := rows.Scan(valuePtrs...); err != nil {
-			s.sendError(conn, msg.ID, err)
-			return nil
-		}
+	row, err := scanRow(rows, len(columns))
+	if err != nil {
+		return s.queryErrorResponse(ctx, msg, err)
+	}
+	if err := mem.reserve(estimateRowSize(row)); err != nil {
+		return s.queryErrorResponse(ctx, msg, err)
+	}
 
-		// Convert []byte to string for JSON serialization
-		for i, v := range values {
-			if b, ok := v.([]byte); ok {
-				values[i] = string(b)
-			}
-		}
+	return s.successResponse(msg.ID, QueryRowResult{Columns: columns, Row: row})
+}
 
-		results = append(results, values)
+// identity returns the client identity of a request
+func (s *TCPServer) identity(msg *TCPMessage) string {
+	if s.config.IdentityFunc != nil {
+		return s.config.IdentityFunc(msg)
 	}
+	return msg.ClientIP
+}
 
-	if err := rows.Err(); err != nil {
-		s.sendError(conn, msg.ID, err)
-		return nil
+// requestContext bounds ctx by the message timeout, capped by
+// MaxRequestTimeout. Without either the context is returned unchanged.
+func (s *TCPServer) requestContext(ctx context.Context, msg *TCPMessage) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(msg.Timeout)
+	if max := s.config.MaxRequestTimeout; max > 0 && (timeout <= 0 || timeout > max) {
+		timeout = max
 	}
-
-	queryResult := QueryResult{
-		Columns: columns,
-		Rows:    results,
+	if timeout <= 0 {
+		return ctx, func() {}
 	}
+	return context.WithTimeout(ctx, timeout)
+}
 
-	resp, err := NewSuccessResponse(msg.ID, queryResult)
-	if err != nil {
-		s.sendError(conn, msg.ID, err)
-		return nil
+// queryErrorResponse converts an EXEC or QUERY error into a response, giving
+// timeouts, memory limit and gate limit errors their error codes
+func (s *TCPServer) queryErrorResponse(ctx context.Context, msg *TCPMessage, err error) *TCPResponse {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return NewErrorResponseWithCode(msg.ID, ErrCodeTimeout, fmt.Errorf("request timed out: %w", err))
 	}
-
-	s.sendResponse(conn, resp)
-	return resp
+	if errors.Is(err, ErrMemoryLimitExceeded) {
+		log.Printf("Aborted query %s from %s: %v", msg.ID, msg.ClientIP, err)
+		s.recordIPReject(msg.ClientIP, RejectReasonMemoryLimit)
+		return NewErrorResponseWithCode(msg.ID, ErrCodeMemoryLimitExceeded, err)
+	}
+	// Gate rejections carry their code and when to retry, so clients can
+	// back off instead of retrying at once
+	if retryAfter := RetryAfter(err); retryAfter > 0 {
+		resp := NewErrorResponseWithCode(msg.ID, ErrorCode(err), err)
+		resp.RetryAfter = int64(retryAfter)
+		return resp
+	}
+	return NewErrorResponse(msg.ID, err)
 }
 
 // handleStats handles a stats message
-func (s *TCPServer) handleStats(conn net.Conn, msg *TCPMessage) {
+func (s *TCPServer) handleStats(msg *TCPMessage) *TCPResponse {
 	stats := s.runtime.Stats()
 
 	statsResult := StatsResult{
@@ -383,17 +719,11 @@ func (s *TCPServer) handleStats(conn net.Conn, msg *TCPMessage) {
 		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
 	}
 
-	resp, err := NewSuccessResponse(msg.ID, statsResult)
-	if err != nil {
-		s.sendError(conn, msg.ID, err)
-		return
-	}
-
-	s.sendResponse(conn, resp)
+	return s.successResponse(msg.ID, statsResult)
 }
 
 // handleMetrics handles a metrics message
-func (s *TCPServer) handleMetrics(conn net.Conn, msg *TCPMessage) {
+func (s *TCPServer) handleMetrics(msg *TCPMessage) *TCPResponse {
 	metrics := s.runtime.Metrics()
 
 	metricsResult := MetricsResult{
@@ -402,15 +732,159 @@ func (s *TCPServer) handleMetrics(conn net.Conn, msg *TCPMessage) {
 		FailedQueries:     metrics.FailedQueries,
 		SlowQueries:       metrics.SlowQueries,
 		AverageQueryTime:  metrics.AverageQueryTime.Nanoseconds(),
+		GateWait:          metrics.GateWait,
+		Cache:             metrics.Cache,
 	}
 
-	resp, err := NewSuccessResponse(msg.ID, metricsResult)
-	if err != nil {
-		s.sendError(conn, msg.ID, err)
-		return
+	return s.successResponse(msg.ID, metricsResult)
+}
+
+// handleMetricsQueries handles a per-statement metrics message
+func (s *TCPServer) handleMetricsQueries(msg *TCPMessage) *TCPResponse {
+	req := QueryMetricsRequest{Limit: 10}
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return NewErrorResponse(msg.ID, fmt.Errorf("invalid metrics payload: %w", err))
+		}
+	}
+	if req.Limit <= 0 || req.Limit > maxQueryFingerprints {
+		req.Limit = 10
 	}
 
-	s.sendResponse(conn, resp)
+	return s.successResponse(msg.ID, s.runtime.TopQueries(req.Limit))
+}
+
+// handleAdmin handles an admin message
+func (s *TCPServer) handleAdmin(msg *TCPMessage) *TCPResponse {
+	if s.config.AdminToken == "" {
+		return NewErrorResponse(msg.ID, fmt.Errorf("admin commands are disabled"))
+	}
+
+	var cmd AdminCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		return NewErrorResponse(msg.ID, fmt.Errorf("invalid admin payload: %w", err))
+	}
+
+	if subtle.ConstantTimeCompare([]byte(cmd.Token), []byte(s.config.AdminToken)) != 1 {
+		log.Printf("Rejected admin command from %s: invalid token", msg.ClientIP)
+		return NewErrorResponse(msg.ID, fmt.Errorf("admin authorization failed"))
+	}
+
+	result := AdminResult{Action: cmd.Action}
+	switch cmd.Action {
+	case AdminActionBlacklistAdd:
+		if cmd.IP == "" {
+			return NewErrorResponse(msg.ID, fmt.Errorf("ip is required"))
+		}
+		s.BlacklistIP(cmd.IP, time.Duration(cmd.TTL))
+		result.Changed = true
+	case AdminActionBlacklistRemove:
+		if cmd.IP == "" {
+			return NewErrorResponse(msg.ID, fmt.Errorf("ip is required"))
+		}
+		result.Changed = s.UnblacklistIP(cmd.IP)
+	case AdminActionBlacklistList:
+		result.Blacklist = s.BlacklistEntries()
+	case AdminActionProtectionGet:
+		settings := s.ProtectionSettings()
+		result.Protection = &settings
+	case AdminActionProtectionUpdate:
+		if cmd.Protection == nil {
+			return NewErrorResponse(msg.ID, fmt.Errorf("protection is required"))
+		}
+		settings, err := s.UpdateProtection(*cmd.Protection)
+		if err != nil {
+			return NewErrorResponse(msg.ID, err)
+		}
+		log.Printf("Protection settings updated by %s: %+v", msg.ClientIP, settings)
+		result.Changed = true
+		result.Protection = &settings
+	case AdminActionGateGet:
+		settings := s.runtime.GateSettings()
+		result.Gate = &settings
+	case AdminActionGateUpdate:
+		if cmd.Gate == nil {
+			return NewErrorResponse(msg.ID, fmt.Errorf("gate is required"))
+		}
+		settings, err := s.runtime.UpdateGateConfig(*cmd.Gate)
+		if err != nil {
+			return NewErrorResponse(msg.ID, err)
+		}
+		log.Printf("Gate settings updated by %s: %+v", msg.ClientIP, settings)
+		result.Changed = true
+		result.Gate = &settings
+	default:
+		return NewErrorResponse(msg.ID, fmt.Errorf("unknown admin action: %s", cmd.Action))
+	}
+
+	return s.successResponse(msg.ID, result)
+}
+
+// handleStatsIPs handles a per-IP stats message. The statistics reveal
+// other clients' addresses, so the admin token is required when configured.
+func (s *TCPServer) handleStatsIPs(msg *TCPMessage) *TCPResponse {
+	var req IPStatsRequest
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return NewErrorResponse(msg.ID, fmt.Errorf("invalid stats payload: %w", err))
+		}
+	}
+
+	if s.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(req.Token), []byte(s.config.AdminToken)) != 1 {
+		log.Printf("Rejected per-IP stats request from %s: invalid token", msg.ClientIP)
+		return NewErrorResponse(msg.ID, fmt.Errorf("admin authorization failed"))
+	}
+
+	stats := s.GetIPStats()
+	if req.Limit > 0 && len(stats) > req.Limit {
+		stats = stats[:req.Limit]
+	}
+	return s.successResponse(msg.ID, stats)
+}
+
+// maxNextIDCount bounds the IDs returned by a single NEXT_ID message
+const maxNextIDCount = 1000
+
+// handleNextID handles a next ID message
+func (s *TCPServer) handleNextID(ctx context.Context, msg *TCPMessage) *TCPResponse {
+	var req NextIDRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return NewErrorResponse(msg.ID, fmt.Errorf("invalid next id payload: %w", err))
+	}
+
+	gen, ok := s.config.IDGenerators[req.Generator]
+	if !ok {
+		return NewErrorResponse(msg.ID, fmt.Errorf("unknown id generator: %s", req.Generator))
+	}
+
+	count := req.Count
+	if count <= 0 {
+		count = 1
+	}
+	if count > maxNextIDCount {
+		return NewErrorResponse(msg.ID, fmt.Errorf("count %d exceeds limit of %d", count, maxNextIDCount))
+	}
+
+	result := NextIDResult{Generator: req.Generator, IDs: make([]string, 0, count)}
+	for i := 0; i < count; i++ {
+		id, err := gen.NextID(ctx)
+		if err != nil {
+			return NewErrorResponse(msg.ID, err)
+		}
+		result.IDs = append(result.IDs, id)
+	}
+
+	return s.successResponse(msg.ID, result)
+}
+
+// successResponse builds a success response, falling back to an error
+// response if the result cannot be encoded
+func (s *TCPServer) successResponse(id string, data interface{}) *TCPResponse {
+	resp, err := NewSuccessResponse(id, data)
+	if err != nil {
+		return NewErrorResponse(id, err)
+	}
+	return resp
 }
 
 // sendResponse sends a response to the client
@@ -421,9 +895,32 @@ func (s *TCPServer) sendResponse(conn net.Conn, resp *TCPResponse) {
 		return
 	}
 
-	if _, err := conn.Write(data); err != nil {
+	if lc, ok := conn.(*lockedConn); ok {
+		lc.writeMu.Lock()
+		defer lc.writeMu.Unlock()
+		conn = lc.Conn
+	}
+
+	if err := WriteMessageChunked(conn, data, s.config.WriteChunkSize); err != nil {
 		log.Printf("Failed to write response: %v", err)
+		return
 	}
+	s.recordIPBytesOut(s.getClientIP(conn), int64(len(data)))
+}
+
+// limitResponse replaces a response that encodes to more than
+// MaxResponseSize bytes with a RESPONSE_TOO_LARGE error
+func (s *TCPServer) limitResponse(resp *TCPResponse) *TCPResponse {
+	if resp == nil || s.config.MaxResponseSize <= 0 {
+		return resp
+	}
+	data, err := EncodeTCPResponse(resp)
+	if err != nil || int64(len(data)) <= s.config.MaxResponseSize {
+		return resp
+	}
+	log.Printf("Response %s too large: %d bytes", resp.ID, len(data))
+	return NewErrorResponseWithCode(resp.ID, ErrCodeResponseTooLarge,
+		fmt.Errorf("response too large: %d bytes exceeds limit of %d", len(data), s.config.MaxResponseSize))
 }
 
 // getClientIP extracts the real client IP address
@@ -436,82 +933,547 @@ func (s *TCPServer) getClientIP(conn net.Conn) string {
 	return host
 }
 
-// allowConnection checks if a connection from the IP should be allowed
-func (s *TCPServer) allowConnection(clientIP string) bool {
+// allowConnection checks if a connection from the IP should be allowed.
+// It returns the reject reason, or an empty string if the connection is
+// allowed; allowed connections must be released with releaseConnection.
+func (s *TCPServer) allowConnection(clientIP string) string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Check blacklist
-	if s.blacklistMap[clientIP] {
-		return false
+	if s.isBlacklistedLocked(clientIP, time.Now()) {
+		return RejectReasonBlacklisted
 	}
 
 	// If whitelist exists and IP not in it, deny
 	if len(s.whitelistMap) > 0 && !s.whitelistMap[clientIP] {
-		return false
+		return RejectReasonNotWhitelisted
 	}
 
-	// Check connections per IP limit
-	if s.config.MaxConnectionsPerIP > 0 {
-		if s.ipConnections[clientIP] >= s.config.MaxConnectionsPerIP {
-			return false
-		}
-		s.ipConnections[clientIP]++
+	// Check connections per IP limit. Connections are counted even without
+	// a limit so one can be set at runtime.
+	if limit := s.protection.MaxConnectionsPerIP; limit > 0 && s.ipConnections[clientIP] >= limit {
+		return RejectReasonConnectionLimit
 	}
+	s.ipConnections[clientIP]++
 
-	return true
+	return ""
 }
 
-// checkRateLimit checks if request is within rate limit for IP
-func (s *TCPServer) checkRateLimit(clientIP string) bool {
-	if s.config.RateLimitPerIP <= 0 {
-		return true
+// releaseConnection frees the per-IP connection slot taken by allowConnection
+func (s *TCPServer) releaseConnection(clientIP string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := s.ipConnections[clientIP]; n > 1 {
+		s.ipConnections[clientIP] = n - 1
+	} else {
+		delete(s.ipConnections, clientIP)
 	}
+}
 
+// checkRateLimit checks if request is within rate limit for IP using a
+// token bucket refilled at the per-IP rate (see ipRateLimit)
+func (s *TCPServer) checkRateLimit(clientIP string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	now := time.Now()
-	lastRequest, exists := s.ipRateLimits[clientIP]
-	
-	if !exists || lastRequest == nil {
-		s.ipRateLimits[clientIP] = &now
+	rate := s.ipRateLimit(now)
+	if rate <= 0 {
 		return true
 	}
 
-	// Simple rate limiting - one request per second per IP
-	if now.Sub(*lastRequest) < time.Second {
+	burst := s.rateLimitBurst(rate)
+	bucket, exists := s.ipRateLimits[clientIP]
+	if !exists {
+		bucket = &ipTokenBucket{tokens: burst, lastRefill: now}
+		s.ipRateLimits[clientIP] = bucket
+	}
+
+	elapsed := now.Sub(bucket.lastRefill).Seconds()
+	bucket.tokens = math.Min(burst, bucket.tokens+elapsed*rate)
+	bucket.lastRefill = now
+
+	if bucket.tokens < 1 {
 		return false
 	}
+	bucket.tokens--
+	return true
+}
+
+// rateLimitRetryAfter returns the time until the bucket of an IP has a
+// token again
+func (s *TCPServer) rateLimitRetryAfter(clientIP string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rate := s.ipRateLimit(time.Now())
+	bucket, ok := s.ipRateLimits[clientIP]
+	if !ok || bucket.tokens >= 1 || rate <= 0 {
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+}
+
+// ipRateLimit returns the requests per second allowed to each IP, 0 for no
+// limit: RateLimitPerIP, or with ShareGateBudget the gate's rate divided
+// among the active IPs, capped by RateLimitPerIP. The caller must hold s.mu.
+func (s *TCPServer) ipRateLimit(now time.Time) float64 {
+	rate := float64(s.protection.RateLimitPerIP)
+	if !s.config.ShareGateBudget || s.runtime == nil || s.runtime.gate == nil {
+		return rate
+	}
+
+	share := s.runtime.gate.Rate() / float64(s.activeIPCount(now))
+	if rate > 0 {
+		share = min(share, rate)
+	}
+	return share
+}
+
+// activeIPCount returns the number of IPs with a request within
+// sharedBudgetWindow, counted at most every sharedBudgetRecount. It is at
+// least 1. The caller must hold s.mu.
+func (s *TCPServer) activeIPCount(now time.Time) int {
+	if now.Sub(s.activeIPsAt) < sharedBudgetRecount && s.activeIPs > 0 {
+		return s.activeIPs
+	}
+
+	active := 0
+	for _, bucket := range s.ipRateLimits {
+		if now.Sub(bucket.lastRefill) < sharedBudgetWindow {
+			active++
+		}
+	}
+	s.activeIPs, s.activeIPsAt = max(active, 1), now
+	return s.activeIPs
+}
+
+// rateLimitBurst returns the per-IP bucket size for a per-IP rate, at least
+// one request. The caller must hold s.mu.
+func (s *TCPServer) rateLimitBurst(rate float64) float64 {
+	if s.protection.RateLimitBurstPerIP > 0 {
+		return float64(s.protection.RateLimitBurstPerIP)
+	}
+	return max(rate, 1)
+}
+
+// cleanupLoop periodically drops per-IP state that no longer matters
+func (s *TCPServer) cleanupLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(ipStateCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.cleanupIPState(time.Now())
+			s.purgeIdempotencyStore()
+			s.cleanupSnapshots(time.Now(), false)
+			s.cleanupCursors(time.Now(), false)
+		case <-s.shutdown:
+			return
+		}
+	}
+}
+
+// purgeIdempotencyStore removes expired entries from stores that need it
+func (s *TCPServer) purgeIdempotencyStore() {
+	purger, ok := s.idempotencyStore.(idempotencyPurger)
+	if !ok {
+		return
+	}
+	if _, err := purger.Purge(context.Background()); err != nil {
+		log.Printf("Idempotency store purge failed: %v", err)
+	}
+}
+
+// cleanupIPState removes full (idle) token buckets, stale violation
+// windows and expired bans
+func (s *TCPServer) cleanupIPState(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rate := s.ipRateLimit(now); rate > 0 {
+		// A bucket idle long enough to refill completely is equivalent to a new one
+		refillTime := time.Duration(s.rateLimitBurst(rate) / rate * float64(time.Second))
+		for ip, bucket := range s.ipRateLimits {
+			if now.Sub(bucket.lastRefill) >= refillTime {
+				delete(s.ipRateLimits, ip)
+			}
+		}
+	}
+
+	for ip, v := range s.ipViolations {
+		if now.Sub(v.windowStart) > rateLimitViolationWindow {
+			delete(s.ipViolations, ip)
+		}
+	}
+
+	for ip := range s.blacklistMap {
+		s.isBlacklistedLocked(ip, now)
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	for ip, st := range s.ipStats {
+		if st.ActiveConnections == 0 && now.Sub(st.LastSeen) > ipStatsRetention {
+			delete(s.ipStats, ip)
+		}
+	}
+}
+
+// recordRateLimitViolation counts a rate limit violation and bans the IP
+// once it exceeds RateLimitBanThreshold within the violation window
+func (s *TCPServer) recordRateLimitViolation(clientIP string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.protection.RateLimitBanThreshold <= 0 {
+		return
+	}
+
+	now := time.Now()
+	v, exists := s.ipViolations[clientIP]
+	if !exists || now.Sub(v.windowStart) > rateLimitViolationWindow {
+		v = &rateLimitViolations{windowStart: now}
+		s.ipViolations[clientIP] = v
+	}
+	v.count++
+
+	if v.count < s.protection.RateLimitBanThreshold {
+		return
+	}
+
+	duration := s.protection.RateLimitBanDuration
+	if duration <= 0 {
+		duration = defaultRateLimitBanDuration
+	}
+	s.blacklistMap[clientIP] = now.Add(duration)
+	delete(s.ipViolations, clientIP)
+	log.Printf("IP %s banned for %v after %d rate limit violations", clientIP, duration, v.count)
+}
+
+// BlacklistIP bans an IP address. A ttl <= 0 bans the IP permanently.
+func (s *TCPServer) BlacklistIP(ip string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	s.blacklistMap[ip] = expiresAt
+}
+
+// UnblacklistIP lifts a ban and reports whether the IP was banned
+func (s *TCPServer) UnblacklistIP(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	banned := s.isBlacklistedLocked(ip, time.Now())
+	delete(s.blacklistMap, ip)
+	delete(s.ipViolations, ip)
+	return banned
+}
+
+// IsBlacklisted reports whether an IP address is currently banned
+func (s *TCPServer) IsBlacklisted(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isBlacklistedLocked(ip, time.Now())
+}
+
+// BlacklistEntries returns the currently active bans
+func (s *TCPServer) BlacklistEntries() []BlacklistEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]BlacklistEntry, 0, len(s.blacklistMap))
+	for ip := range s.blacklistMap {
+		if !s.isBlacklistedLocked(ip, now) {
+			continue
+		}
+		expiresAt := s.blacklistMap[ip]
+		entries = append(entries, BlacklistEntry{
+			IP:        ip,
+			Permanent: expiresAt.IsZero(),
+			ExpiresAt: expiresAt,
+		})
+	}
+	return entries
+}
+
+// ipStatsLocked returns the statistics entry for an IP, creating it if
+// needed. The caller must hold s.statsMu.
+func (s *TCPServer) ipStatsLocked(ip string) *IPStats {
+	st, exists := s.ipStats[ip]
+	if !exists {
+		st = &IPStats{IP: ip}
+		s.ipStats[ip] = st
+	}
+	st.LastSeen = time.Now()
+	return st
+}
 
-	s.ipRateLimits[clientIP] = &now
+// recordIPConnection counts an accepted connection
+func (s *TCPServer) recordIPConnection(ip string) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	st := s.ipStatsLocked(ip)
+	st.ActiveConnections++
+	st.TotalConnections++
+}
+
+// releaseIPConnection counts a closed connection
+func (s *TCPServer) releaseIPConnection(ip string) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if st := s.ipStatsLocked(ip); st.ActiveConnections > 0 {
+		st.ActiveConnections--
+	}
+}
+
+// recordIPRequest counts a request and its size
+func (s *TCPServer) recordIPRequest(ip string, size int64) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	st := s.ipStatsLocked(ip)
+	st.Requests++
+	st.BytesIn += size
+}
+
+// recordIPBytesOut counts bytes written to a client
+func (s *TCPServer) recordIPBytesOut(ip string, n int64) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.ipStatsLocked(ip).BytesOut += n
+}
+
+// recordIPReject counts a rejected connection or request
+func (s *TCPServer) recordIPReject(ip, reason string) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	st := s.ipStatsLocked(ip)
+	if st.Rejects == nil {
+		st.Rejects = make(map[string]int64)
+	}
+	st.Rejects[reason]++
+}
+
+// GetIPStats returns a snapshot of the per-IP statistics, busiest IPs first
+func (s *TCPServer) GetIPStats() []IPStats {
+	s.statsMu.Lock()
+	stats := make([]IPStats, 0, len(s.ipStats))
+	for _, st := range s.ipStats {
+		snapshot := *st
+		if st.Rejects != nil {
+			snapshot.Rejects = make(map[string]int64, len(st.Rejects))
+			for reason, n := range st.Rejects {
+				snapshot.Rejects[reason] = n
+			}
+		}
+		stats = append(stats, snapshot)
+	}
+	s.statsMu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Requests != stats[j].Requests {
+			return stats[i].Requests > stats[j].Requests
+		}
+		return stats[i].IP < stats[j].IP
+	})
+	return stats
+}
+
+// isWhitelisted reports whether an IP passes the whitelist. An empty
+// whitelist allows every IP.
+func (s *TCPServer) isWhitelisted(ip string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.whitelistMap) == 0 || s.whitelistMap[ip]
+}
+
+// isBlacklistedLocked checks the blacklist and drops expired bans.
+// The caller must hold s.mu.
+func (s *TCPServer) isBlacklistedLocked(ip string, now time.Time) bool {
+	expiresAt, exists := s.blacklistMap[ip]
+	if !exists {
+		return false
+	}
+	if !expiresAt.IsZero() && now.After(expiresAt) {
+		delete(s.blacklistMap, ip)
+		return false
+	}
 	return true
 }
 
+// idempotent handles msg once per idempotency key: a retry gets the stored
+// response, and copies of the request arriving while it runs wait for it and
+// share its response
+func (s *TCPServer) idempotent(ctx context.Context, msg *TCPMessage, handle func(context.Context, *TCPMessage) *TCPResponse) *TCPResponse {
+	if !s.config.EnableIdempotency || msg.IdempotencyKey == "" {
+		return handle(ctx, msg)
+	}
+
+	lookup := func() (*TCPResponse, bool, error) {
+		response := s.checkIdempotency(msg)
+		return response, response != nil, nil
+	}
+	// A different request reusing the key must not share the response
+	key := msg.IdempotencyKey + "\x00" + RequestFingerprint(msg)
+	response, shared, err := getOrLoad(ctx, &s.idempotencyFlight, key, lookup, func() (*TCPResponse, error) {
+		reserved, response := s.reserveIdempotency(msg)
+		if response != nil {
+			return response, nil
+		}
+		response = handle(ctx, msg)
+		s.storeIdempotency(msg, response)
+		if reserved && (response == nil || !response.Success) {
+			s.releaseIdempotency(msg)
+		}
+		return response, nil
+	})
+	if err != nil {
+		return NewErrorResponse(msg.ID, err)
+	}
+	if shared && response.ID != msg.ID {
+		// Shared responses answer this copy, not the one that ran
+		replay := *response
+		replay.ID = msg.ID
+		return &replay
+	}
+	return response
+}
+
 // checkIdempotency checks if request has been processed before
 func (s *TCPServer) checkIdempotency(msg *TCPMessage) *TCPResponse {
-	if s.idempotencyCache == nil || msg.IdempotencyKey == "" {
+	if s.idempotencyStore == nil || msg.IdempotencyKey == "" {
+		return nil
+	}
+
+	rec, ok, err := s.idempotencyStore.Get(context.Background(), msg.IdempotencyKey)
+	if err != nil {
+		// Executing again is preferable to failing every request while the store is down
+		log.Printf("Idempotency store lookup failed for key %s: %v", msg.IdempotencyKey, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return s.replayIdempotency(msg, rec)
+}
+
+// replayIdempotency returns the response to msg from the record stored for
+// its key, or nil if msg should run
+func (s *TCPServer) replayIdempotency(msg *TCPMessage, rec *IdempotencyRecord) *TCPResponse {
+	// Records without a fingerprint predate fingerprinting and are trusted
+	if rec.Fingerprint != "" && rec.Fingerprint != RequestFingerprint(msg) {
+		log.Printf("Idempotency key %s reused with a different request from %s", msg.IdempotencyKey, msg.ClientIP)
+		return NewErrorResponseWithCode(msg.ID, ErrCodeIdempotencyConflict,
+			fmt.Errorf("idempotency key %s was already used for a different request", msg.IdempotencyKey))
+	}
+
+	if rec.Pending {
+		return NewErrorResponseWithCode(msg.ID, ErrCodeServerBusy,
+			fmt.Errorf("request with idempotency key %s is in progress", msg.IdempotencyKey))
+	}
+	if rec.Response == nil {
 		return nil
 	}
 
-	ctx := context.Background()
-	if cached, ok := s.idempotencyCache.Get(ctx, msg.IdempotencyKey); ok {
-		if response, ok := cached.(*TCPResponse); ok {
-			log.Printf("Returning cached response for idempotency key: %s", msg.IdempotencyKey)
-			return response
+	if rec.Digest != "" {
+		if msg.Type == MessageTypeQuery || msg.Type == MessageTypeQueryRow {
+			// A read is safe to run again
+			return nil
 		}
+		return NewErrorResponseWithCode(msg.ID, ErrCodeNotReplayable,
+			fmt.Errorf("request with idempotency key %s was already processed; its %d byte response (sha256 %s) was too large to keep",
+				msg.IdempotencyKey, rec.Size, rec.Digest))
+	}
+
+	log.Printf("Returning cached response for idempotency key: %s", msg.IdempotencyKey)
+	// Replayed responses answer the retry, not the original request
+	replay := *rec.Response
+	replay.ID = msg.ID
+	return &replay
+}
+
+// reserveIdempotency reserves the key of msg in a store shared between
+// servers. When another request holds the key, it returns the response to
+// send instead of running msg.
+func (s *TCPServer) reserveIdempotency(msg *TCPMessage) (bool, *TCPResponse) {
+	reserver, ok := s.idempotencyStore.(IdempotencyReserver)
+	if !ok {
+		return false, nil
+	}
+
+	rec, reserved, err := reserver.Reserve(context.Background(), msg.IdempotencyKey, RequestFingerprint(msg), s.idempotencyReserveTTL())
+	if err != nil {
+		log.Printf("Idempotency store reservation failed for key %s: %v", msg.IdempotencyKey, err)
+		return false, nil
+	}
+	if reserved {
+		return true, nil
+	}
+	if rec == nil {
+		// Released or expired since the reservation was refused
+		return false, NewErrorResponseWithCode(msg.ID, ErrCodeServerBusy,
+			fmt.Errorf("request with idempotency key %s is in progress", msg.IdempotencyKey))
+	}
+	return false, s.replayIdempotency(msg, rec)
+}
+
+// releaseIdempotency drops the reservation of a failed request
+func (s *TCPServer) releaseIdempotency(msg *TCPMessage) {
+	if err := s.idempotencyStore.(IdempotencyReserver).Release(context.Background(), msg.IdempotencyKey); err != nil {
+		log.Printf("Failed to release idempotency key %s: %v", msg.IdempotencyKey, err)
 	}
-	return nil
 }
 
 // storeIdempotency stores the response for future idempotency checks
 func (s *TCPServer) storeIdempotency(msg *TCPMessage, response *TCPResponse) {
-	if s.idempotencyCache == nil || msg.IdempotencyKey == "" || response == nil {
+	if s.idempotencyStore == nil || msg.IdempotencyKey == "" || response == nil || !response.Success {
 		return
 	}
 
-	ctx := context.Background()
-	s.idempotencyCache.Set(ctx, msg.IdempotencyKey, response, 300*time.Second) // 5 minutes
+	rec := newIdempotencyRecord(msg, response, s.idempotencyMaxResponse())
+	if err := s.idempotencyStore.Put(context.Background(), msg.IdempotencyKey, rec, s.idempotencyTTL()); err != nil {
+		log.Printf("Failed to store idempotency key %s: %v", msg.IdempotencyKey, err)
+	}
+}
+
+// idempotencyTTL returns how long idempotent responses are kept
+func (s *TCPServer) idempotencyTTL() time.Duration {
+	if s.config.IdempotencyTTL > 0 {
+		return s.config.IdempotencyTTL
+	}
+	return defaultIdempotencyTTL
+}
+
+// idempotencyReserveTTL returns how long a reservation holds a key, so a
+// server stopping mid-request does not block retries for the whole TTL
+func (s *TCPServer) idempotencyReserveTTL() time.Duration {
+	ttl := min(s.idempotencyTTL(), defaultIdempotencyTTL)
+	if s.config.MaxRequestTimeout > 0 {
+		ttl = min(ttl, s.config.MaxRequestTimeout)
+	}
+	return ttl
+}
+
+// idempotencyMaxResponse returns the largest response data kept for replay,
+// or 0 for no limit
+func (s *TCPServer) idempotencyMaxResponse() int64 {
+	switch {
+	case s.config.IdempotencyMaxResponse < 0:
+		return 0
+	case s.config.IdempotencyMaxResponse > 0:
+		return s.config.IdempotencyMaxResponse
+	}
+	return defaultIdempotencyMaxResponse
 }
 
 // sendError sends an error response to the client
@@ -549,6 +1511,15 @@ func ParseExecResult(data json.RawMessage) (*ExecResult, error) {
 	return &result, nil
 }
 
+// ParseQueryRowResult parses a query row result from response data
+func ParseQueryRowResult(data json.RawMessage) (*QueryRowResult, error) {
+	var result QueryRowResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 // ParseQueryResult parses query result from response data
 func ParseQueryResult(data json.RawMessage) (*QueryResult, error) {
 	var result QueryResult
@@ -558,6 +1529,33 @@ func ParseQueryResult(data json.RawMessage) (*QueryResult, error) {
 	return &result, nil
 }
 
+// ParseAdminResult parses admin result from response data
+func ParseAdminResult(data json.RawMessage) (*AdminResult, error) {
+	var result AdminResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ParseIPStats parses per-IP stats from response data
+func ParseIPStats(data json.RawMessage) ([]IPStats, error) {
+	var result []IPStats
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ParseNextIDResult parses next ID result from response data
+func ParseNextIDResult(data json.RawMessage) (*NextIDResult, error) {
+	var result NextIDResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 // ParseStatsResult parses stats result from response data
 func ParseStatsResult(data json.RawMessage) (*StatsResult, error) {
 	var result StatsResult
@@ -567,6 +1565,15 @@ func ParseStatsResult(data json.RawMessage) (*StatsResult, error) {
 	return &result, nil
 }
 
+// ParseQueryStats parses per-statement metrics from response data
+func ParseQueryStats(data json.RawMessage) ([]QueryStats, error) {
+	var result []QueryStats
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // ParseMetricsResult parses metrics result from response data
 func ParseMetricsResult(data json.RawMessage) (*MetricsResult, error) {
 	var result MetricsResult
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -247,6 +254,7 @@ func TestMetricsResult_Encoding(t *testing.T) {
 		FailedQueries:     50,
 		SlowQueries:       10,
 		AverageQueryTime:  5000000,
+		Cache:             &CacheStats{Items: 3, Hits: 30, Misses: 10},
 	}
 
 	resp, err := NewSuccessResponse("test", metricsResult)
@@ -266,6 +274,10 @@ func TestMetricsResult_Encoding(t *testing.T) {
 	if parsed.SuccessfulQueries != 950 {
 		t.Errorf("SuccessfulQueries mismatch: expected 950, got %d", parsed.SuccessfulQueries)
 	}
+
+	if parsed.Cache == nil || parsed.Cache.Hits != 30 || parsed.Cache.HitRate() != 75 {
+		t.Errorf("Cache mismatch: expected 30 hits at 75%%, got %+v", parsed.Cache)
+	}
 }
 
 func TestTCPClient_NextID(t *testing.T) {
@@ -328,3 +340,768 @@ func TestTCPServer_GetAddress(t *testing.T) {
 		t.Errorf("Address mismatch: expected 'localhost:19090', got '%s'", addr)
 	}
 }
+
+func TestTCPServer_BlacklistTTL(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:        "localhost:19090",
+		Runtime:        &DBRuntime{},
+		BlacklistedIPs: []string{"10.0.0.1"},
+	})
+
+	if !server.IsBlacklisted("10.0.0.1") {
+		t.Error("Startup blacklist entry should be banned")
+	}
+
+	server.BlacklistIP("10.0.0.2", 50*time.Millisecond)
+	if !server.IsBlacklisted("10.0.0.2") {
+		t.Error("IP should be banned before TTL expires")
+	}
+
+	if len(server.BlacklistEntries()) != 2 {
+		t.Errorf("Expected 2 blacklist entries, got %d", len(server.BlacklistEntries()))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if server.IsBlacklisted("10.0.0.2") {
+		t.Error("Ban should expire after TTL")
+	}
+
+	if !server.UnblacklistIP("10.0.0.1") {
+		t.Error("UnblacklistIP should report the IP was banned")
+	}
+	if server.IsBlacklisted("10.0.0.1") {
+		t.Error("IP should not be banned after removal")
+	}
+}
+
+func TestTCPServer_RateLimitAutoBan(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:               "localhost:19090",
+		Runtime:               &DBRuntime{},
+		EnableDDoSProtection:  true,
+		RateLimitBanThreshold: 3,
+		RateLimitBanDuration:  time.Minute,
+	})
+
+	for i := 0; i < 2; i++ {
+		server.recordRateLimitViolation("10.0.0.3")
+	}
+	if server.IsBlacklisted("10.0.0.3") {
+		t.Error("IP should not be banned below the threshold")
+	}
+
+	server.recordRateLimitViolation("10.0.0.3")
+	if !server.IsBlacklisted("10.0.0.3") {
+		t.Error("IP should be banned once the threshold is reached")
+	}
+
+	entries := server.BlacklistEntries()
+	if len(entries) != 1 || entries[0].Permanent {
+		t.Errorf("Expected one temporary ban, got %+v", entries)
+	}
+}
+
+func TestTCPServer_AdminBlacklist(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:    "localhost:0",
+		Runtime:    &DBRuntime{},
+		AdminToken: "secret",
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{
+		Address: server.GetAddress(),
+		Timeout: 5 * time.Second,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	if _, err := client.Admin(&AdminCommand{Action: AdminActionBlacklistList, Token: "wrong"}); err == nil {
+		t.Error("Admin command with invalid token should fail")
+	}
+
+	result, err := client.Admin(&AdminCommand{
+		Action: AdminActionBlacklistAdd,
+		Token:  "secret",
+		IP:     "10.0.0.4",
+		TTL:    int64(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add blacklist entry: %v", err)
+	}
+	if !result.Changed {
+		t.Error("Expected blacklist_add to report a change")
+	}
+
+	result, err = client.Admin(&AdminCommand{Action: AdminActionBlacklistList, Token: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to list blacklist: %v", err)
+	}
+	if len(result.Blacklist) != 1 || result.Blacklist[0].IP != "10.0.0.4" {
+		t.Errorf("Unexpected blacklist: %+v", result.Blacklist)
+	}
+
+	result, err = client.Admin(&AdminCommand{Action: AdminActionBlacklistRemove, Token: "secret", IP: "10.0.0.4"})
+	if err != nil {
+		t.Fatalf("Failed to remove blacklist entry: %v", err)
+	}
+	if !result.Changed || server.IsBlacklisted("10.0.0.4") {
+		t.Error("Expected IP to be removed from the blacklist")
+	}
+}
+
+func TestTCPServer_AdminProtectionUpdate(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:              "localhost:0",
+		Runtime:              &DBRuntime{},
+		EnableDDoSProtection: true,
+		AdminToken:           "secret",
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{
+		Address: server.GetAddress(),
+		Timeout: 5 * time.Second,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	negative := -1
+	if _, err := client.Admin(&AdminCommand{
+		Action:     AdminActionProtectionUpdate,
+		Token:      "secret",
+		Protection: &ProtectionUpdate{MaxConnectionsPerIP: &negative},
+	}); err == nil {
+		t.Error("Negative limits should be rejected")
+	}
+
+	maxConns, rate, burst := 1, int64(1), int64(1)
+	result, err := client.Admin(&AdminCommand{
+		Action: AdminActionProtectionUpdate,
+		Token:  "secret",
+		Protection: &ProtectionUpdate{
+			MaxConnectionsPerIP: &maxConns,
+			RateLimitPerIP:      &rate,
+			RateLimitBurstPerIP: &burst,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update protection: %v", err)
+	}
+	want := ProtectionSettings{MaxConnectionsPerIP: 1, RateLimitPerIP: 1, RateLimitBurstPerIP: 1}
+	if !result.Changed || result.Protection == nil || *result.Protection != want {
+		t.Errorf("Unexpected update result: %+v", result)
+	}
+	if server.ProtectionSettings() != want {
+		t.Errorf("Unexpected settings: %+v", server.ProtectionSettings())
+	}
+
+	// The live connection survives and is now rate limited
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Live connection should survive the update: %v", err)
+	}
+	if err := client.Ping(); err == nil {
+		t.Error("Expected the new rate limit to apply to the live connection")
+	}
+
+	// A second connection from the same IP exceeds the new limit
+	second := NewTCPClient(&TCPClientConfig{
+		Address: server.GetAddress(),
+		Timeout: 2 * time.Second,
+	})
+	if err := second.Connect(); err == nil {
+		defer second.Disconnect()
+		if err := second.Ping(); err == nil {
+			t.Error("Expected the new connection limit to reject a second connection")
+		}
+	}
+}
+
+func TestTCPServer_AdminGateUpdate(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().WithDSN("file:unused?mode=memory").WithRateLimit(100).Build())
+	server := NewTCPServer(&TCPServerConfig{
+		Address:    "localhost:0",
+		Runtime:    runtime,
+		AdminToken: "secret",
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{
+		Address: server.GetAddress(),
+		Timeout: 5 * time.Second,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	zero := 0.0
+	if _, err := client.Admin(&AdminCommand{
+		Action: AdminActionGateUpdate,
+		Token:  "secret",
+		Gate:   &GateUpdate{MaxRequestsPerSecond: &zero},
+	}); err == nil {
+		t.Error("A zero rate should be rejected")
+	}
+
+	rate := 25.0
+	result, err := client.Admin(&AdminCommand{
+		Action: AdminActionGateUpdate,
+		Token:  "secret",
+		Gate:   &GateUpdate{MaxRequestsPerSecond: &rate},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update the gate: %v", err)
+	}
+	if !result.Changed || result.Gate == nil || result.Gate.MaxRequestsPerSecond != 25 {
+		t.Errorf("Unexpected update result: %+v", result)
+	}
+
+	result, err = client.Admin(&AdminCommand{Action: AdminActionGateGet, Token: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to get the gate settings: %v", err)
+	}
+	if result.Gate == nil || *result.Gate != runtime.GateSettings() {
+		t.Errorf("Expected the runtime's gate settings, got %+v", result.Gate)
+	}
+}
+
+func TestTCPServer_TokenBucketRateLimit(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:             "localhost:19090",
+		Runtime:             &DBRuntime{},
+		RateLimitPerIP:      20,
+		RateLimitBurstPerIP: 5,
+	})
+
+	for i := 0; i < 5; i++ {
+		if !server.checkRateLimit("10.0.0.5") {
+			t.Fatalf("Request %d should be allowed within the burst", i+1)
+		}
+	}
+	if server.checkRateLimit("10.0.0.5") {
+		t.Error("Request beyond the burst should be rejected")
+	}
+
+	// Other IPs have their own bucket
+	if !server.checkRateLimit("10.0.0.6") {
+		t.Error("A different IP should not be affected")
+	}
+
+	// 20 tokens/sec refills one token every 50ms
+	time.Sleep(60 * time.Millisecond)
+	if !server.checkRateLimit("10.0.0.5") {
+		t.Error("Request should be allowed after refill")
+	}
+}
+
+func TestTCPServer_ShareGateBudget(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().WithDSN("file:unused?mode=memory").WithRateLimit(40).Build())
+	server := NewTCPServer(&TCPServerConfig{
+		Address:         "localhost:19090",
+		Runtime:         runtime,
+		ShareGateBudget: true,
+		RateLimitPerIP:  30,
+	})
+
+	// A single client gets the gate rate, capped by RateLimitPerIP
+	server.checkRateLimit("10.0.0.5")
+	server.mu.Lock()
+	rate := server.ipRateLimit(time.Now())
+	server.mu.Unlock()
+	if rate != 30 {
+		t.Errorf("Expected a single IP to get 30 req/s, got %v", rate)
+	}
+
+	// Active clients split the gate rate
+	for _, ip := range []string{"10.0.0.6", "10.0.0.7", "10.0.0.8"} {
+		server.checkRateLimit(ip)
+	}
+	later := time.Now().Add(sharedBudgetRecount)
+	server.mu.Lock()
+	rate = server.ipRateLimit(later)
+	server.mu.Unlock()
+	if rate != 10 {
+		t.Errorf("Expected 4 IPs to get 10 req/s each, got %v", rate)
+	}
+
+	// Idle clients give their share back
+	server.mu.Lock()
+	rate = server.ipRateLimit(later.Add(sharedBudgetWindow))
+	server.mu.Unlock()
+	if rate != 30 {
+		t.Errorf("Expected idle IPs to release their share, got %v", rate)
+	}
+}
+
+func TestTCPServer_RateLimitRetryAfter(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:              "localhost:19090",
+		Runtime:              &DBRuntime{},
+		EnableDDoSProtection: true,
+		RateLimitPerIP:       20,
+		RateLimitBurstPerIP:  1,
+	})
+	server.checkRateLimit("10.0.0.5")
+
+	resp := server.Process(context.Background(), &TCPMessage{ID: "1", Type: MessageTypePing, ClientIP: "10.0.0.5"})
+	if resp.Success || resp.Code != ErrCodeRateLimitExceeded {
+		t.Fatalf("Expected a rate limit error, got %+v", resp)
+	}
+	// 20 tokens/sec refills a token within 50ms
+	if resp.RetryAfter <= 0 || time.Duration(resp.RetryAfter) > 50*time.Millisecond {
+		t.Errorf("Expected a retry after of at most 50ms, got %v", time.Duration(resp.RetryAfter))
+	}
+	if got := RetryAfter(ResponseError("ping", resp)); got != time.Duration(resp.RetryAfter) {
+		t.Errorf("Expected the client error to carry the retry after, got %v", got)
+	}
+}
+
+func TestTCPServer_CleanupIPState(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:        "localhost:19090",
+		Runtime:        &DBRuntime{},
+		RateLimitPerIP: 10,
+	})
+
+	server.checkRateLimit("10.0.0.7")
+	server.BlacklistIP("10.0.0.8", time.Millisecond)
+
+	server.cleanupIPState(time.Now())
+	if len(server.ipRateLimits) != 1 {
+		t.Errorf("Recently used bucket should be kept, got %d buckets", len(server.ipRateLimits))
+	}
+
+	server.cleanupIPState(time.Now().Add(2 * time.Second))
+	if len(server.ipRateLimits) != 0 {
+		t.Errorf("Idle bucket should be removed, got %d buckets", len(server.ipRateLimits))
+	}
+	if len(server.blacklistMap) != 0 {
+		t.Errorf("Expired ban should be removed, got %d entries", len(server.blacklistMap))
+	}
+}
+
+func TestTCPServer_AcquireRequestSlot(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:                            "localhost:19090",
+		Runtime:                            &DBRuntime{},
+		MaxConcurrentRequestsPerConnection: 1,
+	})
+
+	inflight := make(chan struct{}, 1)
+	if !server.acquireRequestSlot(inflight) {
+		t.Fatal("First slot should be acquired")
+	}
+	if server.acquireRequestSlot(inflight) {
+		t.Error("Excess request should be rejected without queueing")
+	}
+
+	server.config.QueueExcessRequests = true
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-inflight
+	}()
+	if !server.acquireRequestSlot(inflight) {
+		t.Error("Queued request should acquire the slot once it is released")
+	}
+}
+
+func TestResponseError_Code(t *testing.T) {
+	resp := NewErrorResponseWithCode("1", ErrCodeServerBusy, fmt.Errorf("busy"))
+
+	var dbErr *DatabaseError
+	if !errors.As(ResponseError("exec", resp), &dbErr) || dbErr.Code != ErrCodeServerBusy {
+		t.Errorf("Expected DatabaseError with code %s", ErrCodeServerBusy)
+	}
+
+	plain := ResponseError("exec", NewErrorResponse("2", fmt.Errorf("boom")))
+	if plain.Error() != "exec failed: boom" {
+		t.Errorf("Unexpected error message: %v", plain)
+	}
+}
+
+func TestReadMessageLine(t *testing.T) {
+	input := "short\n" + strings.Repeat("x", 100) + "\nafter\nlast"
+	reader := bufio.NewReaderSize(strings.NewReader(input), 16)
+
+	line, err := ReadMessageLine(reader, 10)
+	if err != nil || string(line) != "short" {
+		t.Fatalf("Expected 'short', got %q (%v)", line, err)
+	}
+
+	if _, err := ReadMessageLine(reader, 10); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("Expected ErrMessageTooLarge, got %v", err)
+	}
+
+	// The oversized message is discarded and the stream stays usable
+	line, err = ReadMessageLine(reader, 10)
+	if err != nil || string(line) != "after" {
+		t.Fatalf("Expected 'after', got %q (%v)", line, err)
+	}
+
+	line, err = ReadMessageLine(reader, 10)
+	if err != nil || string(line) != "last" {
+		t.Fatalf("Expected 'last', got %q (%v)", line, err)
+	}
+
+	if _, err := ReadMessageLine(reader, 10); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+type countingWriter struct {
+	writes int
+	bytes.Buffer
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestWriteMessageChunked(t *testing.T) {
+	data := []byte(strings.Repeat("y", 25))
+	w := &countingWriter{}
+
+	if err := WriteMessageChunked(w, data, 10); err != nil {
+		t.Fatalf("WriteMessageChunked failed: %v", err)
+	}
+	if w.writes != 3 {
+		t.Errorf("Expected 3 writes, got %d", w.writes)
+	}
+	if w.String() != string(data) {
+		t.Error("Written data mismatch")
+	}
+}
+
+func TestTCPServer_MessageSizeLimits(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:         "localhost:0",
+		Runtime:         &DBRuntime{},
+		MaxRequestSize:  256,
+		MaxResponseSize: 1024,
+		WriteChunkSize:  16,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{
+		Address:        server.GetAddress(),
+		Timeout:        5 * time.Second,
+		WriteChunkSize: 8,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	// Oversized request is rejected by the server without closing the connection
+	if _, err := client.Exec(strings.Repeat("x", 1000)); err == nil || !strings.Contains(err.Error(), "request too large") {
+		t.Errorf("Expected request too large error, got %v", err)
+	}
+
+	// Chunked writes on both sides still produce intact messages
+	if err := client.Ping(); err != nil {
+		t.Errorf("Ping after oversized request failed: %v", err)
+	}
+
+	small := NewTCPClient(&TCPClientConfig{Address: server.GetAddress(), MaxRequestSize: 10})
+	if err := small.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer small.Disconnect()
+	if err := small.Ping(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected client-side ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestTCPServer_ProcessLimitsResponseSize(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{Address: "localhost:0", Runtime: &DBRuntime{}, MaxResponseSize: 20})
+
+	// The limit is applied by Process, so every transport gets it
+	resp := server.Process(context.Background(), &TCPMessage{Type: MessageTypePing, ID: "1", ClientIP: "10.0.0.1"})
+	if resp.Success || resp.Code != ErrCodeResponseTooLarge || resp.ID != "1" {
+		t.Errorf("Expected RESPONSE_TOO_LARGE, got %+v", resp)
+	}
+
+	server.config.MaxResponseSize = 1024
+	if resp := server.Process(context.Background(), &TCPMessage{Type: MessageTypePing, ID: "2", ClientIP: "10.0.0.1"}); !resp.Success {
+		t.Errorf("Expected a response under the limit to pass, got %+v", resp)
+	}
+}
+
+func TestTCPServer_IPStats(t *testing.T) {
+	server := NewTCPServer(&TCPServerConfig{
+		Address:              "localhost:0",
+		Runtime:              &DBRuntime{},
+		AdminToken:           "secret",
+		EnableDDoSProtection: true,
+		RateLimitPerIP:       1,
+		RateLimitBurstPerIP:  3,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{
+		Address: server.GetAddress(),
+		Timeout: 5 * time.Second,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	// Two pings and the stats request fit in the burst, the next is rejected
+	for i := 0; i < 2; i++ {
+		if err := client.Ping(); err != nil {
+			t.Fatalf("Ping failed: %v", err)
+		}
+	}
+	if _, err := client.IPStats("wrong", 0); err == nil {
+		t.Error("IP stats with invalid token should fail")
+	}
+	if err := client.Ping(); err == nil {
+		t.Error("Expected ping to be rate limited")
+	}
+
+	stats := server.GetIPStats()
+	if len(stats) != 1 {
+		t.Fatalf("Expected stats for 1 IP, got %+v", stats)
+	}
+	st := stats[0]
+	if st.ActiveConnections != 1 || st.TotalConnections != 1 {
+		t.Errorf("Unexpected connection counters: %+v", st)
+	}
+	if st.Requests != 4 || st.BytesIn == 0 || st.BytesOut == 0 {
+		t.Errorf("Unexpected request counters: %+v", st)
+	}
+	if st.Rejects[RejectReasonRateLimited] != 1 {
+		t.Errorf("Expected 1 rate limit reject, got %v", st.Rejects)
+	}
+
+	// Closed connections are no longer active
+	server.releaseIPConnection(st.IP)
+	if got := server.GetIPStats()[0].ActiveConnections; got != 0 {
+		t.Errorf("Expected 0 active connections, got %d", got)
+	}
+}
+
+func TestTCPClient_Failover(t *testing.T) {
+	var servers []*TCPServer
+	for i := 0; i < 2; i++ {
+		server := NewTCPServer(&TCPServerConfig{Address: "localhost:0", Runtime: &DBRuntime{}})
+		if err := server.Start(); err != nil {
+			t.Fatalf("Failed to start server: %v", err)
+		}
+		servers = append(servers, server)
+	}
+	running := map[*TCPServer]bool{servers[0]: true, servers[1]: true}
+	defer func() {
+		for server := range running {
+			server.Stop()
+		}
+	}()
+
+	// Reserve an address nothing listens on
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	unreachable := ln.Addr().String()
+	ln.Close()
+
+	client := NewTCPClient(&TCPClientConfig{
+		Addresses: []string{unreachable, servers[0].GetAddress(), servers[1].GetAddress()},
+		Timeout:   2 * time.Second,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	first := client.CurrentAddress()
+	if first == unreachable {
+		t.Fatal("Client should skip the unreachable address")
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	for _, server := range servers {
+		if server.GetAddress() == first {
+			server.Stop()
+			delete(running, server)
+		}
+	}
+
+	// The read-only request is resent to the remaining server
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping should fail over: %v", err)
+	}
+	if addr := client.CurrentAddress(); addr == first || addr == unreachable {
+		t.Errorf("Expected the client to move to the other server, still on %s", addr)
+	}
+}
+
+func TestAddressBalancer_Order(t *testing.T) {
+	now := time.Now()
+
+	rr := newAddressBalancer([]string{"a", "b", "c"}, LoadBalanceRoundRobin, time.Minute)
+	rr.next = 0
+	if got := rr.candidates(now); fmt.Sprint(got) != "[a b c]" {
+		t.Errorf("Expected [a b c], got %v", got)
+	}
+	if got := rr.candidates(now); fmt.Sprint(got) != "[b c a]" {
+		t.Errorf("Expected rotation to [b c a], got %v", got)
+	}
+
+	// Failed addresses are tried last until the cooldown expires
+	rr.markDown("c", now)
+	if got := rr.candidates(now); fmt.Sprint(got) != "[a b c]" {
+		t.Errorf("Expected the down address last, got %v", got)
+	}
+	rr.next = 2
+	if got := rr.candidates(now.Add(2 * time.Minute)); fmt.Sprint(got) != "[c a b]" {
+		t.Errorf("Expected [c a b] after the cooldown, got %v", got)
+	}
+
+	ll := newAddressBalancer([]string{"a", "b", "c"}, LoadBalanceLeastLatency, time.Minute)
+	ll.observe("a", 30*time.Millisecond)
+	ll.observe("b", 10*time.Millisecond)
+	ll.observe("c", 20*time.Millisecond)
+	if got := ll.candidates(now); fmt.Sprint(got) != "[b c a]" {
+		t.Errorf("Expected [b c a] by latency, got %v", got)
+	}
+	ll.markDown("b", now)
+	if got := ll.candidates(now); fmt.Sprint(got) != "[c a b]" {
+		t.Errorf("Expected [c a b] with b down, got %v", got)
+	}
+}
+
+func TestTCPServer_RequestTimeout(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:request_timeout?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	server := NewTCPServer(&TCPServerConfig{
+		Address:           "localhost:0",
+		Runtime:           runtime,
+		MaxRequestTimeout: time.Minute,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{
+		Address:        server.GetAddress(),
+		Timeout:        5 * time.Second,
+		RequestTimeout: 100 * time.Millisecond,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+	defer client.Disconnect()
+
+	// A query that never finishes is cancelled by the request deadline
+	start := time.Now()
+	_, err := client.Query("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT COUNT(*) FROM n")
+	var dbErr *DatabaseError
+	if !errors.As(err, &dbErr) || dbErr.Code != ErrCodeTimeout {
+		t.Fatalf("Expected TIMEOUT, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Query ran for %v despite the 100ms timeout", elapsed)
+	}
+
+	if err := client.Ping(); err != nil {
+		t.Errorf("Ping after timeout failed: %v", err)
+	}
+
+	// The server maximum caps larger timeouts and applies when none is given
+	for _, timeout := range []time.Duration{0, time.Hour} {
+		ctx, cancel := server.requestContext(context.Background(), &TCPMessage{Timeout: int64(timeout)})
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if !ok || time.Until(deadline) > time.Minute {
+			t.Errorf("Expected timeout %v to be capped at 1m, got deadline %v", timeout, deadline)
+		}
+	}
+}
+
+func TestTCPClient_QueryRow(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:query_row?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := runtime.Exec(ctx, "INSERT INTO users (id, name) VALUES (1, 'alice'), (2, 'bob')"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	server := NewTCPServer(&TCPServerConfig{Address: "localhost:0", Runtime: runtime})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client := NewTCPClient(&TCPClientConfig{Address: server.GetAddress(), Timeout: 5 * time.Second})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+	defer client.Disconnect()
+
+	result, err := client.QueryRow("SELECT id, name FROM users WHERE id = ?", 2)
+	if err != nil {
+		t.Fatalf("QueryRow failed: %v", err)
+	}
+	if len(result.Columns) != 2 || fmt.Sprint(result.Row) != "[2 bob]" {
+		t.Errorf("Expected [2 bob], got %v %v", result.Columns, result.Row)
+	}
+
+	// Only the first row is returned
+	result, err = client.QueryRow("SELECT name FROM users ORDER BY id")
+	if err != nil || fmt.Sprint(result.Row) != "[alice]" {
+		t.Errorf("Expected [alice], got %v (%v)", result, err)
+	}
+
+	_, err = client.QueryRow("SELECT name FROM users WHERE id = ?", 42)
+	if !IsNotFoundError(err) {
+		t.Errorf("Expected NOT_FOUND, got %v", err)
+	}
+
+	// Query errors are not reported as not found
+	if _, err := client.QueryRow("SELECT name FROM missing"); err == nil || IsNotFoundError(err) {
+		t.Errorf("Expected a query error, got %v", err)
+	}
+}
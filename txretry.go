@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/godror/godror"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// TxRetryPolicy configures the retry mode of QueryExecutor.Transaction.
+// Transactions that fail with a serialization failure or deadlock are
+// rolled back and the callback is run again in a new transaction, so the
+// callback must be safe to re-run.
+type TxRetryPolicy struct {
+	// MaxRetries is the number of re-runs after the first attempt (default 3)
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry (default 50ms). It
+	// doubles on every retry, with jitter so conflicting transactions spread out.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries (default 2s)
+	MaxBackoff time.Duration
+}
+
+func (p TxRetryPolicy) withDefaults() TxRetryPolicy {
+	if p.MaxRetries <= 0 {
+		p.MaxRetries = 3
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 50 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 2 * time.Second
+	}
+	return p
+}
+
+// backoff returns the jittered wait before retry number attempt (1-based)
+func (p TxRetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff << (attempt - 1)
+	if d <= 0 || d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	// Wait between half and the full backoff
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// WithTransactionRetry returns an executor whose Transaction retries
+// serialization failures and deadlocks according to policy
+func (qe *QueryExecutor) WithTransactionRetry(policy TxRetryPolicy) *QueryExecutor {
+	policy = policy.withDefaults()
+	return &QueryExecutor{runtime: qe.runtime, txRetry: &policy}
+}
+
+// IsSerializationFailure reports whether err is a transaction conflict that
+// is expected to succeed when the transaction is run again:
+//
+//	PostgreSQL: 40001 serialization_failure, 40P01 deadlock_detected
+//	MySQL:      1213 deadlock, 1205 lock wait timeout
+//	Oracle:     ORA-08177 cannot serialize access, ORA-00060 deadlock
+//	SQLite:     SQLITE_BUSY, SQLITE_LOCKED
+func IsSerializationFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1213 || myErr.Number == 1205
+	}
+	if oraErr, ok := godror.AsOraErr(err); ok {
+		return oraErr.Code() == 8177 || oraErr.Code() == 60
+	}
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code == sqlite3.ErrBusy || liteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// transactionWithRetry runs fn in transactions until one commits, the error
+// is not a serialization failure or the retry budget is spent
+func (qe *QueryExecutor) transactionWithRetry(ctx context.Context, fn func(*AdvancedTx) error) error {
+	policy := *qe.txRetry
+
+	var err error
+	for attempt := 0; attempt <= policy.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("transaction retry aborted: %w (last error: %v)", ctx.Err(), err)
+			case <-time.After(policy.backoff(attempt)):
+			}
+		}
+
+		err = qe.transaction(ctx, fn)
+		if !IsSerializationFailure(err) {
+			return err
+		}
+	}
+
+	return WrapError(ErrCodeRetryExhausted,
+		fmt.Sprintf("transaction failed after %d attempts", policy.MaxRetries+1), err)
+}
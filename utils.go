@@ -11,6 +11,7 @@ import (
 // QueryExecutor provides a convenient interface for executing queries
 type QueryExecutor struct {
 	runtime *DBRuntime
+	txRetry *TxRetryPolicy // nil disables transaction retries
 }
 
 // NewQueryExecutor creates a new query executor
@@ -27,7 +28,7 @@ func (qe *QueryExecutor) Select(ctx context.Context, query string, args []interf
 	defer rows.Close()
 
 	for rows.Next() {
-		if err := scanFunc(rows); err != nil {
+		if err := scanFunc(rows.Rows); err != nil {
 			return fmt.Errorf("scan failed: %w", err)
 		}
 	}
@@ -36,7 +37,7 @@ func (qe *QueryExecutor) Select(ctx context.Context, query string, args []interf
 }
 
 // SelectOne executes a SELECT query expecting exactly one row
-func (qe *QueryExecutor) SelectOne(ctx context.Context, query string, args []interface{}, scanFunc func(*sql.Row) error) error {
+func (qe *QueryExecutor) SelectOne(ctx context.Context, query string, args []interface{}, scanFunc func(*Row) error) error {
 	row := qe.runtime.QueryRow(ctx, query, args...)
 	return scanFunc(row)
 }
@@ -46,8 +47,18 @@ func (qe *QueryExecutor) Execute(ctx context.Context, query string, args ...inte
 	return qe.runtime.Exec(ctx, query, args...)
 }
 
-// Transaction executes a function within a transaction
+// Transaction executes a function within a transaction. If the executor
+// was created with WithTransactionRetry, serialization failures and
+// deadlocks re-run fn in a new transaction.
 func (qe *QueryExecutor) Transaction(ctx context.Context, fn func(*AdvancedTx) error) error {
+	if qe.txRetry != nil {
+		return qe.transactionWithRetry(ctx, fn)
+	}
+	return qe.transaction(ctx, fn)
+}
+
+// transaction runs fn in a single transaction
+func (qe *QueryExecutor) transaction(ctx context.Context, fn func(*AdvancedTx) error) (err error) {
 	tx, err := qe.runtime.Begin(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -68,12 +79,73 @@ func (qe *QueryExecutor) Transaction(ctx context.Context, fn func(*AdvancedTx) e
 	return err
 }
 
+// RowReader is implemented by *sql.Rows and *Rows
+type RowReader interface {
+	Columns() ([]string, error)
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+// ScanAllRows reads all remaining rows into memory. []byte values are
+// converted to strings so the result can be cached and JSON encoded.
+func ScanAllRows(rows RowReader) ([]string, [][]interface{}, error) {
+	return scanRows(rows, nil)
+}
+
+// scanRow scans the current row. []byte values are converted to strings.
+func scanRow(rows RowReader, columns int) ([]interface{}, error) {
+	values := make([]interface{}, columns)
+	ptrs := make([]interface{}, columns)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if b, ok := v.([]byte); ok {
+			values[i] = string(b)
+		}
+	}
+	return values, nil
+}
+
+// scanRows is ScanAllRows with a hook called for each scanned row. An error
+// from onRow stops the scan and is returned.
+func scanRows(rows RowReader, onRow func(row []interface{}) error) ([]string, [][]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var results [][]interface{}
+	for rows.Next() {
+		values, err := scanRow(rows, len(columns))
+		if err != nil {
+			return nil, nil, err
+		}
+		if onRow != nil {
+			if err := onRow(values); err != nil {
+				return nil, nil, err
+			}
+		}
+		results = append(results, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return columns, results, nil
+}
+
 // Diagnostics provides diagnostic information about the runtime
 type Diagnostics struct {
 	Runtime         *DBRuntime
 	ConnectionStats sql.DBStats
 	Metrics         MetricsStats
 	CircuitBreaker  string
+	CircuitHistory  []CircuitTransition // last circuit breaker transitions, oldest first
 	Timestamp       time.Time
 }
 
@@ -84,13 +156,14 @@ func GetDiagnostics(runtime *DBRuntime) *Diagnostics {
 		ConnectionStats: runtime.Stats(),
 		Metrics:         runtime.Metrics(),
 		CircuitBreaker:  runtime.CircuitBreakerState(),
+		CircuitHistory:  runtime.CircuitBreakerHistory(),
 		Timestamp:       time.Now(),
 	}
 }
 
 // String returns a formatted string representation of diagnostics
 func (d *Diagnostics) String() string {
-	return fmt.Sprintf(`Database Runtime Diagnostics
+	s := fmt.Sprintf(`Database Runtime Diagnostics
 ==========================
 Timestamp: %s
 Circuit Breaker: %s
@@ -130,6 +203,32 @@ Performance Metrics:
 		d.Metrics.AverageQueryTime,
 		d.Metrics.SlowQueries,
 	)
+
+	if c := d.Metrics.Cache; c != nil {
+		s += fmt.Sprintf(`
+Cache:
+  Items: %d
+  Hits: %d
+  Misses: %d
+  Hit Rate: %.2f%%
+  Evictions: %d
+  Expired: %d
+`, c.Items, c.Hits, c.Misses, c.HitRate(), c.Evictions, c.ExpiredCount)
+		if c.MaxBytes > 0 {
+			s += fmt.Sprintf("  Bytes: %d of %d\n", c.Bytes, c.MaxBytes)
+		}
+		if c.Errors > 0 {
+			s += fmt.Sprintf("  Backend Errors: %d\n", c.Errors)
+		}
+	}
+
+	if len(d.CircuitHistory) > 0 {
+		s += "\nCircuit Breaker History:\n"
+		for _, t := range d.CircuitHistory {
+			s += fmt.Sprintf("  %s %s -> %s: %s\n", t.Time.Format(time.RFC3339), t.From, t.To, t.Reason)
+		}
+	}
+	return s
 }
 
 // HealthStatus represents the health status of the runtime
@@ -3,8 +3,14 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 )
 
 func TestNewQueryExecutor(t *testing.T) {
@@ -160,3 +166,305 @@ func TestWithRetry_ContextCanceled(t *testing.T) {
 		t.Errorf("Expected context.Canceled, got %v", err)
 	}
 }
+
+func TestBindNamed(t *testing.T) {
+	params := map[string]interface{}{"id": 7, "name": "x"}
+	query := "SELECT ':id', \"a:b\" FROM t WHERE id = :id AND name = :name AND parent = :id -- :skip\nAND ts::date = /* :skip */ now()"
+
+	tests := []struct {
+		dbType DatabaseType
+		query  string
+		args   int
+	}{
+		{DatabaseTypePostgreSQL, "SELECT ':id', \"a:b\" FROM t WHERE id = $1 AND name = $2 AND parent = $1 -- :skip\nAND ts::date = /* :skip */ now()", 2},
+		{DatabaseTypeMySQL, "SELECT ':id', \"a:b\" FROM t WHERE id = ? AND name = ? AND parent = ? -- :skip\nAND ts::date = /* :skip */ now()", 3},
+		{DatabaseTypeOracle, query, 2},
+	}
+	for _, tt := range tests {
+		bound, args, err := BindNamed(tt.dbType, query, params)
+		if err != nil {
+			t.Fatalf("%s: BindNamed failed: %v", tt.dbType, err)
+		}
+		if bound != tt.query || len(args) != tt.args {
+			t.Errorf("%s: got %q with %d args", tt.dbType, bound, len(args))
+		}
+	}
+
+	if _, _, err := BindNamed(DatabaseTypeSQLite, "SELECT :missing", params); err == nil {
+		t.Error("Expected an error for a parameter without a value")
+	}
+
+	runtime := NewDBRuntime(&RuntimeConfig{DatabaseType: DatabaseTypeSQLite, DSN: ":memory:", MaxOpenConns: 1})
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE t (id INTEGER, name TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := runtime.ExecNamed(ctx, "INSERT INTO t VALUES (:id, :name)", params); err != nil {
+		t.Fatalf("ExecNamed failed: %v", err)
+	}
+	rows, err := runtime.QueryNamed(ctx, "SELECT name FROM t WHERE id = :id", params)
+	if err != nil {
+		t.Fatalf("QueryNamed failed: %v", err)
+	}
+	defer rows.Close()
+	_, results, err := ScanAllRows(rows)
+	if err != nil || len(results) != 1 || results[0][0] != "x" {
+		t.Errorf("Expected [[x]], got %v (%v)", results, err)
+	}
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain", errors.New("boom"), false},
+		{"postgres serialization", &pq.Error{Code: "40001"}, true},
+		{"postgres deadlock", &pq.Error{Code: "40P01"}, true},
+		{"postgres unique violation", &pq.Error{Code: "23505"}, false},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213}, true},
+		{"mysql lock wait", &mysql.MySQLError{Number: 1205}, true},
+		{"mysql duplicate key", &mysql.MySQLError{Number: 1062}, false},
+		{"sqlite busy", sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"sqlite constraint", sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{"wrapped", fmt.Errorf("update failed: %w", &pq.Error{Code: "40001"}), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSerializationFailure(tt.err); got != tt.want {
+				t.Errorf("IsSerializationFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionRetry(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:txretry?mode=memory&cache=shared").
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	if _, err := runtime.Exec(ctx, "CREATE TABLE counters (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	executor := NewQueryExecutor(runtime).WithTransactionRetry(TxRetryPolicy{
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+	})
+
+	// Conflicts are rolled back and retried until the callback succeeds
+	attempts := 0
+	err := executor.Transaction(ctx, func(tx *AdvancedTx) error {
+		attempts++
+		if _, err := tx.Exec(ctx, "INSERT INTO counters (id) VALUES (1)"); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return &pq.Error{Code: "40001"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	// Other errors are returned immediately
+	attempts = 0
+	err = executor.Transaction(ctx, func(tx *AdvancedTx) error {
+		attempts++
+		return errors.New("not a conflict")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Expected a single failed attempt, got %d attempts and error %v", attempts, err)
+	}
+
+	// The retry budget is bounded
+	attempts = 0
+	err = executor.Transaction(ctx, func(tx *AdvancedTx) error {
+		attempts++
+		return &mysql.MySQLError{Number: 1213}
+	})
+	var dbErr *DatabaseError
+	if !errors.As(err, &dbErr) || dbErr.Code != ErrCodeRetryExhausted {
+		t.Errorf("Expected RETRY_EXHAUSTED, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if !IsSerializationFailure(err) {
+		t.Error("Expected the last conflict to be wrapped")
+	}
+}
+
+func TestQueryFingerprint(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT id FROM users WHERE id = 42", "select id from users where id=?"},
+		{"select id\n  from users\twhere id=:1", "select id from users where id=?"},
+		{"SELECT id FROM users WHERE name = 'O''Brien' -- lookup", "select id from users where name=?"},
+		{"SELECT * FROM t WHERE id IN (1, 2, 3)", "select*from t where id in(?)"},
+		{"SELECT * FROM t WHERE id IN ($1, $2)", "select*from t where id in(?)"},
+		{"INSERT INTO t (a, b) VALUES (?, ?), (?, ?), (?, ?)", "insert into t(a,b)values(?)"},
+		{`SELECT "Name" FROM /* hint */ t WHERE x::int > 1.5`, `select "Name" from t where x::int>?`},
+	}
+
+	for _, tt := range tests {
+		if got := QueryFingerprint(tt.query); got != tt.want {
+			t.Errorf("QueryFingerprint(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDBMetrics_TopQueries(t *testing.T) {
+	m := NewDBMetrics(nil)
+	for i := 1; i <= 100; i++ {
+		m.RecordStatement(fmt.Sprintf("SELECT * FROM orders WHERE id = %d", i), time.Duration(i)*time.Millisecond, nil)
+	}
+	m.RecordStatement("SELECT 1", time.Millisecond, nil)
+	m.RecordStatement("UPDATE stock SET n = n - 1 WHERE id = ?", time.Millisecond, errors.New("deadlock"))
+	m.RecordStatement("UPDATE stock SET n = n - 1 WHERE id = ?", time.Millisecond, nil)
+
+	top := m.TopQueries(2)
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(top))
+	}
+	orders := top[0]
+	if orders.Fingerprint != "select*from orders where id=?" || orders.Calls != 100 {
+		t.Fatalf("Expected the orders query first with 100 calls, got %+v", orders)
+	}
+	if orders.P50 != 50*time.Millisecond || orders.P95 != 95*time.Millisecond ||
+		orders.P99 != 99*time.Millisecond || orders.MaxTime != 100*time.Millisecond {
+		t.Errorf("Unexpected percentiles: p50=%v p95=%v p99=%v max=%v", orders.P50, orders.P95, orders.P99, orders.MaxTime)
+	}
+	if orders.MeanTime != 50500*time.Microsecond {
+		t.Errorf("Expected a mean of 50.5ms, got %v", orders.MeanTime)
+	}
+	if top[1].ErrorRate != 50 || top[1].Errors != 1 {
+		t.Errorf("Expected the update to have a 50%% error rate, got %+v", top[1])
+	}
+	if all := m.TopQueries(0); len(all) != 3 {
+		t.Errorf("Expected 3 fingerprints, got %d", len(all))
+	}
+}
+
+func TestDialect(t *testing.T) {
+	query := "SELECT '?', \"a?\" FROM t WHERE id = ? -- ?\nAND name = /* ? */ ?"
+	tests := []struct {
+		dbType DatabaseType
+		query  string
+		page   string
+		quoted string
+	}{
+		{DatabaseTypePostgreSQL, "SELECT '?', \"a?\" FROM t WHERE id = $1 -- ?\nAND name = /* ? */ $2", "LIMIT 10 OFFSET 20", `"key"`},
+		{DatabaseTypeOracle, "SELECT '?', \"a?\" FROM t WHERE id = :1 -- ?\nAND name = /* ? */ :2", "OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY", `"key"`},
+		{DatabaseTypeMySQL, query, "LIMIT 10 OFFSET 20", "`key`"},
+		{DatabaseTypeSQLite, query, "LIMIT 10 OFFSET 20", `"key"`},
+	}
+	for _, tt := range tests {
+		d := DialectFor(tt.dbType)
+		if d.Type() != tt.dbType {
+			t.Errorf("%s: got a dialect for %s", tt.dbType, d.Type())
+		}
+		if got := Rebind(d, query); got != tt.query {
+			t.Errorf("%s: Rebind = %q", tt.dbType, got)
+		}
+		if got := d.LimitOffset(10, 20); got != tt.page {
+			t.Errorf("%s: LimitOffset = %q", tt.dbType, got)
+		}
+		if got := d.QuoteIdentifier("key"); got != tt.quoted {
+			t.Errorf("%s: QuoteIdentifier = %q", tt.dbType, got)
+		}
+	}
+
+	if got := DialectFor(DatabaseTypeSQLite).LimitOffset(-1, 5); got != "LIMIT -1 OFFSET 5" {
+		t.Errorf("Unexpected SQLite offset without limit: %q", got)
+	}
+	if got := DialectFor(DatabaseTypePostgreSQL).QuoteIdentifier(`a"b`); got != `"a""b"` {
+		t.Errorf("Expected embedded quotes to be doubled, got %s", got)
+	}
+	if got := DialectFor("db2").ValidationQuery(); got != "SELECT 1" {
+		t.Errorf("Expected the generic dialect for an unknown type, got %q", got)
+	}
+}
+
+func TestDiagnostics_CircuitHistory(t *testing.T) {
+	runtime := NewDBRuntime(&RuntimeConfig{
+		DSN:                       "test@localhost:1521/XE",
+		CircuitBreakerMaxFailures: 1,
+	})
+	runtime.gate.RecordFailure()
+
+	diagnostics := GetDiagnostics(runtime)
+	if len(diagnostics.CircuitHistory) != 1 || diagnostics.CircuitHistory[0].To != CircuitStateOpen {
+		t.Fatalf("Expected the trip in the diagnostics, got %+v", diagnostics.CircuitHistory)
+	}
+	if !strings.Contains(diagnostics.String(), "closed -> open: 1 consecutive failures") {
+		t.Errorf("Expected the transition in the report, got:\n%s", diagnostics)
+	}
+
+	// The monitor reports each transition once
+	monitor := NewMonitor(runtime, time.Hour)
+	var transitions []*CircuitTransition
+	monitor.AddCallback(func(event MonitorEvent) {
+		if event.Type == MonitorEventCircuitTransition {
+			transitions = append(transitions, event.Transition)
+		}
+	})
+	monitor.checkAndNotify(context.Background())
+	monitor.checkAndNotify(context.Background())
+	if len(transitions) != 1 || transitions[0].Reason != "1 consecutive failures" {
+		t.Errorf("Expected one transition event, got %+v", transitions)
+	}
+}
+
+func TestDiagnostics_Cache(t *testing.T) {
+	runtime := NewDBRuntime(NewConfigBuilder().
+		WithDatabaseType(DatabaseTypeSQLite).
+		WithDSN("file:diagnostics_cache?mode=memory&cache=shared").
+		WithAggressiveCaching(100, time.Minute).
+		Build())
+	if err := runtime.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer runtime.Disconnect()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := runtime.QueryCached(ctx, "one", time.Minute, "SELECT 1"); err != nil {
+			t.Fatalf("QueryCached failed: %v", err)
+		}
+	}
+
+	diagnostics := GetDiagnostics(runtime)
+	cache := diagnostics.Metrics.Cache
+	if cache == nil || cache.Hits != 1 || cache.Misses != 1 || cache.Items != 1 {
+		t.Fatalf("Expected 1 hit, 1 miss and 1 item, got %+v", cache)
+	}
+	if !strings.Contains(diagnostics.String(), "Hit Rate: 50.00%") {
+		t.Errorf("Expected the cache hit rate in the report, got:\n%s", diagnostics)
+	}
+
+	// A runtime without a cache reports none
+	if stats := NewDBRuntime(&RuntimeConfig{}).Metrics(); stats.Cache != nil {
+		t.Errorf("Expected no cache stats, got %+v", stats.Cache)
+	}
+}